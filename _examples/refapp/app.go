@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"go.knocknote.io/octillery"
+	_ "go.knocknote.io/octillery/connection/adapter/plugin/mysql"   // compile mysql adapter without `octillery install --mysql`
+	_ "go.knocknote.io/octillery/connection/adapter/plugin/sqlite3" // compile sqlite3 adapter without `octillery install --sqlite`
+	"go.knocknote.io/octillery/database/sql"
+	"go.knocknote.io/octillery/metrics/prometheus"
+)
+
+// App reference application using sharded users and user_items
+type App struct {
+	DB       *sql.DB
+	Recovery *Recovery
+	registry *promclient.Registry
+}
+
+// User row of users with items of the user
+type User struct {
+	ID    int64   `json:"id"`
+	Name  string  `json:"name"`
+	Items []*Item `json:"items,omitempty"`
+}
+
+// Item row of user_items
+type Item struct {
+	ID     int64  `json:"id"`
+	UserID int64  `json:"user_id"`
+	Name   string `json:"name"`
+}
+
+// NewApp loads configuration, creates tables defined under schemaPath for all shards if they are missing and opens database.
+// Production environment should create tables by `octillery migrate` instead.
+func NewApp(configPath string, schemaPath string) (*App, error) {
+	if err := octillery.LoadConfig(configPath); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := octillery.Bootstrap(schemaPath); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// driver name and dsn are decided by configuration
+	db, err := sql.Open("", "")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	registry := promclient.NewRegistry()
+	if err := registry.Register(prometheus.NewCollector(db)); err != nil {
+		db.Close()
+		return nil, errors.WithStack(err)
+	}
+	return &App{
+		DB:       db,
+		Recovery: NewRecovery(db),
+		registry: registry,
+	}, nil
+}
+
+// Close closes all connections to shards
+func (app *App) Close() error {
+	return errors.WithStack(app.DB.Close())
+}
+
+// begin starts transaction. If commit of it is partially failed ( some shards are committed and others are not ),
+// write queries not committed are passed to Recovery and executed again later.
+func (app *App) begin(ctx context.Context) (*sql.Tx, error) {
+	tx, err := app.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	tx.AfterCommitCallback(func() error {
+		return nil
+	}, func(isCritical bool, failureQueries []*sql.QueryLog) error {
+		if isCritical {
+			app.Recovery.Add(failureQueries)
+		}
+		return nil
+	})
+	return tx, nil
+}
+
+// CreateUser inserts user and items of the user by distributed transaction.
+// id of user is published by sequencer, and items are inserted to the shards decided by id of user.
+func (app *App) CreateUser(ctx context.Context, user *User) error {
+	tx, err := app.begin(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	result, err := tx.ExecContext(ctx, "INSERT INTO users(id, name) VALUES (null, ?)", user.Name)
+	if err != nil {
+		tx.Rollback()
+		return errors.WithStack(err)
+	}
+	if user.ID, err = result.LastInsertId(); err != nil {
+		tx.Rollback()
+		return errors.WithStack(err)
+	}
+	for _, item := range user.Items {
+		item.UserID = user.ID
+		if err := app.insertItem(ctx, tx, item); err != nil {
+			tx.Rollback()
+			return errors.WithStack(err)
+		}
+	}
+	return errors.WithStack(tx.Commit())
+}
+
+// User returns user with items by id. If user is not found, returns sql.ErrNoRows
+func (app *App) User(ctx context.Context, id int64) (*User, error) {
+	user := &User{ID: id}
+	if err := app.DB.QueryRowContext(ctx, "SELECT name FROM users WHERE id = ?", id).Scan(&user.Name); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	items, err := app.Items(ctx, id)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	user.Items = items
+	return user, nil
+}
+
+// Users returns all users ordered by id. Query doesn't have shard key, so it is executed on all shards and merged.
+func (app *App) Users(ctx context.Context, limit int) ([]*User, error) {
+	rows, err := app.DB.QueryContext(ctx, "SELECT id, name FROM users ORDER BY id LIMIT ?", limit)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	users := []*User{}
+	for rows.Next() {
+		user := &User{}
+		if err := rows.Scan(&user.ID, &user.Name); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		users = append(users, user)
+	}
+	return users, errors.WithStack(rows.Err())
+}
+
+// UpdateUser updates name of user. If user is not found, returns sql.ErrNoRows
+func (app *App) UpdateUser(ctx context.Context, user *User) error {
+	result, err := app.DB.ExecContext(ctx, "UPDATE users SET name = ? WHERE id = ?", user.Name, user.ID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(expectAffected(result))
+}
+
+// DeleteUser deletes user and items of the user by distributed transaction. If user is not found, returns sql.ErrNoRows
+func (app *App) DeleteUser(ctx context.Context, id int64) error {
+	tx, err := app.begin(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_items WHERE user_id = ?", id); err != nil {
+		tx.Rollback()
+		return errors.WithStack(err)
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = ?", id)
+	if err != nil {
+		tx.Rollback()
+		return errors.WithStack(err)
+	}
+	if err := expectAffected(result); err != nil {
+		tx.Rollback()
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(tx.Commit())
+}
+
+// CreateItem inserts item of user. If user is not found, returns sql.ErrNoRows
+func (app *App) CreateItem(ctx context.Context, item *Item) error {
+	var exists int64
+	if err := app.DB.QueryRowContext(ctx, "SELECT id FROM users WHERE id = ?", item.UserID).Scan(&exists); err != nil {
+		return errors.WithStack(err)
+	}
+	tx, err := app.begin(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := app.insertItem(ctx, tx, item); err != nil {
+		tx.Rollback()
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(tx.Commit())
+}
+
+func (app *App) insertItem(ctx context.Context, tx *sql.Tx, item *Item) error {
+	result, err := tx.ExecContext(ctx, "INSERT INTO user_items(id, user_id, name) VALUES (null, ?, ?)", item.UserID, item.Name)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	item.ID = id
+	return nil
+}
+
+// Items returns items of user ordered by id. Query has shard key, so it is executed on a shard.
+func (app *App) Items(ctx context.Context, userID int64) ([]*Item, error) {
+	rows, err := app.DB.QueryContext(ctx, "SELECT id, name FROM user_items WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	items := []*Item{}
+	for rows.Next() {
+		item := &Item{UserID: userID}
+		if err := rows.Scan(&item.ID, &item.Name); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		items = append(items, item)
+	}
+	return items, errors.WithStack(rows.Err())
+}
+
+// DeleteItem deletes item of user. If item is not found, returns sql.ErrNoRows
+func (app *App) DeleteItem(ctx context.Context, userID int64, id int64) error {
+	result, err := app.DB.ExecContext(ctx, "DELETE FROM user_items WHERE user_id = ? AND id = ?", userID, id)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(expectAffected(result))
+}
+
+// Seed inserts users and items of them defined by JSON file like `[{"name": "alice", "items": ["sword"]}]`.
+// Larger seeds can be imported from CSV by `octillery import`.
+func (app *App) Seed(ctx context.Context, seedPath string) error {
+	content, err := ioutil.ReadFile(seedPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	var seeds []struct {
+		Name  string   `json:"name"`
+		Items []string `json:"items"`
+	}
+	if err := json.Unmarshal(content, &seeds); err != nil {
+		return errors.Wrapf(err, "invalid seeds %s", seedPath)
+	}
+	for _, seed := range seeds {
+		user := &User{Name: seed.Name}
+		for _, name := range seed.Items {
+			user.Items = append(user.Items, &Item{Name: name})
+		}
+		if err := app.CreateUser(ctx, user); err != nil {
+			return errors.Wrapf(err, "cannot insert seed of user %s", seed.Name)
+		}
+	}
+	return nil
+}
+
+// Handler returns http.Handler serving API and metrics
+func (app *App) Handler() http.Handler {
+	return newHandler(app)
+}
+
+func expectAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go.knocknote.io/octillery/database/sql"
+	"go.knocknote.io/octillery/path"
+)
+
+// newTestApp opens app by databases.yml ( SQLite ).
+// Set REFAPP_CONFIG=databases_mysql.yml to run tests against MySQL started by docker-compose.yml.
+func newTestApp(t *testing.T) *App {
+	files, err := filepath.Glob("/tmp/refapp_*.bin")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+	}
+	dir := path.ThisDirPath()
+	configName := os.Getenv("REFAPP_CONFIG")
+	if configName == "" {
+		configName = "databases.yml"
+	}
+	app, err := NewApp(filepath.Join(dir, configName), filepath.Join(dir, "schema"))
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	// databases of MySQL are not removed, so rows of previous tests are deleted
+	for _, table := range []string{"user_items", "users"} {
+		if _, err := app.DB.Exec("DELETE FROM " + table); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+	}
+	return app
+}
+
+func request(t *testing.T, server *httptest.Server, method string, path string, body interface{}, expectedStatus int, result interface{}) {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+	}
+	req, err := http.NewRequest(method, server.URL+path, &reqBody)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer res.Body.Close()
+	content, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if res.StatusCode != expectedStatus {
+		t.Fatalf("%s %s: expected status %d but got %d: %s", method, path, expectedStatus, res.StatusCode, content)
+	}
+	if result != nil {
+		if err := json.Unmarshal(content, result); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+	}
+}
+
+func TestCRUD(t *testing.T) {
+	app := newTestApp(t)
+	defer app.Close()
+	server := httptest.NewServer(app.Handler())
+	defer server.Close()
+
+	if err := app.Seed(context.Background(), filepath.Join(path.ThisDirPath(), "seeds", "users.json")); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	var users []*User
+	request(t, server, http.MethodGet, "/users", nil, http.StatusOK, &users)
+	if len(users) != 3 {
+		t.Fatalf("expected 3 seeded users but got %d", len(users))
+	}
+
+	var created User
+	request(t, server, http.MethodPost, "/users", &User{
+		Name:  "dave",
+		Items: []*Item{{Name: "potion"}, {Name: "ether"}},
+	}, http.StatusCreated, &created)
+	if created.ID == 0 || len(created.Items) != 2 || created.Items[0].UserID != created.ID {
+		t.Fatalf("unexpected user %+v", created)
+	}
+	userPath := "/users/" + strconv.FormatInt(created.ID, 10)
+
+	var user User
+	request(t, server, http.MethodGet, userPath, nil, http.StatusOK, &user)
+	if user.Name != "dave" || len(user.Items) != 2 {
+		t.Fatalf("unexpected user %+v", user)
+	}
+	request(t, server, http.MethodPut, userPath, &User{Name: "david"}, http.StatusOK, nil)
+	request(t, server, http.MethodGet, userPath, nil, http.StatusOK, &user)
+	if user.Name != "david" {
+		t.Fatalf("user is not updated: %+v", user)
+	}
+
+	var item Item
+	request(t, server, http.MethodPost, userPath+"/items", &Item{Name: "elixir"}, http.StatusCreated, &item)
+	var items []*Item
+	request(t, server, http.MethodGet, userPath+"/items", nil, http.StatusOK, &items)
+	if len(items) != 3 || items[2].Name != "elixir" {
+		t.Fatalf("unexpected items %+v", items)
+	}
+	request(t, server, http.MethodDelete, userPath+"/items/"+strconv.FormatInt(item.ID, 10), nil, http.StatusNoContent, nil)
+	request(t, server, http.MethodGet, userPath+"/items", nil, http.StatusOK, &items)
+	if len(items) != 2 {
+		t.Fatalf("item is not deleted: %+v", items)
+	}
+
+	request(t, server, http.MethodDelete, userPath, nil, http.StatusNoContent, nil)
+	request(t, server, http.MethodGet, userPath, nil, http.StatusNotFound, nil)
+	request(t, server, http.MethodGet, userPath+"/items", nil, http.StatusOK, &items)
+	if len(items) != 0 {
+		t.Fatalf("items of deleted user are left: %+v", items)
+	}
+	request(t, server, http.MethodDelete, userPath, nil, http.StatusNotFound, nil)
+	request(t, server, http.MethodPost, "/users/1/items", &Item{}, http.StatusBadRequest, nil)
+	request(t, server, http.MethodGet, "/users/1/unknown", nil, http.StatusNotFound, nil)
+	request(t, server, http.MethodGet, "/healthz", nil, http.StatusOK, nil)
+
+	res, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer res.Body.Close()
+	metrics, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if !strings.Contains(string(metrics), `octillery_queries_total{shard="user_shard_`) {
+		t.Fatalf("metrics of queries are not exported: %s", metrics)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	app := newTestApp(t)
+	defer app.Close()
+	ctx := context.Background()
+
+	// capture write queries of distributed transaction and roll it back instead of failing to commit on some shards
+	var queries []*sql.QueryLog
+	tx, err := app.DB.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	result, err := tx.Exec("INSERT INTO users(id, name) VALUES (null, 'erin')")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	userID, err := result.LastInsertId()
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if _, err := tx.Exec("INSERT INTO user_items(id, user_id, name) VALUES (null, ?, 'ring')", userID); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	tx.BeforeCommitCallback(func(writeQueries []*sql.QueryLog) error {
+		queries = writeQueries
+		return sql.ErrTxDone
+	})
+	if err := tx.Commit(); err == nil {
+		t.Fatal("cannot abort commit")
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if _, err := app.User(ctx, userID); err == nil {
+		t.Fatal("user is committed")
+	}
+
+	app.Recovery.Add(queries)
+	if err := app.Recovery.Recover(ctx); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	// queries already committed are skipped, so recovery can be executed again
+	app.Recovery.Add(queries)
+	if err := app.Recovery.Recover(ctx); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if app.Recovery.Pending() != 0 {
+		t.Fatal("recovered transaction is left")
+	}
+	user, err := app.User(ctx, userID)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if user.Name != "erin" || len(user.Items) != 1 || user.Items[0].Name != "ring" {
+		t.Fatalf("unexpected recovered user %+v", user)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.knocknote.io/octillery/database/sql"
+)
+
+const defaultUserLimit = 100
+
+// newHandler routes requests to API of app
+//
+//	GET    /users                     list users
+//	POST   /users                     create user ( with items )
+//	GET    /users/{id}                get user with items
+//	PUT    /users/{id}                update name of user
+//	DELETE /users/{id}                delete user and items
+//	GET    /users/{id}/items          list items of user
+//	POST   /users/{id}/items          create item of user
+//	DELETE /users/{id}/items/{itemID} delete item of user
+//	GET    /healthz                   ping all databases
+//	GET    /metrics                   metrics for Prometheus
+func newHandler(app *App) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users", app.handleUsers)
+	mux.HandleFunc("/users/", app.handleUser)
+	mux.HandleFunc("/healthz", app.handleHealth)
+	mux.Handle("/metrics", promhttp.HandlerFor(app.registry, promhttp.HandlerOpts{}))
+	return mux
+}
+
+func (app *App) handleUsers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		limit := defaultUserLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeError(w, http.StatusBadRequest, errors.Errorf("invalid limit %s", v))
+				return
+			}
+			limit = n
+		}
+		users, err := app.Users(r.Context(), limit)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, users)
+	case http.MethodPost:
+		var user User
+		if err := json.NewDecoder(r.Body).Decode(&user); err != nil || user.Name == "" {
+			writeError(w, http.StatusBadRequest, errors.New("name of user is required"))
+			return
+		}
+		if err := app.CreateUser(r.Context(), &user); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, &user)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleUser serves /users/{id}, /users/{id}/items and /users/{id}/items/{itemID}
+func (app *App) handleUser(w http.ResponseWriter, r *http.Request) {
+	paths := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/users/"), "/"), "/")
+	ids := make([]int64, 0, 2)
+	for i, path := range paths {
+		if i == 1 {
+			if path != "items" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			continue
+		}
+		id, err := strconv.ParseInt(path, 10, 64)
+		if err != nil || i > 2 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		ids = append(ids, id)
+	}
+	ctx := r.Context()
+	switch {
+	case len(paths) == 1 && r.Method == http.MethodGet:
+		user, err := app.User(ctx, ids[0])
+		if err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, user)
+	case len(paths) == 1 && r.Method == http.MethodPut:
+		user := User{}
+		if err := json.NewDecoder(r.Body).Decode(&user); err != nil || user.Name == "" {
+			writeError(w, http.StatusBadRequest, errors.New("name of user is required"))
+			return
+		}
+		user.ID = ids[0]
+		if err := app.UpdateUser(ctx, &user); err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, &user)
+	case len(paths) == 1 && r.Method == http.MethodDelete:
+		if err := app.DeleteUser(ctx, ids[0]); err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(paths) == 2 && r.Method == http.MethodGet:
+		items, err := app.Items(ctx, ids[0])
+		if err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, items)
+	case len(paths) == 2 && r.Method == http.MethodPost:
+		item := Item{}
+		if err := json.NewDecoder(r.Body).Decode(&item); err != nil || item.Name == "" {
+			writeError(w, http.StatusBadRequest, errors.New("name of item is required"))
+			return
+		}
+		item.UserID = ids[0]
+		if err := app.CreateItem(ctx, &item); err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, &item)
+	case len(paths) == 3 && r.Method == http.MethodDelete:
+		if err := app.DeleteItem(ctx, ids[0], ids[1]); err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (app *App) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if err := app.DB.PingContext(r.Context()); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"pending_recoveries": app.Recovery.Pending()})
+}
+
+func statusOf(err error) int {
+	if errors.Cause(err) == sql.ErrNoRows {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("%+v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	if status == http.StatusInternalServerError {
+		log.Printf("%+v", err)
+	}
+	writeJSON(w, status, map[string]string{"error": errors.Cause(err).Error()})
+}
@@ -0,0 +1,62 @@
+// Command refapp is a reference application of octillery.
+//
+// It serves CRUD of users and their items over HTTP. users are sharded by id and user_items are sharded by user_id,
+// so writing a user with items is a distributed transaction over shards of both tables.
+//
+//	go run ./_examples/refapp -seed
+//	curl -XPOST -d '{"name":"dave"}' localhost:8080/users
+//	curl localhost:8080/users/1/items
+//	curl localhost:8080/metrics
+//
+// databases.yml uses SQLite. To run with MySQL, start it by docker-compose.yml and use databases_mysql.yml instead.
+//
+//	docker-compose -f _examples/refapp/docker-compose.yml up -d
+//	go run ./_examples/refapp -config _examples/refapp/databases_mysql.yml -seed
+//
+// Tables are created by schema under schema directory at startup, write queries of distributed transactions
+// failed to commit are recovered in background, and metrics are exported to Prometheus.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"go.knocknote.io/octillery/path"
+)
+
+func main() {
+	dir := path.ThisDirPath()
+	var (
+		addr       = flag.String("addr", ":8080", "address to listen")
+		configPath = flag.String("config", filepath.Join(dir, "databases.yml"), "path to configuration file of octillery")
+		schemaPath = flag.String("schema", filepath.Join(dir, "schema"), "path to schema directory")
+		seedPath   = flag.String("seed-file", filepath.Join(dir, "seeds", "users.json"), "path to seeds")
+		seed       = flag.Bool("seed", false, "insert seeds before serving")
+		interval   = flag.Duration("recovery-interval", 10*time.Second, "interval of recovery of failed distributed transactions")
+	)
+	flag.Parse()
+
+	app, err := NewApp(*configPath, *schemaPath)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	defer app.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if *seed {
+		if err := app.Seed(ctx, *seedPath); err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
+	go app.Recovery.Run(ctx, *interval)
+
+	log.Printf("listening on %s", *addr)
+	if err := http.ListenAndServe(*addr, app.Handler()); err != nil {
+		log.Fatalf("%+v", err)
+	}
+}
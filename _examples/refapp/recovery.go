@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/database/sql"
+)
+
+// Recovery executes write queries of distributed transactions again, those are not committed on some shards.
+//
+// Queries are kept in memory in this application. Real application should persist them ( e.g. to a file or queue )
+// before returning from callback, because process may exit before recovery.
+type Recovery struct {
+	db      *sql.DB
+	mu      sync.Mutex
+	pending [][]*sql.QueryLog
+}
+
+// NewRecovery creates instance of Recovery for db
+func NewRecovery(db *sql.DB) *Recovery {
+	return &Recovery{db: db}
+}
+
+// Add adds write queries not committed by distributed transaction
+func (r *Recovery) Add(queries []*sql.QueryLog) {
+	if len(queries) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = append(r.pending, queries)
+}
+
+// Pending returns number of transactions waiting for recovery
+func (r *Recovery) Pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.pending)
+}
+
+// Recover executes pending write queries. Queries already committed ( e.g. by previous recovery ) are skipped.
+// Transactions failed to recover are kept for next recovery.
+func (r *Recovery) Recover(ctx context.Context) error {
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = nil
+	r.mu.Unlock()
+
+	var failed [][]*sql.QueryLog
+	var lastErr error
+	for _, queries := range pending {
+		if err := r.recover(ctx, queries); err != nil {
+			failed = append(failed, queries)
+			lastErr = err
+		}
+	}
+	if len(failed) > 0 {
+		r.mu.Lock()
+		r.pending = append(failed, r.pending...)
+		r.mu.Unlock()
+		return errors.Wrapf(lastErr, "cannot recover %d transactions", len(failed))
+	}
+	return nil
+}
+
+func (r *Recovery) recover(ctx context.Context, queries []*sql.QueryLog) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, query := range queries {
+		committed, err := tx.IsAlreadyCommittedQueryLog(query)
+		if err != nil {
+			tx.Rollback()
+			return errors.WithStack(err)
+		}
+		if committed {
+			continue
+		}
+		if _, err := tx.ExecWithQueryLog(query); err != nil {
+			tx.Rollback()
+			return errors.WithStack(err)
+		}
+	}
+	return errors.WithStack(tx.Commit())
+}
+
+// Run recovers pending write queries every interval until ctx is canceled
+func (r *Recovery) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Recover(ctx); err != nil {
+				log.Printf("%+v", err)
+			}
+		}
+	}
+}
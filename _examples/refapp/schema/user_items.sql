@@ -0,0 +1,6 @@
+CREATE TABLE user_items (
+  id integer NOT NULL,
+  user_id bigint NOT NULL,
+  name varchar(255) NOT NULL,
+  PRIMARY KEY (id)
+);
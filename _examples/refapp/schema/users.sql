@@ -0,0 +1,5 @@
+CREATE TABLE users (
+  id integer NOT NULL,
+  name varchar(255) NOT NULL,
+  PRIMARY KEY (id)
+);
@@ -14,7 +14,7 @@ var (
 
 // ShardingAlgorithm is a algorithm for assign sharding target.
 //
-// octillery currently supports modulo and hashmap.
+// octillery currently supports modulo, hashmap and hash ( jump consistent hash ).
 // If use the other new algorithm, implement the following interface as plugin ( new_algorithm.go )
 // and call algorithm.Register("algorithm_name", &NewAlgorithmStructure{}).
 // Also, new_algorithm.go file should put inside go.knocknote.io/octillery/algorithm directory.
@@ -3,7 +3,9 @@ package algorithm
 import (
 	"database/sql"
 	"database/sql/driver"
+	"reflect"
 	"testing"
+	"time"
 )
 
 type TestDriver struct {
@@ -167,3 +169,287 @@ func TestHashMap(t *testing.T) {
 		})
 	})
 }
+
+func TestHash(t *testing.T) {
+	conns := []*sql.DB{}
+	for i := 0; i < 5; i++ {
+		conn, err := sql.Open("sqlite3", "")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		conns = append(conns, conn)
+	}
+	shards := func(conns []*sql.DB, params Params) map[int64]*sql.DB {
+		hash, err := LoadShardingAlgorithm("hash")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if err := InitShardingAlgorithm(hash, conns, params); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		shards := map[int64]*sql.DB{}
+		for id := int64(1); id <= 10000; id++ {
+			shardConn, err := hash.Shard(conns, id)
+			if err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			shards[id] = shardConn
+		}
+		return shards
+	}
+	t.Run("distribution", func(t *testing.T) {
+		counts := map[*sql.DB]int{}
+		for _, conn := range shards(conns[:4], nil) {
+			counts[conn]++
+		}
+		for idx, conn := range conns[:4] {
+			if counts[conn] < 2000 || counts[conn] > 3000 {
+				t.Fatalf("keys are not distributed. shard %d has %d keys", idx, counts[conn])
+			}
+		}
+	})
+	t.Run("add shard", func(t *testing.T) {
+		before := shards(conns[:4], nil)
+		after := shards(conns, nil)
+		moved := 0
+		for id, conn := range after {
+			if before[id] == conn {
+				continue
+			}
+			if conn != conns[4] {
+				t.Fatalf("key %d moved between existing shards", id)
+			}
+			moved++
+		}
+		if moved < 1500 || moved > 2500 {
+			t.Fatalf("moved %d keys. expected about 1/5 of keys", moved)
+		}
+	})
+	t.Run("seed", func(t *testing.T) {
+		if reflect.DeepEqual(shards(conns, nil), shards(conns, Params{"seed": "octillery"})) {
+			t.Fatal("cannot change hash by seed")
+		}
+		hash, _ := LoadShardingAlgorithm("hash")
+		if err := InitShardingAlgorithm(hash, conns, Params{"slot_size": 10}); err == nil {
+			t.Fatal("cannot handle error")
+		}
+	})
+}
+
+func TestAlgorithmConfig(t *testing.T) {
+	conn1, err := sql.Open("sqlite3", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	conn2, err := sql.Open("sqlite3", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	conns := []*sql.DB{conn1, conn2}
+	t.Run("params", func(t *testing.T) {
+		params := Params{"size": 10, "bounds": []interface{}{1, "2", 3.0}, "name": "foo"}
+		if size, err := params.Int("size", 0); err != nil || size != 10 {
+			t.Fatal("cannot get integer parameter")
+		}
+		if size, err := params.Int("unknown", 5); err != nil || size != 5 {
+			t.Fatal("cannot get default value")
+		}
+		if _, err := params.Int("name", 0); err == nil {
+			t.Fatal("cannot handle error")
+		}
+		bounds, err := params.Ints("bounds")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if len(bounds) != 3 || bounds[0] != 1 || bounds[1] != 2 || bounds[2] != 3 {
+			t.Fatalf("invalid bounds %v", bounds)
+		}
+		if name, err := params.String("name", ""); err != nil || name != "foo" {
+			t.Fatal("cannot get string parameter")
+		}
+		if err := params.Validate("size", "bounds"); err == nil {
+			t.Fatal("cannot detect unknown parameter")
+		}
+		if err := params.Validate("size", "bounds", "name"); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+	})
+	t.Run("not configurable algorithm", func(t *testing.T) {
+		modulo, err := LoadShardingAlgorithm("modulo")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if err := InitShardingAlgorithm(modulo, conns, nil); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if err := InitShardingAlgorithm(modulo, conns, Params{"seed": "a"}); err == nil {
+			t.Fatal("cannot handle error")
+		}
+	})
+	t.Run("hashmap", func(t *testing.T) {
+		shardIndexes := func(params Params) []*sql.DB {
+			hashmap, err := LoadShardingAlgorithm("hashmap")
+			if err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			if err := InitShardingAlgorithm(hashmap, conns, params); err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			shards := []*sql.DB{}
+			for id := int64(0); id < 20; id++ {
+				shardConn, err := hashmap.Shard(conns, id)
+				if err != nil {
+					t.Fatalf("%+v\n", err)
+				}
+				shards = append(shards, shardConn)
+			}
+			return shards
+		}
+		defaultShards := shardIndexes(nil)
+		if !reflect.DeepEqual(defaultShards, shardIndexes(Params{"slot_size": 1023, "seed": ""})) {
+			t.Fatal("default parameters must be compatible with Init")
+		}
+		if reflect.DeepEqual(defaultShards, shardIndexes(Params{"seed": "octillery"})) {
+			t.Fatal("cannot change hash by seed")
+		}
+		hashmap, _ := LoadShardingAlgorithm("hashmap")
+		if err := InitShardingAlgorithm(hashmap, conns, Params{"slot_size": 1}); err == nil {
+			t.Fatal("cannot handle error")
+		}
+		if err := InitShardingAlgorithm(hashmap, conns, Params{"slots": 100}); err == nil {
+			t.Fatal("cannot handle error")
+		}
+	})
+}
+
+func TestTimeRange(t *testing.T) {
+	conns := []*sql.DB{}
+	for i := 0; i < 3; i++ {
+		conn, err := sql.Open("sqlite3", "")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		conns = append(conns, conn)
+	}
+	t.Run("month", func(t *testing.T) {
+		timeRange, err := LoadShardingAlgorithm(TimeRangeAlgorithm)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		params := Params{"start": "2020-01-01", "location": "Asia/Tokyo"}
+		if err := InitShardingAlgorithm(timeRange, conns, params); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		jst := time.FixedZone("JST", 9*60*60)
+		for _, test := range []struct {
+			at       time.Time
+			expected int
+		}{
+			{time.Date(2020, 1, 1, 0, 0, 0, 0, jst), 0},
+			{time.Date(2020, 1, 31, 23, 59, 59, 0, jst), 0},
+			{time.Date(2020, 1, 31, 15, 0, 0, 0, time.UTC), 1},
+			{time.Date(2020, 3, 31, 23, 59, 59, 0, jst), 2},
+		} {
+			conn, err := timeRange.Shard(conns, test.at.Unix())
+			if err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			if conn != conns[test.expected] {
+				t.Fatalf("%s must be assigned to %d-th shard", test.at, test.expected)
+			}
+		}
+		if _, err := timeRange.Shard(conns, time.Date(2019, 12, 31, 23, 59, 59, 0, jst).Unix()); err == nil {
+			t.Fatal("cannot handle error for time before start")
+		}
+		if _, err := timeRange.Shard(conns, time.Date(2020, 4, 1, 0, 0, 0, 0, jst).Unix()); err == nil {
+			t.Fatal("cannot handle error for time bucket not prepared")
+		}
+	})
+	t.Run("buckets", func(t *testing.T) {
+		for _, test := range []struct {
+			params Params
+			at     time.Time
+			index  int
+			start  time.Time
+		}{
+			{Params{"period": "day", "start": "2020-02-27"}, time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC), 3, time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)},
+			{Params{"period": "week", "start": "2020-01-06"}, time.Date(2020, 1, 19, 23, 0, 0, 0, time.UTC), 1, time.Date(2020, 1, 13, 0, 0, 0, 0, time.UTC)},
+			{Params{"period": "month", "start": "2019-11-01"}, time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC), 3, time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)},
+			{Params{"period": "year", "start": "2018-01-01"}, time.Date(2020, 12, 31, 0, 0, 0, 0, time.UTC), 2, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		} {
+			buckets, err := NewTimeBuckets(test.params)
+			if err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			if idx := buckets.Index(test.at); idx != test.index {
+				t.Fatalf("%s must be in %d-th %s bucket. but got %d", test.at, test.index, buckets.Period, idx)
+			}
+			if start := buckets.BucketStart(test.index); !start.Equal(test.start) {
+				t.Fatalf("%d-th %s bucket must start at %s. but got %s", test.index, buckets.Period, test.start, start)
+			}
+		}
+	})
+	t.Run("invalid params", func(t *testing.T) {
+		for _, params := range []Params{
+			nil,
+			{"start": "2020/01/01"},
+			{"start": "2020-01-15"},
+			{"period": "year", "start": "2020-02-01"},
+			{"period": "hour", "start": "2020-01-01"},
+			{"start": "2020-01-01", "location": "Unknown/Location"},
+			{"start": "2020-01-01", "size": 10},
+		} {
+			timeRange, _ := LoadShardingAlgorithm(TimeRangeAlgorithm)
+			if err := InitShardingAlgorithm(timeRange, conns, params); err == nil {
+				t.Fatalf("cannot handle error for %v", params)
+			}
+		}
+	})
+}
+
+func TestHierarchical(t *testing.T) {
+	conns := []*sql.DB{}
+	for i := 0; i < 4; i++ {
+		conn, err := sql.Open("sqlite3", "")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		conns = append(conns, conn)
+	}
+	// 2nd shard ( conns[1] ) is split into conns[2] and conns[3]
+	primaryConns := conns[:2]
+	modulo, err := LoadShardingAlgorithm("modulo")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if err := InitShardingAlgorithm(modulo, primaryConns, nil); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	subShards, err := NewSubShards("shard_2", "", nil, conns[2:])
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	logic := NewHierarchicalShardingAlgorithm(modulo, map[int]*SubShards{1: subShards})
+	counts := map[*sql.DB]int{}
+	for id := int64(0); id < 10000; id++ {
+		conn, err := logic.Shard(primaryConns, id)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if id%2 == 0 && conn != conns[0] {
+			t.Fatal("rows of shard not split must not be moved")
+		}
+		counts[conn]++
+	}
+	if counts[conns[1]] != 0 {
+		t.Fatal("rows of split shard must be moved to sub-shards")
+	}
+	for _, conn := range conns[2:] {
+		if counts[conn] < 2000 || counts[conn] > 3000 {
+			t.Fatalf("rows are not distributed to sub-shards. %d rows", counts[conn])
+		}
+	}
+	if _, err := NewSubShards("shard_2", "unknown", nil, conns[2:]); err == nil {
+		t.Fatal("cannot handle error")
+	}
+}
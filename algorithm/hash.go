@@ -0,0 +1,71 @@
+package algorithm
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"hash/fnv"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/debug"
+)
+
+// hashShardingAlgorithm assigns shard by jump consistent hash ( https://arxiv.org/abs/1406.2294 ).
+//
+// If new shard is appended to the end of shards, only 1/N of keys move to new shard
+// and the other keys stay on the same shard.
+type hashShardingAlgorithm struct {
+	hashSeed string
+}
+
+func (h *hashShardingAlgorithm) Init(conns []*sql.DB) bool {
+	return len(conns) > 0
+}
+
+// InitWithParams initializes by 'seed' ( prefix of hashed value. default: empty ) parameter.
+func (h *hashShardingAlgorithm) InitWithParams(conns []*sql.DB, params Params) (bool, error) {
+	if err := params.Validate("seed"); err != nil {
+		return false, errors.WithStack(err)
+	}
+	seed, err := params.String("seed", "")
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	h.hashSeed = seed
+	return h.Init(conns), nil
+}
+
+func (h *hashShardingAlgorithm) Shard(conns []*sql.DB, shardID int64) (*sql.DB, error) {
+	if len(conns) == 0 {
+		return nil, errors.New("cannot shard without connections")
+	}
+	shardIndex := jumpHash(h.hashKey(shardID), len(conns))
+	debug.Printf("shardIndex = %d. (shardId = %d, len(conns) = %d)", shardIndex, shardID, len(conns))
+	return conns[shardIndex], nil
+}
+
+// hashKey scatters sequential shard_key values before jump hash
+func (h *hashShardingAlgorithm) hashKey(shardID int64) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(h.hashSeed))
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(shardID))
+	hash.Write(buf[:])
+	return hash.Sum64()
+}
+
+// jumpHash returns bucket index in [0, numBuckets) for key
+func jumpHash(key uint64, numBuckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(numBuckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+func init() {
+	Register("hash", func() ShardingAlgorithm {
+		return &hashShardingAlgorithm{}
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"hash/crc32"
+	"math"
 
 	"github.com/pkg/errors"
 	"go.knocknote.io/octillery/debug"
@@ -21,6 +22,7 @@ type hashMapCluster struct {
 
 type hashMapShardingAlgorithm struct {
 	hashSlotSize uint32
+	hashSeed     string
 	clusters     []*hashMapCluster
 }
 
@@ -45,27 +47,52 @@ func (h *hashMapShardingAlgorithm) hashSlotToClusterIndex(hashSlot uint32) (int,
 }
 
 func (h *hashMapShardingAlgorithm) Init(conns []*sql.DB) bool {
+	return h.init(conns, hashSlotMaxSize)
+}
+
+// InitWithParams initializes by 'slot_size' ( number of hash slots. default: 1023 )
+// and 'seed' ( prefix of hashed value. default: empty ) parameters.
+func (h *hashMapShardingAlgorithm) InitWithParams(conns []*sql.DB, params Params) (bool, error) {
+	if err := params.Validate("slot_size", "seed"); err != nil {
+		return false, errors.WithStack(err)
+	}
+	slotSize, err := params.Int("slot_size", hashSlotMaxSize)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	if slotSize < int64(len(conns)) || slotSize > math.MaxUint32 {
+		return false, errors.Errorf("slot_size must be between number of shards and %d. but got %d", uint32(math.MaxUint32), slotSize)
+	}
+	seed, err := params.String("seed", "")
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	h.hashSeed = seed
+	return h.init(conns, uint32(slotSize)), nil
+}
+
+func (h *hashMapShardingAlgorithm) init(conns []*sql.DB, slotSize uint32) bool {
 	if len(conns) < 2 {
 		return false
 	}
-	eachClusterSlotNum := uint32(hashSlotMaxSize / len(conns))
+	eachClusterSlotNum := slotSize / uint32(len(conns))
 	startSlotNum := uint32(0)
 	endSlotNum := eachClusterSlotNum
 	lastIndex := len(conns) - 1
 	for idx, conn := range conns {
 		if idx == lastIndex {
-			endSlotNum = hashSlotMaxSize
+			endSlotNum = slotSize
 		}
 		h.addCluster(startSlotNum, endSlotNum, conn)
 		startSlotNum += eachClusterSlotNum + 1
 		endSlotNum += eachClusterSlotNum + 1
 	}
-	h.hashSlotSize = hashSlotMaxSize
+	h.hashSlotSize = slotSize
 	return true
 }
 
 func (h *hashMapShardingAlgorithm) Shard(conns []*sql.DB, shardID int64) (*sql.DB, error) {
-	hash := crc32.ChecksumIEEE([]byte(fmt.Sprintf("%d", shardID)))
+	hash := crc32.ChecksumIEEE([]byte(fmt.Sprintf("%s%d", h.hashSeed, shardID)))
 	hashSlot := hash % h.hashSlotSize
 	clusterIndex, err := h.hashSlotToClusterIndex(hashSlot)
 	debug.Printf("shardId = %d hash = %d hashSlot = %d clusterIndex = %d", shardID, hash, hashSlot, clusterIndex)
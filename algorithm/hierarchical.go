@@ -0,0 +1,93 @@
+package algorithm
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/debug"
+)
+
+// DefaultSubShardingAlgorithm algorithm name used for sub-shards if 'sub_shard_algorithm' is not defined.
+//
+// modulo is not suitable as default, because shard_key values assigned to a shard by modulo have the same remainder,
+// so they are biased to some of sub-shards by modulo again.
+const DefaultSubShardingAlgorithm = "hash"
+
+// SubShards shards split from a shard and algorithm to distribute rows of the shard to them
+type SubShards struct {
+	Algorithm ShardingAlgorithm
+	Conns     []*sql.DB
+}
+
+// NewSubShards loads and initializes algorithm for sub-shards split from shardName.
+// If algorithm is hash and seed is not defined, name of split shard is used as seed.
+func NewSubShards(shardName string, algorithmName string, params Params, conns []*sql.DB) (*SubShards, error) {
+	if algorithmName == "" {
+		algorithmName = DefaultSubShardingAlgorithm
+	}
+	logic, err := LoadShardingAlgorithm(algorithmName)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if algorithmName == "hash" {
+		if _, exists := params["seed"]; !exists {
+			copied := Params{"seed": shardName}
+			for name, value := range params {
+				copied[name] = value
+			}
+			params = copied
+		}
+	}
+	if err := InitShardingAlgorithm(logic, conns, params); err != nil {
+		return nil, errors.Wrapf(err, "invalid algorithm of sub-shards of %s", shardName)
+	}
+	return &SubShards{Algorithm: logic, Conns: conns}, nil
+}
+
+// HierarchicalShardingAlgorithm assigns shard by primary algorithm, and if the shard is split into sub-shards,
+// assigns one of them by algorithm of sub-shards.
+//
+// Connections passed to Shard are the same as primary algorithm. split shard is represented by any one connection
+// and SubShards is registered by index of it.
+type HierarchicalShardingAlgorithm struct {
+	primary   ShardingAlgorithm
+	subShards map[int]*SubShards
+}
+
+// NewHierarchicalShardingAlgorithm creates algorithm that splits shards by index of connections into sub-shards.
+// primary must be already initialized.
+func NewHierarchicalShardingAlgorithm(primary ShardingAlgorithm, subShards map[int]*SubShards) *HierarchicalShardingAlgorithm {
+	return &HierarchicalShardingAlgorithm{
+		primary:   primary,
+		subShards: subShards,
+	}
+}
+
+// Init always returns true because primary and sub-shards are already initialized
+func (h *HierarchicalShardingAlgorithm) Init(conns []*sql.DB) bool {
+	return true
+}
+
+// Shard assigns shard by primary algorithm, then sub-shard if the shard is split
+func (h *HierarchicalShardingAlgorithm) Shard(conns []*sql.DB, shardID int64) (*sql.DB, error) {
+	conn, err := h.primary.Shard(conns, shardID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for idx, c := range conns {
+		if c != conn {
+			continue
+		}
+		subShards, exists := h.subShards[idx]
+		if !exists {
+			return conn, nil
+		}
+		subConn, err := subShards.Algorithm.Shard(subShards.Conns, shardID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot assign sub-shard of %d-th shard", idx)
+		}
+		debug.Printf("assign sub-shard of %d-th shard (shardId = %d)", idx, shardID)
+		return subConn, nil
+	}
+	return conn, nil
+}
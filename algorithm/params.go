@@ -0,0 +1,134 @@
+package algorithm
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Params parameters of sharding algorithm defined by 'algorithm_config' in table definition.
+type Params map[string]interface{}
+
+// ConfigurableShardingAlgorithm is a ShardingAlgorithm accepts parameters defined by 'algorithm_config'.
+//
+// If algorithm implements this interface, InitWithParams is called instead of Init.
+type ConfigurableShardingAlgorithm interface {
+	ShardingAlgorithm
+
+	// initialize structure by connection list and parameters. if returns true, no more call this.
+	InitWithParams(conns []*sql.DB, params Params) (bool, error)
+}
+
+// InitShardingAlgorithm initializes algorithm by connection list and parameters.
+// If algorithm doesn't implement ConfigurableShardingAlgorithm, params must be empty.
+func InitShardingAlgorithm(logic ShardingAlgorithm, conns []*sql.DB, params Params) error {
+	if configurable, ok := logic.(ConfigurableShardingAlgorithm); ok {
+		initialized, err := configurable.InitWithParams(conns, params)
+		if err != nil {
+			return errors.Wrap(err, "cannot initialize sharding algorithm")
+		}
+		if !initialized {
+			return errors.New("cannot initialize sharding algorithm")
+		}
+		return nil
+	}
+	if len(params) > 0 {
+		return errors.Errorf("sharding algorithm doesn't support algorithm_config. %v", params.names())
+	}
+	if !logic.Init(conns) {
+		return errors.New("cannot initialize sharding algorithm")
+	}
+	return nil
+}
+
+// Validate returns error if params includes parameter not contained in names.
+func (p Params) Validate(names ...string) error {
+	known := map[string]bool{}
+	for _, name := range names {
+		known[name] = true
+	}
+	unknown := []string{}
+	for _, name := range p.names() {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		return errors.Errorf("unknown algorithm_config %v", unknown)
+	}
+	return nil
+}
+
+// Int returns integer parameter. If parameter is not defined, returns defaultValue.
+func (p Params) Int(name string, defaultValue int64) (int64, error) {
+	value, exists := p[name]
+	if !exists {
+		return defaultValue, nil
+	}
+	switch v := value.(type) {
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint64:
+		return int64(v), nil
+	case float64:
+		if v == float64(int64(v)) {
+			return int64(v), nil
+		}
+	case string:
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err == nil {
+			return i, nil
+		}
+	}
+	return 0, errors.Errorf("algorithm_config %s must be integer. but got %v", name, value)
+}
+
+// Ints returns list of integer parameter ( e.g. range boundaries ). If parameter is not defined, returns nil.
+func (p Params) Ints(name string) ([]int64, error) {
+	value, exists := p[name]
+	if !exists {
+		return nil, nil
+	}
+	values, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("algorithm_config %s must be list of integer. but got %v", name, value)
+	}
+	ints := make([]int64, 0, len(values))
+	for idx, v := range values {
+		i, err := Params{name: v}.Int(name, 0)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %d-th value", idx)
+		}
+		ints = append(ints, i)
+	}
+	return ints, nil
+}
+
+// String returns string parameter. If parameter is not defined, returns defaultValue.
+func (p Params) String(name string, defaultValue string) (string, error) {
+	value, exists := p[name]
+	if !exists {
+		return defaultValue, nil
+	}
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case int, int64, uint64, float64, bool:
+		return fmt.Sprint(v), nil
+	}
+	return "", errors.Errorf("algorithm_config %s must be string. but got %v", name, value)
+}
+
+func (p Params) names() []string {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,164 @@
+package algorithm
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/debug"
+)
+
+const (
+	// TimeRangeAlgorithm name of algorithm that assigns shard by time bucket of shard_key
+	TimeRangeAlgorithm = "time_range"
+
+	// PeriodDay time bucket of a day
+	PeriodDay = "day"
+
+	// PeriodWeek time bucket of 7 days from start
+	PeriodWeek = "week"
+
+	// PeriodMonth time bucket of a month
+	PeriodMonth = "month"
+
+	// PeriodYear time bucket of a year
+	PeriodYear = "year"
+)
+
+// TimeBuckets consecutive periods of time from start. n-th shard of table using time_range algorithm has rows of n-th bucket.
+type TimeBuckets struct {
+	// period of a bucket ( PeriodDay, PeriodWeek, PeriodMonth or PeriodYear )
+	Period string
+	// start time of first bucket
+	Start time.Time
+}
+
+// NewTimeBuckets creates TimeBuckets by 'period' ( day, week, month or year. default: month ), 'start' ( first day of first bucket like '2020-01-01' )
+// and 'location' ( time zone of buckets like 'Asia/Tokyo'. default: UTC ) parameters.
+// start must be the first day of period for month and year.
+func NewTimeBuckets(params Params) (*TimeBuckets, error) {
+	period, err := params.String("period", PeriodMonth)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	switch period {
+	case PeriodDay, PeriodWeek, PeriodMonth, PeriodYear:
+	default:
+		return nil, errors.Errorf("unknown period %s. it must be day, week, month or year", period)
+	}
+	locationName, err := params.String("location", "UTC")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	location, err := time.LoadLocation(locationName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid location %s", locationName)
+	}
+	startText, err := params.String("start", "")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if startText == "" {
+		return nil, errors.New("start is required for time buckets")
+	}
+	start, err := time.ParseInLocation("2006-01-02", startText, location)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid start %s. it must be like '2020-01-01'", startText)
+	}
+	if (period == PeriodMonth || period == PeriodYear) && start.Day() != 1 {
+		return nil, errors.Errorf("start of %s bucket must be the first day of month. but got %s", period, startText)
+	}
+	if period == PeriodYear && start.Month() != time.January {
+		return nil, errors.Errorf("start of year bucket must be January 1st. but got %s", startText)
+	}
+	return &TimeBuckets{Period: period, Start: start}, nil
+}
+
+// Index returns index of bucket that includes t. If t is before start, returns -1.
+func (b *TimeBuckets) Index(t time.Time) int {
+	if t.Before(b.Start) {
+		return -1
+	}
+	t = t.In(b.Start.Location())
+	var idx int
+	switch b.Period {
+	case PeriodDay, PeriodWeek:
+		// count calendar days, so daylight saving time doesn't shift boundaries
+		days := int(date(t).Sub(date(b.Start)).Hours() / 24)
+		if b.Period == PeriodWeek {
+			days /= 7
+		}
+		idx = days
+	case PeriodMonth:
+		idx = (t.Year()-b.Start.Year())*12 + int(t.Month()-b.Start.Month())
+	case PeriodYear:
+		idx = t.Year() - b.Start.Year()
+	}
+	return idx
+}
+
+// BucketStart returns start time of idx-th bucket
+func (b *TimeBuckets) BucketStart(idx int) time.Time {
+	switch b.Period {
+	case PeriodDay:
+		return b.Start.AddDate(0, 0, idx)
+	case PeriodWeek:
+		return b.Start.AddDate(0, 0, 7*idx)
+	case PeriodYear:
+		return b.Start.AddDate(idx, 0, 0)
+	}
+	return b.Start.AddDate(0, idx, 0)
+}
+
+// date returns midnight of t in UTC with the same calendar date
+func date(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// timeRangeShardingAlgorithm assigns n-th shard to shard_key ( Unix time in seconds ) in n-th time bucket.
+//
+// Shards must be defined in order of time, and shard for new bucket is appended to the end of shards before the bucket starts
+// ( see shard_template of table ). Time value passed by time.Time argument is converted to Unix time by query parser.
+type timeRangeShardingAlgorithm struct {
+	buckets *TimeBuckets
+}
+
+// Init returns false because 'start' parameter is required
+func (t *timeRangeShardingAlgorithm) Init(conns []*sql.DB) bool {
+	return false
+}
+
+// InitWithParams initializes by parameters of NewTimeBuckets
+func (t *timeRangeShardingAlgorithm) InitWithParams(conns []*sql.DB, params Params) (bool, error) {
+	if err := params.Validate("period", "start", "location"); err != nil {
+		return false, errors.WithStack(err)
+	}
+	buckets, err := NewTimeBuckets(params)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	t.buckets = buckets
+	return len(conns) > 0, nil
+}
+
+func (t *timeRangeShardingAlgorithm) Shard(conns []*sql.DB, shardID int64) (*sql.DB, error) {
+	if t.buckets == nil {
+		return nil, errors.New("time buckets are not initialized")
+	}
+	at := time.Unix(shardID, 0)
+	shardIndex := t.buckets.Index(at)
+	if shardIndex < 0 {
+		return nil, errors.Errorf("%s is before start of first shard %s", at.In(t.buckets.Start.Location()), t.buckets.Start)
+	}
+	if shardIndex >= len(conns) {
+		return nil, errors.Errorf("shard for %s bucket starting at %s is not prepared", t.buckets.Period, t.buckets.BucketStart(shardIndex))
+	}
+	debug.Printf("shardIndex = %d. (shardId = %d, len(conns) = %d)", shardIndex, shardID, len(conns))
+	return conns[shardIndex], nil
+}
+
+func init() {
+	Register(TimeRangeAlgorithm, func() ShardingAlgorithm {
+		return &timeRangeShardingAlgorithm{}
+	})
+}
@@ -0,0 +1,7 @@
+// +build !octillery_mysql,!octillery_sqlite3,!octillery_aurora
+
+package main
+
+// include all adapters by default.
+// If adapters are specified by build tags ( e.g. `-tags octillery_mysql` ), only them are included through go.knocknote.io/octillery/plugin.
+import _ "go.knocknote.io/octillery/connection/adapter/plugin"
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery"
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/database/sql"
+	"go.knocknote.io/octillery/sqlparser"
+	"go.knocknote.io/octillery/warning"
+)
+
+const (
+	// chaosModePause pauses containers by 'docker pause'. connections are kept but queries hang
+	chaosModePause = "pause"
+	// chaosModeStop stops containers by 'docker stop'
+	chaosModeStop = "stop"
+	// chaosModeKill kills containers by 'docker kill'
+	chaosModeKill = "kill"
+)
+
+// maximum length of error message in chaos report
+const chaosErrorMessageLength = 120
+
+// chaosPhase period of workload distinguished by fault
+type chaosPhase string
+
+const (
+	chaosPhaseBefore   chaosPhase = "before fault"
+	chaosPhaseDuring   chaosPhase = "during fault"
+	chaosPhaseRecovery chaosPhase = "after recovery"
+)
+
+var chaosPhases = []chaosPhase{chaosPhaseBefore, chaosPhaseDuring, chaosPhaseRecovery}
+
+// chaosPhaseReport behavior of routing layer and callbacks in a phase
+type chaosPhaseReport struct {
+	startedAt            time.Time
+	transactions         int
+	failedTransactions   int
+	queries              int
+	failedQueries        int
+	commitSuccesses      int
+	commitFailures       int
+	criticalCommitErrors int
+	errors               map[string]int
+	warnings             map[warning.Code]int
+}
+
+// chaosReport collects results of workload for each phase
+type chaosReport struct {
+	mu     sync.Mutex
+	phase  chaosPhase
+	phases map[chaosPhase]*chaosPhaseReport
+}
+
+func newChaosReport() *chaosReport {
+	report := &chaosReport{phases: map[chaosPhase]*chaosPhaseReport{}}
+	report.setPhase(chaosPhaseBefore)
+	return report
+}
+
+func (r *chaosReport) setPhase(phase chaosPhase) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.phase = phase
+	r.phases[phase] = &chaosPhaseReport{
+		startedAt: time.Now(),
+		errors:    map[string]int{},
+		warnings:  map[warning.Code]int{},
+	}
+}
+
+func (r *chaosReport) record(fn func(*chaosPhaseReport)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn(r.phases[r.phase])
+}
+
+func (r *chaosReport) recordError(err error) {
+	message := errors.Cause(err).Error()
+	if len(message) > chaosErrorMessageLength {
+		message = message[:chaosErrorMessageLength] + "..."
+	}
+	r.record(func(phase *chaosPhaseReport) { phase.errors[message]++ })
+}
+
+func (r *chaosReport) print(finishedAt time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for idx, name := range chaosPhases {
+		phase, exists := r.phases[name]
+		if !exists {
+			continue
+		}
+		endAt := finishedAt
+		if idx+1 < len(chaosPhases) {
+			if next, exists := r.phases[chaosPhases[idx+1]]; exists {
+				endAt = next.startedAt
+			}
+		}
+		fmt.Printf("[%s] %s\n", name, endAt.Sub(phase.startedAt).Truncate(time.Millisecond))
+		fmt.Printf("  transactions:     %d ok, %d failed\n", phase.transactions-phase.failedTransactions, phase.failedTransactions)
+		fmt.Printf("  queries:          %d ok, %d failed\n", phase.queries-phase.failedQueries, phase.failedQueries)
+		fmt.Printf("  commit callbacks: %d success, %d failure ( %d critical )\n", phase.commitSuccesses, phase.commitFailures, phase.criticalCommitErrors)
+		if len(phase.warnings) > 0 {
+			codes := make([]string, 0, len(phase.warnings))
+			for code, count := range phase.warnings {
+				codes = append(codes, fmt.Sprintf("%s=%d", code, count))
+			}
+			sort.Strings(codes)
+			fmt.Printf("  warnings:         %s\n", strings.Join(codes, ", "))
+		}
+		messages := make([]string, 0, len(phase.errors))
+		for message := range phase.errors {
+			messages = append(messages, message)
+		}
+		sort.Slice(messages, func(i, j int) bool {
+			if phase.errors[messages[i]] != phase.errors[messages[j]] {
+				return phase.errors[messages[i]] > phase.errors[messages[j]]
+			}
+			return messages[i] < messages[j]
+		})
+		for _, message := range messages {
+			fmt.Printf("  error x %d: %s\n", phase.errors[message], message)
+		}
+	}
+}
+
+// recovered returns whether workload succeeded after recovery of shards
+func (r *chaosReport) recovered() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	phase, exists := r.phases[chaosPhaseRecovery]
+	return exists && phase.transactions > phase.failedTransactions
+}
+
+// chaosWorkload queries executed in a transaction repeatedly
+type chaosWorkload struct {
+	queries []string
+	isRead  []bool
+}
+
+// loadChaosWorkload reads queries separated by semicolon. lines start with '--' are ignored
+func loadChaosWorkload(path string) (*chaosWorkload, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	lines := []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+	parser, err := sqlparser.New()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	workload := &chaosWorkload{}
+	for _, text := range strings.Split(strings.Join(lines, "\n"), ";") {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		query, err := parser.Parse(text)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid query in workload: %s", text)
+		}
+		workload.queries = append(workload.queries, text)
+		workload.isRead = append(workload.isRead, query.QueryType().IsReadQuery())
+	}
+	if len(workload.queries) == 0 {
+		return nil, errors.Errorf("workload %s has no query", path)
+	}
+	return workload, nil
+}
+
+func (w *chaosWorkload) exec(ctx context.Context, tx *sql.Tx, idx int) error {
+	if !w.isRead[idx] {
+		_, err := tx.ExecContext(ctx, w.queries[idx])
+		return errors.WithStack(err)
+	}
+	rows, err := tx.QueryContext(ctx, w.queries[idx])
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return errors.WithStack(rows.Err())
+}
+
+// run executes all queries of workload in a transaction
+func (w *chaosWorkload) run(ctx context.Context, db *sql.DB, report *chaosReport) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		report.record(func(phase *chaosPhaseReport) {
+			phase.transactions++
+			phase.failedTransactions++
+		})
+		report.recordError(err)
+		return
+	}
+	for idx := range w.queries {
+		err := w.exec(ctx, tx, idx)
+		report.record(func(phase *chaosPhaseReport) {
+			phase.queries++
+			if err != nil {
+				phase.failedQueries++
+			}
+		})
+		if err != nil {
+			report.recordError(err)
+			tx.Rollback()
+			report.record(func(phase *chaosPhaseReport) {
+				phase.transactions++
+				phase.failedTransactions++
+			})
+			return
+		}
+	}
+	err = tx.Commit()
+	report.record(func(phase *chaosPhaseReport) {
+		phase.transactions++
+		if err != nil {
+			phase.failedTransactions++
+		}
+	})
+	if err != nil {
+		report.recordError(err)
+		tx.Rollback()
+	}
+}
+
+// chaosContainer docker container of shard
+type chaosContainer struct {
+	shardName string
+	name      string
+}
+
+func (cmd *ChaosCommand) docker(args ...string) error {
+	out, err := exec.Command(cmd.Docker, args...).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "failed to run %s %s: %s", cmd.Docker, strings.Join(args, " "), strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (cmd *ChaosCommand) injectFault(container *chaosContainer) error {
+	return errors.WithStack(cmd.docker(cmd.Mode, container.name))
+}
+
+func (cmd *ChaosCommand) recover(container *chaosContainer) error {
+	if cmd.Mode == chaosModePause {
+		return errors.WithStack(cmd.docker("unpause", container.name))
+	}
+	return errors.WithStack(cmd.docker("start", container.name))
+}
+
+// containers returns containers of shards specified by --kill-shard
+func (cmd *ChaosCommand) containers(cfg *config.Config) ([]*chaosContainer, error) {
+	names := map[string]string{}
+	for _, mapping := range cmd.Containers {
+		kv := strings.SplitN(mapping, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, errors.Errorf("invalid container mapping %s. it must be 'shard=container'", mapping)
+		}
+		names[kv[0]] = kv[1]
+	}
+	containers := []*chaosContainer{}
+	for _, shardName := range cmd.KillShards {
+		found := false
+		for _, table := range cfg.Tables {
+			if table.IsShard && table.ShardConfigByName(shardName) != nil {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.Errorf("cannot find shard %s in config", shardName)
+		}
+		name, exists := names[shardName]
+		if !exists {
+			name = shardName
+		}
+		containers = append(containers, &chaosContainer{shardName: shardName, name: name})
+	}
+	return containers, nil
+}
+
+func (cmd *ChaosCommand) validate() error {
+	if len(cmd.KillShards) == 0 {
+		return errors.New("required --kill-shard")
+	}
+	switch cmd.Mode {
+	case chaosModePause, chaosModeStop, chaosModeKill:
+	default:
+		return errors.Errorf("unknown mode %s", cmd.Mode)
+	}
+	if cmd.Duration <= 0 || cmd.Concurrency <= 0 || cmd.Timeout <= 0 {
+		return errors.New("duration, concurrency and timeout must be positive")
+	}
+	if cmd.FaultAfter < 0 || cmd.FaultFor < 0 || cmd.FaultAfter+cmd.FaultFor >= cmd.Duration {
+		return errors.Errorf("fault ( %s after start for %s ) must be finished before end of workload ( %s )", cmd.FaultAfter, cmd.FaultFor, cmd.Duration)
+	}
+	return nil
+}
+
+// Execute executes chaos command
+func (cmd *ChaosCommand) Execute(args []string) (e error) {
+	if err := cmd.validate(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := octillery.LoadConfig(cmd.Config); err != nil {
+		return errors.WithStack(err)
+	}
+	cfg, err := config.Get()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	containers, err := cmd.containers(cfg)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	workload, err := loadChaosWorkload(cmd.Workload)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	db, err := sql.Open("", "")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer db.Close()
+
+	report := newChaosReport()
+	octillery.BeforeCommitCallback(func(*sql.Tx, []*sql.QueryLog) error {
+		return nil
+	})
+	octillery.AfterCommitCallback(func(*sql.Tx) error {
+		report.record(func(phase *chaosPhaseReport) { phase.commitSuccesses++ })
+		return nil
+	}, func(_ *sql.Tx, isCriticalError bool, _ []*sql.QueryLog) error {
+		report.record(func(phase *chaosPhaseReport) {
+			phase.commitFailures++
+			if isCriticalError {
+				phase.criticalCommitErrors++
+			}
+		})
+		return nil
+	})
+	octillery.SetWarningHandler(func(w *warning.Warning) {
+		report.record(func(phase *chaosPhaseReport) { phase.warnings[w.Code]++ })
+	})
+	defer octillery.SetWarningHandler(nil)
+
+	// transactions running at the end of workload are not cancelled by ctx, so they are not reported as failure
+	ctx, cancel := context.WithTimeout(context.Background(), cmd.Duration)
+	defer cancel()
+	workCtx, stopWork := context.WithCancel(context.Background())
+	defer stopWork()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+			stopWork()
+		case <-ctx.Done():
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < cmd.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				txCtx, txCancel := context.WithTimeout(workCtx, cmd.Timeout)
+				workload.run(txCtx, db, report)
+				txCancel()
+			}
+		}()
+	}
+	defer func() {
+		cancel()
+		wg.Wait()
+		report.print(time.Now())
+		if e == nil && !report.recovered() {
+			e = errors.New("workload didn't succeed after recovery of shards")
+		}
+	}()
+
+	injected := []*chaosContainer{}
+	// shards must be recovered even if workload is interrupted, so containers are not left down
+	defer func() {
+		for _, container := range injected {
+			if err := cmd.recover(container); err != nil && e == nil {
+				e = errors.WithStack(err)
+			}
+		}
+	}()
+	if !sleepContext(ctx, cmd.FaultAfter) {
+		return errors.New("workload is interrupted before fault")
+	}
+	report.setPhase(chaosPhaseDuring)
+	for _, container := range containers {
+		fmt.Printf("%s shard %s ( container %s )\n", cmd.Mode, container.shardName, container.name)
+		if err := cmd.injectFault(container); err != nil {
+			return errors.WithStack(err)
+		}
+		injected = append(injected, container)
+	}
+	if !sleepContext(ctx, cmd.FaultFor) {
+		return errors.New("workload is interrupted during fault")
+	}
+	for _, container := range injected {
+		fmt.Printf("recover shard %s ( container %s )\n", container.shardName, container.name)
+		if err := cmd.recover(container); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	injected = nil
+	report.setPhase(chaosPhaseRecovery)
+	<-ctx.Done()
+	return nil
+}
+
+// sleepContext waits for duration. returns false if ctx is done before that
+func sleepContext(ctx context.Context, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
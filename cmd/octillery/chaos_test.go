@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.knocknote.io/octillery/config"
+)
+
+// fakeDocker writes script recording arguments to dir instead of docker command
+func fakeDocker(t *testing.T, dir string, exitCode int) (string, string) {
+	checkErr(t, os.Mkdir(dir, 0755))
+	logPath := filepath.Join(dir, "docker.log")
+	scriptPath := filepath.Join(dir, "docker")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$@\" >> %s\nexit %d\n", logPath, exitCode)
+	checkErr(t, ioutil.WriteFile(scriptPath, []byte(script), 0755))
+	return scriptPath, logPath
+}
+
+func TestLoadChaosWorkload(t *testing.T) {
+	confPath, _, teardown := setup(t)
+	defer teardown()
+	path := filepath.Join(filepath.Dir(confPath), "workload.sql")
+
+	t.Run("queries separated by semicolon", func(t *testing.T) {
+		checkErr(t, ioutil.WriteFile(path, []byte(strings.Join([]string{
+			"-- read user; this line is ignored",
+			"select * from users where id = 1;",
+			"update users",
+			"  set name = 'alice'",
+			"  where id = 1;",
+			"",
+		}, "\n")), 0644))
+		workload, err := loadChaosWorkload(path)
+		checkErr(t, err)
+		if len(workload.queries) != 2 {
+			t.Fatalf("invalid queries %v", workload.queries)
+		}
+		if workload.queries[0] != "select * from users where id = 1" || !workload.isRead[0] {
+			t.Fatalf("invalid read query %s", workload.queries[0])
+		}
+		if !strings.HasPrefix(workload.queries[1], "update users") || workload.isRead[1] {
+			t.Fatalf("invalid write query %s", workload.queries[1])
+		}
+	})
+	t.Run("invalid query", func(t *testing.T) {
+		checkErr(t, ioutil.WriteFile(path, []byte("select * from users; selec * from users;"), 0644))
+		if _, err := loadChaosWorkload(path); err == nil {
+			t.Fatal("cannot handle error of invalid query")
+		}
+	})
+	t.Run("no query", func(t *testing.T) {
+		checkErr(t, ioutil.WriteFile(path, []byte("-- select * from users;\n"), 0644))
+		if _, err := loadChaosWorkload(path); err == nil {
+			t.Fatal("cannot handle error of empty workload")
+		}
+	})
+}
+
+func TestChaosCommandValidate(t *testing.T) {
+	validCommand := func() *ChaosCommand {
+		return &ChaosCommand{
+			KillShards:  []string{"user_shard_1"},
+			Mode:        chaosModePause,
+			Duration:    30 * time.Second,
+			FaultAfter:  10 * time.Second,
+			FaultFor:    10 * time.Second,
+			Concurrency: 4,
+			Timeout:     5 * time.Second,
+		}
+	}
+	checkErr(t, validCommand().validate())
+	for name, modify := range map[string]func(*ChaosCommand){
+		"no shard":                    func(cmd *ChaosCommand) { cmd.KillShards = nil },
+		"unknown mode":                func(cmd *ChaosCommand) { cmd.Mode = "restart" },
+		"zero concurrency":            func(cmd *ChaosCommand) { cmd.Concurrency = 0 },
+		"zero timeout":                func(cmd *ChaosCommand) { cmd.Timeout = 0 },
+		"negative fault after":        func(cmd *ChaosCommand) { cmd.FaultAfter = -time.Second },
+		"fault until end of workload": func(cmd *ChaosCommand) { cmd.FaultFor = 20 * time.Second },
+	} {
+		cmd := validCommand()
+		modify(cmd)
+		if err := cmd.validate(); err == nil {
+			t.Fatalf("cannot handle error of %s", name)
+		}
+	}
+}
+
+func TestChaosContainers(t *testing.T) {
+	confPath, _, teardown := setup(t)
+	defer teardown()
+	cfg, err := config.Load(confPath)
+	checkErr(t, err)
+
+	cmd := &ChaosCommand{
+		KillShards: []string{"user_shard_1", "user_item_shard_2"},
+		Containers: []string{"user_shard_1=mysql_users_1"},
+	}
+	containers, err := cmd.containers(cfg)
+	checkErr(t, err)
+	if len(containers) != 2 {
+		t.Fatalf("invalid containers %v", containers)
+	}
+	if containers[0].shardName != "user_shard_1" || containers[0].name != "mysql_users_1" {
+		t.Fatalf("container must be mapped by --container. %v", containers[0])
+	}
+	if containers[1].shardName != "user_item_shard_2" || containers[1].name != "user_item_shard_2" {
+		t.Fatalf("shard name must be used as container name without mapping. %v", containers[1])
+	}
+	for _, cmd := range []*ChaosCommand{
+		{KillShards: []string{"user_shard_3"}},
+		{KillShards: []string{"user_stages"}},
+		{KillShards: []string{"user_shard_1"}, Containers: []string{"user_shard_1"}},
+		{KillShards: []string{"user_shard_1"}, Containers: []string{"user_shard_1="}},
+	} {
+		if _, err := cmd.containers(cfg); err == nil {
+			t.Fatalf("cannot handle error of shards %v and containers %v", cmd.KillShards, cmd.Containers)
+		}
+	}
+}
+
+func TestChaosReport(t *testing.T) {
+	report := newChaosReport()
+	report.record(func(phase *chaosPhaseReport) { phase.transactions++ })
+	report.recordError(errors.New(strings.Repeat("a", chaosErrorMessageLength+1)))
+	report.recordError(errors.New(strings.Repeat("a", chaosErrorMessageLength+2)))
+	if report.recovered() {
+		t.Fatal("workload must not be recovered before recovery phase")
+	}
+	before := report.phases[chaosPhaseBefore]
+	if before.transactions != 1 || before.errors[strings.Repeat("a", chaosErrorMessageLength)+"..."] != 2 {
+		t.Fatal("long error messages must be truncated and aggregated")
+	}
+	report.setPhase(chaosPhaseDuring)
+	report.setPhase(chaosPhaseRecovery)
+	report.record(func(phase *chaosPhaseReport) {
+		phase.transactions++
+		phase.failedTransactions++
+	})
+	if report.recovered() {
+		t.Fatal("workload must not be recovered if all transactions failed")
+	}
+	report.record(func(phase *chaosPhaseReport) { phase.transactions++ })
+	if !report.recovered() {
+		t.Fatal("workload must be recovered if a transaction succeeded after recovery")
+	}
+	out, err := captureStdout(t, func() error {
+		report.print(time.Now())
+		return nil
+	})
+	checkErr(t, err)
+	for _, text := range []string{
+		"[before fault] ",
+		"[during fault] ",
+		"[after recovery] ",
+		"  transactions:     1 ok, 1 failed\n",
+		"  error x 2: " + strings.Repeat("a", chaosErrorMessageLength) + "...\n",
+	} {
+		if !strings.Contains(out, text) {
+			t.Fatalf("%q is not found in report %q", text, out)
+		}
+	}
+}
+
+func TestChaosCommand(t *testing.T) {
+	confPath, _, teardown := setup(t)
+	defer teardown()
+
+	dir := filepath.Dir(confPath)
+	workloadPath := filepath.Join(dir, "workload.sql")
+	checkErr(t, ioutil.WriteFile(workloadPath, []byte("insert into user_stages(name) values ('alice'); select * from user_stages"), 0644))
+	newCommand := func(mode string, docker string) *ChaosCommand {
+		return &ChaosCommand{
+			KillShards:  []string{"user_shard_1", "user_shard_2"},
+			Containers:  []string{"user_shard_1=mysql_users_1"},
+			Mode:        mode,
+			Workload:    workloadPath,
+			Duration:    300 * time.Millisecond,
+			FaultAfter:  50 * time.Millisecond,
+			FaultFor:    50 * time.Millisecond,
+			Concurrency: 1,
+			Timeout:     time.Second,
+			Docker:      docker,
+			Config:      confPath,
+		}
+	}
+	t.Run("pause and unpause containers", func(t *testing.T) {
+		docker, logPath := fakeDocker(t, filepath.Join(dir, "pause"), 0)
+		out, err := captureStdout(t, func() error { return newCommand(chaosModePause, docker).Execute(nil) })
+		checkErr(t, err)
+		log, err := ioutil.ReadFile(logPath)
+		checkErr(t, err)
+		if string(log) != "pause mysql_users_1\npause user_shard_2\nunpause mysql_users_1\nunpause user_shard_2\n" {
+			t.Fatalf("invalid docker commands %q", log)
+		}
+		for _, phase := range chaosPhases {
+			if !strings.Contains(out, fmt.Sprintf("[%s] ", phase)) {
+				t.Fatalf("%s is not reported. %q", phase, out)
+			}
+		}
+	})
+	t.Run("stop and start containers", func(t *testing.T) {
+		docker, logPath := fakeDocker(t, filepath.Join(dir, "stop"), 0)
+		_, err := captureStdout(t, func() error { return newCommand(chaosModeStop, docker).Execute(nil) })
+		checkErr(t, err)
+		log, err := ioutil.ReadFile(logPath)
+		checkErr(t, err)
+		if string(log) != "stop mysql_users_1\nstop user_shard_2\nstart mysql_users_1\nstart user_shard_2\n" {
+			t.Fatalf("invalid docker commands %q", log)
+		}
+	})
+	t.Run("failure of docker", func(t *testing.T) {
+		docker, logPath := fakeDocker(t, filepath.Join(dir, "failure"), 1)
+		_, err := captureStdout(t, func() error { return newCommand(chaosModeKill, docker).Execute(nil) })
+		if err == nil || !strings.Contains(err.Error(), "failed to run") {
+			t.Fatalf("cannot handle error of docker command: %v", err)
+		}
+		log, err := ioutil.ReadFile(logPath)
+		checkErr(t, err)
+		if string(log) != "kill mysql_users_1\n" {
+			t.Fatalf("containers must not be recovered or killed after failure. %q", log)
+		}
+	})
+}
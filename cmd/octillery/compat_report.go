@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/compat"
+	"go.knocknote.io/octillery/config"
+)
+
+// Execute executes compat-report command.
+// Queries are analyzed by configuration file only, so databases don't need to be running.
+func (cmd *CompatReportCommand) Execute(args []string) error {
+	if _, err := config.Load(cmd.Config); err != nil {
+		return errors.WithStack(err)
+	}
+	file, err := os.Open(cmd.Queries)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer file.Close()
+	report, err := compat.AnalyzeReader(file)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, query := range report.Queries {
+		if query.Route == compat.RouteSingleShard && !cmd.Verbose {
+			continue
+		}
+		fmt.Printf("[%s] %s:%d: %s\n", query.Route, cmd.Queries, query.Line, query.Reason)
+		fmt.Printf("  %s\n", strings.Join(strings.Fields(query.Query), " "))
+	}
+	fmt.Println(report)
+	return nil
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	coresql "database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery"
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/connection"
+)
+
+// exportWriter writes rows of a table by output format of export command
+type exportWriter interface {
+	writeHeader(columns []string) error
+	// values are nil for NULL
+	writeRow(values []coresql.RawBytes) error
+	flush() error
+}
+
+// exportExtensions extension of file for each output format. files of csv, jsonl and sql can be read by import command
+var exportExtensions = map[string]string{
+	"csv":   ".csv",
+	"tsv":   ".tsv",
+	"jsonl": ".jsonl",
+	"sql":   ".sql",
+}
+
+func newExportWriter(format string, tableName string, w io.Writer) (exportWriter, error) {
+	switch format {
+	case "csv":
+		return &csvExportWriter{writer: csv.NewWriter(w)}, nil
+	case "tsv":
+		writer := csv.NewWriter(w)
+		writer.Comma = '\t'
+		return &csvExportWriter{writer: writer}, nil
+	case "jsonl":
+		return &jsonLinesExportWriter{writer: bufio.NewWriter(w)}, nil
+	case "sql":
+		return &sqlExportWriter{tableName: tableName, writer: bufio.NewWriter(w)}, nil
+	}
+	return nil, errors.Errorf("unknown output format %s. supported formats are csv, tsv, jsonl and sql", format)
+}
+
+// csvExportWriter writes header and rows as CSV ( or TSV ). NULL is written as 'null' like seeds of import
+type csvExportWriter struct {
+	writer *csv.Writer
+}
+
+func (w *csvExportWriter) writeHeader(columns []string) error {
+	return errors.WithStack(w.writer.Write(columns))
+}
+
+func (w *csvExportWriter) writeRow(values []coresql.RawBytes) error {
+	record := make([]string, 0, len(values))
+	for _, value := range values {
+		if value == nil {
+			record = append(record, "null")
+			continue
+		}
+		record = append(record, string(value))
+	}
+	return errors.WithStack(w.writer.Write(record))
+}
+
+func (w *csvExportWriter) flush() error {
+	w.writer.Flush()
+	return errors.WithStack(w.writer.Error())
+}
+
+// jsonLinesExportWriter writes a JSON object per row. keys are ordered by columns
+type jsonLinesExportWriter struct {
+	writer  *bufio.Writer
+	columns [][]byte
+}
+
+func (w *jsonLinesExportWriter) writeHeader(columns []string) error {
+	for _, column := range columns {
+		key, err := json.Marshal(column)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		w.columns = append(w.columns, key)
+	}
+	return nil
+}
+
+func (w *jsonLinesExportWriter) writeRow(values []coresql.RawBytes) error {
+	w.writer.WriteString("{")
+	for idx, value := range values {
+		if idx > 0 {
+			w.writer.WriteString(", ")
+		}
+		w.writer.Write(w.columns[idx])
+		w.writer.WriteString(": ")
+		if value == nil {
+			w.writer.WriteString("null")
+			continue
+		}
+		val, err := json.Marshal(string(value))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		w.writer.Write(val)
+	}
+	_, err := w.writer.WriteString("}\n")
+	return errors.WithStack(err)
+}
+
+func (w *jsonLinesExportWriter) flush() error {
+	return errors.WithStack(w.writer.Flush())
+}
+
+// sqlExportWriter writes an INSERT statement per row
+type sqlExportWriter struct {
+	tableName string
+	writer    *bufio.Writer
+	prefix    string
+}
+
+func (w *sqlExportWriter) writeHeader(columns []string) error {
+	escapedColumns := make([]string, 0, len(columns))
+	for _, column := range columns {
+		escapedColumns = append(escapedColumns, fmt.Sprintf("`%s`", column))
+	}
+	w.prefix = fmt.Sprintf("INSERT INTO `%s` (%s) VALUES (", w.tableName, strings.Join(escapedColumns, ", "))
+	return nil
+}
+
+func (w *sqlExportWriter) writeRow(values []coresql.RawBytes) error {
+	w.writer.WriteString(w.prefix)
+	for idx, value := range values {
+		if idx > 0 {
+			w.writer.WriteString(", ")
+		}
+		if value == nil {
+			w.writer.WriteString("NULL")
+			continue
+		}
+		w.writer.WriteString(vtparser.String(vtparser.NewStrVal(value)))
+	}
+	_, err := w.writer.WriteString(");\n")
+	return errors.WithStack(err)
+}
+
+func (w *sqlExportWriter) flush() error {
+	return errors.WithStack(w.writer.Flush())
+}
+
+// Execute executes export command.
+// Rows are read from each shard in order of configuration and written as soon as they are read,
+// so rows of all shards are not loaded into memory at once.
+func (cmd *ExportCommand) Execute(args []string) error {
+	ext, exists := exportExtensions[cmd.Format]
+	if !exists {
+		return errors.Errorf("unknown output format %s. supported formats are csv, tsv, jsonl and sql", cmd.Format)
+	}
+	if len(cmd.Tables) > 1 && cmd.Output == "" {
+		return errors.New("--output directory is required for exporting multiple tables")
+	}
+	if err := octillery.LoadConfig(cmd.Config); err != nil {
+		return errors.WithStack(err)
+	}
+	cfg, err := config.Get()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, tableName := range cmd.Tables {
+		if _, exists := cfg.Tables[tableName]; !exists {
+			return errors.Errorf("invalid table name %s", tableName)
+		}
+	}
+	mgr, err := connection.NewConnectionManager()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer mgr.Close()
+
+	if len(cmd.Tables) > 1 {
+		if err := os.MkdirAll(cmd.Output, 0755); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	for _, tableName := range cmd.Tables {
+		if err := cmd.exportTableTo(mgr, tableName, ext); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// exportTableTo exports table to file decided by --output ( or standard output )
+func (cmd *ExportCommand) exportTableTo(mgr *connection.DBConnectionManager, tableName string, ext string) (e error) {
+	if cmd.Output == "" {
+		return errors.WithStack(cmd.exportTable(mgr, tableName, os.Stdout))
+	}
+	path := cmd.Output
+	if len(cmd.Tables) > 1 {
+		path = filepath.Join(cmd.Output, tableName+ext)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil && e == nil {
+			e = errors.WithStack(err)
+		}
+	}()
+	return errors.WithStack(cmd.exportTable(mgr, tableName, file))
+}
+
+// exportTable writes rows of all shards of table to w. Columns of all shards must be the same.
+func (cmd *ExportCommand) exportTable(mgr *connection.DBConnectionManager, tableName string, w io.Writer) error {
+	conn, err := mgr.ConnectionByTableName(tableName)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	writer, err := newExportWriter(cmd.Format, tableName, w)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	var columns []string
+	totalRows := 0
+	shards := conn.Shards()
+	for _, shard := range shards {
+		shardName := shard.ShardName
+		if shardName == "" {
+			shardName = tableName
+		}
+		rows, err := shard.Connection.Query(fmt.Sprintf("SELECT * FROM `%s`", tableName))
+		if err != nil {
+			return errors.Wrapf(err, "cannot read rows of %s from %s", tableName, shardName)
+		}
+		shardRows, err := cmd.exportRows(rows, writer, &columns)
+		rows.Close()
+		if err != nil {
+			return errors.Wrapf(err, "cannot export rows of %s from %s", tableName, shardName)
+		}
+		totalRows += shardRows
+		fmt.Fprintf(os.Stderr, "[%s] %s: %d rows\n", tableName, shardName, shardRows)
+	}
+	if err := writer.flush(); err != nil {
+		return errors.WithStack(err)
+	}
+	fmt.Fprintf(os.Stderr, "[%s] exported %d rows from %d shards\n", tableName, totalRows, len(shards))
+	return nil
+}
+
+// exportRows writes rows of a shard. Header is written by the first shard, and columns of the others are compared with it.
+func (cmd *ExportCommand) exportRows(rows *coresql.Rows, writer exportWriter, columns *[]string) (int, error) {
+	shardColumns, err := rows.Columns()
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if *columns == nil {
+		if err := writer.writeHeader(shardColumns); err != nil {
+			return 0, errors.WithStack(err)
+		}
+		*columns = shardColumns
+	} else if strings.Join(*columns, ",") != strings.Join(shardColumns, ",") {
+		return 0, errors.Errorf("columns ( %s ) are different from the other shards ( %s )", strings.Join(shardColumns, ", "), strings.Join(*columns, ", "))
+	}
+	values := make([]coresql.RawBytes, len(shardColumns))
+	dest := make([]interface{}, len(values))
+	for idx := range values {
+		dest[idx] = &values[idx]
+	}
+	count := 0
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return 0, errors.WithStack(err)
+		}
+		if err := writer.writeRow(values); err != nil {
+			return 0, errors.WithStack(err)
+		}
+		count++
+	}
+	return count, errors.WithStack(rows.Err())
+}
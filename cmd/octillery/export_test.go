@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	coresql "database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	flags "github.com/jessevdk/go-flags"
+	"go.knocknote.io/octillery/connection"
+)
+
+func TestExportWriter(t *testing.T) {
+	rows := [][]coresql.RawBytes{
+		{coresql.RawBytes("1"), coresql.RawBytes("alice \"a\",\n'b'")},
+		{coresql.RawBytes("2"), nil},
+	}
+	for format, expected := range map[string]string{
+		"csv":   "id,name\n1,\"alice \"\"a\"\",\n'b'\"\n2,null\n",
+		"tsv":   "id\tname\n1\t\"alice \"\"a\"\",\n'b'\"\n2\tnull\n",
+		"jsonl": "{\"id\": \"1\", \"name\": \"alice \\\"a\\\",\\n'b'\"}\n{\"id\": \"2\", \"name\": null}\n",
+		"sql":   "INSERT INTO `users` (`id`, `name`) VALUES ('1', 'alice \\\"a\\\",\\n\\'b\\'');\nINSERT INTO `users` (`id`, `name`) VALUES ('2', NULL);\n",
+	} {
+		var buf bytes.Buffer
+		writer, err := newExportWriter(format, "users", &buf)
+		checkErr(t, err)
+		checkErr(t, writer.writeHeader([]string{"id", "name"}))
+		for _, row := range rows {
+			checkErr(t, writer.writeRow(row))
+		}
+		checkErr(t, writer.flush())
+		if buf.String() != expected {
+			t.Fatalf("invalid output of %s. %q", format, buf.String())
+		}
+	}
+	if _, err := newExportWriter("xml", "users", &bytes.Buffer{}); err == nil {
+		t.Fatal("cannot handle error of unknown format")
+	}
+}
+
+func TestExportCommand(t *testing.T) {
+	confPath, mgr, teardown := setup(t)
+	defer teardown()
+
+	for tableName, rows := range map[string][]string{
+		"users":       {"(1, 'alice', 10)", "(2, 'bob', 20)", "(3, 'carol', 30)"},
+		"user_stages": {"(1, 'stage1')", "(2, NULL)"},
+	} {
+		conn, err := mgr.ConnectionByTableName(tableName)
+		checkErr(t, err)
+		for idx, row := range rows {
+			shards := conn.Shards()
+			shard := shards[idx%len(shards)]
+			if _, err := shard.Connection.Exec("insert into " + tableName + " values " + row); err != nil {
+				t.Fatalf("%+v", err)
+			}
+		}
+	}
+	dir := filepath.Dir(confPath)
+	// export command is invoked from command line parser like main()
+	execute := func(args ...string) error {
+		var options Option
+		_, err := flags.NewParser(&options, flags.Default).ParseArgs(append([]string{"export", "--config", confPath}, args...))
+		return err
+	}
+	t.Run("multiple tables", func(t *testing.T) {
+		outputPath := filepath.Join(dir, "export")
+		checkErr(t, execute("--table", "users", "--table", "user_stages", "--format", "jsonl", "--output", outputPath))
+		users, err := ioutil.ReadFile(filepath.Join(outputPath, "users.jsonl"))
+		checkErr(t, err)
+		if string(users) != strings.Join([]string{
+			`{"id": "1", "name": "alice", "age": "10"}`,
+			`{"id": "3", "name": "carol", "age": "30"}`,
+			`{"id": "2", "name": "bob", "age": "20"}`,
+		}, "\n")+"\n" {
+			t.Fatalf("rows of all shards must be exported in order of shards. %q", users)
+		}
+		stages, err := ioutil.ReadFile(filepath.Join(outputPath, "user_stages.jsonl"))
+		checkErr(t, err)
+		if string(stages) != `{"id": "1", "name": "stage1"}`+"\n"+`{"id": "2", "name": null}`+"\n" {
+			t.Fatalf("invalid rows of table not sharded. %q", stages)
+		}
+	})
+	t.Run("standard output", func(t *testing.T) {
+		out, err := captureStdout(t, func() error { return execute("--table", "user_stages") })
+		checkErr(t, err)
+		if out != "id,name\n1,stage1\n2,null\n" {
+			t.Fatalf("invalid output %q", out)
+		}
+	})
+	t.Run("import exported rows", func(t *testing.T) {
+		for _, format := range []string{"csv", "sql"} {
+			seedsPath := filepath.Join(dir, "seeds_"+format)
+			checkErr(t, os.Mkdir(seedsPath, 0755))
+			checkErr(t, execute("--table", "user_stages", "--format", format, "--output", filepath.Join(seedsPath, "user_stages."+format)))
+			checkErr(t, mgr.ForEachShard("user_stages", func(shard *connection.DBShardConnection) error {
+				_, err := shard.Connection.Exec("delete from user_stages")
+				return err
+			}, nil))
+			cmd := &ImportCommand{NoTruncate: true, Concurrency: 1, StateFile: filepath.Join(dir, "state.json"), Config: confPath}
+			_, err := captureStdout(t, func() error { return cmd.Execute([]string{seedsPath}) })
+			checkErr(t, err)
+			if names := columnValues(t, mgr, "user_stages", "name"); strings.Join(names, ",") != "null,stage1" {
+				t.Fatalf("cannot import rows exported as %s. %v", format, names)
+			}
+		}
+	})
+	t.Run("different columns of shards", func(t *testing.T) {
+		conn, err := mgr.ConnectionByTableName("users")
+		checkErr(t, err)
+		if _, err := conn.Shards()[1].Connection.Exec("alter table users add column nickname text"); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		_, err = captureStdout(t, func() error { return execute("--table", "users") })
+		if err == nil || !strings.Contains(err.Error(), "are different from the other shards") {
+			t.Fatalf("cannot handle error of columns: %v", err)
+		}
+	})
+	t.Run("invalid options", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"--table", "users", "--format", "xml"},
+			{"--table", "users", "--table", "user_stages"},
+			{"--table", "user_decks"},
+			{"--format", "csv"},
+		} {
+			if err := execute(args...); err == nil {
+				t.Fatalf("cannot handle error of %v", args)
+			}
+		}
+	})
+}
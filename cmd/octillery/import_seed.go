@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+// maximum size of a line of JSON lines seed
+const maxJSONLineSize = 64 * 1024 * 1024
+
+// importSeed seeds of a table read from file named by table ( e.g. users.csv, users.jsonl or users.sql )
+type importSeed struct {
+	path string
+	// rows of CSV or JSON lines. the first row is header
+	records [][]string
+	// statements of SQL seed. they are executed as they are instead of records
+	statements []*sqlparser.Statement
+	// if true, values are not quoted like CSV, so escape sequences in strings are not interpreted
+	raw bool
+}
+
+// total returns number of rows ( or statements ) imported by seed
+func (s *importSeed) total() int {
+	if s.statements != nil {
+		return len(s.statements)
+	}
+	if len(s.records) == 0 {
+		return 0
+	}
+	return len(s.records) - 1
+}
+
+// readImportSeed reads seed file by extension. If file is not seed, returns nil.
+func readImportSeed(path string) (*importSeed, error) {
+	switch filepath.Ext(path) {
+	case ".csv":
+		return readCSVSeed(path)
+	case ".jsonl", ".ndjson":
+		return readJSONLinesSeed(path)
+	case ".sql":
+		return readSQLSeed(path)
+	}
+	return nil, nil
+}
+
+func readCSVSeed(path string) (*importSeed, error) {
+	seeds, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open file %s", path)
+	}
+	defer seeds.Close()
+	reader := csv.NewReader(seeds)
+	reader.LazyQuotes = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read file %s", path)
+	}
+	return &importSeed{path: path, records: records}, nil
+}
+
+// readJSONLinesSeed reads a JSON object per line. Columns are keys of the first object, and all objects must have the same keys.
+func readJSONLinesSeed(path string) (*importSeed, error) {
+	seeds, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open file %s", path)
+	}
+	defer seeds.Close()
+	var columns []string
+	records := [][]string{}
+	scanner := bufio.NewScanner(seeds)
+	scanner.Buffer(make([]byte, 64*1024), maxJSONLineSize)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.UseNumber()
+		var object map[string]interface{}
+		if err := decoder.Decode(&object); err != nil {
+			return nil, errors.Wrapf(err, "invalid JSON at line %d of %s", lineNum, path)
+		}
+		if len(object) == 0 {
+			return nil, errors.Errorf("empty object at line %d of %s", lineNum, path)
+		}
+		if columns == nil {
+			for column := range object {
+				columns = append(columns, column)
+			}
+			sort.Strings(columns)
+			records = append(records, columns)
+		}
+		if len(object) != len(columns) {
+			return nil, errors.Errorf("keys at line %d of %s are different from the first line", lineNum, path)
+		}
+		record := make([]string, 0, len(columns))
+		for _, column := range columns {
+			value, exists := object[column]
+			if !exists {
+				return nil, errors.Errorf("%s is not found at line %d of %s", column, lineNum, path)
+			}
+			v, err := jsonSeedValue(value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid value of %s at line %d of %s", column, lineNum, path)
+			}
+			record = append(record, v)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read file %s", path)
+	}
+	return &importSeed{path: path, records: records, raw: true}, nil
+}
+
+// jsonSeedValue converts value of JSON to text of CSV seed
+func jsonSeedValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "null", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	}
+	// nested object or array is stored as JSON text ( e.g. JSON column )
+	content, err := json.Marshal(value)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return string(content), nil
+}
+
+// readSQLSeed reads statements separated by semicolon ( e.g. INSERT statements dumped from database )
+func readSQLSeed(path string) (*importSeed, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read file %s", path)
+	}
+	statements, err := sqlparser.SplitStatements(string(content))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to split statements of %s", path)
+	}
+	seed := &importSeed{path: path, statements: []*sqlparser.Statement{}}
+	for _, statement := range statements {
+		// file without statements is split into an empty statement
+		if statement.Text != "" {
+			seed.statements = append(seed.statements, statement)
+		}
+	}
+	return seed, nil
+}
+
+// validateSQLSeed checks that all statements of SQL seed are INSERT for tableName
+func validateSQLSeed(tableName string, seed *importSeed) error {
+	parser, err := sqlparser.New()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for idx, statement := range seed.statements {
+		query, err := parser.Parse(statement.Text, statement.Args...)
+		if err != nil {
+			return errors.Wrapf(err, "invalid statement %d of %s", idx+1, seed.path)
+		}
+		if query.QueryType() != sqlparser.Insert || query.Table() != tableName {
+			return errors.Errorf("statement %d of %s must be INSERT for %s", idx+1, seed.path, tableName)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadImportSeed(t *testing.T) {
+	confPath, _, teardown := setup(t)
+	defer teardown()
+
+	dir := filepath.Dir(confPath)
+	writeSeed := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		checkErr(t, ioutil.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+	t.Run("csv", func(t *testing.T) {
+		seed, err := readImportSeed(writeSeed("users.csv", "id,name\n1,\"alice\\n\"\n2,bob\n"))
+		checkErr(t, err)
+		if seed.raw || seed.total() != 2 {
+			t.Fatalf("invalid seed %v", seed)
+		}
+		if !reflect.DeepEqual(seed.records, [][]string{{"id", "name"}, {"1", "alice\\n"}, {"2", "bob"}}) {
+			t.Fatalf("invalid records %v", seed.records)
+		}
+	})
+	t.Run("json lines", func(t *testing.T) {
+		for _, name := range []string{"users.jsonl", "users.ndjson"} {
+			path := writeSeed(name, `{"name": "alice\n", "id": 1, "age": null, "tags": ["a", "b"]}`+"\n\n"+`{"id": 2, "name": "bob", "age": 12345678901234567890, "tags": {"admin": true}}`+"\n")
+			seed, err := readImportSeed(path)
+			checkErr(t, err)
+			if !seed.raw || seed.total() != 2 || seed.path != path {
+				t.Fatalf("invalid seed %v", seed)
+			}
+			expected := [][]string{
+				{"age", "id", "name", "tags"},
+				{"null", "1", "alice\n", `["a","b"]`},
+				{"12345678901234567890", "2", "bob", `{"admin":true}`},
+			}
+			if !reflect.DeepEqual(seed.records, expected) {
+				t.Fatalf("invalid records %q", seed.records)
+			}
+		}
+	})
+	t.Run("json value", func(t *testing.T) {
+		for value, expected := range map[string]string{"true": "1", "false": "0", "1.5": "1.5", `"a"`: "a"} {
+			seed, err := readImportSeed(writeSeed("user_stages.jsonl", `{"name": `+value+"}\n"))
+			checkErr(t, err)
+			if seed.records[1][0] != expected {
+				t.Fatalf("%s must be converted to %s. but %s", value, expected, seed.records[1][0])
+			}
+		}
+	})
+	t.Run("invalid json lines", func(t *testing.T) {
+		for _, content := range []string{
+			`{"id": 1, "name": "alice"}` + "\n" + `{"id": 2}`,
+			`{"id": 1, "name": "alice"}` + "\n" + `{"id": 2, "age": 3}`,
+			`{"id": 1}` + "\n" + `{"id": 2, "name": "bob"}`,
+			`{"id": 1}` + "\n" + `{}`,
+			`{"id": 1` + "\n",
+			`[1, 2]` + "\n",
+		} {
+			if _, err := readImportSeed(writeSeed("users.jsonl", content)); err == nil {
+				t.Fatalf("cannot handle error of %q", content)
+			}
+		}
+	})
+	t.Run("sql", func(t *testing.T) {
+		path := writeSeed("users.sql", "INSERT INTO `users` (`id`, `name`) VALUES (1, 'alice;bob');\n-- comment\nINSERT INTO users (id, name) VALUES (2, 'carol');\n")
+		seed, err := readImportSeed(path)
+		checkErr(t, err)
+		if seed.records != nil || seed.total() != 2 {
+			t.Fatalf("invalid seed %v", seed)
+		}
+		checkErr(t, validateSQLSeed("users", seed))
+		if err := validateSQLSeed("user_items", seed); err == nil {
+			t.Fatal("cannot handle error of INSERT for other table")
+		}
+		seed, err = readImportSeed(writeSeed("users.sql", "INSERT INTO users (id, name) VALUES (1, 'alice');\nDELETE FROM users;\n"))
+		checkErr(t, err)
+		if err := validateSQLSeed("users", seed); err == nil {
+			t.Fatal("cannot handle error of statement other than INSERT")
+		}
+		seed, err = readImportSeed(writeSeed("users.sql", "\n"))
+		checkErr(t, err)
+		if seed.statements == nil || seed.total() != 0 {
+			t.Fatalf("empty SQL seed must have no statements. %v", seed)
+		}
+	})
+	t.Run("not seed", func(t *testing.T) {
+		seed, err := readImportSeed(writeSeed("users.txt", "id,name\n"))
+		checkErr(t, err)
+		if seed != nil {
+			t.Fatal("file without extension of seed must be ignored")
+		}
+	})
+}
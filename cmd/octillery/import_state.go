@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// minimum interval of writing state file during import
+const importCheckpointInterval = time.Second
+
+// importState checkpoint of import command recorded to state file
+type importState struct {
+	Tables map[string]*importTableState `json:"tables"`
+
+	path    string
+	mu      sync.Mutex
+	savedAt time.Time
+}
+
+// importTableState rows imported for a table
+type importTableState struct {
+	// path to seed file
+	Source string `json:"source"`
+	// number of rows in seed file for detecting replacement of it after checkpoint
+	Total int `json:"total"`
+	// number of imported rows
+	Rows int `json:"rows"`
+	// number of imported rows for each shard
+	Shards map[string]int `json:"shards,omitempty"`
+	// whether all rows are imported or not
+	Done bool `json:"done"`
+}
+
+func loadImportState(path string) (*importState, error) {
+	state := &importState{Tables: map[string]*importTableState{}, path: path}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read state file %s", path)
+	}
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, errors.Wrapf(err, "cannot parse state file %s", path)
+	}
+	if state.Tables == nil {
+		state.Tables = map[string]*importTableState{}
+	}
+	return state, nil
+}
+
+// table returns state of tableName. If resume is false or seed file is not imported yet, returns new state.
+func (s *importState) table(tableName string, source string, total int, resume bool) (*importTableState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tableState, exists := s.Tables[tableName]; exists && resume {
+		if tableState.Source != source || tableState.Total != total {
+			return nil, errors.Errorf("seed file of %s is changed after checkpoint. remove %s or import without --resume", tableName, s.path)
+		}
+		return tableState, nil
+	}
+	tableState := &importTableState{
+		Source: source,
+		Total:  total,
+		Shards: map[string]int{},
+	}
+	s.Tables[tableName] = tableState
+	return tableState, nil
+}
+
+func (s *importState) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saveWithoutLock()
+}
+
+// saveIfNeeded writes state file if importCheckpointInterval is elapsed from last writing
+func (s *importState) saveIfNeeded() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.savedAt) < importCheckpointInterval {
+		return nil
+	}
+	return s.saveWithoutLock()
+}
+
+func (s *importState) saveWithoutLock() error {
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	tmpPath := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, 0644); err != nil {
+		return errors.Wrapf(err, "cannot write state file %s", tmpPath)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return errors.Wrapf(err, "cannot write state file %s", s.path)
+	}
+	s.savedAt = time.Now()
+	return nil
+}
+
+func (s *importState) remove() error {
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "cannot remove state file %s", s.path)
+	}
+	return nil
+}
+
+// cancelOnSignal calls cancel when import is interrupted by SIGINT or SIGTERM.
+// Rows being inserted are finished or failed before import stops, so checkpoint is written after them by caller.
+// Signals after the first one are handled by default ( e.g. second SIGINT kills process ).
+// Returned function stops handling signals.
+func cancelOnSignal(cancel context.CancelFunc) func() {
+	sigCh := make(chan os.Signal, 1)
+	doneCh := make(chan struct{})
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigCh:
+			signal.Stop(sigCh)
+			fmt.Printf("import is interrupted. waiting for rows being inserted\n")
+			cancel()
+		case <-doneCh:
+		}
+	}()
+	return func() {
+		signal.Stop(sigCh)
+		close(doneCh)
+	}
+}
+
+// importProgress reports progress of importing a table at intervals
+type importProgress struct {
+	tableName   string
+	state       *importState
+	tableState  *importTableState
+	interval    time.Duration
+	startRows   int
+	startShards map[string]int
+	startedAt   time.Time
+	reportedAt  time.Time
+}
+
+func newImportProgress(tableName string, state *importState, tableState *importTableState, interval time.Duration) *importProgress {
+	startShards := map[string]int{}
+	for shardName, rows := range tableState.Shards {
+		startShards[shardName] = rows
+	}
+	now := time.Now()
+	return &importProgress{
+		tableName:   tableName,
+		state:       state,
+		tableState:  tableState,
+		interval:    interval,
+		startRows:   tableState.Rows,
+		startShards: startShards,
+		startedAt:   now,
+		reportedAt:  now,
+	}
+}
+
+// add records rows imported to shard. shardName is empty if shard is unknown or table is not sharded.
+func (p *importProgress) add(shardName string, rows int) error {
+	p.state.mu.Lock()
+	p.tableState.Rows += rows
+	if shardName != "" {
+		if p.tableState.Shards == nil {
+			p.tableState.Shards = map[string]int{}
+		}
+		p.tableState.Shards[shardName] += rows
+	}
+	p.state.mu.Unlock()
+	if p.interval > 0 && time.Since(p.reportedAt) >= p.interval {
+		p.report()
+	}
+	return errors.WithStack(p.state.saveIfNeeded())
+}
+
+// finish marks table as imported and writes state file
+func (p *importProgress) finish() error {
+	p.state.mu.Lock()
+	p.tableState.Done = true
+	p.state.mu.Unlock()
+	p.report()
+	return errors.WithStack(p.state.save())
+}
+
+func (p *importProgress) report() {
+	p.state.mu.Lock()
+	defer p.state.mu.Unlock()
+	p.reportedAt = time.Now()
+	elapsed := time.Since(p.startedAt).Seconds()
+	rate := func(rows int) float64 {
+		if elapsed <= 0 {
+			return 0
+		}
+		return float64(rows) / elapsed
+	}
+	tableRate := rate(p.tableState.Rows - p.startRows)
+	eta := "unknown"
+	if tableRate > 0 {
+		remain := float64(p.tableState.Total-p.tableState.Rows) / tableRate
+		eta = time.Duration(remain * float64(time.Second)).Round(time.Second).String()
+	}
+	percent := 100.0
+	if p.tableState.Total > 0 {
+		percent = float64(p.tableState.Rows) * 100 / float64(p.tableState.Total)
+	}
+	shardNames := make([]string, 0, len(p.tableState.Shards))
+	for shardName := range p.tableState.Shards {
+		shardNames = append(shardNames, shardName)
+	}
+	sort.Strings(shardNames)
+	shards := make([]string, 0, len(shardNames))
+	for _, shardName := range shardNames {
+		rows := p.tableState.Shards[shardName]
+		shards = append(shards, fmt.Sprintf("%s:%d rows (%.1f rows/sec)", shardName, rows, rate(rows-p.startShards[shardName])))
+	}
+	fmt.Printf(
+		"[%s] %d/%d rows (%.1f%%) %.1f rows/sec ETA %s\n",
+		p.tableName, p.tableState.Rows, p.tableState.Total, percent, tableRate, eta,
+	)
+	if len(shards) > 0 {
+		fmt.Printf("[%s]   %s\n", p.tableName, strings.Join(shards, " "))
+	}
+}
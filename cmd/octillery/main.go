@@ -2,18 +2,22 @@ package main
 
 import (
 	"bufio"
+	"context"
 	coresql "database/sql"
-	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
@@ -23,23 +27,35 @@ import (
 	"go.knocknote.io/octillery/algorithm"
 	"go.knocknote.io/octillery/config"
 	"go.knocknote.io/octillery/connection"
-	_ "go.knocknote.io/octillery/connection/adapter/plugin"
 	"go.knocknote.io/octillery/database/sql"
 	"go.knocknote.io/octillery/migrator"
 	"go.knocknote.io/octillery/printer"
 	"go.knocknote.io/octillery/sqlparser"
+	"go.knocknote.io/octillery/stats"
 	"go.knocknote.io/octillery/transposer"
+	"gopkg.in/yaml.v2"
 )
 
 // Option type for command line options
 type Option struct {
-	Version   VersionCommand   `description:"print the version of octillery" command:"version"`
-	Transpose TransposeCommand `description:"replace 'database/sql' to 'go.knocknote.io/octillery/database/sql'" command:"transpose"`
-	Migrate   MigrateCommand   `description:"migrate database schema ( powered by schemalex )" command:"migrate"`
-	Import    ImportCommand    `description:"import seeds" command:"import"`
-	Console   ConsoleCommand   `description:"database console" command:"console"`
-	Install   InstallCommand   `description:"install database adapter" command:"install"`
-	Shard     ShardCommand     `description:"get sharded database information by sharding key" command:"shard"`
+	Version      VersionCommand      `description:"print the version of octillery" command:"version"`
+	Transpose    TransposeCommand    `description:"replace 'database/sql' to 'go.knocknote.io/octillery/database/sql'" command:"transpose"`
+	Migrate      MigrateCommand      `description:"migrate database schema ( powered by schemalex )" command:"migrate"`
+	Import       ImportCommand       `description:"import seeds" command:"import"`
+	Console      ConsoleCommand      `description:"database console" command:"console"`
+	Install      InstallCommand      `description:"install database adapter" command:"install"`
+	Shard        ShardCommand        `description:"get sharded database information by sharding key" command:"shard"`
+	Topology     TopologyCommand     `description:"print routing table without credentials" command:"topology"`
+	Explain      ExplainCommand      `description:"estimate shards touched by query and rough cost of it" command:"explain"`
+	Seed         SeedCommand         `description:"manage test data" command:"seed"`
+	Lint         LintCommand         `description:"check configuration file for risky settings ( exit with 1 if found )" command:"lint"`
+	Maintain     MaintainCommand     `description:"maintain tables of sequencer ( e.g. OPTIMIZE TABLE of MySQL )" command:"maintain" subcommands-optional:"true"`
+	Chaos        ChaosCommand        `description:"run workload while shard containers of docker are paused or killed, and report behavior of routing and callbacks" command:"chaos"`
+	Reshard      ReshardCommand      `description:"move rows of sharded tables to shards decided by current configuration ( e.g. after adding shard )" command:"reshard"`
+	CompatReport CompatReportCommand `description:"report which queries of application are routed to a shard, scattered to shards or unsupported" command:"compat-report"`
+	Export       ExportCommand       `description:"export rows of all shards as a merged dataset ( inverse of import )" command:"export"`
+	VerifySchema VerifySchemaCommand `description:"report drift of schema ( missing columns or indexes ) between shards of sharded tables" command:"verify-schema"`
+	Stats        StatsCommand        `description:"report row counts of each shard and skew of sharded tables to detect hot shards" command:"stats"`
 }
 
 // VersionCommand type for version command
@@ -54,24 +70,39 @@ type TransposeCommand struct {
 
 // MigrateCommand type for migrate command
 type MigrateCommand struct {
-	DryRun bool   `long:"dry-run"           description:"show diff only"`
-	Quiet  bool   `long:"quiet"   short:"q" description:"not print logs during migration"`
-	Config string `long:"config"  short:"c" description:"database configuration file path" required:"config path"`
+	DryRun   bool   `long:"dry-run"           description:"show diff only"`
+	Quiet    bool   `long:"quiet"   short:"q" description:"not print logs during migration"`
+	History  bool   `long:"history"           description:"record applied DDL to octillery_migrations table of each database and refuse to apply DDL already recorded"`
+	Status   bool   `long:"status"            description:"show applied ( recorded by --history ) and pending DDL of each database"`
+	Rollback int    `long:"rollback"          description:"revert the last n migrations recorded by --history on each database ( confirm by --dry-run )"`
+	Config   string `long:"config"  short:"c" description:"database configuration file path" required:"config path"`
 }
 
 // ImportCommand type for import command
 type ImportCommand struct {
-	Config string `long:"config" short:"c" description:"database configuration file path" required:"config path"`
+	DryRun           bool          `long:"dry-run"                     description:"validate seeds and show distribution of rows for each shard without writing anything"`
+	Resume           bool          `long:"resume"                      description:"resume import from checkpoint recorded to state file instead of truncating tables"`
+	NoTruncate       bool          `long:"no-truncate"                 description:"append rows to tables instead of truncating them"`
+	Tables           []string      `long:"table"             short:"t" description:"import seeds of this table only ( can be specified multiple times )"`
+	Concurrency      int           `long:"concurrency"       short:"p" description:"number of tables imported concurrently"                   default:"4"`
+	StateFile        string        `long:"state"                       description:"path to state file that records rows imported per table" default:"octillery_import_state.json"`
+	ProgressInterval time.Duration `long:"progress-interval"           description:"interval of progress output ( 0 disables it )"            default:"10s"`
+	Config           string        `long:"config"            short:"c" description:"database configuration file path"                         required:"config path"`
 }
 
 // ConsoleCommand type for console command
 type ConsoleCommand struct {
+	Table  string `long:"table"  short:"t" description:"table name of shard specified by --shard"`
+	Shard  string `long:"shard"  short:"s" description:"execute queries on this shard directly without routing ( requires --table )"`
+	Format string `long:"format" short:"f" description:"output format of rows ( table, vertical, csv, tsv or json )" default:"table"`
+	Output string `long:"output" short:"o" description:"file path rows are written to instead of standard output"`
 	Config string `long:"config" short:"c" description:"database configuration file path" required:"config path"`
 }
 
 // InstallCommand type for install command
 type InstallCommand struct {
 	MySQLAdapter  bool `long:"mysql"  description:"install mysql adapter"`
+	AuroraAdapter bool `long:"aurora" description:"install aurora adapter ( Amazon Aurora MySQL )"`
 	SQLiteAdapter bool `long:"sqlite" description:"install sqlite3 adapter"`
 }
 
@@ -81,6 +112,101 @@ type ShardCommand struct {
 	Config  string `long:"config" short:"c" description:"database configuration file path" required:"config path"`
 }
 
+// TopologyCommand type for topology command
+type TopologyCommand struct {
+	Format string `long:"format" short:"f" description:"output format ( json or yaml )" default:"json"`
+	Config string `long:"config" short:"c" description:"database configuration file path" required:"config path"`
+}
+
+// ExplainCommand type for explain command
+type ExplainCommand struct {
+	Config string `long:"config" short:"c" description:"database configuration file path" required:"config path"`
+}
+
+// LintCommand type for lint command
+type LintCommand struct {
+	Config string `long:"config" short:"c" description:"database configuration file path" required:"config path"`
+}
+
+// MaintainCommand type for maintain command
+type MaintainCommand struct {
+	Timeout time.Duration          `long:"timeout"           description:"timeout of maintenance" default:"10m"`
+	Config  string                 `long:"config"  short:"c" description:"database configuration file path" required:"config path"`
+	Analyze MaintainAnalyzeCommand `description:"update statistics of tables on all shards ( e.g. ANALYZE TABLE of MySQL )" command:"analyze"`
+}
+
+// MaintainAnalyzeCommand type for maintain analyze command
+type MaintainAnalyzeCommand struct {
+	Concurrency int `long:"concurrency" description:"number of shards of a table analyzed at the same time" default:"4"`
+}
+
+// ChaosCommand type for chaos command
+type ChaosCommand struct {
+	KillShards  []string      `long:"kill-shard"           description:"shard name whose container is paused or killed ( can be specified multiple times )"`
+	Containers  []string      `long:"container"            description:"container name of shard as 'shard=container'. if not specified, shard name is used"`
+	Mode        string        `long:"mode"                 description:"how to inject fault ( pause, stop or kill )"            default:"pause"`
+	Workload    string        `long:"workload"   short:"w" description:"path to file of queries separated by semicolon. they are executed in a transaction repeatedly" required:"workload path"`
+	Duration    time.Duration `long:"duration"   short:"d" description:"duration of workload"                                   default:"30s"`
+	FaultAfter  time.Duration `long:"fault-after"          description:"elapsed time from start of workload to fault"             default:"10s"`
+	FaultFor    time.Duration `long:"fault-for"            description:"duration of fault before containers are recovered"        default:"10s"`
+	Concurrency int           `long:"concurrency"          description:"number of workers running workload"                       default:"4"`
+	Timeout     time.Duration `long:"timeout"              description:"timeout of each transaction"                              default:"5s"`
+	Docker      string        `long:"docker"               description:"path to docker command"                                    default:"docker"`
+	Config      string        `long:"config"     short:"c" description:"database configuration file path"                         required:"config path"`
+}
+
+// ReshardCommand type for reshard command
+type ReshardCommand struct {
+	Tables    []string `long:"table"     short:"t" description:"sharded table name ( can be specified multiple times ). if not specified, all sharded tables are resharded"`
+	DryRun    bool     `long:"dry-run"             description:"only print plan of resharding"`
+	BatchSize int      `long:"batch-size"          description:"number of shard_key values moved by a transaction" default:"100"`
+	Config    string   `long:"config"    short:"c" description:"database configuration file path"                 required:"config path"`
+}
+
+// CompatReportCommand type for compat-report command
+type CompatReportCommand struct {
+	Queries string `long:"queries"           description:"path to file of queries separated by semicolon"           required:"queries path"`
+	Verbose bool   `long:"verbose" short:"v" description:"print queries routed to a shard in addition to the others"`
+	Config  string `long:"config"  short:"c" description:"database configuration file path"                        required:"config path"`
+}
+
+// ExportCommand type for export command
+type ExportCommand struct {
+	Tables []string `long:"table"  short:"t" description:"table name ( can be specified multiple times )"                                                    required:"table name"`
+	Format string   `long:"format" short:"f" description:"output format ( csv, tsv, jsonl or sql )"                                                          default:"csv"`
+	Output string   `long:"output" short:"o" description:"file path rows are written to ( directory if multiple tables are exported ). standard output if not specified"`
+	Config string   `long:"config" short:"c" description:"database configuration file path"                                                                 required:"config path"`
+}
+
+// VerifySchemaCommand type for verify-schema command
+type VerifySchemaCommand struct {
+	Tables []string `long:"table"  short:"t" description:"table name ( can be specified multiple times ). all sharded tables if not specified"`
+	Config string   `long:"config" short:"c" description:"database configuration file path"                                                   required:"config path"`
+}
+
+// StatsCommand type for stats command
+type StatsCommand struct {
+	Tables        []string `long:"table"          short:"t" description:"table name ( can be specified multiple times ). all sharded tables if not specified"`
+	ShardKeyRange bool     `long:"shard-key-range"          description:"report minimum and maximum value of shard key of each shard"`
+	Threshold     float64  `long:"threshold"                description:"ratio of rows to average rows of shards regarded as hot shard" default:"1.5"`
+	Concurrency   int      `long:"concurrency"              description:"number of shards counted concurrently"                         default:"1"`
+	Config        string   `long:"config"         short:"c" description:"database configuration file path"                               required:"config path"`
+}
+
+// SeedCommand type for seed command
+type SeedCommand struct {
+	Generate SeedGenerateCommand `description:"generate randomized rows routed across shards" command:"generate"`
+}
+
+// SeedGenerateCommand type for seed generate command
+type SeedGenerateCommand struct {
+	Table      string `long:"table"  short:"t" description:"table name"                                                                 required:"table name"`
+	Rows       int    `long:"rows"   short:"n" description:"number of rows"                                                             default:"1000"`
+	SchemaPath string `long:"schema" short:"s" description:"path to schema file or directory. if not specified, schema is read from database"`
+	RandSeed   int64  `long:"seed"             description:"seed of random values. if not specified, current time is used"`
+	Config     string `long:"config" short:"c" description:"database configuration file path"                                          required:"config path"`
+}
+
 var opts Option
 
 // Execute executes version command
@@ -115,6 +241,12 @@ func (cmd *TransposeCommand) Execute(args []string) error {
 
 // Execute executes migrate command
 func (cmd *MigrateCommand) Execute(args []string) error {
+	if cmd.Rollback > 0 {
+		if err := octillery.LoadConfig(cmd.Config); err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(migrator.NewMigratorByConfig(cmd.DryRun, cmd.Quiet).Rollback(cmd.Rollback))
+	}
 	if len(args) == 0 {
 		return errors.New("argument is required. it is path to directory includes schema file or direct path to schema file")
 	}
@@ -123,49 +255,47 @@ func (cmd *MigrateCommand) Execute(args []string) error {
 	}
 
 	schemaPath := args[0]
-	migrator, err := migrator.NewMigrator("mysql", cmd.DryRun, cmd.Quiet)
-	if err != nil {
-		return errors.WithStack(err)
+	if !cmd.Quiet {
+		warnings, err := migrator.CheckForeignKeys(schemaPath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for _, warning := range warnings {
+			fmt.Printf("[WARN] foreign key cannot be enforced. %s\n", warning)
+		}
+	}
+	// plugin is chosen by adapter of each table ( mysql, postgres or sqlite3 )
+	migrator := migrator.NewMigratorByConfig(cmd.DryRun, cmd.Quiet)
+	migrator.History = cmd.History
+	if cmd.Status {
+		return errors.WithStack(cmd.printStatus(migrator, schemaPath))
 	}
 	return errors.WithStack(migrator.Migrate(schemaPath))
 }
 
-func (cmd *ImportCommand) schemaFromTableName(tableName string) (vtparser.Statement, error) {
-	mgr, err := connection.NewConnectionManager()
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-	defer mgr.Close()
-	conn, err := mgr.ConnectionByTableName(tableName)
+func (cmd *MigrateCommand) printStatus(m *migrator.Migrator, schemaPath string) error {
+	statuses, err := m.Status(schemaPath)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return errors.WithStack(err)
 	}
-	var db *coresql.DB
-	if conn.IsShard {
-		for _, shard := range conn.ShardConnections.AllShard() {
-			db = shard.Connection
-			break
+	for _, status := range statuses {
+		fmt.Printf("[ %s ]\n\n", status.DSN)
+		fmt.Printf("applied: %d\n", len(status.Applied))
+		for _, history := range status.Applied {
+			fmt.Printf("  v%d %s %s %s\n", history.Version, history.AppliedAt, history.Checksum[:12], strings.Join(strings.Fields(history.Statement), " "))
 		}
-	} else {
-		db = conn.Connection
-	}
-	if db == nil {
-		return nil, errors.New("cannot get database connection")
-	}
-	var table string
-	var tableSchema string
-	if err = db.QueryRow(fmt.Sprintf("SHOW CREATE TABLE `%s`", tableName)).Scan(&table, &tableSchema); err != nil {
-		return nil, errors.Wrapf(err, `failed to execute 'SHOW CREATE TABLE "%s"'`, tableName)
-	}
-	parser, err := sqlparser.New()
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-	query, err := parser.Parse(tableSchema)
-	if err != nil {
-		return nil, errors.WithStack(err)
+		fmt.Printf("pending: %d\n", len(status.Pending))
+		for _, ddl := range status.Pending {
+			statement := strings.Join(strings.Fields(ddl), " ")
+			if history := status.AppliedHistory(ddl); history != nil {
+				fmt.Printf("  %s ( already applied at %s )\n", statement, history.AppliedAt)
+				continue
+			}
+			fmt.Printf("  %s\n", statement)
+		}
+		fmt.Println()
 	}
-	return query.(*sqlparser.QueryBase).Stmt, nil
+	return nil
 }
 
 var (
@@ -215,7 +345,7 @@ const (
 )
 
 // nolint: gocyclo
-func (cmd *ImportCommand) convertMySQLTypeToGOType(typ string) GoType {
+func convertMySQLTypeToGOType(typ string) GoType {
 	if charPattern.MatchString(typ) ||
 		enumPattern.MatchString(typ) ||
 		setPattern.MatchString(typ) ||
@@ -252,10 +382,10 @@ func (cmd *ImportCommand) convertMySQLTypeToGOType(typ string) GoType {
 	return UnknownType
 }
 
-func (cmd *ImportCommand) columnTypes(schema vtparser.Statement) (map[string]GoType, error) {
+func (cmd *ImportCommand) columnTypes(schema *connection.TableSchema) (map[string]GoType, error) {
 	columnToTypeMap := map[string]GoType{}
-	for _, column := range schema.(*vtparser.CreateTable).Columns {
-		typ := cmd.convertMySQLTypeToGOType(column.Type)
+	for _, column := range schema.Stmt.Columns {
+		typ := convertMySQLTypeToGOType(column.Type)
 		if typ == UnknownType {
 			return columnToTypeMap, errors.Errorf("cannot map %s to Go type", column.Type)
 		}
@@ -264,6 +394,22 @@ func (cmd *ImportCommand) columnTypes(schema vtparser.Statement) (map[string]GoT
 	return columnToTypeMap, nil
 }
 
+func (cmd *ImportCommand) typesByColumns(schema *connection.TableSchema, columns []string, tableName string) ([]GoType, error) {
+	columnNameToTypeMap, err := cmd.columnTypes(schema)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot get column types. table is %s", tableName)
+	}
+	types := []GoType{}
+	for _, column := range columns {
+		typ, exists := columnNameToTypeMap[column]
+		if !exists {
+			return nil, errors.Errorf("cannot get Go type from column name %s. table is %s", column, tableName)
+		}
+		types = append(types, typ)
+	}
+	return types, nil
+}
+
 func (cmd *ImportCommand) timeValueWithFormat(format string, v string) (*time.Time, error) {
 	if v == "null" {
 		return nil, nil
@@ -275,8 +421,10 @@ func (cmd *ImportCommand) timeValueWithFormat(format string, v string) (*time.Ti
 	return &value, nil
 }
 
+// values converts record of seed to values of columns.
+// Strings of CSV may have escape sequences ( e.g. '\n' ) and they are interpreted unless raw is true.
 // nolint: gocyclo
-func (cmd *ImportCommand) values(record []string, types []GoType, columns []string, tableName string) ([]interface{}, error) {
+func (cmd *ImportCommand) values(record []string, types []GoType, columns []string, tableName string, raw bool) ([]interface{}, error) {
 	values := []interface{}{}
 	for idx, v := range record {
 		typ := types[idx]
@@ -300,7 +448,9 @@ func (cmd *ImportCommand) values(record []string, types []GoType, columns []stri
 			}
 			values = append(values, value)
 		case GoString:
-			if unquotedString, err := strconv.Unquote(fmt.Sprintf("\"%s\"", v)); err == nil {
+			if raw {
+				values = append(values, v)
+			} else if unquotedString, err := strconv.Unquote(fmt.Sprintf("\"%s\"", v)); err == nil {
 				values = append(values, unquotedString)
 			} else {
 				values = append(values, v)
@@ -341,12 +491,17 @@ func (cmd *ImportCommand) values(record []string, types []GoType, columns []stri
 	return values, nil
 }
 
-// Execute executes import command
+// Execute executes import command.
+// Seeds are CSV ( .csv ), JSON lines ( .jsonl or .ndjson ) or INSERT statements ( .sql ) named by table.
+// Tables are imported concurrently by --concurrency.
 // nolint: gocyclo
-func (cmd *ImportCommand) Execute(args []string) error {
+func (cmd *ImportCommand) Execute(args []string) (e error) {
 	if len(args) == 0 {
 		return errors.New("argument is required. it is path to directory includes schema file or direct path to schema file")
 	}
+	if cmd.Concurrency < 1 {
+		return errors.New("--concurrency must be positive number")
+	}
 	if err := octillery.LoadConfig(cmd.Config); err != nil {
 		return errors.WithStack(err)
 	}
@@ -354,10 +509,15 @@ func (cmd *ImportCommand) Execute(args []string) error {
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	for _, tableName := range cmd.Tables {
+		if _, exists := cfg.Tables[tableName]; !exists {
+			return errors.Errorf("invalid table name %s", tableName)
+		}
+	}
 
 	seedsPath := args[0]
 
-	importTables := map[string][][]string{}
+	importTables := map[string]*importSeed{}
 
 	if err := filepath.Walk(seedsPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -367,26 +527,30 @@ func (cmd *ImportCommand) Execute(args []string) error {
 			return nil
 		}
 		ext := filepath.Ext(path)
-		if ext != ".csv" {
-			return nil
-		}
 		baseName := filepath.Base(path)
 		tableName := baseName[:len(baseName)-len(ext)]
+		if !cmd.isImportTable(tableName) {
+			return nil
+		}
+		seed, err := readImportSeed(path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if seed == nil {
+			return nil
+		}
 		if _, exists := cfg.Tables[tableName]; !exists {
 			return errors.Errorf("invalid table name %s", tableName)
 		}
-		seeds, err := os.Open(path)
-		if err != nil {
-			return errors.Wrapf(err, "failed to open file %s", path)
+		if dup, exists := importTables[tableName]; exists {
+			return errors.Errorf("seeds of %s are duplicated ( %s and %s )", tableName, dup.path, path)
 		}
-		defer seeds.Close()
-		reader := csv.NewReader(seeds)
-		reader.LazyQuotes = true
-		records, err := reader.ReadAll()
-		if err != nil {
-			return errors.Wrapf(err, "failed to read file %s", path)
+		if seed.statements != nil {
+			if err := validateSQLSeed(tableName, seed); err != nil {
+				return errors.WithStack(err)
+			}
 		}
-		importTables[tableName] = records
+		importTables[tableName] = seed
 		return nil
 	}); err != nil {
 		return errors.WithStack(err)
@@ -398,96 +562,365 @@ func (cmd *ImportCommand) Execute(args []string) error {
 	}
 	defer conn.Close()
 
-	for tableName, records := range importTables {
-		if len(records) < 2 {
+	schemaCache := conn.ConnectionManager().SchemaCache()
+	tableNames := []string{}
+	for tableName := range importTables {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+	if err := schemaCache.Warm(tableNames...); err != nil {
+		return errors.Wrapf(err, "cannot get schema")
+	}
+	if cmd.DryRun {
+		return errors.WithStack(cmd.dryRun(conn, cfg, tableNames, importTables))
+	}
+
+	state, err := loadImportState(cmd.StateFile)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopSignalHandler := cancelOnSignal(cancel)
+	defer stopSignalHandler()
+	defer func() {
+		if e != nil {
+			if err := state.save(); err != nil {
+				fmt.Printf("[WARN] cannot save checkpoint: %+v\n", err)
+				return
+			}
+			fmt.Printf("rows imported until failure are recorded to %s. restart import by --resume option\n", cmd.StateFile)
+			return
+		}
+		if err := state.remove(); err != nil {
+			e = errors.WithStack(err)
+		}
+	}()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs = &connection.MultiError{}
+		sem  = make(chan struct{}, cmd.Concurrency)
+	)
+	for _, tableName := range tableNames {
+		mu.Lock()
+		failed := len(errs.Errors) > 0
+		mu.Unlock()
+		if failed || ctx.Err() != nil {
+			// tables not started yet are imported after restarting by --resume
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(tableName string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := cmd.importTable(ctx, conn, cfg, state, tableName, importTables[tableName]); err != nil {
+				mu.Lock()
+				errs.Add(err)
+				mu.Unlock()
+			}
+		}(tableName)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil && errs.ErrorOrNil() == nil {
+		// interrupted before starting remaining tables
+		errs.Add(errors.Wrap(err, "import is interrupted"))
+	}
+	return errors.WithStack(errs.ErrorOrNil())
+}
+
+// isImportTable returns whether seeds of table are imported by --table
+func (cmd *ImportCommand) isImportTable(tableName string) bool {
+	if len(cmd.Tables) == 0 {
+		return true
+	}
+	for _, name := range cmd.Tables {
+		if name == tableName {
+			return true
+		}
+	}
+	return false
+}
+
+// importTable imports seed of table. Table is truncated before import unless --no-truncate or --resume.
+// If ctx is cancelled, import stops after rows being inserted are recorded to state.
+// nolint: gocyclo
+func (cmd *ImportCommand) importTable(ctx context.Context, conn *sql.DB, cfg *config.Config, state *importState, tableName string, seed *importSeed) error {
+	if seed.total() == 0 {
+		return nil
+	}
+	tableState, err := state.table(tableName, seed.path, seed.total(), cmd.Resume)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if tableState.Done {
+		fmt.Printf("[%s] skip because all rows are already imported\n", tableName)
+		return nil
+	}
+	if tableState.Rows > 0 {
+		fmt.Printf("[%s] resume from row %d\n", tableName, tableState.Rows+1)
+	} else if cmd.NoTruncate {
+		fmt.Printf("[%s] append rows without truncating table\n", tableName)
+	} else if _, err := conn.Exec(fmt.Sprintf("TRUNCATE TABLE `%s`", tableName)); err != nil {
+		return errors.Wrapf(err, "cannot truncate table %s", tableName)
+	}
+	progress := newImportProgress(tableName, state, tableState, cmd.ProgressInterval)
+
+	if seed.statements != nil {
+		for _, statement := range seed.statements[tableState.Rows:] {
+			if err := ctx.Err(); err != nil {
+				return errors.Wrapf(err, "import of %s is interrupted", tableName)
+			}
+			if _, err := conn.Exec(statement.Text, statement.Args...); err != nil {
+				return errors.Wrapf(err, "cannot insert [%s]", statement.Text)
+			}
+			if err := progress.add("", 1); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return errors.WithStack(progress.finish())
+	}
+
+	records := seed.records
+	schema, err := conn.ConnectionManager().SchemaCache().Schema(tableName)
+	if err != nil {
+		return errors.Wrapf(err, "cannot get schema. table is %s", tableName)
+	}
+	columns := records[0]
+	types, err := cmd.typesByColumns(schema, columns, tableName)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	recordsWithoutHeader := records[1:][tableState.Rows:]
+
+	placeholders := []string{}
+	for i := 0; i < len(columns); i++ {
+		placeholders = append(placeholders, "?")
+	}
+	escapedColumns := []string{}
+	for _, column := range columns {
+		escapedColumns = append(escapedColumns, fmt.Sprintf("`%s`", column))
+	}
+	if !cfg.Tables[tableName].IsShard {
+		// try to bulk insert if not sharding table
+		placeholderTmpl := fmt.Sprintf("(%s)", strings.Join(placeholders, ","))
+		maxPlaceholderNum := 1000
+		for start := 0; start < len(recordsWithoutHeader); start += maxPlaceholderNum {
+			if err := ctx.Err(); err != nil {
+				return errors.Wrapf(err, "import of %s is interrupted", tableName)
+			}
+			end := start + maxPlaceholderNum
+			if end > len(recordsWithoutHeader) {
+				end = len(recordsWithoutHeader)
+			}
+			filteredRecords := recordsWithoutHeader[start:end]
+			allPlaceholders := []string{}
+			values := []interface{}{}
+			for _, record := range filteredRecords {
+				vals, err := cmd.values(record, types, columns, tableName, seed.raw)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+				allPlaceholders = append(allPlaceholders, placeholderTmpl)
+				values = append(values, vals...)
+			}
+			prepareText := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", tableName, strings.Join(escapedColumns, ","), strings.Join(allPlaceholders, ","))
+			if _, err := conn.Exec(prepareText, values...); err != nil {
+				return errors.Wrapf(err, "cannot insert [%s]:%v", prepareText, values)
+			}
+			if err := progress.add("", len(filteredRecords)); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return errors.WithStack(progress.finish())
+	}
+	shardConn, err := conn.ConnectionManager().ConnectionByTableName(tableName)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	shardKeyIndex := -1
+	shardKeyName := cfg.ShardKeyColumnName(tableName)
+	for idx, column := range columns {
+		if column == shardKeyName {
+			shardKeyIndex = idx
+		}
+	}
+	queryText := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", tableName, strings.Join(escapedColumns, ","), strings.Join(placeholders, ","))
+	for _, record := range recordsWithoutHeader {
+		if err := ctx.Err(); err != nil {
+			return errors.Wrapf(err, "import of %s is interrupted", tableName)
+		}
+		values, err := cmd.values(record, types, columns, tableName, seed.raw)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if _, err := conn.Exec(queryText, values...); err != nil {
+			return errors.Wrapf(err, "cannot insert [%s]:%v", queryText, values)
+		}
+		if err := progress.add(cmd.shardName(shardConn, values, shardKeyIndex), 1); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return errors.WithStack(progress.finish())
+}
+
+// maximum number of invalid rows printed for each table by dry-run
+const maxDryRunErrorsPerTable = 10
+
+// dryRun validates seeds without writing anything.
+// It checks header of CSV ( or keys of JSON lines ) by schema, converts all rows and prints distribution of rows for each shard.
+// Statements of SQL seeds are validated when they are read, so only number of them is printed.
+func (cmd *ImportCommand) dryRun(conn *sql.DB, cfg *config.Config, tableNames []string, importTables map[string]*importSeed) error {
+	schemaCache := conn.ConnectionManager().SchemaCache()
+	invalidTableNum := 0
+	for _, tableName := range tableNames {
+		seed := importTables[tableName]
+		if seed.total() == 0 {
+			fmt.Printf("[%s] skip because there are no rows\n", tableName)
+			continue
+		}
+		if seed.statements != nil {
+			fmt.Printf("[%s] %d statements are valid\n", tableName, len(seed.statements))
 			continue
 		}
-		schema, err := cmd.schemaFromTableName(tableName)
+		records := seed.records
+		schema, err := schemaCache.Schema(tableName)
 		if err != nil {
 			return errors.Wrapf(err, "cannot get schema. table is %s", tableName)
 		}
-		columnNameToTypeMap, err := cmd.columnTypes(schema)
+		columns := records[0]
+		types, err := cmd.typesByColumns(schema, columns, tableName)
 		if err != nil {
-			return errors.Wrapf(err, "cannot get column types. table is %s", tableName)
+			fmt.Printf("[%s] invalid header: %s\n", tableName, err)
+			invalidTableNum++
+			continue
 		}
-		columns := records[0]
-		types := []GoType{}
-		for _, column := range columns {
-			typ, exists := columnNameToTypeMap[column]
-			if !exists {
-				return errors.Errorf("cannot get Go type from column name %s. table is %s", column, tableName)
-			}
-			types = append(types, typ)
-		}
-
-		placeholders := []string{}
-		for i := 0; i < len(columns); i++ {
-			placeholders = append(placeholders, "?")
-		}
-		escapedColumns := []string{}
-		for _, column := range columns {
-			escapedColumns = append(escapedColumns, fmt.Sprintf("`%s`", column))
-		}
-		if !cfg.Tables[tableName].IsShard {
-			// try to bulk insert if not sharding table
-			placeholderTmpl := fmt.Sprintf("(%s)", strings.Join(placeholders, ","))
-			recordsWithoutHeader := records[1:]
-			maxPlaceholderNum := 1000
-			if len(recordsWithoutHeader) < maxPlaceholderNum {
-				maxPlaceholderNum = len(recordsWithoutHeader)
-			}
-			allBulkRequestNum := len(recordsWithoutHeader) / maxPlaceholderNum
-			remainRecordNum := len(recordsWithoutHeader) - maxPlaceholderNum*allBulkRequestNum
-			if _, err := conn.Exec(fmt.Sprintf("TRUNCATE TABLE `%s`", tableName)); err != nil {
-				return errors.Wrapf(err, "cannot truncate table %s", tableName)
-			}
-			for i := 0; i < allBulkRequestNum; i++ {
-				start := i * maxPlaceholderNum
-				end := start + maxPlaceholderNum
-				if (i + 1) == allBulkRequestNum {
-					end += remainRecordNum
-				}
-				filteredRecords := recordsWithoutHeader[start:end]
-				allPlaceholders := []string{}
-				values := []interface{}{}
-				for _, record := range filteredRecords {
-					vals, err := cmd.values(record, types, columns, tableName)
-					if err != nil {
-						return errors.WithStack(err)
-					}
-					allPlaceholders = append(allPlaceholders, placeholderTmpl)
-					values = append(values, vals...)
-				}
-				prepareText := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", tableName, strings.Join(escapedColumns, ","), strings.Join(allPlaceholders, ","))
-				if _, err := conn.Exec(prepareText, values...); err != nil {
-					return errors.Wrapf(err, "cannot insert [%s]:%v", prepareText, values)
+		dbConn, err := conn.ConnectionManager().ConnectionByTableName(tableName)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		shardKeyIndex := -1
+		if dbConn.IsShard {
+			shardKeyName := cfg.ShardKeyColumnName(tableName)
+			for idx, column := range columns {
+				if column == shardKeyName {
+					shardKeyIndex = idx
 				}
 			}
-		} else {
-			prepareText := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", tableName, strings.Join(escapedColumns, ","), strings.Join(placeholders, ","))
-			stmt, err := conn.Prepare(prepareText)
+		}
+		distribution := map[string]int{}
+		invalidRowNum := 0
+		for idx, record := range records[1:] {
+			values, err := cmd.values(record, types, columns, tableName, seed.raw)
 			if err != nil {
-				return errors.Wrapf(err, "cannot prepare [%s]", prepareText)
+				if invalidRowNum < maxDryRunErrorsPerTable {
+					// line number of CSV file includes header
+					fmt.Printf("[%s] invalid row at line %d: %s\n", tableName, idx+2, err)
+				}
+				invalidRowNum++
+				continue
 			}
-			if _, err := conn.Exec(fmt.Sprintf("TRUNCATE TABLE `%s`", tableName)); err != nil {
-				return errors.Wrapf(err, "cannot truncate table %s", tableName)
+			if dbConn.IsShard {
+				distribution[cmd.shardName(dbConn, values, shardKeyIndex)]++
+			} else {
+				distribution[tableName]++
 			}
-			for _, record := range records[1:] {
-				values, err := cmd.values(record, types, columns, tableName)
-				if err != nil {
-					return errors.WithStack(err)
-				}
-				if _, err := stmt.Exec(values...); err != nil {
-					return errors.Wrapf(err, "cannot insert [%s]:%v", prepareText, values)
-				}
+		}
+		fmt.Printf("[%s] %d rows are valid, %d rows are invalid\n", tableName, len(records)-1-invalidRowNum, invalidRowNum)
+		names := []string{}
+		for name := range distribution {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if name == "" {
+				fmt.Printf("[%s]   %d rows ( shard is decided by sequencer )\n", tableName, distribution[name])
+				continue
 			}
+			fmt.Printf("[%s]   %s: %d rows\n", tableName, name, distribution[name])
+		}
+		if invalidRowNum > 0 {
+			invalidTableNum++
 		}
 	}
+	if invalidTableNum > 0 {
+		return errors.Errorf("found invalid seeds for %d tables", invalidTableNum)
+	}
 	return nil
 }
 
-// Execute executes console command
+// shardName returns shard name of inserted row. if shard key is decided by sequencer, returns empty string.
+func (cmd *ImportCommand) shardName(conn *connection.DBConnection, values []interface{}, shardKeyIndex int) string {
+	if shardKeyIndex < 0 {
+		return ""
+	}
+	var id int64
+	switch value := values[shardKeyIndex].(type) {
+	case int64:
+		id = value
+	case uint64:
+		id = int64(value)
+	default:
+		return ""
+	}
+	shardConn, err := conn.ShardConnectionByID(id)
+	if err != nil {
+		return ""
+	}
+	return shardConn.ShardName
+}
+
+// consoleEstimateQuery returns query of 'estimate <query>' typed in console
+func consoleEstimateQuery(line string) (string, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !strings.EqualFold(fields[0], "estimate") {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimSpace(line)[len(fields[0]):]), true
+}
+
+// exec executes query typed in console. If shard is specified, query is executed on the shard directly.
+func (cmd *ConsoleCommand) exec(db *sql.DB, query string) ([]*coresql.Rows, coresql.Result, error) {
+	if cmd.Shard != "" {
+		return octillery.ExecOnShard(db, cmd.Table, cmd.Shard, query)
+	}
+	return octillery.Exec(db, query)
+}
+
+// consoleVerticalQuery returns query typed with '\G' suffix that prints rows vertically like MySQL client
+func consoleVerticalQuery(line string) (string, bool) {
+	query := strings.TrimSpace(line)
+	if !strings.HasSuffix(query, `\G`) {
+		return line, false
+	}
+	return strings.TrimSpace(strings.TrimSuffix(query, `\G`)), true
+}
+
+// Execute executes console command.
+// 'estimate <query>' prints number of rows returned by SELECT query estimated by EXPLAIN of shards without executing it.
+// If --shard is specified, queries are executed on the shard of --table directly without routing by sharding algorithm.
+// Rows are printed by --format ( or vertically if query ends with '\G' ) to --output.
 func (cmd *ConsoleCommand) Execute(args []string) error {
+	if cmd.Shard != "" && cmd.Table == "" {
+		return errors.New("--table is required for --shard")
+	}
+	format, err := printer.ParseFormat(cmd.Format)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	output := os.Stdout
+	if cmd.Output != "" {
+		file, err := os.Create(cmd.Output)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer file.Close()
+		output = file
+	}
 	if err := octillery.LoadConfig(cmd.Config); err != nil {
 		return errors.WithStack(err)
 	}
@@ -495,27 +928,56 @@ func (cmd *ConsoleCommand) Execute(args []string) error {
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	fmt.Print("octillery> ")
+	prompt := "octillery> "
+	if cmd.Shard != "" {
+		// check table and shard before reading queries
+		multiRows, _, err := octillery.ExecOnShard(db, cmd.Table, cmd.Shard, "SELECT 1")
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for _, rows := range multiRows {
+			rows.Close()
+		}
+		prompt = fmt.Sprintf("octillery(%s)> ", cmd.Shard)
+	}
+	fmt.Print(prompt)
 	s := bufio.NewScanner(os.Stdin)
 	for s.Scan() {
 		query := s.Text()
 		if query == "quit" || query == "exit" {
 			return nil
 		}
-		multiRows, result, err := octillery.Exec(db, query)
+		if estimateQuery, ok := consoleEstimateQuery(query); ok {
+			count, err := db.EstimateCount(estimateQuery)
+			if err != nil {
+				fmt.Printf("%+v\n", err)
+			} else {
+				fmt.Printf("about %d rows\n", count)
+			}
+			fmt.Print(prompt)
+			continue
+		}
+		query, vertical := consoleVerticalQuery(query)
+		multiRows, result, err := cmd.exec(db, query)
 		if err != nil {
 			fmt.Printf("%+v\n", err)
 		} else if multiRows != nil {
-			printer, err := printer.NewPrinter(multiRows)
+			rowsPrinter, err := printer.NewPrinter(multiRows)
 			if err != nil {
 				fmt.Printf("%+v\n", err)
 				return nil
 			}
-			printer.Print()
+			rowsPrinter.SetFormat(format)
+			if vertical {
+				rowsPrinter.SetFormat(printer.FormatVertical)
+			}
+			if err := rowsPrinter.Fprint(output); err != nil {
+				return errors.WithStack(err)
+			}
 		} else if result != nil {
 
 		}
-		fmt.Print("octillery> ")
+		fmt.Print(prompt)
 	}
 	return nil
 }
@@ -560,10 +1022,12 @@ func (cmd *InstallCommand) installToPath(sourcePath string) error {
 	var adapterPath string
 	if cmd.MySQLAdapter {
 		adapterPath = filepath.Join(adapterBasePath, "mysql.go")
+	} else if cmd.AuroraAdapter {
+		adapterPath = filepath.Join(adapterBasePath, "aurora.go")
 	} else if cmd.SQLiteAdapter {
 		adapterPath = filepath.Join(adapterBasePath, "sqlite3.go")
 	} else {
-		return errors.New("unknown adapter name. currently supports '--mysql' or '--sqlite' only")
+		return errors.New("unknown adapter name. currently supports '--mysql', '--aurora' or '--sqlite' only")
 	}
 	adapterData, err := ioutil.ReadFile(adapterPath)
 	if err != nil {
@@ -626,16 +1090,36 @@ func (cmd *ShardCommand) Execute(args []string) error {
 	}
 	conns := []*coresql.DB{}
 	connMap := map[*coresql.DB]*config.DatabaseConfig{}
-	for _, shardMap := range tableConfig.Shards {
+	subShards := map[int]*algorithm.SubShards{}
+	for shardIndex, shardMap := range tableConfig.Shards {
 		// append dummy connection
 		conn := &coresql.DB{}
-		for _, shard := range shardMap {
+		for shardName, shard := range shardMap {
 			connMap[conn] = shard
+			if !shard.IsSplit() {
+				continue
+			}
+			subConns := []*coresql.DB{}
+			for _, subShardMap := range shard.SubShards {
+				subConn := &coresql.DB{}
+				for _, subShard := range subShardMap {
+					connMap[subConn] = subShard
+				}
+				subConns = append(subConns, subConn)
+			}
+			split, err := algorithm.NewSubShards(shardName, shard.SubShardAlgorithm, shard.SubShardAlgorithmConfig, subConns)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			subShards[shardIndex] = split
 		}
 		conns = append(conns, conn)
 	}
-	if !logic.Init(conns) {
-		return errors.New("cannot initialize sharding algorithm")
+	if err := algorithm.InitShardingAlgorithm(logic, conns, tableConfig.AlgorithmConfig); err != nil {
+		return errors.WithStack(err)
+	}
+	if len(subShards) > 0 {
+		logic = algorithm.NewHierarchicalShardingAlgorithm(logic, subShards)
 	}
 	conn, err := logic.Shard(conns, cmd.ShardID)
 	if err != nil {
@@ -663,6 +1147,362 @@ func (cmd *ShardCommand) Execute(args []string) error {
 	return errors.New("cannot find target database")
 }
 
+// Execute executes topology command
+func (cmd *TopologyCommand) Execute(args []string) error {
+	cfg, err := config.Load(cmd.Config)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	topology := octillery.NewTopology(cfg)
+	var bytes []byte
+	switch cmd.Format {
+	case "json":
+		bytes, err = json.MarshalIndent(topology, "", "  ")
+	case "yaml":
+		bytes, err = yaml.Marshal(topology)
+	default:
+		return errors.Errorf("unknown format %s", cmd.Format)
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	fmt.Println(strings.TrimSuffix(string(bytes), "\n"))
+	return nil
+}
+
+// Execute executes lint command
+func (cmd *LintCommand) Execute(args []string) error {
+	cfg, err := config.Load(cmd.Config)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	tableNames := make([]string, 0, len(cfg.Tables))
+	for tableName := range cfg.Tables {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+	found := 0
+	for _, tableName := range tableNames {
+		if err := cfg.Tables[tableName].Error(); err != nil {
+			fmt.Printf("[error] %s: %s\n", tableName, err)
+			found++
+		}
+	}
+	for _, warning := range cfg.Lint() {
+		fmt.Println(warning)
+		found++
+	}
+	if found > 0 {
+		os.Exit(1)
+	}
+	fmt.Println("no risky settings found")
+	return nil
+}
+
+// Execute executes maintain command
+func (cmd *MaintainCommand) Execute(args []string) error {
+	if err := octillery.LoadConfig(cmd.Config); err != nil {
+		return errors.WithStack(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cmd.Timeout)
+	defer cancel()
+	results, err := octillery.MaintainSequencers(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	failed := 0
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			fmt.Printf("[error] %s: %s\n", result.SequencerTableName, result.Err)
+			failed++
+		case !result.Maintained:
+			fmt.Printf("[skip] %s: adapter doesn't need maintenance of sequencer\n", result.SequencerTableName)
+		default:
+			fmt.Printf("[ok] %s: %s\n", result.SequencerTableName, strings.Join(result.Messages, ", "))
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("failed to maintain %d sequencer tables", failed)
+	}
+	return nil
+}
+
+// Execute executes maintain analyze command. --config and --timeout are options of maintain command.
+// If tables are not specified by arguments, all tables in configuration file are analyzed.
+func (cmd *MaintainAnalyzeCommand) Execute(args []string) error {
+	if cmd.Concurrency < 1 {
+		return errors.New("concurrency must be positive")
+	}
+	if err := octillery.LoadConfig(opts.Maintain.Config); err != nil {
+		return errors.WithStack(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Maintain.Timeout)
+	defer cancel()
+	results, err := octillery.AnalyzeTables(ctx, cmd.Concurrency, args...)
+	failed := 0
+	for _, result := range results {
+		target := result.TableName
+		if result.ShardName != "" {
+			target = fmt.Sprintf("%s ( %s )", result.TableName, result.ShardName)
+		}
+		switch {
+		case result.Err != nil:
+			fmt.Printf("[error] %s: %s\n", target, result.Err)
+			failed++
+		case !result.Analyzed:
+			fmt.Printf("[skip] %s: adapter doesn't support analyze\n", target)
+		default:
+			fmt.Printf("[ok] %s: %s ( %s )\n", target, strings.Join(result.Messages, ", "), result.Duration)
+		}
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if failed > 0 {
+		return errors.Errorf("failed to analyze %d shards", failed)
+	}
+	return nil
+}
+
+// Execute executes explain command
+func (cmd *ExplainCommand) Execute(args []string) error {
+	if len(args) == 0 {
+		return errors.New("required query")
+	}
+	queryText := strings.Join(args, " ")
+	if err := octillery.LoadConfig(cmd.Config); err != nil {
+		return errors.WithStack(err)
+	}
+	parser, err := sqlparser.New()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	query, err := parser.Parse(queryText)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	mgr, err := connection.NewConnectionManager()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer mgr.Close()
+	sampler := stats.NewSampler(mgr, query.Table())
+	if err := sampler.Sample(context.Background()); err != nil {
+		return errors.WithStack(err)
+	}
+	estimation, err := sampler.Estimate(queryText)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	fmt.Println(estimation)
+	if len(estimation.ShardNames) > 0 {
+		fmt.Printf("shards: %s\n", strings.Join(estimation.ShardNames, ","))
+	}
+	return nil
+}
+
+// Execute executes seed generate command
+// nolint: gocyclo
+func (cmd *SeedGenerateCommand) Execute(args []string) error {
+	if cmd.Rows <= 0 {
+		return errors.New("rows must be positive number")
+	}
+	if err := octillery.LoadConfig(cmd.Config); err != nil {
+		return errors.WithStack(err)
+	}
+	cfg, err := config.Get()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	tableConfig, exists := cfg.Tables[cmd.Table]
+	if !exists {
+		return errors.Errorf("cannot find table name %s in configuration file", cmd.Table)
+	}
+	mgr, err := connection.NewConnectionManager()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer mgr.Close()
+	conn, err := mgr.ConnectionByTableName(cmd.Table)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	schema, err := cmd.schema(mgr)
+	if err != nil {
+		return errors.Wrapf(err, "cannot get schema. table is %s", cmd.Table)
+	}
+	randSeed := cmd.RandSeed
+	if randSeed == 0 {
+		randSeed = time.Now().UnixNano()
+	}
+	generator := &seedGenerator{
+		rand:         rand.New(rand.NewSource(randSeed)),
+		rows:         cmd.Rows,
+		shardKeyName: cfg.ShardKeyColumnName(cmd.Table),
+	}
+	if tableConfig.IsUsedSequencer() {
+		generator.sequencerName = tableConfig.ShardColumnName
+	}
+
+	columns := []string{}
+	valueTexts := []string{}
+	generators := []func() interface{}{}
+	shardKeyIndex := -1
+	for _, column := range schema.Columns {
+		if column.Name == generator.sequencerName {
+			// id is published by sequencer
+			columns = append(columns, fmt.Sprintf("`%s`", column.Name))
+			valueTexts = append(valueTexts, "null")
+			continue
+		}
+		if isAutoIncrementColumn(column) {
+			continue
+		}
+		gen, err := generator.valueGenerator(column)
+		if err != nil {
+			return errors.Wrapf(err, "cannot generate value of %s.%s", cmd.Table, column.Name)
+		}
+		if column.Name == generator.shardKeyName {
+			shardKeyIndex = len(generators)
+		}
+		columns = append(columns, fmt.Sprintf("`%s`", column.Name))
+		valueTexts = append(valueTexts, "?")
+		generators = append(generators, gen)
+	}
+	db, err := sql.Open("", "?parseTime=true")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer db.Close()
+
+	queryText := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", cmd.Table, strings.Join(columns, ","), strings.Join(valueTexts, ","))
+	distribution := map[string]int{}
+	for i := 0; i < cmd.Rows; i++ {
+		values := make([]interface{}, len(generators))
+		for idx, gen := range generators {
+			values[idx] = gen()
+		}
+		result, err := db.Exec(queryText, values...)
+		if err != nil {
+			return errors.Wrapf(err, "cannot insert [%s]:%v", queryText, values)
+		}
+		if !conn.IsShard {
+			distribution[cmd.Table]++
+			continue
+		}
+		var shardKey int64
+		if shardKeyIndex >= 0 {
+			shardKey, _ = values[shardKeyIndex].(int64)
+		} else if shardKey, err = result.LastInsertId(); err != nil {
+			return errors.WithStack(err)
+		}
+		shardConn, err := conn.ShardConnectionByID(shardKey)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		distribution[shardConn.ShardName]++
+	}
+	names := []string{}
+	for name := range distribution {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s: %d rows\n", name, distribution[name])
+	}
+	return nil
+}
+
+func (cmd *SeedGenerateCommand) schema(mgr *connection.DBConnectionManager) (*vtparser.CreateTable, error) {
+	if cmd.SchemaPath != "" {
+		query, err := migrator.TableSchema(cmd.SchemaPath, cmd.Table)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return query.Stmt.(*vtparser.CreateTable), nil
+	}
+	schema, err := mgr.SchemaCache().Schema(cmd.Table)
+	if errors.Cause(err) == connection.ErrSchemaNotSupported {
+		return nil, errors.New("adapter doesn't support reading schema. specify schema file by --schema option")
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return schema.Stmt, nil
+}
+
+func isAutoIncrementColumn(column *vtparser.ColumnDef) bool {
+	for _, option := range column.Options {
+		if option.Type == vtparser.ColumnOptionAutoIncrement {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	columnLengthPattern = regexp.MustCompile(`\((\d+)`)
+	seedWords           = []string{
+		"alice", "bob", "carol", "dave", "ellen", "frank", "grace", "heidi",
+		"ivan", "judy", "mallory", "oscar", "peggy", "trent", "victor", "walter",
+	}
+)
+
+type seedGenerator struct {
+	rand          *rand.Rand
+	rows          int
+	shardKeyName  string
+	sequencerName string
+}
+
+// nolint: gocyclo
+func (g *seedGenerator) valueGenerator(column *vtparser.ColumnDef) (func() interface{}, error) {
+	typ := convertMySQLTypeToGOType(column.Type)
+	maxLength := 0
+	if matches := columnLengthPattern.FindStringSubmatch(column.Type); len(matches) > 1 {
+		maxLength, _ = strconv.Atoi(matches[1])
+	}
+	switch typ {
+	case GoInt, GoUint:
+		if column.Name == g.shardKeyName {
+			// use same range as number of rows for routing rows across shards by the real router
+			return func() interface{} { return g.rand.Int63n(int64(g.rows)) + 1 }, nil
+		}
+		max := int64(1000000)
+		if strings.Contains(strings.ToLower(column.Type), "tinyint") {
+			max = 100
+		}
+		return func() interface{} { return g.rand.Int63n(max) }, nil
+	case GoFloat:
+		// float value is passed as string because it cannot be embedded into query for shards
+		return func() interface{} { return strconv.FormatFloat(g.rand.Float64()*1000, 'f', 2, 64) }, nil
+	case GoString:
+		if len(column.Elems) > 0 {
+			return func() interface{} { return strings.Trim(column.Elems[g.rand.Intn(len(column.Elems))], "'") }, nil
+		}
+		return func() interface{} {
+			value := fmt.Sprintf("%s_%d", seedWords[g.rand.Intn(len(seedWords))], g.rand.Intn(g.rows))
+			if maxLength > 0 && len(value) > maxLength {
+				value = value[:maxLength]
+			}
+			return value
+		}, nil
+	case GoBytes:
+		return func() interface{} {
+			value := make([]byte, 16)
+			g.rand.Read(value)
+			return hex.EncodeToString(value)
+		}, nil
+	case GoDateFormat, GoTimeFormat, GoDateTimeFormat, GoTimeStampFormat, GoYearFormat:
+		now := time.Now()
+		return func() interface{} {
+			return now.Add(-time.Duration(g.rand.Int63n(int64(365 * 24 * time.Hour)))).Truncate(time.Second)
+		}, nil
+	}
+	return nil, errors.Errorf("cannot map %s to Go type", column.Type)
+}
+
 func main() {
 	parser := flags.NewParser(&opts, flags.Default)
 	parser.Parse()
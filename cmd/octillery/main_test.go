@@ -0,0 +1,401 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"go.knocknote.io/octillery"
+	"go.knocknote.io/octillery/connection"
+)
+
+const testConfig = `
+default: &default
+  adapter: sqlite3
+
+tables:
+  users:
+    shard: true
+    shard_column: id
+    sequencer:
+      <<: *default
+      database: %[1]s/user_seq.bin
+    shards:
+      - user_shard_1:
+          <<: *default
+          database: %[1]s/user_shard_1.bin
+      - user_shard_2:
+          <<: *default
+          database: %[1]s/user_shard_2.bin
+  user_items:
+    shard: true
+    shard_key: user_id
+    shards:
+      - user_item_shard_1:
+          <<: *default
+          database: %[1]s/user_item_shard_1.bin
+      - user_item_shard_2:
+          <<: *default
+          database: %[1]s/user_item_shard_2.bin
+  user_stages:
+    <<: *default
+    database: %[1]s/user_stage.bin
+`
+
+var testSchemas = map[string]string{
+	"users":       "create table users (id integer not null primary key, name varchar(255) not null, age int not null)",
+	"user_items":  "create table user_items (id integer not null primary key, user_id int not null, name text)",
+	"user_stages": "create table user_stages (id integer primary key autoincrement, name varchar(8))",
+}
+
+func checkErr(t *testing.T, err error) {
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+// setup creates tables of testConfig in temporary directory and returns path to configuration file
+func setup(t *testing.T) (string, *connection.DBConnectionManager, func()) {
+	dir, err := ioutil.TempDir("", "octillery_cmd")
+	checkErr(t, err)
+	confPath := filepath.Join(dir, "databases.yml")
+	checkErr(t, ioutil.WriteFile(confPath, []byte(fmt.Sprintf(testConfig, dir)), 0644))
+	checkErr(t, octillery.LoadConfig(confPath))
+	mgr, err := connection.NewConnectionManager()
+	checkErr(t, err)
+	for tableName, schema := range testSchemas {
+		checkErr(t, mgr.ForEachShard(tableName, func(shard *connection.DBShardConnection) error {
+			_, err := shard.Connection.Exec(schema)
+			return err
+		}, nil))
+	}
+	return confPath, mgr, func() {
+		mgr.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// captureStdout returns text written to standard output by fn
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	r, w, err := os.Pipe()
+	checkErr(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		content, _ := ioutil.ReadAll(r)
+		out <- string(content)
+	}()
+	err = fn()
+	os.Stdout = stdout
+	w.Close()
+	return <-out, err
+}
+
+// rowCounts returns number of rows of table for each shard ( or table name if it is not sharded )
+func rowCounts(t *testing.T, mgr *connection.DBConnectionManager, tableName string) map[string]int {
+	counts := map[string]int{}
+	checkErr(t, mgr.ForEachShard(tableName, func(shard *connection.DBShardConnection) error {
+		name := shard.ShardName
+		if name == "" {
+			name = tableName
+		}
+		var count int
+		if err := shard.Connection.QueryRow(fmt.Sprintf("select count(*) from %s", tableName)).Scan(&count); err != nil {
+			return err
+		}
+		counts[name] = count
+		return nil
+	}, nil))
+	return counts
+}
+
+// columnValues returns sorted values of column in all shards of table. NULL is returned as 'null'
+func columnValues(t *testing.T, mgr *connection.DBConnectionManager, tableName string, column string) []string {
+	values := []string{}
+	checkErr(t, mgr.ForEachShard(tableName, func(shard *connection.DBShardConnection) error {
+		rows, err := shard.Connection.Query(fmt.Sprintf("select %s from %s", column, tableName))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var value *string
+			if err := rows.Scan(&value); err != nil {
+				return err
+			}
+			if value == nil {
+				values = append(values, "null")
+				continue
+			}
+			values = append(values, *value)
+		}
+		return rows.Err()
+	}, nil))
+	sort.Strings(values)
+	return values
+}
+
+func TestSeedGenerate(t *testing.T) {
+	confPath, mgr, teardown := setup(t)
+	defer teardown()
+
+	t.Run("id published by sequencer", func(t *testing.T) {
+		cmd := &SeedGenerateCommand{Table: "users", Rows: 10, RandSeed: 1, Config: confPath}
+		out, err := captureStdout(t, func() error { return cmd.Execute(nil) })
+		checkErr(t, err)
+		if out != "user_shard_1: 5 rows\nuser_shard_2: 5 rows\n" {
+			t.Fatalf("invalid distribution %q", out)
+		}
+		counts := rowCounts(t, mgr, "users")
+		if counts["user_shard_1"] != 5 || counts["user_shard_2"] != 5 {
+			t.Fatalf("rows are not routed by id. %v", counts)
+		}
+	})
+	t.Run("rows routed by shard key", func(t *testing.T) {
+		cmd := &SeedGenerateCommand{Table: "user_items", Rows: 20, RandSeed: 1, Config: confPath}
+		out, err := captureStdout(t, func() error { return cmd.Execute(nil) })
+		checkErr(t, err)
+		counts := rowCounts(t, mgr, "user_items")
+		if counts["user_item_shard_1"]+counts["user_item_shard_2"] != 20 {
+			t.Fatalf("invalid number of rows %v", counts)
+		}
+		expected := fmt.Sprintf("user_item_shard_1: %d rows\nuser_item_shard_2: %d rows\n", counts["user_item_shard_1"], counts["user_item_shard_2"])
+		if out != expected {
+			t.Fatalf("distribution %q is different from rows of shards %q", out, expected)
+		}
+		checkErr(t, mgr.ForEachShard("user_items", func(shard *connection.DBShardConnection) error {
+			var invalidNum int
+			if err := shard.Connection.QueryRow("select count(*) from user_items where user_id < 1 or user_id > 20").Scan(&invalidNum); err != nil {
+				return err
+			}
+			if invalidNum > 0 {
+				t.Fatalf("shard key must be in range of number of rows")
+			}
+			return nil
+		}, nil))
+	})
+	t.Run("auto increment column of schema file", func(t *testing.T) {
+		schemaPath := filepath.Join(filepath.Dir(confPath), "user_stages.sql")
+		checkErr(t, ioutil.WriteFile(schemaPath, []byte("CREATE TABLE user_stages (id bigint unsigned NOT NULL AUTO_INCREMENT, name varchar(8) NOT NULL, PRIMARY KEY (id));"), 0644))
+		cmd := &SeedGenerateCommand{Table: "user_stages", Rows: 10, RandSeed: 1, SchemaPath: schemaPath, Config: confPath}
+		out, err := captureStdout(t, func() error { return cmd.Execute(nil) })
+		checkErr(t, err)
+		if out != "user_stages: 10 rows\n" {
+			t.Fatalf("invalid distribution %q", out)
+		}
+		checkErr(t, mgr.ForEachShard("user_stages", func(shard *connection.DBShardConnection) error {
+			var (
+				minID, maxID int
+				maxLength    int
+			)
+			if err := shard.Connection.QueryRow("select min(id), max(id), max(length(name)) from user_stages").Scan(&minID, &maxID, &maxLength); err != nil {
+				return err
+			}
+			if minID != 1 || maxID != 10 {
+				t.Fatalf("id must be published by database. ids are from %d to %d", minID, maxID)
+			}
+			if maxLength > 8 {
+				t.Fatalf("value must be truncated to length of column. length is %d", maxLength)
+			}
+			return nil
+		}, nil))
+	})
+	t.Run("same seed", func(t *testing.T) {
+		names := func() []string {
+			names := []string{}
+			checkErr(t, mgr.ForEachShard("user_stages", func(shard *connection.DBShardConnection) error {
+				rows, err := shard.Connection.Query("select name from user_stages order by id desc limit 10")
+				if err != nil {
+					return err
+				}
+				defer rows.Close()
+				for rows.Next() {
+					var name string
+					if err := rows.Scan(&name); err != nil {
+						return err
+					}
+					names = append(names, name)
+				}
+				return rows.Err()
+			}, nil))
+			return names
+		}
+		generated := names()
+		schemaPath := filepath.Join(filepath.Dir(confPath), "user_stages.sql")
+		cmd := &SeedGenerateCommand{Table: "user_stages", Rows: 10, RandSeed: 1, SchemaPath: schemaPath, Config: confPath}
+		_, err := captureStdout(t, func() error { return cmd.Execute(nil) })
+		checkErr(t, err)
+		if strings.Join(names(), ",") != strings.Join(generated, ",") {
+			t.Fatal("same rows must be generated by same seed")
+		}
+	})
+	t.Run("invalid options", func(t *testing.T) {
+		if err := (&SeedGenerateCommand{Table: "users", Rows: 0, Config: confPath}).Execute(nil); err == nil {
+			t.Fatal("cannot handle error of rows")
+		}
+		if err := (&SeedGenerateCommand{Table: "unknown", Rows: 1, Config: confPath}).Execute(nil); err == nil {
+			t.Fatal("cannot handle error of table name")
+		}
+	})
+}
+
+func TestImportDryRun(t *testing.T) {
+	confPath, mgr, teardown := setup(t)
+	defer teardown()
+
+	seedsPath := filepath.Join(filepath.Dir(confPath), "seeds")
+	checkErr(t, os.Mkdir(seedsPath, 0755))
+	seeds := map[string]string{
+		"users.csv":       "id,name,age\n1,alice,10\n2,bob,20\n3,carol,30\n",
+		"user_items.csv":  "id,user_id,name\n1,1,sword\n2,x,shield\n3,2,null\n",
+		"user_stages.csv": "id,title\n1,stage1\n",
+	}
+	for name, seed := range seeds {
+		checkErr(t, ioutil.WriteFile(filepath.Join(seedsPath, name), []byte(seed), 0644))
+	}
+	cmd := &ImportCommand{DryRun: true, Concurrency: 1, StateFile: filepath.Join(filepath.Dir(confPath), "state.json"), Config: confPath}
+	out, err := captureStdout(t, func() error { return cmd.Execute([]string{seedsPath}) })
+	if err == nil || !strings.Contains(err.Error(), "found invalid seeds for 2 tables") {
+		t.Fatalf("cannot handle invalid seeds: %v", err)
+	}
+	expected := strings.Join([]string{
+		"[user_items] invalid row at line 3: cannot convert x to int64. table:[user_items] column:[user_id]: strconv.ParseInt: parsing \"x\": invalid syntax",
+		"[user_items] 2 rows are valid, 1 rows are invalid",
+		"[user_items]   user_item_shard_1: 1 rows",
+		"[user_items]   user_item_shard_2: 1 rows",
+		"[user_stages] invalid header: cannot get Go type from column name title. table is user_stages",
+		"[users] 3 rows are valid, 0 rows are invalid",
+		"[users]   user_shard_1: 1 rows",
+		"[users]   user_shard_2: 2 rows",
+	}, "\n") + "\n"
+	if out != expected {
+		t.Fatalf("invalid output of dry-run %q", out)
+	}
+	for tableName := range seeds {
+		tableName = strings.TrimSuffix(tableName, ".csv")
+		for name, count := range rowCounts(t, mgr, tableName) {
+			if count != 0 {
+				t.Fatalf("rows are written to %s by dry-run", name)
+			}
+		}
+	}
+	if _, err := os.Stat(cmd.StateFile); !os.IsNotExist(err) {
+		t.Fatal("state file must not be created by dry-run")
+	}
+
+	t.Run("valid seeds", func(t *testing.T) {
+		checkErr(t, os.Remove(filepath.Join(seedsPath, "user_items.csv")))
+		checkErr(t, os.Remove(filepath.Join(seedsPath, "user_stages.csv")))
+		out, err := captureStdout(t, func() error { return cmd.Execute([]string{seedsPath}) })
+		checkErr(t, err)
+		if !strings.HasPrefix(out, "[users] 3 rows are valid, 0 rows are invalid\n") {
+			t.Fatalf("invalid output of dry-run %q", out)
+		}
+	})
+}
+
+func TestImport(t *testing.T) {
+	confPath, mgr, teardown := setup(t)
+	defer teardown()
+
+	dir := filepath.Dir(confPath)
+	writeSeeds := func(name string, seeds map[string]string) string {
+		seedsPath := filepath.Join(dir, name)
+		checkErr(t, os.Mkdir(seedsPath, 0755))
+		for name, seed := range seeds {
+			checkErr(t, ioutil.WriteFile(filepath.Join(seedsPath, name), []byte(seed), 0644))
+		}
+		return seedsPath
+	}
+	newCommand := func() *ImportCommand {
+		return &ImportCommand{NoTruncate: true, Concurrency: 4, StateFile: filepath.Join(dir, "state.json"), Config: confPath}
+	}
+	t.Run("seeds of tables", func(t *testing.T) {
+		seedsPath := writeSeeds("seeds", map[string]string{
+			"users.jsonl":     `{"id": 1, "name": "alice", "age": 10}` + "\n" + `{"id": 2, "name": "bob", "age": 20}` + "\n",
+			"user_items.sql":  "INSERT INTO `user_items` (`id`, `user_id`, `name`) VALUES (1, 1, 'sword'), (2, 2, NULL);\nINSERT INTO user_items (id, user_id, name) VALUES (3, 2, 'shield');\n",
+			"user_stages.csv": "id,name\n1,stage1\n",
+		})
+		cmd := newCommand()
+		cmd.Tables = []string{"users", "user_items"}
+		_, err := captureStdout(t, func() error { return cmd.Execute([]string{seedsPath}) })
+		checkErr(t, err)
+		if counts := rowCounts(t, mgr, "users"); counts["user_shard_1"]+counts["user_shard_2"] != 2 {
+			t.Fatalf("cannot import JSON lines seed. %v", counts)
+		}
+		if counts := rowCounts(t, mgr, "user_items"); counts["user_item_shard_1"]+counts["user_item_shard_2"] != 3 {
+			t.Fatalf("cannot import SQL seed. %v", counts)
+		}
+		if counts := rowCounts(t, mgr, "user_stages"); counts["user_stages"] != 0 {
+			t.Fatal("seed of table not specified by --table must not be imported")
+		}
+		if names := columnValues(t, mgr, "users", "name"); strings.Join(names, ",") != "alice,bob" {
+			t.Fatalf("invalid rows of JSON lines seed %v", names)
+		}
+		if names := columnValues(t, mgr, "user_items", "name"); strings.Join(names, ",") != "null,shield,sword" {
+			t.Fatalf("invalid rows of SQL seed %v", names)
+		}
+		if _, err := os.Stat(cmd.StateFile); !os.IsNotExist(err) {
+			t.Fatal("state file must be removed after import succeeded")
+		}
+	})
+	t.Run("raw string of JSON lines", func(t *testing.T) {
+		seedsPath := writeSeeds("raw", map[string]string{
+			"user_stages.jsonl": `{"id": 1, "name": "a\\nb"}` + "\n",
+		})
+		_, err := captureStdout(t, func() error { return newCommand().Execute([]string{seedsPath}) })
+		checkErr(t, err)
+		if names := columnValues(t, mgr, "user_stages", "name"); len(names) != 1 || names[0] != `a\nb` {
+			t.Fatalf("escape sequence of JSON lines seed must not be interpreted again. %q", names)
+		}
+	})
+	t.Run("append rows by no truncate", func(t *testing.T) {
+		seedsPath := writeSeeds("append", map[string]string{
+			"users.csv": "id,name,age\n3,carol,30\n4,dave,40\n",
+		})
+		_, err := captureStdout(t, func() error { return newCommand().Execute([]string{seedsPath}) })
+		checkErr(t, err)
+		if counts := rowCounts(t, mgr, "users"); counts["user_shard_1"]+counts["user_shard_2"] != 4 {
+			t.Fatalf("rows must be appended to table. %v", counts)
+		}
+	})
+	t.Run("invalid seeds", func(t *testing.T) {
+		for name, seeds := range map[string]map[string]string{
+			"duplicated seeds": {
+				"users.csv":   "id,name,age\n5,ellen,50\n",
+				"users.jsonl": `{"id": 6, "name": "frank", "age": 60}` + "\n",
+			},
+			"statement other than INSERT": {
+				"users.sql": "INSERT INTO users (id, name, age) VALUES (5, 'ellen', 50);\nDELETE FROM users;\n",
+			},
+			"unknown table": {
+				"user_decks.csv": "id,user_id\n1,1\n",
+			},
+		} {
+			seedsPath := writeSeeds(strings.Replace(name, " ", "_", -1), seeds)
+			if _, err := captureStdout(t, func() error { return newCommand().Execute([]string{seedsPath}) }); err == nil {
+				t.Fatalf("cannot handle error of %s", name)
+			}
+		}
+		if counts := rowCounts(t, mgr, "users"); counts["user_shard_1"]+counts["user_shard_2"] != 4 {
+			t.Fatalf("rows must not be imported from invalid seeds. %v", counts)
+		}
+	})
+	t.Run("invalid options", func(t *testing.T) {
+		cmd := newCommand()
+		cmd.Tables = []string{"user_decks"}
+		if err := cmd.Execute([]string{dir}); err == nil {
+			t.Fatal("cannot handle error of --table")
+		}
+		cmd = newCommand()
+		cmd.Concurrency = 0
+		if err := cmd.Execute([]string{dir}); err == nil {
+			t.Fatal("cannot handle error of --concurrency")
+		}
+	})
+}
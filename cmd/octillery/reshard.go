@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery"
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/connection"
+	"go.knocknote.io/octillery/reshard"
+)
+
+func (cmd *ReshardCommand) tableNames(cfg *config.Config) ([]string, error) {
+	if len(cmd.Tables) > 0 {
+		for _, tableName := range cmd.Tables {
+			if !cfg.IsShardTable(tableName) {
+				return nil, errors.Errorf("%s is not sharded table", tableName)
+			}
+		}
+		return cmd.Tables, nil
+	}
+	tableNames := []string{}
+	for tableName := range cfg.Tables {
+		if cfg.IsShardTable(tableName) {
+			tableNames = append(tableNames, tableName)
+		}
+	}
+	sort.Strings(tableNames)
+	return tableNames, nil
+}
+
+// Execute executes reshard command
+func (cmd *ReshardCommand) Execute(args []string) error {
+	if err := octillery.LoadConfig(cmd.Config); err != nil {
+		return errors.WithStack(err)
+	}
+	cfg, err := config.Get()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	tableNames, err := cmd.tableNames(cfg)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	mgr, err := connection.NewConnectionManager()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer mgr.Close()
+
+	ctx := context.Background()
+	planner := reshard.NewPlanner(mgr)
+	executor := reshard.NewExecutor(mgr)
+	executor.BatchSize = cmd.BatchSize
+	executor.Progress = func(move *reshard.Move, movedRows int64) {
+		fmt.Printf("  %s -> %s: %d/%d rows\n", move.From, move.To, movedRows, move.Rows)
+	}
+	for _, tableName := range tableNames {
+		plan, err := planner.Plan(ctx, tableName)
+		if err != nil {
+			return errors.Wrapf(err, "cannot plan resharding of %s", tableName)
+		}
+		fmt.Println(plan)
+		if cmd.DryRun || len(plan.Moves) == 0 {
+			continue
+		}
+		result, err := executor.Execute(ctx, plan)
+		if err != nil {
+			return errors.Wrapf(err, "cannot reshard %s. run reshard again to resume it", tableName)
+		}
+		if err := executor.Verify(ctx, plan); err != nil {
+			return errors.Wrapf(err, "cannot verify resharding of %s", tableName)
+		}
+		fmt.Printf("%s: moved %d rows by %d batches\n", tableName, result.MovedRows, result.Batches)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery"
+	"go.knocknote.io/octillery/connection"
+	"go.knocknote.io/octillery/stats"
+)
+
+// Execute executes stats command.
+// Rows of each shard are counted by `SELECT COUNT(*)`, and shards whose ratio to average rows exceeds threshold are marked as hot.
+func (cmd *StatsCommand) Execute(args []string) error {
+	if cmd.Threshold <= 1 {
+		return errors.New("threshold must be greater than 1")
+	}
+	if err := octillery.LoadConfig(cmd.Config); err != nil {
+		return errors.WithStack(err)
+	}
+	mgr, err := connection.NewConnectionManager()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer mgr.Close()
+	distributions, err := stats.Distributions(context.Background(), mgr, &stats.DistributionOptions{
+		WithShardKeyRange: cmd.ShardKeyRange,
+		Concurrency:       cmd.Concurrency,
+	}, cmd.Tables...)
+	for _, distribution := range distributions {
+		fmt.Println(distribution)
+		for _, shard := range distribution.HotShards(cmd.Threshold) {
+			fmt.Printf("  hot shard: %s has %.2f times rows of average\n", shard.ShardName, shard.Ratio)
+		}
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
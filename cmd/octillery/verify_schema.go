@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery"
+)
+
+// Execute executes verify-schema command.
+// Schema of each shard is fetched by adapter ( e.g. SHOW CREATE TABLE ), and drift from the others is printed.
+func (cmd *VerifySchemaCommand) Execute(args []string) error {
+	if err := octillery.LoadConfig(cmd.Config); err != nil {
+		return errors.WithStack(err)
+	}
+	drifts, err := octillery.SchemaDrifts(cmd.Tables...)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if len(drifts) == 0 {
+		fmt.Println("all shards have identical schema")
+		return nil
+	}
+	for _, drift := range drifts {
+		fmt.Println(drift)
+	}
+	return errors.Errorf("schema drift is found in %d tables", len(drifts))
+}
@@ -0,0 +1,228 @@
+// Package compat provides conformance tests of behaviors compatible with 'database/sql' package.
+//
+// Applications can run them against their configured DB with their actual adapters and topology before rollout.
+//
+//	func TestCompatibility(t *testing.T) {
+//		db, _ := sql.Open("mysql", "")
+//		compat.Run(t, &compat.Config{
+//			DB:          db,
+//			Table:       "users",
+//			InsertQuery: "INSERT INTO users(id, name) VALUES (NULL, ?)",
+//			InsertArgs:  []interface{}{"compat"},
+//		})
+//	}
+//
+// Errors returned by octillery have stack trace, so they are compared with errors.Cause of 'github.com/pkg/errors'.
+//
+// Also, Analyze reports how queries of application are routed to shards without accessing databases ( used by `octillery compat-report` ).
+package compat
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/database/sql"
+)
+
+// DefaultIDColumn default column of id returned by LastInsertId
+const DefaultIDColumn = "id"
+
+// Config target of conformance tests
+type Config struct {
+	// DB opened by octillery
+	DB *sql.DB
+	// table that rows are inserted to. rows inserted by tests are deleted after them
+	Table string
+	// column of id returned by LastInsertId. if empty, DefaultIDColumn is used
+	IDColumn string
+	// INSERT query inserts a row to Table
+	InsertQuery string
+	// arguments of InsertQuery
+	InsertArgs []interface{}
+}
+
+type suite struct {
+	cfg         Config
+	insertedIDs []int64
+}
+
+// Run runs all conformance tests as subtests of t
+func Run(t *testing.T, cfg *Config) {
+	if cfg == nil || cfg.DB == nil || cfg.Table == "" || cfg.InsertQuery == "" {
+		t.Fatal("DB, Table and InsertQuery are required to run conformance tests")
+	}
+	s := &suite{cfg: *cfg}
+	if s.cfg.IDColumn == "" {
+		s.cfg.IDColumn = DefaultIDColumn
+	}
+	defer s.cleanup(t)
+	t.Run("LastInsertId", s.testLastInsertID)
+	t.Run("ErrNoRows", s.testErrNoRows)
+	t.Run("Rows", s.testRows)
+	t.Run("ErrTxDone", s.testErrTxDone)
+	t.Run("Rollback", s.testRollback)
+}
+
+func (s *suite) selectByIDQuery() string {
+	return fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?", s.cfg.IDColumn, s.cfg.Table, s.cfg.IDColumn)
+}
+
+// insert inserts a row and returns id of it
+func (s *suite) insert(t *testing.T, exec func(string, ...interface{}) (sql.Result, error)) int64 {
+	result, err := exec(s.cfg.InsertQuery, s.cfg.InsertArgs...)
+	if err != nil {
+		t.Fatalf("cannot insert row: %+v", err)
+	}
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		t.Fatalf("RowsAffected must not return error: %+v", err)
+	}
+	if affectedRows != 1 {
+		t.Fatalf("RowsAffected must be 1. but got %d", affectedRows)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		t.Fatalf("LastInsertId must not return error: %+v", err)
+	}
+	if id <= 0 {
+		t.Fatalf("LastInsertId must be positive. but got %d", id)
+	}
+	s.insertedIDs = append(s.insertedIDs, id)
+	return id
+}
+
+func (s *suite) cleanup(t *testing.T) {
+	for _, id := range s.insertedIDs {
+		query := fmt.Sprintf("DELETE FROM %s WHERE %s = ?", s.cfg.Table, s.cfg.IDColumn)
+		if _, err := s.cfg.DB.Exec(query, id); err != nil {
+			t.Errorf("cannot delete row inserted by conformance tests: %+v", err)
+		}
+	}
+}
+
+func (s *suite) testLastInsertID(t *testing.T) {
+	id := s.insert(t, s.cfg.DB.Exec)
+	var found int64
+	if err := s.cfg.DB.QueryRow(s.selectByIDQuery(), id).Scan(&found); err != nil {
+		t.Fatalf("cannot find row by LastInsertId: %+v", err)
+	}
+	if found != id {
+		t.Fatalf("found %d by LastInsertId %d", found, id)
+	}
+	if nextID := s.insert(t, s.cfg.DB.Exec); nextID == id {
+		t.Fatalf("LastInsertId must be different for each row. but got %d twice", id)
+	}
+}
+
+func (s *suite) testErrNoRows(t *testing.T) {
+	var id int64
+	err := s.cfg.DB.QueryRow(s.selectByIDQuery(), int64(math.MaxInt32)).Scan(&id)
+	if errors.Cause(err) != sql.ErrNoRows {
+		t.Fatalf("Row.Scan must return ErrNoRows if row is not found. but got %+v", err)
+	}
+}
+
+func (s *suite) testRows(t *testing.T) {
+	id := s.insert(t, s.cfg.DB.Exec)
+	rows, err := s.cfg.DB.Query(s.selectByIDQuery(), id)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		t.Fatalf("Columns must not return error: %+v", err)
+	}
+	if len(columns) != 1 {
+		t.Fatalf("Columns must return a selected column. but got %v", columns)
+	}
+	if !rows.Next() {
+		t.Fatalf("Next must return true for inserted row: %+v", rows.Err())
+	}
+	var found int64
+	if err := rows.Scan(&found); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if found != id {
+		t.Fatalf("found %d by id %d", found, id)
+	}
+	if rows.Next() {
+		t.Fatal("Next must return false after the last row")
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("Err must return nil after the last row: %+v", err)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatalf("Close must be idempotent: %+v", err)
+	}
+	if rows.Next() {
+		t.Fatal("Next must return false after Close")
+	}
+	if err := rows.Scan(&found); err == nil {
+		t.Fatal("Scan must return error after Close")
+	}
+
+	empty, err := s.cfg.DB.Query(s.selectByIDQuery(), int64(math.MaxInt32))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer empty.Close()
+	if empty.Next() {
+		t.Fatal("Next must return false for empty result")
+	}
+	if err := empty.Err(); err != nil {
+		t.Fatalf("Err must return nil for empty result: %+v", err)
+	}
+}
+
+func (s *suite) testErrTxDone(t *testing.T) {
+	tx, err := s.cfg.DB.Begin()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	id := s.insert(t, tx.Exec)
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := tx.Commit(); errors.Cause(err) != sql.ErrTxDone {
+		t.Fatalf("Commit must return ErrTxDone after Commit. but got %+v", err)
+	}
+	if err := tx.Rollback(); errors.Cause(err) != sql.ErrTxDone {
+		t.Fatalf("Rollback must return ErrTxDone after Commit. but got %+v", err)
+	}
+	if _, err := tx.Exec(s.cfg.InsertQuery, s.cfg.InsertArgs...); errors.Cause(err) != sql.ErrTxDone {
+		t.Fatalf("Exec must return ErrTxDone after Commit. but got %+v", err)
+	}
+	if _, err := tx.Query(s.selectByIDQuery(), id); errors.Cause(err) != sql.ErrTxDone {
+		t.Fatalf("Query must return ErrTxDone after Commit. but got %+v", err)
+	}
+	var found int64
+	if err := tx.QueryRow(s.selectByIDQuery(), id).Scan(&found); errors.Cause(err) != sql.ErrTxDone {
+		t.Fatalf("QueryRow must return ErrTxDone after Commit. but got %+v", err)
+	}
+	if err := s.cfg.DB.QueryRow(s.selectByIDQuery(), id).Scan(&found); err != nil {
+		t.Fatalf("row inserted by committed transaction is not found: %+v", err)
+	}
+}
+
+func (s *suite) testRollback(t *testing.T) {
+	tx, err := s.cfg.DB.Begin()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	id := s.insert(t, tx.Exec)
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := tx.Commit(); errors.Cause(err) != sql.ErrTxDone {
+		t.Fatalf("Commit must return ErrTxDone after Rollback. but got %+v", err)
+	}
+	var found int64
+	if err := s.cfg.DB.QueryRow(s.selectByIDQuery(), id).Scan(&found); errors.Cause(err) != sql.ErrNoRows {
+		t.Fatalf("row inserted by rolled back transaction must not be found. but got %+v", err)
+	}
+}
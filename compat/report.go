@@ -0,0 +1,285 @@
+package compat
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+// Route how query is routed to shards
+type Route string
+
+const (
+	// RouteSingleShard query is executed on a shard ( or not sharded database )
+	RouteSingleShard Route = "single_shard"
+	// RouteScatter query is executed on multiple shards and results are merged
+	RouteScatter Route = "scatter"
+	// RouteUnsupported query cannot be executed by octillery
+	RouteUnsupported Route = "unsupported"
+)
+
+// Routes all routes in order of report
+var Routes = []Route{RouteSingleShard, RouteScatter, RouteUnsupported}
+
+// QueryReport route of a query in corpus
+type QueryReport struct {
+	// number of line query starts at. 1 origin
+	Line int
+	// query text
+	Query string
+	// table name of query. empty if query cannot be parsed
+	Table string
+	// route of query
+	Route Route
+	// why query is routed so
+	Reason string
+}
+
+// Report routes of queries in corpus
+type Report struct {
+	Queries []*QueryReport
+}
+
+// Count returns number of queries routed by route
+func (r *Report) Count(route Route) int {
+	count := 0
+	for _, query := range r.Queries {
+		if query.Route == route {
+			count++
+		}
+	}
+	return count
+}
+
+// Reasons returns number of queries for each reason of route in order of number of queries
+func (r *Report) Reasons(route Route) []*ReasonCount {
+	countByReason := map[string]int{}
+	for _, query := range r.Queries {
+		if query.Route == route {
+			countByReason[query.Reason]++
+		}
+	}
+	reasons := make([]*ReasonCount, 0, len(countByReason))
+	for reason, count := range countByReason {
+		reasons = append(reasons, &ReasonCount{Reason: reason, Count: count})
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if reasons[i].Count != reasons[j].Count {
+			return reasons[i].Count > reasons[j].Count
+		}
+		return reasons[i].Reason < reasons[j].Reason
+	})
+	return reasons
+}
+
+// ReasonCount number of queries routed by the same reason
+type ReasonCount struct {
+	Reason string
+	Count  int
+}
+
+// String returns summary of report
+func (r *Report) String() string {
+	lines := []string{fmt.Sprintf("%d queries", len(r.Queries))}
+	for _, route := range Routes {
+		count := r.Count(route)
+		percentage := 0.0
+		if len(r.Queries) > 0 {
+			percentage = float64(count) * 100 / float64(len(r.Queries))
+		}
+		lines = append(lines, fmt.Sprintf("  %s: %d ( %.1f%% )", route, count, percentage))
+		for _, reason := range r.Reasons(route) {
+			lines = append(lines, fmt.Sprintf("    %s: %d", reason.Reason, reason.Count))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// corpusQuery query read from corpus with line number
+type corpusQuery struct {
+	line int
+	text string
+}
+
+// readCorpus reads queries separated by semicolon. lines start with '--' are ignored
+func readCorpus(r io.Reader) ([]*corpusQuery, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	queries := []*corpusQuery{}
+	current := []string{}
+	startLine := 0
+	for idx, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		for {
+			semicolon := strings.Index(line, ";")
+			part := line
+			if semicolon >= 0 {
+				part = line[:semicolon]
+			}
+			if len(current) == 0 {
+				startLine = idx + 1
+			}
+			if len(current) > 0 || strings.TrimSpace(part) != "" {
+				current = append(current, part)
+			}
+			if semicolon < 0 {
+				break
+			}
+			if text := strings.TrimSpace(strings.Join(current, "\n")); text != "" {
+				queries = append(queries, &corpusQuery{line: startLine, text: text})
+			}
+			current = []string{}
+			line = line[semicolon+1:]
+		}
+	}
+	if text := strings.TrimSpace(strings.Join(current, "\n")); text != "" {
+		queries = append(queries, &corpusQuery{line: startLine, text: text})
+	}
+	return queries, nil
+}
+
+// AnalyzeReader reads queries separated by semicolon from r and analyzes them by Analyze.
+// Line of each QueryReport is the line query starts at in r.
+func AnalyzeReader(r io.Reader) (*Report, error) {
+	queries, err := readCorpus(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	report, err := analyze(queries)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return report, nil
+}
+
+// Analyze parses queries of application and reports which are routed to a shard, which are scattered to shards
+// and which are unsupported ( e.g. JOIN between tables on different shards, subquery ) by configuration loaded by config.Load.
+// Databases are never accessed, so it can be used for estimating effort of migration before adopting octillery.
+// Line of each QueryReport is index of queries ( 1 origin ).
+func Analyze(queries []string) (*Report, error) {
+	corpus := make([]*corpusQuery, 0, len(queries))
+	for idx, query := range queries {
+		corpus = append(corpus, &corpusQuery{line: idx + 1, text: query})
+	}
+	report, err := analyze(corpus)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return report, nil
+}
+
+func analyze(queries []*corpusQuery) (*Report, error) {
+	cfg, err := config.Get()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	parser, err := sqlparser.New()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	report := &Report{}
+	for _, query := range queries {
+		queryReport := &QueryReport{Line: query.line, Query: query.text}
+		parsed, err := parser.Parse(query.text)
+		if err != nil {
+			queryReport.Route = RouteUnsupported
+			queryReport.Reason = unsupportedReason(err)
+		} else {
+			queryReport.Table = parsed.Table()
+			queryReport.Route, queryReport.Reason = route(cfg, parsed)
+		}
+		report.Queries = append(report.Queries, queryReport)
+	}
+	return report, nil
+}
+
+// unsupportedReason returns reason of parse error without stack trace and position of query
+func unsupportedReason(err error) string {
+	reason := errors.Cause(err).Error()
+	if idx := strings.Index(reason, " at position"); idx > 0 {
+		reason = reason[:idx]
+	}
+	return strings.TrimPrefix(reason, "parse error. ")
+}
+
+// nolint: gocyclo
+func route(cfg *config.Config, query sqlparser.Query) (Route, string) {
+	tableName := query.Table()
+	if tableName == "" {
+		return RouteSingleShard, "not routed by table"
+	}
+	table, exists := cfg.Tables[tableName]
+	if !exists {
+		return RouteUnsupported, "table is not defined in configuration"
+	}
+	if !table.IsShard {
+		return RouteSingleShard, "table is not sharded"
+	}
+	var queryBase *sqlparser.QueryBase
+	switch q := query.(type) {
+	case *sqlparser.QueryBase:
+		queryBase = q
+	case *sqlparser.InsertQuery:
+		queryBase = q.QueryBase
+	case *sqlparser.DeleteQuery:
+		queryBase = q.QueryBase
+	default:
+		return RouteSingleShard, "not routed by table"
+	}
+	if hasSubquery(queryBase.Stmt) {
+		return RouteUnsupported, "subquery is executed on each shard independently"
+	}
+	switch query.QueryType() {
+	case sqlparser.CreateTable, sqlparser.Drop, sqlparser.TruncateTable, sqlparser.Show:
+		return RouteScatter, fmt.Sprintf("%s is executed on all shards", query.QueryType())
+	case sqlparser.Insert:
+		insertQuery := query.(*sqlparser.InsertQuery)
+		if len(insertQuery.RowQueries) > 0 && insertQuery.IsNotFoundShardKeyID() {
+			return RouteScatter, "rows may be inserted to different shards"
+		}
+		return RouteSingleShard, "routed by shard_key"
+	}
+	if queryBase.ShardKeyMove != nil {
+		return RouteScatter, "UPDATE of shard_key moves rows between shards"
+	}
+	if !queryBase.IsNotFoundShardKeyID() || queryBase.ShardKeyIDPlaceholderIndex > 0 {
+		return RouteSingleShard, "routed by shard_key"
+	}
+	if queryBase.IsLockingRead() {
+		return RouteUnsupported, "locking read is rejected unless shard_key decides a single shard"
+	}
+	if len(queryBase.ShardKeyIDs) > 0 {
+		return RouteScatter, "routed to shards of values of IN clause"
+	}
+	if query.QueryType().IsWriteQuery() && cfg.AllShardWritePolicy == config.AllShardWritePolicyReject {
+		return RouteUnsupported, "write to all shards is rejected by all_shard_write_policy"
+	}
+	return RouteScatter, "shard_key is not found in WHERE clause"
+}
+
+// hasSubquery returns whether statement has subquery
+func hasSubquery(stmt vtparser.Statement) bool {
+	if stmt == nil {
+		return false
+	}
+	found := false
+	vtparser.Walk(func(node vtparser.SQLNode) (bool, error) {
+		if _, ok := node.(*vtparser.Subquery); ok {
+			found = true
+			return false, nil
+		}
+		return true, nil
+	}, stmt)
+	return found
+}
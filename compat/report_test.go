@@ -0,0 +1,89 @@
+package compat
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/path"
+)
+
+func TestAnalyze(t *testing.T) {
+	if _, err := config.Load(filepath.Join(path.ThisDirPath(), "..", "test_databases.yml")); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	corpus := `-- queries of application
+SELECT * FROM users WHERE id = ?;
+SELECT * FROM user_items WHERE user_id IN (1, 2, 3);
+SELECT * FROM users
+  WHERE name = 'bob';
+INSERT INTO users(id, name) VALUES (null, 'alice');
+UPDATE user_stages SET name = 'stage' WHERE id = 1;
+SELECT * FROM users WHERE id IN (SELECT user_id FROM user_items);
+SELECT * FROM users JOIN user_stages ON users.id = user_stages.user_id;
+SELECT * FROM unknown_table`
+	report, err := AnalyzeReader(strings.NewReader(corpus))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expected := []struct {
+		line  int
+		route Route
+	}{
+		{2, RouteSingleShard},
+		{3, RouteScatter},
+		{4, RouteScatter},
+		{6, RouteSingleShard},
+		{7, RouteSingleShard},
+		{8, RouteUnsupported},
+		{9, RouteUnsupported},
+		{10, RouteUnsupported},
+	}
+	if len(report.Queries) != len(expected) {
+		t.Fatalf("cannot split queries %d", len(report.Queries))
+	}
+	for idx, query := range report.Queries {
+		if query.Line != expected[idx].line || query.Route != expected[idx].route {
+			t.Fatalf("invalid report of %s. line = %d, route = %s, reason = %s", query.Query, query.Line, query.Route, query.Reason)
+		}
+	}
+	if report.Count(RouteSingleShard) != 3 || report.Count(RouteScatter) != 2 || report.Count(RouteUnsupported) != 3 {
+		t.Fatalf("cannot count queries by route\n%s", report)
+	}
+	reasons := report.Reasons(RouteScatter)
+	if len(reasons) != 2 || reasons[0].Count != 1 {
+		t.Fatalf("cannot count reasons %v", reasons)
+	}
+	if !strings.HasPrefix(report.String(), "8 queries\n  single_shard: 3 ( 37.5% )") {
+		t.Fatalf("invalid summary\n%s", report)
+	}
+
+	t.Run("reject write to all shards", func(t *testing.T) {
+		cfg, err := config.Get()
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		cfg.AllShardWritePolicy = config.AllShardWritePolicyReject
+		defer func() { cfg.AllShardWritePolicy = "" }()
+		report, err := Analyze([]string{"DELETE FROM users WHERE name = 'bob'"})
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if report.Queries[0].Route != RouteUnsupported || report.Queries[0].Line != 1 {
+			t.Fatalf("write to all shards must be unsupported %+v", report.Queries[0])
+		}
+	})
+	t.Run("locking read", func(t *testing.T) {
+		report, err := Analyze([]string{
+			"SELECT * FROM users WHERE id = 1 FOR UPDATE",
+			"SELECT * FROM users WHERE name = 'bob' FOR UPDATE",
+		})
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if report.Queries[0].Route != RouteSingleShard || report.Queries[1].Route != RouteUnsupported {
+			t.Fatalf("locking read without shard_key must be unsupported %+v", report.Queries[1])
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Feature capability compiled in binary ( e.g. 'join', 'adapter:mysql' ).
+// Configuration file can demand features by 'required_features', so binary lacking them fails at Load.
+type Feature string
+
+const (
+	// FeatureJoin JOIN query between tables on the same shard is supported
+	FeatureJoin Feature = "join"
+	// FeatureXA database adapter supporting XA transaction is compiled in
+	FeatureXA Feature = "xa"
+)
+
+// adapterFeaturePrefix prefix of feature for database adapter
+const adapterFeaturePrefix = "adapter:"
+
+// AdapterFeature returns feature reporting database adapter is compiled in ( e.g. 'adapter:mysql' )
+func AdapterFeature(adapterName string) Feature {
+	return Feature(adapterFeaturePrefix + adapterName)
+}
+
+var (
+	featuresMu sync.RWMutex
+	features   = map[Feature]struct{}{}
+)
+
+// RegisterFeature registers feature enabled in binary.
+// It is called by packages providing the feature ( e.g. database adapter ) at initialization.
+func RegisterFeature(feature Feature) {
+	featuresMu.Lock()
+	defer featuresMu.Unlock()
+	features[feature] = struct{}{}
+}
+
+// Features returns registered features sorted by name
+func Features() []Feature {
+	featuresMu.RLock()
+	defer featuresMu.RUnlock()
+	enabled := make([]Feature, 0, len(features))
+	for feature := range features {
+		enabled = append(enabled, feature)
+	}
+	sort.Slice(enabled, func(i, j int) bool { return enabled[i] < enabled[j] })
+	return enabled
+}
+
+// RequireFeatures returns error if some of required features are not registered.
+// Call this at startup for failing fast by binary built without capability application depends on.
+func RequireFeatures(required []Feature) error {
+	featuresMu.RLock()
+	defer featuresMu.RUnlock()
+	missing := []string{}
+	for _, feature := range required {
+		if _, exists := features[feature]; !exists {
+			missing = append(missing, string(feature))
+		}
+	}
+	if len(missing) > 0 {
+		return errors.Errorf("binary doesn't support required features [%s]", strings.Join(missing, ", "))
+	}
+	return nil
+}
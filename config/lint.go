@@ -0,0 +1,172 @@
+package config
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"go.knocknote.io/octillery/algorithm"
+)
+
+// LintCode the kind of risky setting found by Lint
+type LintCode string
+
+const (
+	// LintDistributedTransactionShards distributed_transaction is enabled for table that has many shards
+	LintDistributedTransactionShards LintCode = "distributed_transaction_shards"
+
+	// LintShardColumnWithoutSequencer table is routed by auto increment id, but it is not published by sequencer
+	LintShardColumnWithoutSequencer LintCode = "shard_column_without_sequencer"
+
+	// LintDuplicateDSN multiple shards of the same table are placed on the same database
+	LintDuplicateDSN LintCode = "duplicate_dsn"
+
+	// LintShardNum number of shards doesn't match expectations of sharding algorithm
+	LintShardNum LintCode = "shard_num"
+)
+
+// LintMaxDistributedTransactionShards number of shards that a transaction can access safely.
+// Failure of commit on any shard breaks atomicity of distributed transaction, so it becomes likely as shards increase.
+const LintMaxDistributedTransactionShards = 4
+
+// LintWarning a risky setting. It is valid configuration, but may cause problems in production.
+type LintWarning struct {
+	// kind of warning
+	Code LintCode
+	// table name
+	Table string
+	// human readable message
+	Message string
+}
+
+func (w *LintWarning) String() string {
+	return fmt.Sprintf("[%s] %s: %s", w.Code, w.Table, w.Message)
+}
+
+// Lint returns risky settings of configuration sorted by table name.
+func (c *Config) Lint() []*LintWarning {
+	tableNames := make([]string, 0, len(c.Tables))
+	for tableName := range c.Tables {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+	warnings := []*LintWarning{}
+	for _, tableName := range tableNames {
+		table := c.Tables[tableName]
+		if table == nil || !table.IsShard {
+			continue
+		}
+		warnings = append(warnings, c.lintDistributedTransaction(tableName, table)...)
+		warnings = append(warnings, lintShardColumnWithoutSequencer(tableName, table)...)
+		warnings = append(warnings, lintDuplicateDSN(tableName, table)...)
+		warnings = append(warnings, lintShardNum(tableName, table)...)
+	}
+	return warnings
+}
+
+func (c *Config) lintDistributedTransaction(tableName string, table *TableConfig) []*LintWarning {
+	if !c.DistributedTransaction {
+		return nil
+	}
+	if c.MaxTransactionShards > 0 && c.MaxTransactionShards <= LintMaxDistributedTransactionShards {
+		return nil
+	}
+	shardNum := len(table.PhysicalShards())
+	if shardNum <= LintMaxDistributedTransactionShards {
+		return nil
+	}
+	return []*LintWarning{{
+		Code:    LintDistributedTransactionShards,
+		Table:   tableName,
+		Message: fmt.Sprintf("distributed_transaction is enabled for %d shards. limit shards accessed by a transaction with max_transaction_shards ( <= %d )", shardNum, LintMaxDistributedTransactionShards),
+	}}
+}
+
+func lintShardColumnWithoutSequencer(tableName string, table *TableConfig) []*LintWarning {
+	if table.Sequencer != nil {
+		return nil
+	}
+	if table.ShardColumnName != "" {
+		return []*LintWarning{{
+			Code:    LintShardColumnWithoutSequencer,
+			Table:   tableName,
+			Message: fmt.Sprintf("shard_column %s is defined without sequencer. ids are not unique in all shards", table.ShardColumnName),
+		}}
+	}
+	if table.ShardKeyColumnName == table.IdentityColumn() {
+		return []*LintWarning{{
+			Code:    LintShardColumnWithoutSequencer,
+			Table:   tableName,
+			Message: fmt.Sprintf("shard_key %s is auto increment id without sequencer. it is unknown until row is inserted to a shard", table.ShardKeyColumnName),
+		}}
+	}
+	return nil
+}
+
+func lintDuplicateDSN(tableName string, table *TableConfig) []*LintWarning {
+	warnings := []*LintWarning{}
+	shardNameByDSN := map[string]string{}
+	for _, shard := range table.PhysicalShards() {
+		for shardName, cfg := range shard {
+			if cfg == nil {
+				continue
+			}
+			dsn := cfg.Adapter + ":" + cfg.NameOrPath
+			if len(cfg.Masters) > 0 {
+				dsn = fmt.Sprintf("%s:%s/%s", cfg.Adapter, cfg.Masters[0], cfg.NameOrPath)
+			}
+			if otherShardName, exists := shardNameByDSN[dsn]; exists {
+				warnings = append(warnings, &LintWarning{
+					Code:    LintDuplicateDSN,
+					Table:   tableName,
+					Message: fmt.Sprintf("%s and %s are placed on the same database", otherShardName, shardName),
+				})
+				continue
+			}
+			shardNameByDSN[dsn] = shardName
+		}
+	}
+	return warnings
+}
+
+// lintShardNum initializes sharding algorithm by dummy connections to verify number of shards
+func lintShardNum(tableName string, table *TableConfig) []*LintWarning {
+	if len(table.Shards) == 0 {
+		return []*LintWarning{{Code: LintShardNum, Table: tableName, Message: "shards are not defined"}}
+	}
+	warnings := []*LintWarning{}
+	logic, err := algorithm.LoadShardingAlgorithm(table.Algorithm)
+	if err != nil {
+		return append(warnings, &LintWarning{Code: LintShardNum, Table: tableName, Message: err.Error()})
+	}
+	if err := algorithm.InitShardingAlgorithm(logic, dummyConns(len(table.Shards)), table.AlgorithmConfig); err != nil {
+		warnings = append(warnings, &LintWarning{
+			Code:    LintShardNum,
+			Table:   tableName,
+			Message: fmt.Sprintf("%d shards are not acceptable for algorithm: %s", len(table.Shards), err),
+		})
+	}
+	for _, shard := range table.Shards {
+		for shardName, cfg := range shard {
+			if cfg == nil || !cfg.IsSplit() {
+				continue
+			}
+			if _, err := algorithm.NewSubShards(shardName, cfg.SubShardAlgorithm, cfg.SubShardAlgorithmConfig, dummyConns(len(cfg.SubShards))); err != nil {
+				warnings = append(warnings, &LintWarning{
+					Code:    LintShardNum,
+					Table:   tableName,
+					Message: fmt.Sprintf("%d sub-shards of %s are not acceptable for algorithm: %s", len(cfg.SubShards), shardName, err),
+				})
+			}
+		}
+	}
+	return warnings
+}
+
+func dummyConns(num int) []*sql.DB {
+	conns := make([]*sql.DB, num)
+	for idx := range conns {
+		conns[idx] = &sql.DB{}
+	}
+	return conns
+}
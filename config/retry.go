@@ -0,0 +1,96 @@
+package config
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// RetryOnDeadlock retries query failed by deadlock
+	RetryOnDeadlock = "deadlock"
+
+	// RetryOnLockWaitTimeout retries query failed by timeout of waiting for lock ( or busy database )
+	RetryOnLockWaitTimeout = "lock_wait_timeout"
+
+	// RetryOnConnection retries read query failed by broken connection ( e.g. connection reset )
+	RetryOnConnection = "connection"
+)
+
+const (
+	defaultRetryBackoff    = 50 * time.Millisecond
+	defaultRetryMaxBackoff = time.Second
+)
+
+// RetryConfig type for retry of queries failed by transient errors of shards.
+//
+//	retry:
+//	  max_retries: 3
+//	  backoff: 50ms
+//	  max_backoff: 1s
+//	  retry_on:
+//	    - deadlock
+//	    - connection
+type RetryConfig struct {
+	// max number of retries. 0 disables retry
+	MaxRetries int `yaml:"max_retries"`
+
+	// wait before first retry. it is doubled for each retry up to max_backoff ( default: 50ms )
+	Backoff time.Duration `yaml:"backoff"`
+
+	// max wait between retries ( default: 1s )
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+
+	// classes of errors to retry ( deadlock, lock_wait_timeout or connection ). default is all of them
+	RetryOn []string `yaml:"retry_on"`
+}
+
+// Error returns error of this retry configuration.
+func (c *RetryConfig) Error() error {
+	if c.MaxRetries < 0 {
+		return errors.Errorf("max_retries must not be negative. but got %d", c.MaxRetries)
+	}
+	if c.Backoff < 0 || c.MaxBackoff < 0 {
+		return errors.New("backoff and max_backoff must not be negative")
+	}
+	for _, class := range c.RetryOn {
+		switch class {
+		case RetryOnDeadlock, RetryOnLockWaitTimeout, RetryOnConnection:
+		default:
+			return errors.Errorf("unknown retry_on %s", class)
+		}
+	}
+	return nil
+}
+
+// IsRetryOn returns whether errors of class are retried
+func (c *RetryConfig) IsRetryOn(class string) bool {
+	if len(c.RetryOn) == 0 {
+		return class != ""
+	}
+	for _, retryOn := range c.RetryOn {
+		if retryOn == class {
+			return true
+		}
+	}
+	return false
+}
+
+// BackoffOf returns wait before n-th retry ( n starts from 1 )
+func (c *RetryConfig) BackoffOf(n int) time.Duration {
+	backoff := c.Backoff
+	if backoff == 0 {
+		backoff = defaultRetryBackoff
+	}
+	maxBackoff := c.MaxBackoff
+	if maxBackoff == 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+	for i := 1; i < n && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		return maxBackoff
+	}
+	return backoff
+}
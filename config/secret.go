@@ -0,0 +1,157 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// SecretResolver resolves secret referenced by configuration file ( e.g. fetch it from Vault or AWS Secrets Manager ).
+//
+// Secret is referenced by '${scheme:key}' in username, password, url and dsn ( master, slave, backup and endpoints ) of database,
+// and key is passed to resolver registered by the scheme.
+//
+//	config.RegisterSecretResolver("vault", config.SecretResolverFunc(func(key string) (string, error) {
+//		return fetchFromVault(key)
+//	}))
+//
+// and configure database like the following.
+//
+//	password: ${vault:secret/data/octillery#password}
+type SecretResolver interface {
+	Resolve(key string) (string, error)
+}
+
+// SecretResolverFunc type is an adapter to allow the use of ordinary function as SecretResolver.
+type SecretResolverFunc func(key string) (string, error)
+
+// Resolve calls f(key)
+func (f SecretResolverFunc) Resolve(key string) (string, error) {
+	return f(key)
+}
+
+var (
+	secretResolversMu sync.RWMutex
+	secretResolvers   = map[string]SecretResolver{
+		"file": SecretResolverFunc(resolveFileSecret),
+	}
+	secretReferencePattern = regexp.MustCompile(`\$\{([A-Za-z][A-Za-z0-9_\-]*):([^}]+)\}`)
+)
+
+// RegisterSecretResolver registers resolver by scheme of secret reference.
+// 'file' scheme is registered by default, it reads secret from file ( e.g. ${file:/run/secrets/db_password} ).
+// If resolver is nil or scheme is already registered, RegisterSecretResolver panics.
+func RegisterSecretResolver(scheme string, resolver SecretResolver) {
+	secretResolversMu.Lock()
+	defer secretResolversMu.Unlock()
+	if resolver == nil {
+		panic("config: RegisterSecretResolver resolver is nil")
+	}
+	if _, dup := secretResolvers[scheme]; dup {
+		panic("config: RegisterSecretResolver called twice for scheme " + scheme)
+	}
+	secretResolvers[scheme] = resolver
+}
+
+func resolveFileSecret(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// expandEnv replaces ${VAR} or $VAR in content by environment variable.
+// Secret references like ${scheme:key} are kept, they are resolved after configuration is parsed.
+func expandEnv(content string) string {
+	return os.Expand(content, func(name string) string {
+		if strings.Contains(name, ":") {
+			return fmt.Sprintf("${%s}", name)
+		}
+		return os.Getenv(name)
+	})
+}
+
+// secretResolution resolves secret references in a configuration. same secret is resolved only once.
+type secretResolution struct {
+	secrets map[string]string
+}
+
+func (r *secretResolution) resolve(value string) (string, error) {
+	var resolveErr error
+	resolved := secretReferencePattern.ReplaceAllStringFunc(value, func(reference string) string {
+		if resolveErr != nil {
+			return reference
+		}
+		if secret, exists := r.secrets[reference]; exists {
+			return secret
+		}
+		matched := secretReferencePattern.FindStringSubmatch(reference)
+		scheme, key := matched[1], matched[2]
+		secretResolversMu.RLock()
+		resolver, exists := secretResolvers[scheme]
+		secretResolversMu.RUnlock()
+		if !exists {
+			resolveErr = errors.Errorf("unknown secret resolver %s", scheme)
+			return reference
+		}
+		secret, err := resolver.Resolve(key)
+		if err != nil {
+			resolveErr = errors.Wrapf(err, "cannot resolve secret %s of %s", key, scheme)
+			return reference
+		}
+		r.secrets[reference] = secret
+		return secret
+	})
+	if resolveErr != nil {
+		return "", resolveErr
+	}
+	return resolved, nil
+}
+
+func (r *secretResolution) resolveValues(values []string) error {
+	for idx, value := range values {
+		resolved, err := r.resolve(value)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		values[idx] = resolved
+	}
+	return nil
+}
+
+func (r *secretResolution) resolveDatabase(db *DatabaseConfig) error {
+	var err error
+	if db.Username, err = r.resolve(db.Username); err != nil {
+		return errors.WithStack(err)
+	}
+	if db.Password, err = r.resolve(db.Password); err != nil {
+		return errors.WithStack(err)
+	}
+	if db.URL, err = r.resolve(db.URL); err != nil {
+		return errors.WithStack(err)
+	}
+	if db.ClusterEndpoint, err = r.resolve(db.ClusterEndpoint); err != nil {
+		return errors.WithStack(err)
+	}
+	if db.ReaderEndpoint, err = r.resolve(db.ReaderEndpoint); err != nil {
+		return errors.WithStack(err)
+	}
+	for _, values := range [][]string{db.Masters, db.Slaves, db.Backups} {
+		if err := r.resolveValues(values); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// resolveSecrets replaces secret references in databases of cfg by registered resolvers
+func resolveSecrets(cfg *Config) error {
+	r := &secretResolution{secrets: map[string]string{}}
+	return cfg.eachDatabase(r.resolveDatabase)
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/algorithm"
+)
+
+// WithTimeBucketShards returns copy of configuration that has shards for time buckets until the bucket includes 'at',
+// and names of appended shards. Shards are created from 'shard_template' of tables using 'time_range' algorithm,
+// so the next bucket's shard can be prepared before the period starts ( e.g. at := time.Now().Add(24 * time.Hour) ).
+//
+//	shard_template:
+//	  log_{YYYY}{MM}:
+//	    database: log_{YYYY}{MM}
+//
+// If no shards are appended, returns c itself.
+func (c *Config) WithTimeBucketShards(at time.Time) (*Config, []string, error) {
+	newTables := map[string]*TableConfig{}
+	addedShardNames := []string{}
+	for tableName, table := range c.Tables {
+		if table == nil || table.ShardTemplate == nil {
+			continue
+		}
+		if err := table.Error(); err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid configuration of %s", tableName)
+		}
+		buckets, err := algorithm.NewTimeBuckets(algorithm.Params(table.AlgorithmConfig))
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid algorithm_config of %s", tableName)
+		}
+		lastIndex := buckets.Index(at)
+		if lastIndex < len(table.Shards) {
+			continue
+		}
+		newTable := *table
+		newTable.Shards = append([]map[string]*DatabaseConfig{}, table.Shards...)
+		for idx := len(table.Shards); idx <= lastIndex; idx++ {
+			shardName, shardConfig := table.shardFromTemplate(buckets.BucketStart(idx))
+			if newTable.ShardConfigByName(shardName) != nil {
+				return nil, nil, errors.Errorf("shard %s of %s already exists. shard name of shard_template must include date", shardName, tableName)
+			}
+			newTable.Shards = append(newTable.Shards, map[string]*DatabaseConfig{shardName: shardConfig})
+			addedShardNames = append(addedShardNames, shardName)
+		}
+		newTables[tableName] = &newTable
+	}
+	if len(newTables) == 0 {
+		return c, addedShardNames, nil
+	}
+	newConfig := *c
+	newConfig.Tables = map[string]*TableConfig{}
+	for tableName, table := range c.Tables {
+		if newTable, exists := newTables[tableName]; exists {
+			newConfig.Tables[tableName] = newTable
+		} else {
+			newConfig.Tables[tableName] = table
+		}
+	}
+	return &newConfig, addedShardNames, nil
+}
+
+// shardFromTemplate returns name and database of shard for time bucket starts at 'start'
+func (c *TableConfig) shardFromTemplate(start time.Time) (string, *DatabaseConfig) {
+	replacer := strings.NewReplacer(
+		"{YYYY}", fmt.Sprintf("%04d", start.Year()),
+		"{MM}", fmt.Sprintf("%02d", int(start.Month())),
+		"{DD}", fmt.Sprintf("%02d", start.Day()),
+	)
+	for templateName, templateConfig := range c.ShardTemplate {
+		shardConfig := *templateConfig
+		shardConfig.NameOrPath = replacer.Replace(templateConfig.NameOrPath)
+		return replacer.Replace(templateName), &shardConfig
+	}
+	return "", nil
+}
@@ -1,6 +1,7 @@
 package adapter
 
 import (
+	"context"
 	"database/sql"
 	"sync"
 
@@ -14,6 +15,7 @@ import (
 // octillery currently supports mysql and sqlite3.
 // If use the other new adapter, implement the following interface as plugin ( new_adapter.go ) and call adapter.Register("adapter_name", &NewAdapterStructure{}).
 // Also, new_adapter.go file should put inside go.knocknote.io/octillery/plugin directory.
+// For context-aware adapter, implement DBAdapterV2 and call adapter.RegisterV2 instead.
 type DBAdapter interface {
 	// get current unique id for all shards by sequencer
 	CurrentSequenceID(conn *sql.DB, tableName string) (int64, error)
@@ -34,11 +36,195 @@ type DBAdapter interface {
 	InsertRowToSequencerIfNotExists(conn *sql.DB, tableName string) error
 }
 
+// ContextSequencerAdapter the optional interface for adapter that can cancel query to sequencer by context.
+//
+// If adapter implements this, octillery passes context of query to sequencer,
+// so hung sequencer doesn't stall INSERT past the deadline of caller.
+type ContextSequencerAdapter interface {
+	// get current unique id for all shards by sequencer with context
+	CurrentSequenceIDContext(ctx context.Context, conn *sql.DB, tableName string) (int64, error)
+
+	// get next unique id for all shards by sequencer with context
+	NextSequenceIDContext(ctx context.Context, conn *sql.DB, tableName string) (int64, error)
+}
+
+// ReturningIDAdapter the optional interface for adapter whose driver doesn't support LastInsertId() of sql.Result ( e.g. lib/pq ).
+//
+// If adapter implements this and IsRequiredReturningID returns true,
+// octillery appends 'RETURNING <identity_column>' to INSERT query for table not using sequencer,
+// and populates LastInsertId() of result by returned value.
+type ReturningIDAdapter interface {
+	// returns whether RETURNING clause is required for getting last inserted id
+	IsRequiredReturningID() bool
+}
+
+// SequenceBlockAdapter the optional interface for adapter that can allocate block of ids from sequencer by a query.
+//
+// If adapter implements this and table enables 'sequencer_cache_size', octillery allocates block of ids at once
+// and hands out them from memory, so INSERT doesn't need round trip to sequencer for each id.
+type SequenceBlockAdapter interface {
+	// advances sequencer by size and returns last id of allocated block. ids from ( lastID - size + 1 ) to lastID are reserved for caller.
+	// if ok is false, adapter doesn't support it
+	NextSequenceIDBlock(ctx context.Context, conn *sql.DB, tableName string, size int64) (lastID int64, ok bool, err error)
+}
+
+// SchemaAdapter the optional interface for adapter that can fetch table schema ( e.g. SHOW CREATE TABLE ).
+//
+// If adapter implements this, octillery can verify that all shards of a table have identical schema.
+type SchemaAdapter interface {
+	// returns normalized CREATE TABLE statement. if table doesn't exist, returns empty string without error
+	TableSchema(conn *sql.DB, tableName string) (string, error)
+}
+
+// IgnoreDuplicateAdapter the optional interface for adapter that supports INSERT ignoring duplicate key error.
+//
+// This is used for making replay of QueryLog idempotent.
+type IgnoreDuplicateAdapter interface {
+	// returns modifier put after INSERT keyword ( e.g. 'ignore' of MySQL )
+	// and clause appended to INSERT query ( e.g. 'on conflict do nothing' of PostgreSQL )
+	IgnoreDuplicateClause() (modifier string, clause string)
+}
+
+// SlaveAdapter the optional interface for adapter that can open connection to slave server.
+//
+// If adapter implements this, SELECT query to table enabled 'read_from_slave' is executed on slaves.
+type SlaveAdapter interface {
+	// open connection to slave server by database configuration file. slave is one of 'slave' values in it
+	OpenSlaveConnection(config *config.DatabaseConfig, slave string, queryString string) (*sql.DB, error)
+}
+
+// BackupAdapter the optional interface for adapter that can open connection to backup server.
+//
+// If adapter implements this, master is failed over to one of 'backup' servers when it is down.
+type BackupAdapter interface {
+	// open connection to backup server by database configuration file. backup is one of 'backup' values in it
+	OpenBackupConnection(config *config.DatabaseConfig, backup string, queryString string) (*sql.DB, error)
+}
+
+// ValueSerializerAdapter the optional interface for adapter that serializes query argument to SQL literal
+// when octillery embeds it into rewritten query ( e.g. INSERT query for sharded table ).
+//
+// By default, bool is serialized to 1 or 0 and time.Time is serialized to 'YYYY-MM-DD hh:mm:ss' like MySQL.
+// For example, adapter for PostgreSQL serializes bool to true or false and time.Time to timestamptz format.
+type ValueSerializerAdapter interface {
+	// returns literal of value and whether it is string literal that is quoted and escaped by octillery.
+	// if ok is false, default serialization is used
+	SerializeValue(value interface{}) (literal string, isString bool, ok bool)
+}
+
+// AutoIncrementOffsetAdapter the optional interface for adapter that can offset auto increment ids per connection.
+//
+// If adapter implements this and table enables 'auto_increment: offset',
+// octillery opens connection to each shard with returned query string, so that auto increment ids don't collide between shards.
+type AutoIncrementOffsetAdapter interface {
+	// returns query string of DSN that sets increment and offset of auto increment ids.
+	// if ok is false, adapter doesn't support it
+	AutoIncrementOffsetQueryString(increment int, offset int) (queryString string, ok bool)
+}
+
+// ReplicationPositionAdapter the optional interface for adapter that can read replication position of server
+// ( e.g. GTID of MySQL, LSN of PostgreSQL ).
+//
+// If adapter implements this, consistency token returned after writes has replication positions of masters,
+// and reads can wait until slaves apply changes up to the positions.
+type ReplicationPositionAdapter interface {
+	// returns current replication position of master.
+	// if ok is false, adapter or server doesn't support it ( e.g. GTID is disabled )
+	CurrentReplicationPosition(ctx context.Context, conn *sql.DB) (position string, ok bool, err error)
+
+	// blocks until slave applies changes up to position or context is done
+	WaitForReplicationPosition(ctx context.Context, conn *sql.DB, position string) error
+}
+
+// SequencerMaintenanceAdapter the optional interface for adapter that maintains table of sequencer.
+//
+// Table of sequencer is updated by every INSERT, so it may accrue history or fragmentation under heavy load
+// ( e.g. single-row table of MySQL ). octillery calls this by MaintainSequencers of DBConnectionManager or `octillery maintain`.
+type SequencerMaintenanceAdapter interface {
+	// maintains table of sequencer ( e.g. OPTIMIZE TABLE of MySQL ) and returns messages reported by database.
+	// if ok is false, adapter doesn't need maintenance of sequencer
+	MaintainSequencer(ctx context.Context, conn *sql.DB, tableName string) (messages []string, ok bool, err error)
+}
+
+// TableAnalyzeAdapter the optional interface for adapter that updates statistics of table used by query planner.
+//
+// octillery calls this for every shard of table by AnalyzeTable of DBConnection or `octillery maintain analyze`.
+type TableAnalyzeAdapter interface {
+	// updates statistics of table ( e.g. ANALYZE TABLE of MySQL ) and returns messages reported by database.
+	// if ok is false, adapter doesn't support it
+	AnalyzeTable(ctx context.Context, conn *sql.DB, tableName string) (messages []string, ok bool, err error)
+}
+
+// RowEstimateAdapter the optional interface for adapter that can estimate number of rows returned by query
+// from plan of query planner ( e.g. EXPLAIN of MySQL ) without executing it.
+//
+// If adapter implements this, DB.EstimateCount sums estimates of shards instead of executing expensive COUNT(*) for all shards.
+type RowEstimateAdapter interface {
+	// returns number of rows estimated by query planner.
+	// if ok is false, adapter doesn't support it
+	EstimateRows(ctx context.Context, conn *sql.DB, query string, args ...interface{}) (rows int64, ok bool, err error)
+}
+
+// TemporaryTableAdapter the optional interface for adapter that supports temporary table created in transaction.
+//
+// Temporary table lives in session of database, so octillery drops it by returned query before transaction is finished
+// not to leave it in connection returned to pool.
+type TemporaryTableAdapter interface {
+	// returns query dropping temporary table of tableName.
+	// if ok is false, adapter doesn't support temporary table
+	DropTemporaryTableQuery(tableName string) (query string, ok bool)
+}
+
+// LockingReadAdapter the optional interface for adapter that rewrites locking clause of SELECT query ( e.g. 'FOR UPDATE' ).
+//
+// If adapter doesn't implement this, locking clause is sent to database as it is.
+type LockingReadAdapter interface {
+	// returns locking clause sent to database instead of lock normalized to lower case ( e.g. 'for update' ).
+	// empty clause removes locking clause from query. if ok is false, database doesn't support lock
+	LockClause(lock string) (clause string, ok bool)
+}
+
+// ErrorClassifierAdapter the optional interface for adapter that classifies errors of database driver.
+//
+// If adapter implements this, queries failed by transient errors ( e.g. deadlock ) are retried by 'retry' in configuration file.
+type ErrorClassifierAdapter interface {
+	// returns class of transient error ( config.RetryOnDeadlock, config.RetryOnLockWaitTimeout or config.RetryOnConnection ).
+	// if err is not transient, returns empty string
+	ErrorClass(err error) string
+}
+
+// ConnectionReleaseAdapter the optional interface for adapter holding resources for connections opened by OpenConnection
+// ( e.g. connection keeping in-memory database of sqlite3 alive ).
+//
+// If adapter implements this, octillery calls ReleaseConnection after connection is closed by connection manager.
+type ConnectionReleaseAdapter interface {
+	// releases resources held for conn. conn is already closed, and conn not opened by adapter is ignored
+	ReleaseConnection(conn *sql.DB) error
+}
+
 var (
 	adaptersMu sync.RWMutex
 	adapters   = make(map[string]DBAdapter)
 )
 
+// ReleaseConnection calls ReleaseConnection of all registered adapters implementing ConnectionReleaseAdapter for closed conn
+func ReleaseConnection(conn *sql.DB) error {
+	adaptersMu.RLock()
+	releasers := []ConnectionReleaseAdapter{}
+	for _, adapter := range adapters {
+		if releaser, ok := adapter.(ConnectionReleaseAdapter); ok {
+			releasers = append(releasers, releaser)
+		}
+	}
+	adaptersMu.RUnlock()
+	for _, releaser := range releasers {
+		if err := releaser.ReleaseConnection(conn); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
 // Register register DBAdapter with driver name
 func Register(name string, adapter DBAdapter) {
 	adaptersMu.Lock()
@@ -50,6 +236,11 @@ func Register(name string, adapter DBAdapter) {
 		debug.Printf("Register called twice for adapter %s", name)
 	}
 	adapters[name] = adapter
+	delete(adaptersV2, name)
+	config.RegisterFeature(config.AdapterFeature(name))
+	if CapabilitiesOf(adapter).SupportsXA {
+		config.RegisterFeature(config.FeatureXA)
+	}
 }
 
 // Adapter get adapter by driver name
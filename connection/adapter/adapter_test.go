@@ -1,6 +1,7 @@
 package adapter
 
 import (
+	"context"
 	"database/sql"
 	"testing"
 
@@ -72,3 +73,95 @@ func TestAdapterInstance(t *testing.T) {
 		t.Fatalf("invalid adapter instance")
 	}
 }
+
+type TestAdapterV2 struct {
+	ctx context.Context
+}
+
+func (t *TestAdapterV2) CurrentSequenceID(ctx context.Context, conn *sql.DB, tableName string) (int64, error) {
+	t.ctx = ctx
+	return 1, nil
+}
+
+func (t *TestAdapterV2) NextSequenceID(ctx context.Context, conn *sql.DB, tableName string) (int64, error) {
+	t.ctx = ctx
+	return 2, nil
+}
+
+func (t *TestAdapterV2) ExecDDL(ctx context.Context, config *config.DatabaseConfig) error {
+	return nil
+}
+
+func (t *TestAdapterV2) OpenConnection(ctx context.Context, config *config.DatabaseConfig, opts *ConnectionOptions) (*sql.DB, error) {
+	if opts.QueryString != "parseTime=true" {
+		return nil, errors.New("invalid options")
+	}
+	return nil, nil
+}
+
+func (t *TestAdapterV2) CreateSequencerTableIfNotExists(ctx context.Context, conn *sql.DB, tableName string) error {
+	return nil
+}
+
+func (t *TestAdapterV2) InsertRowToSequencerIfNotExists(ctx context.Context, conn *sql.DB, tableName string) error {
+	return nil
+}
+
+func (t *TestAdapterV2) Capabilities() *Capabilities {
+	return &Capabilities{SupportsReturning: true, RequiresReturningID: true}
+}
+
+func TestAdapterV2Instance(t *testing.T) {
+	t.Run("v1 adapter", func(t *testing.T) {
+		instance, err := AdapterV2("sqlite3")
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		id, err := instance.NextSequenceID(context.Background(), nil, "users")
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if id != 2 {
+			t.Fatal("cannot call v1 adapter")
+		}
+		if capabilities := instance.Capabilities(); capabilities.SupportsReturning || capabilities.SupportsXA {
+			t.Fatal("invalid capabilities")
+		}
+		if _, err := AdapterV2("unknown"); err == nil {
+			t.Fatal("cannot handle error")
+		}
+	})
+	t.Run("v2 adapter", func(t *testing.T) {
+		adapterV2 := &TestAdapterV2{}
+		RegisterV2("v2", adapterV2)
+		instance, err := AdapterV2("v2")
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if instance != adapterV2 {
+			t.Fatal("cannot get adapter instance")
+		}
+		v1Instance, err := Adapter("v2")
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if _, err := v1Instance.OpenConnection(nil, "parseTime=true"); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if id, _ := v1Instance.CurrentSequenceID(nil, "users"); id != 1 || adapterV2.ctx == nil {
+			t.Fatal("cannot call v2 adapter")
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		if _, err := v1Instance.(ContextSequencerAdapter).NextSequenceIDContext(ctx, nil, "users"); err != nil || adapterV2.ctx != ctx {
+			t.Fatal("cannot pass context to v2 adapter")
+		}
+		if !v1Instance.(ReturningIDAdapter).IsRequiredReturningID() || !CapabilitiesOf(v1Instance).SupportsReturning {
+			t.Fatal("invalid capabilities")
+		}
+		Register("v2", &TestAdapter{})
+		if instance, _ := AdapterV2("v2"); instance == adapterV2 {
+			t.Fatal("cannot override adapter")
+		}
+	})
+}
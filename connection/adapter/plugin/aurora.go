@@ -0,0 +1,9 @@
+package plugin
+
+import (
+	auroraadapter "go.knocknote.io/octillery/connection/adapter/plugin/aurora"
+)
+
+// AuroraAdapter implements DBAdapter interface.
+// This file is copied to go.knocknote.io/octillery/plugin by `octillery install --aurora`.
+type AuroraAdapter = auroraadapter.AuroraAdapter
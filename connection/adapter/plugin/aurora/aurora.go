@@ -0,0 +1,50 @@
+// Package aurora is database adapter for Amazon Aurora MySQL.
+//
+// Database is configured by endpoints of Aurora cluster instead of 'master' and 'slave'.
+//
+//	adapter: aurora
+//	cluster_endpoint: mycluster.cluster-xxxx.ap-northeast-1.rds.amazonaws.com:3306
+//	reader_endpoint: mycluster.cluster-ro-xxxx.ap-northeast-1.rds.amazonaws.com:3306
+//
+// Write queries are executed through cluster endpoint, and read queries are executed through reader endpoint if 'read_from_slave' is enabled.
+//
+// After failover, connections opened to previous writer are connected to reader, so write query fails by
+// error 1290 ( ER_OPTION_PREVENTS_STATEMENT ) or 1836 ( ER_READ_ONLY_MODE ).
+// The connection is discarded and query out of transaction is retried by new connection to new writer.
+// Query in transaction returns the error, so use IsFailoverError to retry transaction.
+package aurora
+
+import (
+	"database/sql"
+	"database/sql/driver"
+
+	mysql "github.com/go-sql-driver/mysql"
+	"go.knocknote.io/octillery/connection/adapter"
+	mysqladapter "go.knocknote.io/octillery/connection/adapter/plugin/mysql"
+	osql "go.knocknote.io/octillery/database/sql"
+	osqldriver "go.knocknote.io/octillery/database/sql/driver"
+	"go.knocknote.io/octillery/internal"
+)
+
+// AuroraAdapter implements DBAdapter interface. It behaves as MySQLAdapter except for handling failover by driver.
+type AuroraAdapter struct {
+	mysqladapter.MySQLAdapter
+}
+
+func init() {
+	pluginName := "aurora"
+	if internal.IsLoadedPlugin(pluginName) {
+		return
+	}
+	var drv interface{}
+	drv = mysql.MySQLDriver{}
+	if d, ok := drv.(osqldriver.Driver); ok {
+		// mysql package's import statement is already replaced to "go.knocknote.io/octillery/database/sql".
+		// In this case, failover is not handled by driver
+		osql.RegisterByOctillery(pluginName, d)
+	} else if d, ok := drv.(driver.Driver); ok {
+		sql.Register(pluginName, &failoverDriver{driver: d})
+	}
+	adapter.Register(pluginName, &AuroraAdapter{})
+	internal.SetLoadedPlugin(pluginName)
+}
@@ -0,0 +1,217 @@
+package aurora
+
+import (
+	"context"
+	"database/sql/driver"
+
+	mysql "github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/debug"
+)
+
+const (
+	// errOptionPreventsStatement ER_OPTION_PREVENTS_STATEMENT. returned for write query by instance running with --read-only
+	errOptionPreventsStatement = 1290
+
+	// errReadOnlyMode ER_READ_ONLY_MODE. returned for write query by instance in read-only mode
+	errReadOnlyMode = 1836
+)
+
+// IsFailoverError returns whether err is returned by write query to instance that is not writer anymore because of failover.
+// Transaction failed by this error can be retried, then it is executed on new writer.
+func IsFailoverError(err error) bool {
+	mysqlErr, ok := errors.Cause(err).(*mysql.MySQLError)
+	if !ok {
+		return false
+	}
+	return mysqlErr.Number == errOptionPreventsStatement || mysqlErr.Number == errReadOnlyMode
+}
+
+// failoverDriver wraps driver of MySQL, and discards connection to instance demoted to reader by failover,
+// so new connection is opened to new writer through cluster endpoint.
+type failoverDriver struct {
+	driver driver.Driver
+}
+
+func (d *failoverDriver) Open(dsn string) (driver.Conn, error) {
+	conn, err := d.driver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &failoverConn{conn: conn}, nil
+}
+
+// failoverConn is used by a goroutine at the same time because database/sql serializes access to driver.Conn
+type failoverConn struct {
+	conn driver.Conn
+	// true if transaction is in progress
+	inTx bool
+	// true if connected instance is demoted to reader
+	demoted bool
+}
+
+// handleError marks connection demoted if err is caused by failover.
+// Out of transaction, it returns driver.ErrBadConn, so database/sql retries query by new connection.
+func (c *failoverConn) handleError(err error) error {
+	if err == nil || !IsFailoverError(err) {
+		return err
+	}
+	c.demoted = true
+	if c.inTx {
+		return err
+	}
+	debug.Printf("connection is discarded because instance is demoted by failover: %s", err)
+	return driver.ErrBadConn
+}
+
+func (c *failoverConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *failoverConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var (
+		stmt driver.Stmt
+		err  error
+	)
+	if preparer, ok := c.conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, c.handleError(err)
+	}
+	return &failoverStmt{stmt: stmt, conn: c}, nil
+}
+
+func (c *failoverConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *failoverConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *failoverConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var (
+		tx  driver.Tx
+		err error
+	)
+	if beginner, ok := c.conn.(driver.ConnBeginTx); ok {
+		tx, err = beginner.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.conn.Begin()
+	}
+	if err != nil {
+		return nil, c.handleError(err)
+	}
+	c.inTx = true
+	return &failoverTx{tx: tx, conn: c}, nil
+}
+
+func (c *failoverConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	result, err := execer.ExecContext(ctx, query, args)
+	return result, c.handleError(err)
+}
+
+func (c *failoverConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	rows, err := queryer.QueryContext(ctx, query, args)
+	return rows, c.handleError(err)
+}
+
+func (c *failoverConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *failoverConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// ResetSession discards connection to demoted instance when it is returned to pool
+func (c *failoverConn) ResetSession(ctx context.Context) error {
+	if c.demoted {
+		return driver.ErrBadConn
+	}
+	if resetter, ok := c.conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+type failoverTx struct {
+	tx   driver.Tx
+	conn *failoverConn
+}
+
+func (t *failoverTx) Commit() error {
+	t.conn.inTx = false
+	return t.tx.Commit()
+}
+
+func (t *failoverTx) Rollback() error {
+	t.conn.inTx = false
+	return t.tx.Rollback()
+}
+
+type failoverStmt struct {
+	stmt driver.Stmt
+	conn *failoverConn
+}
+
+func (s *failoverStmt) Close() error {
+	return s.stmt.Close()
+}
+
+func (s *failoverStmt) NumInput() int {
+	return s.stmt.NumInput()
+}
+
+func (s *failoverStmt) Exec(args []driver.Value) (driver.Result, error) {
+	result, err := s.stmt.Exec(args)
+	return result, s.conn.handleError(err)
+}
+
+func (s *failoverStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows, err := s.stmt.Query(args)
+	return rows, s.conn.handleError(err)
+}
+
+func (s *failoverStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := s.stmt.(driver.StmtExecContext)
+	if !ok {
+		return s.Exec(namedValuesToValues(args))
+	}
+	result, err := execer.ExecContext(ctx, args)
+	return result, s.conn.handleError(err)
+}
+
+func (s *failoverStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := s.stmt.(driver.StmtQueryContext)
+	if !ok {
+		return s.Query(namedValuesToValues(args))
+	}
+	rows, err := queryer.QueryContext(ctx, args)
+	return rows, s.conn.handleError(err)
+}
+
+func namedValuesToValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for idx, arg := range args {
+		values[idx] = arg.Value
+	}
+	return values
+}
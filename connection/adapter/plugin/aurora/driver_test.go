@@ -0,0 +1,116 @@
+package aurora
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync"
+	"testing"
+
+	mysql "github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+)
+
+// testDriver opens connection to demoted instance at first, and opens connection to writer after that
+type testDriver struct {
+	mu     sync.Mutex
+	opened int
+}
+
+func (d *testDriver) Open(dsn string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.opened++
+	return &testConn{demoted: d.opened == 1}, nil
+}
+
+func (d *testDriver) openedConnections() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.opened
+}
+
+type testConn struct {
+	demoted bool
+}
+
+func (c *testConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *testConn) Close() error {
+	return nil
+}
+
+func (c *testConn) Begin() (driver.Tx, error) {
+	return &testTx{}, nil
+}
+
+func (c *testConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.demoted {
+		return nil, &mysql.MySQLError{Number: errReadOnlyMode, Message: "Running in read-only mode"}
+	}
+	return driver.RowsAffected(1), nil
+}
+
+type testTx struct{}
+
+func (*testTx) Commit() error {
+	return nil
+}
+
+func (*testTx) Rollback() error {
+	return nil
+}
+
+func openTestDB(t *testing.T, name string) (*sql.DB, *testDriver) {
+	drv := &testDriver{}
+	sql.Register(name, &failoverDriver{driver: drv})
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	return db, drv
+}
+
+func TestFailover(t *testing.T) {
+	t.Run("out of transaction", func(t *testing.T) {
+		db, drv := openTestDB(t, "aurora_test")
+		defer db.Close()
+		if _, err := db.Exec("UPDATE users SET name = 'alice'"); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if drv.openedConnections() != 2 {
+			t.Fatal("query must be retried by new connection")
+		}
+		if _, err := db.Exec("UPDATE users SET name = 'bob'"); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if drv.openedConnections() != 2 {
+			t.Fatal("connection to writer must be reused")
+		}
+	})
+	t.Run("in transaction", func(t *testing.T) {
+		db, drv := openTestDB(t, "aurora_tx_test")
+		defer db.Close()
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if _, err := tx.Exec("UPDATE users SET name = 'alice'"); !IsFailoverError(err) {
+			t.Fatalf("cannot get failover error. %v", err)
+		}
+		if err := tx.Rollback(); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if _, err := db.Exec("UPDATE users SET name = 'alice'"); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if drv.openedConnections() != 2 {
+			t.Fatal("connection to demoted instance must be discarded")
+		}
+	})
+	if IsFailoverError(errors.New("error")) || !IsFailoverError(errors.WithStack(&mysql.MySQLError{Number: errOptionPreventsStatement})) {
+		t.Fatal("cannot detect failover error")
+	}
+}
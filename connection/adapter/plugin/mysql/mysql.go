@@ -0,0 +1,363 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	mysql "github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/connection/adapter"
+	osql "go.knocknote.io/octillery/database/sql"
+	osqldriver "go.knocknote.io/octillery/database/sql/driver"
+	"go.knocknote.io/octillery/debug"
+	"go.knocknote.io/octillery/internal"
+)
+
+// MySQLAdapter implements DBAdapter interface.
+type MySQLAdapter struct {
+}
+
+var autoIncrementOption = regexp.MustCompile(` AUTO_INCREMENT=[0-9]+`)
+
+func init() {
+	pluginName := "mysql"
+	if internal.IsLoadedPlugin(pluginName) {
+		return
+	}
+	var driver interface{}
+	driver = mysql.MySQLDriver{}
+	if drv, ok := driver.(osqldriver.Driver); ok {
+		// mysql package's import statement is already replaced to "go.knocknote.io/octillery/database/sql"
+		osql.RegisterByOctillery(pluginName, drv)
+	} else {
+		// In this case, mysql package already call `sql.Register("mysql", &MySQLDriver{})`.
+		// So, octillery skip driver registration
+	}
+	adapter.Register(pluginName, &MySQLAdapter{})
+	internal.SetLoadedPlugin(pluginName)
+}
+
+// CurrentSequenceID get current unique id for all shards by sequencer
+func (adapter *MySQLAdapter) CurrentSequenceID(conn *sql.DB, tableName string) (int64, error) {
+	return adapter.CurrentSequenceIDContext(context.Background(), conn, tableName)
+}
+
+// CurrentSequenceIDContext get current unique id for all shards by sequencer with context
+func (adapter *MySQLAdapter) CurrentSequenceIDContext(ctx context.Context, conn *sql.DB, tableName string) (int64, error) {
+	return adapter.lastInsertID(ctx, conn, fmt.Sprintf("update %s set id = last_insert_id(id)", tableName))
+}
+
+// NextSequenceID get next unique id for all shards by sequencer
+func (adapter *MySQLAdapter) NextSequenceID(conn *sql.DB, tableName string) (int64, error) {
+	return adapter.NextSequenceIDContext(context.Background(), conn, tableName)
+}
+
+// NextSequenceIDContext get next unique id for all shards by sequencer with context
+func (adapter *MySQLAdapter) NextSequenceIDContext(ctx context.Context, conn *sql.DB, tableName string) (int64, error) {
+	return adapter.lastInsertID(ctx, conn, fmt.Sprintf("update %s set id = last_insert_id(id + 1)", tableName))
+}
+
+// NextSequenceIDBlock advances sequencer by size and returns last id of allocated block
+func (adapter *MySQLAdapter) NextSequenceIDBlock(ctx context.Context, conn *sql.DB, tableName string, size int64) (int64, bool, error) {
+	lastID, err := adapter.lastInsertID(ctx, conn, fmt.Sprintf("update %s set id = last_insert_id(id + %d)", tableName, size))
+	if err != nil {
+		return 0, false, errors.WithStack(err)
+	}
+	return lastID, true, nil
+}
+
+// lastInsertID executes query and selects last_insert_id() on the same connection
+func (adapter *MySQLAdapter) lastInsertID(ctx context.Context, conn *sql.DB, query string) (int64, error) {
+	c, err := conn.Conn(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "cannot get connection to sequencer")
+	}
+	defer c.Close()
+	var seqID int64
+	if _, err := c.ExecContext(ctx, query); err != nil {
+		return 0, errors.Wrapf(err, "cannot execute '%s'", query)
+	}
+	if err := c.QueryRowContext(ctx, "select last_insert_id()").Scan(&seqID); err != nil {
+		return 0, errors.Wrap(err, "cannot select last_insert_id()")
+	}
+	return seqID, nil
+}
+
+// ExecDDL create database if not exists by database configuration file.
+func (adapter *MySQLAdapter) ExecDDL(config *config.DatabaseConfig) error {
+	if len(config.Masters) > 1 {
+		return errors.New("Sorry, currently supports single master database only")
+	}
+	dbname := config.NameOrPath
+	for _, master := range config.Masters {
+		serverDsn := fmt.Sprintf("%s:%s@tcp(%s)/?%s", config.Username, config.Password, master, config.URLParams())
+		serverConn, err := sql.Open(config.Adapter, serverDsn)
+		defer serverConn.Close()
+		if err != nil {
+			return errors.Wrapf(err, "cannot open connection from %s", serverDsn)
+		}
+		if _, err := serverConn.Exec(fmt.Sprintf(`CREATE DATABASE IF NOT EXISTS %s`, dbname)); err != nil {
+			return errors.Wrapf(err, "cannot create database %s", dbname)
+		}
+		return nil
+	}
+	return errors.New("must define 'master' server")
+}
+
+// OpenConnection open connection by database configuration file
+func (adapter *MySQLAdapter) OpenConnection(config *config.DatabaseConfig, queryString string) (*sql.DB, error) {
+	if len(config.Masters) > 1 {
+		return nil, errors.New("Sorry, currently supports single master database only")
+	}
+	dbname := config.NameOrPath
+	queryString = joinQueryString(config.URLParams(), queryString)
+	for _, master := range config.Masters {
+		dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?%s", config.Username, config.Password, master, dbname, queryString)
+		debug.Printf("dsn = %s", strings.Replace(dsn, "%", "%%", -1))
+		conn, err := sql.Open(config.Adapter, dsn)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return conn, nil
+	}
+	return nil, errors.New("must define 'master' server")
+}
+
+// OpenSlaveConnection open connection to slave server
+func (adapter *MySQLAdapter) OpenSlaveConnection(config *config.DatabaseConfig, slave string, queryString string) (*sql.DB, error) {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?%s", config.Username, config.Password, slave, config.NameOrPath, joinQueryString(config.URLParams(), queryString))
+	debug.Printf("slave dsn = %s", strings.Replace(dsn, "%", "%%", -1))
+	conn, err := sql.Open(config.Adapter, dsn)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return conn, nil
+}
+
+// OpenBackupConnection open connection to backup server
+func (adapter *MySQLAdapter) OpenBackupConnection(config *config.DatabaseConfig, backup string, queryString string) (*sql.DB, error) {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?%s", config.Username, config.Password, backup, config.NameOrPath, joinQueryString(config.URLParams(), queryString))
+	debug.Printf("backup dsn = %s", strings.Replace(dsn, "%", "%%", -1))
+	conn, err := sql.Open(config.Adapter, dsn)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return conn, nil
+}
+
+// joinQueryString joins parameters of url in configuration and parameters given by octillery
+func joinQueryString(urlParams string, queryString string) string {
+	if urlParams == "" {
+		return queryString
+	}
+	if queryString == "" {
+		return urlParams
+	}
+	return urlParams + "&" + queryString
+}
+
+// CreateSequencerTableIfNotExists create table for sequencer if not exists
+func (adapter *MySQLAdapter) CreateSequencerTableIfNotExists(conn *sql.DB, tableName string) error {
+	_, err := conn.Exec(fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+    id integer NOT NULL PRIMARY KEY AUTO_INCREMENT
+)`, tableName))
+	return errors.Wrap(err, "cannot create table for sequencer")
+}
+
+// InsertRowToSequencerIfNotExists insert first row to sequencer if not exists
+func (adapter *MySQLAdapter) InsertRowToSequencerIfNotExists(conn *sql.DB, tableName string) error {
+	var rowCount uint64
+	if err := conn.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", tableName)).Scan(&rowCount); err != nil {
+		return errors.Wrapf(err, "cannot SELECT COUNT(*) FROM %s", tableName)
+	}
+	// ignore if already inserted row (perhaps id is 0)
+	if rowCount > 0 {
+		return nil
+	}
+	// insert id is 0, but inserted row's id is 1 because this table enabled AUTO_INCREMENT
+	if _, err := conn.Exec(fmt.Sprintf("INSERT INTO %s(id) VALUES (0)", tableName)); err != nil {
+		return errors.Wrap(err, "cannot insert new row to sequencer")
+	}
+	// force update first row's id to 0 because last_insert_id() returns 2 at first insert
+	if _, err := conn.Exec(fmt.Sprintf("UPDATE %s SET id = 0", tableName)); err != nil {
+		return errors.Wrap(err, "cannot update new row's id to sequencer")
+	}
+	return nil
+}
+
+// TableSchema returns result of SHOW CREATE TABLE without AUTO_INCREMENT option because it is different for each shard
+func (adapter *MySQLAdapter) TableSchema(conn *sql.DB, tableName string) (string, error) {
+	var (
+		table  string
+		schema string
+	)
+	if err := conn.QueryRow(fmt.Sprintf("SHOW CREATE TABLE `%s`", tableName)).Scan(&table, &schema); err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == 1146 {
+			// table doesn't exist
+			return "", nil
+		}
+		return "", errors.Wrapf(err, `failed to execute 'SHOW CREATE TABLE "%s"'`, tableName)
+	}
+	return autoIncrementOption.ReplaceAllString(schema, ""), nil
+}
+
+// AutoIncrementOffsetQueryString returns system variables of session for auto increment ids
+func (adapter *MySQLAdapter) AutoIncrementOffsetQueryString(increment int, offset int) (string, bool) {
+	return fmt.Sprintf("auto_increment_increment=%d&auto_increment_offset=%d", increment, offset), true
+}
+
+// IgnoreDuplicateClause returns modifier for INSERT IGNORE
+func (adapter *MySQLAdapter) IgnoreDuplicateClause() (string, string) {
+	return "ignore", ""
+}
+
+// DropTemporaryTableQuery returns DROP TEMPORARY TABLE not to drop normal table of the same name
+func (adapter *MySQLAdapter) DropTemporaryTableQuery(tableName string) (string, bool) {
+	return fmt.Sprintf("drop temporary table if exists `%s`", tableName), true
+}
+
+// CurrentReplicationPosition returns GTID set executed on server. if GTID is disabled, returns false
+func (adapter *MySQLAdapter) CurrentReplicationPosition(ctx context.Context, conn *sql.DB) (string, bool, error) {
+	var gtidSet string
+	if err := conn.QueryRowContext(ctx, "SELECT @@GLOBAL.gtid_executed").Scan(&gtidSet); err != nil {
+		return "", false, errors.Wrap(err, "failed to get executed GTID set")
+	}
+	if gtidSet == "" {
+		return "", false, nil
+	}
+	return gtidSet, true, nil
+}
+
+// WaitForReplicationPosition waits until slave executes all transactions of GTID set
+func (adapter *MySQLAdapter) WaitForReplicationPosition(ctx context.Context, conn *sql.DB, position string) error {
+	var result int
+	if err := conn.QueryRowContext(ctx, "SELECT WAIT_FOR_EXECUTED_GTID_SET(?)", position).Scan(&result); err != nil {
+		return errors.Wrapf(err, "failed to wait for GTID set %s", position)
+	}
+	if result != 0 {
+		return errors.Errorf("timed out waiting for GTID set %s", position)
+	}
+	return nil
+}
+
+// MaintainSequencer rebuilds table of sequencer by OPTIMIZE TABLE and returns messages of it.
+// Single row of sequencer is updated by every INSERT, so InnoDB accrues undo history and fragmentation under heavy load.
+func (adapter *MySQLAdapter) MaintainSequencer(ctx context.Context, conn *sql.DB, tableName string) ([]string, bool, error) {
+	messages, err := adapter.execTableMaintenance(ctx, conn, "OPTIMIZE", tableName)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "cannot optimize table %s", tableName)
+	}
+	return messages, true, nil
+}
+
+// AnalyzeTable updates index statistics of table by ANALYZE TABLE and returns messages of it.
+func (adapter *MySQLAdapter) AnalyzeTable(ctx context.Context, conn *sql.DB, tableName string) ([]string, bool, error) {
+	messages, err := adapter.execTableMaintenance(ctx, conn, "ANALYZE", tableName)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "cannot analyze table %s", tableName)
+	}
+	return messages, true, nil
+}
+
+// execTableMaintenance executes table maintenance statement ( e.g. OPTIMIZE TABLE ) and returns 'Msg_type: Msg_text' of result
+func (adapter *MySQLAdapter) execTableMaintenance(ctx context.Context, conn *sql.DB, statement string, tableName string) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("%s TABLE `%s`", statement, tableName))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+	messages := []string{}
+	for rows.Next() {
+		var table, op, msgType, msgText string
+		if err := rows.Scan(&table, &op, &msgType, &msgText); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		messages = append(messages, fmt.Sprintf("%s: %s", msgType, msgText))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return messages, nil
+}
+
+const (
+	errLockWaitTimeout = 1205 // ER_LOCK_WAIT_TIMEOUT
+	errLockDeadlock    = 1213 // ER_LOCK_DEADLOCK
+)
+
+// ErrorClass classifies deadlock, lock wait timeout and invalid connection as transient errors for retry
+func (adapter *MySQLAdapter) ErrorClass(err error) string {
+	cause := errors.Cause(err)
+	if cause == mysql.ErrInvalidConn {
+		return config.RetryOnConnection
+	}
+	mysqlErr, ok := cause.(*mysql.MySQLError)
+	if !ok {
+		return ""
+	}
+	switch mysqlErr.Number {
+	case errLockDeadlock:
+		return config.RetryOnDeadlock
+	case errLockWaitTimeout:
+		return config.RetryOnLockWaitTimeout
+	}
+	return ""
+}
+
+// EstimateRows returns sum of 'rows' * 'filtered' of EXPLAIN for tables in the outermost query ( or each query of UNION ).
+// Tables of subquery are not counted because they don't decide number of rows returned by query.
+func (adapter *MySQLAdapter) EstimateRows(ctx context.Context, conn *sql.DB, query string, args ...interface{}) (int64, bool, error) {
+	rows, err := conn.QueryContext(ctx, "EXPLAIN "+query, args...)
+	if err != nil {
+		return 0, false, errors.Wrapf(err, "cannot explain %s", query)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, false, errors.WithStack(err)
+	}
+	var estimated float64
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for idx := range values {
+			dest[idx] = &values[idx]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return 0, false, errors.WithStack(err)
+		}
+		valueByColumn := map[string]string{}
+		for idx, column := range columns {
+			valueByColumn[strings.ToLower(column)] = values[idx].String
+		}
+		switch valueByColumn["select_type"] {
+		case "SIMPLE", "PRIMARY", "UNION":
+		default:
+			continue
+		}
+		rowNum, err := strconv.ParseFloat(valueByColumn["rows"], 64)
+		if err != nil {
+			// e.g. 'Impossible WHERE noticed after reading const tables'
+			continue
+		}
+		if filtered, err := strconv.ParseFloat(valueByColumn["filtered"], 64); err == nil {
+			rowNum = rowNum * filtered / 100
+		}
+		estimated += rowNum
+	}
+	if err := rows.Err(); err != nil {
+		return 0, false, errors.WithStack(err)
+	}
+	return int64(estimated + 0.5), true, nil
+}
+
+// Capabilities returns features supported by driver
+func (*MySQLAdapter) Capabilities() *adapter.Capabilities {
+	return &adapter.Capabilities{SupportsXA: true}
+}
@@ -0,0 +1,106 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+const memoryDatabaseName = ":memory:"
+
+// memoryDatabase connection keeping named in-memory database alive.
+// shared cache database is discarded when the last connection to it is closed,
+// but *sql.DB closes idle connections by SetMaxIdleConns/SetConnMaxLifetime.
+type memoryDatabase struct {
+	db   *sql.DB
+	conn *sql.Conn
+	// number of connection pools opened for database
+	refs int
+}
+
+var (
+	memoryDatabases   = map[string]*memoryDatabase{}
+	memoryConns       = map[*sql.DB]string{}
+	memoryDatabasesMu sync.Mutex
+	memoryCounter     uint32
+)
+
+// isMemoryDatabase returns whether path is ':memory:' or 'file::memory:?...' or not
+func isMemoryDatabase(path string) bool {
+	return path == memoryDatabaseName || strings.HasPrefix(path, "file:"+memoryDatabaseName)
+}
+
+// memoryDSN converts in-memory database path to DSN of named shared cache database, and returns name specified by path.
+// Each shard is able to have individual database by name parameter ( e.g. 'file::memory:?cache=shared&name=shard1' ).
+// If name is not specified, unique name is assigned for every connection pool and returned name is empty.
+func memoryDSN(path string) (string, string, error) {
+	values := url.Values{}
+	if path != memoryDatabaseName {
+		u, err := url.Parse(path)
+		if err != nil {
+			return "", "", errors.Wrapf(err, "cannot parse %s", path)
+		}
+		values = u.Query()
+	}
+	name := values.Get("name")
+	databaseName := name
+	if databaseName == "" {
+		databaseName = fmt.Sprintf("octillery_memory_%d", atomic.AddUint32(&memoryCounter, 1))
+	}
+	values.Del("name")
+	values.Set("mode", "memory")
+	values.Set("cache", "shared")
+	return fmt.Sprintf("file:%s?%s", url.PathEscape(databaseName), values.Encode()), name, nil
+}
+
+// keepMemoryDatabase holds a connection to named in-memory database until all connection pools opened for it are released
+func keepMemoryDatabase(driverName string, name string, dsn string, pool *sql.DB) error {
+	memoryDatabasesMu.Lock()
+	defer memoryDatabasesMu.Unlock()
+	if database, exists := memoryDatabases[name]; exists {
+		database.refs++
+		memoryConns[pool] = name
+		return nil
+	}
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return errors.Wrapf(err, "cannot open connection from %s", dsn)
+	}
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		db.Close()
+		return errors.Wrapf(err, "cannot open connection from %s", dsn)
+	}
+	memoryDatabases[name] = &memoryDatabase{db: db, conn: conn, refs: 1}
+	memoryConns[pool] = name
+	return nil
+}
+
+// releaseMemoryDatabase releases connection pool opened for named in-memory database,
+// and discards database if pool is the last one
+func releaseMemoryDatabase(pool *sql.DB) error {
+	memoryDatabasesMu.Lock()
+	defer memoryDatabasesMu.Unlock()
+	name, exists := memoryConns[pool]
+	if !exists {
+		return nil
+	}
+	delete(memoryConns, pool)
+	database := memoryDatabases[name]
+	database.refs--
+	if database.refs > 0 {
+		return nil
+	}
+	delete(memoryDatabases, name)
+	if err := database.conn.Close(); err != nil {
+		database.db.Close()
+		return errors.Wrapf(err, "cannot close in-memory database %s", name)
+	}
+	return errors.Wrapf(database.db.Close(), "cannot close in-memory database %s", name)
+}
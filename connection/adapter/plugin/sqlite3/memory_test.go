@@ -0,0 +1,110 @@
+package sqlite3
+
+import (
+	"database/sql"
+	"testing"
+
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/connection"
+)
+
+func checkErr(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func isKeptMemoryDatabase(name string) bool {
+	memoryDatabasesMu.Lock()
+	defer memoryDatabasesMu.Unlock()
+	_, exists := memoryDatabases[name]
+	return exists
+}
+
+func TestMemoryDatabase(t *testing.T) {
+	adapter := &SQLiteAdapter{}
+	hasTable := func(conn *sql.DB) bool {
+		_, err := conn.Exec("select id from items")
+		return err == nil
+	}
+	t.Run("anonymous database", func(t *testing.T) {
+		cfg := &config.DatabaseConfig{Adapter: "sqlite3", NameOrPath: ":memory:"}
+		conn, err := adapter.OpenConnection(cfg, "")
+		checkErr(t, err)
+		defer conn.Close()
+		other, err := adapter.OpenConnection(cfg, "")
+		checkErr(t, err)
+		defer other.Close()
+		_, err = conn.Exec("create table items (id integer)")
+		checkErr(t, err)
+		if !hasTable(conn) || hasTable(other) {
+			t.Fatal("anonymous database must be opened for each connection")
+		}
+		memoryDatabasesMu.Lock()
+		kept := len(memoryConns)
+		memoryDatabasesMu.Unlock()
+		if kept != 0 {
+			t.Fatal("anonymous database must not be kept")
+		}
+	})
+	t.Run("named database", func(t *testing.T) {
+		name := "octillery_memory_test"
+		cfg := &config.DatabaseConfig{Adapter: "sqlite3", NameOrPath: "file::memory:?cache=shared&name=" + name}
+		conn, err := adapter.OpenConnection(cfg, "")
+		checkErr(t, err)
+		other, err := adapter.OpenConnection(cfg, "")
+		checkErr(t, err)
+		_, err = conn.Exec("create table items (id integer)")
+		checkErr(t, err)
+		if !hasTable(other) {
+			t.Fatal("named database must be shared by connections")
+		}
+
+		checkErr(t, conn.Close())
+		checkErr(t, adapter.ReleaseConnection(conn))
+		if !isKeptMemoryDatabase(name) || !hasTable(other) {
+			t.Fatal("named database must be kept until all connections are released")
+		}
+		checkErr(t, other.Close())
+		checkErr(t, adapter.ReleaseConnection(other))
+		if isKeptMemoryDatabase(name) {
+			t.Fatal("cannot release named database")
+		}
+
+		reopened, err := adapter.OpenConnection(cfg, "")
+		checkErr(t, err)
+		defer func() {
+			checkErr(t, reopened.Close())
+			checkErr(t, adapter.ReleaseConnection(reopened))
+		}()
+		if hasTable(reopened) {
+			t.Fatal("released database must be discarded")
+		}
+	})
+	t.Run("release by connection manager", func(t *testing.T) {
+		name := "octillery_memory_items"
+		cfg := &config.Config{Tables: map[string]*config.TableConfig{
+			"items": {
+				DatabaseConfig: config.DatabaseConfig{
+					Adapter:    "sqlite3",
+					NameOrPath: "file::memory:?cache=shared&name=" + name,
+				},
+			},
+		}}
+		checkErr(t, connection.SetConfig(cfg))
+		mgr, err := connection.NewConnectionManager()
+		checkErr(t, err)
+		conn, err := mgr.ConnectionByTableName("items")
+		checkErr(t, err)
+		_, err = conn.Connection.Exec("create table items (id integer)")
+		checkErr(t, err)
+		if !isKeptMemoryDatabase(name) {
+			t.Fatal("named database must be kept while connection manager is opened")
+		}
+		checkErr(t, mgr.Close())
+		if isKeptMemoryDatabase(name) {
+			t.Fatal("named database must be released by closing connection manager")
+		}
+	})
+}
@@ -0,0 +1,188 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/connection/adapter"
+	osql "go.knocknote.io/octillery/database/sql"
+	osqldriver "go.knocknote.io/octillery/database/sql/driver"
+	"go.knocknote.io/octillery/debug"
+	"go.knocknote.io/octillery/internal"
+)
+
+// SQLiteAdapter implements DBAdapter interface.
+type SQLiteAdapter struct {
+}
+
+func init() {
+	pluginName := "sqlite3"
+	if internal.IsLoadedPlugin(pluginName) {
+		return
+	}
+	var driver interface{}
+	driver = &sqlite3.SQLiteDriver{}
+	if drv, ok := driver.(osqldriver.Driver); ok {
+		osql.RegisterByOctillery(pluginName, drv)
+	} else {
+		// In this case, sqlite3 package already call `sql.Register("sqlite3", &SQLiteDriver{})`.
+		// So, octillery skip driver registration
+	}
+	adapter.Register(pluginName, &SQLiteAdapter{})
+	internal.SetLoadedPlugin(pluginName)
+}
+
+// CurrentSequenceID get current unique id for all shards by sequencer
+func (adapter *SQLiteAdapter) CurrentSequenceID(conn *sql.DB, tableName string) (int64, error) {
+	return adapter.CurrentSequenceIDContext(context.Background(), conn, tableName)
+}
+
+// CurrentSequenceIDContext get current unique id for all shards by sequencer with context
+func (adapter *SQLiteAdapter) CurrentSequenceIDContext(ctx context.Context, conn *sql.DB, tableName string) (int64, error) {
+	var seqID int64
+	// ignore error of ErrNoRows
+	conn.QueryRowContext(ctx, fmt.Sprintf("select seq_id from %s where id = 0", tableName)).Scan(&seqID)
+	return seqID, nil
+}
+
+// NextSequenceID get next unique id for all shards by sequencer
+func (adapter *SQLiteAdapter) NextSequenceID(conn *sql.DB, tableName string) (int64, error) {
+	return adapter.NextSequenceIDContext(context.Background(), conn, tableName)
+}
+
+// NextSequenceIDContext get next unique id for all shards by sequencer with context
+func (adapter *SQLiteAdapter) NextSequenceIDContext(ctx context.Context, conn *sql.DB, tableName string) (int64, error) {
+	var seqID int64
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("update %s set seq_id = seq_id + 1 where id = 0", tableName)); err != nil {
+		return 0, errors.Wrap(err, "cannot update seq_id")
+	}
+	if err := conn.QueryRowContext(ctx, fmt.Sprintf("select seq_id from %s where id = 0", tableName)).Scan(&seqID); err != nil {
+		return 0, errors.Wrap(err, "cannot select seq_id")
+	}
+	return seqID, nil
+}
+
+// NextSequenceIDBlock advances sequencer by size and returns last id of allocated block
+func (adapter *SQLiteAdapter) NextSequenceIDBlock(ctx context.Context, conn *sql.DB, tableName string, size int64) (int64, bool, error) {
+	var seqID int64
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("update %s set seq_id = seq_id + %d where id = 0", tableName, size)); err != nil {
+		return 0, false, errors.Wrap(err, "cannot update seq_id")
+	}
+	if err := conn.QueryRowContext(ctx, fmt.Sprintf("select seq_id from %s where id = 0", tableName)).Scan(&seqID); err != nil {
+		return 0, false, errors.Wrap(err, "cannot select seq_id")
+	}
+	return seqID, true, nil
+}
+
+// ExecDDL do nothing
+func (adapter *SQLiteAdapter) ExecDDL(config *config.DatabaseConfig) error {
+	return nil
+}
+
+// OpenConnection open connection by database configuration file.
+// If database is ':memory:' or 'file::memory:?cache=shared&name=shard1', open in-memory database.
+// Named in-memory database is kept until connections opened for it are released by ReleaseConnection.
+func (adapter *SQLiteAdapter) OpenConnection(config *config.DatabaseConfig, queryValues string) (*sql.DB, error) {
+	filePath := config.NameOrPath
+	memoryName := ""
+	if isMemoryDatabase(filePath) {
+		dsn, name, err := memoryDSN(filePath)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		filePath = dsn
+		memoryName = name
+	} else if params := config.URLParams(); params != "" {
+		// parameters of url like '_busy_timeout=5000' are passed to driver
+		filePath = filePath + "?" + params
+	}
+	debug.Printf("open connection %s", filePath)
+	conn, err := sql.Open(config.Adapter, filePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open connection from %s", filePath)
+	}
+	if memoryName != "" {
+		if err := keepMemoryDatabase(config.Adapter, memoryName, filePath, conn); err != nil {
+			conn.Close()
+			return nil, errors.WithStack(err)
+		}
+	}
+	return conn, nil
+}
+
+// ReleaseConnection discards named in-memory database if conn is the last connection opened for it
+func (adapter *SQLiteAdapter) ReleaseConnection(conn *sql.DB) error {
+	return errors.WithStack(releaseMemoryDatabase(conn))
+}
+
+// CreateSequencerTableIfNotExists create table for sequencer if not exists
+func (adapter *SQLiteAdapter) CreateSequencerTableIfNotExists(conn *sql.DB, tableName string) error {
+	_, err := conn.Exec(fmt.Sprintf("create table if not exists %s (id integer not null primary key autoincrement, seq_id integer not null)", tableName))
+	return errors.Wrap(err, "cannot create table for sequencer")
+}
+
+// InsertRowToSequencerIfNotExists insert first row to sequencer if not exists
+func (adapter *SQLiteAdapter) InsertRowToSequencerIfNotExists(conn *sql.DB, tableName string) error {
+	_, err := conn.Exec(fmt.Sprintf("insert into %s(id, seq_id) values (0, 1)", tableName))
+	return errors.Wrap(err, "cannot insert new row for sequncer")
+}
+
+// TableSchema returns CREATE TABLE statement stored in sqlite_master
+func (adapter *SQLiteAdapter) TableSchema(conn *sql.DB, tableName string) (string, error) {
+	var schema string
+	err := conn.QueryRow("select sql from sqlite_master where type = 'table' and name = ?", tableName).Scan(&schema)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot get schema of %s", tableName)
+	}
+	return schema, nil
+}
+
+// IgnoreDuplicateClause returns modifier for INSERT OR IGNORE
+func (adapter *SQLiteAdapter) IgnoreDuplicateClause() (string, string) {
+	return "or ignore", ""
+}
+
+// DropTemporaryTableQuery returns DROP TABLE for temp schema not to drop normal table of the same name
+func (adapter *SQLiteAdapter) DropTemporaryTableQuery(tableName string) (string, bool) {
+	return fmt.Sprintf("drop table if exists temp.`%s`", tableName), true
+}
+
+// LockClause removes locking clause because SQLite doesn't lock rows ( whole database is locked by transaction writing it ).
+// 'NOWAIT' and 'SKIP LOCKED' are not supported
+func (adapter *SQLiteAdapter) LockClause(lock string) (string, bool) {
+	if strings.HasSuffix(lock, "nowait") || strings.HasSuffix(lock, "skip locked") {
+		return "", false
+	}
+	return "", true
+}
+
+// AnalyzeTable updates statistics of table stored in sqlite_stat1 by ANALYZE. SQLite reports no messages
+func (adapter *SQLiteAdapter) AnalyzeTable(ctx context.Context, conn *sql.DB, tableName string) ([]string, bool, error) {
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("analyze `%s`", tableName)); err != nil {
+		return nil, false, errors.Wrapf(err, "cannot analyze table %s", tableName)
+	}
+	return []string{}, true, nil
+}
+
+// ErrorClass classifies busy or locked database as transient error for retry
+func (adapter *SQLiteAdapter) ErrorClass(err error) string {
+	if sqliteErr, ok := errors.Cause(err).(sqlite3.Error); ok {
+		if sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked {
+			return config.RetryOnLockWaitTimeout
+		}
+	}
+	return ""
+}
+
+// Capabilities returns features supported by driver
+func (*SQLiteAdapter) Capabilities() *adapter.Capabilities {
+	return &adapter.Capabilities{SupportsReturning: true}
+}
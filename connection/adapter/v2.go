@@ -0,0 +1,268 @@
+package adapter
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/debug"
+)
+
+// ConnectionOptions options for opening connection by DBAdapterV2
+type ConnectionOptions struct {
+	// query string appended to DSN like 'parseTime=true'
+	QueryString string
+}
+
+// Capabilities features supported by database driver.
+type Capabilities struct {
+	// support RETURNING clause for INSERT/UPDATE/DELETE
+	SupportsReturning bool
+	// support XA transaction
+	SupportsXA bool
+	// driver doesn't support LastInsertId() of sql.Result, so RETURNING clause is required for getting last inserted id
+	RequiresReturningID bool
+}
+
+// CapabilitiesAdapter the optional interface for DBAdapter that reports features supported by database driver.
+type CapabilitiesAdapter interface {
+	Capabilities() *Capabilities
+}
+
+// DBAdapterV2 is a context-aware version of DBAdapter.
+//
+// New adapter should implement this and call adapter.RegisterV2("adapter_name", &NewAdapterStructure{}).
+// Adapter registered by RegisterV2 is also available as DBAdapter, and DBAdapter registered by Register is also available as DBAdapterV2.
+type DBAdapterV2 interface {
+	// get current unique id for all shards by sequencer
+	CurrentSequenceID(ctx context.Context, conn *sql.DB, tableName string) (int64, error)
+
+	// get next unique id for all shards by sequencer
+	NextSequenceID(ctx context.Context, conn *sql.DB, tableName string) (int64, error)
+
+	// create database if not exists by database configuration file.
+	ExecDDL(ctx context.Context, config *config.DatabaseConfig) error
+
+	// open connection by database configuration file
+	OpenConnection(ctx context.Context, config *config.DatabaseConfig, opts *ConnectionOptions) (*sql.DB, error)
+
+	// create table for sequencer if not exists
+	CreateSequencerTableIfNotExists(ctx context.Context, conn *sql.DB, tableName string) error
+
+	// insert first row to sequencer if not exists
+	InsertRowToSequencerIfNotExists(ctx context.Context, conn *sql.DB, tableName string) error
+
+	// features supported by database driver
+	Capabilities() *Capabilities
+}
+
+var adaptersV2 = make(map[string]DBAdapterV2)
+
+// RegisterV2 register DBAdapterV2 with driver name
+func RegisterV2(name string, adapter DBAdapterV2) {
+	if adapter == nil {
+		panic("Register adapter is nil")
+	}
+	Register(name, &v1Adapter{adapter: adapter})
+	adaptersMu.Lock()
+	defer adaptersMu.Unlock()
+	adaptersV2[name] = adapter
+}
+
+// AdapterV2 get DBAdapterV2 by driver name.
+// If adapter is registered by Register, returns it wrapped by DBAdapterV2.
+func AdapterV2(name string) (DBAdapterV2, error) {
+	adaptersMu.RLock()
+	defer adaptersMu.RUnlock()
+	if adapter := adaptersV2[name]; adapter != nil {
+		return adapter, nil
+	}
+	adapter := adapters[name]
+	if adapter == nil {
+		return nil, errors.Errorf("unknown adapter name %s", name)
+	}
+	debug.Printf("adapter %s is used by DBAdapterV2 interface through compatibility layer", name)
+	return &v2Adapter{adapter: adapter}, nil
+}
+
+// CapabilitiesOf returns features supported by database driver of adapter.
+func CapabilitiesOf(adapter DBAdapter) *Capabilities {
+	if capabilitiesAdapter, ok := adapter.(CapabilitiesAdapter); ok {
+		if capabilities := capabilitiesAdapter.Capabilities(); capabilities != nil {
+			return capabilities
+		}
+	}
+	capabilities := &Capabilities{}
+	if returningIDAdapter, ok := adapter.(ReturningIDAdapter); ok && returningIDAdapter.IsRequiredReturningID() {
+		capabilities.SupportsReturning = true
+		capabilities.RequiresReturningID = true
+	}
+	return capabilities
+}
+
+// v2Adapter wraps DBAdapter as DBAdapterV2
+type v2Adapter struct {
+	adapter DBAdapter
+}
+
+func (a *v2Adapter) CurrentSequenceID(ctx context.Context, conn *sql.DB, tableName string) (int64, error) {
+	if adapter, ok := a.adapter.(ContextSequencerAdapter); ok {
+		return adapter.CurrentSequenceIDContext(ctx, conn, tableName)
+	}
+	return a.adapter.CurrentSequenceID(conn, tableName)
+}
+
+func (a *v2Adapter) NextSequenceID(ctx context.Context, conn *sql.DB, tableName string) (int64, error) {
+	if adapter, ok := a.adapter.(ContextSequencerAdapter); ok {
+		return adapter.NextSequenceIDContext(ctx, conn, tableName)
+	}
+	return a.adapter.NextSequenceID(conn, tableName)
+}
+
+func (a *v2Adapter) ExecDDL(ctx context.Context, config *config.DatabaseConfig) error {
+	return a.adapter.ExecDDL(config)
+}
+
+func (a *v2Adapter) OpenConnection(ctx context.Context, config *config.DatabaseConfig, opts *ConnectionOptions) (*sql.DB, error) {
+	queryString := ""
+	if opts != nil {
+		queryString = opts.QueryString
+	}
+	return a.adapter.OpenConnection(config, queryString)
+}
+
+func (a *v2Adapter) CreateSequencerTableIfNotExists(ctx context.Context, conn *sql.DB, tableName string) error {
+	return a.adapter.CreateSequencerTableIfNotExists(conn, tableName)
+}
+
+func (a *v2Adapter) InsertRowToSequencerIfNotExists(ctx context.Context, conn *sql.DB, tableName string) error {
+	return a.adapter.InsertRowToSequencerIfNotExists(conn, tableName)
+}
+
+func (a *v2Adapter) Capabilities() *Capabilities {
+	return CapabilitiesOf(a.adapter)
+}
+
+// v1Adapter wraps DBAdapterV2 as DBAdapter
+type v1Adapter struct {
+	adapter DBAdapterV2
+}
+
+func (a *v1Adapter) CurrentSequenceID(conn *sql.DB, tableName string) (int64, error) {
+	return a.adapter.CurrentSequenceID(context.Background(), conn, tableName)
+}
+
+func (a *v1Adapter) NextSequenceID(conn *sql.DB, tableName string) (int64, error) {
+	return a.adapter.NextSequenceID(context.Background(), conn, tableName)
+}
+
+func (a *v1Adapter) CurrentSequenceIDContext(ctx context.Context, conn *sql.DB, tableName string) (int64, error) {
+	return a.adapter.CurrentSequenceID(ctx, conn, tableName)
+}
+
+func (a *v1Adapter) NextSequenceIDContext(ctx context.Context, conn *sql.DB, tableName string) (int64, error) {
+	return a.adapter.NextSequenceID(ctx, conn, tableName)
+}
+
+func (a *v1Adapter) ExecDDL(config *config.DatabaseConfig) error {
+	return a.adapter.ExecDDL(context.Background(), config)
+}
+
+func (a *v1Adapter) OpenConnection(config *config.DatabaseConfig, queryString string) (*sql.DB, error) {
+	return a.adapter.OpenConnection(context.Background(), config, &ConnectionOptions{QueryString: queryString})
+}
+
+func (a *v1Adapter) CreateSequencerTableIfNotExists(conn *sql.DB, tableName string) error {
+	return a.adapter.CreateSequencerTableIfNotExists(context.Background(), conn, tableName)
+}
+
+func (a *v1Adapter) InsertRowToSequencerIfNotExists(conn *sql.DB, tableName string) error {
+	return a.adapter.InsertRowToSequencerIfNotExists(context.Background(), conn, tableName)
+}
+
+func (a *v1Adapter) IsRequiredReturningID() bool {
+	capabilities := a.adapter.Capabilities()
+	return capabilities != nil && capabilities.RequiresReturningID
+}
+
+func (a *v1Adapter) Capabilities() *Capabilities {
+	return a.adapter.Capabilities()
+}
+
+func (a *v1Adapter) AutoIncrementOffsetQueryString(increment int, offset int) (string, bool) {
+	if adapter, ok := a.adapter.(AutoIncrementOffsetAdapter); ok {
+		return adapter.AutoIncrementOffsetQueryString(increment, offset)
+	}
+	return "", false
+}
+
+func (a *v1Adapter) SerializeValue(value interface{}) (string, bool, bool) {
+	if adapter, ok := a.adapter.(ValueSerializerAdapter); ok {
+		return adapter.SerializeValue(value)
+	}
+	return "", false, false
+}
+
+func (a *v1Adapter) CurrentReplicationPosition(ctx context.Context, conn *sql.DB) (string, bool, error) {
+	if adapter, ok := a.adapter.(ReplicationPositionAdapter); ok {
+		return adapter.CurrentReplicationPosition(ctx, conn)
+	}
+	return "", false, nil
+}
+
+func (a *v1Adapter) WaitForReplicationPosition(ctx context.Context, conn *sql.DB, position string) error {
+	if adapter, ok := a.adapter.(ReplicationPositionAdapter); ok {
+		return adapter.WaitForReplicationPosition(ctx, conn, position)
+	}
+	return errors.New("adapter doesn't support replication position")
+}
+
+func (a *v1Adapter) NextSequenceIDBlock(ctx context.Context, conn *sql.DB, tableName string, size int64) (int64, bool, error) {
+	if adapter, ok := a.adapter.(SequenceBlockAdapter); ok {
+		return adapter.NextSequenceIDBlock(ctx, conn, tableName, size)
+	}
+	return 0, false, nil
+}
+
+func (a *v1Adapter) MaintainSequencer(ctx context.Context, conn *sql.DB, tableName string) ([]string, bool, error) {
+	if adapter, ok := a.adapter.(SequencerMaintenanceAdapter); ok {
+		return adapter.MaintainSequencer(ctx, conn, tableName)
+	}
+	return nil, false, nil
+}
+
+func (a *v1Adapter) AnalyzeTable(ctx context.Context, conn *sql.DB, tableName string) ([]string, bool, error) {
+	if adapter, ok := a.adapter.(TableAnalyzeAdapter); ok {
+		return adapter.AnalyzeTable(ctx, conn, tableName)
+	}
+	return nil, false, nil
+}
+
+func (a *v1Adapter) EstimateRows(ctx context.Context, conn *sql.DB, query string, args ...interface{}) (int64, bool, error) {
+	if adapter, ok := a.adapter.(RowEstimateAdapter); ok {
+		return adapter.EstimateRows(ctx, conn, query, args...)
+	}
+	return 0, false, nil
+}
+
+func (a *v1Adapter) DropTemporaryTableQuery(tableName string) (string, bool) {
+	if adapter, ok := a.adapter.(TemporaryTableAdapter); ok {
+		return adapter.DropTemporaryTableQuery(tableName)
+	}
+	return "", false
+}
+
+func (a *v1Adapter) LockClause(lock string) (string, bool) {
+	if adapter, ok := a.adapter.(LockingReadAdapter); ok {
+		return adapter.LockClause(lock)
+	}
+	return lock, true
+}
+
+func (a *v1Adapter) ErrorClass(err error) string {
+	if adapter, ok := a.adapter.(ErrorClassifierAdapter); ok {
+		return adapter.ErrorClass(err)
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+package connection
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	adap "go.knocknote.io/octillery/connection/adapter"
+)
+
+// TableAnalysis result of ANALYZE of a table on a shard
+type TableAnalysis struct {
+	// table name
+	TableName string
+	// shard name defined in configuration file. empty if table is not sharded
+	ShardName string
+	// DSN of shard
+	DSN string
+	// whether adapter analyzes table. if false, adapter doesn't support it
+	Analyzed bool
+	// messages reported by database
+	Messages []string
+	// time spent by ANALYZE
+	Duration time.Duration
+	// error of ANALYZE
+	Err error
+}
+
+// AnalyzeTable updates statistics of table on all shards if adapter implements TableAnalyzeAdapter.
+// concurrency is number of shards analyzed at the same time ( 0 or 1 means sequential ).
+// Error of each shard is set to TableAnalysis, so the other shards are analyzed. Results are ordered by shards in configuration file.
+func (c *DBConnection) AnalyzeTable(ctx context.Context, tableName string, concurrency int) ([]*TableAnalysis, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	adapter, supported := c.Adapter.(adap.TableAnalyzeAdapter)
+	shards := c.Shards()
+	results := make([]*TableAnalysis, len(shards))
+	indexByShardName := map[string]int{}
+	for idx, shard := range shards {
+		indexByShardName[shard.ShardName] = idx
+		results[idx] = &TableAnalysis{
+			TableName: tableName,
+			ShardName: shard.ShardName,
+			DSN:       shard.DSN(),
+		}
+	}
+	processed := make([]bool, len(shards))
+	err := c.ForEachShard(func(shard *DBShardConnection) error {
+		idx := indexByShardName[shard.ShardName]
+		processed[idx] = true
+		if !supported {
+			return nil
+		}
+		result := results[idx]
+		start := time.Now()
+		messages, ok, err := adapter.AnalyzeTable(ctx, shard.Connection, tableName)
+		result.Duration = time.Since(start)
+		result.Analyzed = ok
+		result.Messages = messages
+		result.Err = errors.WithStack(err)
+		return nil
+	}, &ForEachShardOptions{Concurrency: concurrency, Context: ctx})
+	if err != nil {
+		// shards skipped by cancellation of context are reported as error
+		for idx, result := range results {
+			if !processed[idx] {
+				result.Err = errors.Wrapf(ctx.Err(), "cancelled before analyzing %s", result.ShardName)
+			}
+		}
+		return results, errors.WithStack(err)
+	}
+	return results, nil
+}
+
+// AnalyzeTables updates statistics of tables on all shards in order of tableNames.
+// If tableNames is empty, all tables in configuration file are analyzed in order of table name.
+// If context is done, returns results of shards analyzed already with error.
+func (cm *DBConnectionManager) AnalyzeTables(ctx context.Context, tableNames []string, concurrency int) ([]*TableAnalysis, error) {
+	if len(tableNames) == 0 {
+		cfg := getGlobalConfig()
+		if cfg == nil {
+			return nil, errors.New("cannot analyze tables. config is not loaded")
+		}
+		for tableName := range cfg.Tables {
+			tableNames = append(tableNames, tableName)
+		}
+		sort.Strings(tableNames)
+	}
+	results := []*TableAnalysis{}
+	for _, tableName := range tableNames {
+		conn, err := cm.ConnectionByTableName(tableName)
+		if err != nil {
+			return results, errors.WithStack(err)
+		}
+		analyses, err := conn.AnalyzeTable(ctx, tableName, concurrency)
+		results = append(results, analyses...)
+		if err != nil {
+			return results, errors.Wrapf(err, "cannot analyze %s", tableName)
+		}
+	}
+	return results, nil
+}
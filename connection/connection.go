@@ -4,19 +4,37 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"math/rand"
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
 	"go.knocknote.io/octillery/algorithm"
 	"go.knocknote.io/octillery/config"
 	adap "go.knocknote.io/octillery/connection/adapter"
+	"go.knocknote.io/octillery/idgen"
+	"go.knocknote.io/octillery/metrics"
+	"go.knocknote.io/octillery/warning"
 )
 
+// globalConfig holds *config.Config set by SetConfig. it is replaced while queries are executed by reloading configuration
+var globalConfig atomic.Value
+
+func getGlobalConfig() *config.Config {
+	cfg, _ := globalConfig.Load().(*config.Config)
+	return cfg
+}
+
+func setGlobalConfig(cfg *config.Config) {
+	globalConfig.Store(cfg)
+}
+
 var (
-	globalConfig *config.Config
+	// ErrTransactionShardsLimitExceeded returned when transaction accesses databases more than max_transaction_shards
+	ErrTransactionShardsLimitExceeded = errors.New("transaction error. number of databases accessed by same Tx instance exceeds max_transaction_shards")
 )
 
 // QueryLog type for storing information of executed query
@@ -24,21 +42,28 @@ type QueryLog struct {
 	Query        string        `json:"query"`
 	Args         []interface{} `json:"args"`
 	LastInsertID int64         `json:"lastInsertId"`
+	// DSN of database the write query is executed on
+	DSN string `json:"dsn,omitempty"`
+	// replication position of database after commit ( e.g. GTID of MySQL ). set only if 'capture_replication_position' is enabled
+	ReplicationPosition string `json:"replicationPosition,omitempty"`
 }
 
 // Connection common interface for DBConnection and DBShardConnection
 type Connection interface {
 	DSN() string
 	Conn() *sql.DB
+	ReadConn() *sql.DB
 }
 
 // DBShardConnection has connection to sharded database.
 type DBShardConnection struct {
-	ShardName  string
-	Connection *sql.DB
-	Masters    []*sql.DB
-	Slaves     []*sql.DB
-	dsn        string
+	ShardName      string
+	Connection     *sql.DB
+	Masters        []*sql.DB
+	Slaves         []*sql.DB
+	dsn            string
+	slaveBalancing string
+	slaveCounter   uint32
 }
 
 // DSN returns DSN for shard
@@ -55,6 +80,8 @@ func (c *DBShardConnection) Conn() *sql.DB {
 type DBShardConnections struct {
 	connMap  map[string]*DBShardConnection
 	connList []*DBShardConnection
+	// connections passed to sharding algorithm. if nil, connections of all shards are used
+	shardingConns []*sql.DB
 }
 
 func (c *DBShardConnections) addConnection(conn *DBShardConnection) {
@@ -83,16 +110,12 @@ func (c *DBShardConnections) ShardConnectionByIndex(shardIndex int) *DBShardConn
 
 // Close close all database connections for shards
 func (c *DBShardConnections) Close() error {
-	var errs []string
+	errs := &MultiError{}
 	for _, conn := range c.connList {
-		if err := closeConn(conn.Connection); err != nil {
-			errs = append(errs, err.Error())
-		}
-	}
-	if len(errs) > 0 {
-		return errors.New(strings.Join(errs, ":"))
+		errs.AddShardError(conn.ShardName, conn.DSN(), closeConn(conn.Connection))
+		errs.AddShardError(conn.ShardName, conn.DSN(), closeSlaves(conn.Slaves))
 	}
-	return nil
+	return errs.ErrorOrNil()
 }
 
 // ShardNum returns number of shards
@@ -113,17 +136,39 @@ type DBConnection struct {
 	IsShard            bool
 	IsUsedSequencer    bool
 	Connection         *sql.DB
+	Slaves             []*sql.DB
 	Sequencer          *sql.DB
+	IDGenerator        idgen.IDGenerator
 	ShardKeyColumnName string
 	ShardColumnName    string
 	ShardConnections   *DBShardConnections
+	sequencerCounter   uint32
+	slaveCounter       uint32
+	// ids allocated from sequencer by 'sequencer_cache_size'. nil if it is not enabled
+	sequenceBlocks *sequenceBlockCache
+	// connections to sequencer and its replicas in order of configuration. Sequencer is the one at sequencerIndex
+	sequencers     []*sql.DB
+	sequencerIndex int
+	// hook of connection manager opened this connection
+	queryHook *queryHookHolder
+	// interceptor of connection manager deciding shards of queries
+	routingInterceptor *routingInterceptorHolder
+	// table name of this connection and handler of connection manager for failover of its masters
+	tableName string
+	failover  *failoverHandler
 }
 
 // TxConnection manage transaction
 type TxConnection struct {
 	dsnList                    []string
 	dsnToTx                    map[string]*sql.Tx
+	dsnToConn                  map[string]Connection
 	txToWriteQueries           map[*sql.Tx][]*QueryLog
+	adapter                    adap.DBAdapter
+	isCommitted                bool
+	committedPositions         map[string]string
+	savepoints                 []*savepoint
+	temporaryTables            map[string]Connection
 	ctx                        context.Context
 	opts                       *sql.TxOptions
 	WriteQueries               []*QueryLog
@@ -133,10 +178,22 @@ type TxConnection struct {
 	AfterCommitFailureCallback func(bool, []*QueryLog) error
 }
 
-func (c *TxConnection) beginIfNotInitialized(conn Connection) error {
+// context returns ctx of each query. If it is nil, context of transaction is used instead
+func (c *TxConnection) context(ctx context.Context) context.Context {
+	if ctx == nil {
+		return c.ctx
+	}
+	return ctx
+}
+
+func (c *TxConnection) beginIfNotInitialized(ctx context.Context, conn Connection) error {
+	if ctx != nil && ctx.Err() != nil {
+		return errors.WithStack(ctx.Err())
+	}
 	dsn := conn.DSN()
 	tx := c.dsnToTx[dsn]
-	if !globalConfig.DistributedTransaction {
+	cfg := getGlobalConfig()
+	if !cfg.DistributedTransaction {
 		entries := len(c.dsnToTx)
 		if entries > 0 && tx == nil {
 			return errors.New("transaction error. cannot access other database by same Tx instance")
@@ -145,6 +202,9 @@ func (c *TxConnection) beginIfNotInitialized(conn Connection) error {
 	if tx != nil {
 		return nil
 	}
+	if max := cfg.MaxTransactionShards; max > 0 && len(c.dsnToTx) >= max {
+		return errors.Wrapf(ErrTransactionShardsLimitExceeded, "cannot begin transaction to %s. max_transaction_shards is %d", dsn, max)
+	}
 	newTx, err := func() (*sql.Tx, error) {
 		if c.ctx != nil {
 			return conn.Conn().BeginTx(c.ctx, c.opts)
@@ -154,14 +214,20 @@ func (c *TxConnection) beginIfNotInitialized(conn Connection) error {
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	if err := c.restoreSavepoints(ctx, newTx); err != nil {
+		newTx.Rollback()
+		return errors.WithStack(err)
+	}
 	c.dsnList = append(c.dsnList, dsn)
 	c.dsnToTx[dsn] = newTx
+	c.dsnToConn[dsn] = conn
 	return nil
 }
 
 // Prepare executes `Prepare` with transaction.
 func (c *TxConnection) Prepare(ctx context.Context, conn Connection, query string) (*sql.Stmt, error) {
-	if err := c.beginIfNotInitialized(conn); err != nil {
+	ctx = c.context(ctx)
+	if err := c.beginIfNotInitialized(ctx, conn); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	tx := c.dsnToTx[conn.DSN()]
@@ -186,6 +252,7 @@ func (c *TxConnection) AddWriteQuery(conn Connection, result sql.Result, query s
 		Query:        query,
 		Args:         args,
 		LastInsertID: id,
+		DSN:          conn.DSN(),
 	}
 	tx := c.dsnToTx[conn.DSN()]
 	c.txToWriteQueries[tx] = append(c.txToWriteQueries[tx], queryLog)
@@ -202,7 +269,8 @@ func (c *TxConnection) AddReadQuery(query string, args ...interface{}) {
 
 // Stmt executes `Stmt` with transaction.
 func (c *TxConnection) Stmt(ctx context.Context, conn Connection, stmt *sql.Stmt) (*sql.Stmt, error) {
-	if err := c.beginIfNotInitialized(conn); err != nil {
+	ctx = c.context(ctx)
+	if err := c.beginIfNotInitialized(ctx, conn); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	tx := c.dsnToTx[conn.DSN()]
@@ -214,7 +282,8 @@ func (c *TxConnection) Stmt(ctx context.Context, conn Connection, stmt *sql.Stmt
 
 // QueryRow executes `QueryRow` with transaction.
 func (c *TxConnection) QueryRow(ctx context.Context, conn Connection, query string, args ...interface{}) (*sql.Row, error) {
-	if err := c.beginIfNotInitialized(conn); err != nil {
+	ctx = c.context(ctx)
+	if err := c.beginIfNotInitialized(ctx, conn); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	tx := c.dsnToTx[conn.DSN()]
@@ -232,18 +301,18 @@ func (c *TxConnection) QueryRow(ctx context.Context, conn Connection, query stri
 }
 
 // Query executes `Query` with transaction.
+// If it is the first query to database of conn in transaction, failure by transient error is retried by global 'retry' in configuration file.
 func (c *TxConnection) Query(ctx context.Context, conn Connection, query string, args ...interface{}) (*sql.Rows, error) {
-	if err := c.beginIfNotInitialized(conn); err != nil {
-		return nil, errors.WithStack(err)
-	}
-	tx := c.dsnToTx[conn.DSN()]
-	rows, err := func() (*sql.Rows, error) {
+	ctx = c.context(ctx)
+	var rows *sql.Rows
+	if err := c.readWithRetry(ctx, conn, func(tx *sql.Tx) (err error) {
 		if ctx == nil {
-			return tx.Query(query, args...)
+			rows, err = tx.Query(query, args...)
+		} else {
+			rows, err = tx.QueryContext(ctx, query, args...)
 		}
-		return tx.QueryContext(ctx, query, args...)
-	}()
-	if err != nil {
+		return err
+	}); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	c.ReadQueries = append(c.ReadQueries, &QueryLog{
@@ -253,9 +322,46 @@ func (c *TxConnection) Query(ctx context.Context, conn Connection, query string,
 	return rows, nil
 }
 
+// readWithRetry begins transaction to database of conn if it is not begun yet, and calls fn with it.
+// If transaction is begun by this call, nothing is done in it yet, so failure of fn by transient error is retried by new transaction.
+func (c *TxConnection) readWithRetry(ctx context.Context, conn Connection, fn func(*sql.Tx) error) error {
+	dsn := conn.DSN()
+	if tx := c.dsnToTx[dsn]; tx != nil {
+		return fn(tx)
+	}
+	var retry *config.RetryConfig
+	if cfg := getGlobalConfig(); cfg != nil {
+		retry = cfg.RetryConfig
+	}
+	return retryQuery(ctx, retry, c.adapter, false, func() error {
+		if err := c.beginIfNotInitialized(ctx, conn); err != nil {
+			return errors.WithStack(err)
+		}
+		if err := fn(c.dsnToTx[dsn]); err != nil {
+			c.discardTx(dsn)
+			return err
+		}
+		return nil
+	})
+}
+
+// discardTx rolls back transaction to dsn that has done nothing, and forgets it
+func (c *TxConnection) discardTx(dsn string) {
+	c.dsnToTx[dsn].Rollback()
+	delete(c.dsnToTx, dsn)
+	delete(c.dsnToConn, dsn)
+	for idx, d := range c.dsnList {
+		if d == dsn {
+			c.dsnList = append(c.dsnList[:idx], c.dsnList[idx+1:]...)
+			break
+		}
+	}
+}
+
 // Exec executes `Exec` with transaction.
 func (c *TxConnection) Exec(ctx context.Context, conn Connection, query string, args ...interface{}) (sql.Result, error) {
-	if err := c.beginIfNotInitialized(conn); err != nil {
+	ctx = c.context(ctx)
+	if err := c.beginIfNotInitialized(ctx, conn); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	tx := c.dsnToTx[conn.DSN()]
@@ -276,6 +382,7 @@ func (c *TxConnection) Exec(ctx context.Context, conn Connection, query string,
 		Query:        query,
 		Args:         args,
 		LastInsertID: id,
+		DSN:          conn.DSN(),
 	}
 	c.txToWriteQueries[tx] = append(c.txToWriteQueries[tx], queryLog)
 	c.WriteQueries = append(c.WriteQueries, queryLog)
@@ -293,6 +400,9 @@ func (c *TxConnection) Commit() (e error) {
 	if err := c.BeforeCommitCallback(); err != nil {
 		return errors.WithStack(err)
 	}
+	if err := c.dropTemporaryTables().ErrorOrNil(); err != nil {
+		return errors.WithStack(err)
+	}
 	committedWriteQueryNum := 0
 	failedWriteQueries := []*QueryLog{}
 	isCriticalError := false
@@ -309,28 +419,54 @@ func (c *TxConnection) Commit() (e error) {
 		}
 	}()
 
-	errs := []string{}
+	errs := &MultiError{}
 	for _, dsn := range c.dsnList {
 		tx := c.dsnToTx[dsn]
 		if err := tx.Commit(); err != nil {
 			failedWriteQueries = append(failedWriteQueries, c.txToWriteQueries[tx]...)
+			metrics.RecordCommitFailure(dsn, committedWriteQueryNum > 0)
 			if committedWriteQueryNum > 0 {
 				// distributed transaction error
 				isCriticalError = true
-				errs = append(errs, errors.Wrapf(err, "cannot commit to %s", dsn).Error())
+				errs.AddShardError("", dsn, errors.Wrapf(err, "cannot commit to %s", dsn))
 			} else {
 				return errors.Wrapf(err, "cannot commit to %s", dsn)
 			}
 		} else {
 			committedWriteQueryNum += len(c.txToWriteQueries[tx])
+			c.captureReplicationPosition(dsn, tx)
 		}
 	}
-	if len(errs) > 0 {
-		return errors.New(strings.Join(errs, ":"))
+	if err := errs.ErrorOrNil(); err != nil {
+		return err
 	}
+	c.isCommitted = true
 	return nil
 }
 
+// captureReplicationPosition attaches replication position of database after commit to write queries of transaction.
+// position may include other transactions committed at the same time, so it is a position at or after the commit.
+func (c *TxConnection) captureReplicationPosition(dsn string, tx *sql.Tx) {
+	if !getGlobalConfig().CaptureReplicationPosition || len(c.txToWriteQueries[tx]) == 0 {
+		return
+	}
+	position, err := replicationPosition(context.Background(), c.adapter, c.dsnToConn[dsn].Conn())
+	if err != nil {
+		warning.Warn(&warning.Warning{
+			Code:    warning.ReplicationPositionUnavailable,
+			Message: fmt.Sprintf("cannot capture replication position of %s after commit: %s", dsn, err),
+		})
+		return
+	}
+	if c.committedPositions == nil {
+		c.committedPositions = map[string]string{}
+	}
+	c.committedPositions[dsn] = position
+	for _, queryLog := range c.txToWriteQueries[tx] {
+		queryLog.ReplicationPosition = position
+	}
+}
+
 // Rollback executes `Rollback` with transaction.
 func (c *TxConnection) Rollback() error {
 	if c == nil {
@@ -339,16 +475,12 @@ func (c *TxConnection) Rollback() error {
 	if len(c.dsnToTx) == 0 {
 		return nil
 	}
-	errs := []string{}
-	for _, tx := range c.dsnToTx {
-		if err := tx.Rollback(); err != nil {
-			errs = append(errs, err.Error())
-		}
-	}
-	if len(errs) > 0 {
-		return errors.New(strings.Join(errs, ":"))
+	// temporary table of MySQL is not dropped by rollback
+	errs := c.dropTemporaryTables()
+	for dsn, tx := range c.dsnToTx {
+		errs.AddShardError("", dsn, tx.Rollback())
 	}
-	return nil
+	return errs.ErrorOrNil()
 }
 
 // DSN returns DSN for not sharded database
@@ -365,12 +497,19 @@ func (c *DBConnection) Conn() *sql.DB {
 	return c.Connection
 }
 
+// Capabilities returns features supported by database driver of this connection
+func (c *DBConnection) Capabilities() *adap.Capabilities {
+	return adap.CapabilitiesOf(c.Adapter)
+}
+
 // Begin creates TxConnection instance for transaction.
 func (c *DBConnection) Begin(ctx context.Context, opts *sql.TxOptions) *TxConnection {
 	return &TxConnection{
 		dsnList:                    []string{},
 		dsnToTx:                    map[string]*sql.Tx{},
+		dsnToConn:                  map[string]Connection{},
 		txToWriteQueries:           map[*sql.Tx][]*QueryLog{},
+		adapter:                    c.Adapter,
 		ctx:                        ctx,
 		opts:                       opts,
 		BeforeCommitCallback:       func() error { return nil },
@@ -379,14 +518,6 @@ func (c *DBConnection) Begin(ctx context.Context, opts *sql.TxOptions) *TxConnec
 	}
 }
 
-// NextSequenceID returns next unique id by sequencer table name.
-func (c *DBConnection) NextSequenceID(tableName string) (int64, error) {
-	if c.Sequencer == nil {
-		return 0, errors.New("cannot get next sequence id")
-	}
-	return c.Adapter.NextSequenceID(c.Sequencer, sequencerTableName(tableName))
-}
-
 // IsEqualShardColumnToShardKeyColumn returns whether shard_column value equals to shard_key value or not.
 func (c *DBConnection) IsEqualShardColumnToShardKeyColumn() bool {
 	if c.ShardKeyColumnName == "" {
@@ -403,6 +534,9 @@ func (c *DBConnection) ShardConnectionByID(id int64) (*DBShardConnection, error)
 		connMap[shardConn.Connection] = shardConn
 		conns = append(conns, shardConn.Connection)
 	}
+	if c.ShardConnections.shardingConns != nil {
+		conns = c.ShardConnections.shardingConns
+	}
 	dbConn, err := c.Algorithm.Shard(conns, id)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -451,6 +585,30 @@ func (c *DBConnection) EqualDSN(conn *DBConnection) bool {
 	return true
 }
 
+// ReadQuery executes `Query` (not shards) on slave if 'read_from_slave' is enabled.
+func (c *DBConnection) ReadQuery(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if ctx == nil {
+		rows, err := c.ReadConn().Query(query, args...)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return rows, nil
+	}
+	rows, err := c.ReadConn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return rows, nil
+}
+
+// ReadQueryRow executes `QueryRow` (not shards) on slave if 'read_from_slave' is enabled.
+func (c *DBConnection) ReadQueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if ctx == nil {
+		return c.ReadConn().QueryRow(query, args...)
+	}
+	return c.ReadConn().QueryRowContext(ctx, query, args...)
+}
+
 // Query executes `Query` (not shards).
 func (c *DBConnection) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
 	if ctx == nil {
@@ -509,28 +667,65 @@ func (c *DBConnection) Exec(ctx context.Context, query string, args ...interface
 }
 
 // DBConnectionMap has all DBConnection.
+// DBConnection is read from immutable snapshot without lock, and snapshot is replaced
+// only when topology is changed ( e.g. table is opened, reloaded or credentials are rotated ).
 type DBConnectionMap struct {
-	*sync.Map
+	mu       *sync.Mutex
+	snapshot *atomic.Value
+}
+
+func newDBConnectionMap() DBConnectionMap {
+	snapshot := &atomic.Value{}
+	snapshot.Store(map[string]*DBConnection{})
+	return DBConnectionMap{mu: &sync.Mutex{}, snapshot: snapshot}
+}
+
+func (m DBConnectionMap) load() map[string]*DBConnection {
+	if m.snapshot == nil {
+		return nil
+	}
+	return m.snapshot.Load().(map[string]*DBConnection)
+}
+
+// update replaces snapshot by copy of it changed by f
+func (m DBConnectionMap) update(f func(map[string]*DBConnection)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current := m.load()
+	next := make(map[string]*DBConnection, len(current)+1)
+	for tableName, conn := range current {
+		next[tableName] = conn
+	}
+	f(next)
+	m.snapshot.Store(next)
 }
 
 // Get get DBConnection instance by table name.
 func (m DBConnectionMap) Get(tableName string) *DBConnection {
-	if conn, exists := m.Load(tableName); exists {
-		return conn.(*DBConnection)
-	}
-	return nil
+	return m.load()[tableName]
 }
 
 // Set set DBConnection instance with table name.
 func (m DBConnectionMap) Set(tableName string, conn *DBConnection) {
-	m.Store(tableName, conn)
+	m.update(func(conns map[string]*DBConnection) {
+		conns[tableName] = conn
+	})
+}
+
+// Delete delete DBConnection instance by table name.
+func (m DBConnectionMap) Delete(tableName string) {
+	m.update(func(conns map[string]*DBConnection) {
+		delete(conns, tableName)
+	})
 }
 
 // Each iterate all DBConnections.
 func (m DBConnectionMap) Each(f func(string, *DBConnection) bool) {
-	m.Range(func(k, v interface{}) bool {
-		return f(k.(string), v.(*DBConnection))
-	})
+	for tableName, conn := range m.load() {
+		if !f(tableName, conn) {
+			return
+		}
+	}
 }
 
 // DBConnectionManager has DBConnectionMap and settings to connection of database
@@ -539,7 +734,38 @@ type DBConnectionManager struct {
 	maxIdleConns    int
 	maxOpenConns    int
 	connMaxLifetime time.Duration
-	queryString     string
+	connMaxIdleTime time.Duration
+	// max duration added to connMaxLifetime randomly for each connection pool
+	connMaxLifetimeJitter time.Duration
+	queryString           string
+
+	credentialMu           sync.Mutex
+	credentialDrainTimeout time.Duration
+
+	// hook called for queries of this connection manager
+	queryHook *queryHookHolder
+
+	// interceptor deciding shards of queries of this connection manager
+	routingInterceptor *routingInterceptorHolder
+
+	// handler fails over masters of connections of this connection manager to backups
+	failover *failoverHandler
+
+	// connections of table being opened. concurrent first access to a table waits for it
+	openingMu sync.Mutex
+	opening   map[string]*openingConnection
+
+	schemaCacheMu sync.Mutex
+	schemaCache   *SchemaCache
+
+	// configuration connections are opened by instead of global configuration. set only for connections staged by Reload
+	stagedConfig *config.Config
+
+	sequenceIDCacheMu sync.Mutex
+	sequenceIDCache   *sequenceIDCache
+
+	sequencerMaintainerMu sync.Mutex
+	sequencerMaintainer   *sequencerMaintainer
 }
 
 // SetQueryString set up query string like `?parseTime=true`
@@ -559,56 +785,83 @@ func (cm *DBConnectionManager) SetQueryString(s string) error {
 // SetMaxIdleConns compatible interface of SetMaxIdleConns in 'database/sql' package
 func (cm *DBConnectionManager) SetMaxIdleConns(n int) {
 	cm.maxIdleConns = n
+	cm.applyConnectionSettings()
 }
 
 // SetMaxOpenConns compatible interface of SetMaxOpenConns in 'database/sql' package
 func (cm *DBConnectionManager) SetMaxOpenConns(n int) {
 	cm.maxOpenConns = n
+	cm.applyConnectionSettings()
 }
 
 // SetConnMaxLifetime compatible interface of SetConnMaxLifetime in 'database/sql' package
 func (cm *DBConnectionManager) SetConnMaxLifetime(d time.Duration) {
 	cm.connMaxLifetime = d
+	cm.applyConnectionSettings()
+}
+
+// SetConnMaxIdleTime compatible interface of SetConnMaxIdleTime in 'database/sql' package.
+// It is ignored if octillery is built by Go 1.14 or older.
+func (cm *DBConnectionManager) SetConnMaxIdleTime(d time.Duration) {
+	cm.connMaxIdleTime = d
+	cm.applyConnectionSettings()
+}
+
+// SetConnMaxLifetimeJitter sets max duration added to max lifetime of connections randomly for each connection pool,
+// so connections of many shards are not recycled at the same time.
+func (cm *DBConnectionManager) SetConnMaxLifetimeJitter(d time.Duration) {
+	cm.connMaxLifetimeJitter = d
+}
+
+// jitteredConnMaxLifetime returns max lifetime of connections for a connection pool
+func (cm *DBConnectionManager) jitteredConnMaxLifetime() time.Duration {
+	return cm.jitterConnMaxLifetime(cm.connMaxLifetime)
+}
+
+// jitterConnMaxLifetime adds random duration up to jitter to connMaxLifetime
+func (cm *DBConnectionManager) jitterConnMaxLifetime(connMaxLifetime time.Duration) time.Duration {
+	if connMaxLifetime <= 0 || cm.connMaxLifetimeJitter <= 0 {
+		return connMaxLifetime
+	}
+	return connMaxLifetime + time.Duration(rand.Int63n(int64(cm.connMaxLifetimeJitter)+1))
 }
 
 func closeConn(conn *sql.DB) error {
 	if conn == nil {
 		return nil
 	}
-	return conn.Close()
+	err := conn.Close()
+	if releaseErr := adap.ReleaseConnection(conn); releaseErr != nil && err == nil {
+		return errors.WithStack(releaseErr)
+	}
+	return err
 }
 
 // Close close all connections
 func (cm *DBConnectionManager) Close() error {
-	errs := []string{}
+	cm.StopSequenceIDCache()
+	cm.StopSequencerMaintenance()
+	errs := &MultiError{}
 	cm.connMap.Each(func(tableName string, conn *DBConnection) bool {
 		if conn.IsShard {
 			if conn.IsUsedSequencer {
-				if err := closeConn(conn.Sequencer); err != nil {
-					errs = append(errs, err.Error())
-				}
-			}
-			if err := conn.ShardConnections.Close(); err != nil {
-				errs = append(errs, err.Error())
+				errs.Add(errors.Wrapf(closeConns(conn.sequencerConns()), "cannot close sequencer of %s", tableName))
 			}
+			errs.Add(conn.ShardConnections.Close())
 		} else {
-			if err := closeConn(conn.Connection); err != nil {
-				errs = append(errs, err.Error())
-			}
+			errs.AddShardError("", conn.DSN(), closeConn(conn.Connection))
+			errs.AddShardError("", conn.DSN(), closeSlaves(conn.Slaves))
 		}
 		return true
 	})
-	if len(errs) > 0 {
-		return errors.New(strings.Join(errs, ":"))
-	}
-	return nil
+	return errs.ErrorOrNil()
 }
 
 // ConnectionByTableName returns DBConnection instance by table name
 func (cm *DBConnectionManager) ConnectionByTableName(tableName string) (*DBConnection, error) {
 	conn := cm.connMap.Get(tableName)
 	if conn == nil {
-		if err := cm.open(tableName); err != nil {
+		if err := cm.openOnce(tableName); err != nil {
 			return nil, errors.WithStack(err)
 		}
 		conn = cm.connMap.Get(tableName)
@@ -633,26 +886,36 @@ func (cm *DBConnectionManager) SequencerConnectionByTableName(tableName string)
 
 // CurrentSequenceID returns current unique id by table name of sequencer
 func (cm *DBConnectionManager) CurrentSequenceID(tableName string) (int64, error) {
+	return cm.CurrentSequenceIDContext(context.Background(), tableName)
+}
+
+// CurrentSequenceIDContext returns current unique id by table name of sequencer with context
+func (cm *DBConnectionManager) CurrentSequenceIDContext(ctx context.Context, tableName string) (int64, error) {
 	conn, err := cm.ConnectionByTableName(tableName)
 	if err != nil {
 		return 0, errors.WithStack(err)
 	}
-	if conn.Sequencer == nil {
+	if conn.Sequencer == nil && conn.IDGenerator == nil {
 		return 0, errors.WithStack(err)
 	}
-	return conn.Adapter.CurrentSequenceID(conn.Sequencer, sequencerTableName(tableName))
+	return conn.CurrentSequenceIDContext(ctx, tableName)
 }
 
 // NextSequenceID returns next unique id by table name of sequencer
 func (cm *DBConnectionManager) NextSequenceID(tableName string) (int64, error) {
+	return cm.NextSequenceIDContext(context.Background(), tableName)
+}
+
+// NextSequenceIDContext returns next unique id by table name of sequencer with context
+func (cm *DBConnectionManager) NextSequenceIDContext(ctx context.Context, tableName string) (int64, error) {
 	conn, err := cm.ConnectionByTableName(tableName)
 	if err != nil {
 		return 0, errors.WithStack(err)
 	}
-	if conn.Sequencer == nil {
+	if conn.Sequencer == nil && conn.IDGenerator == nil {
 		return 0, errors.WithStack(err)
 	}
-	return conn.Adapter.NextSequenceID(conn.Sequencer, sequencerTableName(tableName))
+	return conn.NextSequenceIDContext(ctx, tableName)
 }
 
 // IsShardTable whether sharding table or not.
@@ -684,8 +947,52 @@ func (cm *DBConnectionManager) ShardKeyColumnName(tableName string) string {
 	return conn.ShardKeyColumnName
 }
 
+// openingConnection connection of table being opened
+type openingConnection struct {
+	done chan struct{}
+	err  error
+}
+
+// openOnce opens connection of table only once even if it is called concurrently,
+// so duplicate connection pools are not opened by concurrent first access to the table.
+func (cm *DBConnectionManager) openOnce(tableName string) error {
+	cm.openingMu.Lock()
+	if cm.connMap.Get(tableName) != nil {
+		// opened by other goroutine
+		cm.openingMu.Unlock()
+		return nil
+	}
+	if opening, exists := cm.opening[tableName]; exists {
+		cm.openingMu.Unlock()
+		<-opening.done
+		return errors.WithStack(opening.err)
+	}
+	opening := &openingConnection{done: make(chan struct{})}
+	if cm.opening == nil {
+		cm.opening = map[string]*openingConnection{}
+	}
+	cm.opening[tableName] = opening
+	cm.openingMu.Unlock()
+
+	opening.err = cm.open(tableName)
+
+	cm.openingMu.Lock()
+	delete(cm.opening, tableName)
+	cm.openingMu.Unlock()
+	close(opening.done)
+	return errors.WithStack(opening.err)
+}
+
+// currentConfig returns configuration connections are opened by
+func (cm *DBConnectionManager) currentConfig() *config.Config {
+	if cm.stagedConfig != nil {
+		return cm.stagedConfig
+	}
+	return getGlobalConfig()
+}
+
 func (cm *DBConnectionManager) open(tableName string) error {
-	for tblName, tableConfig := range globalConfig.Tables {
+	for tblName, tableConfig := range cm.currentConfig().Tables {
 		if tableName != tblName {
 			continue
 		}
@@ -697,76 +1004,191 @@ func (cm *DBConnectionManager) open(tableName string) error {
 	return errors.New("not found tableName in database config")
 }
 
-func (cm *DBConnectionManager) setConnectionSettings(conn *sql.DB) {
+// setConnectionSettings applies settings of connection pool for db of table to conn.
+// Settings not specified by configuration are given by SetMaxIdleConns etc.
+func (cm *DBConnectionManager) setConnectionSettings(conn *sql.DB, table *config.TableConfig, db *config.DatabaseConfig) {
 	if conn == nil {
 		return
 	}
-	conn.SetMaxIdleConns(cm.maxIdleConns)
-	conn.SetMaxOpenConns(cm.maxOpenConns)
-	conn.SetConnMaxLifetime(cm.connMaxLifetime)
+	pool := cm.currentConfig().ConnectionPool(table, db)
+	maxIdleConns := cm.maxIdleConns
+	if pool.MaxIdleConns != nil {
+		maxIdleConns = *pool.MaxIdleConns
+	}
+	maxOpenConns := cm.maxOpenConns
+	if pool.MaxOpenConns != nil {
+		maxOpenConns = *pool.MaxOpenConns
+	}
+	connMaxLifetime := cm.connMaxLifetime
+	if pool.ConnMaxLifetime != nil {
+		connMaxLifetime = *pool.ConnMaxLifetime
+	}
+	connMaxIdleTime := cm.connMaxIdleTime
+	if pool.ConnMaxIdleTime != nil {
+		connMaxIdleTime = *pool.ConnMaxIdleTime
+	}
+	conn.SetMaxIdleConns(maxIdleConns)
+	conn.SetMaxOpenConns(maxOpenConns)
+	conn.SetConnMaxLifetime(cm.jitterConnMaxLifetime(connMaxLifetime))
+	setConnMaxIdleTime(conn, connMaxIdleTime)
 }
 
 func (cm *DBConnectionManager) openShardConnection(tableName string, table *config.TableConfig) error {
-	var seqConn *sql.DB
-	if table.IsUsedSequencer() {
-		adapter, err := adap.Adapter(table.Sequencer.Adapter)
-		if err != nil {
-			return errors.WithStack(err)
+	var (
+		seqConn    *sql.DB
+		sequencers []*sql.DB
+		generator  idgen.IDGenerator
+	)
+	if table.IsUsedSequencer() && table.Sequencer.IsIDGenerator() {
+		var err error
+		if generator, err = idgen.New(table.Sequencer); err != nil {
+			return errors.Wrapf(err, "invalid sequencer of %s", tableName)
 		}
-		if seqConn, err = adapter.OpenConnection(table.Sequencer, cm.queryString); err != nil {
+	} else if table.IsUsedSequencer() {
+		var err error
+		if sequencers, err = cm.openSequencerConnections(table); err != nil {
 			return errors.WithStack(err)
 		}
+		seqConn = sequencers[0]
 	}
 	var adapter adap.DBAdapter
 	shardConns := &DBShardConnections{}
+	openShard := func(shardName string, shardValue *config.DatabaseConfig) (*sql.DB, error) {
+		var err error
+		adapter, err = adap.Adapter(shardValue.Adapter)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		queryString, err := cm.shardQueryString(adapter, table, shardConns.ShardNum())
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot open connection to %s", shardName)
+		}
+		shardConn, err := adapter.OpenConnection(shardValue, queryString)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		cm.setConnectionSettings(shardConn, table, shardValue)
+		slaves, err := cm.openSlaveConnections(adapter, table, shardValue)
+		if err != nil {
+			closeConn(shardConn)
+			return nil, errors.WithStack(err)
+		}
+		var dsn string
+		if len(shardValue.Masters) > 0 {
+			dsn = fmt.Sprintf("%s/%s", shardValue.Masters[0], shardValue.NameOrPath)
+		} else {
+			dsn = shardValue.NameOrPath
+		}
+		shardConns.addConnection(&DBShardConnection{
+			ShardName:      shardName,
+			Connection:     shardConn,
+			Slaves:         slaves,
+			dsn:            dsn,
+			slaveBalancing: table.SlaveBalancing,
+		})
+		return shardConn, nil
+	}
+	// connections passed to sharding algorithm. split shard is represented by its first sub-shard
 	conns := make([]*sql.DB, 0)
-	for _, shard := range table.Shards {
+	subShards := map[int]*algorithm.SubShards{}
+	for shardIndex, shard := range table.Shards {
 		for shardName, shardValue := range shard {
-			var err error
-			adapter, err = adap.Adapter(shardValue.Adapter)
-			if err != nil {
-				return errors.WithStack(err)
+			if !shardValue.IsSplit() {
+				shardConn, err := openShard(shardName, shardValue)
+				if err != nil {
+					closeConns(sequencers)
+					shardConns.Close()
+					return errors.WithStack(err)
+				}
+				conns = append(conns, shardConn)
+				continue
 			}
-			shardConn, err := adapter.OpenConnection(shardValue, cm.queryString)
-			if err != nil {
-				return errors.WithStack(err)
+			subConns := []*sql.DB{}
+			for _, subShard := range shardValue.SubShards {
+				for subShardName, subShardValue := range subShard {
+					subConn, err := openShard(subShardName, subShardValue)
+					if err != nil {
+						closeConns(sequencers)
+						shardConns.Close()
+						return errors.WithStack(err)
+					}
+					subConns = append(subConns, subConn)
+				}
 			}
-			cm.setConnectionSettings(shardConn)
-			conns = append(conns, shardConn)
-			var dsn string
-			if len(shardValue.Masters) > 0 {
-				dsn = fmt.Sprintf("%s/%s", shardValue.Masters[0], shardValue.NameOrPath)
-			} else {
-				dsn = shardValue.NameOrPath
+			split, err := algorithm.NewSubShards(shardName, shardValue.SubShardAlgorithm, shardValue.SubShardAlgorithmConfig, subConns)
+			if err != nil {
+				closeConns(sequencers)
+				shardConns.Close()
+				return errors.Wrapf(err, "invalid algorithm of %s", tableName)
 			}
-			shardConns.addConnection(&DBShardConnection{
-				ShardName:  shardName,
-				Connection: shardConn,
-				dsn:        dsn,
-			})
+			subShards[shardIndex] = split
+			conns = append(conns, subConns[0])
 		}
 	}
+	shardConns.shardingConns = conns
 	logic, err := algorithm.LoadShardingAlgorithm(table.Algorithm)
 	if err != nil {
+		closeConns(sequencers)
+		shardConns.Close()
 		return errors.WithStack(err)
 	}
-	if !logic.Init(conns) {
-		return errors.New("cannot initialize sharding algorithm")
+	if err := algorithm.InitShardingAlgorithm(logic, conns, table.AlgorithmConfig); err != nil {
+		closeConns(sequencers)
+		shardConns.Close()
+		return errors.Wrapf(err, "invalid algorithm of %s", tableName)
 	}
-	cm.connMap.Set(tableName, &DBConnection{
+	if len(subShards) > 0 {
+		logic = algorithm.NewHierarchicalShardingAlgorithm(logic, subShards)
+	}
+	conn := &DBConnection{
+		queryHook:          cm.queryHook,
+		routingInterceptor: cm.routingInterceptor,
+		tableName:          tableName,
+		failover:           cm.failover,
 		Config:             table,
 		IsShard:            table.IsShard,
 		Algorithm:          logic,
 		Adapter:            adapter,
 		IsUsedSequencer:    table.IsUsedSequencer(),
 		Sequencer:          seqConn,
+		sequencers:         sequencers,
+		IDGenerator:        generator,
 		ShardColumnName:    table.ShardColumnName,
 		ShardKeyColumnName: table.ShardKeyColumnName,
 		ShardConnections:   shardConns,
-	})
+	}
+	if table.IsUsedSequencerDatabase() && table.SequencerCacheSize > 1 {
+		conn.sequenceBlocks = newSequenceBlockCache(table.SequencerCacheSize)
+	}
+	if err := conn.verifySchemaByConfig(tableName, cm.currentConfig()); err != nil {
+		closeConns(sequencers)
+		shardConns.Close()
+		return errors.WithStack(err)
+	}
+	cm.connMap.Set(tableName, conn)
 	return nil
 }
 
+// shardQueryString returns query string of DSN for shard.
+// If table enables 'auto_increment: offset', auto increment ids are offset by shard index ( sub-shards are counted as shards ).
+func (cm *DBConnectionManager) shardQueryString(adapter adap.DBAdapter, table *config.TableConfig, shardIndex int) (string, error) {
+	if table.AutoIncrement != config.AutoIncrementOffset {
+		return cm.queryString, nil
+	}
+	offsetAdapter, ok := adapter.(adap.AutoIncrementOffsetAdapter)
+	if !ok {
+		return "", errors.New("adapter doesn't support offset of auto increment ids")
+	}
+	queryString, ok := offsetAdapter.AutoIncrementOffsetQueryString(len(table.PhysicalShards()), shardIndex+1)
+	if !ok {
+		return "", errors.New("adapter doesn't support offset of auto increment ids")
+	}
+	if cm.queryString == "" {
+		return queryString, nil
+	}
+	return cm.queryString + "&" + queryString, nil
+}
+
 func (cm *DBConnectionManager) openConnection(tableName string, table *config.TableConfig) error {
 	adapter, err := adap.Adapter(table.DatabaseConfig.Adapter)
 	if err != nil {
@@ -776,11 +1198,20 @@ func (cm *DBConnectionManager) openConnection(tableName string, table *config.Ta
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	cm.setConnectionSettings(conn)
+	cm.setConnectionSettings(conn, table, &table.DatabaseConfig)
+	slaves, err := cm.openSlaveConnections(adapter, table, &table.DatabaseConfig)
+	if err != nil {
+		closeConn(conn)
+		return errors.WithStack(err)
+	}
 	cm.connMap.Set(tableName, &DBConnection{
+		queryHook:  cm.queryHook,
+		tableName:  tableName,
+		failover:   cm.failover,
 		Config:     table,
 		Adapter:    adapter,
 		Connection: conn,
+		Slaves:     slaves,
 	})
 	return nil
 }
@@ -788,19 +1219,28 @@ func (cm *DBConnectionManager) openConnection(tableName string, table *config.Ta
 // NewConnectionManager creates instance of DBConnectionManager,
 // If call this before loads configuration file, it returns error.
 func NewConnectionManager() (*DBConnectionManager, error) {
-	if globalConfig == nil {
+	if getGlobalConfig() == nil {
 		return nil, errors.New("cannot setup from sharding config")
 	}
 	connMgr := &DBConnectionManager{
-		connMap:     DBConnectionMap{&sync.Map{}},
-		queryString: "",
+		connMap:            newDBConnectionMap(),
+		queryString:        "",
+		queryHook:          &queryHookHolder{},
+		routingInterceptor: &routingInterceptorHolder{},
 	}
+	connMgr.failover = &failoverHandler{cm: connMgr}
 	return connMgr, nil
 }
 
-// SetConfig set config.Config instance to internal global variable
+// SetConfig set config.Config instance to internal global variable.
+// cfg is also set as global configuration of config package, so it doesn't need to be loaded from file ( e.g. config.LoadFromBytes ).
+// If cfg is nil, current configuration is kept.
 func SetConfig(cfg *config.Config) error {
-	globalConfig = cfg
+	if cfg == nil {
+		return errors.New("cannot set config. config is nil")
+	}
+	setGlobalConfig(cfg)
+	config.Set(cfg)
 	return errors.WithStack(setupDBFromConfig(cfg))
 }
 
@@ -812,26 +1252,27 @@ func setupDBFromConfig(config *config.Config) error {
 		return nil
 	}
 	for tableName, table := range config.Tables {
-		var err error
-		if table.IsShard {
-			err = setupShardDB(tableName, table)
-		} else {
-			err = setupDB(tableName, table)
-		}
-		if err != nil {
+		if err := setupTable(tableName, table); err != nil {
 			return errors.WithStack(err)
 		}
 	}
 	return nil
 }
 
-func insertRowToSequencerIfNotExists(conn *sql.DB, tableName string, adapter adap.DBAdapter) error {
-	seqID, err := adapter.CurrentSequenceID(conn, sequencerTableName(tableName))
+func setupTable(tableName string, table *config.TableConfig) error {
+	if table.IsShard {
+		return setupShardDB(tableName, table)
+	}
+	return setupDB(tableName, table)
+}
+
+func insertRowToSequencerIfNotExists(conn *sql.DB, seqTableName string, adapter adap.DBAdapter) error {
+	seqID, err := adapter.CurrentSequenceID(conn, seqTableName)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 	if seqID == 0 {
-		return adapter.InsertRowToSequencerIfNotExists(conn, sequencerTableName(tableName))
+		return adapter.InsertRowToSequencerIfNotExists(conn, seqTableName)
 	}
 	return nil
 }
@@ -844,27 +1285,14 @@ func setupShardDB(tableName string, table *config.TableConfig) error {
 	if err := table.Error(); err != nil {
 		return errors.WithStack(err)
 	}
-	if table.IsUsedSequencer() {
-		adapter, err := adap.Adapter(table.Sequencer.Adapter)
-		if err != nil {
-			return errors.WithStack(err)
-		}
-		if err := adapter.ExecDDL(table.Sequencer); err != nil {
-			return errors.WithStack(err)
-		}
-		seqConn, err := adapter.OpenConnection(table.Sequencer, "")
-		defer closeConn(seqConn)
-		if err != nil {
-			return errors.WithStack(err)
-		}
-		if err := adapter.CreateSequencerTableIfNotExists(seqConn, sequencerTableName(tableName)); err != nil {
-			return errors.WithStack(err)
-		}
-		if err := insertRowToSequencerIfNotExists(seqConn, tableName, adapter); err != nil {
-			return errors.WithStack(err)
+	if table.IsUsedSequencerDatabase() {
+		for _, seqConfig := range sequencerConfigs(table.Sequencer) {
+			if err := setupSequencerDB(tableName, table, seqConfig); err != nil {
+				return errors.WithStack(err)
+			}
 		}
 	}
-	for _, shard := range table.Shards {
+	for _, shard := range table.PhysicalShards() {
 		for _, shardValue := range shard {
 			adapter, err := adap.Adapter(shardValue.Adapter)
 			if err != nil {
@@ -878,6 +1306,36 @@ func setupShardDB(tableName string, table *config.TableConfig) error {
 	return nil
 }
 
+// setupSequencerDB creates sequencer tables ( and tables of unique columns ) in database of sequencer or its replica
+func setupSequencerDB(tableName string, table *config.TableConfig, seqConfig *config.DatabaseConfig) error {
+	adapter, err := adap.Adapter(seqConfig.Adapter)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := adapter.ExecDDL(seqConfig); err != nil {
+		return errors.WithStack(err)
+	}
+	seqConn, err := adapter.OpenConnection(seqConfig, "")
+	defer closeConn(seqConn)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	partitionNum := sequencerPartitionNum(table.Sequencer)
+	for partition := 0; partition < partitionNum; partition++ {
+		seqTableName := sequencerPartitionTableName(tableName, partition, partitionNum)
+		if err := adapter.CreateSequencerTableIfNotExists(seqConn, seqTableName); err != nil {
+			return errors.WithStack(err)
+		}
+		if err := insertRowToSequencerIfNotExists(seqConn, seqTableName, adapter); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if err := createUniqueTablesIfNotExists(seqConn, tableName, table.UniqueColumns); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
 func setupDB(tableName string, table *config.TableConfig) error {
 	adapter, err := adap.Adapter(table.DatabaseConfig.Adapter)
 	if err != nil {
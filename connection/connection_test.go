@@ -4,10 +4,16 @@ import (
 	"context"
 	"database/sql"
 	"database/sql/driver"
+	"errors"
+	"fmt"
 	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	pkgerrors "github.com/pkg/errors"
 	"go.knocknote.io/octillery/config"
 	"go.knocknote.io/octillery/connection/adapter"
 	"go.knocknote.io/octillery/path"
@@ -16,6 +22,9 @@ import (
 type TestAdapter struct {
 }
 
+// number of connection pools opened by TestAdapter
+var openedConnections int64
+
 func (t *TestAdapter) CurrentSequenceID(conn *sql.DB, tableName string) (int64, error) {
 	return 1, nil
 }
@@ -29,6 +38,7 @@ func (t *TestAdapter) ExecDDL(config *config.DatabaseConfig) error {
 }
 
 func (t *TestAdapter) OpenConnection(config *config.DatabaseConfig, queryValues string) (*sql.DB, error) {
+	atomic.AddInt64(&openedConnections, 1)
 	return sql.Open("sqlite3", "")
 }
 
@@ -187,6 +197,62 @@ func TestGetConnection(t *testing.T) {
 	})
 }
 
+func TestConcurrentFirstAccess(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	before := atomic.LoadInt64(&openedConnections)
+	var wg sync.WaitGroup
+	conns := make([]*DBConnection, 50)
+	errs := make([]error, len(conns))
+	for i := range conns {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conns[i], errs[i] = mgr.ConnectionByTableName("users")
+		}(i)
+	}
+	wg.Wait()
+	for i, conn := range conns {
+		checkErr(t, errs[i])
+		if conn != conns[0] {
+			t.Fatal("concurrent first access must get the same connection")
+		}
+	}
+	// sequencer and two shards
+	if opened := atomic.LoadInt64(&openedConnections) - before; opened != 3 {
+		t.Fatalf("connection pools must be opened once. opened %d pools", opened)
+	}
+	if _, err := mgr.ConnectionByTableName("unknown_table"); err == nil {
+		t.Fatal("cannot handle error of unknown table")
+	}
+}
+
+func BenchmarkConnectionByTableName(b *testing.B) {
+	mgr, err := NewConnectionManager()
+	if err != nil {
+		b.Fatalf("%+v\n", err)
+	}
+	defer mgr.Close()
+	tableNames := []string{"users", "user_items", "user_decks", "user_stages"}
+	for _, tableName := range tableNames {
+		if _, err := mgr.ConnectionByTableName(tableName); err != nil {
+			b.Fatalf("%+v\n", err)
+		}
+	}
+	b.SetParallelism(64)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, err := mgr.ConnectionByTableName(tableNames[i%len(tableNames)]); err != nil {
+				b.Fatalf("%+v\n", err)
+			}
+			i++
+		}
+	})
+}
+
 func TestSetSettings(t *testing.T) {
 	mgr, err := NewConnectionManager()
 	checkErr(t, err)
@@ -194,209 +260,1594 @@ func TestSetSettings(t *testing.T) {
 	mgr.SetMaxIdleConns(10)
 	mgr.SetMaxOpenConns(10)
 	mgr.SetConnMaxLifetime(10 * time.Second)
+	mgr.SetConnMaxIdleTime(5 * time.Second)
+	if mgr.jitteredConnMaxLifetime() != 10*time.Second {
+		t.Fatal("max lifetime must not be changed without jitter")
+	}
+	mgr.SetConnMaxLifetimeJitter(time.Second)
+	lifetimes := map[time.Duration]struct{}{}
+	for i := 0; i < 100; i++ {
+		lifetime := mgr.jitteredConnMaxLifetime()
+		if lifetime < 10*time.Second || lifetime > 11*time.Second {
+			t.Fatalf("jittered max lifetime is out of range. %s", lifetime)
+		}
+		lifetimes[lifetime] = struct{}{}
+	}
+	if len(lifetimes) == 1 {
+		t.Fatal("cannot jitter max lifetime")
+	}
+	if _, err := mgr.ConnectionByTableName("users"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	mgr.SetConnMaxLifetime(0)
+	if mgr.jitteredConnMaxLifetime() != 0 {
+		t.Fatal("unlimited max lifetime must not be jittered")
+	}
 }
 
-func TestCurrentSequenceID(t *testing.T) {
+func TestConnectionPoolConfig(t *testing.T) {
+	cfg, err := config.Get()
+	checkErr(t, err)
+	users := cfg.Tables["users"]
+	shard := users.ShardConfigByName("user_shard_2")
+	intPtr := func(n int) *int { return &n }
+	cfg.MaxOpenConns = intPtr(5)
+	users.MaxOpenConns = intPtr(4)
+	shard.MaxOpenConns = intPtr(3)
+	defer func() {
+		cfg.MaxOpenConns = nil
+		users.MaxOpenConns = nil
+		shard.MaxOpenConns = nil
+	}()
+
 	mgr, err := NewConnectionManager()
 	checkErr(t, err)
 	defer mgr.Close()
-	id, err := mgr.CurrentSequenceID("users")
+	mgr.SetMaxOpenConns(10)
+	conn, err := mgr.ConnectionByTableName("users")
 	checkErr(t, err)
-	if id != 1 {
-		t.Fatal("cannot get current sequence id")
+	if n := conn.Sequencer.Stats().MaxOpenConnections; n != 4 {
+		t.Fatalf("sequencer must inherit settings of table. %d", n)
+	}
+	for _, shardConn := range conn.ShardConnections.AllShard() {
+		expected := 4
+		if shardConn.ShardName == "user_shard_2" {
+			expected = 3
+		}
+		if n := shardConn.Connection.Stats().MaxOpenConnections; n != expected {
+			t.Fatalf("invalid max open connections of %s. expected %d but got %d", shardConn.ShardName, expected, n)
+		}
+	}
+	conn, err = mgr.ConnectionByTableName("user_stages")
+	checkErr(t, err)
+	if n := conn.Connection.Stats().MaxOpenConnections; n != 5 {
+		t.Fatalf("table must inherit global settings. %d", n)
+	}
+	cfg.MaxOpenConns = nil
+	conn, err = mgr.ConnectionByTableName("user_decks")
+	checkErr(t, err)
+	for _, shardConn := range conn.ShardConnections.AllShard() {
+		if n := shardConn.Connection.Stats().MaxOpenConnections; n != 10 {
+			t.Fatalf("settings of connection manager must be used as default. %d", n)
+		}
 	}
 }
 
-func TestNextSequenceID(t *testing.T) {
+func TestStats(t *testing.T) {
 	mgr, err := NewConnectionManager()
 	checkErr(t, err)
 	defer mgr.Close()
-	id, err := mgr.NextSequenceID("users")
+	conn, err := mgr.ConnectionByTableName("users")
 	checkErr(t, err)
-	if id != 2 {
-		t.Fatal("cannot get next sequence id")
+	if stats := mgr.Stats(); stats.MaxOpenConnections != 0 {
+		t.Fatalf("unlimited connection pools must be 0. %d", stats.MaxOpenConnections)
 	}
-	{
-		conn, err := mgr.ConnectionByTableName("users")
-		checkErr(t, err)
-		id, err := conn.NextSequenceID("users")
-		checkErr(t, err)
-		if id != 2 {
-			t.Fatal("cannot get next sequence id")
+	mgr.SetMaxOpenConns(7)
+	for _, shardConn := range conn.ShardConnections.AllShard() {
+		if n := shardConn.Connection.Stats().MaxOpenConnections; n != 7 {
+			t.Fatalf("settings must be applied to opened connection pools. %d", n)
 		}
 	}
-}
-
-func TestIsShardTable(t *testing.T) {
-	mgr, err := NewConnectionManager()
+	// sequencer and two shards
+	if stats := mgr.Stats(); stats.MaxOpenConnections != 21 {
+		t.Fatalf("cannot sum up max open connections. %d", stats.MaxOpenConnections)
+	}
+	rows, err := conn.Sequencer.Query("SELECT 1")
 	checkErr(t, err)
-	defer mgr.Close()
-	if !mgr.IsShardTable("users") {
-		t.Fatal("cannot set is_shard configuration")
+	defer rows.Close()
+	if stats := mgr.Stats(); stats.InUse != 1 || stats.OpenConnections < 1 {
+		t.Fatalf("cannot sum up connections in use. %+v", stats)
 	}
-	if mgr.IsShardTable("user_stages") {
-		t.Fatal("cannot set is_shard configuration")
+	poolStats := mgr.PoolStats()
+	if len(poolStats) != 3 {
+		t.Fatalf("cannot get statistics of each connection pool. %d", len(poolStats))
+	}
+	for _, stats := range poolStats {
+		if stats.Table != "users" || stats.Stats.MaxOpenConnections != 7 {
+			t.Fatalf("invalid statistics %+v", stats)
+		}
+		switch stats.Role {
+		case PoolRoleSequencer:
+			if stats.Shard != "" || stats.Stats.InUse != 1 {
+				t.Fatalf("invalid statistics of sequencer %+v", stats)
+			}
+		case PoolRoleMaster:
+			if stats.Shard != "user_shard_1" && stats.Shard != "user_shard_2" {
+				t.Fatalf("invalid statistics of shard %+v", stats)
+			}
+		default:
+			t.Fatalf("unknown role %s", stats.Role)
+		}
 	}
 }
 
-func TestEqualDSN(t *testing.T) {
+func TestRotateCredentials(t *testing.T) {
 	mgr, err := NewConnectionManager()
 	checkErr(t, err)
 	defer mgr.Close()
+	mgr.SetCredentialDrainTimeout(10 * time.Millisecond)
 	conn, err := mgr.ConnectionByTableName("users")
 	checkErr(t, err)
-	t.Run("same instance", func(t *testing.T) {
-		if !conn.EqualDSN(conn) {
-			t.Fatal("cannot work equal dsn")
+	t.Run("rotate single shard", func(t *testing.T) {
+		checkErr(t, mgr.RotateCredentials("users", "user_shard_1", "rotated_user", "rotated_password"))
+		newConn, err := mgr.ConnectionByTableName("users")
+		checkErr(t, err)
+		if newConn == conn {
+			t.Fatal("cannot swap connection")
+		}
+		oldShard := conn.ShardConnections.ShardConnectionByName("user_shard_1")
+		newShard := newConn.ShardConnections.ShardConnectionByName("user_shard_1")
+		if oldShard.Connection == newShard.Connection {
+			t.Fatal("cannot rotate shard connection")
+		}
+		if conn.ShardConnections.ShardConnectionByName("user_shard_2").Connection !=
+			newConn.ShardConnections.ShardConnectionByName("user_shard_2").Connection {
+			t.Fatal("rotate unspecified shard")
+		}
+		if newConn.Sequencer != conn.Sequencer {
+			t.Fatal("rotate unspecified sequencer")
+		}
+		if newConn.Config.ShardConfigByName("user_shard_1").Username != "rotated_user" {
+			t.Fatal("cannot update config")
+		}
+		shardConn, err := newConn.ShardConnectionByID(2)
+		checkErr(t, err)
+		if shardConn == nil {
+			t.Fatal("cannot get shard connection")
+		}
+		time.Sleep(50 * time.Millisecond)
+		if err := oldShard.Connection.Ping(); err == nil {
+			t.Fatal("cannot close old connection")
 		}
 	})
-	t.Run("another instance", func(t *testing.T) {
-		mgr, err := NewConnectionManager()
+	t.Run("rotate all databases", func(t *testing.T) {
+		conn, err := mgr.ConnectionByTableName("user_stages")
 		checkErr(t, err)
-		defer mgr.Close()
-		anotherConn, err := mgr.ConnectionByTableName("users")
+		checkErr(t, mgr.RotateCredentials("user_stages", "", "rotated_user", "rotated_password"))
+		newConn, err := mgr.ConnectionByTableName("user_stages")
 		checkErr(t, err)
-		if !conn.EqualDSN(anotherConn) {
-			t.Fatal("cannot work equal dsn")
+		if newConn.Connection == conn.Connection {
+			t.Fatal("cannot rotate connection")
+		}
+	})
+	t.Run("reload credentials", func(t *testing.T) {
+		cfg, err := config.Get()
+		checkErr(t, err)
+		reloaded := &config.Config{Tables: map[string]*config.TableConfig{}}
+		for tableName, table := range cfg.Tables {
+			copied := *table
+			copied.Username = ""
+			copied.Password = ""
+			copied.Shards = []map[string]*config.DatabaseConfig{}
+			for _, shard := range table.Shards {
+				copiedShard := map[string]*config.DatabaseConfig{}
+				for shardName, shardConfig := range shard {
+					copiedConfig := *shardConfig
+					copiedConfig.Username = ""
+					copiedConfig.Password = ""
+					copiedShard[shardName] = &copiedConfig
+				}
+				copied.Shards = append(copied.Shards, copiedShard)
+			}
+			reloaded.Tables[tableName] = &copied
+		}
+		checkErr(t, mgr.ReloadCredentials(reloaded))
+		current, err := config.Get()
+		checkErr(t, err)
+		if current.Tables["users"].ShardConfigByName("user_shard_1").Username != "" {
+			t.Fatal("cannot reload credentials")
+		}
+		if current.Tables["user_stages"].Username != "" {
+			t.Fatal("cannot reload credentials")
+		}
+		conn, err := mgr.ConnectionByTableName("user_stages")
+		checkErr(t, err)
+		if conn.Config.Username != "" {
+			t.Fatal("cannot reload credentials of connection")
+		}
+		if cfg.Tables["user_stages"].Username != "rotated_user" {
+			t.Fatal("configuration read by others must not be changed by rotation")
+		}
+	})
+	t.Run("concurrent rotation", func(t *testing.T) {
+		conn, err := mgr.ConnectionByTableName("users")
+		checkErr(t, err)
+		var started, wg sync.WaitGroup
+		done := make(chan struct{})
+		for i := 0; i < 4; i++ {
+			started.Add(1)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for read := 0; ; read++ {
+					// configuration of connection got before rotation is still read by queries in progress
+					_ = conn.Config.ShardConfigByName("user_shard_1").Username
+					cfg, err := config.Get()
+					if err != nil {
+						t.Errorf("%+v", err)
+					} else {
+						_ = cfg.Tables["users"].ShardConfigByName("user_shard_1").Password
+					}
+					if read == 0 {
+						started.Done()
+					}
+					select {
+					case <-done:
+						return
+					default:
+					}
+				}
+			}()
+		}
+		started.Wait()
+		for i := 0; i < 10; i++ {
+			username := fmt.Sprintf("user_%d", i)
+			if err := mgr.RotateCredentials("users", "user_shard_1", username, "password"); err != nil {
+				t.Errorf("%+v", err)
+				break
+			}
+		}
+		close(done)
+		wg.Wait()
+		conn, err = mgr.ConnectionByTableName("users")
+		checkErr(t, err)
+		if conn.Config.ShardConfigByName("user_shard_1").Username != "user_9" {
+			t.Fatal("cannot rotate credentials")
+		}
+	})
+	t.Run("invalid shard name", func(t *testing.T) {
+		if err := mgr.RotateCredentials("users", "invalid_shard", "", ""); err == nil {
+			t.Fatal("cannot handle error")
+		}
+		if err := mgr.RotateCredentials("user_stages", "invalid_shard", "", ""); err == nil {
+			t.Fatal("cannot handle error")
 		}
 	})
 }
 
-func TestIsEqualShardColumnToShardKeyColumn(t *testing.T) {
+func TestReload(t *testing.T) {
+	cfg, err := config.Get()
+	checkErr(t, err)
+	defer SetConfig(cfg)
+
 	mgr, err := NewConnectionManager()
 	checkErr(t, err)
 	defer mgr.Close()
-	if !mgr.IsEqualShardColumnToShardKeyColumn("users") {
-		t.Fatal("cannot set shard_column and shard_key")
+	mgr.SetCredentialDrainTimeout(10 * time.Millisecond)
+	tableNames := []string{"users", "user_decks", "user_stages"}
+	conns := map[string]*DBConnection{}
+	for _, tableName := range tableNames {
+		conn, err := mgr.ConnectionByTableName(tableName)
+		checkErr(t, err)
+		conns[tableName] = conn
 	}
-	if mgr.IsEqualShardColumnToShardKeyColumn("user_items") {
-		t.Fatal("cannot set shard_column and shard_key")
+	reloaded := &config.Config{Tables: map[string]*config.TableConfig{}}
+	for tableName, table := range cfg.Tables {
+		reloaded.Tables[tableName] = table
 	}
-	if mgr.IsEqualShardColumnToShardKeyColumn("user_decks") {
-		t.Fatal("cannot set shard_column and shard_key")
+	stages := *cfg.Tables["user_stages"]
+	stages.NameOrPath = "reloaded_user_stages"
+	reloaded.Tables["user_stages"] = &stages
+	delete(reloaded.Tables, "user_decks")
+
+	result, err := mgr.Reload(reloaded)
+	checkErr(t, err)
+	if getGlobalConfig() != cfg {
+		t.Fatal("configuration must not be set by reload")
 	}
-	if !mgr.IsEqualShardColumnToShardKeyColumn("user_stages") {
-		t.Fatal("cannot set shard_column and shard_key")
+	checkErr(t, SetConfig(reloaded))
+	if !reflect.DeepEqual(result.Changed, []string{"user_stages"}) || !reflect.DeepEqual(result.Removed, []string{"user_decks"}) {
+		t.Fatalf("invalid result of reload %+v", result)
 	}
-	conn, err := mgr.ConnectionByTableName("users")
+	if conn, _ := mgr.ConnectionByTableName("users"); conn != conns["users"] {
+		t.Fatal("connection of unchanged table must be kept")
+	}
+	conn, err := mgr.ConnectionByTableName("user_stages")
 	checkErr(t, err)
-	if !conn.IsEqualShardColumnToShardKeyColumn() {
-		t.Fatal("cannot set shard_column and shard_key")
+	if conn == conns["user_stages"] || conn.Config.NameOrPath != "reloaded_user_stages" {
+		t.Fatal("cannot swap connection of changed table")
+	}
+	if _, err := mgr.ConnectionByTableName("user_decks"); err == nil {
+		t.Fatal("connection of removed table must not be opened")
+	}
+	if _, err := mgr.ConnectionByTableName("user_items"); err != nil {
+		t.Fatalf("cannot open table not opened yet by reloaded configuration: %+v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := conns["user_stages"].Connection.Ping(); err == nil {
+		t.Fatal("cannot close old connection")
 	}
-}
 
-func TestShardConnectionByID(t *testing.T) {
-	mgr, err := NewConnectionManager()
-	checkErr(t, err)
-	defer mgr.Close()
-	conn, err := mgr.ConnectionByTableName("users")
-	checkErr(t, err)
-	{
-		shardConn, err := conn.ShardConnectionByID(1)
-		checkErr(t, err)
-		if shardConn.ShardName != "user_shard_2" {
-			t.Fatal("invalid shard connection by id")
-		}
+	invalid := &config.Config{Tables: map[string]*config.TableConfig{}}
+	for tableName, table := range reloaded.Tables {
+		invalid.Tables[tableName] = table
 	}
-	{
-		shardConn, err := conn.ShardConnectionByID(2)
-		checkErr(t, err)
-		if shardConn.ShardName != "user_shard_1" {
-			t.Fatal("invalid shard connection by id")
-		}
+	invalidStages := stages
+	invalidStages.Adapter = "invalid_adapter"
+	invalid.Tables["user_stages"] = &invalidStages
+	if _, err := mgr.Reload(invalid); err == nil {
+		t.Fatal("cannot handle invalid configuration")
+	}
+	if current, _ := mgr.ConnectionByTableName("user_stages"); current != conn {
+		t.Fatal("current connection must be kept if reload is failed")
+	}
+	if getGlobalConfig() != reloaded {
+		t.Fatal("current configuration must be kept if reload is failed")
 	}
 }
 
-func TestShardColumnName(t *testing.T) {
+type ReturningIDTestAdapter struct {
+	TestAdapter
+}
+
+func (t *ReturningIDTestAdapter) IsRequiredReturningID() bool {
+	return true
+}
+
+func TestReturningID(t *testing.T) {
 	mgr, err := NewConnectionManager()
 	checkErr(t, err)
 	defer mgr.Close()
-	if mgr.ShardColumnName("users") != "id" {
-		t.Fatal("cannot get shard_column name")
+	conn, err := mgr.ConnectionByTableName("user_stages")
+	checkErr(t, err)
+	if conn.IsRequiredReturningID() {
+		t.Fatal("invalid adapter")
 	}
-	if mgr.ShardColumnName("user_items") != "" {
-		t.Fatal("cannot get shard_column name")
+	returningConn := &DBConnection{
+		Config:     &config.TableConfig{IdentityColumnName: "stage_id"},
+		Adapter:    &ReturningIDTestAdapter{},
+		Connection: conn.Connection,
 	}
-	if mgr.ShardColumnName("user_decks") != "id" {
-		t.Fatal("cannot get shard_column name")
+	if !returningConn.IsRequiredReturningID() {
+		t.Fatal("cannot detect adapter requires returning id")
 	}
-	if mgr.ShardColumnName("user_stages") != "" {
-		t.Fatal("cannot get shard_column name")
+	query := returningConn.QueryWithReturningID("insert into user_stages(name) values ('alice')")
+	if query != "insert into user_stages(name) values ('alice') returning stage_id" {
+		t.Fatalf("invalid query %s", query)
+	}
+	if conn.QueryWithReturningID("insert into user_stages(name) values ('alice')") != "insert into user_stages(name) values ('alice') returning id" {
+		t.Fatal("invalid default identity column")
 	}
 }
 
-func TestShardKeyColumnName(t *testing.T) {
-	mgr, err := NewConnectionManager()
-	checkErr(t, err)
-	defer mgr.Close()
-	if mgr.ShardKeyColumnName("users") != "id" {
-		t.Fatal("cannot get shard_key name")
+func TestMultiError(t *testing.T) {
+	errShard1 := errors.New("shard1 error")
+	errShard2 := errors.New("shard2 error")
+	errs := &MultiError{}
+	if errs.ErrorOrNil() != nil {
+		t.Fatal("invalid error")
 	}
-	if mgr.ShardKeyColumnName("user_items") != "user_id" {
-		t.Fatal("cannot get shard_key name")
+	errs.AddShardError("user_shard_1", "/tmp/user_shard_1.bin", errShard1)
+	errs.AddShardError("user_shard_2", "/tmp/user_shard_2.bin", nil)
+	nested := &MultiError{}
+	nested.AddShardError("user_shard_2", "/tmp/user_shard_2.bin", errShard2)
+	errs.Add(nested)
+	err := errs.ErrorOrNil()
+	if err == nil {
+		t.Fatal("cannot handle error")
 	}
-	if mgr.ShardKeyColumnName("user_decks") != "user_id" {
-		t.Fatal("cannot get shard_key name")
+	if err.Error() != "(DB:user_shard_1):shard1 error:(DB:user_shard_2):shard2 error" {
+		t.Fatalf("invalid error message %s", err.Error())
 	}
-	if mgr.ShardKeyColumnName("user_stages") != "" {
-		t.Fatal("cannot get shard_key name")
+	if !errors.Is(err, errShard1) || !errors.Is(err, errShard2) {
+		t.Fatal("cannot unwrap errors")
+	}
+	var shardErr *ShardError
+	if !errors.As(err, &shardErr) || shardErr.ShardName != "user_shard_1" {
+		t.Fatal("cannot find shard error")
+	}
+	shardErrs := errs.ShardErrors()
+	if len(shardErrs) != 2 {
+		t.Fatal("cannot get shard errors")
+	}
+	if shardErrs[1].ShardName != "user_shard_2" || shardErrs[1].DSN != "/tmp/user_shard_2.bin" {
+		t.Fatal("invalid shard attribution")
 	}
 }
 
-func TestQuery(t *testing.T) {
+func TestForEachShard(t *testing.T) {
 	mgr, err := NewConnectionManager()
 	checkErr(t, err)
 	defer mgr.Close()
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	t.Run("sharding table", func(t *testing.T) {
+		var mu sync.Mutex
+		shardNames := map[string]bool{}
+		checkErr(t, mgr.ForEachShard("users", func(shard *DBShardConnection) error {
+			mu.Lock()
+			defer mu.Unlock()
+			shardNames[shard.ShardName] = true
+			return nil
+		}, &ForEachShardOptions{Concurrency: 2}))
+		if len(shardNames) != 2 || !shardNames["user_shard_1"] || !shardNames["user_shard_2"] {
+			t.Fatal("cannot iterate all shards")
+		}
+	})
 	t.Run("not sharding table", func(t *testing.T) {
-		conn, err := mgr.ConnectionByTableName("user_stages")
-		checkErr(t, err)
-		t.Run("without context", func(t *testing.T) {
-			rows, err := conn.Query(nil, "select * from user_stages")
-			checkErr(t, err)
-			if columns, _ := rows.Columns(); len(columns) != 0 {
-				t.Fatal("unknown rows")
+		callCount := 0
+		checkErr(t, ForEachShard("user_stages", func(shard *DBShardConnection) error {
+			if shard.Connection == nil || shard.DSN() != "/tmp/user_stage.bin" {
+				t.Fatal("invalid connection")
 			}
-		})
-		t.Run("with context", func(t *testing.T) {
-			rows, err := conn.Query(ctx, "select * from user_stages")
-			checkErr(t, err)
-			if columns, _ := rows.Columns(); len(columns) != 0 {
-				t.Fatal("unknown rows")
+			callCount++
+			return nil
+		}, nil))
+		if callCount != 1 {
+			t.Fatal("cannot iterate database")
+		}
+	})
+	t.Run("aggregate errors", func(t *testing.T) {
+		err := mgr.ForEachShard("users", func(shard *DBShardConnection) error {
+			return errors.New("error")
+		}, &ForEachShardOptions{Concurrency: 2})
+		multiErr, ok := err.(*MultiError)
+		if !ok {
+			t.Fatalf("cannot aggregate errors %+v", err)
+		}
+		if len(multiErr.ShardErrors()) != 2 {
+			t.Fatal("cannot attribute errors to shards")
+		}
+	})
+	t.Run("stop on error", func(t *testing.T) {
+		callCount := 0
+		err := mgr.ForEachShard("users", func(shard *DBShardConnection) error {
+			callCount++
+			return errors.New("error")
+		}, &ForEachShardOptions{StopOnError: true})
+		if err == nil {
+			t.Fatal("cannot handle error")
+		}
+		if callCount != 1 {
+			t.Fatal("cannot stop on error")
+		}
+	})
+	t.Run("cancel context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		callCount := 0
+		err := mgr.ForEachShard("users", func(shard *DBShardConnection) error {
+			callCount++
+			cancel()
+			return nil
+		}, &ForEachShardOptions{Context: ctx})
+		if err == nil {
+			t.Fatal("cannot handle cancelled context")
+		}
+		if callCount != 1 {
+			t.Fatal("cannot stop by cancelled context")
+		}
+	})
+	t.Run("recover panic", func(t *testing.T) {
+		err := mgr.ForEachShard("users", func(shard *DBShardConnection) error {
+			if shard.ShardName == "user_shard_1" {
+				panic("unexpected")
 			}
-		})
+			return nil
+		}, &ForEachShardOptions{Concurrency: 2})
+		multiErr, ok := err.(*MultiError)
+		if !ok {
+			t.Fatalf("cannot recover panic %+v", err)
+		}
+		shardErrs := multiErr.ShardErrors()
+		if len(shardErrs) != 1 || shardErrs[0].ShardName != "user_shard_1" {
+			t.Fatal("cannot attribute panic to shard")
+		}
+	})
+	t.Run("invalid table", func(t *testing.T) {
+		if err := mgr.ForEachShard("invalid_table", func(*DBShardConnection) error { return nil }, nil); err == nil {
+			t.Fatal("cannot handle error")
+		}
 	})
 }
 
-func TestQueryRow(t *testing.T) {
+type SchemaTestAdapter struct {
+	TestAdapter
+	schemas map[*sql.DB]string
+}
+
+func (t *SchemaTestAdapter) TableSchema(conn *sql.DB, tableName string) (string, error) {
+	return t.schemas[conn], nil
+}
+
+func TestVerifySchema(t *testing.T) {
 	mgr, err := NewConnectionManager()
 	checkErr(t, err)
 	defer mgr.Close()
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	t.Run("not sharding table", func(t *testing.T) {
-		conn, err := mgr.ConnectionByTableName("user_stages")
-		checkErr(t, err)
-		t.Run("without context", func(t *testing.T) {
-			conn.QueryRow(nil, "select * from user_stages where user_id = 1")
-		})
-		t.Run("with context", func(t *testing.T) {
-			conn.QueryRow(ctx, "select * from user_stages where user_id = 1")
+	conn, err := mgr.ConnectionByTableName("users")
+	checkErr(t, err)
+	if err := conn.VerifySchema("users"); pkgerrors.Cause(err) != ErrSchemaVerificationNotSupported {
+		t.Fatal("cannot handle error")
+	}
+	shard1 := conn.ShardConnections.ShardConnectionByName("user_shard_1").Connection
+	shard2 := conn.ShardConnections.ShardConnectionByName("user_shard_2").Connection
+	adapter := &SchemaTestAdapter{schemas: map[*sql.DB]string{
+		shard1: "create table users (id integer)",
+		shard2: "create table users (id integer)\n",
+	}}
+	verifiedConn := *conn
+	verifiedConn.Adapter = adapter
+	defer InvalidateMetadataCache()
+	checkErr(t, verifiedConn.VerifySchema("users"))
+
+	adapter.schemas[shard2] = "create table users (id integer, name varchar(255))"
+	checkErr(t, verifiedConn.VerifySchema("users"))
+	InvalidateMetadataCache(conn.ShardConnections.ShardConnectionByName("user_shard_2").DSN())
+	err = verifiedConn.VerifySchema("users")
+	mismatchErr, ok := err.(*SchemaMismatchError)
+	if !ok {
+		t.Fatalf("cannot detect schema differences %+v", err)
+	}
+	if pkgerrors.Cause(err) != ErrSchemaMismatch || len(mismatchErr.Schemas) != 2 {
+		t.Fatal("invalid error")
+	}
+	t.Run("warn", func(t *testing.T) {
+		var handledErr *SchemaMismatchError
+		SetSchemaMismatchHandler(func(err *SchemaMismatchError) {
+			handledErr = err
 		})
+		defer SetSchemaMismatchHandler(nil)
+		checkErr(t, verifiedConn.verifySchemaByConfig("users", &config.Config{SchemaVerification: config.SchemaVerificationWarn}))
+		if handledErr == nil || handledErr.TableName != "users" {
+			t.Fatal("cannot call handler")
+		}
+	})
+	t.Run("strict", func(t *testing.T) {
+		if err := verifiedConn.verifySchemaByConfig("users", &config.Config{SchemaVerification: config.SchemaVerificationStrict}); pkgerrors.Cause(err) != ErrSchemaMismatch {
+			t.Fatal("cannot handle error")
+		}
+	})
+	t.Run("not sharded table", func(t *testing.T) {
+		checkErr(t, mgr.VerifySchemas("user_stages"))
 	})
 }
 
-func TestPrepare(t *testing.T) {
+func TestSchemaDrift(t *testing.T) {
 	mgr, err := NewConnectionManager()
 	checkErr(t, err)
 	defer mgr.Close()
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	t.Run("not sharding table", func(t *testing.T) {
-		conn, err := mgr.ConnectionByTableName("user_stages")
-		checkErr(t, err)
-		t.Run("without context", func(t *testing.T) {
+	conn, err := mgr.ConnectionByTableName("user_items")
+	checkErr(t, err)
+	if _, err := conn.SchemaDrift("user_items"); pkgerrors.Cause(err) != ErrSchemaVerificationNotSupported {
+		t.Fatal("cannot handle error")
+	}
+	shard := func(name string) *sql.DB {
+		return conn.ShardConnections.ShardConnectionByName(name).Connection
+	}
+	schema := "create table user_items (id integer, user_id integer, name varchar(255), primary key (id), key idx_user_id (user_id))"
+	adapter := &SchemaTestAdapter{schemas: map[*sql.DB]string{}}
+	for _, shardConn := range conn.ShardConnections.AllShard() {
+		adapter.schemas[shardConn.Connection] = schema
+	}
+	adapter.schemas[shard("user_item_shard_2")] = "create table user_items (id integer, user_id bigint, extra integer, primary key (id))"
+	adapter.schemas[shard("user_item_shard_4")] = ""
+	driftConn := *conn
+	driftConn.Adapter = adapter
+	defer InvalidateMetadataCache()
+	drift, err := driftConn.SchemaDrift("user_items")
+	checkErr(t, err)
+	if drift == nil || len(drift.BaseShards) != len(conn.ShardConnections.AllShard())-2 || len(drift.Shards) != 2 {
+		t.Fatalf("cannot detect drift %+v", drift)
+	}
+	shard2 := drift.Shards[0]
+	if shard2.ShardName != "user_item_shard_2" ||
+		!reflect.DeepEqual(shard2.MissingColumns, []string{"name"}) ||
+		!reflect.DeepEqual(shard2.ExtraColumns, []string{"extra"}) ||
+		!reflect.DeepEqual(shard2.ChangedColumns, []string{"user_id"}) ||
+		!reflect.DeepEqual(shard2.MissingIndexes, []string{"idx_user_id"}) {
+		t.Fatalf("invalid drift %s", shard2)
+	}
+	if !drift.Shards[1].MissingTable {
+		t.Fatalf("cannot detect missing table %s", drift.Shards[1])
+	}
+
+	for _, name := range []string{"user_item_shard_2", "user_item_shard_4"} {
+		adapter.schemas[shard(name)] = schema
+	}
+	InvalidateMetadataCache()
+	drift, err = driftConn.SchemaDrift("user_items")
+	checkErr(t, err)
+	if drift != nil {
+		t.Fatalf("unexpected drift %s", drift)
+	}
+
+	t.Run("drifts of errors of VerifySchemas", func(t *testing.T) {
+		errs := &MultiError{}
+		errs.Add(&SchemaMismatchError{
+			TableName:  "user_items",
+			Schemas:    map[string]string{"user_item_shard_1": schema, "user_item_shard_2": ""},
+			ShardNames: []string{"user_item_shard_1", "user_item_shard_2"},
+		})
+		errs.Add(pkgerrors.Wrapf(ErrSchemaVerificationNotSupported, "users"))
+		drifts, err := schemaDrifts(errs)
+		if len(drifts) != 1 || !drifts[0].Shards[0].MissingTable {
+			t.Fatalf("cannot convert mismatch error to drift %v", drifts)
+		}
+		if multiErr, ok := err.(*MultiError); !ok || len(multiErr.Errors) != 1 || pkgerrors.Cause(multiErr.Errors[0]) != ErrSchemaVerificationNotSupported {
+			t.Fatalf("other errors must be returned. err = %v", err)
+		}
+	})
+}
+
+func TestSchemaCache(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	conn, err := mgr.ConnectionByTableName("users")
+	checkErr(t, err)
+	cache := mgr.SchemaCache()
+	if cache != mgr.SchemaCache() {
+		t.Fatal("cannot share schema cache")
+	}
+	if _, err := cache.Schema("users"); pkgerrors.Cause(err) != ErrSchemaNotSupported {
+		t.Fatal("cannot handle error")
+	}
+	originalAdapter := conn.Adapter
+	defer func() { conn.Adapter = originalAdapter }()
+	shard := conn.Shards()[0].Connection
+	adapter := &SchemaTestAdapter{schemas: map[*sql.DB]string{
+		shard: "create table users (id integer, name varchar(255))",
+	}}
+	conn.Adapter = adapter
+	checkErr(t, cache.Warm("users"))
+	schema, err := cache.Schema("users")
+	checkErr(t, err)
+	if !reflect.DeepEqual(schema.Columns(), []string{"id", "name"}) || !schema.HasColumn("NAME") || schema.HasColumn("age") || len(schema.PrimaryKey()) != 0 {
+		t.Fatalf("invalid schema %s", schema.Text)
+	}
+
+	adapter.schemas[shard] = "create table users (id integer, name varchar(255), age integer, primary key (id, name))"
+	if cached, _ := cache.Schema("users"); cached != schema {
+		t.Fatal("cannot cache schema")
+	}
+	checkErr(t, cache.Refresh())
+	if refreshed, _ := cache.Schema("users"); !refreshed.HasColumn("age") || !reflect.DeepEqual(refreshed.PrimaryKey(), []string{"id", "name"}) {
+		t.Fatal("cannot refresh schema")
+	}
+
+	adapter.schemas[shard] = ""
+	cache.Invalidate("users")
+	if _, err := cache.Schema("users"); pkgerrors.Cause(err) != ErrSchemaNotFound {
+		t.Fatal("cannot handle error")
+	}
+}
+
+func TestSetConfig(t *testing.T) {
+	cfg, err := config.Get()
+	checkErr(t, err)
+	if err := SetConfig(nil); err == nil {
+		t.Fatal("cannot handle error")
+	}
+	if getGlobalConfig() != cfg {
+		t.Fatal("current configuration must be kept")
+	}
+	copied := *cfg
+	checkErr(t, SetConfig(&copied))
+	defer SetConfig(cfg)
+	if current, _ := config.Get(); current != &copied {
+		t.Fatal("cannot set global configuration of config package")
+	}
+}
+
+func TestMetadataCache(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	conn, err := mgr.ConnectionByTableName("users")
+	checkErr(t, err)
+	defer InvalidateMetadataCache()
+	shard := conn.ShardConnections.ShardConnectionByName("user_shard_1")
+	adapter := &SchemaTestAdapter{schemas: map[*sql.DB]string{
+		shard.Connection: "create table users (id integer)",
+	}}
+	InvalidateMetadataCache()
+	before := GetMetadataCacheStats()
+	for i := 0; i < 3; i++ {
+		schema, err := cachedTableSchema(adapter, shard.DSN(), shard.Connection, "users")
+		checkErr(t, err)
+		if schema != "create table users (id integer)" {
+			t.Fatalf("invalid schema %s", schema)
+		}
+	}
+	stats := GetMetadataCacheStats()
+	if stats.Misses-before.Misses != 1 || stats.Hits-before.Hits != 2 || stats.Entries != 1 {
+		t.Fatalf("cannot cache result of metadata query %+v", stats)
+	}
+	t.Run("invalidate", func(t *testing.T) {
+		adapter.schemas[shard.Connection] = "create table users (id integer, name varchar(255))"
+		InvalidateMetadataCache(shard.DSN())
+		schema, err := cachedTableSchema(adapter, shard.DSN(), shard.Connection, "users")
+		checkErr(t, err)
+		if schema != "create table users (id integer, name varchar(255))" {
+			t.Fatalf("cannot invalidate cached schema %s", schema)
+		}
+		otherDSN := conn.ShardConnections.ShardConnectionByName("user_shard_2").DSN()
+		InvalidateMetadataCache(otherDSN)
+		if GetMetadataCacheStats().Entries != 1 {
+			t.Fatal("cached results of other databases must be kept")
+		}
+	})
+}
+
+type SlaveTestAdapter struct {
+	TestAdapter
+	slaves []string
+}
+
+func (t *SlaveTestAdapter) OpenSlaveConnection(config *config.DatabaseConfig, slave string, queryString string) (*sql.DB, error) {
+	t.slaves = append(t.slaves, slave)
+	return sql.Open("sqlite3", "")
+}
+
+func TestReadFromSlave(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	table := &config.TableConfig{
+		DatabaseConfig: config.DatabaseConfig{
+			Adapter: "sqlite3",
+			Slaves:  []string{"slave1", "slave2"},
+		},
+	}
+	master, err := sql.Open("sqlite3", "")
+	checkErr(t, err)
+	defer master.Close()
+	t.Run("disabled", func(t *testing.T) {
+		adapter := &SlaveTestAdapter{}
+		slaves, err := mgr.openSlaveConnections(adapter, table, &table.DatabaseConfig)
+		checkErr(t, err)
+		if len(slaves) != 0 || len(adapter.slaves) != 0 {
+			t.Fatal("cannot ignore slaves")
+		}
+		conn := &DBConnection{Config: table, Connection: master}
+		if conn.ReadConn() != master {
+			t.Fatal("cannot read from master")
+		}
+	})
+	table.ReadFromSlave = true
+	t.Run("not supported adapter", func(t *testing.T) {
+		if _, err := mgr.openSlaveConnections(&TestAdapter{}, table, &table.DatabaseConfig); err == nil {
+			t.Fatal("cannot handle error")
+		}
+	})
+	t.Run("round robin", func(t *testing.T) {
+		adapter := &SlaveTestAdapter{}
+		slaves, err := mgr.openSlaveConnections(adapter, table, &table.DatabaseConfig)
+		checkErr(t, err)
+		defer closeSlaves(slaves)
+		if !reflect.DeepEqual(adapter.slaves, []string{"slave1", "slave2"}) {
+			t.Fatalf("invalid slaves %v", adapter.slaves)
+		}
+		conn := &DBConnection{Config: table, Connection: master, Slaves: slaves}
+		if conn.Conn() != master {
+			t.Fatal("cannot write to master")
+		}
+		for i := 0; i < 4; i++ {
+			if conn.ReadConn() != slaves[i%2] {
+				t.Fatal("cannot balance slaves by round robin")
+			}
+		}
+		shardConn := &DBShardConnection{Connection: master, Slaves: slaves}
+		if shardConn.ReadConn() != slaves[0] || shardConn.ReadConn() != slaves[1] {
+			t.Fatal("cannot balance slaves by round robin")
+		}
+	})
+	t.Run("random", func(t *testing.T) {
+		slaves, err := mgr.openSlaveConnections(&SlaveTestAdapter{}, table, &table.DatabaseConfig)
+		checkErr(t, err)
+		defer closeSlaves(slaves)
+		shardConn := &DBShardConnection{Connection: master, Slaves: slaves, slaveBalancing: config.SlaveBalancingRandom}
+		for i := 0; i < 10; i++ {
+			if conn := shardConn.ReadConn(); conn != slaves[0] && conn != slaves[1] {
+				t.Fatal("cannot balance slaves randomly")
+			}
+		}
+	})
+}
+
+func TestCurrentSequenceID(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	id, err := mgr.CurrentSequenceID("users")
+	checkErr(t, err)
+	if id != 1 {
+		t.Fatal("cannot get current sequence id")
+	}
+}
+
+func TestNextSequenceID(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	id, err := mgr.NextSequenceID("users")
+	checkErr(t, err)
+	if id != 2 {
+		t.Fatal("cannot get next sequence id")
+	}
+	{
+		conn, err := mgr.ConnectionByTableName("users")
+		checkErr(t, err)
+		id, err := conn.NextSequenceID("users")
+		checkErr(t, err)
+		if id != 2 {
+			t.Fatal("cannot get next sequence id")
+		}
+	}
+}
+
+type BlockingSequencerTestAdapter struct {
+	TestAdapter
+	release chan struct{}
+}
+
+func (t *BlockingSequencerTestAdapter) NextSequenceID(conn *sql.DB, tableName string) (int64, error) {
+	<-t.release
+	return 2, nil
+}
+
+type ContextSequencerTestAdapter struct {
+	TestAdapter
+	ctx context.Context
+}
+
+func (t *ContextSequencerTestAdapter) CurrentSequenceIDContext(ctx context.Context, conn *sql.DB, tableName string) (int64, error) {
+	t.ctx = ctx
+	return 1, nil
+}
+
+func (t *ContextSequencerTestAdapter) NextSequenceIDContext(ctx context.Context, conn *sql.DB, tableName string) (int64, error) {
+	t.ctx = ctx
+	return 2, ctx.Err()
+}
+
+func TestSequenceIDContext(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	conn, err := mgr.ConnectionByTableName("users")
+	checkErr(t, err)
+	t.Run("adapter not supporting context", func(t *testing.T) {
+		adapter := &BlockingSequencerTestAdapter{release: make(chan struct{})}
+		defer close(adapter.release)
+		blockingConn := *conn
+		blockingConn.Adapter = adapter
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if _, err := blockingConn.NextSequenceIDContext(ctx, "users"); pkgerrors.Cause(err) != context.DeadlineExceeded {
+			t.Fatalf("cannot stop waiting sequencer %+v", err)
+		}
+	})
+	t.Run("adapter supporting context", func(t *testing.T) {
+		adapter := &ContextSequencerTestAdapter{}
+		contextConn := *conn
+		contextConn.Adapter = adapter
+		ctx, cancel := context.WithCancel(context.Background())
+		id, err := contextConn.NextSequenceIDByKeyContext(ctx, "users", 1)
+		checkErr(t, err)
+		if id != 2 || adapter.ctx != ctx {
+			t.Fatal("cannot pass context to adapter")
+		}
+		cancel()
+		if _, err := contextConn.NextSequenceIDContext(ctx, "users"); pkgerrors.Cause(err) != context.Canceled {
+			t.Fatal("cannot handle error")
+		}
+		if _, err := contextConn.CurrentSequenceIDContext(nil, "users"); err != nil || adapter.ctx == nil {
+			t.Fatal("cannot use background context")
+		}
+	})
+}
+
+func TestPartitionedSequencer(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	conn, err := mgr.ConnectionByTableName("users")
+	checkErr(t, err)
+	conn.Config.Sequencer.Partitions = 3
+	defer func() { conn.Config.Sequencer.Partitions = 0 }()
+	if sequencerPartitionTableName("users", 1, 3) != "users_ids_1" {
+		t.Fatal("invalid partition table name")
+	}
+	if sequencerPartitionTableName("users", 0, 1) != "users_ids" {
+		t.Fatal("invalid table name without partitioning")
+	}
+	ids := map[int64]bool{}
+	for i := 0; i < 3; i++ {
+		id, err := conn.NextSequenceID("users")
+		checkErr(t, err)
+		ids[id] = true
+	}
+	if len(ids) != 3 || !ids[4] || !ids[5] || !ids[6] {
+		t.Fatalf("cannot get interleaved ids %v", ids)
+	}
+	idByKey, err := conn.NextSequenceIDByKey("users", 10)
+	checkErr(t, err)
+	for i := 0; i < 3; i++ {
+		id, err := conn.NextSequenceIDByKey("users", 10)
+		checkErr(t, err)
+		if id != idByKey {
+			t.Fatal("cannot select same partition by same key")
+		}
+	}
+	currentID, err := mgr.CurrentSequenceID("users")
+	checkErr(t, err)
+	if currentID != 3 {
+		t.Fatalf("cannot get current sequence id %d", currentID)
+	}
+}
+
+type SequenceBlockTestAdapter struct {
+	TestAdapter
+	mu        sync.Mutex
+	seqIDs    map[string]int64
+	allocated int
+}
+
+func (t *SequenceBlockTestAdapter) NextSequenceIDBlock(ctx context.Context, conn *sql.DB, tableName string, size int64) (int64, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seqIDs[tableName] += size
+	t.allocated++
+	return t.seqIDs[tableName], true, nil
+}
+
+// BlockingSequenceBlockTestAdapter blocks allocation of blocks of blockedTable until release is closed
+type BlockingSequenceBlockTestAdapter struct {
+	SequenceBlockTestAdapter
+	blockedTable string
+	allocating   chan struct{}
+	release      chan struct{}
+}
+
+func (t *BlockingSequenceBlockTestAdapter) NextSequenceIDBlock(ctx context.Context, conn *sql.DB, tableName string, size int64) (int64, bool, error) {
+	if tableName == t.blockedTable {
+		t.allocating <- struct{}{}
+		<-t.release
+	}
+	return t.SequenceBlockTestAdapter.NextSequenceIDBlock(ctx, conn, tableName, size)
+}
+
+func TestSequenceIDBlock(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	conn, err := mgr.ConnectionByTableName("users")
+	checkErr(t, err)
+	t.Run("adapter supporting block", func(t *testing.T) {
+		adapter := &SequenceBlockTestAdapter{seqIDs: map[string]int64{}}
+		blockConn := *conn
+		blockConn.Adapter = adapter
+		blockConn.sequenceBlocks = newSequenceBlockCache(3)
+		for expected := int64(1); expected <= 7; expected++ {
+			id, err := blockConn.NextSequenceID("users")
+			checkErr(t, err)
+			if id != expected {
+				t.Fatalf("cannot hand out id from block. expected %d but got %d", expected, id)
+			}
+		}
+		if adapter.allocated != 3 {
+			t.Fatalf("block must be allocated only when it is used up. allocated %d times", adapter.allocated)
+		}
+		blockConn.ResetSequenceIDBlocks()
+		id, err := blockConn.NextSequenceID("users")
+		checkErr(t, err)
+		if id != 10 || adapter.allocated != 4 {
+			t.Fatalf("cannot discard ids of block. id = %d", id)
+		}
+	})
+	t.Run("partitioned sequencer", func(t *testing.T) {
+		conn.Config.Sequencer.Partitions = 2
+		defer func() { conn.Config.Sequencer.Partitions = 0 }()
+		adapter := &SequenceBlockTestAdapter{seqIDs: map[string]int64{}}
+		blockConn := *conn
+		blockConn.Adapter = adapter
+		blockConn.sequenceBlocks = newSequenceBlockCache(2)
+		ids := map[int64]bool{}
+		for i := 0; i < 4; i++ {
+			id, err := blockConn.NextSequenceID("users")
+			checkErr(t, err)
+			ids[id] = true
+		}
+		if len(ids) != 4 || !ids[1] || !ids[2] || !ids[3] || !ids[4] {
+			t.Fatalf("cannot get interleaved ids from blocks %v", ids)
+		}
+	})
+	t.Run("allocation in progress", func(t *testing.T) {
+		adapter := &BlockingSequenceBlockTestAdapter{
+			SequenceBlockTestAdapter: SequenceBlockTestAdapter{seqIDs: map[string]int64{}},
+			blockedTable:             "blocked_seq",
+			allocating:               make(chan struct{}, 5),
+			release:                  make(chan struct{}),
+		}
+		blockConn := *conn
+		blockConn.Adapter = adapter
+		blockConn.sequenceBlocks = newSequenceBlockCache(3)
+		var wg sync.WaitGroup
+		ids := make([]int64, 5)
+		errs := make([]error, 5)
+		for i := range ids {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				ids[i], errs[i] = blockConn.sequenceBlocks.nextID(context.Background(), &blockConn, "users", "blocked_seq")
+			}(i)
+		}
+		<-adapter.allocating
+		done := make(chan error, 1)
+		go func() {
+			_, err := blockConn.sequenceBlocks.nextID(context.Background(), &blockConn, "users", "other_seq")
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			checkErr(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("id of other block must be handed out while block is allocated")
+		}
+		close(adapter.release)
+		wg.Wait()
+		handedOut := map[int64]bool{}
+		for i, id := range ids {
+			checkErr(t, errs[i])
+			handedOut[id] = true
+		}
+		if len(handedOut) != 5 {
+			t.Fatalf("same id is handed out %v", ids)
+		}
+		// blocked_seq: 2 blocks for 5 ids, other_seq: 1 block
+		if adapter.allocated != 3 {
+			t.Fatalf("callers waiting for allocation must share allocated block. allocated %d times", adapter.allocated)
+		}
+	})
+	t.Run("adapter not supporting block", func(t *testing.T) {
+		blockConn := *conn
+		blockConn.sequenceBlocks = newSequenceBlockCache(3)
+		if _, err := blockConn.NextSequenceID("users"); err == nil {
+			t.Fatal("cannot handle adapter not supporting block")
+		}
+	})
+	t.Run("reset by connection manager", func(t *testing.T) {
+		checkErr(t, mgr.ResetSequenceIDBlocks())
+		if err := mgr.ResetSequenceIDBlocks("unknown"); err == nil {
+			t.Fatal("cannot handle unknown table")
+		}
+	})
+}
+
+type CountingIDGenerator struct {
+	id int64
+}
+
+func (g *CountingIDGenerator) NextID(ctx context.Context) (int64, error) {
+	g.id += 100
+	return g.id, nil
+}
+
+func TestIDGenerator(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	conn, err := mgr.ConnectionByTableName("users")
+	checkErr(t, err)
+	generatorConn := *conn
+	generatorConn.Sequencer = nil
+	generatorConn.IDGenerator = &CountingIDGenerator{}
+	generatorConn.Config = &config.TableConfig{Sequencer: &config.DatabaseConfig{Type: "counting"}}
+	id, err := generatorConn.NextSequenceID("users")
+	checkErr(t, err)
+	if id != 100 {
+		t.Fatalf("cannot generate id by id generator. id = %d", id)
+	}
+	if id, err := generatorConn.NextSequenceIDByKey("users", 1); err != nil || id != 200 {
+		t.Fatalf("cannot generate id by id generator. id = %d", id)
+	}
+	if _, err := generatorConn.CurrentSequenceID("users"); err == nil {
+		t.Fatal("id generator must not have current id")
+	}
+	t.Run("open by configuration", func(t *testing.T) {
+		table := getGlobalConfig().Tables["users"]
+		sequencer := table.Sequencer
+		table.Sequencer = &config.DatabaseConfig{Type: "snowflake", NodeID: 3}
+		defer func() { table.Sequencer = sequencer }()
+		mgr, err := NewConnectionManager()
+		checkErr(t, err)
+		defer mgr.Close()
+		conn, err := mgr.ConnectionByTableName("users")
+		checkErr(t, err)
+		if conn.Sequencer != nil || conn.IDGenerator == nil {
+			t.Fatal("cannot open id generator instead of sequencer")
+		}
+		if id, err := mgr.NextSequenceID("users"); err != nil || id <= 0 {
+			t.Fatalf("cannot generate id by snowflake. id = %d, err = %+v", id, err)
+		}
+	})
+}
+
+func TestIsShardTable(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	if !mgr.IsShardTable("users") {
+		t.Fatal("cannot set is_shard configuration")
+	}
+	if mgr.IsShardTable("user_stages") {
+		t.Fatal("cannot set is_shard configuration")
+	}
+}
+
+func TestEqualDSN(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	conn, err := mgr.ConnectionByTableName("users")
+	checkErr(t, err)
+	t.Run("same instance", func(t *testing.T) {
+		if !conn.EqualDSN(conn) {
+			t.Fatal("cannot work equal dsn")
+		}
+	})
+	t.Run("another instance", func(t *testing.T) {
+		mgr, err := NewConnectionManager()
+		checkErr(t, err)
+		defer mgr.Close()
+		anotherConn, err := mgr.ConnectionByTableName("users")
+		checkErr(t, err)
+		if !conn.EqualDSN(anotherConn) {
+			t.Fatal("cannot work equal dsn")
+		}
+	})
+}
+
+func TestIsEqualShardColumnToShardKeyColumn(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	if !mgr.IsEqualShardColumnToShardKeyColumn("users") {
+		t.Fatal("cannot set shard_column and shard_key")
+	}
+	if mgr.IsEqualShardColumnToShardKeyColumn("user_items") {
+		t.Fatal("cannot set shard_column and shard_key")
+	}
+	if mgr.IsEqualShardColumnToShardKeyColumn("user_decks") {
+		t.Fatal("cannot set shard_column and shard_key")
+	}
+	if !mgr.IsEqualShardColumnToShardKeyColumn("user_stages") {
+		t.Fatal("cannot set shard_column and shard_key")
+	}
+	conn, err := mgr.ConnectionByTableName("users")
+	checkErr(t, err)
+	if !conn.IsEqualShardColumnToShardKeyColumn() {
+		t.Fatal("cannot set shard_column and shard_key")
+	}
+}
+
+type AutoIncrementOffsetTestAdapter struct {
+	TestAdapter
+}
+
+func (t *AutoIncrementOffsetTestAdapter) AutoIncrementOffsetQueryString(increment int, offset int) (string, bool) {
+	return fmt.Sprintf("increment=%d&offset=%d", increment, offset), true
+}
+
+func TestSequenceIDCache(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	if _, err := mgr.CachedSequenceID("users"); err == nil {
+		t.Fatal("cannot handle error before starting cache")
+	}
+	checkErr(t, mgr.StartSequenceIDCache(time.Hour))
+	stat, err := mgr.CachedSequenceID("users")
+	checkErr(t, err)
+	if stat.ID != 1 || stat.UpdatedAt.IsZero() || stat.Err != nil {
+		t.Fatalf("cannot cache current sequence id. %+v", stat)
+	}
+	if _, err := mgr.CachedSequenceID("user_stages"); err == nil {
+		t.Fatal("cannot handle table not using sequencer")
+	}
+	stats, err := mgr.CachedSequenceIDs()
+	checkErr(t, err)
+	if len(stats) == 0 || stats[0].TableName > stats[len(stats)-1].TableName {
+		t.Fatal("cannot get cached sequence ids of all tables")
+	}
+	mgr.StopSequenceIDCache()
+	if _, err := mgr.CachedSequenceID("users"); err == nil {
+		t.Fatal("cannot stop cache")
+	}
+}
+
+type SequencerMaintenanceTestAdapter struct {
+	TestAdapter
+	tableNames []string
+}
+
+func (t *SequencerMaintenanceTestAdapter) MaintainSequencer(ctx context.Context, conn *sql.DB, tableName string) ([]string, bool, error) {
+	t.tableNames = append(t.tableNames, tableName)
+	if tableName == "users_ids_1" {
+		return nil, false, errors.New("cannot optimize table")
+	}
+	return []string{"status: OK"}, true, nil
+}
+
+func TestSequencerMaintenance(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	t.Run("adapter not supporting maintenance", func(t *testing.T) {
+		results, err := mgr.MaintainSequencers(context.Background())
+		checkErr(t, err)
+		if len(results) == 0 || results[0].TableName > results[len(results)-1].TableName {
+			t.Fatal("cannot maintain sequencers of all tables")
+		}
+		for _, result := range results {
+			if result.Maintained || result.Err != nil {
+				t.Fatalf("sequencer must not be maintained. %+v", result)
+			}
+		}
+	})
+	t.Run("partitioned sequencer", func(t *testing.T) {
+		conn, err := mgr.ConnectionByTableName("users")
+		checkErr(t, err)
+		copied := *conn
+		adapter := &SequencerMaintenanceTestAdapter{}
+		copied.Adapter = adapter
+		conn.Config.Sequencer.Partitions = 2
+		defer func() { conn.Config.Sequencer.Partitions = 0 }()
+		results, err := copied.MaintainSequencer(context.Background(), "users")
+		checkErr(t, err)
+		if !reflect.DeepEqual(adapter.tableNames, []string{"users_ids_0", "users_ids_1"}) {
+			t.Fatalf("cannot maintain all partitions %v", adapter.tableNames)
+		}
+		if !results[0].Maintained || !reflect.DeepEqual(results[0].Messages, []string{"status: OK"}) {
+			t.Fatalf("cannot get result of maintenance %+v", results[0])
+		}
+		if results[1].Err == nil {
+			t.Fatal("cannot handle error of maintenance")
+		}
+	})
+	t.Run("table not using sequencer", func(t *testing.T) {
+		conn, err := mgr.ConnectionByTableName("user_stages")
+		checkErr(t, err)
+		if _, err := conn.MaintainSequencer(context.Background(), "user_stages"); err == nil {
+			t.Fatal("cannot handle table not using sequencer")
+		}
+	})
+	t.Run("periodic maintenance", func(t *testing.T) {
+		done := make(chan []*SequencerMaintenance, 1)
+		checkErr(t, mgr.StartSequencerMaintenance(10*time.Millisecond, func(results []*SequencerMaintenance, err error) {
+			checkErr(t, err)
+			select {
+			case done <- results:
+			default:
+			}
+		}))
+		defer mgr.StopSequencerMaintenance()
+		select {
+		case results := <-done:
+			if len(results) == 0 {
+				t.Fatal("cannot maintain sequencers periodically")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("sequencers are not maintained")
+		}
+		if err := mgr.StartSequencerMaintenance(0, nil); err == nil {
+			t.Fatal("cannot validate interval")
+		}
+	})
+}
+
+func TestHealthCheck(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	statuses, err := mgr.HealthCheck(context.Background())
+	checkErr(t, err)
+	var shared *HealthStatus
+	for idx, status := range statuses {
+		if !status.Healthy() {
+			t.Fatalf("database must be healthy %+v", status)
+		}
+		if idx > 0 && statuses[idx-1].DSN > status.DSN {
+			t.Fatal("statuses must be ordered by DSN")
+		}
+		if status.DSN == "/tmp/user_shard_1.bin" {
+			if shared != nil {
+				t.Fatal("database shared by tables must be checked once")
+			}
+			shared = status
+		}
+	}
+	if shared == nil || !reflect.DeepEqual(shared.Tables, []string{"user_profiles", "users"}) {
+		t.Fatalf("cannot get tables sharing database %+v", shared)
+	}
+	checkErr(t, mgr.Ping(context.Background()))
+
+	conn, err := mgr.ConnectionByTableName("user_stages")
+	checkErr(t, err)
+	conn.Connection.Close()
+	statuses, err = mgr.HealthCheck(context.Background())
+	checkErr(t, err)
+	unhealthy := []*HealthStatus{}
+	for _, status := range statuses {
+		if !status.Healthy() {
+			unhealthy = append(unhealthy, status)
+		}
+	}
+	if len(unhealthy) != 1 || unhealthy[0].DSN != "/tmp/user_stage.bin" || unhealthy[0].Role != PoolRoleMaster {
+		t.Fatalf("cannot find unhealthy database %+v", unhealthy)
+	}
+	err = mgr.Ping(context.Background())
+	multiErr, ok := err.(*MultiError)
+	if !ok || len(multiErr.ShardErrors()) != 1 || multiErr.ShardErrors()[0].DSN != "/tmp/user_stage.bin" {
+		t.Fatalf("cannot get error of unhealthy database %+v", err)
+	}
+}
+
+type AnalyzeTestAdapter struct {
+	TestAdapter
+	mu    sync.Mutex
+	conns []*sql.DB
+}
+
+func (t *AnalyzeTestAdapter) AnalyzeTable(ctx context.Context, conn *sql.DB, tableName string) ([]string, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.conns = append(t.conns, conn)
+	if len(t.conns) == 2 {
+		return nil, false, errors.New("cannot analyze table")
+	}
+	return []string{"status: OK"}, true, nil
+}
+
+func TestAnalyzeTable(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	t.Run("adapter not supporting analyze", func(t *testing.T) {
+		results, err := mgr.AnalyzeTables(context.Background(), nil, 2)
+		checkErr(t, err)
+		if len(results) == 0 || results[0].TableName > results[len(results)-1].TableName {
+			t.Fatal("cannot analyze all tables")
+		}
+		for _, result := range results {
+			if result.Analyzed || result.Err != nil {
+				t.Fatalf("table must not be analyzed. %+v", result)
+			}
+		}
+	})
+	t.Run("sharding table", func(t *testing.T) {
+		conn, err := mgr.ConnectionByTableName("users")
+		checkErr(t, err)
+		copied := *conn
+		adapter := &AnalyzeTestAdapter{}
+		copied.Adapter = adapter
+		results, err := copied.AnalyzeTable(context.Background(), "users", 1)
+		checkErr(t, err)
+		if len(adapter.conns) != 2 || len(results) != 2 {
+			t.Fatal("cannot analyze all shards")
+		}
+		if results[0].ShardName != "user_shard_1" || results[1].ShardName != "user_shard_2" {
+			t.Fatal("results must be ordered by shards")
+		}
+		if !results[0].Analyzed || !reflect.DeepEqual(results[0].Messages, []string{"status: OK"}) {
+			t.Fatalf("cannot get result of analyze %+v", results[0])
+		}
+		if results[1].Err == nil {
+			t.Fatal("cannot handle error of analyze")
+		}
+	})
+	t.Run("cancel context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		results, err := mgr.AnalyzeTables(ctx, []string{"users"}, 1)
+		if err == nil {
+			t.Fatal("cannot handle cancelled context")
+		}
+		if len(results) != 2 || results[0].Err == nil || results[1].Err == nil {
+			t.Fatal("cannot report shards skipped by cancellation")
+		}
+	})
+	t.Run("unknown table", func(t *testing.T) {
+		if _, err := mgr.AnalyzeTables(context.Background(), []string{"unknown"}, 1); err == nil {
+			t.Fatal("cannot handle unknown table")
+		}
+	})
+}
+
+func TestShardQueryString(t *testing.T) {
+	table := &config.TableConfig{
+		IsShard:       true,
+		Shards:        []map[string]*config.DatabaseConfig{{"shard_1": {}}, {"shard_2": {}}, {"shard_3": {}}},
+		AutoIncrement: config.AutoIncrementOffset,
+	}
+	mgr := &DBConnectionManager{queryString: "charset=utf8mb4"}
+	queryString, err := mgr.shardQueryString(&AutoIncrementOffsetTestAdapter{}, table, 1)
+	checkErr(t, err)
+	if queryString != "charset=utf8mb4&increment=3&offset=2" {
+		t.Fatalf("cannot offset auto increment ids. query string = %s", queryString)
+	}
+	if _, err := mgr.shardQueryString(&TestAdapter{}, table, 1); err == nil {
+		t.Fatal("cannot handle adapter not supporting offset of auto increment ids")
+	}
+	table.AutoIncrement = ""
+	queryString, err = mgr.shardQueryString(&TestAdapter{}, table, 1)
+	checkErr(t, err)
+	if queryString != "charset=utf8mb4" {
+		t.Fatalf("query string must not be changed. query string = %s", queryString)
+	}
+}
+
+func TestShardConnectionByID(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	conn, err := mgr.ConnectionByTableName("users")
+	checkErr(t, err)
+	{
+		shardConn, err := conn.ShardConnectionByID(1)
+		checkErr(t, err)
+		if shardConn.ShardName != "user_shard_2" {
+			t.Fatal("invalid shard connection by id")
+		}
+	}
+	{
+		shardConn, err := conn.ShardConnectionByID(2)
+		checkErr(t, err)
+		if shardConn.ShardName != "user_shard_1" {
+			t.Fatal("invalid shard connection by id")
+		}
+	}
+}
+
+func TestSubShards(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	table := &config.TableConfig{
+		DatabaseConfig: config.DatabaseConfig{Adapter: "sqlite3"},
+		IsShard:        true,
+		Shards: []map[string]*config.DatabaseConfig{
+			{"split_shard_1": {Adapter: "sqlite3", NameOrPath: "/tmp/split_shard_1.bin"}},
+			{"split_shard_2": {
+				SubShards: []map[string]*config.DatabaseConfig{
+					{"split_shard_2_1": {Adapter: "sqlite3", NameOrPath: "/tmp/split_shard_2_1.bin"}},
+					{"split_shard_2_2": {Adapter: "sqlite3", NameOrPath: "/tmp/split_shard_2_2.bin"}},
+				},
+			}},
+		},
+	}
+	checkErr(t, mgr.openShardConnection("split_users", table))
+	conn, err := mgr.ConnectionByTableName("split_users")
+	checkErr(t, err)
+	if conn.ShardConnections.ShardNum() != 3 {
+		t.Fatalf("cannot open sub-shards. shard num = %d", conn.ShardConnections.ShardNum())
+	}
+	if conn.ShardConnections.ShardConnectionByName("split_shard_2") != nil {
+		t.Fatal("split shard must not have connection")
+	}
+	assigned := map[string]int{}
+	for id := int64(1); id <= 100; id++ {
+		shardConn, err := conn.ShardConnectionByID(id)
+		checkErr(t, err)
+		if id%2 == 0 && shardConn.ShardName != "split_shard_1" {
+			t.Fatalf("id %d must be assigned to split_shard_1 by primary algorithm. shard = %s", id, shardConn.ShardName)
+		}
+		assigned[shardConn.ShardName]++
+	}
+	if assigned["split_shard_2_1"] == 0 || assigned["split_shard_2_2"] == 0 {
+		t.Fatalf("cannot distribute rows to sub-shards. assigned = %v", assigned)
+	}
+}
+
+func TestShardColumnName(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	if mgr.ShardColumnName("users") != "id" {
+		t.Fatal("cannot get shard_column name")
+	}
+	if mgr.ShardColumnName("user_items") != "" {
+		t.Fatal("cannot get shard_column name")
+	}
+	if mgr.ShardColumnName("user_decks") != "id" {
+		t.Fatal("cannot get shard_column name")
+	}
+	if mgr.ShardColumnName("user_stages") != "" {
+		t.Fatal("cannot get shard_column name")
+	}
+}
+
+func TestShardKeyColumnName(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	if mgr.ShardKeyColumnName("users") != "id" {
+		t.Fatal("cannot get shard_key name")
+	}
+	if mgr.ShardKeyColumnName("user_items") != "user_id" {
+		t.Fatal("cannot get shard_key name")
+	}
+	if mgr.ShardKeyColumnName("user_decks") != "user_id" {
+		t.Fatal("cannot get shard_key name")
+	}
+	if mgr.ShardKeyColumnName("user_stages") != "" {
+		t.Fatal("cannot get shard_key name")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	t.Run("not sharding table", func(t *testing.T) {
+		conn, err := mgr.ConnectionByTableName("user_stages")
+		checkErr(t, err)
+		t.Run("without context", func(t *testing.T) {
+			rows, err := conn.Query(nil, "select * from user_stages")
+			checkErr(t, err)
+			if columns, _ := rows.Columns(); len(columns) != 0 {
+				t.Fatal("unknown rows")
+			}
+		})
+		t.Run("with context", func(t *testing.T) {
+			rows, err := conn.Query(ctx, "select * from user_stages")
+			checkErr(t, err)
+			if columns, _ := rows.Columns(); len(columns) != 0 {
+				t.Fatal("unknown rows")
+			}
+		})
+	})
+}
+
+func TestQueryRow(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	t.Run("not sharding table", func(t *testing.T) {
+		conn, err := mgr.ConnectionByTableName("user_stages")
+		checkErr(t, err)
+		t.Run("without context", func(t *testing.T) {
+			conn.QueryRow(nil, "select * from user_stages where user_id = 1")
+		})
+		t.Run("with context", func(t *testing.T) {
+			conn.QueryRow(ctx, "select * from user_stages where user_id = 1")
+		})
+	})
+}
+
+func TestPrepare(t *testing.T) {
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	t.Run("not sharding table", func(t *testing.T) {
+		conn, err := mgr.ConnectionByTableName("user_stages")
+		checkErr(t, err)
+		t.Run("without context", func(t *testing.T) {
 			stmt, err := conn.Prepare(nil, "select * from user_stages where user_id = ?")
 			checkErr(t, err)
 			checkErr(t, stmt.Close())
@@ -483,4 +1934,520 @@ func TestTransaction(t *testing.T) {
 		}
 		checkErr(t, tx.Rollback())
 	})
+	t.Run("exceed max transaction shards", func(t *testing.T) {
+		getGlobalConfig().MaxTransactionShards = 1
+		defer func() { getGlobalConfig().MaxTransactionShards = 0 }()
+		shardConn, err := mgr.ConnectionByTableName("users")
+		checkErr(t, err)
+		tx := conn.Begin(ctx, nil)
+		if _, err := tx.Exec(ctx, conn, "delete from user_stages where id = 1"); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if _, err := tx.Exec(ctx, conn, "delete from user_stages where id = 2"); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		_, err = tx.Exec(ctx, shardConn.ShardConnections.ShardConnectionByIndex(0), "delete from users where id = 1")
+		if pkgerrors.Cause(err) != ErrTransactionShardsLimitExceeded {
+			t.Fatalf("%+v\n", err)
+		}
+		checkErr(t, tx.Rollback())
+	})
+}
+
+type ReplicationPositionTestAdapter struct {
+	TestAdapter
+	position       string
+	waitedPosition string
+}
+
+func (t *ReplicationPositionTestAdapter) CurrentReplicationPosition(ctx context.Context, conn *sql.DB) (string, bool, error) {
+	return t.position, true, nil
+}
+
+func (t *ReplicationPositionTestAdapter) WaitForReplicationPosition(ctx context.Context, conn *sql.DB, position string) error {
+	t.waitedPosition = position
+	return ctx.Err()
+}
+
+func TestConsistencyToken(t *testing.T) {
+	slave, err := sql.Open("sqlite3", "")
+	checkErr(t, err)
+	defer slave.Close()
+	t.Run("encode", func(t *testing.T) {
+		token := &ConsistencyToken{Positions: map[string]string{"shard1": "uuid:1-10"}}
+		token.Merge(&ConsistencyToken{Positions: map[string]string{"shard2": "uuid:1-20"}})
+		parsed, err := ParseConsistencyToken(token.String())
+		checkErr(t, err)
+		if !reflect.DeepEqual(parsed.Positions, map[string]string{"shard1": "uuid:1-10", "shard2": "uuid:1-20"}) {
+			t.Fatalf("cannot decode token %v", parsed.Positions)
+		}
+		if _, err := ParseConsistencyToken("%"); err == nil {
+			t.Fatal("cannot handle error")
+		}
+	})
+	t.Run("tx", func(t *testing.T) {
+		mgr, err := NewConnectionManager()
+		checkErr(t, err)
+		defer mgr.Close()
+		conn, err := mgr.ConnectionByTableName("users")
+		checkErr(t, err)
+		copied := *conn
+		copied.Adapter = &ReplicationPositionTestAdapter{position: "uuid:1-10"}
+		tx := copied.Begin(nil, nil)
+		shard := conn.ShardConnections.ShardConnectionByIndex(0)
+		_, err = tx.QueryRow(nil, conn.ShardConnections.ShardConnectionByIndex(1), "select 1")
+		checkErr(t, err)
+		_, err = tx.Exec(nil, shard, "delete from users where id = 0")
+		checkErr(t, err)
+		checkErr(t, tx.Commit())
+		token, err := tx.ConsistencyToken(nil)
+		checkErr(t, err)
+		if !reflect.DeepEqual(token.Positions, map[string]string{shard.DSN(): "uuid:1-10"}) {
+			t.Fatalf("token must have only written shard %v", token.Positions)
+		}
+	})
+	t.Run("wait", func(t *testing.T) {
+		adapter := &ReplicationPositionTestAdapter{}
+		slavesByDSN := map[string][]*replicaSlave{
+			"shard1": {{adapter: adapter, conn: slave}},
+		}
+		token := &ConsistencyToken{Positions: map[string]string{"shard1": "uuid:1-10", "shard2": ""}}
+		checkErr(t, waitForConsistencyToken(context.Background(), token, slavesByDSN))
+		if adapter.waitedPosition != "uuid:1-10" {
+			t.Fatal("cannot wait for position")
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := waitForConsistencyToken(ctx, token, slavesByDSN); pkgerrors.Cause(err) != context.Canceled {
+			t.Fatalf("cannot handle cancelled context %+v", err)
+		}
+		token.Positions["shard1"] = ""
+		if err := waitForConsistencyToken(context.Background(), token, slavesByDSN); err == nil {
+			t.Fatal("cannot handle unknown position")
+		}
+		slavesByDSN["shard1"][0].adapter = &TestAdapter{}
+		token.Positions["shard1"] = "uuid:1-10"
+		if err := waitForConsistencyToken(context.Background(), token, slavesByDSN); err == nil {
+			t.Fatal("cannot handle adapter not supporting replication position")
+		}
+	})
+}
+
+func TestCaptureReplicationPosition(t *testing.T) {
+	getGlobalConfig().CaptureReplicationPosition = true
+	defer func() { getGlobalConfig().CaptureReplicationPosition = false }()
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	conn, err := mgr.ConnectionByTableName("users")
+	checkErr(t, err)
+	copied := *conn
+	adapter := &ReplicationPositionTestAdapter{position: "uuid:1-10"}
+	copied.Adapter = adapter
+	tx := copied.Begin(nil, nil)
+	shard := conn.ShardConnections.ShardConnectionByIndex(0)
+	_, err = tx.Exec(nil, shard, "delete from users where id = 0")
+	checkErr(t, err)
+	tx.AfterCommitSuccessCallback = func() error {
+		if len(tx.WriteQueries) != 1 {
+			t.Fatal("invalid write queries")
+		}
+		if log := tx.WriteQueries[0]; log.DSN != shard.DSN() || log.ReplicationPosition != "uuid:1-10" {
+			t.Fatalf("cannot capture replication position %+v", log)
+		}
+		return nil
+	}
+	checkErr(t, tx.Commit())
+
+	// token reuses position captured at commit
+	adapter.position = "uuid:1-20"
+	token, err := tx.ConsistencyToken(nil)
+	checkErr(t, err)
+	if token.Positions[shard.DSN()] != "uuid:1-10" {
+		t.Fatalf("cannot reuse captured position %v", token.Positions)
+	}
+}
+
+type RetryTestAdapter struct {
+	TestAdapter
+}
+
+var errTestDeadlock = errors.New("deadlock")
+
+func (t *RetryTestAdapter) ErrorClass(err error) string {
+	if pkgerrors.Cause(err) == errTestDeadlock {
+		return config.RetryOnDeadlock
+	}
+	return ""
+}
+
+func TestRetry(t *testing.T) {
+	retry := &config.RetryConfig{MaxRetries: 2, Backoff: time.Millisecond}
+	conn := &DBConnection{
+		Config:  &config.TableConfig{RetryConfig: retry},
+		Adapter: &RetryTestAdapter{},
+	}
+	failure := func(calls *int, err error, failures int) func() error {
+		return func() error {
+			*calls++
+			if *calls <= failures {
+				return err
+			}
+			return nil
+		}
+	}
+	t.Run("retry transient error", func(t *testing.T) {
+		calls := 0
+		checkErr(t, conn.Retry(context.Background(), true, failure(&calls, pkgerrors.WithStack(errTestDeadlock), 2)))
+		if calls != 3 {
+			t.Fatalf("cannot retry query. calls = %d", calls)
+		}
+		calls = 0
+		if err := conn.Retry(nil, false, failure(&calls, errTestDeadlock, 3)); pkgerrors.Cause(err) != errTestDeadlock || calls != 3 {
+			t.Fatalf("must give up after max_retries. calls = %d", calls)
+		}
+	})
+	t.Run("not transient error", func(t *testing.T) {
+		calls := 0
+		if err := conn.Retry(nil, false, failure(&calls, errors.New("syntax error"), 1)); err == nil || calls != 1 {
+			t.Fatal("must not retry error that is not transient")
+		}
+	})
+	t.Run("connection error", func(t *testing.T) {
+		calls := 0
+		checkErr(t, conn.Retry(nil, false, failure(&calls, driver.ErrBadConn, 1)))
+		if calls != 2 {
+			t.Fatal("cannot retry read query by connection error")
+		}
+		calls = 0
+		if err := conn.Retry(nil, true, failure(&calls, driver.ErrBadConn, 1)); err != driver.ErrBadConn || calls != 1 {
+			t.Fatal("write query must not be retried by connection error")
+		}
+		retry.RetryOn = []string{config.RetryOnDeadlock}
+		defer func() { retry.RetryOn = nil }()
+		calls = 0
+		if err := conn.Retry(nil, false, failure(&calls, driver.ErrBadConn, 1)); err == nil || calls != 1 {
+			t.Fatal("must retry only classes of retry_on")
+		}
+	})
+	t.Run("deadline", func(t *testing.T) {
+		retry.Backoff = time.Second
+		defer func() { retry.Backoff = time.Millisecond }()
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		calls := 0
+		startedAt := time.Now()
+		if err := conn.Retry(ctx, false, failure(&calls, errTestDeadlock, 1)); err == nil || calls != 1 {
+			t.Fatal("must not retry after deadline")
+		}
+		if time.Since(startedAt) > 500*time.Millisecond {
+			t.Fatal("must not wait for backoff beyond deadline")
+		}
+	})
+	t.Run("first read in transaction", func(t *testing.T) {
+		getGlobalConfig().RetryConfig = retry
+		defer func() { getGlobalConfig().RetryConfig = nil }()
+		db, err := sql.Open("sqlite3", "")
+		checkErr(t, err)
+		defer db.Close()
+		shardConn := &DBShardConnection{ShardName: "shard", Connection: db, dsn: "shard_dsn"}
+		tx := conn.Begin(context.Background(), nil)
+		calls := 0
+		checkErr(t, tx.readWithRetry(context.Background(), shardConn, func(*sql.Tx) error {
+			calls++
+			if calls == 1 {
+				return errTestDeadlock
+			}
+			return nil
+		}))
+		if calls != 2 || len(tx.dsnList) != 1 || tx.dsnToTx["shard_dsn"] == nil {
+			t.Fatalf("cannot retry first read in transaction. calls = %d", calls)
+		}
+		calls = 0
+		if err := tx.readWithRetry(context.Background(), shardConn, func(*sql.Tx) error {
+			calls++
+			return errTestDeadlock
+		}); err == nil || calls != 1 {
+			t.Fatal("read after transaction is begun must not be retried")
+		}
+		checkErr(t, tx.Rollback())
+	})
+}
+
+type FailoverTestAdapter struct {
+	TestAdapter
+	mu   sync.Mutex
+	down map[string]bool
+}
+
+func (t *FailoverTestAdapter) OpenBackupConnection(config *config.DatabaseConfig, backup string, queryString string) (*sql.DB, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	conn, err := sql.Open("sqlite3", "")
+	if err != nil {
+		return nil, err
+	}
+	if t.down[backup] {
+		conn.Close()
+	}
+	return conn, nil
+}
+
+func TestFailover(t *testing.T) {
+	failoverAdapter := &FailoverTestAdapter{down: map[string]bool{"backup1": true}}
+	adapter.Register("failover_test", failoverAdapter)
+	getGlobalConfig().Tables["failover_items"] = &config.TableConfig{
+		DatabaseConfig: config.DatabaseConfig{
+			Adapter:    "failover_test",
+			NameOrPath: "items",
+			Masters:    []string{"master"},
+			Backups:    []string{"backup1", "backup2"},
+		},
+	}
+	defer delete(getGlobalConfig().Tables, "failover_items")
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	mgr.SetCredentialDrainTimeout(10 * time.Millisecond)
+	events := []*FailoverEvent{}
+	mgr.SetFailoverCallback(func(event *FailoverEvent) {
+		events = append(events, event)
+	})
+	conn, err := mgr.ConnectionByTableName("failover_items")
+	checkErr(t, err)
+	t.Run("master is alive", func(t *testing.T) {
+		calls := 0
+		if err := conn.RetryWithFailover(nil, conn, false, func(Connection) error {
+			calls++
+			return driver.ErrBadConn
+		}); err != driver.ErrBadConn || calls != 1 || len(events) != 0 {
+			t.Fatal("must not fail over master responding to ping")
+		}
+	})
+	t.Run("fail over to backup", func(t *testing.T) {
+		conn.Connection.Close()
+		dsnList := []string{}
+		checkErr(t, conn.RetryWithFailover(nil, conn, false, func(c Connection) error {
+			dsnList = append(dsnList, c.DSN())
+			if c.Conn() == conn.Connection {
+				return driver.ErrBadConn
+			}
+			return nil
+		}))
+		if !reflect.DeepEqual(dsnList, []string{"master/items", "backup2/items"}) {
+			t.Fatalf("cannot call again with connection to backup. %v", dsnList)
+		}
+		if len(events) != 1 || events[0].Table != "failover_items" || events[0].From != "master" || events[0].To != "backup2" || events[0].Err != driver.ErrBadConn {
+			t.Fatalf("cannot notify failover %+v", events)
+		}
+		newConn, err := mgr.ConnectionByTableName("failover_items")
+		checkErr(t, err)
+		if newConn.Connection == conn.Connection {
+			t.Fatal("cannot swap connection")
+		}
+		cfg := newConn.Config.DatabaseConfig
+		if !reflect.DeepEqual(cfg.Masters, []string{"backup2"}) || !reflect.DeepEqual(cfg.Backups, []string{"backup1", "master"}) {
+			t.Fatalf("cannot exchange master and backup. master = %v, backup = %v", cfg.Masters, cfg.Backups)
+		}
+		calls := 0
+		if err := conn.RetryWithFailover(nil, conn, true, func(Connection) error {
+			calls++
+			return driver.ErrBadConn
+		}); err != driver.ErrBadConn || calls != 1 || len(events) != 1 {
+			t.Fatal("write query must not be called again")
+		}
+	})
+	t.Run("promote", func(t *testing.T) {
+		checkErr(t, mgr.Promote("failover_items", "", "master"))
+		if len(events) != 2 || events[1].From != "backup2" || events[1].To != "master" || events[1].Err != nil {
+			t.Fatalf("cannot notify promotion %+v", events)
+		}
+		if err := mgr.Promote("failover_items", "", "backup1"); err == nil {
+			t.Fatal("must not promote backup not responding to ping")
+		}
+		if err := mgr.Promote("failover_items", "", "unknown"); err == nil {
+			t.Fatal("must not promote server not in backup")
+		}
+		if err := mgr.Promote("failover_items", "shard", "backup2"); err == nil {
+			t.Fatal("must not promote shard of not sharded table")
+		}
+		if err := mgr.Promote("users", "user_shard_1", "backup"); err == nil {
+			t.Fatal("must not promote shard without backup")
+		}
+	})
+	t.Run("concurrent promotion", func(t *testing.T) {
+		mgr.SetCredentialDrainTimeout(time.Second)
+		defer mgr.SetCredentialDrainTimeout(10 * time.Millisecond)
+		conn, err := mgr.ConnectionByTableName("failover_items")
+		checkErr(t, err)
+		dsn := conn.DSN()
+		var started, wg sync.WaitGroup
+		done := make(chan struct{})
+		for i := 0; i < 4; i++ {
+			started.Add(1)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for read := 0; ; read++ {
+					// DSN of connection got before promotion is still used by queries in progress
+					if conn.DSN() != dsn {
+						t.Errorf("DSN of connection is changed by promotion. %s", conn.DSN())
+					}
+					cfg, err := config.Get()
+					if err != nil {
+						t.Errorf("%+v", err)
+					} else {
+						_ = cfg.Tables["failover_items"].Masters[0]
+					}
+					current, err := mgr.ConnectionByTableName("failover_items")
+					if err != nil {
+						t.Errorf("%+v", err)
+					} else {
+						if _, err := current.Connection.Exec("SELECT 1"); err != nil {
+							t.Errorf("%+v", err)
+						}
+					}
+					if read == 0 {
+						started.Done()
+					}
+					select {
+					case <-done:
+						return
+					default:
+					}
+				}
+			}()
+		}
+		started.Wait()
+		for i := 0; i < 10; i++ {
+			backup := "backup2"
+			if i%2 == 1 {
+				backup = "master"
+			}
+			if err := mgr.Promote("failover_items", "", backup); err != nil {
+				t.Errorf("%+v", err)
+				break
+			}
+		}
+		close(done)
+		wg.Wait()
+		cfg, err := config.Get()
+		checkErr(t, err)
+		if !reflect.DeepEqual(cfg.Tables["failover_items"].Masters, []string{"master"}) {
+			t.Fatalf("cannot set promoted master to configuration. %v", cfg.Tables["failover_items"].Masters)
+		}
+	})
+}
+
+type SequencerFailoverTestAdapter struct {
+	TestAdapter
+	mu     sync.Mutex
+	seqIDs map[string]int64
+}
+
+func (t *SequencerFailoverTestAdapter) CurrentSequenceID(conn *sql.DB, tableName string) (int64, error) {
+	if err := conn.Ping(); err != nil {
+		return 0, driver.ErrBadConn
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.seqIDs[fmt.Sprintf("%p/%s", conn, tableName)], nil
+}
+
+func (t *SequencerFailoverTestAdapter) NextSequenceID(conn *sql.DB, tableName string) (int64, error) {
+	if err := conn.Ping(); err != nil {
+		return 0, driver.ErrBadConn
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := fmt.Sprintf("%p/%s", conn, tableName)
+	t.seqIDs[key]++
+	return t.seqIDs[key], nil
+}
+
+func TestSequencerFailover(t *testing.T) {
+	adapter.Register("sequencer_failover_test", &SequencerFailoverTestAdapter{seqIDs: map[string]int64{}})
+	mgr, err := NewConnectionManager()
+	checkErr(t, err)
+	defer mgr.Close()
+	events := []*FailoverEvent{}
+	mgr.SetFailoverCallback(func(event *FailoverEvent) {
+		events = append(events, event)
+	})
+	table := &config.TableConfig{
+		DatabaseConfig:  config.DatabaseConfig{Adapter: "sequencer_failover_test"},
+		IsShard:         true,
+		ShardColumnName: "id",
+		Sequencer: &config.DatabaseConfig{
+			Adapter:    "sequencer_failover_test",
+			NameOrPath: "seq1",
+			Partitions: 2,
+			Replicas: []*config.DatabaseConfig{
+				{Adapter: "sequencer_failover_test", NameOrPath: "seq2"},
+				{Adapter: "sequencer_failover_test", NameOrPath: "seq3"},
+			},
+		},
+		Shards: []map[string]*config.DatabaseConfig{
+			{"sequencer_failover_shard": {Adapter: "sequencer_failover_test", NameOrPath: "shard"}},
+		},
+	}
+	checkErr(t, mgr.openShardConnection("sequencer_failover_items", table))
+	conn, err := mgr.ConnectionByTableName("sequencer_failover_items")
+	checkErr(t, err)
+	if len(conn.sequencers) != 3 || conn.Sequencer != conn.sequencers[0] {
+		t.Fatal("cannot open connections to replicas of sequencer")
+	}
+	ids := map[int64]bool{}
+	nextID := func(conn *DBConnection) int64 {
+		id, err := conn.NextSequenceID("sequencer_failover_items")
+		checkErr(t, err)
+		if ids[id] {
+			t.Fatalf("id %d is published twice", id)
+		}
+		ids[id] = true
+		return id
+	}
+	t.Run("sequencer is alive", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			if id := nextID(conn); id != 1 && id != 2 {
+				t.Fatalf("invalid id of sequencer %d", id)
+			}
+		}
+		if len(events) != 0 {
+			t.Fatal("must not fail over sequencer responding to ping")
+		}
+	})
+	t.Run("fail over to next replica", func(t *testing.T) {
+		conn.sequencers[0].Close()
+		conn.sequencers[1].Close()
+		for i := 0; i < 2; i++ {
+			if id := nextID(conn); id != 5 && id != 6 {
+				t.Fatalf("id must be published by range of replica. but got %d", id)
+			}
+		}
+		if len(events) != 1 || events[0].Table != "sequencer_failover_items" || events[0].From != "seq1" || events[0].To != "seq3" || pkgerrors.Cause(events[0].Err) != driver.ErrBadConn {
+			t.Fatalf("cannot notify failover of sequencer %+v", events)
+		}
+		newConn, err := mgr.ConnectionByTableName("sequencer_failover_items")
+		checkErr(t, err)
+		if newConn.Sequencer != newConn.sequencers[2] || newConn.sequencerIndex != 2 {
+			t.Fatal("cannot swap sequencer with replica")
+		}
+		for i := 0; i < 2; i++ {
+			if id := nextID(newConn); id != 11 && id != 12 {
+				t.Fatalf("id must be published by range of replica. but got %d", id)
+			}
+		}
+		if len(events) != 1 {
+			t.Fatal("must not fail over again")
+		}
+	})
+	t.Run("all sequencers are down", func(t *testing.T) {
+		newConn, err := mgr.ConnectionByTableName("sequencer_failover_items")
+		checkErr(t, err)
+		newConn.sequencers[2].Close()
+		if _, err := newConn.NextSequenceID("sequencer_failover_items"); err == nil {
+			t.Fatal("must return error if no replica responds to ping")
+		}
+	})
 }
@@ -0,0 +1,202 @@
+package connection
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	adap "go.knocknote.io/octillery/connection/adapter"
+)
+
+// ConsistencyToken replication positions of masters after writes ( e.g. GTID of MySQL ).
+//
+// Reads after WaitForConsistencyToken see the writes even if they are executed on slaves.
+// Token is encoded to string by String and decoded by ParseConsistencyToken, so it can be passed across requests.
+type ConsistencyToken struct {
+	// replication position by DSN of master. position is empty if adapter doesn't support it
+	Positions map[string]string `json:"positions"`
+}
+
+// String encodes token to URL safe string
+func (t *ConsistencyToken) String() string {
+	if t == nil || len(t.Positions) == 0 {
+		return ""
+	}
+	encoded, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// Merge adds positions of other token. position of the same DSN is overwritten by other, so other should be newer
+func (t *ConsistencyToken) Merge(other *ConsistencyToken) {
+	if other == nil {
+		return
+	}
+	if t.Positions == nil {
+		t.Positions = map[string]string{}
+	}
+	for dsn, position := range other.Positions {
+		t.Positions[dsn] = position
+	}
+}
+
+// ParseConsistencyToken decodes token encoded by ConsistencyToken.String. empty string is decoded to empty token
+func ParseConsistencyToken(token string) (*ConsistencyToken, error) {
+	parsed := &ConsistencyToken{Positions: map[string]string{}}
+	if token == "" {
+		return parsed, nil
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid consistency token %s", token)
+	}
+	if err := json.Unmarshal(decoded, parsed); err != nil {
+		return nil, errors.Wrapf(err, "invalid consistency token %s", token)
+	}
+	return parsed, nil
+}
+
+func replicationPosition(ctx context.Context, adapter adap.DBAdapter, conn *sql.DB) (string, error) {
+	positionAdapter, ok := adapter.(adap.ReplicationPositionAdapter)
+	if !ok {
+		return "", nil
+	}
+	position, _, err := positionAdapter.CurrentReplicationPosition(ctx, conn)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return position, nil
+}
+
+// ConsistencyToken returns replication positions of databases written by transaction. It must be called after commit.
+func (c *TxConnection) ConsistencyToken(ctx context.Context) (*ConsistencyToken, error) {
+	token := &ConsistencyToken{Positions: map[string]string{}}
+	if c == nil {
+		return token, nil
+	}
+	if len(c.dsnToTx) > 0 && !c.isCommitted {
+		return nil, errors.New("cannot get consistency token before commit")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	for _, dsn := range c.dsnList {
+		if len(c.txToWriteQueries[c.dsnToTx[dsn]]) == 0 {
+			continue
+		}
+		if position, exists := c.committedPositions[dsn]; exists {
+			token.Positions[dsn] = position
+			continue
+		}
+		position, err := replicationPosition(ctx, c.adapter, c.dsnToConn[dsn].Conn())
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot get replication position of %s", dsn)
+		}
+		token.Positions[dsn] = position
+	}
+	return token, nil
+}
+
+// ConsistencyToken returns current replication positions of all shards ( or database of not sharded table ) of tables.
+// Call this after writes out of transaction.
+func (cm *DBConnectionManager) ConsistencyToken(ctx context.Context, tableNames ...string) (*ConsistencyToken, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	token := &ConsistencyToken{Positions: map[string]string{}}
+	for _, tableName := range tableNames {
+		conn, err := cm.ConnectionByTableName(tableName)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for _, shard := range conn.Shards() {
+			position, err := replicationPosition(ctx, conn.Adapter, shard.Conn())
+			if err != nil {
+				return nil, errors.Wrapf(err, "cannot get replication position of %s", shard.DSN())
+			}
+			token.Positions[shard.DSN()] = position
+		}
+	}
+	return token, nil
+}
+
+// replicaSlave slave connection that replicates master
+type replicaSlave struct {
+	adapter adap.DBAdapter
+	conn    *sql.DB
+}
+
+// slavesByDSN returns slaves of all tables enabled 'read_from_slave' by DSN of master
+func (cm *DBConnectionManager) slavesByDSN() (map[string][]*replicaSlave, error) {
+	slavesByDSN := map[string][]*replicaSlave{}
+	cfg := getGlobalConfig()
+	if cfg == nil {
+		return slavesByDSN, nil
+	}
+	added := map[*sql.DB]bool{}
+	add := func(adapter adap.DBAdapter, dsn string, slaves []*sql.DB) {
+		for _, slave := range slaves {
+			if added[slave] {
+				continue
+			}
+			added[slave] = true
+			slavesByDSN[dsn] = append(slavesByDSN[dsn], &replicaSlave{adapter: adapter, conn: slave})
+		}
+	}
+	for tableName, table := range cfg.Tables {
+		if !table.ReadFromSlave {
+			continue
+		}
+		conn, err := cm.ConnectionByTableName(tableName)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if !conn.IsShard {
+			add(conn.Adapter, conn.DSN(), conn.Slaves)
+			continue
+		}
+		for _, shard := range conn.ShardConnections.AllShard() {
+			add(conn.Adapter, shard.DSN(), shard.Slaves)
+		}
+	}
+	return slavesByDSN, nil
+}
+
+// WaitForConsistencyToken blocks until all slaves of masters in token apply changes up to the positions, or context is done.
+// If master has slaves but its position is unknown because adapter doesn't support it, returns error.
+func (cm *DBConnectionManager) WaitForConsistencyToken(ctx context.Context, token *ConsistencyToken) error {
+	if token == nil || len(token.Positions) == 0 {
+		return nil
+	}
+	slavesByDSN, err := cm.slavesByDSN()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(waitForConsistencyToken(ctx, token, slavesByDSN))
+}
+
+func waitForConsistencyToken(ctx context.Context, token *ConsistencyToken, slavesByDSN map[string][]*replicaSlave) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	for dsn, position := range token.Positions {
+		slaves := slavesByDSN[dsn]
+		if len(slaves) == 0 {
+			continue
+		}
+		if position == "" {
+			return errors.Errorf("cannot wait for slaves of %s. replication position is unknown", dsn)
+		}
+		for _, slave := range slaves {
+			positionAdapter, ok := slave.adapter.(adap.ReplicationPositionAdapter)
+			if !ok {
+				return errors.Errorf("cannot wait for slaves of %s. adapter doesn't support replication position", dsn)
+			}
+			if err := positionAdapter.WaitForReplicationPosition(ctx, slave.conn, position); err != nil {
+				return errors.Wrapf(err, "cannot wait for slave of %s", dsn)
+			}
+		}
+	}
+	return nil
+}
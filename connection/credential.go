@@ -0,0 +1,283 @@
+package connection
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/algorithm"
+	"go.knocknote.io/octillery/config"
+	adap "go.knocknote.io/octillery/connection/adapter"
+)
+
+const (
+	// DefaultCredentialDrainTimeout default time for waiting queries in progress on old connection pool after rotation
+	DefaultCredentialDrainTimeout = 30 * time.Second
+)
+
+// globalConfigMu serializes replacing configuration of table in global configuration by rotated one
+var globalConfigMu sync.Mutex
+
+type credentialRotation struct {
+	table   *config.TableConfig
+	config  *config.DatabaseConfig
+	oldConn *sql.DB
+	newConn *sql.DB
+}
+
+// SetCredentialDrainTimeout set up time for waiting queries in progress on old connection pool after credential rotation.
+// Old connection pool is closed after this duration. If not specified, DefaultCredentialDrainTimeout is used.
+func (cm *DBConnectionManager) SetCredentialDrainTimeout(d time.Duration) {
+	cm.credentialDrainTimeout = d
+}
+
+// RotateCredentials opens new connection pools by username and password for database of tableName,
+// and swaps current pools with them atomically.
+// If shardName is empty, all databases of table ( includes sequencer ) are rotated.
+// Old pools are not closed immediately, they are closed after queries in progress are drained.
+// Configuration of table is never changed in place. Copy of it having new credentials is swapped in with connections,
+// and it is also set to global configuration, so connections opened after rotation use new credentials.
+func (cm *DBConnectionManager) RotateCredentials(tableName string, shardName string, username string, password string) error {
+	cm.credentialMu.Lock()
+	defer cm.credentialMu.Unlock()
+
+	conn, err := cm.ConnectionByTableName(tableName)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	rotations := []*credentialRotation{}
+	if conn.IsShard {
+		if shardName == "" && conn.Sequencer != nil {
+			seqConfigs := sequencerConfigs(conn.Config.Sequencer)
+			for idx, seqConn := range conn.sequencerConns() {
+				rotations = append(rotations, &credentialRotation{table: conn.Config, config: seqConfigs[idx], oldConn: seqConn})
+			}
+		}
+		for _, shardConn := range conn.ShardConnections.AllShard() {
+			if shardName != "" && shardName != shardConn.ShardName {
+				continue
+			}
+			rotations = append(rotations, &credentialRotation{
+				table:   conn.Config,
+				config:  conn.Config.ShardConfigByName(shardConn.ShardName),
+				oldConn: shardConn.Connection,
+			})
+		}
+	} else if shardName == "" {
+		rotations = append(rotations, &credentialRotation{table: conn.Config, config: &conn.Config.DatabaseConfig, oldConn: conn.Connection})
+	}
+	if len(rotations) == 0 {
+		return errors.Errorf("cannot find shard %s of %s", shardName, tableName)
+	}
+	if err := cm.openRotatedConnections(rotations, username, password); err != nil {
+		return errors.WithStack(err)
+	}
+	newConn, err := cm.rotatedConnection(conn, rotations)
+	if err != nil {
+		for _, rotation := range rotations {
+			closeConn(rotation.newConn)
+		}
+		return errors.WithStack(err)
+	}
+	targets := map[*config.DatabaseConfig]bool{}
+	for _, rotation := range rotations {
+		targets[rotation.config] = true
+	}
+	newConn.Config = rotatedTableConfig(conn.Config, targets, func(db *config.DatabaseConfig) {
+		db.Username = username
+		db.Password = password
+	})
+	cm.connMap.Set(tableName, newConn)
+	setRotatedTableConfig(tableName, conn.Config, newConn.Config)
+	for _, rotation := range rotations {
+		cm.drainConn(rotation.oldConn)
+	}
+	return nil
+}
+
+// rotatedTableConfig returns copy of table whose databases in targets are changed by rotate.
+// All databases are copied, so current configuration read by queries in progress is never changed.
+func rotatedTableConfig(table *config.TableConfig, targets map[*config.DatabaseConfig]bool, rotate func(db *config.DatabaseConfig)) *config.TableConfig {
+	var copyDatabase func(db *config.DatabaseConfig) *config.DatabaseConfig
+	copyShards := func(shards []map[string]*config.DatabaseConfig) []map[string]*config.DatabaseConfig {
+		if shards == nil {
+			return nil
+		}
+		copied := make([]map[string]*config.DatabaseConfig, 0, len(shards))
+		for _, shard := range shards {
+			copiedShard := make(map[string]*config.DatabaseConfig, len(shard))
+			for shardName, shardConfig := range shard {
+				copiedShard[shardName] = copyDatabase(shardConfig)
+			}
+			copied = append(copied, copiedShard)
+		}
+		return copied
+	}
+	copyDatabase = func(db *config.DatabaseConfig) *config.DatabaseConfig {
+		if db == nil {
+			return nil
+		}
+		copied := *db
+		if targets[db] {
+			rotate(&copied)
+		}
+		if db.Replicas != nil {
+			copied.Replicas = make([]*config.DatabaseConfig, 0, len(db.Replicas))
+			for _, replica := range db.Replicas {
+				copied.Replicas = append(copied.Replicas, copyDatabase(replica))
+			}
+		}
+		copied.SubShards = copyShards(db.SubShards)
+		return &copied
+	}
+	copied := *table
+	copied.DatabaseConfig = *copyDatabase(&table.DatabaseConfig)
+	copied.Sequencer = copyDatabase(table.Sequencer)
+	copied.Shards = copyShards(table.Shards)
+	return &copied
+}
+
+// setRotatedTableConfig replaces configuration of table in global configuration by rotated one.
+// If global configuration doesn't have old one ( e.g. it is reloaded by other configuration ), it is kept.
+func setRotatedTableConfig(tableName string, old *config.TableConfig, rotated *config.TableConfig) {
+	globalConfigMu.Lock()
+	defer globalConfigMu.Unlock()
+	cfg := getGlobalConfig()
+	if cfg == nil || cfg.Tables[tableName] != old {
+		return
+	}
+	newConfig := *cfg
+	newConfig.Tables = make(map[string]*config.TableConfig, len(cfg.Tables))
+	for name, table := range cfg.Tables {
+		newConfig.Tables[name] = table
+	}
+	newConfig.Tables[tableName] = rotated
+	setGlobalConfig(&newConfig)
+	config.Set(&newConfig)
+}
+
+// ReloadCredentials rotates credentials of opened connections
+// if username or password in cfg is different from current ones.
+func (cm *DBConnectionManager) ReloadCredentials(cfg *config.Config) error {
+	type target struct {
+		tableName string
+		shardName string
+		config    *config.DatabaseConfig
+	}
+	targets := []*target{}
+	cm.connMap.Each(func(tableName string, conn *DBConnection) bool {
+		table, exists := cfg.Tables[tableName]
+		if !exists {
+			return true
+		}
+		if !conn.IsShard {
+			targets = append(targets, &target{tableName: tableName, config: &table.DatabaseConfig})
+			return true
+		}
+		for _, shard := range table.PhysicalShards() {
+			for shardName, shardConfig := range shard {
+				targets = append(targets, &target{tableName: tableName, shardName: shardName, config: shardConfig})
+			}
+		}
+		return true
+	})
+	for _, t := range targets {
+		conn, err := cm.ConnectionByTableName(t.tableName)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		current := &conn.Config.DatabaseConfig
+		if t.shardName != "" {
+			current = conn.Config.ShardConfigByName(t.shardName)
+		}
+		if current == nil || (current.Username == t.config.Username && current.Password == t.config.Password) {
+			continue
+		}
+		if err := cm.RotateCredentials(t.tableName, t.shardName, t.config.Username, t.config.Password); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+func (cm *DBConnectionManager) openRotatedConnections(rotations []*credentialRotation, username string, password string) error {
+	for _, rotation := range rotations {
+		cfg := *rotation.config
+		cfg.Username = username
+		cfg.Password = password
+		adapter, err := adap.Adapter(cfg.Adapter)
+		if err == nil {
+			rotation.newConn, err = adapter.OpenConnection(&cfg, cm.queryString)
+		}
+		if err == nil {
+			err = rotation.newConn.Ping()
+		}
+		if err != nil {
+			for _, rotation := range rotations {
+				closeConn(rotation.newConn)
+			}
+			return errors.Wrapf(err, "cannot open connection to %s by new credentials", cfg.NameOrPath)
+		}
+		cm.setConnectionSettings(rotation.newConn, rotation.table, rotation.config)
+	}
+	return nil
+}
+
+func (cm *DBConnectionManager) rotatedConnection(conn *DBConnection, rotations []*credentialRotation) (*DBConnection, error) {
+	newConnByOldConn := map[*sql.DB]*sql.DB{}
+	for _, rotation := range rotations {
+		newConnByOldConn[rotation.oldConn] = rotation.newConn
+	}
+	replace := func(oldConn *sql.DB) *sql.DB {
+		if newConn, exists := newConnByOldConn[oldConn]; exists {
+			return newConn
+		}
+		return oldConn
+	}
+	newConn := *conn
+	if !conn.IsShard {
+		newConn.Connection = replace(conn.Connection)
+		return &newConn, nil
+	}
+	newConn.Sequencer = replace(conn.Sequencer)
+	if len(conn.sequencers) > 0 {
+		newConn.sequencers = make([]*sql.DB, 0, len(conn.sequencers))
+		for _, seqConn := range conn.sequencers {
+			newConn.sequencers = append(newConn.sequencers, replace(seqConn))
+		}
+	}
+	shardConns := &DBShardConnections{}
+	conns := []*sql.DB{}
+	for _, shardConn := range conn.ShardConnections.AllShard() {
+		newShardConn := *shardConn
+		newShardConn.Connection = replace(shardConn.Connection)
+		shardConns.addConnection(&newShardConn)
+		conns = append(conns, newShardConn.Connection)
+	}
+	logic, err := algorithm.LoadShardingAlgorithm(conn.Config.Algorithm)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := algorithm.InitShardingAlgorithm(logic, conns, conn.Config.AlgorithmConfig); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	newConn.Algorithm = logic
+	newConn.ShardConnections = shardConns
+	return &newConn, nil
+}
+
+func (cm *DBConnectionManager) drainConn(conn *sql.DB) {
+	if conn == nil {
+		return
+	}
+	// release idle connections soon, and connections in use are released after they are returned to pool
+	conn.SetMaxIdleConns(0)
+	timeout := cm.credentialDrainTimeout
+	if timeout == 0 {
+		timeout = DefaultCredentialDrainTimeout
+	}
+	time.AfterFunc(timeout, func() {
+		closeConn(conn)
+	})
+}
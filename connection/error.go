@@ -0,0 +1,91 @@
+package connection
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ShardError the error occurred at a shard ( or not sharded database ).
+type ShardError struct {
+	// shard name defined in configuration file. this is empty if database is not sharded
+	ShardName string
+	// DSN of database
+	DSN string
+	// original error
+	Err error
+}
+
+func (e *ShardError) Error() string {
+	if e.ShardName == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("(DB:%s):%s", e.ShardName, e.Err)
+}
+
+// Unwrap returns original error
+func (e *ShardError) Unwrap() error {
+	return e.Err
+}
+
+// Cause returns original error for errors.Cause of github.com/pkg/errors
+func (e *ShardError) Cause() error {
+	return e.Err
+}
+
+// MultiError the error that aggregates errors occurred at multiple shards.
+// It implements Unwrap() []error, so errors.Is and errors.As of standard package can inspect each error.
+type MultiError struct {
+	Errors []error
+}
+
+// Add appends error. nil is ignored.
+func (e *MultiError) Add(err error) {
+	if err == nil {
+		return
+	}
+	e.Errors = append(e.Errors, err)
+}
+
+// AddShardError appends error with shard name and DSN. nil is ignored.
+func (e *MultiError) AddShardError(shardName string, dsn string, err error) {
+	if err == nil {
+		return
+	}
+	e.Errors = append(e.Errors, &ShardError{ShardName: shardName, DSN: dsn, Err: err})
+}
+
+// ErrorOrNil returns nil if there is no error, otherwise returns MultiError itself.
+func (e *MultiError) ErrorOrNil() error {
+	if e == nil || len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *MultiError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for idx, err := range e.Errors {
+		msgs[idx] = err.Error()
+	}
+	return strings.Join(msgs, ":")
+}
+
+// Unwrap returns all aggregated errors
+func (e *MultiError) Unwrap() []error {
+	return e.Errors
+}
+
+// ShardErrors returns errors that have shard attribution.
+// It includes ShardError aggregated by nested MultiError.
+func (e *MultiError) ShardErrors() []*ShardError {
+	shardErrs := []*ShardError{}
+	for _, err := range e.Errors {
+		switch typedErr := err.(type) {
+		case *ShardError:
+			shardErrs = append(shardErrs, typedErr)
+		case *MultiError:
+			shardErrs = append(shardErrs, typedErr.ShardErrors()...)
+		}
+	}
+	return shardErrs
+}
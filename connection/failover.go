@@ -0,0 +1,243 @@
+package connection
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+	adap "go.knocknote.io/octillery/connection/adapter"
+	"go.knocknote.io/octillery/debug"
+)
+
+const (
+	// failoverPingTimeout time for waiting response of ping to master or backup before failover
+	failoverPingTimeout = 3 * time.Second
+)
+
+// FailoverEvent information of failover from master to backup passed to FailoverCallback
+type FailoverEvent struct {
+	// table name of database failed over
+	Table string
+	// shard name defined in configuration file. empty if table is not sharded or sequencer is failed over
+	ShardName string
+	// master server before failover ( DSN of sequencer if sequencer is failed over to its replica )
+	From string
+	// backup server promoted to master ( DSN of replica if sequencer is failed over to it )
+	To string
+	// error of master caused failover. nil if backup is promoted by Promote
+	Err error
+}
+
+// FailoverCallback function called after backup is promoted to master
+type FailoverCallback func(event *FailoverEvent)
+
+// failoverHandler fails over masters of connections opened by connection manager
+type failoverHandler struct {
+	cm       *DBConnectionManager
+	mu       sync.RWMutex
+	callback FailoverCallback
+}
+
+func (h *failoverHandler) notify(event *FailoverEvent) {
+	h.mu.RLock()
+	callback := h.callback
+	h.mu.RUnlock()
+	if callback != nil {
+		callback(event)
+	}
+}
+
+// SetFailoverCallback sets callback called after master is failed over to backup. If callback is nil, removes current callback.
+func (cm *DBConnectionManager) SetFailoverCallback(callback FailoverCallback) {
+	cm.failover.mu.Lock()
+	defer cm.failover.mu.Unlock()
+	cm.failover.callback = callback
+}
+
+// Promote promotes backup to master of database of tableName manually. shardName must be specified for sharded table.
+// backup must be one of 'backup' in configuration file, and current master becomes backup instead of it.
+// Connection pool of current master is closed after queries in progress are drained.
+func (cm *DBConnectionManager) Promote(tableName string, shardName string, backup string) error {
+	cm.credentialMu.Lock()
+	defer cm.credentialMu.Unlock()
+
+	conn, err := cm.ConnectionByTableName(tableName)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	master, cfg, err := conn.masterOf(shardName)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !hasBackup(cfg, backup) {
+		return errors.Errorf("%s is not backup of %s", backup, master.DSN())
+	}
+	backupConn, err := cm.openBackupConnection(conn, cfg, backup)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = cm.promote(conn, shardName, cfg, backup, backupConn, nil)
+	return errors.WithStack(err)
+}
+
+// failoverMaster fails over master of failed connection to backup if master doesn't respond to ping, and returns connection to backup.
+// If master is already failed over by other query, returns current connection. If master responds to ping, returns nil.
+func (cm *DBConnectionManager) failoverMaster(tableName string, failed Connection, cause error) (Connection, error) {
+	cm.credentialMu.Lock()
+	defer cm.credentialMu.Unlock()
+
+	conn, err := cm.ConnectionByTableName(tableName)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	shardName := ""
+	if shardConn, ok := failed.(*DBShardConnection); ok {
+		shardName = shardConn.ShardName
+	}
+	master, cfg, err := conn.masterOf(shardName)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if master.Conn() != failed.Conn() {
+		return master, nil
+	}
+	if len(cfg.Backups) == 0 || pingConn(master.Conn()) == nil {
+		return nil, nil
+	}
+	errs := &MultiError{}
+	for _, backup := range cfg.Backups {
+		backupConn, err := cm.openBackupConnection(conn, cfg, backup)
+		if err != nil {
+			errs.Add(err)
+			continue
+		}
+		return cm.promote(conn, shardName, cfg, backup, backupConn, cause)
+	}
+	return nil, errors.Wrapf(errs.ErrorOrNil(), "cannot fail over %s to backup", master.DSN())
+}
+
+// openBackupConnection opens connection to backup of database, and checks that it responds to ping
+func (cm *DBConnectionManager) openBackupConnection(conn *DBConnection, cfg *config.DatabaseConfig, backup string) (*sql.DB, error) {
+	backupAdapter, ok := conn.Adapter.(adap.BackupAdapter)
+	if !ok {
+		return nil, errors.Errorf("adapter %s doesn't support backup", cfg.Adapter)
+	}
+	backupConn, err := backupAdapter.OpenBackupConnection(cfg, backup, cm.queryString)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open connection to backup %s", backup)
+	}
+	if err := pingConn(backupConn); err != nil {
+		closeConn(backupConn)
+		return nil, errors.Wrapf(err, "cannot ping backup %s", backup)
+	}
+	cm.setConnectionSettings(backupConn, conn.Config, cfg)
+	return backupConn, nil
+}
+
+// promote swaps master of conn with backupConn atomically, and exchanges master and backup in configuration.
+// Configuration is never changed in place. Copy of it is swapped in with connection like credential rotation.
+func (cm *DBConnectionManager) promote(conn *DBConnection, shardName string, cfg *config.DatabaseConfig, backup string, backupConn *sql.DB, cause error) (Connection, error) {
+	master, _, err := conn.masterOf(shardName)
+	if err != nil {
+		closeConn(backupConn)
+		return nil, errors.WithStack(err)
+	}
+	newConn, err := cm.rotatedConnection(conn, []*credentialRotation{{oldConn: master.Conn(), newConn: backupConn}})
+	if err != nil {
+		closeConn(backupConn)
+		return nil, errors.WithStack(err)
+	}
+	from := ""
+	if len(cfg.Masters) > 0 {
+		from = cfg.Masters[0]
+	}
+	backups := []string{}
+	for _, b := range cfg.Backups {
+		if b != backup {
+			backups = append(backups, b)
+		}
+	}
+	if from != "" {
+		backups = append(backups, from)
+	}
+	newConn.Config = rotatedTableConfig(conn.Config, map[*config.DatabaseConfig]bool{cfg: true}, func(db *config.DatabaseConfig) {
+		db.Masters = []string{backup}
+		db.Backups = backups
+	})
+	promoted := Connection(newConn)
+	if shardName != "" {
+		shardConn := newConn.ShardConnections.ShardConnectionByName(shardName)
+		shardConn.dsn = fmt.Sprintf("%s/%s", backup, cfg.NameOrPath)
+		promoted = shardConn
+	}
+	cm.connMap.Set(conn.tableName, newConn)
+	setRotatedTableConfig(conn.tableName, conn.Config, newConn.Config)
+	cm.drainConn(master.Conn())
+	debug.Printf("failed over %s to %s", master.DSN(), promoted.DSN())
+	cm.failover.notify(&FailoverEvent{
+		Table:     conn.tableName,
+		ShardName: shardName,
+		From:      from,
+		To:        backup,
+		Err:       cause,
+	})
+	return promoted, nil
+}
+
+// masterOf returns connection to master and its configuration of shard ( or not sharded database if shardName is empty )
+func (c *DBConnection) masterOf(shardName string) (Connection, *config.DatabaseConfig, error) {
+	if !c.IsShard {
+		if shardName != "" {
+			return nil, nil, errors.Errorf("%s is not sharded", c.tableName)
+		}
+		return c, &c.Config.DatabaseConfig, nil
+	}
+	shardConn := c.ShardConnections.ShardConnectionByName(shardName)
+	cfg := c.Config.ShardConfigByName(shardName)
+	if shardConn == nil || cfg == nil {
+		return nil, nil, errors.Errorf("cannot find shard %s of %s", shardName, c.tableName)
+	}
+	return shardConn, cfg, nil
+}
+
+// RetryWithFailover calls fn with conn and retries it like Retry.
+// If fn fails by connection error and master of conn doesn't respond to ping, master is failed over to backup in configuration file,
+// and fn is called again with connection to backup. Write query ( write is true ) is not called again, because it may be executed on old master.
+// Queries in transaction are not failed over.
+func (c *DBConnection) RetryWithFailover(ctx context.Context, conn Connection, write bool, fn func(Connection) error) error {
+	if c == nil {
+		return fn(conn)
+	}
+	err := c.Retry(ctx, write, func() error { return fn(conn) })
+	if err == nil || c.failover == nil || c.ErrorClass(err) != config.RetryOnConnection {
+		return err
+	}
+	backupConn, failoverErr := c.failover.cm.failoverMaster(c.tableName, conn, err)
+	if failoverErr != nil {
+		debug.Printf("%+v", failoverErr)
+		return err
+	}
+	if backupConn == nil || write {
+		return err
+	}
+	return c.Retry(ctx, write, func() error { return fn(backupConn) })
+}
+
+func hasBackup(cfg *config.DatabaseConfig, backup string) bool {
+	for _, b := range cfg.Backups {
+		if b == backup {
+			return true
+		}
+	}
+	return false
+}
+
+func pingConn(conn *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), failoverPingTimeout)
+	defer cancel()
+	return conn.PingContext(ctx)
+}
@@ -0,0 +1,118 @@
+package connection
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ForEachShardOptions options for ForEachShard
+type ForEachShardOptions struct {
+	// number of shards processed concurrently. 0 or 1 means sequential processing
+	Concurrency int
+	// if true, shards not processed yet are skipped after error occurred
+	StopOnError bool
+	// if not nil, shards not processed yet are skipped after context is done
+	Context context.Context
+}
+
+// Shards returns all DBShardConnection of table.
+// If table is not sharded, returns single DBShardConnection for its database ( ShardName is empty ).
+func (c *DBConnection) Shards() []*DBShardConnection {
+	if c.IsShard {
+		return c.ShardConnections.AllShard()
+	}
+	return []*DBShardConnection{
+		{
+			Connection: c.Connection,
+			dsn:        c.DSN(),
+		},
+	}
+}
+
+// ForEachShard calls fn for every shard of table ( or database of table if it is not sharded ).
+// Errors returned by fn are aggregated to MultiError with shard attribution.
+func (cm *DBConnectionManager) ForEachShard(tableName string, fn func(*DBShardConnection) error, opts *ForEachShardOptions) error {
+	conn, err := cm.ConnectionByTableName(tableName)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return conn.ForEachShard(fn, opts)
+}
+
+// callShardFunc calls fn for shard. If fn panics, it is recovered and returned as error.
+func callShardFunc(fn func(*DBShardConnection) error, shard *DBShardConnection) (e error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e = errors.Errorf("panic while processing %s: %v", shard.ShardName, r)
+		}
+	}()
+	return fn(shard)
+}
+
+// ForEachShard calls fn for every shard of this connection ( or database if it is not sharded ).
+// Errors returned by fn are aggregated to MultiError with shard attribution. Panic of fn is recovered and aggregated as error.
+func (c *DBConnection) ForEachShard(fn func(*DBShardConnection) error, opts *ForEachShardOptions) error {
+	if opts == nil {
+		opts = &ForEachShardOptions{}
+	}
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		isStopped bool
+	)
+	errs := &MultiError{}
+	sem := make(chan struct{}, concurrency)
+	for _, shard := range c.Shards() {
+		sem <- struct{}{}
+		mu.Lock()
+		stopped := isStopped
+		mu.Unlock()
+		if stopped {
+			<-sem
+			break
+		}
+		if opts.Context != nil && opts.Context.Err() != nil {
+			<-sem
+			mu.Lock()
+			errs.Add(errors.Wrapf(opts.Context.Err(), "cancelled before processing %s", shard.ShardName))
+			mu.Unlock()
+			break
+		}
+		wg.Add(1)
+		go func(shard *DBShardConnection) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := callShardFunc(fn, shard); err != nil {
+				mu.Lock()
+				errs.AddShardError(shard.ShardName, shard.DSN(), err)
+				isStopped = opts.StopOnError
+				mu.Unlock()
+			}
+		}(shard)
+	}
+	wg.Wait()
+	return errs.ErrorOrNil()
+}
+
+// ForEachShard calls fn for every shard of table by new DBConnectionManager.
+// Connections opened by this are closed before return.
+func ForEachShard(tableName string, fn func(*DBShardConnection) error, opts *ForEachShardOptions) (e error) {
+	mgr, err := NewConnectionManager()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		if err := mgr.Close(); err != nil && e == nil {
+			e = errors.WithStack(err)
+		}
+	}()
+	return mgr.ForEachShard(tableName, fn, opts)
+}
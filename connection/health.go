@@ -0,0 +1,128 @@
+package connection
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// HealthStatus result of health check of a database
+type HealthStatus struct {
+	// DSN of database. empty if connection cannot be opened by configuration
+	DSN string
+	// role of connection pool ( PoolRoleMaster, PoolRoleSlave or PoolRoleSequencer )
+	Role string
+	// tables using database in order of name
+	Tables []string
+	// shard name. empty if database is not a shard
+	ShardName string
+	// time spent by ping
+	Latency time.Duration
+	// error of ping or opening connection. nil if database is healthy
+	Err error
+}
+
+// Healthy returns whether database responds to ping
+func (s *HealthStatus) Healthy() bool {
+	return s.Err == nil
+}
+
+// healthCheckTarget connection pool pinged by health check and tables sharing it
+type healthCheckTarget struct {
+	db     *sql.DB
+	status *HealthStatus
+}
+
+// HealthCheck pings all databases ( shards, slaves, sequencers and not sharded databases ) of tables in configuration file concurrently,
+// and returns status of each DSN in order of DSN and role. Connections of tables not used yet are opened by this.
+// Database shared by tables is pinged once, so it can be used for readiness probe of application.
+func (cm *DBConnectionManager) HealthCheck(ctx context.Context) ([]*HealthStatus, error) {
+	cfg := getGlobalConfig()
+	if cfg == nil {
+		return nil, errors.New("cannot check health of databases. config is not loaded")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	tableNames := make([]string, 0, len(cfg.Tables))
+	for tableName := range cfg.Tables {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+	statuses := []*HealthStatus{}
+	for _, tableName := range tableNames {
+		if _, err := cm.ConnectionByTableName(tableName); err != nil {
+			table := cfg.Tables[tableName]
+			status := &HealthStatus{Role: PoolRoleMaster, Tables: []string{tableName}, Err: errors.WithStack(err)}
+			if !table.IsShard {
+				status.DSN = masterDSN(&table.DatabaseConfig)
+			}
+			statuses = append(statuses, status)
+		}
+	}
+	targets := []*healthCheckTarget{}
+	targetByDSN := map[string]*healthCheckTarget{}
+	cm.eachDB(func(pool *connectionPool) {
+		key := pool.role + "\x00" + pool.dsn
+		if target, exists := targetByDSN[key]; exists {
+			target.status.Tables = append(target.status.Tables, pool.tableName)
+			return
+		}
+		target := &healthCheckTarget{
+			db: pool.db,
+			status: &HealthStatus{
+				DSN:       pool.dsn,
+				Role:      pool.role,
+				Tables:    []string{pool.tableName},
+				ShardName: pool.shardName,
+			},
+		}
+		targetByDSN[key] = target
+		targets = append(targets, target)
+	})
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target *healthCheckTarget) {
+			defer wg.Done()
+			start := time.Now()
+			err := target.db.PingContext(ctx)
+			target.status.Latency = time.Since(start)
+			target.status.Err = errors.WithStack(err)
+		}(target)
+		statuses = append(statuses, target.status)
+	}
+	wg.Wait()
+	for _, status := range statuses {
+		sort.Strings(status.Tables)
+	}
+	sort.SliceStable(statuses, func(i, j int) bool {
+		if statuses[i].DSN != statuses[j].DSN {
+			return statuses[i].DSN < statuses[j].DSN
+		}
+		return statuses[i].Role < statuses[j].Role
+	})
+	return statuses, nil
+}
+
+// Ping pings all databases of tables in configuration file concurrently by HealthCheck.
+// Errors of unhealthy databases are aggregated to MultiError with shard attribution.
+func (cm *DBConnectionManager) Ping(ctx context.Context) error {
+	statuses, err := cm.HealthCheck(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	errs := &MultiError{}
+	for _, status := range statuses {
+		if status.Healthy() {
+			continue
+		}
+		errs.AddShardError(status.ShardName, status.DSN, errors.Wrapf(status.Err, "%s of %s is unhealthy", status.Role, strings.Join(status.Tables, ", ")))
+	}
+	return errs.ErrorOrNil()
+}
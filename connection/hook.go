@@ -0,0 +1,158 @@
+package connection
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.knocknote.io/octillery/logging"
+)
+
+// QueryHookInfo information of query executed on a database passed to QueryHook
+type QueryHookInfo struct {
+	// table name of query
+	Table string
+	// shard name defined in configuration file. empty if table is not sharded
+	ShardName string
+	// DSN of database query is executed on
+	DSN string
+	// query text sent to database
+	Query string
+	// query arguments as they are. use RedactedArgs if they may include sensitive values
+	Args []interface{}
+	// false before query is executed, true after query is executed
+	Finished bool
+	// time spent by query. it is set after query is executed.
+	// for query returns rows, it doesn't include time for reading rows
+	Duration time.Duration
+	// error of query. it is set after query is executed.
+	// error of QueryRow is returned by Scan, so it is always nil
+	Err error
+	// number of rows affected by query. it is set after query is executed.
+	// -1 if query doesn't return result ( e.g. SELECT ) or driver doesn't report it
+	RowsAffected int64
+}
+
+// RedactedArgs returns query arguments replaced by their types ( e.g. '<string>' ), so they can be logged safely
+func (info QueryHookInfo) RedactedArgs() []interface{} {
+	args := make([]interface{}, len(info.Args))
+	for idx, arg := range info.Args {
+		if arg == nil {
+			continue
+		}
+		args[idx] = fmt.Sprintf("<%T>", arg)
+	}
+	return args
+}
+
+// QueryHook function called before and after every query is executed on each database ( shard ).
+// It is called synchronously in goroutine executing query, so it must return immediately.
+type QueryHook func(ctx context.Context, info QueryHookInfo)
+
+// queryHookHolder holds hook shared by connection manager and its connections
+type queryHookHolder struct {
+	mu   sync.RWMutex
+	hook QueryHook
+}
+
+func (h *queryHookHolder) get() QueryHook {
+	if h == nil {
+		return nil
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.hook
+}
+
+func (h *queryHookHolder) set(hook QueryHook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hook = hook
+}
+
+var globalQueryHook = &queryHookHolder{}
+
+// SetQueryHook sets hook called for queries of all connection managers. If hook is nil, removes current hook.
+func SetQueryHook(hook QueryHook) {
+	globalQueryHook.set(hook)
+}
+
+// SetQueryHook sets hook called for queries of this connection manager in addition to global hook.
+// If hook is nil, removes current hook.
+func (cm *DBConnectionManager) SetQueryHook(hook QueryHook) {
+	cm.queryHook.set(hook)
+}
+
+// QueryDoneFunc function called after query is executed with its result. result is nil if query doesn't return it ( e.g. SELECT ).
+type QueryDoneFunc func(result sql.Result, err error)
+
+// StartQuery calls hooks before query of tableName is executed on conn, and returns function that calls hooks after it is executed.
+// Also, it logs query taking longer than slow_query_threshold in configuration file. ctx may be nil.
+func (c *DBConnection) StartQuery(ctx context.Context, tableName string, conn Connection, query string, args []interface{}) QueryDoneFunc {
+	hooks := make([]QueryHook, 0, 2)
+	for _, holder := range []*queryHookHolder{globalQueryHook, c.queryHook} {
+		if hook := holder.get(); hook != nil {
+			hooks = append(hooks, hook)
+		}
+	}
+	var slowQueryThreshold time.Duration
+	if cfg := getGlobalConfig(); cfg != nil {
+		slowQueryThreshold = cfg.SlowQueryThreshold
+	}
+	if len(hooks) == 0 && slowQueryThreshold <= 0 {
+		return func(sql.Result, error) {}
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	info := QueryHookInfo{
+		Table: tableName,
+		DSN:   conn.DSN(),
+		Query: query,
+		Args:  args,
+	}
+	if shardConn, ok := conn.(*DBShardConnection); ok {
+		info.ShardName = shardConn.ShardName
+	}
+	for _, hook := range hooks {
+		hook(ctx, info)
+	}
+	startedAt := time.Now()
+	return func(result sql.Result, err error) {
+		info.Finished = true
+		info.Duration = time.Since(startedAt)
+		info.Err = err
+		info.RowsAffected = -1
+		if result != nil && err == nil {
+			if rowsAffected, err := result.RowsAffected(); err == nil {
+				info.RowsAffected = rowsAffected
+			}
+		}
+		for _, hook := range hooks {
+			hook(ctx, info)
+		}
+		if slowQueryThreshold > 0 && info.Duration >= slowQueryThreshold {
+			logSlowQuery(startedAt, info)
+		}
+	}
+}
+
+// logSlowQuery logs query of info as slow query. Arguments are redacted because they may include sensitive values
+func logSlowQuery(startedAt time.Time, info QueryHookInfo) {
+	slowQuery := &logging.SlowQuery{
+		Time:         startedAt,
+		Table:        info.Table,
+		ShardName:    info.ShardName,
+		DSN:          info.DSN,
+		Query:        info.Query,
+		Args:         info.RedactedArgs(),
+		Duration:     info.Duration,
+		RowsAffected: info.RowsAffected,
+	}
+	if info.Err != nil {
+		slowQuery.Error = info.Err.Error()
+	}
+	logging.LogSlowQuery(slowQuery)
+}
@@ -0,0 +1,14 @@
+// +build !go1.15
+
+package connection
+
+import (
+	"database/sql"
+	"time"
+)
+
+// setConnMaxIdleTime is not supported before Go 1.15
+func setConnMaxIdleTime(conn *sql.DB, d time.Duration) {}
+
+// addMaxIdleTimeClosed is not supported before Go 1.15
+func addMaxIdleTimeClosed(total *sql.DBStats, stats sql.DBStats) {}
@@ -0,0 +1,16 @@
+// +build go1.15
+
+package connection
+
+import (
+	"database/sql"
+	"time"
+)
+
+func setConnMaxIdleTime(conn *sql.DB, d time.Duration) {
+	conn.SetConnMaxIdleTime(d)
+}
+
+func addMaxIdleTimeClosed(total *sql.DBStats, stats sql.DBStats) {
+	total.MaxIdleTimeClosed += stats.MaxIdleTimeClosed
+}
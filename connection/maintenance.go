@@ -0,0 +1,141 @@
+package connection
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	adap "go.knocknote.io/octillery/connection/adapter"
+)
+
+// SequencerMaintenance result of maintenance of a sequencer table
+type SequencerMaintenance struct {
+	// table name using sequencer
+	TableName string
+	// table name of sequencer ( e.g. 'users_ids', or 'users_ids_0' if sequencer is partitioned )
+	SequencerTableName string
+	// whether adapter maintains sequencer. if false, sequencer of the adapter doesn't need maintenance
+	Maintained bool
+	// messages reported by database
+	Messages []string
+	// error of maintenance
+	Err error
+}
+
+// MaintainSequencer maintains all partitions of sequencer used by table if adapter implements SequencerMaintenanceAdapter.
+// If sequencer has replicas, only current sequencer publishing ids ( replica after failover ) is maintained.
+// Error of each partition is set to SequencerMaintenance, so the other partitions are maintained.
+func (c *DBConnection) MaintainSequencer(ctx context.Context, tableName string) ([]*SequencerMaintenance, error) {
+	if c.Sequencer == nil {
+		return nil, errors.Errorf("%s doesn't use sequencer", tableName)
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	adapter, supported := c.Adapter.(adap.SequencerMaintenanceAdapter)
+	partitionNum := sequencerPartitionNum(c.Config.Sequencer)
+	results := make([]*SequencerMaintenance, 0, partitionNum)
+	for partition := 0; partition < partitionNum; partition++ {
+		result := &SequencerMaintenance{
+			TableName:          tableName,
+			SequencerTableName: sequencerPartitionTableName(tableName, partition, partitionNum),
+		}
+		if supported {
+			messages, ok, err := adapter.MaintainSequencer(ctx, c.Sequencer, result.SequencerTableName)
+			result.Maintained = ok
+			result.Messages = messages
+			result.Err = errors.WithStack(err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// MaintainSequencers maintains sequencers of all tables using sequencer in order of table name.
+func (cm *DBConnectionManager) MaintainSequencers(ctx context.Context) ([]*SequencerMaintenance, error) {
+	cfg := getGlobalConfig()
+	if cfg == nil {
+		return nil, errors.New("cannot maintain sequencers. config is not loaded")
+	}
+	tableNames := []string{}
+	for tableName, table := range cfg.Tables {
+		if table.IsUsedSequencerDatabase() {
+			tableNames = append(tableNames, tableName)
+		}
+	}
+	sort.Strings(tableNames)
+	results := []*SequencerMaintenance{}
+	for _, tableName := range tableNames {
+		conn, err := cm.ConnectionByTableName(tableName)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		maintenances, err := conn.MaintainSequencer(ctx, tableName)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		results = append(results, maintenances...)
+	}
+	return results, nil
+}
+
+// sequencerMaintainer maintains sequencers periodically
+type sequencerMaintainer struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (m *sequencerMaintainer) run(cm *DBConnectionManager, interval time.Duration, handler func([]*SequencerMaintenance, error)) {
+	defer close(m.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			results, err := cm.MaintainSequencers(ctx)
+			cancel()
+			if handler != nil {
+				handler(results, err)
+			}
+		}
+	}
+}
+
+func (m *sequencerMaintainer) close() {
+	close(m.stop)
+	<-m.done
+}
+
+// StartSequencerMaintenance maintains sequencers of all tables at each interval in background ( e.g. every day at low traffic ).
+// handler receives results of each maintenance. If maintenance is already started, it is restarted by new interval.
+func (cm *DBConnectionManager) StartSequencerMaintenance(interval time.Duration, handler func([]*SequencerMaintenance, error)) error {
+	if interval <= 0 {
+		return errors.Errorf("invalid interval %s", interval)
+	}
+	cm.StopSequencerMaintenance()
+	maintainer := &sequencerMaintainer{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go maintainer.run(cm, interval, handler)
+
+	cm.sequencerMaintainerMu.Lock()
+	cm.sequencerMaintainer = maintainer
+	cm.sequencerMaintainerMu.Unlock()
+	return nil
+}
+
+// StopSequencerMaintenance stops maintenance started by StartSequencerMaintenance.
+func (cm *DBConnectionManager) StopSequencerMaintenance() {
+	cm.sequencerMaintainerMu.Lock()
+	maintainer := cm.sequencerMaintainer
+	cm.sequencerMaintainer = nil
+	cm.sequencerMaintainerMu.Unlock()
+	if maintainer != nil {
+		maintainer.close()
+	}
+}
@@ -0,0 +1,101 @@
+package connection
+
+import (
+	"database/sql"
+	"sync"
+
+	"github.com/pkg/errors"
+	adap "go.knocknote.io/octillery/connection/adapter"
+)
+
+// MetadataCacheStats statistics of cache for metadata queries ( e.g. SHOW CREATE TABLE )
+type MetadataCacheStats struct {
+	// number of metadata queries answered by cache
+	Hits int64
+	// number of metadata queries sent to database
+	Misses int64
+	// number of cached results
+	Entries int
+}
+
+// metadataCache caches results of metadata queries by DSN of database, so tools verifying, importing or migrating schema
+// don't send same queries to shards repeatedly.
+// Results are cached until DDL is executed on the database by octillery, migration is run or InvalidateMetadataCache is called.
+type metadataCache struct {
+	mu      sync.Mutex
+	entries map[string]map[string]string
+	hits    int64
+	misses  int64
+}
+
+var globalMetadataCache = &metadataCache{entries: map[string]map[string]string{}}
+
+// load returns cached result of key for dsn. If it is not cached, calls fetch and caches its result unless it returns error.
+func (c *metadataCache) load(dsn string, key string, fetch func() (string, error)) (string, error) {
+	c.mu.Lock()
+	if result, exists := c.entries[dsn][key]; exists {
+		c.hits++
+		c.mu.Unlock()
+		return result, nil
+	}
+	c.misses++
+	c.mu.Unlock()
+	result, err := fetch()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries[dsn] == nil {
+		c.entries[dsn] = map[string]string{}
+	}
+	c.entries[dsn][key] = result
+	return result, nil
+}
+
+func (c *metadataCache) invalidate(dsns ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(dsns) == 0 {
+		c.entries = map[string]map[string]string{}
+		return
+	}
+	for _, dsn := range dsns {
+		delete(c.entries, dsn)
+	}
+}
+
+func (c *metadataCache) stats() MetadataCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries := 0
+	for _, results := range c.entries {
+		entries += len(results)
+	}
+	return MetadataCacheStats{Hits: c.hits, Misses: c.misses, Entries: entries}
+}
+
+// InvalidateMetadataCache discards cached results of metadata queries for databases of dsns.
+// If dsns is not specified, all results are discarded.
+//
+// This is called automatically after DDL is executed by octillery ( CREATE/DROP/TRUNCATE TABLE, migration and Bootstrap ).
+// Call this if schema is changed by other process.
+func InvalidateMetadataCache(dsns ...string) {
+	globalMetadataCache.invalidate(dsns...)
+}
+
+// GetMetadataCacheStats returns statistics of cache for metadata queries
+func GetMetadataCacheStats() MetadataCacheStats {
+	return globalMetadataCache.stats()
+}
+
+// cachedTableSchema returns schema of table fetched by schemaAdapter from database of dsn. result is cached until invalidated
+func cachedTableSchema(schemaAdapter adap.SchemaAdapter, dsn string, conn *sql.DB, tableName string) (string, error) {
+	schema, err := globalMetadataCache.load(dsn, "SHOW CREATE TABLE "+tableName, func() (string, error) {
+		return schemaAdapter.TableSchema(conn, tableName)
+	})
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return schema, nil
+}
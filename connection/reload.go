@@ -0,0 +1,155 @@
+package connection
+
+import (
+	"database/sql"
+	"reflect"
+	"sort"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+)
+
+// ReloadResult tables affected by Reload
+type ReloadResult struct {
+	// tables whose connections are opened by new configuration and swapped
+	Changed []string
+	// tables whose connections are closed because they are removed from new configuration
+	Removed []string
+}
+
+// Reload applies cfg to connection manager without restart of process.
+//
+// Configuration of each opened table is compared with cfg. Connections of changed tables are opened by cfg first,
+// and swapped with current ones atomically after all of them are opened. Connections of tables removed from cfg are discarded.
+// Old connection pools are closed after queries in progress are drained ( same as RotateCredentials ).
+// cfg is not set as global configuration, so call SetConfig after all connection managers are reloaded
+// to open tables not opened yet by cfg. If any connection cannot be opened, current connections are kept.
+func (cm *DBConnectionManager) Reload(cfg *config.Config) (*ReloadResult, error) {
+	staged, err := cm.PrepareReload(cfg)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return staged.Apply(), nil
+}
+
+// StagedReload connections opened by new configuration that are not swapped with current ones yet.
+type StagedReload struct {
+	cm      *DBConnectionManager
+	result  *ReloadResult
+	staging *DBConnectionManager
+}
+
+// PrepareReload opens connections of tables changed by cfg without changing current connections.
+// Opened connections are swapped by Apply or closed by Discard, so multiple connection managers can be reloaded
+// only if connections of all of them are opened.
+func (cm *DBConnectionManager) PrepareReload(cfg *config.Config) (*StagedReload, error) {
+	if cfg == nil {
+		return nil, errors.New("cannot reload connections. config is nil")
+	}
+	cm.credentialMu.Lock()
+	defer cm.credentialMu.Unlock()
+
+	result := &ReloadResult{}
+	cm.connMap.Each(func(tableName string, conn *DBConnection) bool {
+		table, exists := cfg.Tables[tableName]
+		switch {
+		case !exists:
+			result.Removed = append(result.Removed, tableName)
+		case !reflect.DeepEqual(conn.Config, table):
+			result.Changed = append(result.Changed, tableName)
+		}
+		return true
+	})
+	sort.Strings(result.Changed)
+	sort.Strings(result.Removed)
+
+	if !cfg.SkipAutoSetup {
+		for _, tableName := range result.Changed {
+			if err := setupTable(tableName, cfg.Tables[tableName]); err != nil {
+				return nil, errors.Wrapf(err, "cannot setup database of %s", tableName)
+			}
+		}
+	}
+	staging, err := cm.openStagedConnections(cfg, result.Changed)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &StagedReload{cm: cm, result: result, staging: staging}, nil
+}
+
+// Apply swaps current connections with staged ones, and discards connections of removed tables.
+func (r *StagedReload) Apply() *ReloadResult {
+	cm := r.cm
+	cm.credentialMu.Lock()
+	defer cm.credentialMu.Unlock()
+
+	for _, tableName := range r.result.Changed {
+		oldConn := cm.connMap.Get(tableName)
+		cm.connMap.Set(tableName, r.staging.connMap.Get(tableName))
+		cm.drainDBConnection(oldConn)
+	}
+	for _, tableName := range r.result.Removed {
+		oldConn := cm.connMap.Get(tableName)
+		cm.connMap.Delete(tableName)
+		cm.drainDBConnection(oldConn)
+	}
+	cm.schemaCacheMu.Lock()
+	if cm.schemaCache != nil {
+		cm.schemaCache.Invalidate(append(r.result.Changed, r.result.Removed...)...)
+	}
+	cm.schemaCacheMu.Unlock()
+	return r.result
+}
+
+// Discard closes staged connections and keeps current ones.
+func (r *StagedReload) Discard() error {
+	return errors.WithStack(r.staging.Close())
+}
+
+// openStagedConnections opens connections of tables by cfg without changing current connections.
+func (cm *DBConnectionManager) openStagedConnections(cfg *config.Config, tableNames []string) (*DBConnectionManager, error) {
+	staging := &DBConnectionManager{
+		connMap:               newDBConnectionMap(),
+		maxIdleConns:          cm.maxIdleConns,
+		maxOpenConns:          cm.maxOpenConns,
+		connMaxLifetime:       cm.connMaxLifetime,
+		connMaxIdleTime:       cm.connMaxIdleTime,
+		connMaxLifetimeJitter: cm.connMaxLifetimeJitter,
+		queryString:           cm.queryString,
+		queryHook:             cm.queryHook,
+		routingInterceptor:    cm.routingInterceptor,
+		failover:              cm.failover,
+		stagedConfig:          cfg,
+	}
+	for _, tableName := range tableNames {
+		if err := staging.open(tableName); err != nil {
+			staging.Close()
+			return nil, errors.Wrapf(err, "cannot open connection of %s by new configuration", tableName)
+		}
+	}
+	return staging, nil
+}
+
+// drainDBConnection closes all connection pools of conn after queries in progress are drained
+func (cm *DBConnectionManager) drainDBConnection(conn *DBConnection) {
+	if conn == nil {
+		return
+	}
+	drainSlaves := func(slaves []*sql.DB) {
+		for _, slave := range slaves {
+			cm.drainConn(slave)
+		}
+	}
+	if !conn.IsShard {
+		cm.drainConn(conn.Connection)
+		drainSlaves(conn.Slaves)
+		return
+	}
+	for _, seqConn := range conn.sequencerConns() {
+		cm.drainConn(seqConn)
+	}
+	for _, shardConn := range conn.ShardConnections.AllShard() {
+		cm.drainConn(shardConn.Connection)
+		drainSlaves(shardConn.Slaves)
+	}
+}
@@ -0,0 +1,104 @@
+package connection
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+	adap "go.knocknote.io/octillery/connection/adapter"
+	"go.knocknote.io/octillery/debug"
+)
+
+// ErrorClass returns class of transient error ( config.RetryOnDeadlock, config.RetryOnLockWaitTimeout or config.RetryOnConnection ) classified by adapter.
+// Broken connection and network errors are classified as config.RetryOnConnection for all adapters. If err is not transient, returns empty string.
+func (c *DBConnection) ErrorClass(err error) string {
+	return errorClass(c.Adapter, err)
+}
+
+// Retry calls fn until it succeeds, and retries it by 'retry' of table ( or global 'retry' ) in configuration file if it fails by transient error.
+// If write is true, fn is retried only for deadlock and lock wait timeout, because statement is rolled back by database for them.
+// Waiting for backoff is interrupted by cancellation of ctx, and fn is not retried if deadline of ctx comes before next retry. ctx may be nil.
+func (c *DBConnection) Retry(ctx context.Context, write bool, fn func() error) error {
+	if c == nil {
+		return fn()
+	}
+	var retry *config.RetryConfig
+	if c.Config != nil {
+		retry = c.Config.RetryConfig
+	}
+	if cfg := getGlobalConfig(); retry == nil && cfg != nil {
+		retry = cfg.RetryConfig
+	}
+	return retryQuery(ctx, retry, c.Adapter, write, fn)
+}
+
+func retryQuery(ctx context.Context, retry *config.RetryConfig, adapter adap.DBAdapter, write bool, fn func() error) error {
+	err := fn()
+	if retry == nil {
+		return err
+	}
+	for n := 1; err != nil && n <= retry.MaxRetries; n++ {
+		class := errorClass(adapter, err)
+		if !retry.IsRetryOn(class) || (write && class == config.RetryOnConnection) {
+			return err
+		}
+		if waitErr := waitBackoff(ctx, retry.BackoffOf(n)); waitErr != nil {
+			return err
+		}
+		debug.Printf("retry query failed by %s (%d/%d): %s", class, n, retry.MaxRetries, err)
+		err = fn()
+	}
+	return err
+}
+
+// waitBackoff waits for backoff. If ctx is cancelled or its deadline comes before backoff, returns error immediately.
+func waitBackoff(ctx context.Context, backoff time.Duration) error {
+	if ctx == nil {
+		time.Sleep(backoff)
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+		return errors.WithStack(context.DeadlineExceeded)
+	}
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
+
+func errorClass(adapter adap.DBAdapter, err error) string {
+	if err == nil {
+		return ""
+	}
+	cause := errors.Cause(err)
+	if cause == context.Canceled || cause == context.DeadlineExceeded {
+		return ""
+	}
+	if classifier, ok := adapter.(adap.ErrorClassifierAdapter); ok {
+		if class := classifier.ErrorClass(err); class != "" {
+			return class
+		}
+	}
+	switch cause {
+	case driver.ErrBadConn, io.ErrUnexpectedEOF:
+		return config.RetryOnConnection
+	}
+	switch e := cause.(type) {
+	case *net.OpError:
+		return config.RetryOnConnection
+	case syscall.Errno:
+		if e == syscall.ECONNRESET || e == syscall.ECONNREFUSED || e == syscall.EPIPE {
+			return config.RetryOnConnection
+		}
+	}
+	return ""
+}
@@ -0,0 +1,82 @@
+package connection
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/pkg/errors"
+	adap "go.knocknote.io/octillery/connection/adapter"
+)
+
+// returningIDResult a implementation of sql.Result for INSERT query executed with RETURNING clause
+type returningIDResult struct {
+	lastInsertID int64
+}
+
+func (r *returningIDResult) LastInsertId() (int64, error) {
+	return r.lastInsertID, nil
+}
+
+func (r *returningIDResult) RowsAffected() (int64, error) {
+	return 1, nil
+}
+
+// IsRequiredReturningID returns whether adapter requires RETURNING clause for getting last inserted id.
+func (c *DBConnection) IsRequiredReturningID() bool {
+	if c.Adapter == nil {
+		return false
+	}
+	adapter, ok := c.Adapter.(adap.ReturningIDAdapter)
+	return ok && adapter.IsRequiredReturningID()
+}
+
+// QueryWithReturningID returns INSERT query appended RETURNING clause for identity column.
+func (c *DBConnection) QueryWithReturningID(query string) string {
+	return fmt.Sprintf("%s returning %s", query, c.Config.IdentityColumn())
+}
+
+func scanReturningID(row *sql.Row) (sql.Result, error) {
+	var id int64
+	if err := row.Scan(&id); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &returningIDResult{lastInsertID: id}, nil
+}
+
+// ExecReturningID executes INSERT query that has RETURNING clause of identity column,
+// and returns sql.Result includes returned id as LastInsertId().
+func ExecReturningID(ctx context.Context, conn Connection, query string, args ...interface{}) (sql.Result, error) {
+	if ctx == nil {
+		return scanReturningID(conn.Conn().QueryRow(query, args...))
+	}
+	return scanReturningID(conn.Conn().QueryRowContext(ctx, query, args...))
+}
+
+// ExecReturningID executes INSERT query that has RETURNING clause of identity column with transaction.
+func (c *TxConnection) ExecReturningID(ctx context.Context, conn Connection, query string, args ...interface{}) (sql.Result, error) {
+	ctx = c.context(ctx)
+	if err := c.beginIfNotInitialized(ctx, conn); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	tx := c.dsnToTx[conn.DSN()]
+	result, err := func() (sql.Result, error) {
+		if ctx == nil {
+			return scanReturningID(tx.QueryRow(query, args...))
+		}
+		return scanReturningID(tx.QueryRowContext(ctx, query, args...))
+	}()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	id, _ := result.LastInsertId()
+	queryLog := &QueryLog{
+		Query:        query,
+		Args:         args,
+		LastInsertID: id,
+		DSN:          conn.DSN(),
+	}
+	c.txToWriteQueries[tx] = append(c.txToWriteQueries[tx], queryLog)
+	c.WriteQueries = append(c.WriteQueries, queryLog)
+	return result, nil
+}
@@ -0,0 +1,92 @@
+package connection
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+// RoutingInterceptor decides shards query is executed on instead of shards chosen by sharding algorithm.
+// It can narrow, broaden or replace them for custom policies ( e.g. canary shards, pinning a customer to a shard ),
+// or veto query by returning error.
+//
+// It is called for SELECT/INSERT/UPDATE/DELETE of sharded tables. Query that must be executed on a single shard
+// ( e.g. INSERT, UPDATE/DELETE with RETURNING clause for QueryRow ) fails if interceptor decides multiple shards.
+// UPDATE moving rows to another shard by changing shard_key is not intercepted.
+type RoutingInterceptor interface {
+	// returns shards query is executed on. proposedShards are shards chosen by sharding algorithm ( all shards for query without shard_key ).
+	// returned shards must be shards of the same table. if error is returned, query is not executed
+	Decide(query sqlparser.Query, proposedShards []*DBShardConnection) ([]*DBShardConnection, error)
+}
+
+// routingInterceptorHolder holds interceptor shared by connection manager and its connections
+type routingInterceptorHolder struct {
+	mu          sync.RWMutex
+	interceptor RoutingInterceptor
+}
+
+func (h *routingInterceptorHolder) get() RoutingInterceptor {
+	if h == nil {
+		return nil
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.interceptor
+}
+
+func (h *routingInterceptorHolder) set(interceptor RoutingInterceptor) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.interceptor = interceptor
+}
+
+// SetRoutingInterceptor sets interceptor deciding shards of queries of this connection manager.
+// If interceptor is nil, removes current interceptor.
+func (cm *DBConnectionManager) SetRoutingInterceptor(interceptor RoutingInterceptor) {
+	cm.routingInterceptor.set(interceptor)
+}
+
+// HasRoutingInterceptor returns true if shards of queries are decided by RoutingInterceptor
+func (c *DBConnection) HasRoutingInterceptor() bool {
+	return c.IsShard && c.routingInterceptor.get() != nil
+}
+
+// RouteShards returns shards query is executed on decided by RoutingInterceptor from proposedShards chosen by sharding algorithm.
+// If interceptor is not set, returns proposedShards as they are.
+func (c *DBConnection) RouteShards(query sqlparser.Query, proposedShards []*DBShardConnection) ([]*DBShardConnection, error) {
+	if !c.IsShard {
+		return proposedShards, nil
+	}
+	interceptor := c.routingInterceptor.get()
+	if interceptor == nil {
+		return proposedShards, nil
+	}
+	// interceptor must not modify shards of connection ( e.g. by append )
+	proposed := make([]*DBShardConnection, len(proposedShards))
+	copy(proposed, proposedShards)
+	shards, err := interceptor.Decide(query, proposed)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s query of %s is vetoed by routing interceptor", query.QueryType(), query.Table())
+	}
+	if len(shards) == 0 {
+		return nil, errors.Errorf("routing interceptor decided no shards for %s query of %s", query.QueryType(), query.Table())
+	}
+	known := map[*DBShardConnection]struct{}{}
+	for _, shard := range c.ShardConnections.AllShard() {
+		known[shard] = struct{}{}
+	}
+	routed := make([]*DBShardConnection, 0, len(shards))
+	decided := map[*DBShardConnection]struct{}{}
+	for _, shard := range shards {
+		if _, exists := known[shard]; !exists {
+			return nil, errors.Errorf("routing interceptor decided shard that is not shard of %s", query.Table())
+		}
+		if _, exists := decided[shard]; exists {
+			continue
+		}
+		decided[shard] = struct{}{}
+		routed = append(routed, shard)
+	}
+	return routed, nil
+}
@@ -0,0 +1,123 @@
+package connection
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+var savepointNamePattern = regexp.MustCompile(`^\w+$`)
+
+// savepoint state of transaction when savepoint is set
+type savepoint struct {
+	name string
+	// number of write queries when savepoint is set
+	writeQueryNum int
+	// number of write queries of each database when savepoint is set.
+	// database accessed after savepoint is not included, so its number is 0
+	txToWriteQueryNum map[*sql.Tx]int
+}
+
+func (c *TxConnection) savepointIndex(name string) int {
+	for idx := len(c.savepoints) - 1; idx >= 0; idx-- {
+		if c.savepoints[idx].name == name {
+			return idx
+		}
+	}
+	return -1
+}
+
+// execOnAllTx executes query on transactions of all databases accessed by transaction
+func (c *TxConnection) execOnAllTx(ctx context.Context, query string) error {
+	errs := &MultiError{}
+	for _, dsn := range c.dsnList {
+		errs.AddShardError("", dsn, errors.WithStack(execOnTx(ctx, c.dsnToTx[dsn], query)))
+	}
+	return errs.ErrorOrNil()
+}
+
+func execOnTx(ctx context.Context, tx *sql.Tx, query string) error {
+	if ctx == nil {
+		_, err := tx.Exec(query)
+		return err
+	}
+	_, err := tx.ExecContext(ctx, query)
+	return err
+}
+
+// restoreSavepoints sets savepoints on transaction of database accessed after them,
+// so ROLLBACK TO SAVEPOINT rollbacks changes on it as well as the other databases.
+func (c *TxConnection) restoreSavepoints(ctx context.Context, tx *sql.Tx) error {
+	for _, sp := range c.savepoints {
+		if err := execOnTx(ctx, tx, fmt.Sprintf("SAVEPOINT %s", sp.name)); err != nil {
+			return errors.Wrapf(err, "cannot set savepoint %s", sp.name)
+		}
+	}
+	return nil
+}
+
+// Savepoint sets savepoint on all databases accessed by transaction.
+// Databases accessed after this also set it when transaction begins on them.
+// If savepoint of the same name exists, it is replaced by new one.
+func (c *TxConnection) Savepoint(ctx context.Context, name string) error {
+	if !savepointNamePattern.MatchString(name) {
+		return errors.Errorf("invalid savepoint name %s", name)
+	}
+	ctx = c.context(ctx)
+	if err := c.execOnAllTx(ctx, fmt.Sprintf("SAVEPOINT %s", name)); err != nil {
+		return errors.Wrapf(err, "cannot set savepoint %s", name)
+	}
+	if idx := c.savepointIndex(name); idx >= 0 {
+		c.savepoints = append(c.savepoints[:idx], c.savepoints[idx+1:]...)
+	}
+	txToWriteQueryNum := map[*sql.Tx]int{}
+	for tx, queries := range c.txToWriteQueries {
+		txToWriteQueryNum[tx] = len(queries)
+	}
+	c.savepoints = append(c.savepoints, &savepoint{
+		name:              name,
+		writeQueryNum:     len(c.WriteQueries),
+		txToWriteQueryNum: txToWriteQueryNum,
+	})
+	return nil
+}
+
+// ReleaseSavepoint removes savepoint and savepoints set after it from all databases accessed by transaction.
+func (c *TxConnection) ReleaseSavepoint(ctx context.Context, name string) error {
+	idx := c.savepointIndex(name)
+	if idx < 0 {
+		return errors.Errorf("savepoint %s does not exist", name)
+	}
+	ctx = c.context(ctx)
+	if err := c.execOnAllTx(ctx, fmt.Sprintf("RELEASE SAVEPOINT %s", name)); err != nil {
+		return errors.Wrapf(err, "cannot release savepoint %s", name)
+	}
+	c.savepoints = c.savepoints[:idx]
+	return nil
+}
+
+// RollbackToSavepoint rollbacks changes after savepoint on all databases accessed by transaction.
+// Write queries executed after savepoint are removed from WriteQueries. Savepoint itself remains.
+//
+// If it is failed on some databases, changes are partially rolled back,
+// so transaction should be rolled back entirely.
+func (c *TxConnection) RollbackToSavepoint(ctx context.Context, name string) error {
+	idx := c.savepointIndex(name)
+	if idx < 0 {
+		return errors.Errorf("savepoint %s does not exist", name)
+	}
+	ctx = c.context(ctx)
+	if err := c.execOnAllTx(ctx, fmt.Sprintf("ROLLBACK TO SAVEPOINT %s", name)); err != nil {
+		return errors.Wrapf(err, "cannot rollback to savepoint %s", name)
+	}
+	sp := c.savepoints[idx]
+	c.savepoints = c.savepoints[:idx+1]
+	c.WriteQueries = c.WriteQueries[:sp.writeQueryNum]
+	for tx, queries := range c.txToWriteQueries {
+		c.txToWriteQueries[tx] = queries[:sp.txToWriteQueryNum[tx]]
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package connection
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+	adap "go.knocknote.io/octillery/connection/adapter"
+)
+
+var (
+	// ErrSchemaMismatch returned when shards of a table have different schema
+	ErrSchemaMismatch = errors.New("schema of shards are not identical")
+
+	// ErrSchemaVerificationNotSupported returned when adapter doesn't implement adapter.SchemaAdapter
+	ErrSchemaVerificationNotSupported = errors.New("adapter doesn't support schema verification")
+)
+
+// SchemaMismatchError has schema of each shard for a table that has different schema between shards.
+type SchemaMismatchError struct {
+	// table name
+	TableName string
+	// map shard name and schema. schema is empty if table doesn't exist in the shard
+	Schemas map[string]string
+	// shard names in order of shards
+	ShardNames []string
+}
+
+func (e *SchemaMismatchError) Error() string {
+	shardNames := make([]string, 0, len(e.Schemas))
+	for shardName := range e.Schemas {
+		shardNames = append(shardNames, shardName)
+	}
+	sort.Strings(shardNames)
+	groups := map[string][]string{}
+	schemas := []string{}
+	for _, shardName := range shardNames {
+		schema := e.Schemas[shardName]
+		if _, exists := groups[schema]; !exists {
+			schemas = append(schemas, schema)
+		}
+		groups[schema] = append(groups[schema], shardName)
+	}
+	details := make([]string, len(schemas))
+	for idx, schema := range schemas {
+		details[idx] = fmt.Sprintf("[%s]", strings.Join(groups[schema], ","))
+	}
+	return fmt.Sprintf("%s: %s. shards are grouped by schema %s", ErrSchemaMismatch, e.TableName, strings.Join(details, " "))
+}
+
+// Cause returns ErrSchemaMismatch for errors.Cause of github.com/pkg/errors
+func (e *SchemaMismatchError) Cause() error {
+	return ErrSchemaMismatch
+}
+
+var (
+	schemaMismatchHandlerMu sync.RWMutex
+	schemaMismatchHandler   = func(err *SchemaMismatchError) {
+		log.Printf("[octillery] WARNING: %s", err)
+	}
+)
+
+// SetSchemaMismatchHandler set function for it is called when schema differences between shards are found under `schema_verification: warn`.
+// Default handler writes warning by standard log package.
+func SetSchemaMismatchHandler(handler func(*SchemaMismatchError)) {
+	schemaMismatchHandlerMu.Lock()
+	defer schemaMismatchHandlerMu.Unlock()
+	schemaMismatchHandler = handler
+}
+
+func handleSchemaMismatch(err *SchemaMismatchError) {
+	schemaMismatchHandlerMu.RLock()
+	defer schemaMismatchHandlerMu.RUnlock()
+	if schemaMismatchHandler != nil {
+		schemaMismatchHandler(err)
+	}
+}
+
+// VerifySchema compares schema of table between all shards.
+// If there are differences, returns *SchemaMismatchError.
+// For table that is not sharded, this always returns nil.
+func (c *DBConnection) VerifySchema(tableName string) error {
+	if !c.IsShard {
+		return nil
+	}
+	schemaAdapter, ok := c.Adapter.(adap.SchemaAdapter)
+	if !ok {
+		return errors.Wrapf(ErrSchemaVerificationNotSupported, "%s", tableName)
+	}
+	schemas := map[string]string{}
+	shardNames := []string{}
+	isIdentical := true
+	var baseSchema *string
+	for _, shardConn := range c.ShardConnections.AllShard() {
+		schema, err := cachedTableSchema(schemaAdapter, shardConn.DSN(), shardConn.Connection, tableName)
+		if err != nil {
+			return errors.Wrapf(err, "cannot get schema of %s from %s", tableName, shardConn.ShardName)
+		}
+		schema = strings.TrimSpace(schema)
+		schemas[shardConn.ShardName] = schema
+		shardNames = append(shardNames, shardConn.ShardName)
+		if baseSchema == nil {
+			baseSchema = &schema
+		} else if *baseSchema != schema {
+			isIdentical = false
+		}
+	}
+	if isIdentical {
+		return nil
+	}
+	return &SchemaMismatchError{TableName: tableName, Schemas: schemas, ShardNames: shardNames}
+}
+
+// verifySchemaByConfig verifies schema by `schema_verification` parameter in configuration file.
+func (c *DBConnection) verifySchemaByConfig(tableName string, cfg *config.Config) error {
+	if cfg == nil || cfg.SchemaVerification == "" {
+		return nil
+	}
+	err := c.VerifySchema(tableName)
+	if err == nil {
+		return nil
+	}
+	if errors.Cause(err) == ErrSchemaVerificationNotSupported {
+		// skip verification at opening connection
+		return nil
+	}
+	mismatchErr, ok := err.(*SchemaMismatchError)
+	if ok && cfg.SchemaVerification == config.SchemaVerificationWarn {
+		handleSchemaMismatch(mismatchErr)
+		return nil
+	}
+	return errors.WithStack(err)
+}
+
+// VerifySchemas compares schema of each table between all shards.
+// If tableNames is not specified, all sharded tables in configuration file are verified.
+// Errors for each table are aggregated to MultiError.
+func (cm *DBConnectionManager) VerifySchemas(tableNames ...string) error {
+	if len(tableNames) == 0 {
+		tableNames = shardedTableNames()
+	}
+	errs := &MultiError{}
+	for _, tableName := range tableNames {
+		conn, err := cm.ConnectionByTableName(tableName)
+		if err != nil {
+			errs.Add(errors.WithStack(err))
+			continue
+		}
+		errs.Add(conn.VerifySchema(tableName))
+	}
+	return errs.ErrorOrNil()
+}
+
+// shardedTableNames returns names of all sharded tables in configuration file in order of name
+func shardedTableNames() []string {
+	tableNames := []string{}
+	for tableName, table := range getGlobalConfig().Tables {
+		if table.IsShard {
+			tableNames = append(tableNames, tableName)
+		}
+	}
+	sort.Strings(tableNames)
+	return tableNames
+}
+
+// VerifySchemas compares schema of each table between all shards by new DBConnectionManager.
+// Connections opened by this are closed before return.
+func VerifySchemas(tableNames ...string) (e error) {
+	mgr, err := NewConnectionManager()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		if err := mgr.Close(); err != nil && e == nil {
+			e = errors.WithStack(err)
+		}
+	}()
+	return mgr.VerifySchemas(tableNames...)
+}
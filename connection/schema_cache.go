@@ -0,0 +1,273 @@
+package connection
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/pkg/errors"
+	adap "go.knocknote.io/octillery/connection/adapter"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+var (
+	// ErrSchemaNotFound returned when table doesn't exist in database
+	ErrSchemaNotFound = errors.New("table doesn't exist")
+
+	// ErrSchemaNotSupported returned when adapter doesn't implement adapter.SchemaAdapter
+	ErrSchemaNotSupported = errors.New("adapter doesn't support fetching schema")
+)
+
+// TableSchema schema of a table fetched from database.
+type TableSchema struct {
+	// table name
+	TableName string
+	// CREATE TABLE statement returned by adapter ( e.g. SHOW CREATE TABLE )
+	Text string
+	// parsed CREATE TABLE statement
+	Stmt *vtparser.CreateTable
+	// time of fetching schema
+	FetchedAt time.Time
+}
+
+// Columns returns column names in order of definition.
+func (s *TableSchema) Columns() []string {
+	columns := make([]string, 0, len(s.Stmt.Columns))
+	for _, column := range s.Stmt.Columns {
+		columns = append(columns, column.Name)
+	}
+	return columns
+}
+
+// Column returns definition of column. If column doesn't exist, returns nil.
+// Column name is compared case-insensitively.
+func (s *TableSchema) Column(name string) *vtparser.ColumnDef {
+	for _, column := range s.Stmt.Columns {
+		if strings.EqualFold(column.Name, name) {
+			return column
+		}
+	}
+	return nil
+}
+
+// HasColumn returns whether table has column or not
+func (s *TableSchema) HasColumn(name string) bool {
+	return s.Column(name) != nil
+}
+
+// PrimaryKey returns column names of primary key. If table doesn't have primary key, returns empty slice.
+func (s *TableSchema) PrimaryKey() []string {
+	columns := []string{}
+	for _, constraint := range s.Stmt.Constraints {
+		if constraint.Type != vtparser.ConstraintPrimaryKey {
+			continue
+		}
+		for _, key := range constraint.Keys {
+			columns = append(columns, key.String())
+		}
+		return columns
+	}
+	for _, column := range s.Stmt.Columns {
+		for _, option := range column.Options {
+			if option.Type == vtparser.ColumnOptionPrimaryKey {
+				columns = append(columns, column.Name)
+			}
+		}
+	}
+	return columns
+}
+
+// SchemaCache caches schema of tables fetched by adapter.SchemaAdapter.
+// Schema is fetched from first shard of sharded table, because all shards are expected to have same schema ( see VerifySchema ).
+type SchemaCache struct {
+	connMgr *DBConnectionManager
+	mu      sync.RWMutex
+	schemas map[string]*TableSchema
+}
+
+// NewSchemaCache creates instance of SchemaCache uses connections of connMgr.
+func NewSchemaCache(connMgr *DBConnectionManager) *SchemaCache {
+	return &SchemaCache{
+		connMgr: connMgr,
+		schemas: map[string]*TableSchema{},
+	}
+}
+
+// Schema returns cached schema of table. If schema is not cached yet, fetches it from database.
+func (c *SchemaCache) Schema(tableName string) (*TableSchema, error) {
+	c.mu.RLock()
+	schema, exists := c.schemas[tableName]
+	c.mu.RUnlock()
+	if exists {
+		return schema, nil
+	}
+	schema, err := c.fetch(tableName)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cachedSchema, exists := c.schemas[tableName]; exists {
+		return cachedSchema, nil
+	}
+	c.schemas[tableName] = schema
+	return schema, nil
+}
+
+// Warm fetches schema of tables that are not cached yet.
+// If tableNames is not specified, all tables in configuration file are fetched.
+// Errors for each table are aggregated to MultiError.
+func (c *SchemaCache) Warm(tableNames ...string) error {
+	errs := &MultiError{}
+	for _, tableName := range c.tableNamesOrAll(tableNames) {
+		_, err := c.Schema(tableName)
+		errs.Add(err)
+	}
+	return errs.ErrorOrNil()
+}
+
+// Refresh fetches schema of tables again from database even if it is cached.
+// If tableNames is not specified, all cached tables are refreshed.
+// If fetching schema of a table fails, cached schema of it is discarded.
+func (c *SchemaCache) Refresh(tableNames ...string) error {
+	if len(tableNames) == 0 {
+		tableNames = c.cachedTableNames()
+	}
+	c.invalidateMetadata(tableNames)
+	errs := &MultiError{}
+	for _, tableName := range tableNames {
+		schema, err := c.fetch(tableName)
+		c.mu.Lock()
+		if err != nil {
+			delete(c.schemas, tableName)
+		} else {
+			c.schemas[tableName] = schema
+		}
+		c.mu.Unlock()
+		errs.Add(err)
+	}
+	return errs.ErrorOrNil()
+}
+
+// Invalidate discards cached schema of tables. If tableNames is not specified, all schemas are discarded.
+// Cached results of metadata queries for them are also discarded ( see InvalidateMetadataCache ).
+func (c *SchemaCache) Invalidate(tableNames ...string) {
+	if len(tableNames) == 0 {
+		c.invalidateMetadata(c.cachedTableNames())
+	} else {
+		c.invalidateMetadata(tableNames)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(tableNames) == 0 {
+		c.schemas = map[string]*TableSchema{}
+		return
+	}
+	for _, tableName := range tableNames {
+		delete(c.schemas, tableName)
+	}
+}
+
+func (c *SchemaCache) cachedTableNames() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	tableNames := make([]string, 0, len(c.schemas))
+	for tableName := range c.schemas {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+	return tableNames
+}
+
+func (*SchemaCache) tableNamesOrAll(tableNames []string) []string {
+	cfg := getGlobalConfig()
+	if len(tableNames) > 0 || cfg == nil {
+		return tableNames
+	}
+	for tableName := range cfg.Tables {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+	return tableNames
+}
+
+// invalidateMetadata discards cached results of metadata queries for databases schema of tables are fetched from
+func (c *SchemaCache) invalidateMetadata(tableNames []string) {
+	for _, tableName := range tableNames {
+		conn := c.connMgr.connMap.Get(tableName)
+		if conn == nil {
+			continue
+		}
+		if shards := conn.Shards(); len(shards) > 0 {
+			globalMetadataCache.invalidate(shards[0].DSN())
+		}
+	}
+}
+
+func (c *SchemaCache) fetch(tableName string) (*TableSchema, error) {
+	conn, err := c.connMgr.ConnectionByTableName(tableName)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return conn.TableSchema(tableName)
+}
+
+// TableSchema fetches schema of table from first shard of this connection by adapter.SchemaAdapter.
+// Text of schema is cached as result of metadata query ( see InvalidateMetadataCache ).
+func (c *DBConnection) TableSchema(tableName string) (*TableSchema, error) {
+	schemaAdapter, ok := c.Adapter.(adap.SchemaAdapter)
+	if !ok {
+		return nil, errors.Wrapf(ErrSchemaNotSupported, "%s", tableName)
+	}
+	shards := c.Shards()
+	if len(shards) == 0 {
+		return nil, errors.Errorf("cannot get database connection of %s", tableName)
+	}
+	text, err := cachedTableSchema(schemaAdapter, shards[0].DSN(), shards[0].Connection, tableName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot get schema of %s", tableName)
+	}
+	return parseTableSchema(tableName, text)
+}
+
+// parseTableSchema parses CREATE TABLE statement fetched by adapter.SchemaAdapter
+func parseTableSchema(tableName string, text string) (*TableSchema, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, errors.Wrapf(ErrSchemaNotFound, "%s", tableName)
+	}
+	parser, err := sqlparser.New()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	query, err := parser.Parse(text)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot parse schema of %s", tableName)
+	}
+	queryBase, ok := query.(*sqlparser.QueryBase)
+	if !ok {
+		return nil, errors.Errorf("schema of %s is not CREATE TABLE statement", tableName)
+	}
+	createTable, ok := queryBase.Stmt.(*vtparser.CreateTable)
+	if !ok {
+		return nil, errors.Errorf("schema of %s is not CREATE TABLE statement", tableName)
+	}
+	return &TableSchema{
+		TableName: tableName,
+		Text:      text,
+		Stmt:      createTable,
+		FetchedAt: time.Now(),
+	}, nil
+}
+
+// SchemaCache returns SchemaCache shared by users of DBConnectionManager ( e.g. import command and recovery by query log ).
+func (cm *DBConnectionManager) SchemaCache() *SchemaCache {
+	cm.schemaCacheMu.Lock()
+	defer cm.schemaCacheMu.Unlock()
+	if cm.schemaCache == nil {
+		cm.schemaCache = NewSchemaCache(cm)
+	}
+	return cm.schemaCache
+}
@@ -0,0 +1,237 @@
+package connection
+
+import (
+	"fmt"
+	"strings"
+
+	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/pkg/errors"
+)
+
+// ShardSchemaDrift differences of schema of a shard from base shards ( see SchemaDrift ).
+type ShardSchemaDrift struct {
+	ShardName string
+	// table doesn't exist in the shard
+	MissingTable bool
+	// columns that exist in base shards but not in the shard
+	MissingColumns []string
+	// columns that exist in the shard but not in base shards
+	ExtraColumns []string
+	// columns whose definition is different from base shards
+	ChangedColumns []string
+	// indexes that exist in base shards but not in the shard. index without name is named by its definition
+	MissingIndexes []string
+	// indexes that exist in the shard but not in base shards
+	ExtraIndexes []string
+	// indexes whose definition is different from base shards
+	ChangedIndexes []string
+	// schema is different by the others ( e.g. order of columns or table options )
+	OtherChanged bool
+}
+
+func (d *ShardSchemaDrift) String() string {
+	if d.MissingTable {
+		return fmt.Sprintf("%s: table doesn't exist", d.ShardName)
+	}
+	details := []string{}
+	add := func(kind string, names []string) {
+		if len(names) > 0 {
+			details = append(details, fmt.Sprintf("%s ( %s )", kind, strings.Join(names, ", ")))
+		}
+	}
+	add("missing columns", d.MissingColumns)
+	add("extra columns", d.ExtraColumns)
+	add("changed columns", d.ChangedColumns)
+	add("missing indexes", d.MissingIndexes)
+	add("extra indexes", d.ExtraIndexes)
+	add("changed indexes", d.ChangedIndexes)
+	if d.OtherChanged {
+		details = append(details, "order of columns or table options are different")
+	}
+	return fmt.Sprintf("%s: %s", d.ShardName, strings.Join(details, ", "))
+}
+
+// SchemaDrift differences of schema of a sharded table between shards.
+// Schema shared by the most shards is regarded as base, and the other shards are compared with it.
+type SchemaDrift struct {
+	TableName string
+	// shards that have base schema
+	BaseShards []string
+	// shards whose schema is different from base schema in order of shard
+	Shards []*ShardSchemaDrift
+}
+
+func (d *SchemaDrift) String() string {
+	lines := []string{fmt.Sprintf("%s: schema is drifted from [%s]", d.TableName, strings.Join(d.BaseShards, ","))}
+	for _, shard := range d.Shards {
+		lines = append(lines, "  "+shard.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SchemaDrift compares schema of table between all shards by columns and indexes.
+// Schema of shards are compared by VerifySchema, and drift is returned for its *SchemaMismatchError.
+// If all shards have identical schema or table is not sharded, returns nil.
+func (c *DBConnection) SchemaDrift(tableName string) (*SchemaDrift, error) {
+	err := c.VerifySchema(tableName)
+	if err == nil {
+		return nil, nil
+	}
+	mismatchErr, ok := err.(*SchemaMismatchError)
+	if !ok {
+		return nil, errors.WithStack(err)
+	}
+	drift, err := mismatchErr.Drift()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return drift, nil
+}
+
+// Drift returns differences of schema of shards by columns and indexes
+func (e *SchemaMismatchError) Drift() (*SchemaDrift, error) {
+	tableName := e.TableName
+	groups := map[string][]string{}
+	for _, shardName := range e.ShardNames {
+		schema := e.Schemas[shardName]
+		groups[schema] = append(groups[schema], shardName)
+	}
+	// shards are iterated in order, so the first group wins if the number of shards is the same.
+	// shards that don't have table are never regarded as base.
+	var baseSchema string
+	for _, shardName := range e.ShardNames {
+		schema := e.Schemas[shardName]
+		if schema == "" {
+			continue
+		}
+		if baseSchema == "" || len(groups[schema]) > len(groups[baseSchema]) {
+			baseSchema = schema
+		}
+	}
+	base, err := parseTableSchema(tableName, baseSchema)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot parse schema of %s on %s", tableName, groups[baseSchema][0])
+	}
+	drift := &SchemaDrift{TableName: tableName, BaseShards: groups[baseSchema]}
+	for _, shardName := range e.ShardNames {
+		schema := e.Schemas[shardName]
+		if schema == baseSchema {
+			continue
+		}
+		if schema == "" {
+			drift.Shards = append(drift.Shards, &ShardSchemaDrift{ShardName: shardName, MissingTable: true})
+			continue
+		}
+		target, err := parseTableSchema(tableName, schema)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot parse schema of %s on %s", tableName, shardName)
+		}
+		drift.Shards = append(drift.Shards, compareTableSchema(shardName, base, target))
+	}
+	return drift, nil
+}
+
+func compareTableSchema(shardName string, base *TableSchema, target *TableSchema) *ShardSchemaDrift {
+	drift := &ShardSchemaDrift{ShardName: shardName}
+	baseColumns := map[string]string{}
+	baseColumnNames := []string{}
+	for _, column := range base.Stmt.Columns {
+		baseColumns[column.Name] = column.String()
+		baseColumnNames = append(baseColumnNames, column.Name)
+	}
+	targetColumns := map[string]string{}
+	targetColumnNames := []string{}
+	for _, column := range target.Stmt.Columns {
+		targetColumns[column.Name] = column.String()
+		targetColumnNames = append(targetColumnNames, column.Name)
+	}
+	drift.MissingColumns, drift.ExtraColumns, drift.ChangedColumns = diffDefinitions(baseColumnNames, baseColumns, targetColumnNames, targetColumns)
+
+	baseIndexNames, baseIndexes := indexDefinitions(base.Stmt.Constraints)
+	targetIndexNames, targetIndexes := indexDefinitions(target.Stmt.Constraints)
+	drift.MissingIndexes, drift.ExtraIndexes, drift.ChangedIndexes = diffDefinitions(baseIndexNames, baseIndexes, targetIndexNames, targetIndexes)
+
+	if len(drift.MissingColumns)+len(drift.ExtraColumns)+len(drift.ChangedColumns)+
+		len(drift.MissingIndexes)+len(drift.ExtraIndexes)+len(drift.ChangedIndexes) == 0 {
+		drift.OtherChanged = true
+	}
+	return drift
+}
+
+// indexDefinitions returns names of indexes in order and mapping from name to definition
+func indexDefinitions(constraints []*vtparser.Constraint) ([]string, map[string]string) {
+	names := []string{}
+	definitions := map[string]string{}
+	for _, constraint := range constraints {
+		definition := constraint.String()
+		name := constraint.Name
+		if constraint.Type == vtparser.ConstraintPrimaryKey {
+			name = "PRIMARY"
+		} else if name == "" {
+			name = definition
+		}
+		names = append(names, name)
+		definitions[name] = definition
+	}
+	return names, definitions
+}
+
+// diffDefinitions returns names that are missing in target, extra in target and changed between base and target
+func diffDefinitions(baseNames []string, base map[string]string, targetNames []string, target map[string]string) ([]string, []string, []string) {
+	var missing, extra, changed []string
+	for _, name := range baseNames {
+		definition, exists := target[name]
+		if !exists {
+			missing = append(missing, name)
+		} else if definition != base[name] {
+			changed = append(changed, name)
+		}
+	}
+	for _, name := range targetNames {
+		if _, exists := base[name]; !exists {
+			extra = append(extra, name)
+		}
+	}
+	return missing, extra, changed
+}
+
+// SchemaDrifts compares schema of each table between all shards by VerifySchemas, and returns drifts of tables.
+// If tableNames is not specified, all sharded tables in configuration file are compared.
+// Errors for each table are aggregated to MultiError.
+func (cm *DBConnectionManager) SchemaDrifts(tableNames ...string) ([]*SchemaDrift, error) {
+	return schemaDrifts(cm.VerifySchemas(tableNames...))
+}
+
+// SchemaDrifts compares schema of each table between all shards by new DBConnectionManager.
+// Connections opened by this are closed before return.
+func SchemaDrifts(tableNames ...string) ([]*SchemaDrift, error) {
+	return schemaDrifts(VerifySchemas(tableNames...))
+}
+
+// schemaDrifts converts *SchemaMismatchError aggregated to err by VerifySchemas to drifts of tables.
+// Other errors are aggregated to MultiError again.
+func schemaDrifts(err error) ([]*SchemaDrift, error) {
+	drifts := []*SchemaDrift{}
+	if err == nil {
+		return drifts, nil
+	}
+	multiErr, ok := err.(*MultiError)
+	if !ok {
+		return nil, errors.WithStack(err)
+	}
+	errs := &MultiError{}
+	for _, err := range multiErr.Errors {
+		mismatchErr, ok := err.(*SchemaMismatchError)
+		if !ok {
+			errs.Add(err)
+			continue
+		}
+		drift, err := mismatchErr.Drift()
+		if err != nil {
+			errs.Add(err)
+			continue
+		}
+		drifts = append(drifts, drift)
+	}
+	return drifts, errs.ErrorOrNil()
+}
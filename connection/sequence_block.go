@@ -0,0 +1,160 @@
+package connection
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	adap "go.knocknote.io/octillery/connection/adapter"
+	"go.knocknote.io/octillery/metrics"
+)
+
+// sequenceBlock range of ids allocated from sequencer. ids from next to last are not handed out yet
+type sequenceBlock struct {
+	next int64
+	last int64
+}
+
+// sequenceBlockCache hands out ids from blocks allocated by 'sequencer_cache_size' for each sequencer table ( or partition )
+type sequenceBlockCache struct {
+	mu     sync.Mutex
+	size   int64
+	blocks map[string]*sequenceBlock
+	// allocations of blocks in progress. other callers for the same block wait for it instead of allocating another block
+	refills map[string]*sequenceBlockRefill
+	// incremented by reset, so blocks allocated before reset are discarded
+	generation int
+}
+
+// sequenceBlockRefill allocation of block from sequencer. done is closed after block or err is set
+type sequenceBlockRefill struct {
+	done  chan struct{}
+	block *sequenceBlock
+	err   error
+}
+
+func newSequenceBlockCache(size int) *sequenceBlockCache {
+	return &sequenceBlockCache{
+		size:    int64(size),
+		blocks:  map[string]*sequenceBlock{},
+		refills: map[string]*sequenceBlockRefill{},
+	}
+}
+
+// nextID returns id from block of seqTableName. If block is used up, allocates new block from sequencer.
+// Blocks are kept for each replica of sequencer, because ids are converted by range of replica allocated them.
+// Lock is not held while block is allocated, so ids of other blocks are handed out during round trip to sequencer.
+func (c *sequenceBlockCache) nextID(ctx context.Context, conn *DBConnection, tableName string, seqTableName string) (int64, error) {
+	key := fmt.Sprintf("%d/%s", conn.sequencerIndex, seqTableName)
+	c.mu.Lock()
+	for {
+		if block, exists := c.blocks[key]; exists && block.next <= block.last {
+			id := block.next
+			block.next++
+			c.mu.Unlock()
+			return id, nil
+		}
+		refill, inProgress := c.refills[key]
+		if !inProgress {
+			refill = &sequenceBlockRefill{done: make(chan struct{})}
+			c.refills[key] = refill
+			generation := c.generation
+			c.mu.Unlock()
+			refill.block, refill.err = c.allocate(ctx, conn, tableName, seqTableName)
+			c.mu.Lock()
+			delete(c.refills, key)
+			close(refill.done)
+			if refill.err != nil {
+				c.mu.Unlock()
+				return 0, errors.WithStack(refill.err)
+			}
+			if generation == c.generation {
+				c.blocks[key] = refill.block
+			}
+			continue
+		}
+		c.mu.Unlock()
+		if err := waitRefill(ctx, refill); err != nil {
+			return 0, errors.WithStack(err)
+		}
+		c.mu.Lock()
+	}
+}
+
+// waitRefill waits for allocation of block by other caller, and returns error if it is failed
+func waitRefill(ctx context.Context, refill *sequenceBlockRefill) error {
+	if ctx == nil {
+		<-refill.done
+		return errors.WithStack(refill.err)
+	}
+	select {
+	case <-refill.done:
+		return errors.WithStack(refill.err)
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	}
+}
+
+func (c *sequenceBlockCache) allocate(ctx context.Context, conn *DBConnection, tableName string, seqTableName string) (*sequenceBlock, error) {
+	adapter, ok := conn.Adapter.(adap.SequenceBlockAdapter)
+	if !ok {
+		return nil, errors.Errorf("adapter of %s doesn't support sequencer_cache_size", tableName)
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	startedAt := time.Now()
+	lastID, supported, err := adapter.NextSequenceIDBlock(ctx, conn.Sequencer, seqTableName, c.size)
+	metrics.RecordSequencer(tableName, time.Since(startedAt), err)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !supported {
+		return nil, errors.Errorf("adapter of %s doesn't support sequencer_cache_size", tableName)
+	}
+	return &sequenceBlock{next: lastID - c.size + 1, last: lastID}, nil
+}
+
+func (c *sequenceBlockCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blocks = map[string]*sequenceBlock{}
+	c.generation++
+}
+
+// ResetSequenceIDBlocks discards ids allocated by 'sequencer_cache_size' and not handed out yet.
+// Next id is allocated from sequencer again, so call this after sequencer is changed by others ( e.g. restored from backup ).
+// Discarded ids are never used, so ids have gap.
+func (c *DBConnection) ResetSequenceIDBlocks() {
+	if c.sequenceBlocks != nil {
+		c.sequenceBlocks.reset()
+	}
+}
+
+// ResetSequenceIDBlocks discards ids allocated by 'sequencer_cache_size' of tables and not handed out yet.
+// If tableNames is empty, ids of all tables using sequencer are discarded.
+func (cm *DBConnectionManager) ResetSequenceIDBlocks(tableNames ...string) error {
+	if len(tableNames) == 0 {
+		cfg := getGlobalConfig()
+		if cfg == nil {
+			return errors.New("cannot reset sequence id blocks. config is not loaded")
+		}
+		for tableName, table := range cfg.Tables {
+			if table.IsUsedSequencerDatabase() {
+				tableNames = append(tableNames, tableName)
+			}
+		}
+		sort.Strings(tableNames)
+	}
+	for _, tableName := range tableNames {
+		conn, err := cm.ConnectionByTableName(tableName)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		conn.ResetSequenceIDBlocks()
+	}
+	return nil
+}
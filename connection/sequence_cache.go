@@ -0,0 +1,161 @@
+package connection
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SequenceIDStat current sequence id of a table cached for monitoring
+type SequenceIDStat struct {
+	// table name using sequencer
+	TableName string
+	// current sequence id. it keeps previous value if refresh failed
+	ID int64
+	// time when ID was fetched from sequencer. zero if it has never been fetched
+	UpdatedAt time.Time
+	// error of last refresh
+	Err error
+}
+
+// sequenceIDCache refreshes current sequence ids of all tables using sequencer periodically
+type sequenceIDCache struct {
+	mu    sync.RWMutex
+	stats map[string]*SequenceIDStat
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func (c *sequenceIDCache) refresh(cm *DBConnectionManager, timeout time.Duration) {
+	for tableName, table := range getGlobalConfig().Tables {
+		if !table.IsUsedSequencerDatabase() {
+			continue
+		}
+		var (
+			id  int64
+			err error
+		)
+		conn, err := cm.ConnectionByTableName(tableName)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			id, err = conn.CurrentSequenceIDContext(ctx, tableName)
+			cancel()
+		}
+		c.mu.Lock()
+		stat, exists := c.stats[tableName]
+		if !exists {
+			stat = &SequenceIDStat{TableName: tableName}
+			c.stats[tableName] = stat
+		}
+		stat.Err = errors.WithStack(err)
+		if err == nil {
+			stat.ID = id
+			stat.UpdatedAt = time.Now()
+		}
+		c.mu.Unlock()
+	}
+}
+
+func (c *sequenceIDCache) run(cm *DBConnectionManager, interval time.Duration) {
+	defer close(c.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.refresh(cm, interval)
+		}
+	}
+}
+
+func (c *sequenceIDCache) close() {
+	close(c.stop)
+	<-c.done
+}
+
+// StartSequenceIDCache fetches current sequence ids of all tables using sequencer, and refreshes them at each interval in background.
+//
+// Monitoring systems should read ids by CachedSequenceID instead of CurrentSequenceID,
+// because CurrentSequenceID updates sequencer table for getting id ( e.g. LAST_INSERT_ID() of MySQL ) and adds write load to sequencer.
+// If cache is already started, it is restarted by new interval.
+func (cm *DBConnectionManager) StartSequenceIDCache(interval time.Duration) error {
+	if interval <= 0 {
+		return errors.Errorf("invalid interval %s", interval)
+	}
+	if getGlobalConfig() == nil {
+		return errors.New("cannot start sequence id cache. config is not loaded")
+	}
+	cm.StopSequenceIDCache()
+	cache := &sequenceIDCache{
+		stats: map[string]*SequenceIDStat{},
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	cache.refresh(cm, interval)
+	go cache.run(cm, interval)
+
+	cm.sequenceIDCacheMu.Lock()
+	cm.sequenceIDCache = cache
+	cm.sequenceIDCacheMu.Unlock()
+	return nil
+}
+
+// StopSequenceIDCache stops refreshing sequence ids started by StartSequenceIDCache.
+func (cm *DBConnectionManager) StopSequenceIDCache() {
+	cm.sequenceIDCacheMu.Lock()
+	cache := cm.sequenceIDCache
+	cm.sequenceIDCache = nil
+	cm.sequenceIDCacheMu.Unlock()
+	if cache != nil {
+		cache.close()
+	}
+}
+
+func (cm *DBConnectionManager) currentSequenceIDCache() (*sequenceIDCache, error) {
+	cm.sequenceIDCacheMu.Lock()
+	defer cm.sequenceIDCacheMu.Unlock()
+	if cm.sequenceIDCache == nil {
+		return nil, errors.New("sequence id cache is not started. call StartSequenceIDCache before")
+	}
+	return cm.sequenceIDCache, nil
+}
+
+// CachedSequenceID returns current sequence id of table cached by StartSequenceIDCache without querying sequencer.
+func (cm *DBConnectionManager) CachedSequenceID(tableName string) (*SequenceIDStat, error) {
+	cache, err := cm.currentSequenceIDCache()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	stat, exists := cache.stats[tableName]
+	if !exists {
+		return nil, errors.Errorf("%s doesn't use sequencer", tableName)
+	}
+	copied := *stat
+	return &copied, nil
+}
+
+// CachedSequenceIDs returns current sequence ids of all tables using sequencer cached by StartSequenceIDCache in order of table name.
+func (cm *DBConnectionManager) CachedSequenceIDs() ([]*SequenceIDStat, error) {
+	cache, err := cm.currentSequenceIDCache()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	stats := make([]*SequenceIDStat, 0, len(cache.stats))
+	for _, stat := range cache.stats {
+		copied := *stat
+		stats = append(stats, &copied)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].TableName < stats[j].TableName
+	})
+	return stats, nil
+}
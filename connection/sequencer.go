@@ -0,0 +1,213 @@
+package connection
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+	adap "go.knocknote.io/octillery/connection/adapter"
+	"go.knocknote.io/octillery/debug"
+	"go.knocknote.io/octillery/metrics"
+)
+
+func sequencerPartitionNum(cfg *config.DatabaseConfig) int {
+	if cfg == nil || cfg.Partitions < 1 {
+		return 1
+	}
+	return cfg.Partitions
+}
+
+// sequencerPartitionTableName returns table name of sequencer partition.
+// If sequencer is not partitioned, returns same name as before partitioning is supported.
+func sequencerPartitionTableName(tableName string, partition int, partitionNum int) string {
+	if partitionNum == 1 {
+		return sequencerTableName(tableName)
+	}
+	return fmt.Sprintf("%s_%d", sequencerTableName(tableName), partition)
+}
+
+// sequenceIDByPartition converts id published by sequencer partition to unique id for all partitions.
+// Each partition has interleaved range like partition 0 => 1, 1+N, 1+2N ..., partition 1 => 2, 2+N, 2+2N ...
+func sequenceIDByPartition(partitionID int64, partition int, partitionNum int) int64 {
+	if partitionID == 0 {
+		return 0
+	}
+	return (partitionID-1)*int64(partitionNum) + int64(partition) + 1
+}
+
+type sequenceIDResult struct {
+	id  int64
+	err error
+}
+
+// callSequencer calls adapter by context.
+// If adapter doesn't implement ContextSequencerAdapter, it returns error when context is done without waiting response from sequencer.
+func callSequencer(ctx context.Context, fn func() (int64, error)) (int64, error) {
+	if ctx == nil || ctx.Done() == nil {
+		return fn()
+	}
+	resultCh := make(chan *sequenceIDResult, 1)
+	go func() {
+		id, err := fn()
+		resultCh <- &sequenceIDResult{id: id, err: err}
+	}()
+	select {
+	case <-ctx.Done():
+		return 0, errors.Wrap(ctx.Err(), "cannot get response from sequencer")
+	case result := <-resultCh:
+		return result.id, result.err
+	}
+}
+
+func (c *DBConnection) adapterNextSequenceID(ctx context.Context, seqConn *sql.DB, seqTableName string) (int64, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if adapter, ok := c.Adapter.(adap.ContextSequencerAdapter); ok {
+		return adapter.NextSequenceIDContext(ctx, seqConn, seqTableName)
+	}
+	return callSequencer(ctx, func() (int64, error) {
+		return c.Adapter.NextSequenceID(seqConn, seqTableName)
+	})
+}
+
+func (c *DBConnection) adapterCurrentSequenceID(ctx context.Context, seqConn *sql.DB, seqTableName string) (int64, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if adapter, ok := c.Adapter.(adap.ContextSequencerAdapter); ok {
+		return adapter.CurrentSequenceIDContext(ctx, seqConn, seqTableName)
+	}
+	return callSequencer(ctx, func() (int64, error) {
+		return c.Adapter.CurrentSequenceID(seqConn, seqTableName)
+	})
+}
+
+// nextSequenceIDByPartition returns next unique id by partition of sequencer.
+// If sequencer fails by connection error and doesn't respond to ping, sequencer is failed over to its replica,
+// and id is published by replica again. Ids published by each replica have interleaved range, so they never collide.
+func (c *DBConnection) nextSequenceIDByPartition(ctx context.Context, tableName string, partition int, partitionNum int) (int64, error) {
+	if c.IDGenerator != nil {
+		return c.nextGeneratedID(ctx, tableName)
+	}
+	if c.Sequencer == nil {
+		return 0, errors.New("cannot get next sequence id")
+	}
+	id, err := c.nextSequenceIDBySequencer(ctx, tableName, partition, partitionNum)
+	if err == nil || c.failover == nil || len(c.sequencers) < 2 || c.ErrorClass(err) != config.RetryOnConnection {
+		return id, errors.WithStack(err)
+	}
+	replica, failoverErr := c.failover.cm.failoverSequencer(c.tableName, c.Sequencer, err)
+	if failoverErr != nil {
+		debug.Printf("%+v", failoverErr)
+		return 0, errors.WithStack(err)
+	}
+	if replica == nil {
+		return 0, errors.WithStack(err)
+	}
+	id, err = replica.nextSequenceIDBySequencer(ctx, tableName, partition, partitionNum)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return id, nil
+}
+
+// nextSequenceIDBySequencer returns next unique id by partition of current sequencer ( or its replica after failover )
+func (c *DBConnection) nextSequenceIDBySequencer(ctx context.Context, tableName string, partition int, partitionNum int) (int64, error) {
+	seqTableName := sequencerPartitionTableName(tableName, partition, partitionNum)
+	slot := c.sequencerIndex*partitionNum + partition
+	slotNum := sequencerNum(c.Config.Sequencer) * partitionNum
+	if c.sequenceBlocks != nil {
+		partitionID, err := c.sequenceBlocks.nextID(ctx, c, tableName, seqTableName)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		return sequenceIDByPartition(partitionID, slot, slotNum), nil
+	}
+	startedAt := time.Now()
+	partitionID, err := c.adapterNextSequenceID(ctx, c.Sequencer, seqTableName)
+	metrics.RecordSequencer(tableName, time.Since(startedAt), err)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return sequenceIDByPartition(partitionID, slot, slotNum), nil
+}
+
+// nextGeneratedID returns next unique id by id generator instead of sequencer's database
+func (c *DBConnection) nextGeneratedID(ctx context.Context, tableName string) (int64, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	startedAt := time.Now()
+	id, err := c.IDGenerator.NextID(ctx)
+	metrics.RecordSequencer(tableName, time.Since(startedAt), err)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return id, nil
+}
+
+// NextSequenceID returns next unique id by sequencer table name.
+// If sequencer is partitioned, partition is selected by round robin.
+func (c *DBConnection) NextSequenceID(tableName string) (int64, error) {
+	return c.NextSequenceIDContext(context.Background(), tableName)
+}
+
+// NextSequenceIDContext returns next unique id by sequencer table name with context.
+func (c *DBConnection) NextSequenceIDContext(ctx context.Context, tableName string) (int64, error) {
+	partitionNum := sequencerPartitionNum(c.Config.Sequencer)
+	partition := int(atomic.AddUint32(&c.sequencerCounter, 1) % uint32(partitionNum))
+	return c.nextSequenceIDByPartition(ctx, tableName, partition, partitionNum)
+}
+
+// NextSequenceIDByKey returns next unique id by sequencer table name.
+// If sequencer is partitioned, partition is selected by hash of key ( e.g. value of shard_key ).
+func (c *DBConnection) NextSequenceIDByKey(tableName string, key int64) (int64, error) {
+	return c.NextSequenceIDByKeyContext(context.Background(), tableName, key)
+}
+
+// NextSequenceIDByKeyContext returns next unique id by sequencer table name and key with context.
+func (c *DBConnection) NextSequenceIDByKeyContext(ctx context.Context, tableName string, key int64) (int64, error) {
+	partitionNum := sequencerPartitionNum(c.Config.Sequencer)
+	hash := fnv.New32a()
+	hash.Write([]byte(strconv.FormatInt(key, 10)))
+	partition := int(hash.Sum32() % uint32(partitionNum))
+	return c.nextSequenceIDByPartition(ctx, tableName, partition, partitionNum)
+}
+
+// CurrentSequenceID returns current unique id by sequencer table name.
+// If sequencer is partitioned or has replicas, returns maximum id of all partitions of them.
+func (c *DBConnection) CurrentSequenceID(tableName string) (int64, error) {
+	return c.CurrentSequenceIDContext(context.Background(), tableName)
+}
+
+// CurrentSequenceIDContext returns current unique id by sequencer table name with context.
+func (c *DBConnection) CurrentSequenceIDContext(ctx context.Context, tableName string) (int64, error) {
+	if c.IDGenerator != nil {
+		return 0, errors.Errorf("cannot get current sequence id. ids of %s are generated by %s", tableName, c.Config.Sequencer.Type)
+	}
+	if c.Sequencer == nil {
+		return 0, errors.New("cannot get current sequence id")
+	}
+	partitionNum := sequencerPartitionNum(c.Config.Sequencer)
+	slotNum := sequencerNum(c.Config.Sequencer) * partitionNum
+	var maxID int64
+	for idx, seqConn := range c.sequencerConns() {
+		for partition := 0; partition < partitionNum; partition++ {
+			partitionID, err := c.adapterCurrentSequenceID(ctx, seqConn, sequencerPartitionTableName(tableName, partition, partitionNum))
+			if err != nil {
+				return 0, errors.WithStack(err)
+			}
+			if id := sequenceIDByPartition(partitionID, idx*partitionNum+partition, slotNum); id > maxID {
+				maxID = id
+			}
+		}
+	}
+	return maxID, nil
+}
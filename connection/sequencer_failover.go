@@ -0,0 +1,110 @@
+package connection
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+	adap "go.knocknote.io/octillery/connection/adapter"
+	"go.knocknote.io/octillery/debug"
+)
+
+// sequencerConfigs returns configurations of sequencer and its replicas in order of failover
+func sequencerConfigs(cfg *config.DatabaseConfig) []*config.DatabaseConfig {
+	if cfg == nil {
+		return nil
+	}
+	return append([]*config.DatabaseConfig{cfg}, cfg.Replicas...)
+}
+
+// sequencerNum returns number of sequencer databases ( sequencer and its replicas ) publishing ids
+func sequencerNum(cfg *config.DatabaseConfig) int {
+	if cfg == nil {
+		return 1
+	}
+	return 1 + len(cfg.Replicas)
+}
+
+// sequencerConns returns connections to sequencer and its replicas in order of configuration
+func (c *DBConnection) sequencerConns() []*sql.DB {
+	if len(c.sequencers) > 0 {
+		return c.sequencers
+	}
+	if c.Sequencer == nil {
+		return nil
+	}
+	return []*sql.DB{c.Sequencer}
+}
+
+// openSequencerConnections opens connections to sequencer and its replicas of table
+func (cm *DBConnectionManager) openSequencerConnections(table *config.TableConfig) ([]*sql.DB, error) {
+	conns := []*sql.DB{}
+	for _, cfg := range sequencerConfigs(table.Sequencer) {
+		adapter, err := adap.Adapter(cfg.Adapter)
+		if err != nil {
+			closeConns(conns)
+			return nil, errors.WithStack(err)
+		}
+		conn, err := adapter.OpenConnection(cfg, cm.queryString)
+		if err != nil {
+			closeConns(conns)
+			return nil, errors.Wrapf(err, "cannot open connection to sequencer %s", masterDSN(cfg))
+		}
+		cm.setConnectionSettings(conn, table, cfg)
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+// closeConns closes all connection pools of conns
+func closeConns(conns []*sql.DB) error {
+	errs := &MultiError{}
+	for _, conn := range conns {
+		errs.Add(closeConn(conn))
+	}
+	return errs.ErrorOrNil()
+}
+
+// failoverSequencer fails over sequencer of table to next replica responding to ping if failed sequencer doesn't respond to ping,
+// and returns connection using new sequencer. If sequencer is already failed over by other query, returns current connection.
+// If failed sequencer responds to ping, returns nil.
+// Connection pool of failed sequencer is not closed, because it becomes candidate of next failover after recovery.
+func (cm *DBConnectionManager) failoverSequencer(tableName string, failed *sql.DB, cause error) (*DBConnection, error) {
+	cm.credentialMu.Lock()
+	defer cm.credentialMu.Unlock()
+
+	conn, err := cm.ConnectionByTableName(tableName)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if conn.Sequencer != failed {
+		return conn, nil
+	}
+	configs := sequencerConfigs(conn.Config.Sequencer)
+	if len(conn.sequencers) < 2 || len(conn.sequencers) != len(configs) || pingConn(failed) == nil {
+		return nil, nil
+	}
+	from := masterDSN(configs[conn.sequencerIndex])
+	errs := &MultiError{}
+	for i := 1; i < len(conn.sequencers); i++ {
+		index := (conn.sequencerIndex + i) % len(conn.sequencers)
+		if err := pingConn(conn.sequencers[index]); err != nil {
+			errs.Add(errors.Wrapf(err, "cannot ping sequencer %s", masterDSN(configs[index])))
+			continue
+		}
+		newConn := *conn
+		newConn.Sequencer = conn.sequencers[index]
+		newConn.sequencerIndex = index
+		cm.connMap.Set(tableName, &newConn)
+		to := masterDSN(configs[index])
+		debug.Printf("failed over sequencer of %s from %s to %s", tableName, from, to)
+		cm.failover.notify(&FailoverEvent{
+			Table: tableName,
+			From:  from,
+			To:    to,
+			Err:   cause,
+		})
+		return &newConn, nil
+	}
+	return nil, errors.Wrapf(errs.ErrorOrNil(), "cannot fail over sequencer %s of %s", from, tableName)
+}
@@ -0,0 +1,294 @@
+package connection
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+	adap "go.knocknote.io/octillery/connection/adapter"
+	"go.knocknote.io/octillery/debug"
+	"go.knocknote.io/octillery/idgen"
+)
+
+// SequencerMigration switch of sequencer of table by MigrateSequencer ( e.g. from sequencer's database to snowflake ).
+//
+// Ids published by new sequencer are greater than MaxID + Headroom, so they never collide with ids existing in shards.
+// Headroom is reserved for ids published by old sequencer for queries in progress during the switch, and Verify checks it.
+// Old sequencer is kept until Commit, so Rollback can switch back to it with the same guarantee.
+// Configuration file must be changed to new sequencer after Commit, because the switch is not persisted.
+type SequencerMigration struct {
+	// table name using sequencer
+	TableName string
+	// configuration of sequencer before migration
+	From *config.DatabaseConfig
+	// configuration of sequencer after migration
+	To *config.DatabaseConfig
+	// maximum id of shard_column in all shards ( or published by old sequencer ) at the time of switch
+	MaxID int64
+	// number of ids reserved for old sequencer after MaxID
+	Headroom int64
+	// first id published by new sequencer to verify the switch. it is always greater than MaxID + Headroom
+	FirstID int64
+
+	cm       *DBConnectionManager
+	mu       sync.Mutex
+	oldConn  *DBConnection
+	newConn  *DBConnection
+	finished bool
+}
+
+// MigrateSequencer switches sequencer of table to the one of configuration to, and returns migration for verification and rollback.
+// Before the switch, maximum id of shard_column in all shards is read, and sequencer's database of to is advanced above it
+// ( id generator of to must generate larger ids like snowflake, otherwise migration fails ).
+// headroom is number of ids published by old sequencer for queries in progress during the switch.
+// Tables using unique_columns cannot be migrated, because their values are reserved in sequencer's database.
+func (cm *DBConnectionManager) MigrateSequencer(ctx context.Context, tableName string, to *config.DatabaseConfig, headroom int64) (*SequencerMigration, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if to == nil {
+		return nil, errors.Errorf("sequencer of %s to migrate is not defined", tableName)
+	}
+	if headroom < 0 {
+		return nil, errors.New("headroom of sequencer migration must be positive number")
+	}
+	cm.credentialMu.Lock()
+	defer cm.credentialMu.Unlock()
+
+	conn, err := cm.ConnectionByTableName(tableName)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !conn.Config.IsUsedSequencer() {
+		return nil, errors.Errorf("%s doesn't use sequencer", tableName)
+	}
+	if len(conn.Config.UniqueColumns) > 0 {
+		return nil, errors.Errorf("cannot migrate sequencer of %s. values of unique_columns are reserved in sequencer's database", tableName)
+	}
+	newConn, err := cm.openMigratedSequencer(tableName, conn, to)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	migration := &SequencerMigration{
+		TableName: tableName,
+		From:      conn.Config.Sequencer,
+		To:        to,
+		Headroom:  headroom,
+		cm:        cm,
+		oldConn:   conn,
+		newConn:   newConn,
+	}
+	if migration.MaxID, migration.FirstID, err = cm.switchSequencer(ctx, tableName, conn, newConn, headroom); err != nil {
+		closeConns(newConn.sequencers)
+		return nil, errors.WithStack(err)
+	}
+	debug.Printf("migrated sequencer of %s. max id = %d, first id = %d", tableName, migration.MaxID, migration.FirstID)
+	return migration, nil
+}
+
+// openMigratedSequencer returns copy of conn using sequencer of configuration to.
+// Tables of sequencer are created in its database if they don't exist.
+func (cm *DBConnectionManager) openMigratedSequencer(tableName string, conn *DBConnection, to *config.DatabaseConfig) (*DBConnection, error) {
+	table := *conn.Config
+	table.Sequencer = to
+	if err := table.Error(); err != nil {
+		return nil, errors.Wrapf(err, "invalid sequencer of %s to migrate", tableName)
+	}
+	newConn := *conn
+	newConn.Config = &table
+	newConn.Sequencer = nil
+	newConn.sequencers = nil
+	newConn.sequencerIndex = 0
+	newConn.IDGenerator = nil
+	newConn.sequenceBlocks = nil
+	if to.IsIDGenerator() {
+		generator, err := idgen.New(to)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid sequencer of %s to migrate", tableName)
+		}
+		newConn.IDGenerator = generator
+		return &newConn, nil
+	}
+	for _, seqConfig := range sequencerConfigs(to) {
+		if err := setupSequencerDB(tableName, &table, seqConfig); err != nil {
+			return nil, errors.Wrapf(err, "cannot set up sequencer %s", masterDSN(seqConfig))
+		}
+	}
+	sequencers, err := cm.openSequencerConnections(&table)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	newConn.Sequencer = sequencers[0]
+	newConn.sequencers = sequencers
+	if table.SequencerCacheSize > 1 {
+		newConn.sequenceBlocks = newSequenceBlockCache(table.SequencerCacheSize)
+	}
+	return &newConn, nil
+}
+
+// switchSequencer advances sequencer of next above ids of current, verifies first id of next and swaps current with next.
+// It returns maximum id at the time of switch and first id published by next.
+func (cm *DBConnectionManager) switchSequencer(ctx context.Context, tableName string, current *DBConnection, next *DBConnection, headroom int64) (int64, int64, error) {
+	maxID, err := current.maxSequenceID(ctx, tableName)
+	if err != nil {
+		return 0, 0, errors.WithStack(err)
+	}
+	if next.IDGenerator == nil {
+		if err := next.advanceSequencer(ctx, tableName, maxID+headroom); err != nil {
+			return 0, 0, errors.WithStack(err)
+		}
+	}
+	firstID, err := next.NextSequenceIDContext(ctx, tableName)
+	if err != nil {
+		return 0, 0, errors.WithStack(err)
+	}
+	if firstID <= maxID+headroom {
+		return 0, 0, errors.Errorf("cannot switch sequencer of %s. first id %d of new sequencer must be greater than %d ( max id %d + headroom %d )", tableName, firstID, maxID+headroom, maxID, headroom)
+	}
+	cm.connMap.Set(tableName, next)
+	return maxID, firstID, nil
+}
+
+// maxSequenceID returns maximum id of shard_column in all shards and current id of sequencer's database
+func (c *DBConnection) maxSequenceID(ctx context.Context, tableName string) (int64, error) {
+	var (
+		mu    sync.Mutex
+		maxID int64
+	)
+	query := fmt.Sprintf("SELECT MAX(%s) FROM %s", c.ShardColumnName, tableName)
+	err := c.ForEachShard(func(shardConn *DBShardConnection) error {
+		var id sql.NullInt64
+		if err := shardConn.Connection.QueryRowContext(ctx, query).Scan(&id); err != nil {
+			return errors.Wrapf(err, "cannot get max id of %s", tableName)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if id.Valid && id.Int64 > maxID {
+			maxID = id.Int64
+		}
+		return nil
+	}, &ForEachShardOptions{Concurrency: len(c.Shards()), Context: ctx})
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if c.IDGenerator != nil {
+		return maxID, nil
+	}
+	currentID, err := c.CurrentSequenceIDContext(ctx, tableName)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if currentID > maxID {
+		maxID = currentID
+	}
+	return maxID, nil
+}
+
+// advanceSequencer advances all partitions of sequencer ( and its replicas ) so that next ids are greater than minID
+func (c *DBConnection) advanceSequencer(ctx context.Context, tableName string, minID int64) error {
+	adapter, ok := c.Adapter.(adap.SequenceBlockAdapter)
+	if !ok {
+		return errors.Errorf("adapter of %s doesn't support advancing sequencer", tableName)
+	}
+	partitionNum := sequencerPartitionNum(c.Config.Sequencer)
+	slotNum := sequencerNum(c.Config.Sequencer) * partitionNum
+	// next id published by any partition is greater than minID if current id of the partition is not less than this
+	minPartitionID := minID/int64(slotNum) + 1
+	for _, seqConn := range c.sequencerConns() {
+		for partition := 0; partition < partitionNum; partition++ {
+			seqTableName := sequencerPartitionTableName(tableName, partition, partitionNum)
+			currentID, err := c.adapterCurrentSequenceID(ctx, seqConn, seqTableName)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if currentID >= minPartitionID {
+				continue
+			}
+			if _, supported, err := adapter.NextSequenceIDBlock(ctx, seqConn, seqTableName, minPartitionID-currentID); err != nil {
+				return errors.Wrapf(err, "cannot advance sequencer %s", seqTableName)
+			} else if !supported {
+				return errors.Errorf("adapter of %s doesn't support advancing sequencer", tableName)
+			}
+		}
+	}
+	return nil
+}
+
+// Verify checks that ids published by old sequencer during the switch don't reach FirstID.
+// If old sequencer is id generator, ids published by it cannot be checked, so this checks that migration is still in effect only.
+func (m *SequencerMigration) Verify(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.finished {
+		return errors.Errorf("migration of sequencer of %s is already finished", m.TableName)
+	}
+	conn, err := m.cm.ConnectionByTableName(m.TableName)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if conn.Config != m.newConn.Config {
+		return errors.Errorf("sequencer of %s is changed by others after migration", m.TableName)
+	}
+	if m.oldConn.IDGenerator != nil {
+		return nil
+	}
+	currentID, err := m.oldConn.CurrentSequenceIDContext(ctx, m.TableName)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if currentID >= m.FirstID {
+		return errors.Errorf("old sequencer of %s published id %d after first id %d of new sequencer. ids may collide, so headroom must be larger", m.TableName, currentID, m.FirstID)
+	}
+	return nil
+}
+
+// Commit finishes migration. Connections to old sequencer are closed after queries in progress are drained.
+func (m *SequencerMigration) Commit() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.finished {
+		return errors.Errorf("migration of sequencer of %s is already finished", m.TableName)
+	}
+	m.finished = true
+	for _, seqConn := range m.oldConn.sequencers {
+		m.cm.drainConn(seqConn)
+	}
+	return nil
+}
+
+// Rollback switches sequencer back to old one. Old sequencer's database is advanced above ids published by new sequencer,
+// so ids are kept unique. Connections to new sequencer are closed after queries in progress are drained.
+func (m *SequencerMigration) Rollback(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.finished {
+		return errors.Errorf("migration of sequencer of %s is already finished", m.TableName)
+	}
+	m.cm.credentialMu.Lock()
+	defer m.cm.credentialMu.Unlock()
+
+	conn, err := m.cm.ConnectionByTableName(m.TableName)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if conn.Config != m.newConn.Config {
+		return errors.Errorf("sequencer of %s is changed by others after migration", m.TableName)
+	}
+	if _, _, err := m.cm.switchSequencer(ctx, m.TableName, conn, m.oldConn, m.Headroom); err != nil {
+		return errors.Wrapf(err, "cannot roll back sequencer of %s", m.TableName)
+	}
+	m.finished = true
+	for _, seqConn := range conn.sequencers {
+		m.cm.drainConn(seqConn)
+	}
+	return nil
+}
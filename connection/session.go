@@ -0,0 +1,137 @@
+package connection
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// SessionConnection pins a connection ( session ) of each database accessed through it.
+//
+// Queries routed to the same database are executed on the same connection,
+// so session state ( e.g. variables set by SET or temporary tables ) is kept between them.
+// Queries are always executed on master, because session of slave is different from it.
+type SessionConnection struct {
+	mu        sync.Mutex
+	dsnList   []string
+	dsnToConn map[string]*sql.Conn
+	closed    bool
+}
+
+// NewSessionConnection creates instance of SessionConnection. connection of each database is pinned at first access.
+func NewSessionConnection() *SessionConnection {
+	return &SessionConnection{dsnToConn: map[string]*sql.Conn{}}
+}
+
+func (s *SessionConnection) conn(ctx context.Context, conn Connection) (*sql.Conn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errors.New("connection is already closed")
+	}
+	dsn := conn.DSN()
+	if pinned, exists := s.dsnToConn[dsn]; exists {
+		return pinned, nil
+	}
+	pinned, err := conn.Conn().Conn(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot get connection to %s", dsn)
+	}
+	s.dsnList = append(s.dsnList, dsn)
+	s.dsnToConn[dsn] = pinned
+	return pinned, nil
+}
+
+func sessionContext(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
+
+// Exec executes `Exec` on pinned connection of database.
+func (s *SessionConnection) Exec(ctx context.Context, conn Connection, query string, args ...interface{}) (sql.Result, error) {
+	ctx = sessionContext(ctx)
+	pinned, err := s.conn(ctx, conn)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	result, err := pinned.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return result, nil
+}
+
+// ExecReturningID executes INSERT query that has RETURNING clause of identity column on pinned connection of database.
+func (s *SessionConnection) ExecReturningID(ctx context.Context, conn Connection, query string, args ...interface{}) (sql.Result, error) {
+	ctx = sessionContext(ctx)
+	pinned, err := s.conn(ctx, conn)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return scanReturningID(pinned.QueryRowContext(ctx, query, args...))
+}
+
+// Query executes `Query` on pinned connection of database.
+func (s *SessionConnection) Query(ctx context.Context, conn Connection, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx = sessionContext(ctx)
+	pinned, err := s.conn(ctx, conn)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	rows, err := pinned.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return rows, nil
+}
+
+// QueryRow executes `QueryRow` on pinned connection of database.
+func (s *SessionConnection) QueryRow(ctx context.Context, conn Connection, query string, args ...interface{}) (*sql.Row, error) {
+	ctx = sessionContext(ctx)
+	pinned, err := s.conn(ctx, conn)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return pinned.QueryRowContext(ctx, query, args...), nil
+}
+
+// Prepare executes `Prepare` on pinned connection of database.
+func (s *SessionConnection) Prepare(ctx context.Context, conn Connection, query string) (*sql.Stmt, error) {
+	ctx = sessionContext(ctx)
+	pinned, err := s.conn(ctx, conn)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	stmt, err := pinned.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return stmt, nil
+}
+
+// PinnedDSNs returns DSN of pinned databases in order of first access.
+func (s *SessionConnection) PinnedDSNs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.dsnList...)
+}
+
+// Close returns all pinned connections to connection pool.
+func (s *SessionConnection) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	errs := &MultiError{}
+	for _, dsn := range s.dsnList {
+		errs.AddShardError("", dsn, s.dsnToConn[dsn].Close())
+	}
+	s.dsnToConn = nil
+	return errs.ErrorOrNil()
+}
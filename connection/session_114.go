@@ -0,0 +1,24 @@
+// +build go1.14
+
+package connection
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Raw calls f with driver connection of each pinned database in order of first access.
+func (s *SessionConnection) Raw(f func(dsn string, driverConn interface{}) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("connection is already closed")
+	}
+	for _, dsn := range s.dsnList {
+		if err := s.dsnToConn[dsn].Raw(func(driverConn interface{}) error {
+			return f(dsn, driverConn)
+		}); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
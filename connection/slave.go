@@ -0,0 +1,66 @@
+package connection
+
+import (
+	"database/sql"
+	"math/rand"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+	adap "go.knocknote.io/octillery/connection/adapter"
+)
+
+// ReadConn returns *sql.DB for read query to shard.
+// If slaves are opened by 'read_from_slave', returns one of them. Otherwise, returns connection to master.
+func (c *DBShardConnection) ReadConn() *sql.DB {
+	return chooseSlave(c.Connection, c.Slaves, c.slaveBalancing, &c.slaveCounter)
+}
+
+// ReadConn returns *sql.DB for read query to not sharded database.
+// If slaves are opened by 'read_from_slave', returns one of them. Otherwise, returns connection to master.
+func (c *DBConnection) ReadConn() *sql.DB {
+	balancing := ""
+	if c.Config != nil {
+		balancing = c.Config.SlaveBalancing
+	}
+	return chooseSlave(c.Connection, c.Slaves, balancing, &c.slaveCounter)
+}
+
+func chooseSlave(master *sql.DB, slaves []*sql.DB, balancing string, counter *uint32) *sql.DB {
+	switch len(slaves) {
+	case 0:
+		return master
+	case 1:
+		return slaves[0]
+	}
+	if balancing == config.SlaveBalancingRandom {
+		return slaves[rand.Intn(len(slaves))]
+	}
+	return slaves[(atomic.AddUint32(counter, 1)-1)%uint32(len(slaves))]
+}
+
+// openSlaveConnections opens connections to slaves of database if table enables 'read_from_slave'.
+func (cm *DBConnectionManager) openSlaveConnections(adapter adap.DBAdapter, table *config.TableConfig, cfg *config.DatabaseConfig) ([]*sql.DB, error) {
+	if !table.ReadFromSlave || len(cfg.Slaves) == 0 {
+		return nil, nil
+	}
+	slaveAdapter, ok := adapter.(adap.SlaveAdapter)
+	if !ok {
+		return nil, errors.Errorf("adapter %s doesn't support slave. disable read_from_slave", cfg.Adapter)
+	}
+	conns := make([]*sql.DB, 0, len(cfg.Slaves))
+	for _, slave := range cfg.Slaves {
+		conn, err := slaveAdapter.OpenSlaveConnection(cfg, slave, cm.queryString)
+		if err != nil {
+			closeSlaves(conns)
+			return nil, errors.Wrapf(err, "cannot open connection to slave %s", slave)
+		}
+		cm.setConnectionSettings(conn, table, cfg)
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+func closeSlaves(conns []*sql.DB) error {
+	return closeConns(conns)
+}
@@ -0,0 +1,152 @@
+package connection
+
+import (
+	"database/sql"
+	"fmt"
+
+	"go.knocknote.io/octillery/config"
+)
+
+const (
+	// PoolRoleMaster connection pool to master of database
+	PoolRoleMaster = "master"
+
+	// PoolRoleSlave connection pool to slave of database
+	PoolRoleSlave = "slave"
+
+	// PoolRoleSequencer connection pool to sequencer of table
+	PoolRoleSequencer = "sequencer"
+)
+
+// connectionPool opened connection pool with configuration of it
+type connectionPool struct {
+	db        *sql.DB
+	tableName string
+	// empty if database is not sharded
+	shardName string
+	role      string
+	dsn       string
+	table     *config.TableConfig
+	config    *config.DatabaseConfig
+}
+
+// PoolStats statistics of a connection pool
+type PoolStats struct {
+	// table name
+	Table string
+	// shard name. empty if table is not sharded
+	Shard string
+	// role of connection pool ( PoolRoleMaster, PoolRoleSlave or PoolRoleSequencer )
+	Role  string
+	Stats sql.DBStats
+}
+
+// eachDB iterates all opened connection pools ( includes sequencers and slaves ) with configuration of them
+func (cm *DBConnectionManager) eachDB(f func(pool *connectionPool)) {
+	cm.connMap.Each(func(tableName string, conn *DBConnection) bool {
+		table := conn.Config
+		if !conn.IsShard {
+			f(&connectionPool{db: conn.Connection, tableName: tableName, role: PoolRoleMaster, dsn: conn.DSN(), table: table, config: &table.DatabaseConfig})
+			for idx, slave := range conn.Slaves {
+				f(&connectionPool{db: slave, tableName: tableName, role: PoolRoleSlave, dsn: slaveDSN(&table.DatabaseConfig, idx), table: table, config: &table.DatabaseConfig})
+			}
+			return true
+		}
+		seqConfigs := sequencerConfigs(table.Sequencer)
+		for idx, seqConn := range conn.sequencerConns() {
+			if idx < len(seqConfigs) {
+				f(&connectionPool{db: seqConn, tableName: tableName, role: PoolRoleSequencer, dsn: masterDSN(seqConfigs[idx]), table: table, config: seqConfigs[idx]})
+			}
+		}
+		for _, shardConn := range conn.ShardConnections.AllShard() {
+			shardConfig := table.ShardConfigByName(shardConn.ShardName)
+			f(&connectionPool{
+				db:        shardConn.Connection,
+				tableName: tableName,
+				shardName: shardConn.ShardName,
+				role:      PoolRoleMaster,
+				dsn:       shardConn.DSN(),
+				table:     table,
+				config:    shardConfig,
+			})
+			for idx, slave := range shardConn.Slaves {
+				f(&connectionPool{
+					db:        slave,
+					tableName: tableName,
+					shardName: shardConn.ShardName,
+					role:      PoolRoleSlave,
+					dsn:       slaveDSN(shardConfig, idx),
+					table:     table,
+					config:    shardConfig,
+				})
+			}
+		}
+		return true
+	})
+}
+
+// masterDSN returns DSN of master of database like DBShardConnection.DSN
+func masterDSN(cfg *config.DatabaseConfig) string {
+	if cfg == nil {
+		return ""
+	}
+	if len(cfg.Masters) > 0 {
+		return fmt.Sprintf("%s/%s", cfg.Masters[0], cfg.NameOrPath)
+	}
+	return cfg.NameOrPath
+}
+
+// slaveDSN returns DSN of slave opened at idx of slaves of database
+func slaveDSN(cfg *config.DatabaseConfig, idx int) string {
+	if cfg == nil || idx >= len(cfg.Slaves) {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s", cfg.Slaves[idx], cfg.NameOrPath)
+}
+
+// applyConnectionSettings applies current settings to all opened connection pools
+func (cm *DBConnectionManager) applyConnectionSettings() {
+	cm.eachDB(func(pool *connectionPool) {
+		cm.setConnectionSettings(pool.db, pool.table, pool.config)
+	})
+}
+
+// Stats returns statistics of all opened connection pools ( includes sequencers and slaves ).
+// Numbers of connections and waits are summed up. MaxOpenConnections is 0 if any connection pool is unlimited.
+func (cm *DBConnectionManager) Stats() sql.DBStats {
+	total := sql.DBStats{}
+	unlimited := false
+	cm.eachDB(func(pool *connectionPool) {
+		stats := pool.db.Stats()
+		if stats.MaxOpenConnections <= 0 {
+			unlimited = true
+		}
+		total.MaxOpenConnections += stats.MaxOpenConnections
+		total.OpenConnections += stats.OpenConnections
+		total.InUse += stats.InUse
+		total.Idle += stats.Idle
+		total.WaitCount += stats.WaitCount
+		total.WaitDuration += stats.WaitDuration
+		total.MaxIdleClosed += stats.MaxIdleClosed
+		total.MaxLifetimeClosed += stats.MaxLifetimeClosed
+		addMaxIdleTimeClosed(&total, stats)
+	})
+	if unlimited {
+		total.MaxOpenConnections = 0
+	}
+	return total
+}
+
+// PoolStats returns statistics of each opened connection pool ( includes sequencers and slaves )
+func (cm *DBConnectionManager) PoolStats() []*PoolStats {
+	stats := []*PoolStats{}
+	cm.eachDB(func(pool *connectionPool) {
+		stats = append(stats, &PoolStats{
+			Table: pool.tableName,
+			Shard: pool.shardName,
+			Role:  pool.role,
+			Stats: pool.db.Stats(),
+		})
+	})
+	return stats
+}
@@ -0,0 +1,113 @@
+package connection
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	"github.com/pkg/errors"
+	adap "go.knocknote.io/octillery/connection/adapter"
+)
+
+// TemporaryTableConnection returns connection to database having temporary table created in transaction.
+// If temporary table of tableName is not created, returns false.
+func (c *TxConnection) TemporaryTableConnection(tableName string) (Connection, bool) {
+	conn, exists := c.temporaryTables[tableName]
+	return conn, exists
+}
+
+// HasTemporaryTables returns true if transaction has temporary tables
+func (c *TxConnection) HasTemporaryTables() bool {
+	return len(c.temporaryTables) > 0
+}
+
+// CreateTemporaryTable executes 'CREATE TEMPORARY TABLE' on database where transaction started.
+// Temporary table is visible only from session of transaction, so queries referencing it must be executed by ExecTemporaryTable
+// or Query/QueryRow with connection returned by TemporaryTableConnection.
+// It is dropped before transaction is committed or rolled back.
+func (c *TxConnection) CreateTemporaryTable(ctx context.Context, tableName string, query string, args ...interface{}) (sql.Result, error) {
+	if len(c.dsnList) == 0 {
+		return nil, errors.Errorf("cannot create temporary table %s. transaction doesn't access any database yet", tableName)
+	}
+	if _, supported := c.dropTemporaryTableQuery(tableName); !supported {
+		return nil, errors.Errorf("adapter doesn't support temporary table %s", tableName)
+	}
+	conn, exists := c.temporaryTables[tableName]
+	if !exists {
+		conn = c.dsnToConn[c.dsnList[0]]
+	}
+	result, err := c.execWithoutQueryLog(ctx, conn, query, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot create temporary table %s", tableName)
+	}
+	if c.temporaryTables == nil {
+		c.temporaryTables = map[string]Connection{}
+	}
+	c.temporaryTables[tableName] = conn
+	return result, nil
+}
+
+// DropTemporaryTable drops temporary table created in transaction by query of adapter.
+func (c *TxConnection) DropTemporaryTable(ctx context.Context, tableName string) (sql.Result, error) {
+	conn, exists := c.temporaryTables[tableName]
+	if !exists {
+		return nil, errors.Errorf("temporary table %s does not exist", tableName)
+	}
+	query, _ := c.dropTemporaryTableQuery(tableName)
+	result, err := c.execWithoutQueryLog(ctx, conn, query)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot drop temporary table %s", tableName)
+	}
+	delete(c.temporaryTables, tableName)
+	return result, nil
+}
+
+// ExecTemporaryTable executes INSERT/UPDATE/DELETE query for temporary table created in transaction.
+// Changes of temporary table are discarded at the end of transaction, so query is not added to WriteQueries.
+func (c *TxConnection) ExecTemporaryTable(ctx context.Context, tableName string, query string, args ...interface{}) (sql.Result, error) {
+	conn, exists := c.temporaryTables[tableName]
+	if !exists {
+		return nil, errors.Errorf("temporary table %s does not exist", tableName)
+	}
+	result, err := c.execWithoutQueryLog(ctx, conn, query, args...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return result, nil
+}
+
+func (c *TxConnection) execWithoutQueryLog(ctx context.Context, conn Connection, query string, args ...interface{}) (sql.Result, error) {
+	ctx = c.context(ctx)
+	if err := c.beginIfNotInitialized(ctx, conn); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	tx := c.dsnToTx[conn.DSN()]
+	if ctx == nil {
+		return tx.Exec(query, args...)
+	}
+	return tx.ExecContext(ctx, query, args...)
+}
+
+func (c *TxConnection) dropTemporaryTableQuery(tableName string) (string, bool) {
+	adapter, ok := c.adapter.(adap.TemporaryTableAdapter)
+	if !ok {
+		return "", false
+	}
+	return adapter.DropTemporaryTableQuery(tableName)
+}
+
+// dropTemporaryTables drops all temporary tables not to leave them in connections returned to pool
+func (c *TxConnection) dropTemporaryTables() *MultiError {
+	errs := &MultiError{}
+	tableNames := make([]string, 0, len(c.temporaryTables))
+	for tableName := range c.temporaryTables {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+	for _, tableName := range tableNames {
+		dsn := c.temporaryTables[tableName].DSN()
+		_, err := c.DropTemporaryTable(context.Background(), tableName)
+		errs.AddShardError("", dsn, err)
+	}
+	return errs
+}
@@ -0,0 +1,137 @@
+package connection
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// DuplicateUniqueValueError returned when INSERT has value of unique column that is already used in any shard
+type DuplicateUniqueValueError struct {
+	Table  string
+	Column string
+	Value  string
+}
+
+func (e *DuplicateUniqueValueError) Error() string {
+	return fmt.Sprintf("duplicate value '%s' of unique column %s.%s in all shards", e.Value, e.Table, e.Column)
+}
+
+// IsDuplicateUniqueValue returns whether err is caused by DuplicateUniqueValueError
+func IsDuplicateUniqueValue(err error) bool {
+	_, ok := errors.Cause(err).(*DuplicateUniqueValueError)
+	return ok
+}
+
+// uniqueTableName returns name of table in sequencer's database that reserves values of unique column
+func uniqueTableName(tableName string, column string) string {
+	return fmt.Sprintf("%s_%s_uniques", tableName, column)
+}
+
+func createUniqueTablesIfNotExists(conn *sql.DB, tableName string, columns []string) error {
+	for _, column := range columns {
+		query := fmt.Sprintf("create table if not exists `%s` (value varchar(255) not null primary key)", uniqueTableName(tableName, column))
+		if _, err := conn.Exec(query); err != nil {
+			return errors.Wrapf(err, "cannot create table for unique column %s.%s", tableName, column)
+		}
+	}
+	return nil
+}
+
+// sequencerConnection implements Connection interface for sequencer's database
+type sequencerConnection struct {
+	conn *DBConnection
+}
+
+func (c *sequencerConnection) DSN() string {
+	cfg := c.conn.Config.Sequencer
+	if len(cfg.Masters) > 0 {
+		return fmt.Sprintf("%s/%s", cfg.Masters[0], cfg.NameOrPath)
+	}
+	return cfg.NameOrPath
+}
+
+func (c *sequencerConnection) Conn() *sql.DB {
+	return c.conn.Sequencer
+}
+
+func (c *sequencerConnection) ReadConn() *sql.DB {
+	return c.conn.Sequencer
+}
+
+func (c *DBConnection) execOnSequencer(ctx context.Context, tx *TxConnection, query string, args ...interface{}) error {
+	if c.Sequencer == nil {
+		return errors.New("sequencer's connection is nil")
+	}
+	if tx != nil {
+		_, err := tx.Exec(ctx, &sequencerConnection{conn: c}, query, args...)
+		return errors.WithStack(err)
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, err := c.Sequencer.ExecContext(ctx, query, args...)
+	return errors.WithStack(err)
+}
+
+// ReserveUniqueValues reserves values of unique columns ( map of column name and value ) in sequencer's database.
+// If tx is not nil, values are reserved in the transaction, so they are released by rollback.
+//
+// If any value is already reserved, returns DuplicateUniqueValueError.
+// Returned function releases reserved values. It must be called if inserting row is failed.
+func (c *DBConnection) ReserveUniqueValues(ctx context.Context, tx *TxConnection, tableName string, values map[string]string) (func() error, error) {
+	reserved := map[string]string{}
+	release := func() error {
+		errs := &MultiError{}
+		for column, value := range reserved {
+			errs.Add(c.ReleaseUniqueValue(ctx, tx, tableName, column, value))
+		}
+		return errs.ErrorOrNil()
+	}
+	for column, value := range values {
+		query := fmt.Sprintf("insert into `%s`(value) values (?)", uniqueTableName(tableName, column))
+		if err := c.execOnSequencer(ctx, tx, query, value); err != nil {
+			if releaseErr := release(); releaseErr != nil {
+				return nil, errors.Wrapf(releaseErr, "cannot release reserved values after %s", err)
+			}
+			if c.isReservedUniqueValue(ctx, tx, tableName, column, value) {
+				return nil, errors.WithStack(&DuplicateUniqueValueError{Table: tableName, Column: column, Value: value})
+			}
+			return nil, errors.Wrapf(err, "cannot reserve value of unique column %s.%s", tableName, column)
+		}
+		reserved[column] = value
+	}
+	return release, nil
+}
+
+// isReservedUniqueValue returns whether value is already reserved
+func (c *DBConnection) isReservedUniqueValue(ctx context.Context, tx *TxConnection, tableName string, column string, value string) bool {
+	query := fmt.Sprintf("select count(*) from `%s` where value = ?", uniqueTableName(tableName, column))
+	var row *sql.Row
+	if tx != nil {
+		r, err := tx.QueryRow(ctx, &sequencerConnection{conn: c}, query, value)
+		if err != nil {
+			return false
+		}
+		row = r
+	} else {
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		row = c.Sequencer.QueryRowContext(ctx, query, value)
+	}
+	var count int64
+	if err := row.Scan(&count); err != nil {
+		return false
+	}
+	return count > 0
+}
+
+// ReleaseUniqueValue releases reserved value of unique column, so it can be used by the other row.
+// It is called by UPDATE and DELETE queries of table, so application doesn't need to call this for rows changed by them.
+func (c *DBConnection) ReleaseUniqueValue(ctx context.Context, tx *TxConnection, tableName string, column string, value string) error {
+	query := fmt.Sprintf("delete from `%s` where value = ?", uniqueTableName(tableName, column))
+	return errors.Wrapf(c.execOnSequencer(ctx, tx, query, value), "cannot release value of unique column %s.%s", tableName, column)
+}
@@ -0,0 +1,253 @@
+package sql
+
+import (
+	"context"
+	core "database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/connection"
+	"go.knocknote.io/octillery/debug"
+	"go.knocknote.io/octillery/exec"
+	"go.knocknote.io/octillery/logging"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+// Conn the compatible structure of Conn in 'database/sql' package.
+//
+// Conn pins a connection of each database ( shard ) that queries are routed to,
+// so session state is kept between queries routed to the same shard.
+// Connections are pinned at first query to each database, and returned to pool by Close.
+type Conn struct {
+	db      *DB
+	session *connection.SessionConnection
+}
+
+// Conn the compatible method of Conn in 'database/sql' package.
+func (db *DB) Conn(ctx context.Context) (*Conn, error) {
+	debug.Printf("DB.Conn()")
+	if db.connMgr == nil {
+		return nil, errors.New("cannot get connection manager from sql.(*DB)")
+	}
+	if ctx != nil && ctx.Err() != nil {
+		return nil, errors.WithStack(ctx.Err())
+	}
+	return &Conn{db: db, session: connection.NewSessionConnection()}, nil
+}
+
+// PingContext the compatible method of PingContext in 'database/sql' package.
+// It pings all databases like PingContext of DB.
+func (c *Conn) PingContext(ctx context.Context) error {
+	return errors.WithStack(c.db.connMgr.Ping(ctx))
+}
+
+// ExecContext the compatible method of ExecContext in 'database/sql' package.
+func (c *Conn) ExecContext(ctx context.Context, query string, args ...interface{}) (Result, error) {
+	debug.Printf("Conn.ExecContext: %s", query)
+	start := time.Now()
+	result, err := c.execProxy(ctx, query, args...)
+	logging.LogQuery(start, query, args, err)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return result, nil
+}
+
+// QueryContext the compatible method of QueryContext in 'database/sql' package.
+func (c *Conn) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	debug.Printf("Conn.QueryContext: %s", query)
+	start := time.Now()
+	rows, err := c.queryProxy(ctx, query, args...)
+	logging.LogQuery(start, query, args, err)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return rows, nil
+}
+
+// QueryRowContext the compatible method of QueryRowContext in 'database/sql' package.
+func (c *Conn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	debug.Printf("Conn.QueryRowContext: %s", query)
+	start := time.Now()
+	row := c.queryRowProxy(ctx, query, args...)
+	logging.LogQuery(start, query, args, row.err)
+	return row
+}
+
+// PrepareContext the compatible method of PrepareContext in 'database/sql' package.
+func (c *Conn) PrepareContext(ctx context.Context, query string) (*Stmt, error) {
+	debug.Printf("Conn.PrepareContext: %s", query)
+	stmt, err := c.prepareProxy(ctx, query)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return stmt, nil
+}
+
+// Close the compatible method of Close in 'database/sql' package.
+// It returns all pinned connections to connection pool.
+func (c *Conn) Close() error {
+	debug.Printf("Conn.Close()")
+	return errors.WithStack(c.session.Close())
+}
+
+func (c *Conn) execProxy(ctx context.Context, queryText string, args ...interface{}) (Result, error) {
+	queryText, args, err := bindNamedArgs(queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	statements, err := splitMultiStatements(ctx, c.db.connMgr, queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if statements != nil {
+		result, err := execMultiStatements(ctx, statements, c.execProxy)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return result, nil
+	}
+	if query, ok := sqlparser.ParseSavepoint(queryText); ok {
+		return nil, errors.Errorf("%s must be executed in transaction", query.QueryType())
+	}
+	if query, ok := sqlparser.ParseTemporaryTable(queryText); ok {
+		return nil, errors.Errorf("%s must be executed in transaction", query.QueryType())
+	}
+	conn, query, err := c.db.connectionAndQuery(queryText, args...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	query, args, err = encryptQuery(query, queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := assertForeignKeys(ctx, query, c.queryRowProxy); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if conn.IsShard {
+		result, err := exec.NewSessionQueryExecutor(ctx, conn, c.session, query).Exec()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return result, nil
+	}
+	if isRequiredReturningID(conn, query) {
+		returningQueryText := conn.QueryWithReturningID(queryText)
+		done := conn.StartQuery(ctx, query.Table(), conn, returningQueryText, args)
+		result, err := c.session.ExecReturningID(ctx, conn, returningQueryText, args...)
+		done(result, err)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return result, nil
+	}
+	done := conn.StartQuery(ctx, query.Table(), conn, queryText, args)
+	result, err := c.session.Exec(ctx, conn, queryText, args...)
+	done(result, err)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return result, nil
+}
+
+func (c *Conn) prepareProxy(ctx context.Context, queryText string) (*Stmt, error) {
+	if isMultiStatement(queryText) {
+		return nil, errors.New("Prepare doesn't support multi statement query")
+	}
+	conn, query, err := connectionAndPreparedQuery(c.db.connMgr, queryText)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := assertPreparable(query); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if conn.IsShard {
+		// statement is prepared lazily on the shard decided by query arguments
+		stmt := exec.NewShardStmt(conn, nil, queryText).WithSession(c.session)
+		return &Stmt{shard: stmt, query: queryText, queryRow: c.queryRowProxy}, nil
+	}
+	stmt, err := c.session.Prepare(ctx, conn, queryText)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &Stmt{core: stmt, query: queryText, queryRow: c.queryRowProxy}, nil
+}
+
+func (c *Conn) queryProxy(ctx context.Context, queryText string, args ...interface{}) (*Rows, error) {
+	queryText, args, err := bindNamedArgs(queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	statements, err := splitMultiStatements(ctx, c.db.connMgr, queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if statements != nil {
+		rows, err := queryMultiStatements(ctx, statements, c.queryProxy)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return rows, nil
+	}
+	conn, query, err := c.db.connectionAndQuery(queryText, args...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	query, args, err = encryptQuery(query, queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if conn.IsShard {
+		rows, err := exec.NewSessionQueryExecutor(ctx, conn, c.session, query).Query()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return newQueryRows(ctx, rows, query)
+	}
+	done := conn.StartQuery(ctx, query.Table(), conn, queryText, args)
+	rows, err := c.session.Query(ctx, conn, queryText, args...)
+	done(nil, err)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return newQueryRows(ctx, []*core.Rows{rows}, query)
+}
+
+func (c *Conn) queryRowProxy(ctx context.Context, queryText string, args ...interface{}) *Row {
+	if isMultiStatement(queryText) {
+		return &Row{err: errors.New("QueryRow doesn't support multi statement query")}
+	}
+	queryText, args, err := bindNamedArgs(queryText, args)
+	if err != nil {
+		return &Row{err: err}
+	}
+	conn, query, err := c.db.connectionAndQuery(queryText, args...)
+	if err != nil {
+		return &Row{err: err}
+	}
+	query, args, err = encryptQuery(query, queryText, args)
+	if err != nil {
+		return &Row{err: err}
+	}
+	if encrypted, err := isEncryptedSelect(query); err != nil {
+		return &Row{err: err}
+	} else if encrypted {
+		// columns are required for decryption, so row is read by Rows
+		rows, err := c.queryProxy(ctx, queryText, args...)
+		return &Row{rows: rows, err: err}
+	}
+	if conn.IsShard {
+		row, err := exec.NewSessionQueryExecutor(ctx, conn, c.session, query).QueryRow()
+		if err != nil {
+			return &Row{err: err}
+		}
+		return &Row{core: row}
+	}
+	done := conn.StartQuery(ctx, query.Table(), conn, queryText, args)
+	row, err := c.session.QueryRow(ctx, conn, queryText, args...)
+	done(nil, err)
+	if err != nil {
+		return &Row{err: err}
+	}
+	return &Row{core: row}
+}
@@ -0,0 +1,17 @@
+// +build go1.14
+
+package sql
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Raw the compatible method of Raw in 'database/sql' package.
+//
+// f is called with driver connection of each pinned database in order of first access,
+// so f is not called if no query is executed by Conn yet.
+func (c *Conn) Raw(f func(driverConn interface{}) error) error {
+	return errors.WithStack(c.session.Raw(func(dsn string, driverConn interface{}) error {
+		return f(driverConn)
+	}))
+}
@@ -0,0 +1,41 @@
+package sql
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/connection"
+)
+
+// ConsistencyToken returns token encoding current replication positions of all shards of tables.
+// Call this after writes out of transaction, and pass the token to WaitForToken before reading from slaves.
+func (db *DB) ConsistencyToken(ctx context.Context, tableNames ...string) (string, error) {
+	token, err := db.connMgr.ConsistencyToken(ctx, tableNames...)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return token.String(), nil
+}
+
+// WaitForToken blocks until slaves apply writes encoded in token returned by ConsistencyToken, or context is done.
+// Empty token returns immediately.
+func (db *DB) WaitForToken(ctx context.Context, token string) error {
+	parsed, err := connection.ParseConsistencyToken(token)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := db.connMgr.WaitForConsistencyToken(ctx, parsed); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// ConsistencyToken returns token encoding replication positions of databases written by transaction.
+// It must be called after Commit.
+func (proxy *Tx) ConsistencyToken(ctx context.Context) (string, error) {
+	token, err := proxy.tx.ConsistencyToken(ctx)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return token.String(), nil
+}
@@ -10,6 +10,7 @@ import (
 	"go.knocknote.io/octillery/connection"
 	"go.knocknote.io/octillery/debug"
 	"go.knocknote.io/octillery/exec"
+	"go.knocknote.io/octillery/logging"
 	"go.knocknote.io/octillery/sqlparser"
 )
 
@@ -36,17 +37,16 @@ func (db *DB) ConnectionManager() *connection.DBConnectionManager {
 }
 
 // PingContext the compatible method of PingContext in 'database/sql' package.
-// Currently, PingContext is ignored.
+// It pings all shards, slaves, sequencers and not sharded databases concurrently, and returns errors of unhealthy ones.
+// Use HealthCheck of ConnectionManager() to get status of each database.
 func (db *DB) PingContext(ctx context.Context) error {
-	// ignore pingContext
-	return nil
+	return errors.WithStack(db.connMgr.Ping(ctx))
 }
 
 // Ping the compatible method of Ping in 'database/sql' package.
-// Currently, Ping is ignored.
+// It pings all databases like PingContext.
 func (db *DB) Ping() error {
-	// ignore Ping
-	return nil
+	return errors.WithStack(db.connMgr.Ping(context.Background()))
 }
 
 // Close the compatible method of Close in 'database/sql' package.
@@ -72,9 +72,35 @@ func (db *DB) SetConnMaxLifetime(d time.Duration) {
 	db.connMgr.SetConnMaxLifetime(d)
 }
 
+// SetConnMaxIdleTime the compatible method of SetConnMaxIdleTime in 'database/sql' package,
+// call SetConnMaxIdleTime for all opened connections.
+func (db *DB) SetConnMaxIdleTime(d time.Duration) {
+	db.connMgr.SetConnMaxIdleTime(d)
+}
+
+// SetConnMaxLifetimeJitter adds random duration up to d to max lifetime of each connection pool ( a shard or a slave ),
+// so recycling connections across many shards is not synchronized and doesn't cause periodic latency spikes.
+func (db *DB) SetConnMaxLifetimeJitter(d time.Duration) {
+	db.connMgr.SetConnMaxLifetimeJitter(d)
+}
+
+// SetQueryHook set function for it is called before and after every query of this DB is executed on each database ( shard ).
+// It is called in addition to hook set by global SetQueryHook. If hook is nil, removes current hook.
+func (db *DB) SetQueryHook(hook func(context.Context, QueryHookInfo)) {
+	db.connMgr.SetQueryHook(hook)
+}
+
+// SetRoutingInterceptor set interceptor deciding shards of queries of this DB ( e.g. canary shards, pinning a customer to a shard ).
+// It is called with shards chosen by sharding algorithm for every SELECT/INSERT/UPDATE/DELETE of sharded tables.
+// If interceptor is nil, removes current interceptor.
+func (db *DB) SetRoutingInterceptor(interceptor RoutingInterceptor) {
+	db.connMgr.SetRoutingInterceptor(interceptor)
+}
+
 // Stats the compatible method of Stats in 'database/sql' package.
+// It returns statistics summed up for all opened connection pools.
 func (db *DB) Stats() DBStats {
-	return DBStats{}
+	return newDBStats(db.connMgr.Stats())
 }
 
 // PrepareContext the compatible method of PrepareContext in 'database/sql' package.
@@ -84,7 +110,7 @@ func (db *DB) PrepareContext(ctx context.Context, query string) (*Stmt, error) {
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	return &Stmt{core: stmt, query: query}, nil
+	return stmt, nil
 }
 
 // Prepare the compatible method of Prepare in 'database/sql' package.
@@ -94,13 +120,15 @@ func (db *DB) Prepare(query string) (*Stmt, error) {
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	return &Stmt{core: stmt, query: query}, nil
+	return stmt, nil
 }
 
 // ExecContext the compatible method of ExecContext in 'database/sql' package.
 func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (Result, error) {
 	debug.Printf("DB.ExecContext: %s", query)
+	start := time.Now()
 	result, err := db.execProxy(ctx, query, args...)
+	logging.LogQuery(start, query, args, err)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -110,7 +138,9 @@ func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}
 // Exec the compatible method of Exec in 'database/sql' package.
 func (db *DB) Exec(query string, args ...interface{}) (Result, error) {
 	debug.Printf("DB.Exec: %s", query)
+	start := time.Now()
 	result, err := db.execProxy(nil, query, args...)
+	logging.LogQuery(start, query, args, err)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -120,7 +150,9 @@ func (db *DB) Exec(query string, args ...interface{}) (Result, error) {
 // QueryContext the compatible method of QueryContext in 'database/sql' package.
 func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
 	debug.Printf("DB.QueryContext: %s", query)
+	start := time.Now()
 	rows, err := db.queryProxy(ctx, query, args...)
+	logging.LogQuery(start, query, args, err)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -130,7 +162,9 @@ func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{
 // Query the compatible method of Query in 'database/sql' package.
 func (db *DB) Query(query string, args ...interface{}) (*Rows, error) {
 	debug.Printf("DB.Query: %s", query)
+	start := time.Now()
 	rows, err := db.queryProxy(nil, query, args...)
+	logging.LogQuery(start, query, args, err)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -140,13 +174,19 @@ func (db *DB) Query(query string, args ...interface{}) (*Rows, error) {
 // QueryRowContext the compatible method of QueryRowContext in 'database/sql' package.
 func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
 	debug.Printf("DB.QueryRowContext: %s", query)
-	return db.queryRowProxy(ctx, query, args...)
+	start := time.Now()
+	row := db.queryRowProxy(ctx, query, args...)
+	logging.LogQuery(start, query, args, row.err)
+	return row
 }
 
 // QueryRow the compatible method of QueryRow in 'database/sql' package.
 func (db *DB) QueryRow(query string, args ...interface{}) *Row {
 	debug.Printf("DB.QueryRow: %s", query)
-	return db.queryRowProxy(nil, query, args...)
+	start := time.Now()
+	row := db.queryRowProxy(nil, query, args...)
+	logging.LogQuery(start, query, args, row.err)
+	return row
 }
 
 // BeginTx the compatible method of BeginTx in 'database/sql' package.
@@ -203,14 +243,65 @@ func (db *DB) connectionAndQuery(queryText string, args ...interface{}) (*connec
 	if err != nil {
 		return nil, nil, errors.WithStack(err)
 	}
+	if err := exec.ValidatePermission(conn, query); err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return conn, query, nil
+}
+
+// connectionAndPreparedQuery returns connection and query of statement prepared before arguments are bound
+func connectionAndPreparedQuery(connMgr *connection.DBConnectionManager, queryText string) (*connection.DBConnection, sqlparser.Query, error) {
+	parser, err := sqlparser.New()
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	query, err := parser.ParsePrepared(queryText)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	conn, err := connMgr.ConnectionByTableName(query.Table())
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	if err := exec.ValidatePermission(conn, query); err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
 	return conn, query, nil
 }
 
 func (db *DB) execProxy(ctx context.Context, queryText string, args ...interface{}) (Result, error) {
+	queryText, args, err := bindNamedArgs(queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	statements, err := splitMultiStatements(ctx, db.connMgr, queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if statements != nil {
+		result, err := execMultiStatements(ctx, statements, db.execProxy)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return result, nil
+	}
+	if query, ok := sqlparser.ParseSavepoint(queryText); ok {
+		return nil, errors.Errorf("%s must be executed in transaction", query.QueryType())
+	}
+	if query, ok := sqlparser.ParseTemporaryTable(queryText); ok {
+		return nil, errors.Errorf("%s must be executed in transaction", query.QueryType())
+	}
 	conn, query, err := db.connectionAndQuery(queryText, args...)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	query, args, err = encryptQuery(query, queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := assertForeignKeys(ctx, query, db.queryRowProxy); err != nil {
+		return nil, errors.WithStack(err)
+	}
 	if conn.IsShard {
 		result, err := exec.NewQueryExecutor(ctx, conn, nil, query).Exec()
 		if err != nil {
@@ -218,56 +309,127 @@ func (db *DB) execProxy(ctx context.Context, queryText string, args ...interface
 		}
 		return result, nil
 	}
+	if isRequiredReturningID(conn, query) {
+		returningQueryText := conn.QueryWithReturningID(queryText)
+		done := conn.StartQuery(ctx, query.Table(), conn, returningQueryText, args)
+		result, err := connection.ExecReturningID(ctx, conn, returningQueryText, args...)
+		done(result, err)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return result, nil
+	}
+	done := conn.StartQuery(ctx, query.Table(), conn, queryText, args)
 	result, err := conn.Exec(ctx, queryText, args...)
+	done(result, err)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return result, nil
 }
 
-func (db *DB) prepareProxy(ctx context.Context, queryText string) (*core.Stmt, error) {
-	conn, query, err := db.connectionAndQuery(queryText)
+// isRequiredReturningID returns whether INSERT query for not sharded table needs RETURNING clause for LastInsertId()
+func isRequiredReturningID(conn *connection.DBConnection, query sqlparser.Query) bool {
+	if query.QueryType() != sqlparser.Insert || !conn.IsRequiredReturningID() {
+		return false
+	}
+	insertQuery, ok := query.(*sqlparser.InsertQuery)
+	return ok && !insertQuery.IsReturning()
+}
+
+func (db *DB) prepareProxy(ctx context.Context, queryText string) (*Stmt, error) {
+	if isMultiStatement(queryText) {
+		return nil, errors.New("Prepare doesn't support multi statement query")
+	}
+	conn, query, err := connectionAndPreparedQuery(db.connMgr, queryText)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	if err := assertPreparable(query); err != nil {
+		return nil, errors.WithStack(err)
+	}
 	if conn.IsShard {
-		stmt, err := exec.NewQueryExecutor(ctx, conn, nil, query).Prepare()
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
-		return stmt, nil
+		// statement is prepared lazily on the shard decided by query arguments
+		return &Stmt{shard: exec.NewShardStmt(conn, nil, queryText), query: queryText, queryRow: db.queryRowProxy}, nil
 	}
 	stmt, err := conn.Prepare(ctx, queryText)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	return stmt, nil
+	return &Stmt{core: stmt, query: queryText, queryRow: db.queryRowProxy}, nil
 }
 
 func (db *DB) queryProxy(ctx context.Context, queryText string, args ...interface{}) (*Rows, error) {
+	queryText, args, err := bindNamedArgs(queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	statements, err := splitMultiStatements(ctx, db.connMgr, queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if statements != nil {
+		rows, err := queryMultiStatements(ctx, statements, db.queryProxy)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return rows, nil
+	}
 	conn, query, err := db.connectionAndQuery(queryText, args...)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	query, args, err = encryptQuery(query, queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 	if conn.IsShard {
 		rows, err := exec.NewQueryExecutor(ctx, conn, nil, query).Query()
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
-		return &Rows{cores: rows}, nil
+		return newQueryRows(ctx, rows, query)
+	}
+	done := conn.StartQuery(ctx, query.Table(), conn, queryText, args)
+	if sqlparser.IsSlaveReadable(query) {
+		rows, err := conn.ReadQuery(ctx, queryText, args...)
+		done(nil, err)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return newQueryRows(ctx, []*core.Rows{rows}, query)
 	}
 	rows, err := conn.Query(ctx, queryText, args...)
+	done(nil, err)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	return &Rows{cores: []*core.Rows{rows}}, nil
+	return newQueryRows(ctx, []*core.Rows{rows}, query)
 }
 
 func (db *DB) queryRowProxy(ctx context.Context, queryText string, args ...interface{}) *Row {
+	if isMultiStatement(queryText) {
+		return &Row{err: errors.New("QueryRow doesn't support multi statement query")}
+	}
+	queryText, args, err := bindNamedArgs(queryText, args)
+	if err != nil {
+		return &Row{err: err}
+	}
 	conn, query, err := db.connectionAndQuery(queryText, args...)
 	if err != nil {
 		return &Row{err: err}
 	}
+	query, args, err = encryptQuery(query, queryText, args)
+	if err != nil {
+		return &Row{err: err}
+	}
+	if encrypted, err := isEncryptedSelect(query); err != nil {
+		return &Row{err: err}
+	} else if encrypted {
+		// columns are required for decryption, so row is read by Rows
+		rows, err := db.queryProxy(ctx, queryText, args...)
+		return &Row{rows: rows, err: err}
+	}
 	if conn.IsShard {
 		row, err := exec.NewQueryExecutor(ctx, conn, nil, query).QueryRow()
 		if err != nil {
@@ -275,5 +437,10 @@ func (db *DB) queryRowProxy(ctx context.Context, queryText string, args ...inter
 		}
 		return &Row{core: row}
 	}
+	done := conn.StartQuery(ctx, query.Table(), conn, queryText, args)
+	defer done(nil, nil)
+	if sqlparser.IsSlaveReadable(query) {
+		return &Row{core: conn.ReadQueryRow(ctx, queryText, args...)}
+	}
 	return &Row{core: conn.QueryRow(ctx, queryText, args...)}
 }
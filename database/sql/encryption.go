@@ -0,0 +1,197 @@
+package sql
+
+import (
+	"context"
+	core "database/sql"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/encryption"
+	"go.knocknote.io/octillery/exec"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+// columnDecrypter decrypts values of encrypted columns when rows are scanned.
+// Columns of rows are matched with encrypted columns by name, so aliased column is not decrypted.
+type columnDecrypter struct {
+	encryptors map[string]encryption.Encryptor
+}
+
+// newColumnDecrypter returns nil if query doesn't select table that has encrypted columns
+func newColumnDecrypter(query sqlparser.Query) (*columnDecrypter, error) {
+	if query.QueryType() != sqlparser.Select {
+		return nil, nil
+	}
+	encryptors, err := exec.ColumnEncryptors(query.Table())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if encryptors == nil {
+		return nil, nil
+	}
+	return &columnDecrypter{encryptors: encryptors}, nil
+}
+
+// newQueryRows creates Rows of cores selected by query. Values of encrypted columns are decrypted by Scan.
+func newQueryRows(ctx context.Context, cores []*core.Rows, query sqlparser.Query) (*Rows, error) {
+	rows := newRows(ctx, cores)
+	decrypter, err := newColumnDecrypter(query)
+	if err != nil {
+		rows.Close()
+		return nil, errors.WithStack(err)
+	}
+	if decrypter != nil {
+		rows.decrypters = make([]*columnDecrypter, len(cores))
+		for idx := range cores {
+			rows.decrypters[idx] = decrypter
+		}
+	}
+	return rows, nil
+}
+
+// isEncryptedSelect returns whether query selects table that has encrypted columns
+func isEncryptedSelect(query sqlparser.Query) (bool, error) {
+	decrypter, err := newColumnDecrypter(query)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return decrypter != nil, nil
+}
+
+// encryptQuery encrypts arguments for encrypted columns and parses query again by them.
+// If query doesn't write encrypted columns, returns query and args as they are.
+func encryptQuery(query sqlparser.Query, queryText string, args []interface{}) (sqlparser.Query, []interface{}, error) {
+	encryptedArgs, err := exec.EncryptArgs(query)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	if encryptedArgs == nil {
+		return query, args, nil
+	}
+	parser, err := sqlparser.New()
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	encryptedQuery, err := parser.Parse(queryText, encryptedArgs...)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return encryptedQuery, encryptedArgs, nil
+}
+
+// assertPreparable returns error if table of query has encrypted columns, because arguments of prepared statement are not encrypted
+func assertPreparable(query sqlparser.Query) error {
+	encryptors, err := exec.ColumnEncryptors(query.Table())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if encryptors != nil {
+		return errors.Errorf("Prepare doesn't support %s. it has encrypted columns", query.Table())
+	}
+	return nil
+}
+
+// scan scans values of encrypted columns as binary by scanner, and sets decrypted values to dest
+func (d *columnDecrypter) scan(columns []string, scanner func(dest ...interface{}) error, dest []interface{}) error {
+	scanDest := append([]interface{}{}, dest...)
+	encrypted := map[int]*[]byte{}
+	for idx, column := range columns {
+		if idx >= len(dest) {
+			break
+		}
+		if _, exists := d.encryptors[strings.ToLower(column)]; exists {
+			value := []byte{}
+			encrypted[idx] = &value
+			scanDest[idx] = &value
+		}
+	}
+	if err := scanner(scanDest...); err != nil {
+		return errors.WithStack(err)
+	}
+	for idx, value := range encrypted {
+		column := columns[idx]
+		if *value == nil {
+			if err := assignDecryptedValue(dest[idx], nil); err != nil {
+				return errors.Wrapf(err, "cannot scan %s", column)
+			}
+			continue
+		}
+		plaintext, err := exec.DecryptValue(d.encryptors[strings.ToLower(column)], *value)
+		if err != nil {
+			return errors.Wrapf(err, "cannot decrypt %s", column)
+		}
+		if err := assignDecryptedValue(dest[idx], plaintext); err != nil {
+			return errors.Wrapf(err, "cannot scan %s", column)
+		}
+	}
+	return nil
+}
+
+// assignDecryptedValue sets decrypted value to dest. plaintext is nil if value is NULL.
+func assignDecryptedValue(dest interface{}, plaintext []byte) error {
+	switch d := dest.(type) {
+	case Scanner:
+		if plaintext == nil {
+			return errors.WithStack(d.Scan(nil))
+		}
+		return errors.WithStack(d.Scan(plaintext))
+	case *[]byte:
+		*d = plaintext
+		return nil
+	case *RawBytes:
+		*d = plaintext
+		return nil
+	case *core.RawBytes:
+		*d = plaintext
+		return nil
+	case *interface{}:
+		if plaintext == nil {
+			*d = nil
+		} else {
+			*d = plaintext
+		}
+		return nil
+	}
+	value := reflect.ValueOf(dest)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return errors.Errorf("destination must be non-nil pointer. but got %T", dest)
+	}
+	if plaintext == nil {
+		return errors.Errorf("converting NULL to %T is unsupported", dest)
+	}
+	elem := value.Elem()
+	text := string(plaintext)
+	switch elem.Kind() {
+	case reflect.String:
+		elem.SetString(text)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err := strconv.ParseInt(text, 10, elem.Type().Bits())
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		elem.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := strconv.ParseUint(text, 10, elem.Type().Bits())
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		elem.SetUint(v)
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(text, elem.Type().Bits())
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		elem.SetFloat(v)
+	case reflect.Bool:
+		v, err := strconv.ParseBool(text)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		elem.SetBool(v)
+	default:
+		return errors.Errorf("unsupported destination %T for encrypted column", dest)
+	}
+	return nil
+}
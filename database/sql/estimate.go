@@ -0,0 +1,78 @@
+package sql
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/connection"
+	adap "go.knocknote.io/octillery/connection/adapter"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+// EstimateCountContext returns number of rows returned by SELECT query estimated by query planner of shards ( e.g. EXPLAIN of MySQL ).
+// Query is not executed, so it is cheap even if query is scattered to all shards like COUNT(*) without shard_key.
+// It is useful for showing 'about N results' on UI, but the result may be far from actual number of rows.
+// Adapter of table must implement adapter.RowEstimateAdapter.
+func (db *DB) EstimateCountContext(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	queryText, args, err := bindNamedArgs(query, args)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	conn, parsedQuery, err := db.connectionAndQuery(queryText, args...)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if parsedQuery.QueryType() != sqlparser.Select {
+		return 0, errors.Errorf("cannot estimate count of %s query", parsedQuery.QueryType())
+	}
+	parsedQuery, args, err = encryptQuery(parsedQuery, queryText, args)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	estimator, ok := conn.Adapter.(adap.RowEstimateAdapter)
+	if !ok {
+		return 0, errors.Errorf("adapter of %s doesn't support estimate of rows", parsedQuery.Table())
+	}
+	var (
+		mu    sync.Mutex
+		count int64
+	)
+	estimate := func(shard *connection.DBShardConnection) error {
+		rows, ok, err := estimator.EstimateRows(ctx, shard.Connection, queryText, args...)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if !ok {
+			return errors.Errorf("adapter of %s doesn't support estimate of rows", parsedQuery.Table())
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		count += rows
+		return nil
+	}
+	queryBase, ok := parsedQuery.(*sqlparser.QueryBase)
+	if ok && conn.IsShard {
+		queryText, args = queryBase.Text, queryBase.Args
+		if !queryBase.IsNotFoundShardKeyID() {
+			shard, err := conn.ShardConnectionByID(int64(queryBase.ShardKeyID))
+			if err != nil {
+				return 0, errors.WithStack(err)
+			}
+			if err := estimate(shard); err != nil {
+				return 0, errors.WithStack(err)
+			}
+			return count, nil
+		}
+	}
+	err = conn.ForEachShard(estimate, &connection.ForEachShardOptions{Concurrency: len(conn.Shards()), Context: ctx})
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return count, nil
+}
+
+// EstimateCount returns number of rows returned by SELECT query estimated by query planner of shards. See EstimateCountContext.
+func (db *DB) EstimateCount(query string, args ...interface{}) (int64, error) {
+	return db.EstimateCountContext(context.Background(), query, args...)
+}
@@ -0,0 +1,178 @@
+package sql
+
+import (
+	"context"
+	"sync"
+
+	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/debug"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+var (
+	foreignKeysMu        sync.RWMutex
+	globalForeignKeysMap = map[string][]*sqlparser.ForeignKey{}
+)
+
+// ErrForeignKeyConstraint returned when referenced row by foreign key is not found at INSERT
+var ErrForeignKeyConstraint = errors.New("cannot find row referenced by foreign key")
+
+// SetForeignKeys enables assertion of foreign keys at INSERT.
+// Before executing INSERT query, octillery selects referenced row by routed query and
+// returns ErrForeignKeyConstraint if it is not found.
+// This is intended to use for foreign keys that cannot be enforced by database because of sharding.
+func SetForeignKeys(foreignKeys []*sqlparser.ForeignKey) {
+	foreignKeysMap := map[string][]*sqlparser.ForeignKey{}
+	for _, fk := range foreignKeys {
+		foreignKeysMap[fk.Table] = append(foreignKeysMap[fk.Table], fk)
+	}
+	foreignKeysMu.Lock()
+	defer foreignKeysMu.Unlock()
+	globalForeignKeysMap = foreignKeysMap
+}
+
+func foreignKeysByTableName(tableName string) []*sqlparser.ForeignKey {
+	foreignKeysMu.RLock()
+	defer foreignKeysMu.RUnlock()
+	return globalForeignKeysMap[tableName]
+}
+
+func hasForeignKeys() bool {
+	foreignKeysMu.RLock()
+	defer foreignKeysMu.RUnlock()
+	return len(globalForeignKeysMap) > 0
+}
+
+// insertedRow values of a row inserted by INSERT query
+type insertedRow struct {
+	query *sqlparser.InsertQuery
+	// index of value tuple in query
+	index int
+}
+
+// insertedRows returns all rows inserted by query
+func insertedRows(query *sqlparser.InsertQuery) []*insertedRow {
+	rows := []*insertedRow{}
+	if len(query.RowQueries) > 0 {
+		for _, rowQuery := range query.RowQueries {
+			rows = append(rows, &insertedRow{query: rowQuery})
+		}
+		return rows
+	}
+	for idx := range query.Stmt.Rows.(vtparser.Values) {
+		rows = append(rows, &insertedRow{query: query, index: idx})
+	}
+	return rows
+}
+
+func assertForeignKeys(ctx context.Context, query sqlparser.Query, queryRow func(context.Context, string, ...interface{}) *Row) error {
+	if query.QueryType() != sqlparser.Insert {
+		return nil
+	}
+	foreignKeys := foreignKeysByTableName(query.Table())
+	if len(foreignKeys) == 0 {
+		return nil
+	}
+	asserted := map[string]bool{}
+	for _, row := range insertedRows(query.(*sqlparser.InsertQuery)) {
+		for _, fk := range foreignKeys {
+			whereExpr := foreignKeyWhereExpr(row, fk)
+			if whereExpr == nil {
+				continue
+			}
+			queryText := vtparser.String(&vtparser.Select{
+				SelectExprs: vtparser.SelectExprs{
+					&vtparser.AliasedExpr{
+						Expr: &vtparser.FuncExpr{
+							Name:  vtparser.NewColIdent("count"),
+							Exprs: vtparser.SelectExprs{&vtparser.StarExpr{}},
+						},
+					},
+				},
+				From: vtparser.TableExprs{
+					&vtparser.AliasedTableExpr{
+						Expr: vtparser.TableName{Name: vtparser.NewTableIdent(fk.ReferencedTable)},
+					},
+				},
+				Where: vtparser.NewWhere(vtparser.WhereStr, whereExpr),
+			})
+			if asserted[queryText] {
+				// rows reference the same row
+				continue
+			}
+			asserted[queryText] = true
+			debug.Printf("assert foreign key: %s", queryText)
+			var count uint64
+			if err := queryRow(ctx, queryText).Scan(&count); err != nil {
+				return errors.WithStack(err)
+			}
+			if count == 0 {
+				return errors.Wrapf(ErrForeignKeyConstraint, "%s", queryText)
+			}
+		}
+	}
+	return nil
+}
+
+// assertForeignKeys asserts foreign keys of INSERT query executed by prepared statement with args
+func (s *Stmt) assertForeignKeys(ctx context.Context, args []interface{}) error {
+	if s.queryRow == nil || !hasForeignKeys() {
+		return nil
+	}
+	parser, err := sqlparser.New()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	query, err := parser.Parse(s.query, args...)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(assertForeignKeys(ctx, query, s.queryRow))
+}
+
+// foreignKeyWhereExpr returns nil if any column value of foreign key is NULL or unknown
+func foreignKeyWhereExpr(row *insertedRow, fk *sqlparser.ForeignKey) vtparser.Expr {
+	if len(fk.Columns) != len(fk.ReferencedColumns) {
+		return nil
+	}
+	var whereExpr vtparser.Expr
+	for idx, column := range fk.Columns {
+		value := insertColumnValue(row, column)
+		if value == nil {
+			return nil
+		}
+		expr := &vtparser.ComparisonExpr{
+			Operator: vtparser.EqualStr,
+			Left:     &vtparser.ColName{Name: vtparser.NewColIdent(fk.ReferencedColumns[idx])},
+			Right:    value,
+		}
+		if whereExpr == nil {
+			whereExpr = expr
+		} else {
+			whereExpr = &vtparser.AndExpr{Left: whereExpr, Right: expr}
+		}
+	}
+	return whereExpr
+}
+
+// insertColumnValue returns value of column in row. placeholders are replaced by query arguments only in the first value tuple
+func insertColumnValue(row *insertedRow, columnName string) *vtparser.SQLVal {
+	query := row.query
+	for idx, column := range query.Stmt.Columns {
+		if column.String() != columnName {
+			continue
+		}
+		var value *vtparser.SQLVal
+		if row.index == 0 && query.ColumnValues[idx] != nil {
+			value = query.ColumnValues[idx]()
+		} else if val, ok := query.Stmt.Rows.(vtparser.Values)[row.index][idx].(*vtparser.SQLVal); ok {
+			value = val
+		}
+		if value == nil || value.Type == vtparser.ValArg || string(value.Val) == "null" {
+			return nil
+		}
+		return value
+	}
+	return nil
+}
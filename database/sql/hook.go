@@ -0,0 +1,22 @@
+package sql
+
+import (
+	"context"
+
+	"go.knocknote.io/octillery/connection"
+)
+
+// QueryHookInfo information of query passed to hook set by SetQueryHook.
+// Use RedactedArgs instead of Args if arguments may include sensitive values.
+type QueryHookInfo = connection.QueryHookInfo
+
+// SetQueryHook set function for it is called before and after every query is executed on each database ( shard ) of all DB instances.
+// Function is set as internal global variable and called synchronously by goroutine executing query, so it must return immediately.
+// If hook is nil, removes current hook.
+func SetQueryHook(hook func(context.Context, QueryHookInfo)) {
+	connection.SetQueryHook(hook)
+}
+
+// RoutingInterceptor decides shards query is executed on instead of sharding algorithm. It is set by DB.SetRoutingInterceptor.
+// It can narrow, broaden or replace shards chosen by algorithm, or veto query by returning error.
+type RoutingInterceptor = connection.RoutingInterceptor
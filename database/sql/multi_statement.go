@@ -0,0 +1,122 @@
+package sql
+
+import (
+	"context"
+	core "database/sql"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/connection"
+	"go.knocknote.io/octillery/exec"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+// multiStatementResult merged result of all statements of multi statement query
+type multiStatementResult struct {
+	lastInsertID    int64
+	lastInsertIDErr error
+	affectedRows    int64
+}
+
+func (r *multiStatementResult) LastInsertId() (int64, error) {
+	return r.lastInsertID, r.lastInsertIDErr
+}
+
+func (r *multiStatementResult) RowsAffected() (int64, error) {
+	return r.affectedRows, nil
+}
+
+// isMultiStatement returns whether query has multiple statements
+func isMultiStatement(queryText string) bool {
+	// SplitStatements returns error only if query has multiple statements
+	statements, err := sqlparser.SplitStatements(queryText)
+	return err != nil || len(statements) > 1
+}
+
+// splitMultiStatements splits multi statement query ( e.g. 'stmt1; stmt2' ) and validates where statements are routed to.
+// If query is single statement, returns nil.
+func splitMultiStatements(ctx context.Context, connMgr *connection.DBConnectionManager, queryText string, args []interface{}) ([]*sqlparser.Statement, error) {
+	statements, err := sqlparser.SplitStatements(queryText, args...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(statements) <= 1 {
+		return nil, nil
+	}
+	parser, err := sqlparser.New()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	conns := make([]*connection.DBConnection, 0, len(statements))
+	queries := make([]sqlparser.Query, 0, len(statements))
+	for _, statement := range statements {
+		query, err := parser.Parse(statement.Text, statement.Args...)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		conn, err := connMgr.ConnectionByTableName(query.Table())
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		conns = append(conns, conn)
+		queries = append(queries, query)
+	}
+	if err := exec.ValidateMultiStatement(ctx, conns, queries); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return statements, nil
+}
+
+// execMultiStatements executes each statement in order.
+// RowsAffected of result is total of all statements, and LastInsertId is the value of last statement.
+func execMultiStatements(
+	ctx context.Context,
+	statements []*sqlparser.Statement,
+	execProxy func(context.Context, string, ...interface{}) (Result, error)) (Result, error) {
+	merged := &multiStatementResult{}
+	for idx, statement := range statements {
+		result, err := execProxy(ctx, statement.Text, statement.Args...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot execute %d-th statement", idx+1)
+		}
+		affectedRows, err := result.RowsAffected()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		merged.affectedRows += affectedRows
+		// some drivers don't support LastInsertId, so error is returned only by LastInsertId of merged result
+		merged.lastInsertID, merged.lastInsertIDErr = result.LastInsertId()
+	}
+	return merged, nil
+}
+
+// queryMultiStatements executes each statement in order. Rows of each statement is read as a result set by NextResultSet.
+func queryMultiStatements(
+	ctx context.Context,
+	statements []*sqlparser.Statement,
+	queryProxy func(context.Context, string, ...interface{}) (*Rows, error)) (*Rows, error) {
+	cores := []*core.Rows{}
+	decrypters := []*columnDecrypter{}
+	resultSetIndexes := make([]int, 0, len(statements))
+	for idx, statement := range statements {
+		rows, err := queryProxy(ctx, statement.Text, statement.Args...)
+		if err != nil {
+			newRows(nil, cores).Close()
+			return nil, errors.Wrapf(err, "cannot query %d-th statement", idx+1)
+		}
+		// cores are watched by merged rows instead
+		rows.stopWatchingContext()
+		resultSetIndexes = append(resultSetIndexes, len(cores))
+		for idx := range rows.cores {
+			var decrypter *columnDecrypter
+			if idx < len(rows.decrypters) {
+				decrypter = rows.decrypters[idx]
+			}
+			decrypters = append(decrypters, decrypter)
+		}
+		cores = append(cores, rows.cores...)
+	}
+	merged := newRows(ctx, cores)
+	merged.resultSetIndexes = resultSetIndexes
+	merged.decrypters = decrypters
+	return merged, nil
+}
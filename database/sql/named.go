@@ -0,0 +1,37 @@
+package sql
+
+import (
+	core "database/sql"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+// coreArgs converts NamedArg to NamedArg of 'database/sql' package, so driver and sqlparser can handle it
+func coreArgs(args []interface{}) []interface{} {
+	var converted []interface{}
+	for idx, arg := range args {
+		named, ok := arg.(NamedArg)
+		if !ok {
+			continue
+		}
+		if converted == nil {
+			converted = append([]interface{}{}, args...)
+		}
+		converted[idx] = core.Named(named.Name, named.Value)
+	}
+	if converted == nil {
+		return args
+	}
+	return converted
+}
+
+// bindNamedArgs replaces named placeholders ( ':name' or '@name' ) to '?' and arranges arguments in order of them,
+// so query is routed by shard_key passed by NamedArg and executed by driver that doesn't support named placeholders.
+func bindNamedArgs(queryText string, args []interface{}) (string, []interface{}, error) {
+	queryText, args, err := sqlparser.BindNamedArgs(queryText, coreArgs(args))
+	if err != nil {
+		return "", nil, errors.WithStack(err)
+	}
+	return queryText, args, nil
+}
@@ -5,11 +5,14 @@ import (
 	core "database/sql"
 	coredriver "database/sql/driver"
 	"reflect"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"go.knocknote.io/octillery/connection"
 	"go.knocknote.io/octillery/database/sql/driver"
+	"go.knocknote.io/octillery/exec"
+	"go.knocknote.io/octillery/logging"
 )
 
 // NamedArg the compatible structure of NamedArg in 'database/sql' package.
@@ -53,23 +56,75 @@ type NullBool struct {
 }
 
 // DBStats the compatible structure of DBStats in 'database/sql' package.
+// Statistics of all connection pools ( shards, sequencers and slaves ) are summed up.
 type DBStats struct {
 	core core.DBStats
+
+	// maximum number of open connections. 0 if any connection pool is unlimited
+	MaxOpenConnections int
+
+	// number of established connections both in use and idle
+	OpenConnections int
+	// number of connections currently in use
+	InUse int
+	// number of idle connections
+	Idle int
+
+	// total number of connections waited for
+	WaitCount int64
+	// total time blocked waiting for a new connection
+	WaitDuration time.Duration
+	// total number of connections closed due to SetMaxIdleConns
+	MaxIdleClosed int64
+	// total number of connections closed due to SetConnMaxIdleTime ( Go 1.15 or later )
+	MaxIdleTimeClosed int64
+	// total number of connections closed due to SetConnMaxLifetime
+	MaxLifetimeClosed int64
+}
+
+func newDBStats(stats core.DBStats) DBStats {
+	return DBStats{
+		core:               stats,
+		MaxOpenConnections: stats.MaxOpenConnections,
+		OpenConnections:    stats.OpenConnections,
+		InUse:              stats.InUse,
+		Idle:               stats.Idle,
+		WaitCount:          stats.WaitCount,
+		WaitDuration:       stats.WaitDuration,
+		MaxIdleClosed:      stats.MaxIdleClosed,
+		MaxIdleTimeClosed:  maxIdleTimeClosed(stats),
+		MaxLifetimeClosed:  stats.MaxLifetimeClosed,
+	}
 }
 
 // Stmt the compatible structure of Stmt in 'database/sql' package.
 type Stmt struct {
-	core  *core.Stmt
+	core *core.Stmt
+	// statement for sharded table. if it is not nil, core is nil
+	shard *exec.ShardStmt
 	err   error
 	query string
 	tx    *connection.TxConnection
 	conn  connection.Connection
+	// queryRow selects rows referenced by foreign keys of INSERT query
+	queryRow func(context.Context, string, ...interface{}) *Row
 }
 
 // Rows the compatible structure of Rows in 'database/sql' package.
 type Rows struct {
 	cores            []*core.Rows
 	currentRowsIndex int
+	// indexes of cores that each result set of multi statement query begins at
+	resultSetIndexes []int
+	currentResultSet int
+	ctx              context.Context
+	// closed when Rows is closed to stop watching ctx
+	done     chan struct{}
+	mu       sync.Mutex
+	isClosed bool
+	ctxErr   error
+	// decrypter of each core. nil if rows of core have no encrypted columns
+	decrypters []*columnDecrypter
 }
 
 // ColumnType the compatible structure of ColumnType in 'database/sql' package.
@@ -80,6 +135,8 @@ type ColumnType struct {
 // Row the compatible structure of Row in 'database/sql' package.
 type Row struct {
 	core *core.Row
+	// rows of query selecting encrypted columns. *sql.Row cannot return columns, so they are scanned by Rows instead of core
+	rows *Rows
 	err  error
 }
 
@@ -93,10 +150,12 @@ type Result interface {
 type RawBytes []byte
 
 // ErrTxDone the compatible value of ErrTxDone in 'database/sql' package.
-var ErrTxDone = errors.New("sql: Transaction has already been committed or rolled back")
+// It is the same value as the one of 'database/sql' package, so errors returned by driver can be compared with it.
+var ErrTxDone = core.ErrTxDone
 
 // ErrNoRows the compatible value of ErrNoRows in 'database/sql' package.
-var ErrNoRows = errors.New("sql: no rows in result set")
+// It is the same value as the one of 'database/sql' package, so errors returned by driver can be compared with it.
+var ErrNoRows = core.ErrNoRows
 
 type driverProxy struct {
 	driver driver.Driver
@@ -323,28 +382,85 @@ func (n NullBool) Value() (driver.Value, error) {
 
 // ExecContext the compatible method of ExecContext in 'database/sql' package.
 func (s *Stmt) ExecContext(ctx context.Context, args ...interface{}) (core.Result, error) {
-	if s.err != nil {
-		return nil, errors.WithStack(s.err)
+	start := time.Now()
+	result, err := s.execProxy(ctx, args...)
+	logging.LogQuery(start, s.query, args, err)
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
-	result, err := s.core.ExecContext(ctx, args...)
+	return result, nil
+}
+
+// Exec the compatible method of Exec in 'database/sql' package.
+func (s *Stmt) Exec(args ...interface{}) (core.Result, error) {
+	start := time.Now()
+	result, err := s.execProxy(nil, args...)
+	logging.LogQuery(start, s.query, args, err)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	if s.tx == nil {
-		return result, nil
+	return result, nil
+}
+
+// QueryContext the compatible method of QueryContext in 'database/sql' package.
+func (s *Stmt) QueryContext(ctx context.Context, args ...interface{}) (*Rows, error) {
+	start := time.Now()
+	rows, err := s.queryProxy(ctx, args...)
+	logging.LogQuery(start, s.query, args, err)
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
-	if err := s.tx.AddWriteQuery(s.conn, result, s.query, args...); err != nil {
+	return rows, nil
+}
+
+// Query the compatible method of Query in 'database/sql' package.
+func (s *Stmt) Query(args ...interface{}) (*Rows, error) {
+	start := time.Now()
+	rows, err := s.queryProxy(nil, args...)
+	logging.LogQuery(start, s.query, args, err)
+	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	return result, nil
+	return rows, nil
 }
 
-// Exec the compatible method of Exec in 'database/sql' package.
-func (s *Stmt) Exec(args ...interface{}) (core.Result, error) {
+// QueryRowContext the compatible method of QueryRowContext in 'database/sql' package.
+func (s *Stmt) QueryRowContext(ctx context.Context, args ...interface{}) *Row {
+	start := time.Now()
+	row := s.queryRowProxy(ctx, args...)
+	logging.LogQuery(start, s.query, args, row.err)
+	return row
+}
+
+// QueryRow the compatible method of QueryRow in 'database/sql' package.
+func (s *Stmt) QueryRow(args ...interface{}) *Row {
+	start := time.Now()
+	row := s.queryRowProxy(nil, args...)
+	logging.LogQuery(start, s.query, args, row.err)
+	return row
+}
+
+func (s *Stmt) execProxy(ctx context.Context, args ...interface{}) (core.Result, error) {
+	args = coreArgs(args)
 	if s.err != nil {
 		return nil, errors.WithStack(s.err)
 	}
-	result, err := s.core.Exec(args...)
+	if err := s.assertForeignKeys(ctx, args); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if s.shard != nil {
+		result, err := s.shard.Exec(ctx, args...)
+		return result, errors.WithStack(err)
+	}
+	var (
+		result core.Result
+		err    error
+	)
+	if ctx == nil {
+		result, err = s.core.Exec(args...)
+	} else {
+		result, err = s.core.ExecContext(ctx, args...)
+	}
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -357,74 +473,145 @@ func (s *Stmt) Exec(args ...interface{}) (core.Result, error) {
 	return result, nil
 }
 
-// QueryContext the compatible method of QueryContext in 'database/sql' package.
-func (s *Stmt) QueryContext(ctx context.Context, args ...interface{}) (*Rows, error) {
+func (s *Stmt) queryProxy(ctx context.Context, args ...interface{}) (*Rows, error) {
+	args = coreArgs(args)
 	if s.err != nil {
 		return nil, errors.WithStack(s.err)
 	}
-	rows, err := s.core.QueryContext(ctx, args...)
+	if s.shard != nil {
+		rows, err := s.shard.Query(ctx, args...)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return newRows(ctx, rows), nil
+	}
+	var (
+		rows *core.Rows
+		err  error
+	)
+	if ctx == nil {
+		rows, err = s.core.Query(args...)
+	} else {
+		rows, err = s.core.QueryContext(ctx, args...)
+	}
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 	if s.tx != nil {
 		s.tx.AddReadQuery(s.query, args...)
 	}
-	return &Rows{cores: []*core.Rows{rows}}, nil
+	return newRows(ctx, []*core.Rows{rows}), nil
 }
 
-// Query the compatible method of Query in 'database/sql' package.
-func (s *Stmt) Query(args ...interface{}) (*Rows, error) {
+func (s *Stmt) queryRowProxy(ctx context.Context, args ...interface{}) *Row {
+	args = coreArgs(args)
 	if s.err != nil {
-		return nil, errors.WithStack(s.err)
+		return &Row{err: s.err}
 	}
-	rows, err := s.core.Query(args...)
-	if err != nil {
-		return nil, errors.WithStack(err)
+	if s.shard != nil {
+		row, err := s.shard.QueryRow(ctx, args...)
+		return &Row{core: row, err: err}
 	}
 	if s.tx != nil {
 		s.tx.AddReadQuery(s.query, args...)
 	}
-	return &Rows{cores: []*core.Rows{rows}}, nil
+	if ctx == nil {
+		return &Row{core: s.core.QueryRow(args...)}
+	}
+	return &Row{core: s.core.QueryRowContext(ctx, args...)}
 }
 
-// QueryRowContext the compatible method of QueryRowContext in 'database/sql' package.
-func (s *Stmt) QueryRowContext(ctx context.Context, args ...interface{}) *Row {
-	if s.err != nil {
-		return &Row{err: s.err}
+// Close the compatible method of Close in 'database/sql' package.
+func (s *Stmt) Close() error {
+	if s.shard != nil {
+		return errors.WithStack(s.shard.Close())
 	}
-	if s.tx != nil {
-		s.tx.AddReadQuery(s.query, args...)
+	if s.core == nil {
+		return nil
 	}
-	return &Row{core: s.core.QueryRowContext(ctx, args...)}
+	return errors.WithStack(s.core.Close())
 }
 
-// QueryRow the compatible method of QueryRow in 'database/sql' package.
-func (s *Stmt) QueryRow(args ...interface{}) *Row {
-	if s.err != nil {
-		return &Row{err: s.err}
+// newRows creates Rows for rows of shards.
+// If ctx is cancelled before Rows is closed, rows of all shards are closed immediately
+// to release connections of shards that are not read yet.
+func newRows(ctx context.Context, cores []*core.Rows) *Rows {
+	rs := &Rows{cores: cores, ctx: ctx}
+	if ctx == nil || ctx.Done() == nil {
+		return rs
+	}
+	rs.done = make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			rs.cancel(ctx.Err())
+		case <-rs.done:
+		}
+	}()
+	return rs
+}
+
+// cancel closes rows of all shards by cancellation of context
+func (rs *Rows) cancel(err error) {
+	rs.mu.Lock()
+	if rs.isClosed {
+		rs.mu.Unlock()
+		return
 	}
-	if s.tx != nil {
-		s.tx.AddReadQuery(s.query, args...)
+	rs.ctxErr = err
+	rs.mu.Unlock()
+	for _, core := range rs.cores {
+		core.Close()
 	}
-	return &Row{core: s.core.QueryRow(args...)}
 }
 
-// Close the compatible method of Close in 'database/sql' package.
-func (s *Stmt) Close() error {
-	return errors.WithStack(s.core.Close())
+// contextErr returns error if context is cancelled while reading rows
+func (rs *Rows) contextErr() error {
+	if rs.ctx != nil {
+		if err := rs.ctx.Err(); err != nil {
+			rs.cancel(err)
+		}
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.ctxErr
+}
+
+// stopWatchingContext stops goroutine watching context
+func (rs *Rows) stopWatchingContext() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.isClosed {
+		return
+	}
+	rs.isClosed = true
+	if rs.done != nil {
+		close(rs.done)
+	}
 }
 
 func (rs *Rows) index() int {
 	idx := rs.currentRowsIndex
-	if len(rs.cores) == rs.currentRowsIndex {
+	if rs.resultSetEnd() == rs.currentRowsIndex {
 		idx = rs.currentRowsIndex - 1
 	}
 	return idx
 }
 
+// resultSetEnd returns index of cores that current result set ends at
+func (rs *Rows) resultSetEnd() int {
+	if rs.currentResultSet+1 < len(rs.resultSetIndexes) {
+		return rs.resultSetIndexes[rs.currentResultSet+1]
+	}
+	return len(rs.cores)
+}
+
 // Next the compatible method of Next in 'database/sql' package.
 func (rs *Rows) Next() bool {
-	if len(rs.cores) == rs.currentRowsIndex {
+	if rs.contextErr() != nil {
+		return false
+	}
+	if rs.resultSetEnd() == rs.currentRowsIndex {
 		return false
 	}
 	existsNextRow := rs.cores[rs.currentRowsIndex].Next()
@@ -437,6 +624,15 @@ func (rs *Rows) Next() bool {
 
 // NextResultSet the compatible method of NextResultSet in 'database/sql' package.
 func (rs *Rows) NextResultSet() bool {
+	if len(rs.resultSetIndexes) > 0 {
+		// result set of multi statement query is rows of each statement
+		if rs.currentResultSet+1 >= len(rs.resultSetIndexes) {
+			return false
+		}
+		rs.currentResultSet++
+		rs.currentRowsIndex = rs.resultSetIndexes[rs.currentResultSet]
+		return true
+	}
 	if len(rs.cores) == rs.currentRowsIndex {
 		return false
 	}
@@ -450,6 +646,9 @@ func (rs *Rows) NextResultSet() bool {
 
 // Err the compatible method of Err in 'database/sql' package.
 func (rs *Rows) Err() error {
+	if err := rs.contextErr(); err != nil {
+		return errors.WithStack(err)
+	}
 	return errors.WithStack(rs.cores[rs.index()].Err())
 }
 
@@ -480,21 +679,25 @@ func (rs *Rows) ColumnTypes() ([]*ColumnType, error) {
 
 // Scan the compatible method of Scan in 'database/sql' package.
 func (rs *Rows) Scan(dest ...interface{}) error {
-	return errors.WithStack(rs.cores[rs.index()].Scan(dest...))
+	idx := rs.index()
+	if idx < len(rs.decrypters) && rs.decrypters[idx] != nil {
+		columns, err := rs.cores[idx].Columns()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(rs.decrypters[idx].scan(columns, rs.cores[idx].Scan, dest))
+	}
+	return errors.WithStack(rs.cores[idx].Scan(dest...))
 }
 
 // Close the compatible method of Close in 'database/sql' package.
 func (rs *Rows) Close() error {
-	errs := []string{}
+	rs.stopWatchingContext()
+	errs := &connection.MultiError{}
 	for _, core := range rs.cores {
-		if err := core.Close(); err != nil {
-			errs = append(errs, err.Error())
-		}
-	}
-	if len(errs) > 0 {
-		return errors.New(strings.Join(errs, ":"))
+		errs.Add(core.Close())
 	}
-	return nil
+	return errs.ErrorOrNil()
 }
 
 // Name the compatible method of Name in 'database/sql' package.
@@ -532,6 +735,16 @@ func (r *Row) Scan(dest ...interface{}) error {
 	if r.err != nil {
 		return errors.WithStack(r.err)
 	}
+	if r.rows != nil {
+		defer r.rows.Close()
+		if !r.rows.Next() {
+			if err := r.rows.Err(); err != nil {
+				return errors.WithStack(err)
+			}
+			return errors.WithStack(ErrNoRows)
+		}
+		return errors.WithStack(r.rows.Scan(dest...))
+	}
 	if r.core == nil {
 		return errors.New("sql.Row pointer is nil")
 	}
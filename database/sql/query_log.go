@@ -2,14 +2,21 @@ package sql
 
 import (
 	"fmt"
+	"regexp"
+	"strings"
 
 	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
 	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/connection"
+	"go.knocknote.io/octillery/connection/adapter"
 	"go.knocknote.io/octillery/debug"
 	"go.knocknote.io/octillery/exec"
 	"go.knocknote.io/octillery/sqlparser"
+	"go.knocknote.io/octillery/warning"
 )
 
+var insertKeyword = regexp.MustCompile(`(?i)^(\s*insert)\s+`)
+
 // GetParsedQueryByQueryLog get instance of `sqlparser.Query` by QueryLog.
 // If QueryLog has LastInsertID value, add to query it
 func (t *Tx) GetParsedQueryByQueryLog(log *QueryLog) (sqlparser.Query, error) {
@@ -111,9 +118,39 @@ func (t *Tx) IsAlreadyCommittedQueryLog(log *QueryLog) (bool, error) {
 	return count > 0, nil
 }
 
+// ReplayOptions options for replaying QueryLog by ReplayQueryLogs
+type ReplayOptions struct {
+	// convert INSERT to the one ignores duplicate key error by dialect of adapter
+	// ( e.g. 'INSERT IGNORE' of MySQL or 'INSERT OR IGNORE' of SQLite ).
+	// This makes replay idempotent even if the row was actually committed.
+	IgnoreDuplicate bool
+
+	// verify columns of INSERT/UPDATE exist in schema cached by connection manager before replaying.
+	// If unknown column is found, cached schema is refreshed once because table may be altered after caching.
+	ValidateColumns bool
+}
+
 // ExecWithQueryLog exec query by *connection.QueryLog.
 // This is able to use for recovery from distributed transaction error.
 func (t *Tx) ExecWithQueryLog(log *QueryLog) (Result, error) {
+	return t.execWithQueryLog(log, nil)
+}
+
+// ReplayQueryLogs exec queries by QueryLog list in order.
+// This is able to use for recovery from distributed transaction error ( e.g. failureQueries passed to AfterCommitCallback ).
+func (t *Tx) ReplayQueryLogs(logs []*QueryLog, opts *ReplayOptions) ([]Result, error) {
+	results := make([]Result, 0, len(logs))
+	for _, log := range logs {
+		result, err := t.execWithQueryLog(log, opts)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (t *Tx) execWithQueryLog(log *QueryLog, opts *ReplayOptions) (Result, error) {
 	query, err := t.GetParsedQueryByQueryLog(log)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -125,6 +162,21 @@ func (t *Tx) ExecWithQueryLog(log *QueryLog) (Result, error) {
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	if err := exec.ValidatePermission(conn, query); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if opts != nil && opts.ValidateColumns {
+		if err := t.validateColumns(query); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	queryText := log.Query
+	if opts != nil && opts.IgnoreDuplicate && query.QueryType() == sqlparser.Insert {
+		queryText, err = t.ignoreDuplicate(conn, query.(*sqlparser.InsertQuery), queryText)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
 	t.begin(conn)
 	if conn.IsShard {
 		result, err := exec.NewQueryExecutor(t.ctx, conn, t.tx, query).Exec()
@@ -133,13 +185,89 @@ func (t *Tx) ExecWithQueryLog(log *QueryLog) (Result, error) {
 		}
 		return result, nil
 	}
-	result, err := t.tx.Exec(t.ctx, conn, log.Query, log.Args...)
+	result, err := t.tx.Exec(t.ctx, conn, queryText, log.Args...)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return result, nil
 }
 
+// validateColumns verifies columns written by query exist in schema of table.
+func (t *Tx) validateColumns(query sqlparser.Query) error {
+	columns := []string{}
+	switch q := query.(type) {
+	case *sqlparser.InsertQuery:
+		for _, column := range q.Stmt.Columns {
+			columns = append(columns, column.String())
+		}
+	case *sqlparser.QueryBase:
+		if stmt, ok := q.Stmt.(*vtparser.Update); ok {
+			for _, expr := range stmt.Exprs {
+				columns = append(columns, expr.Name.Name.String())
+			}
+		}
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+	schemaCache := t.connMgr.SchemaCache()
+	unknownColumns := func() ([]string, error) {
+		schema, err := schemaCache.Schema(query.Table())
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		unknownColumns := []string{}
+		for _, column := range columns {
+			if !schema.HasColumn(column) {
+				unknownColumns = append(unknownColumns, column)
+			}
+		}
+		return unknownColumns, nil
+	}
+	found, err := unknownColumns()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	debug.Printf("refresh schema of %s because unknown columns %v are found", query.Table(), found)
+	if err := schemaCache.Refresh(query.Table()); err != nil {
+		return errors.WithStack(err)
+	}
+	found, err = unknownColumns()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if len(found) > 0 {
+		return errors.Errorf("unknown columns %s for table %s", strings.Join(found, ","), query.Table())
+	}
+	return nil
+}
+
+// ignoreDuplicate converts INSERT query to the one ignores duplicate key error.
+// It returns converted text of queryText for not sharded table.
+func (*Tx) ignoreDuplicate(conn *connection.DBConnection, query *sqlparser.InsertQuery, queryText string) (string, error) {
+	if query.Stmt.Ignore != "" {
+		debug.Printf("replay '%s' as it is. it already ignores duplicate key", queryText)
+		return queryText, nil
+	}
+	ignoreDuplicateAdapter, ok := conn.Adapter.(adapter.IgnoreDuplicateAdapter)
+	if !ok {
+		return "", errors.Errorf("cannot replay '%s' ignoring duplicate key. adapter doesn't support it", queryText)
+	}
+	modifier, clause := ignoreDuplicateAdapter.IgnoreDuplicateClause()
+	query.SetIgnoreDuplicate(modifier, clause)
+	if modifier != "" {
+		queryText = insertKeyword.ReplaceAllString(queryText, "${1} "+modifier+" ")
+	}
+	if clause != "" {
+		queryText = fmt.Sprintf("%s %s", strings.TrimRight(queryText, "; \t\n"), clause)
+	}
+	debug.Printf("replay '%s' ignoring duplicate key", queryText)
+	return queryText, nil
+}
+
 func (*Tx) replaceInsertQueryByQueryLog(log *QueryLog, query *sqlparser.InsertQuery) {
 	if log.LastInsertID == 0 {
 		return
@@ -267,7 +395,10 @@ func (t *Tx) createEqualComparisonExprWithArgs(left vtparser.Expr, right vtparse
 			Right:    values,
 		}
 	default:
-		debug.Printf("[WARN] unexpected expr value %T", val)
+		warning.Warn(&warning.Warning{
+			Code:    warning.UnsupportedArgType,
+			Message: fmt.Sprintf("unexpected expr value %T", val),
+		})
 		return nil
 	}
 }
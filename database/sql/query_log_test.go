@@ -1,8 +1,10 @@
 package sql
 
 import (
+	"strings"
 	"testing"
 
+	"go.knocknote.io/octillery/connection"
 	"go.knocknote.io/octillery/sqlparser"
 )
 
@@ -262,3 +264,61 @@ func TestIsAlreadyCommittedQueryLog(t *testing.T) {
 		Query: "DELETE FROM users WHERE id = 10",
 	})
 }
+
+func TestReplayQueryLogs(t *testing.T) {
+	db, err := Open("", "")
+	checkErr(t, err)
+	replay := func(t *testing.T, logs []*QueryLog, opts *ReplayOptions) []*connection.QueryLog {
+		tx, err := db.Begin()
+		checkErr(t, err)
+		defer tx.Rollback()
+		results, err := tx.ReplayQueryLogs(logs, opts)
+		checkErr(t, err)
+		if len(results) != len(logs) {
+			t.Fatal("cannot get results")
+		}
+		return tx.WriteQueries()
+	}
+	t.Run("ignore duplicate", func(t *testing.T) {
+		logs := []*QueryLog{
+			{Query: "INSERT INTO user_stages(user_id) VALUES (10)"},
+			{Query: "INSERT IGNORE INTO user_stages(user_id) VALUES (20)"},
+		}
+		writeQueries := replay(t, logs, &ReplayOptions{IgnoreDuplicate: true})
+		if writeQueries[0].Query != "INSERT ignore INTO user_stages(user_id) VALUES (10)" {
+			t.Fatalf("cannot convert query %s", writeQueries[0].Query)
+		}
+		if writeQueries[1].Query != logs[1].Query {
+			t.Fatalf("must not convert query %s", writeQueries[1].Query)
+		}
+	})
+	t.Run("ignore duplicate for sharding table", func(t *testing.T) {
+		writeQueries := replay(t, []*QueryLog{
+			{Query: "INSERT INTO user_items(user_id) VALUES (10)", LastInsertID: 1},
+		}, &ReplayOptions{IgnoreDuplicate: true})
+		if !strings.HasPrefix(writeQueries[0].Query, "insert ignore into user_items") {
+			t.Fatalf("cannot convert query %s", writeQueries[0].Query)
+		}
+	})
+	t.Run("validate columns", func(t *testing.T) {
+		replay(t, []*QueryLog{
+			{Query: "INSERT INTO user_stages(user_id, name) VALUES (10, 'alice')"},
+			{Query: "UPDATE user_stages SET age = 5 WHERE user_id = 10"},
+		}, &ReplayOptions{ValidateColumns: true})
+		tx, err := db.Begin()
+		checkErr(t, err)
+		defer tx.Rollback()
+		if _, err := tx.ReplayQueryLogs([]*QueryLog{
+			{Query: "UPDATE user_stages SET unknown = 5 WHERE user_id = 10"},
+		}, &ReplayOptions{ValidateColumns: true}); err == nil {
+			t.Fatal("cannot detect unknown column")
+		}
+	})
+	t.Run("without options", func(t *testing.T) {
+		query := "INSERT INTO user_stages(user_id) VALUES (10)"
+		writeQueries := replay(t, []*QueryLog{{Query: query}}, nil)
+		if writeQueries[0].Query != query {
+			t.Fatal("must not convert query")
+		}
+	})
+}
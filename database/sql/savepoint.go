@@ -0,0 +1,68 @@
+package sql
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+// savepointResult result of savepoint statement. it doesn't change any rows
+type savepointResult struct{}
+
+func (r *savepointResult) LastInsertId() (int64, error) {
+	return 0, nil
+}
+
+func (r *savepointResult) RowsAffected() (int64, error) {
+	return 0, nil
+}
+
+// execSavepoint executes 'SAVEPOINT', 'RELEASE SAVEPOINT' or 'ROLLBACK TO SAVEPOINT' on all databases accessed by transaction.
+// If no database is accessed yet, savepoints are kept until transaction begins.
+func (proxy *Tx) execSavepoint(ctx context.Context, query *sqlparser.SavepointQuery) (Result, error) {
+	if proxy.tx == nil {
+		if err := proxy.execPendingSavepoint(query); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return &savepointResult{}, nil
+	}
+	var err error
+	switch query.QueryType() {
+	case sqlparser.Savepoint:
+		err = proxy.tx.Savepoint(ctx, query.Name)
+	case sqlparser.ReleaseSavepoint:
+		err = proxy.tx.ReleaseSavepoint(ctx, query.Name)
+	case sqlparser.RollbackToSavepoint:
+		err = proxy.tx.RollbackToSavepoint(ctx, query.Name)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &savepointResult{}, nil
+}
+
+func (proxy *Tx) execPendingSavepoint(query *sqlparser.SavepointQuery) error {
+	idx := -1
+	for i, name := range proxy.savepoints {
+		if name == query.Name {
+			idx = i
+		}
+	}
+	if query.QueryType() == sqlparser.Savepoint {
+		if idx >= 0 {
+			proxy.savepoints = append(proxy.savepoints[:idx], proxy.savepoints[idx+1:]...)
+		}
+		proxy.savepoints = append(proxy.savepoints, query.Name)
+		return nil
+	}
+	if idx < 0 {
+		return errors.Errorf("savepoint %s does not exist", query.Name)
+	}
+	if query.QueryType() == sqlparser.ReleaseSavepoint {
+		proxy.savepoints = proxy.savepoints[:idx]
+	} else {
+		proxy.savepoints = proxy.savepoints[:idx+1]
+	}
+	return nil
+}
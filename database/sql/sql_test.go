@@ -3,11 +3,13 @@ package sql
 import (
 	"context"
 	core "database/sql"
+	"fmt"
 	"io"
 	"log"
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -16,6 +18,8 @@ import (
 	"go.knocknote.io/octillery/connection"
 	"go.knocknote.io/octillery/connection/adapter"
 	"go.knocknote.io/octillery/database/sql/driver"
+	"go.knocknote.io/octillery/exec"
+	"go.knocknote.io/octillery/metrics"
 	"go.knocknote.io/octillery/path"
 )
 
@@ -52,6 +56,18 @@ func (t *TestAdapter) InsertRowToSequencerIfNotExists(conn *core.DB, tableName s
 	return t.insertRowToSequencerIfNotExistsErr
 }
 
+func (t *TestAdapter) IgnoreDuplicateClause() (string, string) {
+	return "ignore", ""
+}
+
+func (t *TestAdapter) TableSchema(conn *core.DB, tableName string) (string, error) {
+	return fmt.Sprintf("create table %s (id integer, user_id integer, name varchar(255), age integer)", tableName), nil
+}
+
+func (t *TestAdapter) EstimateRows(ctx context.Context, conn *core.DB, query string, args ...interface{}) (int64, bool, error) {
+	return 10, true, nil
+}
+
 type TestDriver struct {
 	openErr error
 }
@@ -60,6 +76,10 @@ func (t *TestDriver) Open(name string) (driver.Conn, error) {
 	return &TestConn{}, t.openErr
 }
 
+// onTestQuery is called before TestConn prepares or executes query.
+// Tests replace it to observe queries sent to shards or fail them.
+var onTestQuery = func(query string) error { return nil }
+
 type TestConn struct {
 	prepareErr error
 	beginErr   error
@@ -68,6 +88,9 @@ type TestConn struct {
 }
 
 func (t *TestConn) Prepare(query string) (driver.Stmt, error) {
+	if err := onTestQuery(query); err != nil {
+		return nil, err
+	}
 	inputNum := len(regexp.MustCompile(`\?`).Split(query, -1)) - 1
 	return &TestStmt{inputNum: inputNum}, t.prepareErr
 }
@@ -81,6 +104,9 @@ func (t *TestConn) Close() error {
 }
 
 func (t *TestConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	if err := onTestQuery(query); err != nil {
+		return nil, err
+	}
 	return &TestRows{firstTime: true}, t.queryErr
 }
 
@@ -402,6 +428,8 @@ func TestDB(t *testing.T) {
 	db.SetMaxIdleConns(10)
 	db.SetMaxOpenConns(10)
 	db.SetConnMaxLifetime(10 * time.Second)
+	db.SetConnMaxIdleTime(5 * time.Second)
+	db.SetConnMaxLifetimeJitter(time.Second)
 	db.Stats()
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -644,15 +672,17 @@ func TestTransaction(t *testing.T) {
 			testTransactionWithNotShardingTable(ctx, t, tx)
 		})
 		t.Run("sharding table", func(t *testing.T) {
-			if _, err := tx.Prepare("select * from users where id = ?"); err == nil {
-				t.Fatal("cannot handle error")
-			}
+			stmt, err := tx.Prepare("select * from users where id = ?")
+			checkErr(t, err)
+			checkErr(t, stmt.Close())
 			tx, err := db.Begin()
 			checkErr(t, err)
-			stmt := tx.Stmt(&Stmt{query: "select * from users where id = ?"})
-			if _, err := stmt.Query(1); err == nil {
-				t.Fatal("cannot handle error")
-			}
+			stmt = tx.Stmt(&Stmt{query: "select * from users where id = ?"})
+			rows, err := stmt.Query(1)
+			checkErr(t, err)
+			checkErr(t, rows.Close())
+			checkErr(t, stmt.Close())
+			checkErr(t, tx.Rollback())
 		})
 	})
 
@@ -920,6 +950,439 @@ func testQueryRowContextTransactionError(t *testing.T, tx *Tx) {
 	})
 }
 
+func TestPermission(t *testing.T) {
+	cfg, err := config.Get()
+	checkErr(t, err)
+	db, err := Open("sqlite3", "?parseTime=true&loc=Asia%2FTokyo")
+	checkErr(t, err)
+	defer db.Close()
+	tx, err := db.Begin()
+	checkErr(t, err)
+	defer tx.Rollback()
+
+	t.Run("read only", func(t *testing.T) {
+		cfg.Tables["users"].ReadOnly = true
+		defer func() { cfg.Tables["users"].ReadOnly = false }()
+		if _, err := db.Exec("delete from users where id = 1"); errors.Cause(err) != exec.ErrReadOnlyTable {
+			t.Fatalf("%+v\n", err)
+		}
+		if _, err := tx.Exec("delete from users where id = 1"); errors.Cause(err) != exec.ErrReadOnlyTable {
+			t.Fatalf("%+v\n", err)
+		}
+		if _, err := db.Exec("drop table users"); errors.Cause(err) != exec.ErrReadOnlyTable {
+			t.Fatalf("%+v\n", err)
+		}
+	})
+	t.Run("write only", func(t *testing.T) {
+		cfg.Tables["users"].WriteOnly = true
+		defer func() { cfg.Tables["users"].WriteOnly = false }()
+		if _, err := db.Query("select * from users where id = 1"); errors.Cause(err) != exec.ErrWriteOnlyTable {
+			t.Fatalf("%+v\n", err)
+		}
+		if _, err := tx.Query("select * from users where id = 1"); errors.Cause(err) != exec.ErrWriteOnlyTable {
+			t.Fatalf("%+v\n", err)
+		}
+	})
+}
+
+func TestAllShardWrite(t *testing.T) {
+	cfg, err := config.Get()
+	checkErr(t, err)
+	db, err := Open("sqlite3", "?parseTime=true&loc=Asia%2FTokyo")
+	checkErr(t, err)
+	defer db.Close()
+	ctx := exec.WithAllShards(context.Background())
+
+	t.Run("allow", func(t *testing.T) {
+		if _, err := db.Exec("delete from users"); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if _, err := db.Exec("update users set name = 'alice'"); errors.Cause(err) != exec.ErrAllShardWriteNotAcknowledged {
+			t.Fatalf("%+v\n", err)
+		}
+		if _, err := db.ExecContext(ctx, "update users set name = 'alice'"); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if _, err := db.ExecContext(ctx, "delete from users where name = 'alice'"); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+	})
+	t.Run("require context", func(t *testing.T) {
+		cfg.AllShardWritePolicy = config.AllShardWritePolicyRequireContext
+		defer func() { cfg.AllShardWritePolicy = "" }()
+		if _, err := db.Exec("delete from users"); errors.Cause(err) != exec.ErrAllShardWriteNotAcknowledged {
+			t.Fatalf("%+v\n", err)
+		}
+		if _, err := db.ExecContext(ctx, "delete from users"); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if _, err := db.Exec("update users set name = 'alice' where id = 1"); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+	})
+	t.Run("reject", func(t *testing.T) {
+		cfg.AllShardWritePolicy = config.AllShardWritePolicyReject
+		defer func() { cfg.AllShardWritePolicy = "" }()
+		if _, err := db.ExecContext(ctx, "delete from users"); errors.Cause(err) != exec.ErrAllShardWriteRejected {
+			t.Fatalf("%+v\n", err)
+		}
+		if _, err := db.ExecContext(ctx, "update users set name = 'alice'"); errors.Cause(err) != exec.ErrAllShardWriteRejected {
+			t.Fatalf("%+v\n", err)
+		}
+	})
+}
+
+func TestReturning(t *testing.T) {
+	db, err := Open("sqlite3", "?parseTime=true&loc=Asia%2FTokyo")
+	checkErr(t, err)
+	defer db.Close()
+	t.Run("insert returning", func(t *testing.T) {
+		rows, err := db.Query("insert into users(id, name) values (null, 'alice') returning id")
+		checkErr(t, err)
+		checkErr(t, rows.Close())
+	})
+	t.Run("update returning", func(t *testing.T) {
+		rows, err := db.Query("update users set name = 'alice' where id = 1 returning id")
+		checkErr(t, err)
+		checkErr(t, rows.Close())
+		if _, err := db.Query("update users set name = 'alice' returning id"); errors.Cause(err) != exec.ErrAllShardWriteNotAcknowledged {
+			t.Fatalf("%+v\n", err)
+		}
+		rows, err = db.QueryContext(exec.WithAllShards(context.Background()), "update users set name = 'alice' returning id")
+		checkErr(t, err)
+		checkErr(t, rows.Close())
+	})
+	t.Run("delete returning", func(t *testing.T) {
+		if row := db.QueryRow("delete from users where id = 1 returning id"); row.err != nil {
+			t.Fatalf("%+v\n", row.err)
+		}
+	})
+	t.Run("without returning", func(t *testing.T) {
+		if _, err := db.Query("update users set name = 'alice' where id = 1"); err == nil {
+			t.Fatal("cannot handle error")
+		}
+	})
+}
+
+func TestCancelAllShardQuery(t *testing.T) {
+	db, err := Open("sqlite3", "?parseTime=true&loc=Asia%2FTokyo")
+	checkErr(t, err)
+	defer db.Close()
+	defer func() { onTestQuery = func(string) error { return nil } }()
+
+	// cancel context while first shard is queried, so the remaining shard must not be queried
+	cancelAtFirstShard := func() (context.Context, *int) {
+		ctx, cancel := context.WithCancel(context.Background())
+		queryCount := 0
+		onTestQuery = func(string) error {
+			queryCount++
+			cancel()
+			return nil
+		}
+		return ctx, &queryCount
+	}
+	t.Run("select", func(t *testing.T) {
+		ctx, queryCount := cancelAtFirstShard()
+		if _, err := db.QueryContext(ctx, "select * from users"); errors.Cause(err) != context.Canceled {
+			t.Fatalf("%+v\n", err)
+		}
+		if *queryCount != 1 {
+			t.Fatalf("remaining shards are queried after cancel. query count is %d", *queryCount)
+		}
+	})
+	t.Run("aggregate", func(t *testing.T) {
+		ctx, queryCount := cancelAtFirstShard()
+		if row := db.QueryRowContext(ctx, "select count(*) from users"); errors.Cause(row.err) != context.Canceled {
+			t.Fatalf("%+v\n", row.err)
+		}
+		if *queryCount != 1 {
+			t.Fatalf("remaining shards are queried after cancel. query count is %d", *queryCount)
+		}
+	})
+	t.Run("delete", func(t *testing.T) {
+		ctx, queryCount := cancelAtFirstShard()
+		if _, err := db.ExecContext(exec.WithAllShards(ctx), "delete from users"); errors.Cause(err) != context.Canceled {
+			t.Fatalf("%+v\n", err)
+		}
+		if *queryCount != 1 {
+			t.Fatalf("remaining shards are executed after cancel. query count is %d", *queryCount)
+		}
+	})
+}
+
+func TestConsistencyToken(t *testing.T) {
+	db, err := Open("sqlite3", "?parseTime=true&loc=Asia%2FTokyo")
+	checkErr(t, err)
+	defer db.Close()
+	ctx := context.Background()
+	t.Run("db", func(t *testing.T) {
+		_, err := db.Exec("update users set name = 'alice' where id = 1")
+		checkErr(t, err)
+		token, err := db.ConsistencyToken(ctx, "users")
+		checkErr(t, err)
+		parsed, err := connection.ParseConsistencyToken(token)
+		checkErr(t, err)
+		if len(parsed.Positions) != 2 {
+			t.Fatalf("invalid token %v", parsed.Positions)
+		}
+		checkErr(t, db.WaitForToken(ctx, token))
+	})
+	t.Run("tx", func(t *testing.T) {
+		tx, err := db.Begin()
+		checkErr(t, err)
+		_, err = tx.Exec("update users set name = 'alice' where id = 1")
+		checkErr(t, err)
+		if _, err := tx.ConsistencyToken(ctx); err == nil {
+			t.Fatal("cannot handle error")
+		}
+		checkErr(t, tx.Commit())
+		token, err := tx.ConsistencyToken(ctx)
+		checkErr(t, err)
+		parsed, err := connection.ParseConsistencyToken(token)
+		checkErr(t, err)
+		if len(parsed.Positions) != 1 {
+			t.Fatalf("token must have only written shard %v", parsed.Positions)
+		}
+		checkErr(t, db.WaitForToken(ctx, token))
+	})
+	t.Run("invalid token", func(t *testing.T) {
+		if err := db.WaitForToken(ctx, "invalid token"); err == nil {
+			t.Fatal("cannot handle error")
+		}
+	})
+}
+
+func TestExecutionTrace(t *testing.T) {
+	db, err := Open("sqlite3", "?parseTime=true&loc=Asia%2FTokyo")
+	checkErr(t, err)
+	defer db.Close()
+	defer func() { onTestQuery = func(string) error { return nil } }()
+
+	// fail query at second shard
+	failAtSecondShard := func() {
+		queryCount := 0
+		onTestQuery = func(string) error {
+			queryCount++
+			if queryCount == 2 {
+				return errors.New("shard is down")
+			}
+			return nil
+		}
+	}
+	validateTrace := func(t *testing.T, err error) {
+		trace, ok := exec.TraceOf(err)
+		if !ok {
+			t.Fatalf("error doesn't have trace %+v", err)
+		}
+		if len(trace.Shards) != 2 {
+			t.Fatalf("invalid trace %s", trace)
+		}
+		if trace.Shards[0].ShardName != "user_shard_1" || trace.Shards[0].Err != nil {
+			t.Fatalf("invalid trace %s", trace)
+		}
+		if trace.Shards[1].ShardName != "user_shard_2" || trace.Shards[1].Err == nil {
+			t.Fatalf("invalid trace %s", trace)
+		}
+		if !strings.Contains(err.Error(), "user_shard_1:ok") || !strings.Contains(err.Error(), "user_shard_2:failed") {
+			t.Fatalf("error message doesn't have trace %s", err)
+		}
+	}
+	t.Run("select", func(t *testing.T) {
+		failAtSecondShard()
+		_, err := db.Query("select * from users")
+		validateTrace(t, err)
+	})
+	t.Run("delete", func(t *testing.T) {
+		failAtSecondShard()
+		_, err := db.Exec("delete from users")
+		validateTrace(t, err)
+	})
+	t.Run("single shard", func(t *testing.T) {
+		onTestQuery = func(string) error { return errors.New("shard is down") }
+		if _, err := db.Query("select * from users where id = 1"); err == nil {
+			t.Fatal("cannot handle error")
+		} else if _, ok := exec.TraceOf(err); ok {
+			t.Fatal("query for single shard must not have trace")
+		}
+	})
+}
+
+func TestDBConn(t *testing.T) {
+	db, err := Open("sqlite3", "?parseTime=true&loc=Asia%2FTokyo")
+	checkErr(t, err)
+	defer db.Close()
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	checkErr(t, err)
+	pinnedNum := func() int {
+		num := 0
+		checkErr(t, conn.Raw(func(driverConn interface{}) error {
+			num++
+			return nil
+		}))
+		return num
+	}
+	if pinnedNum() != 0 {
+		t.Fatal("connection must be pinned lazily")
+	}
+	t.Run("single shard", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			if _, err := conn.ExecContext(ctx, "update users set name = 'alice' where id = 1"); err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+		}
+		var (
+			name      string
+			age       int
+			isGod     bool
+			point     float32
+			power     int32
+			createdAt time.Time
+		)
+		row := conn.QueryRowContext(ctx, "select * from users where id = 1")
+		checkErr(t, row.Scan(&name, &age, &isGod, &point, &power, &createdAt))
+		if name != "alice" {
+			t.Fatal("cannot scan")
+		}
+		if pinnedNum() != 1 {
+			t.Fatal("queries for the same shard must use the same connection")
+		}
+	})
+	t.Run("all shards", func(t *testing.T) {
+		rows, err := conn.QueryContext(ctx, "select name from users")
+		checkErr(t, err)
+		checkErr(t, rows.Close())
+		if pinnedNum() != 2 {
+			t.Fatal("cannot pin connection of each shard")
+		}
+	})
+	t.Run("prepare", func(t *testing.T) {
+		stmt, err := conn.PrepareContext(ctx, "select name from users where id = ?")
+		checkErr(t, err)
+		rows, err := stmt.QueryContext(ctx, 1)
+		checkErr(t, err)
+		checkErr(t, rows.Close())
+		checkErr(t, stmt.Close())
+		stmt, err = conn.PrepareContext(ctx, "select name from user_stages where id = ?")
+		checkErr(t, err)
+		checkErr(t, stmt.Close())
+		if pinnedNum() != 3 {
+			t.Fatal("cannot pin connection of not sharded table")
+		}
+	})
+	checkErr(t, conn.Close())
+	if _, err := conn.ExecContext(ctx, "update users set name = 'alice' where id = 1"); err == nil {
+		t.Fatal("cannot handle closed connection")
+	}
+}
+
+func TestNamedArgs(t *testing.T) {
+	db, err := Open("sqlite3", "?parseTime=true&loc=Asia%2FTokyo")
+	checkErr(t, err)
+	defer db.Close()
+	defer func() { onTestQuery = func(string) error { return nil } }()
+
+	queries := []string{}
+	onTestQuery = func(query string) error {
+		queries = append(queries, query)
+		return nil
+	}
+	t.Run("exec", func(t *testing.T) {
+		queries = []string{}
+		if _, err := db.Exec("update users set name = :name where id = :id", Named("id", 1), Named("name", "bob")); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if len(queries) != 1 || queries[0] != "update users set name = ? where id = ?" {
+			t.Fatalf("cannot route query by named argument. %v", queries)
+		}
+	})
+	t.Run("query", func(t *testing.T) {
+		queries = []string{}
+		rows, err := db.Query("select * from users where id = @id", Named("id", 1))
+		checkErr(t, err)
+		checkErr(t, rows.Close())
+		if len(queries) != 1 || queries[0] != "select * from users where id = ?" {
+			t.Fatalf("cannot route query by named argument. %v", queries)
+		}
+	})
+	t.Run("prepare", func(t *testing.T) {
+		stmt, err := db.Prepare("select * from users where id = :id")
+		checkErr(t, err)
+		defer stmt.Close()
+		rows, err := stmt.Query(Named("id", 1))
+		checkErr(t, err)
+		checkErr(t, rows.Close())
+	})
+	t.Run("unknown name", func(t *testing.T) {
+		if _, err := db.Exec("update users set name = 'bob' where id = :id", Named("user_id", 1)); err == nil {
+			t.Fatal("cannot handle unknown named argument")
+		}
+	})
+}
+
+func TestRoutingMetrics(t *testing.T) {
+	db, err := Open("sqlite3", "?parseTime=true&loc=Asia%2FTokyo")
+	checkErr(t, err)
+	defer db.Close()
+	metrics.ResetRoutingCounts()
+	defer metrics.ResetRoutingCounts()
+
+	if _, err := db.Exec("update users set name = 'bob' where id = 1"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	for _, queryText := range []string{
+		"select * from users where id = 1",
+		"select * from users where id in (1, 2)",
+		"select * from users",
+	} {
+		rows, err := db.Query(queryText)
+		checkErr(t, err)
+		checkErr(t, rows.Close())
+	}
+	counts := map[metrics.RoutingKey]uint64{}
+	for _, count := range metrics.RoutingCounts() {
+		counts[count.RoutingKey] = count.Count
+	}
+	for key, count := range map[metrics.RoutingKey]uint64{
+		{Table: "users", Kind: metrics.KindUpdate, Mode: metrics.SingleShard}: 1,
+		{Table: "users", Kind: metrics.KindSelect, Mode: metrics.SingleShard}: 1,
+		{Table: "users", Kind: metrics.KindSelect, Mode: metrics.MultiShard}:  1,
+		{Table: "users", Kind: metrics.KindSelect, Mode: metrics.Broadcast}:   1,
+	} {
+		if counts[key] != count {
+			t.Fatalf("invalid counter of %+v. %d", key, counts[key])
+		}
+	}
+	if len(counts) != 4 {
+		t.Fatalf("unexpected counters %v", counts)
+	}
+}
+
+func TestEstimateCount(t *testing.T) {
+	db, err := Open("sqlite3", "?parseTime=true&loc=Asia%2FTokyo")
+	checkErr(t, err)
+	defer db.Close()
+	for _, test := range []struct {
+		query    string
+		args     []interface{}
+		expected int64
+	}{
+		{query: "select count(*) from users", expected: 20},
+		{query: "select * from users where id = 1", expected: 10},
+		{query: "select * from users where id = ?", args: []interface{}{1}, expected: 10},
+		{query: "select * from user_stages", expected: 10},
+	} {
+		count, err := db.EstimateCount(test.query, test.args...)
+		checkErr(t, err)
+		if count != test.expected {
+			t.Fatalf("invalid estimate of %s. %d", test.query, count)
+		}
+	}
+	if _, err := db.EstimateCount("update users set name = 'bob' where id = 1"); err == nil {
+		t.Fatal("cannot handle write query")
+	}
+}
+
 func TestError(t *testing.T) {
 	adapter.Register("test", &TestAdapter{adapterName: "test"})
 	confPath := filepath.Join(path.ThisDirPath(), "error_config.yml")
@@ -977,3 +1440,31 @@ func TestError(t *testing.T) {
 	testQueryRowContextTransactionError(t, tx)
 	checkErr(t, tx.Commit())
 }
+
+func TestRowsWithCancelledContext(t *testing.T) {
+	conn, err := core.Open("sqlite3", "")
+	checkErr(t, err)
+	defer conn.Close()
+	cores := []*core.Rows{}
+	for i := 0; i < 2; i++ {
+		rows, err := conn.Query("select * from users")
+		checkErr(t, err)
+		cores = append(cores, rows)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	rows := newRows(ctx, cores)
+	defer rows.Close()
+	if !rows.Next() {
+		t.Fatal("cannot read first row")
+	}
+	cancel()
+	if rows.Next() {
+		t.Fatal("rows must be stopped by cancelled context")
+	}
+	if errors.Cause(rows.Err()) != context.Canceled {
+		t.Fatalf("unexpected error %v", rows.Err())
+	}
+	if _, err := cores[1].Columns(); err == nil {
+		t.Fatal("rows of shard not read yet must be closed")
+	}
+}
@@ -0,0 +1,12 @@
+// +build !go1.15
+
+package sql
+
+import (
+	core "database/sql"
+)
+
+// maxIdleTimeClosed is not supported before Go 1.15
+func maxIdleTimeClosed(stats core.DBStats) int64 {
+	return 0
+}
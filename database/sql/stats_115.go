@@ -0,0 +1,11 @@
+// +build go1.15
+
+package sql
+
+import (
+	core "database/sql"
+)
+
+func maxIdleTimeClosed(stats core.DBStats) int64 {
+	return stats.MaxIdleTimeClosed
+}
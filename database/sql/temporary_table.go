@@ -0,0 +1,128 @@
+package sql
+
+import (
+	"context"
+	core "database/sql"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/connection"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+// temporaryTableResult result of 'CREATE TEMPORARY TABLE' or 'DROP TEMPORARY TABLE' of not existing table
+type temporaryTableResult struct{}
+
+func (r *temporaryTableResult) LastInsertId() (int64, error) {
+	return 0, nil
+}
+
+func (r *temporaryTableResult) RowsAffected() (int64, error) {
+	return 0, nil
+}
+
+// execTemporaryTable creates temporary table on database where transaction started, or drops it.
+// Temporary table is not defined in configuration file, so transaction must access database before creating it.
+func (proxy *Tx) execTemporaryTable(ctx context.Context, query *sqlparser.TemporaryTableQuery) (Result, error) {
+	if proxy.tx == nil {
+		return nil, errors.Errorf("cannot execute %s before transaction accesses any database", query.QueryType())
+	}
+	tableName := query.Table()
+	if query.QueryType() == sqlparser.CreateTemporaryTable {
+		result, err := proxy.tx.CreateTemporaryTable(ctx, tableName, query.Text, query.Args...)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return result, nil
+	}
+	if _, exists := proxy.tx.TemporaryTableConnection(tableName); !exists && query.IfExists {
+		return &temporaryTableResult{}, nil
+	}
+	result, err := proxy.tx.DropTemporaryTable(ctx, tableName)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return result, nil
+}
+
+// temporaryTableConnection returns connection to database having temporary table referenced by query.
+// If query doesn't reference temporary table created in transaction, returns nil.
+func (proxy *Tx) temporaryTableConnection(queryText string, args ...interface{}) (connection.Connection, sqlparser.Query, error) {
+	if proxy.tx == nil || !proxy.tx.HasTemporaryTables() {
+		return nil, nil, nil
+	}
+	parser, err := sqlparser.New()
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	query, err := parser.Parse(queryText, args...)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	conn, exists := proxy.tx.TemporaryTableConnection(query.Table())
+	if !exists {
+		return nil, nil, nil
+	}
+	return conn, query, nil
+}
+
+// execOnTemporaryTable executes statement of temporary table. If query is not for temporary table, returns false.
+func (proxy *Tx) execOnTemporaryTable(ctx context.Context, queryText string, args ...interface{}) (Result, bool, error) {
+	if query, ok := sqlparser.ParseTemporaryTable(queryText, args...); ok {
+		result, err := proxy.execTemporaryTable(ctx, query)
+		if err != nil {
+			return nil, false, errors.WithStack(err)
+		}
+		return result, true, nil
+	}
+	conn, query, err := proxy.temporaryTableConnection(queryText, args...)
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	if conn == nil {
+		return nil, false, nil
+	}
+	if query.QueryType() == sqlparser.Select {
+		return nil, false, errors.New("SELECT query for temporary table must be executed by Query or QueryRow")
+	}
+	result, err := proxy.tx.ExecTemporaryTable(ctx, query.Table(), queryText, args...)
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	return result, true, nil
+}
+
+// queryOnTemporaryTable executes SELECT query for temporary table. If query is not for temporary table, returns false.
+func (proxy *Tx) queryOnTemporaryTable(ctx context.Context, queryText string, args ...interface{}) (*Rows, bool, error) {
+	conn, query, err := proxy.temporaryTableConnection(queryText, args...)
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	if conn == nil {
+		return nil, false, nil
+	}
+	rows, err := proxy.tx.Query(ctx, conn, queryText, args...)
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	result, err := newQueryRows(ctx, []*core.Rows{rows}, query)
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	return result, true, nil
+}
+
+// queryRowOnTemporaryTable executes SELECT query for temporary table. If query is not for temporary table, returns nil.
+func (proxy *Tx) queryRowOnTemporaryTable(ctx context.Context, queryText string, args ...interface{}) *Row {
+	conn, _, err := proxy.temporaryTableConnection(queryText, args...)
+	if err != nil {
+		return &Row{err: err}
+	}
+	if conn == nil {
+		return nil
+	}
+	row, err := proxy.tx.QueryRow(ctx, conn, queryText, args...)
+	if err != nil {
+		return &Row{err: err}
+	}
+	return &Row{core: row}
+}
@@ -4,11 +4,14 @@ import (
 	"context"
 	core "database/sql"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
 	"go.knocknote.io/octillery/connection"
 	"go.knocknote.io/octillery/debug"
 	"go.knocknote.io/octillery/exec"
+	"go.knocknote.io/octillery/logging"
 	"go.knocknote.io/octillery/sqlparser"
 )
 
@@ -24,6 +27,10 @@ type QueryLog struct {
 	Query        string        `json:"query"`
 	Args         []interface{} `json:"args"`
 	LastInsertID int64         `json:"lastInsertId"`
+	// DSN of database the write query is executed on
+	DSN string `json:"dsn,omitempty"`
+	// replication position of database after commit ( e.g. GTID of MySQL ). set only if 'capture_replication_position' is enabled
+	ReplicationPosition string `json:"replicationPosition,omitempty"`
 }
 
 // SetBeforeCommitCallback set function for it is callbacked before commit.
@@ -60,6 +67,26 @@ type Tx struct {
 	beforeCommitCallback       func([]*QueryLog) error
 	afterCommitSuccessCallback func() error
 	afterCommitFailureCallback func(bool, []*QueryLog) error
+	// savepoints set before any database is accessed
+	savepoints []string
+	// 1 after Commit or Rollback is called
+	done int32
+}
+
+// markDone marks transaction as committed or rolled back. If it is already done, returns ErrTxDone
+func (proxy *Tx) markDone() error {
+	if !atomic.CompareAndSwapInt32(&proxy.done, 0, 1) {
+		return ErrTxDone
+	}
+	return nil
+}
+
+// doneErr returns ErrTxDone if transaction is already committed or rolled back
+func (proxy *Tx) doneErr() error {
+	if atomic.LoadInt32(&proxy.done) != 0 {
+		return ErrTxDone
+	}
+	return nil
 }
 
 // BeforeCommitCallback set callback function for before commit
@@ -102,6 +129,9 @@ func (proxy *Tx) connectionAndQuery(queryText string, args ...interface{}) (*con
 	if err != nil {
 		return nil, nil, errors.WithStack(err)
 	}
+	if err := exec.ValidatePermission(conn, query); err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
 	return conn, query, nil
 }
 
@@ -109,9 +139,11 @@ func (proxy *Tx) convertQueryLogs(connQueries []*connection.QueryLog) []*QueryLo
 	queries := []*QueryLog{}
 	for _, query := range connQueries {
 		queries = append(queries, &QueryLog{
-			Query:        query.Query,
-			Args:         query.Args,
-			LastInsertID: query.LastInsertID,
+			Query:               query.Query,
+			Args:                query.Args,
+			LastInsertID:        query.LastInsertID,
+			DSN:                 query.DSN,
+			ReplicationPosition: query.ReplicationPosition,
 		})
 	}
 	return queries
@@ -134,14 +166,56 @@ func (proxy *Tx) begin(conn *connection.DBConnection) {
 			return errors.WithStack(globalAfterCommitFailureCallback(proxy, isCritical, failureQueries))
 		})
 	}
+	for _, name := range proxy.savepoints {
+		// no database is accessed by tx yet, so savepoint is only recorded and never fails
+		tx.Savepoint(proxy.ctx, name)
+	}
+	proxy.savepoints = nil
 	proxy.tx = tx
 }
 
 func (proxy *Tx) execProxy(ctx context.Context, queryText string, args ...interface{}) (Result, error) {
+	if err := proxy.doneErr(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	queryText, args, err := bindNamedArgs(queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	statements, err := splitMultiStatements(ctx, proxy.connMgr, queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if statements != nil {
+		result, err := execMultiStatements(ctx, statements, proxy.execProxy)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return result, nil
+	}
+	if query, ok := sqlparser.ParseSavepoint(queryText); ok {
+		result, err := proxy.execSavepoint(ctx, query)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return result, nil
+	}
+	if result, ok, err := proxy.execOnTemporaryTable(ctx, queryText, args...); err != nil {
+		return nil, errors.WithStack(err)
+	} else if ok {
+		return result, nil
+	}
 	conn, query, err := proxy.connectionAndQuery(queryText, args...)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	query, args, err = encryptQuery(query, queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := assertForeignKeys(ctx, query, proxy.queryRowProxy); err != nil {
+		return nil, errors.WithStack(err)
+	}
 	proxy.begin(conn)
 	if conn.IsShard {
 		result, err := exec.NewQueryExecutor(ctx, conn, proxy.tx, query).Exec()
@@ -150,82 +224,167 @@ func (proxy *Tx) execProxy(ctx context.Context, queryText string, args ...interf
 		}
 		return result, nil
 	}
+	if isRequiredReturningID(conn, query) {
+		returningQueryText := conn.QueryWithReturningID(queryText)
+		done := conn.StartQuery(ctx, query.Table(), conn, returningQueryText, args)
+		result, err := proxy.tx.ExecReturningID(ctx, conn, returningQueryText, args...)
+		done(result, err)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return result, nil
+	}
+	done := conn.StartQuery(ctx, query.Table(), conn, queryText, args)
 	result, err := proxy.tx.Exec(ctx, conn, queryText, args...)
+	done(result, err)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return result, nil
 }
 
-func (proxy *Tx) prepareProxy(ctx context.Context, queryText string) (*core.Stmt, connection.Connection, error) {
-	conn, query, err := proxy.connectionAndQuery(queryText)
+func (proxy *Tx) prepareProxy(ctx context.Context, queryText string) (*Stmt, error) {
+	if err := proxy.doneErr(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if isMultiStatement(queryText) {
+		return nil, errors.New("Prepare doesn't support multi statement query")
+	}
+	conn, query, err := connectionAndPreparedQuery(proxy.connMgr, queryText)
 	if err != nil {
-		return nil, nil, errors.WithStack(err)
+		return nil, errors.WithStack(err)
+	}
+	if err := assertPreparable(query); err != nil {
+		return nil, errors.WithStack(err)
 	}
 	proxy.begin(conn)
 	if conn.IsShard {
-		stmt, err := exec.NewQueryExecutor(ctx, conn, proxy.tx, query).Prepare()
-		if err != nil {
-			return nil, nil, errors.WithStack(err)
-		}
-		return stmt, conn, nil
+		// statement is prepared lazily on the shard decided by query arguments
+		return &Stmt{shard: exec.NewShardStmt(conn, proxy.tx, queryText), query: queryText, queryRow: proxy.queryRowProxy}, nil
 	}
 	stmt, err := proxy.tx.Prepare(ctx, conn, queryText)
 	if err != nil {
-		return nil, nil, errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
-	return stmt, conn, nil
+	return &Stmt{
+		core:     stmt,
+		query:    queryText,
+		tx:       proxy.tx,
+		conn:     conn,
+		queryRow: proxy.queryRowProxy,
+	}, nil
 }
 
-func (proxy *Tx) stmtProxy(ctx context.Context, stmt *Stmt) (*core.Stmt, connection.Connection, error) {
+func (proxy *Tx) stmtProxy(ctx context.Context, stmt *Stmt) (*Stmt, error) {
+	if err := proxy.doneErr(); err != nil {
+		return nil, errors.WithStack(err)
+	}
 	if stmt == nil {
-		return nil, nil, errors.New("invalid stmt")
+		return nil, errors.New("invalid stmt")
 	}
-	conn, query, err := proxy.connectionAndQuery(stmt.query)
+	conn, _, err := connectionAndPreparedQuery(proxy.connMgr, stmt.query)
 	if err != nil {
-		return nil, nil, errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 	proxy.begin(conn)
+	if stmt.shard != nil {
+		return &Stmt{shard: stmt.shard.WithTx(proxy.tx), query: stmt.query, queryRow: proxy.queryRowProxy}, nil
+	}
 	if conn.IsShard {
-		stmt, err := exec.NewQueryExecutor(ctx, conn, proxy.tx, query).Stmt()
-		if err != nil {
-			return nil, nil, errors.WithStack(err)
-		}
-		return stmt, conn, nil
+		return &Stmt{shard: exec.NewShardStmt(conn, proxy.tx, stmt.query), query: stmt.query, queryRow: proxy.queryRowProxy}, nil
 	}
 	result, err := proxy.tx.Stmt(ctx, conn, stmt.core)
 	if err != nil {
-		return nil, nil, errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
-	return result, conn, nil
+	return &Stmt{
+		core:     result,
+		query:    stmt.query,
+		tx:       proxy.tx,
+		conn:     conn,
+		queryRow: proxy.queryRowProxy,
+	}, nil
 }
 
 func (proxy *Tx) queryProxy(ctx context.Context, queryText string, args ...interface{}) (*Rows, error) {
+	if err := proxy.doneErr(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	queryText, args, err := bindNamedArgs(queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	statements, err := splitMultiStatements(ctx, proxy.connMgr, queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if statements != nil {
+		rows, err := queryMultiStatements(ctx, statements, proxy.queryProxy)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return rows, nil
+	}
+	if rows, ok, err := proxy.queryOnTemporaryTable(ctx, queryText, args...); err != nil {
+		return nil, errors.WithStack(err)
+	} else if ok {
+		return rows, nil
+	}
 	conn, query, err := proxy.connectionAndQuery(queryText, args...)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	query, args, err = encryptQuery(query, queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 	proxy.begin(conn)
 	if conn.IsShard {
 		rows, err := exec.NewQueryExecutor(ctx, conn, proxy.tx, query).Query()
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
-		return &Rows{cores: rows}, nil
+		return newQueryRows(ctx, rows, query)
 	}
 
+	done := conn.StartQuery(ctx, query.Table(), conn, queryText, args)
 	rows, err := proxy.tx.Query(ctx, conn, queryText, args...)
+	done(nil, err)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	return &Rows{cores: []*core.Rows{rows}}, nil
+	return newQueryRows(ctx, []*core.Rows{rows}, query)
 }
 
 func (proxy *Tx) queryRowProxy(ctx context.Context, queryText string, args ...interface{}) *Row {
+	if err := proxy.doneErr(); err != nil {
+		return &Row{err: err}
+	}
+	if isMultiStatement(queryText) {
+		return &Row{err: errors.New("QueryRow doesn't support multi statement query")}
+	}
+	queryText, args, err := bindNamedArgs(queryText, args)
+	if err != nil {
+		return &Row{err: err}
+	}
+	if row := proxy.queryRowOnTemporaryTable(ctx, queryText, args...); row != nil {
+		return row
+	}
 	conn, query, err := proxy.connectionAndQuery(queryText, args...)
 	if err != nil {
 		return &Row{err: err}
 	}
+	query, args, err = encryptQuery(query, queryText, args)
+	if err != nil {
+		return &Row{err: err}
+	}
+	if encrypted, err := isEncryptedSelect(query); err != nil {
+		return &Row{err: err}
+	} else if encrypted {
+		// columns are required for decryption, so row is read by Rows
+		rows, err := proxy.queryProxy(ctx, queryText, args...)
+		return &Row{rows: rows, err: err}
+	}
 	proxy.begin(conn)
 	if conn.IsShard {
 		row, err := exec.NewQueryExecutor(ctx, conn, proxy.tx, query).QueryRow()
@@ -234,7 +393,9 @@ func (proxy *Tx) queryRowProxy(ctx context.Context, queryText string, args ...in
 		}
 		return &Row{core: row}
 	}
+	done := conn.StartQuery(ctx, query.Table(), conn, queryText, args)
 	row, err := proxy.tx.QueryRow(ctx, conn, queryText, args...)
+	done(nil, err)
 	if err != nil {
 		return &Row{err: err}
 	}
@@ -244,6 +405,9 @@ func (proxy *Tx) queryRowProxy(ctx context.Context, queryText string, args ...in
 // Commit the compatible method of Commit in 'database/sql' package.
 func (proxy *Tx) Commit() error {
 	debug.Printf("Tx.Commit()")
+	if err := proxy.markDone(); err != nil {
+		return errors.WithStack(err)
+	}
 	if proxy.tx == nil {
 		return nil
 	}
@@ -259,6 +423,8 @@ func (proxy *Tx) Commit() error {
 		return errors.WithStack(proxy.afterCommitFailureCallback(isCriticalError, queries))
 	}
 	if err := proxy.tx.Commit(); err != nil {
+		// transactions of shards may not be finished ( e.g. by error of BeforeCommitCallback ), so they can be rolled back
+		atomic.StoreInt32(&proxy.done, 0)
 		return errors.WithStack(err)
 	}
 	return nil
@@ -267,6 +433,9 @@ func (proxy *Tx) Commit() error {
 // Rollback the compatible method of Rollback in 'database/sql' package.
 func (proxy *Tx) Rollback() error {
 	debug.Printf("Tx.Rollback()")
+	if err := proxy.markDone(); err != nil {
+		return errors.WithStack(err)
+	}
 	if err := proxy.tx.Rollback(); err != nil {
 		return errors.WithStack(err)
 	}
@@ -276,67 +445,49 @@ func (proxy *Tx) Rollback() error {
 // PrepareContext the compatible method of PrepareContext in 'database/sql' package.
 func (proxy *Tx) PrepareContext(ctx context.Context, query string) (*Stmt, error) {
 	debug.Printf("Tx.PrepareContext: %s", query)
-	stmt, conn, err := proxy.prepareProxy(ctx, query)
+	stmt, err := proxy.prepareProxy(ctx, query)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	return &Stmt{
-		core:  stmt,
-		query: query,
-		tx:    proxy.tx,
-		conn:  conn,
-	}, nil
+	return stmt, nil
 }
 
 // Prepare the compatible method of Prepare in 'database/sql' package.
 func (proxy *Tx) Prepare(query string) (*Stmt, error) {
 	debug.Printf("Tx.Prepare: %s", query)
-	stmt, conn, err := proxy.prepareProxy(nil, query)
+	stmt, err := proxy.prepareProxy(nil, query)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	return &Stmt{
-		core:  stmt,
-		query: query,
-		tx:    proxy.tx,
-		conn:  conn,
-	}, nil
+	return stmt, nil
 }
 
 // StmtContext the compatible method of StmtContext in 'database/sql' package.
 func (proxy *Tx) StmtContext(ctx context.Context, stmt *Stmt) *Stmt {
 	debug.Printf("Tx.StmtContext")
-	result, conn, err := proxy.stmtProxy(ctx, stmt)
+	result, err := proxy.stmtProxy(ctx, stmt)
 	if err != nil {
 		return &Stmt{err: err}
 	}
-	return &Stmt{
-		core:  result,
-		query: stmt.query,
-		tx:    proxy.tx,
-		conn:  conn,
-	}
+	return result
 }
 
 // Stmt the compatible method of Stmt in 'database/sql' package.
 func (proxy *Tx) Stmt(stmt *Stmt) *Stmt {
 	debug.Printf("Tx.Stmt")
-	result, conn, err := proxy.stmtProxy(nil, stmt)
+	result, err := proxy.stmtProxy(nil, stmt)
 	if err != nil {
 		return &Stmt{err: err}
 	}
-	return &Stmt{
-		core:  result,
-		query: stmt.query,
-		tx:    proxy.tx,
-		conn:  conn,
-	}
+	return result
 }
 
 // ExecContext the compatible method of ExecContext in 'database/sql' package.
 func (proxy *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (Result, error) {
 	debug.Printf("Tx.ExecContext: %s", query)
+	start := time.Now()
 	result, err := proxy.execProxy(ctx, query, args...)
+	logging.LogQuery(start, query, args, err)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -346,7 +497,9 @@ func (proxy *Tx) ExecContext(ctx context.Context, query string, args ...interfac
 // Exec the compatible method of Exec in 'database/sql' package.
 func (proxy *Tx) Exec(query string, args ...interface{}) (Result, error) {
 	debug.Printf("Tx.Exec: %s", query)
+	start := time.Now()
 	result, err := proxy.execProxy(nil, query, args...)
+	logging.LogQuery(start, query, args, err)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -356,7 +509,9 @@ func (proxy *Tx) Exec(query string, args ...interface{}) (Result, error) {
 // QueryContext the compatible method of QueryContext in 'database/sql' package.
 func (proxy *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
 	debug.Printf("Tx.QueryContext: %s", query)
+	start := time.Now()
 	rows, err := proxy.queryProxy(ctx, query, args...)
+	logging.LogQuery(start, query, args, err)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -366,7 +521,9 @@ func (proxy *Tx) QueryContext(ctx context.Context, query string, args ...interfa
 // Query the compatible method of Query in 'database/sql' package.
 func (proxy *Tx) Query(query string, args ...interface{}) (*Rows, error) {
 	debug.Printf("Tx.Query: %s", query)
+	start := time.Now()
 	rows, err := proxy.queryProxy(nil, query, args...)
+	logging.LogQuery(start, query, args, err)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -376,11 +533,17 @@ func (proxy *Tx) Query(query string, args ...interface{}) (*Rows, error) {
 // QueryRowContext the compatible method of QueryRowContext in 'database/sql' package.
 func (proxy *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
 	debug.Printf("Tx.QueryRowContext: %s", query)
-	return proxy.queryRowProxy(ctx, query, args...)
+	start := time.Now()
+	row := proxy.queryRowProxy(ctx, query, args...)
+	logging.LogQuery(start, query, args, row.err)
+	return row
 }
 
 // QueryRow the compatible method of QueryRow in 'database/sql' package.
 func (proxy *Tx) QueryRow(query string, args ...interface{}) *Row {
 	debug.Printf("Tx.QueryRow: %s", query)
-	return proxy.queryRowProxy(nil, query, args...)
+	start := time.Now()
+	row := proxy.queryRowProxy(nil, query, args...)
+	logging.LogQuery(start, query, args, row.err)
+	return row
 }
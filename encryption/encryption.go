@@ -0,0 +1,105 @@
+// Package encryption provides encryptors for columns configured by 'encrypted_columns' of table.
+//
+// Values of encrypted columns are encrypted by octillery before INSERT/UPDATE query is routed to shards,
+// and decrypted when rows are scanned, so application doesn't have to encrypt them at every call site.
+// Encrypted values are stored as base64 text, so VARCHAR or TEXT column with enough length can be used.
+//
+//	key := ... // 16, 24 or 32 bytes
+//	encryptor, _ := encryption.NewAESGCMEncryptor(key)
+//	encryption.Register("user_secret", encryptor)
+//
+// and configure table like the following.
+//
+//	tables:
+//	  users:
+//	    encrypted_columns:
+//	      email: user_secret
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	encryptorsMu sync.RWMutex
+	encryptors   = map[string]Encryptor{}
+)
+
+// Encryptor encrypts and decrypts values of columns.
+// Encrypted values are encoded to base64 text by octillery, so type of encrypted column should be VARCHAR or TEXT with enough length.
+type Encryptor interface {
+	// Encrypt returns encrypted value of plaintext
+	Encrypt(plaintext []byte) ([]byte, error)
+	// Decrypt returns plaintext of value encrypted by Encrypt
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// Register register encryptor with name used by 'encrypted_columns' of configuration
+func Register(name string, encryptor Encryptor) {
+	encryptorsMu.Lock()
+	defer encryptorsMu.Unlock()
+	if encryptor == nil {
+		panic("register encryptor is nil")
+	}
+	if _, dup := encryptors[name]; dup {
+		panic("register called twice for encryptor " + name)
+	}
+	encryptors[name] = encryptor
+}
+
+// EncryptorByName returns registered encryptor by name
+func EncryptorByName(name string) (Encryptor, error) {
+	encryptorsMu.RLock()
+	defer encryptorsMu.RUnlock()
+	encryptor, exists := encryptors[name]
+	if !exists {
+		return nil, errors.Errorf("cannot find encryptor %s. it must be registered by encryption.Register", name)
+	}
+	return encryptor, nil
+}
+
+// AESGCMEncryptor encrypts values by AES-GCM. Random nonce is prepended to each encrypted value.
+type AESGCMEncryptor struct {
+	aead cipher.AEAD
+}
+
+// NewAESGCMEncryptor creates instance of AESGCMEncryptor. key must be 16, 24 or 32 bytes for AES-128, AES-192 or AES-256.
+func NewAESGCMEncryptor(key []byte) (*AESGCMEncryptor, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &AESGCMEncryptor{aead: aead}, nil
+}
+
+// Encrypt encrypts plaintext by random nonce
+func (e *AESGCMEncryptor) Encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return e.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt decrypts value encrypted by Encrypt
+func (e *AESGCMEncryptor) Decrypt(ciphertext []byte) ([]byte, error) {
+	nonceSize := e.aead.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, errors.New("cannot decrypt value. it is shorter than nonce")
+	}
+	plaintext, err := e.aead.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot decrypt value")
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,55 @@
+package encryption
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAESGCMEncryptor(t *testing.T) {
+	if _, err := NewAESGCMEncryptor([]byte("short")); err == nil {
+		t.Fatal("cannot validate key size")
+	}
+	encryptor, err := NewAESGCMEncryptor([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	plaintext := []byte("alice")
+	encrypted, err := encryptor.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if bytes.Contains(encrypted, plaintext) {
+		t.Fatal("plaintext is contained in encrypted value")
+	}
+	decrypted, err := encryptor.Decrypt(encrypted)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("cannot decrypt value. %s", decrypted)
+	}
+	encrypted[len(encrypted)-1] ^= 0xff
+	if _, err := encryptor.Decrypt(encrypted); err == nil {
+		t.Fatal("cannot detect tampered value")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	encryptor, err := NewAESGCMEncryptor([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	Register("register_test", encryptor)
+	if e, err := EncryptorByName("register_test"); err != nil || e != encryptor {
+		t.Fatalf("cannot get registered encryptor. err = %v", err)
+	}
+	if _, err := EncryptorByName("unknown"); err == nil {
+		t.Fatal("cannot return error for unknown encryptor")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("cannot panic for duplicate name")
+		}
+	}()
+	Register("register_test", encryptor)
+}
@@ -0,0 +1,393 @@
+package exec
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+// aggregateMerger merges results of aggregate functions ( COUNT/SUM/MIN/MAX/AVG ) selected from all shards into a row.
+type aggregateMerger struct {
+	columns []*sqlparser.AggregateColumn
+	// index of first column in shard's result for each column. AVG uses two columns ( SUM and COUNT )
+	shardColumnIndexes []int
+	// query executed on each shard
+	queryText string
+	args      []interface{}
+}
+
+// newAggregateMerger returns nil if query cannot be merged by aggregateMerger
+func newAggregateMerger(query *sqlparser.QueryBase) *aggregateMerger {
+	columns := query.Aggregates()
+	if len(columns) == 0 {
+		return nil
+	}
+	merger := &aggregateMerger{
+		columns:            columns,
+		shardColumnIndexes: make([]int, len(columns)),
+		queryText:          query.Text,
+		args:               query.Args,
+	}
+	hasAvg := false
+	shardColumnIndex := 0
+	selectExprs := vtparser.SelectExprs{}
+	for idx, column := range columns {
+		merger.shardColumnIndexes[idx] = shardColumnIndex
+		if column.Func != sqlparser.AggregateAvg {
+			selectExprs = append(selectExprs, &vtparser.AliasedExpr{Expr: column.Expr})
+			shardColumnIndex++
+			continue
+		}
+		hasAvg = true
+		// AVG of all shards cannot be calculated by AVG of each shard, so select SUM and COUNT instead of it
+		selectExprs = append(selectExprs,
+			&vtparser.AliasedExpr{Expr: &vtparser.FuncExpr{Name: vtparser.NewColIdent("sum"), Exprs: column.Expr.Exprs}},
+			&vtparser.AliasedExpr{Expr: &vtparser.FuncExpr{Name: vtparser.NewColIdent("count"), Exprs: column.Expr.Exprs}},
+		)
+		shardColumnIndex += 2
+	}
+	if hasAvg {
+		stmt := *query.Stmt.(*vtparser.Select)
+		stmt.SelectExprs = selectExprs
+		merger.queryText = sqlparser.StringWithPlaceholder(&stmt)
+	}
+	return merger
+}
+
+func (m *aggregateMerger) shardQuery() (string, []interface{}) {
+	return m.queryText, m.args
+}
+
+// merge reads all rows of shards and returns merged values. rows are closed after reading.
+func (m *aggregateMerger) merge(allRows []*sql.Rows) (*mergedRowsSet, error) {
+	defer func() {
+		for _, rows := range allRows {
+			rows.Close()
+		}
+	}()
+	accumulators := make([]*aggregateAccumulator, len(m.columns))
+	for idx, column := range m.columns {
+		accumulators[idx] = &aggregateAccumulator{fn: column.Func}
+	}
+	var columnNames []string
+	for _, rows := range allRows {
+		shardColumns, err := rows.Columns()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if columnNames == nil {
+			columnNames = m.columnNames(shardColumns)
+		}
+		columnTypes, err := rows.ColumnTypes()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for rows.Next() {
+			values := make([]interface{}, len(shardColumns))
+			dest := make([]interface{}, len(shardColumns))
+			for idx := range values {
+				dest[idx] = &values[idx]
+			}
+			if err := rows.Scan(dest...); err != nil {
+				return nil, errors.WithStack(err)
+			}
+			for idx, accumulator := range accumulators {
+				shardColumnIndex := m.shardColumnIndexes[idx]
+				if accumulator.fn == sqlparser.AggregateAvg {
+					if err := accumulator.addAvg(values[shardColumnIndex], values[shardColumnIndex+1]); err != nil {
+						return nil, errors.Wrapf(err, "cannot merge %s", columnNames[idx])
+					}
+					continue
+				}
+				if err := accumulator.add(values[shardColumnIndex], columnKind(columnTypes[shardColumnIndex])); err != nil {
+					return nil, errors.Wrapf(err, "cannot merge %s", columnNames[idx])
+				}
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	value := make([]driver.Value, len(accumulators))
+	for idx, accumulator := range accumulators {
+		value[idx] = accumulator.result()
+	}
+	return &mergedRowsSet{columns: columnNames, values: [][]driver.Value{value}}, nil
+}
+
+// columnNames returns column names of merged row. Name of AVG column is not included in shard's columns.
+func (m *aggregateMerger) columnNames(shardColumns []string) []string {
+	names := make([]string, len(m.columns))
+	for idx, column := range m.columns {
+		switch {
+		case column.Alias != "":
+			names[idx] = column.Alias
+		case column.Func != sqlparser.AggregateAvg && m.shardColumnIndexes[idx] < len(shardColumns):
+			names[idx] = shardColumns[m.shardColumnIndexes[idx]]
+		default:
+			names[idx] = vtparser.String(column.Expr)
+		}
+	}
+	return names
+}
+
+// aggregateAccumulator accumulates values of a column returned by shards
+type aggregateAccumulator struct {
+	fn       sqlparser.AggregateFunc
+	intSum   int64
+	floatSum float64
+	isFloat  bool
+	count    int64
+	value    interface{}
+	exists   bool
+}
+
+func (a *aggregateAccumulator) add(value interface{}, kind valueKind) error {
+	if value == nil {
+		return nil
+	}
+	switch a.fn {
+	case sqlparser.AggregateCount, sqlparser.AggregateSum:
+		return errors.WithStack(a.addNumber(value))
+	case sqlparser.AggregateMin, sqlparser.AggregateMax:
+		if !a.exists {
+			a.value = value
+			a.exists = true
+			return nil
+		}
+		cmp, err := compareValue(value, a.value, kind)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if (a.fn == sqlparser.AggregateMin && cmp < 0) || (a.fn == sqlparser.AggregateMax && cmp > 0) {
+			a.value = value
+		}
+	}
+	return nil
+}
+
+func (a *aggregateAccumulator) addAvg(sum interface{}, count interface{}) error {
+	if sum == nil {
+		return nil
+	}
+	if err := a.addNumber(sum); err != nil {
+		return errors.WithStack(err)
+	}
+	n, _, err := toNumber(count)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	a.count += n
+	return nil
+}
+
+func (a *aggregateAccumulator) addNumber(value interface{}) error {
+	i, f, err := toNumber(value)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	a.exists = true
+	if f != nil {
+		if !a.isFloat {
+			a.isFloat = true
+			a.floatSum = float64(a.intSum)
+		}
+		a.floatSum += *f
+		return nil
+	}
+	if a.isFloat {
+		a.floatSum += float64(i)
+		return nil
+	}
+	a.intSum += i
+	return nil
+}
+
+func (a *aggregateAccumulator) result() driver.Value {
+	switch a.fn {
+	case sqlparser.AggregateCount:
+		return a.intSum
+	case sqlparser.AggregateSum:
+		if !a.exists {
+			return nil
+		}
+		if a.isFloat {
+			return a.floatSum
+		}
+		return a.intSum
+	case sqlparser.AggregateAvg:
+		if a.count == 0 {
+			return nil
+		}
+		if a.isFloat {
+			return a.floatSum / float64(a.count)
+		}
+		return float64(a.intSum) / float64(a.count)
+	}
+	if !a.exists {
+		return nil
+	}
+	return a.value
+}
+
+// toNumber converts value returned by driver to int64. If value is not integer, returns it as float64.
+func toNumber(value interface{}) (int64, *float64, error) {
+	switch v := value.(type) {
+	case int64:
+		return v, nil, nil
+	case float64:
+		return 0, &v, nil
+	case bool:
+		if v {
+			return 1, nil, nil
+		}
+		return 0, nil, nil
+	case []byte:
+		return toNumber(string(v))
+	case string:
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return i, nil, nil
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, nil, errors.Errorf("cannot convert %s to number", v)
+		}
+		return 0, &f, nil
+	}
+	return 0, nil, errors.Errorf("cannot convert %v to number", value)
+}
+
+// valueKind the way to compare values of a column
+type valueKind int
+
+const (
+	// valueKindUnknown type of column is not reported by driver, so values are compared by their types
+	valueKindUnknown valueKind = iota
+	// valueKindNumber values of numeric column are compared as numbers
+	valueKindNumber
+	// valueKindString values of other columns are compared as strings
+	valueKindString
+)
+
+var numericTypeNames = map[string]bool{
+	"BIT":       true,
+	"BOOL":      true,
+	"BOOLEAN":   true,
+	"TINYINT":   true,
+	"SMALLINT":  true,
+	"MEDIUMINT": true,
+	"INT":       true,
+	"INTEGER":   true,
+	"BIGINT":    true,
+	"DECIMAL":   true,
+	"NUMERIC":   true,
+	"FLOAT":     true,
+	"DOUBLE":    true,
+	"REAL":      true,
+	"YEAR":      true,
+}
+
+// columnKind returns how values of column are compared by database type of column ( e.g. 'UNSIGNED BIGINT', 'VARCHAR(255)' ).
+// Drivers return numeric values as []byte, so values cannot be compared by whether they are parsed as numbers.
+func columnKind(columnType *sql.ColumnType) valueKind {
+	typeName := strings.ToUpper(columnType.DatabaseTypeName())
+	if index := strings.Index(typeName, "("); index >= 0 {
+		typeName = typeName[:index]
+	}
+	words := strings.Fields(typeName)
+	if len(words) == 0 {
+		return valueKindUnknown
+	}
+	for _, word := range words {
+		if numericTypeNames[word] {
+			return valueKindNumber
+		}
+	}
+	return valueKindString
+}
+
+// compareValue returns -1 if a < b, 0 if a == b, 1 if a > b
+func compareValue(a interface{}, b interface{}, kind valueKind) (int, error) {
+	if at, ok := a.(time.Time); ok {
+		bt, ok := b.(time.Time)
+		if !ok {
+			return 0, errors.Errorf("cannot compare %v and %v", a, b)
+		}
+		switch {
+		case at.Before(bt):
+			return -1, nil
+		case at.After(bt):
+			return 1, nil
+		}
+		return 0, nil
+	}
+	if kind == valueKindUnknown {
+		kind = valueKindString
+		if isNumber(a) && isNumber(b) {
+			kind = valueKindNumber
+		}
+	}
+	if kind == valueKindString {
+		return strings.Compare(toString(a), toString(b)), nil
+	}
+	ai, af, err := toNumber(a)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	bi, bf, err := toNumber(b)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if af == nil && bf == nil {
+		switch {
+		case ai < bi:
+			return -1, nil
+		case ai > bi:
+			return 1, nil
+		}
+		return 0, nil
+	}
+	av, bv := float64(ai), float64(bi)
+	if af != nil {
+		av = *af
+	}
+	if bf != nil {
+		bv = *bf
+	}
+	return compareFloat(av, bv), nil
+}
+
+func isNumber(value interface{}) bool {
+	switch value.(type) {
+	case int64, float64, bool:
+		return true
+	}
+	return false
+}
+
+func compareFloat(a float64, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,101 @@
+package exec
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/connection"
+	"go.knocknote.io/octillery/debug"
+)
+
+var (
+	// ErrAllShardWriteNotAcknowledged returned when UPDATE/DELETE without shard_key is executed by context that isn't created by WithAllShards
+	ErrAllShardWriteNotAcknowledged = errors.New("UPDATE/DELETE for all shards requires context created by WithAllShards")
+
+	// ErrAllShardWriteRejected returned when UPDATE/DELETE without shard_key is executed under 'reject' policy
+	ErrAllShardWriteRejected = errors.New("UPDATE/DELETE for all shards is rejected by all_shard_write_policy")
+)
+
+type allShardsKey struct{}
+
+// WithAllShards returns context that acknowledges UPDATE/DELETE for all shards.
+// Query without shard_key is executed to all shards only if it is executed by this context.
+func WithAllShards(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, allShardsKey{}, true)
+}
+
+// IsAllShardsAcknowledged returns whether context is created by WithAllShards or not.
+func IsAllShardsAcknowledged(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	acknowledged, _ := ctx.Value(allShardsKey{}).(bool)
+	return acknowledged
+}
+
+func allShardWritePolicy() string {
+	cfg, err := config.Get()
+	if err != nil || cfg.AllShardWritePolicy == "" {
+		return config.AllShardWritePolicyAllow
+	}
+	return cfg.AllShardWritePolicy
+}
+
+// validateAllShardWrite returns error if UPDATE/DELETE for all shards is not allowed.
+// isAllowedByDefault is used when policy is 'allow' and context is not acknowledged.
+func (e *QueryExecutorBase) validateAllShardWrite(isAllowedByDefault bool) error {
+	policy := allShardWritePolicy()
+	if policy == config.AllShardWritePolicyReject {
+		return errors.Wrapf(ErrAllShardWriteRejected, "%s", e.query.Table())
+	}
+	if IsAllShardsAcknowledged(e.ctx) {
+		return nil
+	}
+	if policy == config.AllShardWritePolicyAllow && isAllowedByDefault {
+		return nil
+	}
+	return errors.Wrapf(ErrAllShardWriteNotAcknowledged, "%s", e.query.Table())
+}
+
+func (e *QueryExecutorBase) execAllShard(query string, args ...interface{}) (sql.Result, error) {
+	shardConns, err := e.routeAllShards()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return e.execShards(shardConns, query, args...)
+}
+
+// execShards executes query on shards and sums up affected rows of them
+func (e *QueryExecutorBase) execShards(shardConns []*connection.DBShardConnection, query string, args ...interface{}) (sql.Result, error) {
+	var totalAffectedRows int64
+	errs := &connection.MultiError{}
+	trace := &ExecutionTrace{}
+	for _, shardConn := range shardConns {
+		if err := e.contextErr(); err != nil {
+			return nil, trace.wrap(errors.Wrapf(err, "cancelled before executing query on %s", shardConn.ShardName))
+		}
+		debug.Printf("(DB:%s):%s", shardConn.ShardName, query)
+		done := trace.start(shardConn.ShardName)
+		result, err := e.exec(shardConn, query, args...)
+		done(err)
+		if err != nil {
+			errs.AddShardError(shardConn.ShardName, shardConn.DSN(), err)
+			continue
+		}
+		affectedRows, err := result.(sql.Result).RowsAffected()
+		errs.AddShardError(shardConn.ShardName, shardConn.DSN(), err)
+		totalAffectedRows = totalAffectedRows + affectedRows
+	}
+
+	if err := errs.ErrorOrNil(); err != nil {
+		return nil, trace.wrap(err)
+	}
+
+	debug.Printf("totalAffectedRows = %d", totalAffectedRows)
+	return &mergedResult{affectedRows: totalAffectedRows, err: nil}, nil
+}
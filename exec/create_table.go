@@ -5,6 +5,7 @@ import (
 
 	"github.com/pkg/errors"
 	"go.knocknote.io/octillery/debug"
+	"go.knocknote.io/octillery/metrics"
 	"go.knocknote.io/octillery/sqlparser"
 )
 
@@ -35,9 +36,17 @@ func (e *CreateTableQueryExecutor) Exec() (sql.Result, error) {
 	if !ok {
 		return nil, errors.New("cannot convert sqlparser.Query to *sqlparser.QueryBase")
 	}
+	e.recordRouting(metrics.Broadcast)
+	trace := &ExecutionTrace{}
 	for _, shardConn := range e.conn.ShardConnections.AllShard() {
-		if _, err := shardConn.Connection.Exec(query.Text); err != nil {
-			return nil, errors.WithStack(err)
+		if err := e.contextErr(); err != nil {
+			return nil, trace.wrap(errors.Wrapf(err, "cancelled before executing query on %s", shardConn.ShardName))
+		}
+		done := trace.start(shardConn.ShardName)
+		_, err := e.execDDL(shardConn, query.Text)
+		done(err)
+		if err != nil {
+			return nil, trace.wrap(errors.WithStack(err))
 		}
 	}
 	return nil, nil
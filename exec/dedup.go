@@ -0,0 +1,218 @@
+package exec
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+// scatterCall execution of scatter query shared by concurrent callers
+type scatterCall struct {
+	done chan struct{}
+	sets []*mergedRowsSet
+	err  error
+
+	mu sync.Mutex
+	// deadline of shared execution. it is extended to the longest deadline of callers
+	deadline time.Time
+	// timer cancels shared execution at deadline. nil if any caller doesn't have deadline
+	timer  *time.Timer
+	cancel context.CancelFunc
+}
+
+var (
+	scatterCallsMu sync.Mutex
+	scatterCalls   = map[string]*scatterCall{}
+)
+
+// isDeduplicateScatterQueries returns whether scatter query of executor can be shared with concurrent callers.
+// Query in transaction or session must see its own writes, so it is never shared.
+func (e *SelectQueryExecutor) isDeduplicateScatterQueries() bool {
+	if e.tx != nil || e.session != nil {
+		return false
+	}
+	cfg, err := config.Get()
+	return err == nil && cfg.DeduplicateScatterQueries
+}
+
+// scatterQueryKey returns fingerprint of query and its arguments
+func scatterQueryKey(query *sqlparser.QueryBase) string {
+	return fmt.Sprintf("%s\x00%s\x00%#v", query.Table(), query.Text, query.Args)
+}
+
+// newScatterCall creates shared execution having deadline of ctx, and returns context for it.
+// Context is detached from ctx, so shared execution is never cancelled by the first caller.
+func newScatterCall(ctx context.Context) (*scatterCall, context.Context) {
+	sharedCtx, cancel := context.WithCancel(context.Background())
+	call := &scatterCall{done: make(chan struct{}), cancel: cancel}
+	if ctx == nil {
+		return call, sharedCtx
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		call.deadline = deadline
+		call.timer = time.AfterFunc(time.Until(deadline), call.expire)
+	}
+	return call, sharedCtx
+}
+
+// expire cancels shared execution if deadline is not extended by other callers
+func (c *scatterCall) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer == nil || time.Now().Before(c.deadline) {
+		return
+	}
+	c.cancel()
+}
+
+// join extends deadline of shared execution to deadline of ctx. If ctx doesn't have deadline, deadline is removed.
+// It returns false if shared execution is already cancelled by deadline, so caller cannot share it.
+func (c *scatterCall) join(ctx context.Context) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer == nil {
+		return true
+	}
+	if !time.Now().Before(c.deadline) {
+		return false
+	}
+	var deadline time.Time
+	var ok bool
+	if ctx != nil {
+		deadline, ok = ctx.Deadline()
+	}
+	if !ok {
+		c.timer.Stop()
+		c.timer = nil
+		return true
+	}
+	if deadline.After(c.deadline) {
+		c.deadline = deadline
+		c.timer.Reset(time.Until(deadline))
+	}
+	return true
+}
+
+// finish sets result of shared execution, and notifies it to callers
+func (c *scatterCall) finish(sets []*mergedRowsSet, err error) {
+	c.mu.Lock()
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.mu.Unlock()
+	c.cancel()
+	c.sets, c.err = sets, err
+	close(c.done)
+}
+
+// wait waits result of shared execution. If ctx is done before it is finished, returns error of ctx.
+func (c *scatterCall) wait(ctx context.Context) ([]*mergedRowsSet, error) {
+	if ctx == nil {
+		<-c.done
+		return c.sets, c.err
+	}
+	select {
+	case <-c.done:
+		return c.sets, c.err
+	case <-ctx.Done():
+		return nil, errors.WithStack(ctx.Err())
+	}
+}
+
+// deduplicateScatterQuery executes fn only once for the same key while it is running, and returns its result to all callers.
+// fn is executed by context detached from callers, and it is cancelled at the longest deadline of callers.
+// If ctx is done before shared execution is finished, returns error of ctx without cancelling shared execution.
+func deduplicateScatterQuery(ctx context.Context, key string, fn func(context.Context) ([]*mergedRowsSet, error)) ([]*mergedRowsSet, error) {
+	scatterCallsMu.Lock()
+	if call, exists := scatterCalls[key]; exists {
+		if call.join(ctx) {
+			scatterCallsMu.Unlock()
+			return call.wait(ctx)
+		}
+	}
+	call, sharedCtx := newScatterCall(ctx)
+	scatterCalls[key] = call
+	scatterCallsMu.Unlock()
+
+	go func() {
+		sets, err := fn(sharedCtx)
+		scatterCallsMu.Lock()
+		if scatterCalls[key] == call {
+			delete(scatterCalls, key)
+		}
+		scatterCallsMu.Unlock()
+		call.finish(sets, err)
+	}()
+	return call.wait(ctx)
+}
+
+// readRowsSets reads all rows to memory, so they can be read by multiple callers
+func readRowsSets(allRows []*sql.Rows) ([]*mergedRowsSet, error) {
+	defer func() {
+		for _, rows := range allRows {
+			rows.Close()
+		}
+	}()
+	sets := make([]*mergedRowsSet, 0, len(allRows))
+	for _, rows := range allRows {
+		columns, err := rows.Columns()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		set := &mergedRowsSet{columns: columns, values: [][]driver.Value{}}
+		for rows.Next() {
+			values := make([]interface{}, len(columns))
+			dest := make([]interface{}, len(columns))
+			for idx := range values {
+				dest[idx] = &values[idx]
+			}
+			if err := rows.Scan(dest...); err != nil {
+				return nil, errors.WithStack(err)
+			}
+			row := make([]driver.Value, len(columns))
+			for idx, value := range values {
+				row[idx] = value
+			}
+			set.values = append(set.values, row)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		sets = append(sets, set)
+	}
+	return sets, nil
+}
+
+// queryDeduplicatedRowsSets executes scatter query once for concurrent callers of the same query and arguments.
+// Each caller receives its own rows sets reading shared values.
+func (e *SelectQueryExecutor) queryDeduplicatedRowsSets(query *sqlparser.QueryBase) ([]*mergedRowsSet, error) {
+	sets, err := deduplicateScatterQuery(e.ctx, scatterQueryKey(query), func(ctx context.Context) ([]*mergedRowsSet, error) {
+		base := *e.QueryExecutorBase
+		base.ctx = ctx
+		shared := &SelectQueryExecutor{QueryExecutorBase: &base, routedShards: e.routedShards}
+		allRows, err := shared.queryScatter(query)
+		if err != nil {
+			for _, rows := range allRows {
+				rows.Close()
+			}
+			return nil, errors.WithStack(err)
+		}
+		return readRowsSets(allRows)
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	copied := make([]*mergedRowsSet, 0, len(sets))
+	for _, set := range sets {
+		copied = append(copied, &mergedRowsSet{columns: set.columns, values: set.values})
+	}
+	return copied, nil
+}
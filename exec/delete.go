@@ -2,11 +2,12 @@ package exec
 
 import (
 	"database/sql"
-	"strings"
 
 	"github.com/pkg/errors"
 	"go.knocknote.io/octillery/debug"
+	"go.knocknote.io/octillery/metrics"
 	"go.knocknote.io/octillery/sqlparser"
+	"go.knocknote.io/octillery/warning"
 )
 
 // DeleteQueryExecutor inherits QueryExecutorBase structure
@@ -19,50 +20,58 @@ func NewDeleteQueryExecutor(base *QueryExecutorBase) *DeleteQueryExecutor {
 	return &DeleteQueryExecutor{base}
 }
 
-// Query doesn't support in DeleteQueryExecutor, returns always error.
+// Query executes DELETE query that has RETURNING clause for shards.
+// If query doesn't have RETURNING clause, returns always error.
 func (e *DeleteQueryExecutor) Query() ([]*sql.Rows, error) {
-	return nil, errors.New("DeleteQueryExecutor cannot invoke Query()")
+	query, ok := e.query.(*sqlparser.DeleteQuery)
+	if !ok {
+		return nil, errors.New("cannot convert sqlparser.Query to *sqlparser.DeleteQuery")
+	}
+	var rows []*sql.Rows
+	if err := e.execUniqueValuesRelease(query, func() (err error) {
+		rows, err = e.queryReturning(query.QueryBase)
+		return err
+	}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return rows, nil
 }
 
-// QueryRow doesn't support in DeleteQueryExecutor, returns always error.
+// QueryRow executes DELETE query that has RETURNING clause for single shard.
+// If query doesn't have RETURNING clause, returns always error.
 func (e *DeleteQueryExecutor) QueryRow() (*sql.Row, error) {
-	return nil, errors.New("DeleteQueryExecutor cannot invoke QueryRow()")
+	query, ok := e.query.(*sqlparser.DeleteQuery)
+	if !ok {
+		return nil, errors.New("cannot convert sqlparser.Query to *sqlparser.DeleteQuery")
+	}
+	if e.hasUniqueColumns() {
+		// rows may not be deleted until Scan, so values of unique columns cannot be released
+		return nil, errors.Errorf("cannot invoke QueryRow() for DELETE query of %s that has unique columns. use Query() instead", query.Table())
+	}
+	return e.queryRowReturning(query.QueryBase)
 }
 
 func (e *DeleteQueryExecutor) deleteShardTable(query *sqlparser.DeleteQuery) (sql.Result, error) {
 	debug.Printf("delete shard table")
-
-	var totalAffectedRows int64
-	errs := []string{}
-	for _, shardConn := range e.conn.ShardConnections.AllShard() {
-		debug.Printf("(DB:%s):%s", shardConn.ShardName, query.Text)
-		result, err := e.exec(shardConn, query.Text, query.Args...)
-		if err != nil {
-			errs = append(errs, err.Error())
-			continue
-		}
-		affectedRows, err := result.(sql.Result).RowsAffected()
-		if err != nil {
-			errs = append(errs, err.Error())
-		}
-		totalAffectedRows = totalAffectedRows + affectedRows
-	}
-
-	if len(errs) > 0 {
-		return nil, errors.New(strings.Join(errs, ":"))
+	if err := e.validateAllShardWrite(true); err != nil {
+		return nil, errors.WithStack(err)
 	}
-
-	debug.Printf("totalAffectedRows = %d", totalAffectedRows)
-	return &mergedResult{affectedRows: totalAffectedRows, err: nil}, nil
+	e.recordRouting(metrics.Broadcast)
+	return e.execAllShard(query.Text, query.Args...)
 }
 
 func (e *DeleteQueryExecutor) deleteForAllShard(query *sqlparser.DeleteQuery) (sql.Result, error) {
-	debug.Printf("[WARN] delete query for all shards. too slow")
-	// 1. select for all shards to get delete targets
-	// 2. exec delete query to every shard
-	// 3. if succeeded delete query, merge selected rows from every shard
-	// 4. exec delete query for sequencer table
-	return nil, errors.New("still not support to delete for all shards")
+	warning.Warn(&warning.Warning{
+		Code:    warning.ScatterQuery,
+		Message: "delete query for all shards. too slow",
+		Table:   query.Table(),
+		Query:   query.Text,
+	})
+	if err := e.validateAllShardWrite(false); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	e.recordRouting(metrics.Broadcast)
+	return e.execAllShard(query.Text, query.Args...)
 }
 
 // Exec executes DELETE query for shards.
@@ -72,21 +81,38 @@ func (e *DeleteQueryExecutor) Exec() (sql.Result, error) {
 		return nil, errors.New("cannot convert to sqlparser.Query to *sqlparser.DeleteQuery")
 	}
 
-	if e.conn.IsUsedSequencer && e.conn.Sequencer == nil {
+	if e.conn.IsUsedSequencer && e.conn.Sequencer == nil && e.conn.IDGenerator == nil {
 		return nil, errors.New("cannot delete. sequencer's connection is nil")
 	}
 
+	var result sql.Result
+	if err := e.execUniqueValuesRelease(query, func() (err error) {
+		result, err = e.execDelete(query)
+		return err
+	}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return result, nil
+}
+
+func (e *DeleteQueryExecutor) execDelete(query *sqlparser.DeleteQuery) (sql.Result, error) {
 	if query.IsDeleteTable {
 		return e.deleteShardTable(query)
 	} else if query.IsAllShardQuery {
 		return e.deleteForAllShard(query)
 	}
 
-	shardConn, err := e.conn.ShardConnectionByID(int64(query.ShardKeyID))
+	shardConns, err := e.routeShardByID(int64(query.ShardKeyID))
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	result, err := e.exec(shardConn, query.Text, query.Args...)
+	if len(shardConns) > 1 {
+		// broadened by routing interceptor
+		e.recordRouting(metrics.MultiShard)
+		return e.execShards(shardConns, query.Text, query.Args...)
+	}
+	e.recordRouting(metrics.SingleShard)
+	result, err := e.exec(shardConns[0], query.Text, query.Args...)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
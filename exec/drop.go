@@ -2,10 +2,11 @@ package exec
 
 import (
 	"database/sql"
-	"strings"
 
 	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/connection"
 	"go.knocknote.io/octillery/debug"
+	"go.knocknote.io/octillery/metrics"
 	"go.knocknote.io/octillery/sqlparser"
 )
 
@@ -36,24 +37,29 @@ func (e *DropQueryExecutor) Exec() (sql.Result, error) {
 	if !ok {
 		return nil, errors.New("cannot convert sqlparser.Query to *sqlparser.QueryBase")
 	}
+	e.recordRouting(metrics.Broadcast)
 	var totalAffectedRows int64
-	errs := []string{}
+	errs := &connection.MultiError{}
+	trace := &ExecutionTrace{}
 	for _, shardConn := range e.conn.ShardConnections.AllShard() {
-		result, err := shardConn.Connection.Exec(query.Text, query.Args...)
+		if err := e.contextErr(); err != nil {
+			return nil, trace.wrap(errors.Wrapf(err, "cancelled before executing query on %s", shardConn.ShardName))
+		}
+		done := trace.start(shardConn.ShardName)
+		result, err := e.execDDL(shardConn, query.Text, query.Args...)
+		done(err)
 		if err != nil {
-			errs = append(errs, err.Error())
+			errs.AddShardError(shardConn.ShardName, shardConn.DSN(), err)
 			continue
 		}
 		if result != nil {
 			affectedRows, err := result.(sql.Result).RowsAffected()
-			if err != nil {
-				errs = append(errs, err.Error())
-			}
+			errs.AddShardError(shardConn.ShardName, shardConn.DSN(), err)
 			totalAffectedRows = totalAffectedRows + affectedRows
 		}
 	}
-	if len(errs) > 0 {
-		return nil, errors.New(strings.Join(errs, ":"))
+	if err := errs.ErrorOrNil(); err != nil {
+		return nil, trace.wrap(err)
 	}
 	debug.Printf("totalAffectedRows = %d", totalAffectedRows)
 	return &mergedResult{affectedRows: totalAffectedRows}, nil
@@ -0,0 +1,173 @@
+package exec
+
+import (
+	"database/sql/driver"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/encryption"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+// ColumnEncryptors returns encryptors of encrypted columns of table by column name. If table has no encrypted columns, returns nil.
+func ColumnEncryptors(tableName string) (map[string]encryption.Encryptor, error) {
+	cfg, err := config.Get()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	columns := cfg.EncryptedColumns(tableName)
+	if columns == nil {
+		return nil, nil
+	}
+	encryptors := map[string]encryption.Encryptor{}
+	for column, name := range columns {
+		encryptor, err := encryption.EncryptorByName(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid encrypted column %s of %s", column, tableName)
+		}
+		encryptors[strings.ToLower(column)] = encryptor
+	}
+	return encryptors, nil
+}
+
+// EncryptArgs returns arguments of INSERT/UPDATE query whose values for encrypted columns are encrypted.
+// Values of encrypted columns must be passed by placeholder, so plaintext is never written in query text.
+// If query doesn't write encrypted columns, returns nil.
+func EncryptArgs(query sqlparser.Query) ([]interface{}, error) {
+	if query.QueryType() != sqlparser.Insert && query.QueryType() != sqlparser.Update {
+		return nil, nil
+	}
+	encryptors, err := ColumnEncryptors(query.Table())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if encryptors == nil {
+		return nil, nil
+	}
+	var (
+		queryBase *sqlparser.QueryBase
+		exprs     vtparser.UpdateExprs
+		values    []encryptedValue
+	)
+	switch q := query.(type) {
+	case *sqlparser.InsertQuery:
+		queryBase = q.QueryBase
+		rows, ok := q.Stmt.Rows.(vtparser.Values)
+		if !ok || len(q.Stmt.Columns) == 0 {
+			return nil, errors.Errorf("%s has encrypted columns. INSERT query must have column list and VALUES clause", query.Table())
+		}
+		for _, row := range rows {
+			for idx, column := range q.Stmt.Columns {
+				if encryptor, exists := encryptors[column.Lowered()]; exists && idx < len(row) {
+					values = append(values, encryptedValue{column: column.String(), expr: row[idx], encryptor: encryptor})
+				}
+			}
+		}
+		exprs = vtparser.UpdateExprs(q.Stmt.OnDup)
+	case *sqlparser.QueryBase:
+		queryBase = q
+		stmt, ok := q.Stmt.(*vtparser.Update)
+		if !ok {
+			return nil, nil
+		}
+		exprs = stmt.Exprs
+	default:
+		return nil, nil
+	}
+	for _, expr := range exprs {
+		if encryptor, exists := encryptors[expr.Name.Name.Lowered()]; exists {
+			values = append(values, encryptedValue{column: expr.Name.Name.String(), expr: expr.Expr, encryptor: encryptor})
+		}
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	if queryBase.DollarPlaceholder {
+		return nil, errors.Errorf("%s has encrypted columns. query must use '?' placeholders", query.Table())
+	}
+	args := append([]interface{}{}, queryBase.Args...)
+	for _, value := range values {
+		if err := value.encrypt(args); err != nil {
+			return nil, errors.Wrapf(err, "cannot encrypt %s of %s", value.column, query.Table())
+		}
+	}
+	return args, nil
+}
+
+// encryptedValue value expression written to encrypted column
+type encryptedValue struct {
+	column    string
+	expr      vtparser.Expr
+	encryptor encryption.Encryptor
+}
+
+// encrypt replaces argument of placeholder with encrypted value
+func (v encryptedValue) encrypt(args []interface{}) error {
+	val, ok := v.expr.(*vtparser.SQLVal)
+	if !ok {
+		if _, isNull := v.expr.(*vtparser.NullVal); isNull {
+			return nil
+		}
+		return errors.Errorf("value must be passed by placeholder. but got %s", vtparser.String(v.expr))
+	}
+	if val.Type != vtparser.ValArg {
+		return errors.New("value must be passed by placeholder. but got literal")
+	}
+	index, err := strconv.Atoi(strings.TrimPrefix(string(val.Val), ":v"))
+	if err != nil || index < 1 || index > len(args) {
+		return errors.Errorf("cannot find argument of %s", string(val.Val))
+	}
+	encrypted, err := encryptValue(v.encryptor, args[index-1])
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	args[index-1] = encrypted
+	return nil
+}
+
+// encryptValue encrypts text representation of arg, and encodes it to base64 text. NULL is not encrypted.
+func encryptValue(encryptor encryption.Encryptor, arg interface{}) (interface{}, error) {
+	value, err := driver.DefaultParameterConverter.ConvertValue(arg)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var plaintext []byte
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		plaintext = v
+	case string:
+		plaintext = []byte(v)
+	case time.Time:
+		plaintext = []byte(v.Format(time.RFC3339Nano))
+	default:
+		plaintext = []byte(fmt.Sprint(v))
+	}
+	encrypted, err := encryptor.Encrypt(plaintext)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return base64.StdEncoding.EncodeToString(encrypted), nil
+}
+
+// DecryptValue decrypts value read from encrypted column.
+// Encrypted value is stored as base64 text, so it can be written to VARCHAR or TEXT column and inlined to INSERT query.
+func DecryptValue(encryptor encryption.Encryptor, value []byte) ([]byte, error) {
+	encrypted := make([]byte, base64.StdEncoding.DecodedLen(len(value)))
+	n, err := base64.StdEncoding.Decode(encrypted, value)
+	if err != nil {
+		return nil, errors.Wrap(err, "encrypted value is not base64 text")
+	}
+	plaintext, err := encryptor.Decrypt(encrypted[:n])
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return plaintext, nil
+}
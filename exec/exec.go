@@ -6,6 +6,7 @@ import (
 
 	"github.com/pkg/errors"
 	"go.knocknote.io/octillery/connection"
+	"go.knocknote.io/octillery/metrics"
 	"go.knocknote.io/octillery/sqlparser"
 )
 
@@ -27,8 +28,6 @@ func (r *mergedResult) RowsAffected() (int64, error) {
 type QueryExecutor interface {
 	Query() ([]*sql.Rows, error)
 	QueryRow() (*sql.Row, error)
-	Prepare() (*sql.Stmt, error)
-	Stmt() (*sql.Stmt, error)
 	Exec() (sql.Result, error)
 }
 
@@ -38,47 +37,85 @@ type QueryExecutorBase struct {
 	tx    *connection.TxConnection
 	conn  *connection.DBConnection
 	query sqlparser.Query
+	// pinned connections used out of transaction. nil if connections are taken from pool for each query
+	session *connection.SessionConnection
 }
 
-// Prepare executes prepare for shards.
-// Currently, this is not supported.
-func (e *QueryExecutorBase) Prepare() (*sql.Stmt, error) {
-	return nil, errors.New("currently not supported Prepare() for sharding table")
-}
+func (e *QueryExecutorBase) exec(conn connection.Connection, query string, args ...interface{}) (result sql.Result, err error) {
+	query = e.shardQueryText(query)
+	done := e.startQuery(conn, query, args)
+	defer func() { done(result, err) }()
+	if e.tx != nil {
+		result, err := e.tx.Exec(e.ctx, conn, query, args...)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return result, nil
+	}
+	if e.session != nil {
+		return e.session.Exec(e.ctx, conn, query, args...)
+	}
 
-// Stmt executes stmt for shards.
-// Currently, this is not supported.
-func (e *QueryExecutorBase) Stmt() (*sql.Stmt, error) {
-	return nil, errors.New("currently not supported Stmt() for sharding table")
+	err = e.conn.RetryWithFailover(e.ctx, conn, true, func(conn connection.Connection) (err error) {
+		if e.ctx == nil {
+			result, err = conn.Conn().Exec(query, args...)
+		} else {
+			result, err = conn.Conn().ExecContext(e.ctx, query, args...)
+		}
+		return err
+	})
+	return result, err
 }
 
-func (e *QueryExecutorBase) exec(conn connection.Connection, query string, args ...interface{}) (sql.Result, error) {
+func (e *QueryExecutorBase) execReturningID(conn connection.Connection, query string, args ...interface{}) (result sql.Result, err error) {
+	query = e.shardQueryText(query)
+	done := e.startQuery(conn, query, args)
+	defer func() { done(result, err) }()
 	if e.tx != nil {
-		result, err := e.tx.Exec(e.ctx, conn, query, args...)
+		result, err := e.tx.ExecReturningID(e.ctx, conn, query, args...)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
 		return result, nil
 	}
-
-	if e.ctx == nil {
-		return conn.Conn().Exec(query, args...)
+	if e.session != nil {
+		return e.session.ExecReturningID(e.ctx, conn, query, args...)
 	}
-	return conn.Conn().ExecContext(e.ctx, query, args...)
+	err = e.conn.RetryWithFailover(e.ctx, conn, true, func(conn connection.Connection) (err error) {
+		result, err = connection.ExecReturningID(e.ctx, conn, query, args...)
+		return err
+	})
+	return result, err
 }
 
-func (e *QueryExecutorBase) execQuery(conn connection.Connection, query string, args ...interface{}) (*sql.Rows, error) {
+func (e *QueryExecutorBase) execQuery(conn connection.Connection, query string, args ...interface{}) (rows *sql.Rows, err error) {
+	query = e.shardQueryText(query)
+	done := e.startQuery(conn, query, args)
+	defer func() { done(nil, err) }()
 	if e.tx != nil {
 		return e.tx.Query(e.ctx, conn, query, args...)
 	}
-
-	if e.ctx == nil {
-		return conn.Conn().Query(query, args...)
+	if e.session != nil {
+		return e.session.Query(e.ctx, conn, query, args...)
 	}
-	return conn.Conn().QueryContext(e.ctx, query, args...)
+
+	isWrite := e.query == nil || !e.query.QueryType().IsReadQuery()
+	err = e.conn.RetryWithFailover(e.ctx, conn, isWrite, func(conn connection.Connection) (err error) {
+		db := e.connForQuery(conn)
+		if e.ctx == nil {
+			rows, err = db.Query(query, args...)
+		} else {
+			rows, err = db.QueryContext(e.ctx, query, args...)
+		}
+		return err
+	})
+	return rows, err
 }
 
-func (e *QueryExecutorBase) execQueryRow(conn connection.Connection, query string, args ...interface{}) (*sql.Row, error) {
+func (e *QueryExecutorBase) execQueryRow(conn connection.Connection, query string, args ...interface{}) (row *sql.Row, err error) {
+	query = e.shardQueryText(query)
+	done := e.startQuery(conn, query, args)
+	defer func() { done(nil, err) }()
 	if e.tx != nil {
 		row, err := e.tx.QueryRow(e.ctx, conn, query, args...)
 		if err != nil {
@@ -86,23 +123,103 @@ func (e *QueryExecutorBase) execQueryRow(conn connection.Connection, query strin
 		}
 		return row, nil
 	}
+	if e.session != nil {
+		return e.session.QueryRow(e.ctx, conn, query, args...)
+	}
 
+	db := e.connForQuery(conn)
 	if e.ctx == nil {
-		return conn.Conn().QueryRow(query, args...), nil
+		return db.QueryRow(query, args...), nil
 	}
-	return conn.Conn().QueryRowContext(e.ctx, query, args...), nil
+	return db.QueryRowContext(e.ctx, query, args...), nil
+}
+
+// execDDL executes DDL query on connection out of transaction
+func (e *QueryExecutorBase) execDDL(conn connection.Connection, query string, args ...interface{}) (result sql.Result, err error) {
+	query = e.shardQueryText(query)
+	done := e.startQuery(conn, query, args)
+	defer func() { done(result, err) }()
+	defer connection.InvalidateMetadataCache(conn.DSN())
+	if e.session != nil {
+		return e.session.Exec(e.ctx, conn, query, args...)
+	}
+	if e.ctx == nil {
+		return conn.Conn().Exec(query, args...)
+	}
+	return conn.Conn().ExecContext(e.ctx, query, args...)
+}
+
+// startQuery calls query hooks before query is executed on conn, and returns function that calls them after it is executed
+func (e *QueryExecutorBase) startQuery(conn connection.Connection, query string, args []interface{}) connection.QueryDoneFunc {
+	if e.conn == nil {
+		return func(sql.Result, error) {}
+	}
+	tableName := ""
+	if e.query != nil {
+		tableName = e.query.Table()
+	}
+	return e.conn.StartQuery(e.ctx, tableName, conn, query, args)
+}
+
+// shardQueryText returns query text sent to shard.
+// Query written by PostgreSQL style placeholders ( '$1' ) is parsed as '?', so they are restored for driver.
+func (e *QueryExecutorBase) shardQueryText(query string) string {
+	if e.query != nil && sqlparser.IsDollarPlaceholder(e.query) {
+		return sqlparser.ReplacePlaceholderToDollar(query)
+	}
+	return query
+}
+
+// recordRouting counts up query of executor by how it is routed to shards
+func (e *QueryExecutorBase) recordRouting(mode metrics.RoutingMode) {
+	metrics.RecordRouting(e.query.Table(), e.query.QueryType(), mode)
+}
+
+// contextErr returns error if context is already cancelled or its deadline is exceeded.
+// Executors check this before sending query to each shard, so remaining shards are not queried after cancellation.
+func (e *QueryExecutorBase) contextErr() error {
+	if e.ctx == nil {
+		return nil
+	}
+	if err := e.ctx.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// connForQuery returns slave connection for read query out of transaction.
+// write query with RETURNING clause is always executed on master.
+func (e *QueryExecutorBase) connForQuery(conn connection.Connection) *sql.DB {
+	if e.query != nil && sqlparser.IsSlaveReadable(e.query) {
+		return conn.ReadConn()
+	}
+	return conn.Conn()
 }
 
 // NewQueryExecutor creates instance of QueryExecutor interface.
 // If specify unknown query type, returns nil
 func NewQueryExecutor(ctx context.Context, conn *connection.DBConnection, tx *connection.TxConnection, query sqlparser.Query) QueryExecutor {
-	base := &QueryExecutorBase{
+	return newQueryExecutor(&QueryExecutorBase{
 		ctx:   ctx,
 		tx:    tx,
 		query: query,
 		conn:  conn,
-	}
-	switch query.QueryType() {
+	})
+}
+
+// NewSessionQueryExecutor creates instance of QueryExecutor interface that executes query on connections pinned by session.
+// If specify unknown query type, returns nil
+func NewSessionQueryExecutor(ctx context.Context, conn *connection.DBConnection, session *connection.SessionConnection, query sqlparser.Query) QueryExecutor {
+	return newQueryExecutor(&QueryExecutorBase{
+		ctx:     ctx,
+		query:   query,
+		conn:    conn,
+		session: session,
+	})
+}
+
+func newQueryExecutor(base *QueryExecutorBase) QueryExecutor {
+	switch base.query.QueryType() {
 	case sqlparser.CreateTable:
 		return NewCreateTableQueryExecutor(base)
 	case sqlparser.TruncateTable:
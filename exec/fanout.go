@@ -0,0 +1,57 @@
+package exec
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/connection"
+)
+
+// FanOutOptions options for FanOutWithOptions
+type FanOutOptions struct {
+	// maximum number of shards processed concurrently. 0 means all shards are processed concurrently
+	Concurrency int
+	// if true, context passed to fn is cancelled after error occurred and shards not processed yet are skipped
+	StopOnError bool
+}
+
+// FanOut calls fn for every shard of conn ( or database of conn if it is not sharded ) concurrently.
+// It is the same primitive as octillery uses for processing shards, so application can use it for maintenance of shards.
+// Errors returned by fn are aggregated to connection.MultiError with shard attribution, and panic of fn is recovered as error.
+// Shards not processed yet are skipped after ctx is done.
+func FanOut(ctx context.Context, conn *connection.DBConnection, fn func(context.Context, *connection.DBShardConnection) error) error {
+	return FanOutWithOptions(ctx, conn, fn, nil)
+}
+
+// FanOutWithOptions calls fn for every shard of conn by options. If opts is nil, it is the same as FanOut.
+func FanOutWithOptions(ctx context.Context, conn *connection.DBConnection, fn func(context.Context, *connection.DBShardConnection) error, opts *FanOutOptions) error {
+	if conn == nil {
+		return errors.New("cannot fan out to shards. connection is nil")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if opts == nil {
+		opts = &FanOutOptions{}
+	}
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = len(conn.Shards())
+	}
+	fnCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// shards skipped by cancellation of fnCtx are not reported as error, so only ctx of caller is checked for them
+	return conn.ForEachShard(func(shard *connection.DBShardConnection) error {
+		if err := fn(fnCtx, shard); err != nil {
+			if opts.StopOnError {
+				cancel()
+			}
+			return err
+		}
+		return nil
+	}, &connection.ForEachShardOptions{
+		Concurrency: concurrency,
+		StopOnError: opts.StopOnError,
+		Context:     ctx,
+	})
+}
@@ -4,8 +4,12 @@ import (
 	"database/sql"
 
 	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/connection"
 	"go.knocknote.io/octillery/debug"
+	"go.knocknote.io/octillery/metrics"
 	"go.knocknote.io/octillery/sqlparser"
+	"go.knocknote.io/octillery/warning"
 )
 
 // InsertQueryExecutor inherits QueryExecutorBase structure
@@ -18,21 +22,312 @@ func NewInsertQueryExecutor(base *QueryExecutorBase) *InsertQueryExecutor {
 	return &InsertQueryExecutor{base}
 }
 
-// Query doesn't support in InsertQueryExecutor, returns always error.
+// Query executes INSERT query that has RETURNING clause for shards.
+// If query doesn't have RETURNING clause, returns always error.
 func (e *InsertQueryExecutor) Query() ([]*sql.Rows, error) {
-	return nil, errors.New("InsertQueryExecutor cannot invoke Query()")
+	query, err := e.returningQuery()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if query.IsMultiRows() {
+		return e.queryMultiRows(query)
+	}
+	shardConn, release, err := e.shardConnectionAndReserveUniqueValues(query)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	debug.Printf("(DB:%s):%s", shardConn.ShardName, query.String())
+	rows, err := e.execQuery(shardConn, query.String())
+	if err != nil {
+		return nil, errors.WithStack(e.releaseUniqueValues(release, err))
+	}
+	return []*sql.Rows{rows}, nil
 }
 
-// QueryRow doesn't support in InsertQueryExecutor, returns always error.
+// QueryRow executes INSERT query that has RETURNING clause for shards.
+// If query doesn't have RETURNING clause, returns always error.
 func (e *InsertQueryExecutor) QueryRow() (*sql.Row, error) {
-	return nil, errors.New("InsertQueryExecutor cannot invoke QueryRow()")
+	query, err := e.returningQuery()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if query.IsMultiRows() {
+		return e.queryRowMultiRows(query)
+	}
+	shardConn, release, err := e.shardConnectionAndReserveUniqueValues(query)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	debug.Printf("(DB:%s):%s", shardConn.ShardName, query.String())
+	row, err := e.execQueryRow(shardConn, query.String())
+	if err != nil {
+		return nil, errors.WithStack(e.releaseUniqueValues(release, err))
+	}
+	return row, nil
+}
+
+func (e *InsertQueryExecutor) returningQuery() (*sqlparser.InsertQuery, error) {
+	query, ok := e.query.(*sqlparser.InsertQuery)
+	if !ok {
+		return nil, errors.New("cannot convert to sqlparser.Query to sqlparser.InsertQuery")
+	}
+	if !query.IsReturning() {
+		return nil, errors.New("InsertQueryExecutor cannot invoke Query() without RETURNING clause")
+	}
+	return query, nil
+}
+
+func (e *InsertQueryExecutor) shardConnectionAndReserveUniqueValues(query *sqlparser.InsertQuery) (*connection.DBShardConnection, func() error, error) {
+	shardConn, err := e.shardConnection(query)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	e.recordRouting(metrics.SingleShard)
+	release, err := e.reserveUniqueValues(query)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return shardConn, release, nil
+}
+
+// insertShard rows of multi-row INSERT query inserted to the same shard
+type insertShard struct {
+	conn *connection.DBShardConnection
+	rows []*sqlparser.InsertQuery
+}
+
+// shardRows publishes next sequence id and decides shard for each row of multi-row INSERT query.
+// Rows are grouped by shard, and shards are ordered by the first row inserted to them.
+func (e *InsertQueryExecutor) shardRows(query *sqlparser.InsertQuery) ([]*insertShard, error) {
+	shards := []*insertShard{}
+	shardByName := map[string]*insertShard{}
+	for _, row := range query.RowQueries {
+		shardConn, err := e.shardConnection(row)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		shard, exists := shardByName[shardConn.ShardName]
+		if !exists {
+			shard = &insertShard{conn: shardConn}
+			shardByName[shardConn.ShardName] = shard
+			shards = append(shards, shard)
+		}
+		shard.rows = append(shard.rows, row)
+	}
+	if len(shards) == 1 {
+		e.recordRouting(metrics.SingleShard)
+	} else {
+		e.recordRouting(metrics.MultiShard)
+	}
+	return shards, nil
+}
+
+// reserveUniqueValuesOfRows reserves values of 'unique_columns' for all rows.
+// Returned function releases them, and it is called if inserting rows is failed.
+func (e *InsertQueryExecutor) reserveUniqueValuesOfRows(rows []*sqlparser.InsertQuery) (func() error, error) {
+	releases := []func() error{}
+	release := func() error {
+		for _, r := range releases {
+			if err := r(); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return nil
+	}
+	for _, row := range rows {
+		r, err := e.reserveUniqueValues(row)
+		if err != nil {
+			return nil, errors.WithStack(e.releaseUniqueValues(release, err))
+		}
+		releases = append(releases, r)
+	}
+	return release, nil
+}
+
+// queryMultiRows executes multi-row INSERT query that has RETURNING clause as bulk insert for each shard
+func (e *InsertQueryExecutor) queryMultiRows(query *sqlparser.InsertQuery) ([]*sql.Rows, error) {
+	shards, err := e.shardRows(query)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	results := []*sql.Rows{}
+	trace := &ExecutionTrace{}
+	for _, shard := range shards {
+		done := trace.start(shard.conn.ShardName)
+		rows, err := e.queryShardRows(query, shard)
+		done(err)
+		if err != nil {
+			for _, rows := range results {
+				rows.Close()
+			}
+			return nil, trace.wrap(errors.WithStack(err))
+		}
+		results = append(results, rows)
+	}
+	return results, nil
+}
+
+func (e *InsertQueryExecutor) queryShardRows(query *sqlparser.InsertQuery, shard *insertShard) (*sql.Rows, error) {
+	if err := e.contextErr(); err != nil {
+		return nil, errors.Wrapf(err, "cancelled before inserting to %s", shard.conn.ShardName)
+	}
+	release, err := e.reserveUniqueValuesOfRows(shard.rows)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	text := query.BulkString(shard.rows)
+	debug.Printf("(DB:%s):%s", shard.conn.ShardName, text)
+	rows, err := e.execQuery(shard.conn, text)
+	if err != nil {
+		return nil, errors.WithStack(e.releaseUniqueValues(release, err))
+	}
+	return rows, nil
+}
+
+// queryRowMultiRows executes multi-row INSERT query that has RETURNING clause.
+// sql.Row cannot merge rows returned by multiple shards, so all rows must be inserted to the same shard.
+func (e *InsertQueryExecutor) queryRowMultiRows(query *sqlparser.InsertQuery) (*sql.Row, error) {
+	shards, err := e.shardRows(query)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(shards) > 1 {
+		return nil, errors.Errorf("cannot invoke QueryRow() for INSERT query inserts rows to %d shards", len(shards))
+	}
+	shard := shards[0]
+	release, err := e.reserveUniqueValuesOfRows(shard.rows)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	text := query.BulkString(shard.rows)
+	debug.Printf("(DB:%s):%s", shard.conn.ShardName, text)
+	row, err := e.execQueryRow(shard.conn, text)
+	if err != nil {
+		return nil, errors.WithStack(e.releaseUniqueValues(release, err))
+	}
+	return row, nil
+}
+
+// execMultiRows executes multi-row INSERT query as bulk insert for each shard.
+// RowsAffected of result is the sum of all shards, and LastInsertId is id of the first row like MySQL.
+// If rows are inserted to multiple shards out of transaction, they are inserted in a transaction begun for this query,
+// so rows inserted to other shards are rolled back if inserting to a shard is failed.
+func (e *InsertQueryExecutor) execMultiRows(query *sqlparser.InsertQuery) (sql.Result, error) {
+	shards, err := e.shardRows(query)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if e.tx != nil || len(shards) == 1 {
+		return e.execShards(query, shards)
+	}
+	if cfg, err := config.Get(); err != nil || !cfg.DistributedTransaction {
+		return nil, errors.Errorf("cannot insert rows of %s to %d shards out of transaction. distributed_transaction is required", query.Table(), len(shards))
+	}
+	tx := e.conn.Begin(e.ctx, nil)
+	executor := &InsertQueryExecutor{QueryExecutorBase: &QueryExecutorBase{ctx: e.ctx, tx: tx, conn: e.conn, query: e.query}}
+	result, err := executor.execShards(query, shards)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			debug.Printf("failed to rollback inserting rows: %+v", rollbackErr)
+		}
+		return nil, errors.WithStack(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrapf(err, "cannot commit rows of %s inserted to %d shards", query.Table(), len(shards))
+	}
+	return result, nil
+}
+
+// execShards inserts rows grouped by shard
+func (e *InsertQueryExecutor) execShards(query *sqlparser.InsertQuery, shards []*insertShard) (sql.Result, error) {
+	var (
+		firstResult  sql.Result
+		affectedRows int64
+	)
+	trace := &ExecutionTrace{}
+	for _, shard := range shards {
+		done := trace.start(shard.conn.ShardName)
+		result, err := e.execShardRows(query, shard)
+		done(err)
+		if err != nil {
+			return nil, trace.wrap(errors.WithStack(err))
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return nil, trace.wrap(errors.WithStack(err))
+		}
+		affectedRows += rowsAffected
+		if firstResult == nil {
+			firstResult = result
+		}
+	}
+	if e.conn.IsUsedSequencer {
+		return &mergedResult{affectedRows: affectedRows, lastInsertedID: int64(query.RowQueries[0].NextSequenceID())}, nil
+	}
+	return e.shardLocalIDResult(query, &multiRowsResult{Result: firstResult, affectedRows: affectedRows}), nil
+}
+
+func (e *InsertQueryExecutor) execShardRows(query *sqlparser.InsertQuery, shard *insertShard) (sql.Result, error) {
+	if err := e.contextErr(); err != nil {
+		return nil, errors.Wrapf(err, "cancelled before inserting to %s", shard.conn.ShardName)
+	}
+	release, err := e.reserveUniqueValuesOfRows(shard.rows)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	text := query.BulkString(shard.rows)
+	debug.Printf("(DB:%s):%s", shard.conn.ShardName, text)
+	result, err := e.exec(shard.conn, text)
+	if err != nil {
+		return nil, errors.WithStack(e.releaseUniqueValues(release, err))
+	}
+	return result, nil
+}
+
+// reserveUniqueValues reserves values of 'unique_columns' in sequencer's database.
+// Returned function releases them, and it is called if inserting row is failed.
+func (e *InsertQueryExecutor) reserveUniqueValues(query *sqlparser.InsertQuery) (func() error, error) {
+	if e.conn.Config == nil || len(e.conn.Config.UniqueColumns) == 0 {
+		return func() error { return nil }, nil
+	}
+	values := map[string]string{}
+	for _, column := range e.conn.Config.UniqueColumns {
+		if val, exists := query.ColumnValue(column); exists {
+			values[column] = string(val.Val)
+		}
+	}
+	release, err := e.conn.ReserveUniqueValues(e.ctx, e.tx, query.Table(), values)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return release, nil
+}
+
+// releaseUniqueValues releases values reserved for row failed to insert, and returns error of insertion
+func (e *InsertQueryExecutor) releaseUniqueValues(release func() error, err error) error {
+	if releaseErr := release(); releaseErr != nil {
+		return errors.Wrapf(releaseErr, "cannot release unique values after %s", err)
+	}
+	return err
 }
 
 func (e *InsertQueryExecutor) nextSequenceID(query *sqlparser.InsertQuery) (int64, error) {
 	if !e.conn.IsUsedSequencer {
 		return 0, nil
 	}
-	nextSequenceID, err := e.conn.NextSequenceID(query.TableName)
+	if id := query.NextSequenceID(); id > 0 {
+		// id is already published ( e.g. query replayed by QueryLog for recovery ), so the row is inserted by the same id
+		return int64(id), nil
+	}
+	var (
+		nextSequenceID int64
+		err            error
+	)
+	if query.ShardKeyID != sqlparser.UnknownID {
+		nextSequenceID, err = e.conn.NextSequenceIDByKeyContext(e.ctx, query.TableName, int64(query.ShardKeyID))
+	} else {
+		nextSequenceID, err = e.conn.NextSequenceIDContext(e.ctx, query.TableName)
+	}
 	if err != nil {
 		return 0, errors.WithStack(err)
 	}
@@ -40,14 +335,9 @@ func (e *InsertQueryExecutor) nextSequenceID(query *sqlparser.InsertQuery) (int6
 	return nextSequenceID, nil
 }
 
-// Exec executes INSERT query for shards.
-func (e *InsertQueryExecutor) Exec() (sql.Result, error) {
-	query, ok := e.query.(*sqlparser.InsertQuery)
-	if !ok {
-		return nil, errors.New("cannot convert to sqlparser.Query to sqlparser.InsertQuery")
-	}
-
-	if e.conn.IsUsedSequencer && e.conn.Sequencer == nil {
+// shardConnection publishes next sequence id and decides shard for inserting row
+func (e *InsertQueryExecutor) shardConnection(query *sqlparser.InsertQuery) (*connection.DBShardConnection, error) {
+	if e.conn.IsUsedSequencer && e.conn.Sequencer == nil && e.conn.IDGenerator == nil {
 		return nil, errors.New("cannot insert row. sequencer's connection is nil")
 	}
 	if e.conn.ShardConnections.ShardNum() == 0 {
@@ -66,17 +356,80 @@ func (e *InsertQueryExecutor) Exec() (sql.Result, error) {
 	if shardKeyID == sqlparser.UnknownID {
 		return nil, errors.New("shard_key id is not found")
 	}
-	shardConn, err := e.conn.ShardConnectionByID(int64(shardKeyID))
+	shardConn, err := e.routeSingleShardByID(int64(shardKeyID))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return shardConn, nil
+}
+
+// Exec executes INSERT query for shards.
+func (e *InsertQueryExecutor) Exec() (sql.Result, error) {
+	query, ok := e.query.(*sqlparser.InsertQuery)
+	if !ok {
+		return nil, errors.New("cannot convert to sqlparser.Query to sqlparser.InsertQuery")
+	}
+	if query.IsMultiRows() {
+		return e.execMultiRows(query)
+	}
+	shardConn, release, err := e.shardConnectionAndReserveUniqueValues(query)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	nextSequenceID := int64(query.NextSequenceID())
+	if !e.conn.IsUsedSequencer && e.conn.IsRequiredReturningID() && !query.IsReturning() {
+		// driver cannot support LastInsertId(), so get inserted id by RETURNING clause
+		query.Returning = e.conn.Config.IdentityColumn()
+		debug.Printf("(DB:%s):%s", shardConn.ShardName, query.String())
+		result, err := e.execReturningID(shardConn, query.String())
+		if err != nil {
+			return nil, errors.WithStack(e.releaseUniqueValues(release, err))
+		}
+		return e.shardLocalIDResult(query, result), nil
+	}
 	debug.Printf("(DB:%s):%s", shardConn.ShardName, query.String())
 	result, err := e.exec(shardConn, query.String())
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, errors.WithStack(e.releaseUniqueValues(release, err))
 	}
 	if e.conn.IsUsedSequencer {
 		return &mergedResult{affectedRows: 1, lastInsertedID: nextSequenceID}, nil
 	}
-	return result.(sql.Result), nil
+	return e.shardLocalIDResult(query, result.(sql.Result)), nil
+}
+
+// shardLocalIDResult wraps result to warn LastInsertId if table enables 'auto_increment: warn'
+func (e *InsertQueryExecutor) shardLocalIDResult(query *sqlparser.InsertQuery, result sql.Result) sql.Result {
+	if e.conn.Config == nil || e.conn.Config.AutoIncrement != config.AutoIncrementWarn {
+		return result
+	}
+	return &shardLocalIDResult{Result: result, table: query.Table(), query: query.String()}
+}
+
+// shardLocalIDResult result of INSERT whose LastInsertId is auto increment id unique only in a shard
+type shardLocalIDResult struct {
+	sql.Result
+	table string
+	query string
+}
+
+// multiRowsResult result of multi-row INSERT executed for some shards.
+// LastInsertId is the one of the shard inserted the first row.
+type multiRowsResult struct {
+	sql.Result
+	affectedRows int64
+}
+
+func (r *multiRowsResult) RowsAffected() (int64, error) {
+	return r.affectedRows, nil
+}
+
+func (r *shardLocalIDResult) LastInsertId() (int64, error) {
+	warning.Warn(&warning.Warning{
+		Code:    warning.ShardLocalID,
+		Message: "LastInsertId is auto increment id of a shard. it may collide with id of the other shards",
+		Table:   r.table,
+		Query:   r.query,
+	})
+	return r.Result.LastInsertId()
 }
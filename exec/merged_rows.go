@@ -0,0 +1,156 @@
+package exec
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+var (
+	mergedRowsDB     *sql.DB
+	mergedRowsDBOnce sync.Once
+	mergedRowsSets   sync.Map
+	mergedRowsID     uint64
+)
+
+// rowsMerger merges rows selected from all shards
+type rowsMerger interface {
+	// shardQuery returns query and arguments executed on each shard
+	shardQuery() (string, []interface{})
+	// merge merges rows of all shards
+	merge(allRows []*sql.Rows) (*mergedRowsSet, error)
+}
+
+// newRowsMerger returns nil if rows selected by query cannot be merged
+func newRowsMerger(query *sqlparser.QueryBase) (rowsMerger, error) {
+	if merger := newAggregateMerger(query); merger != nil {
+		return merger, nil
+	}
+	merger, err := newOrderedMerger(query)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if merger != nil {
+		return merger, nil
+	}
+	return nil, nil
+}
+
+// mergedRowsSet rows built from results of all shards
+type mergedRowsSet struct {
+	columns []string
+	values  [][]driver.Value
+	// if source is not nil, rows are read from it lazily instead of values
+	source mergedRowsSource
+}
+
+// mergedRowsSource reads merged rows lazily from rows of shards
+type mergedRowsSource interface {
+	// next sets values of next row to dest. returns io.EOF if there are no more rows
+	next(dest []driver.Value) error
+	// close closes rows of shards
+	close() error
+}
+
+// queryKey registers rows and returns query text for fetching them by mergedRowsDriver
+func (s *mergedRowsSet) queryKey() string {
+	key := fmt.Sprintf("merged_rows_%d", atomic.AddUint64(&mergedRowsID, 1))
+	mergedRowsSets.Store(key, s)
+	return key
+}
+
+// openMergedRowsDB opens *sql.DB by mergedRowsDriver without registration to keep sql.Drivers() as is
+func openMergedRowsDB() *sql.DB {
+	mergedRowsDBOnce.Do(func() {
+		mergedRowsDB = sql.OpenDB(&mergedRowsDriver{})
+	})
+	return mergedRowsDB
+}
+
+// Rows returns merged rows as *sql.Rows
+func (s *mergedRowsSet) Rows() (*sql.Rows, error) {
+	rows, err := openMergedRowsDB().Query(s.queryKey())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return rows, nil
+}
+
+// Row returns first row of merged rows as *sql.Row
+func (s *mergedRowsSet) Row() (*sql.Row, error) {
+	return openMergedRowsDB().QueryRow(s.queryKey()), nil
+}
+
+// mergedRowsDriver implements driver.Driver and driver.Connector
+type mergedRowsDriver struct{}
+
+func (*mergedRowsDriver) Open(name string) (driver.Conn, error) {
+	return &mergedRowsConn{}, nil
+}
+
+func (*mergedRowsDriver) Connect(context.Context) (driver.Conn, error) {
+	return &mergedRowsConn{}, nil
+}
+
+func (d *mergedRowsDriver) Driver() driver.Driver {
+	return d
+}
+
+// mergedRowsConn implements driver.Queryer. query text is the key of registered mergedRowsSet.
+type mergedRowsConn struct{}
+
+func (*mergedRowsConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("merged rows doesn't support Prepare")
+}
+
+func (*mergedRowsConn) Close() error {
+	return nil
+}
+
+func (*mergedRowsConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("merged rows doesn't support transaction")
+}
+
+func (*mergedRowsConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	set, exists := mergedRowsSets.Load(query)
+	if !exists {
+		return nil, errors.Errorf("cannot find merged rows %s", query)
+	}
+	mergedRowsSets.Delete(query)
+	return &mergedRows{set: set.(*mergedRowsSet)}, nil
+}
+
+type mergedRows struct {
+	set   *mergedRowsSet
+	index int
+}
+
+func (r *mergedRows) Columns() []string {
+	return r.set.columns
+}
+
+func (r *mergedRows) Close() error {
+	if r.set.source != nil {
+		return r.set.source.close()
+	}
+	return nil
+}
+
+func (r *mergedRows) Next(dest []driver.Value) error {
+	if r.set.source != nil {
+		return r.set.source.next(dest)
+	}
+	if r.index >= len(r.set.values) {
+		return io.EOF
+	}
+	copy(dest, r.set.values[r.index])
+	r.index++
+	return nil
+}
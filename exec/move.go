@@ -0,0 +1,181 @@
+package exec
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/connection"
+	"go.knocknote.io/octillery/debug"
+	"go.knocknote.io/octillery/metrics"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+// execShardKeyMove executes UPDATE query changing shard_key by moving rows to the shard of new shard_key value.
+// Rows are deleted from current shard and inserted to new shard with values assigned by SET clause.
+// If query is executed out of transaction, they are executed in a transaction begun for this query.
+func (e *UpdateQueryExecutor) execShardKeyMove(query *sqlparser.QueryBase) (sql.Result, error) {
+	from, err := e.conn.ShardConnectionByID(int64(query.ShardKeyID))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	to, err := e.conn.ShardConnectionByID(int64(query.ShardKeyMove.ShardKeyID))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if from.ShardName == to.ShardName {
+		// both values are mapped to the same shard, so rows are updated in place
+		e.recordRouting(metrics.SingleShard)
+		debug.Printf("(DB:%s):%s", from.ShardName, query.Text)
+		return e.exec(from, query.Text, query.Args...)
+	}
+	if cfg, err := config.Get(); err != nil || !cfg.DistributedTransaction {
+		return nil, errors.Errorf("cannot move rows of %s from %s to %s. distributed_transaction is required", query.Table(), from.ShardName, to.ShardName)
+	}
+	e.recordRouting(metrics.MultiShard)
+	if e.tx != nil {
+		return e.moveRows(e.QueryExecutorBase, query, from, to)
+	}
+	tx := e.conn.Begin(e.ctx, nil)
+	result, err := e.moveRows(&QueryExecutorBase{ctx: e.ctx, tx: tx, conn: e.conn, query: e.query}, query, from, to)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			debug.Printf("failed to rollback moving rows: %+v", rollbackErr)
+		}
+		return nil, errors.WithStack(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrapf(err, "cannot commit moving rows of %s from %s to %s", query.Table(), from.ShardName, to.ShardName)
+	}
+	return result, nil
+}
+
+// moveRows reads rows matched by WHERE clause with lock, and deletes them by their keys actually read,
+// so rows inserted or updated by others after reading are never deleted without being moved.
+func (e *UpdateQueryExecutor) moveRows(base *QueryExecutorBase, query *sqlparser.QueryBase, from, to *connection.DBShardConnection) (sql.Result, error) {
+	move := query.ShardKeyMove
+	keyColumns, err := e.movedRowKeyColumns(query.Table())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	selectQuery := fmt.Sprintf("SELECT * FROM %s WHERE %s", query.Table(), move.Where)
+	lock, err := e.forUpdateClause()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot move rows")
+	}
+	if lock != "" {
+		selectQuery += " " + lock
+	}
+	debug.Printf("(DB:%s):%s", from.ShardName, selectQuery)
+	rows, err := base.execQuery(from, selectQuery, move.WhereArgs...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	columns, values, err := scanMovedRows(rows)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(values) == 0 {
+		return &mergedResult{}, nil
+	}
+	columnIndex := func(column string) int {
+		for idx, col := range columns {
+			if strings.EqualFold(col, column) {
+				return idx
+			}
+		}
+		return -1
+	}
+	for _, column := range move.Columns {
+		if columnIndex(column) < 0 {
+			return nil, errors.Errorf("cannot move rows. %s is not a column of %s", column, query.Table())
+		}
+	}
+	keyIndexes := []int{}
+	conditions := []string{}
+	for _, column := range keyColumns {
+		idx := columnIndex(column)
+		if idx < 0 {
+			return nil, errors.Errorf("cannot move rows. %s is not a column of %s", column, query.Table())
+		}
+		keyIndexes = append(keyIndexes, idx)
+		conditions = append(conditions, fmt.Sprintf("%s = ?", column))
+	}
+
+	deleteQuery := fmt.Sprintf("DELETE FROM %s WHERE %s", query.Table(), strings.Join(conditions, " AND "))
+	debug.Printf("(DB:%s):%s", from.ShardName, deleteQuery)
+	var deletedRows int64
+	for _, row := range values {
+		keys := make([]interface{}, 0, len(keyIndexes))
+		for _, idx := range keyIndexes {
+			keys = append(keys, row[idx])
+		}
+		result, err := base.exec(from, deleteQuery, keys...)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		affectedRows, err := result.RowsAffected()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		deletedRows += affectedRows
+	}
+	if deletedRows != int64(len(values)) {
+		return nil, errors.Errorf("cannot move rows of %s. %d rows are deleted from %s but %d rows are read", query.Table(), deletedRows, from.ShardName, len(values))
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	insertQuery := fmt.Sprintf("INSERT INTO %s(%s) VALUES (%s)", query.Table(), strings.Join(columns, ", "), placeholders)
+	debug.Printf("(DB:%s):%s", to.ShardName, insertQuery)
+	for _, row := range values {
+		for idx, column := range columns {
+			if value, assigned := move.ValueByColumn(column); assigned {
+				row[idx] = value
+			}
+		}
+		if _, err := base.exec(to, insertQuery, row...); err != nil {
+			return nil, errors.Wrapf(err, "cannot insert moved row to %s", to.ShardName)
+		}
+	}
+	return &mergedResult{affectedRows: int64(len(values))}, nil
+}
+
+// movedRowKeyColumns returns columns identifying moved rows.
+// shard_column is unique for all shards, otherwise primary key of schema fetched by adapter is used.
+func (e *UpdateQueryExecutor) movedRowKeyColumns(tableName string) ([]string, error) {
+	if e.conn.ShardColumnName != "" {
+		return []string{e.conn.ShardColumnName}, nil
+	}
+	schema, err := e.conn.TableSchema(tableName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot move rows. primary key of %s is required", tableName)
+	}
+	keyColumns := schema.PrimaryKey()
+	if len(keyColumns) == 0 {
+		return nil, errors.Errorf("cannot move rows. %s doesn't have primary key", tableName)
+	}
+	return keyColumns, nil
+}
+
+// scanMovedRows reads all columns of rows as they are, so they can be passed to INSERT query as arguments
+func scanMovedRows(rows *sql.Rows) ([]string, [][]interface{}, error) {
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	values := [][]interface{}{}
+	for rows.Next() {
+		row := make([]interface{}, len(columns))
+		dest := make([]interface{}, len(columns))
+		for idx := range row {
+			dest[idx] = &row[idx]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		values = append(values, row)
+	}
+	return columns, values, errors.WithStack(rows.Err())
+}
@@ -0,0 +1,85 @@
+package exec
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/connection"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+// ErrMultiStatementNotAcknowledged returned when statements of multi statement query are routed to different shards
+// and it is executed by context that isn't created by WithBroadcast
+var ErrMultiStatementNotAcknowledged = errors.New("statements of multi statement query routed to different shards require context created by WithBroadcast")
+
+type broadcastKey struct{}
+
+// WithBroadcast returns context that acknowledges multi statement query whose statements are routed to different shards.
+func WithBroadcast(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, broadcastKey{}, true)
+}
+
+// IsBroadcastAcknowledged returns whether context is created by WithBroadcast or not.
+func IsBroadcastAcknowledged(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	acknowledged, _ := ctx.Value(broadcastKey{}).(bool)
+	return acknowledged
+}
+
+// ValidateMultiStatement returns error if statements of multi statement query are not routed to the same shard.
+// Statement for all shards or statement whose shard is decided by sequencer or routing interceptor is regarded as routed to different shards.
+// If ctx is created by WithBroadcast, always returns nil.
+func ValidateMultiStatement(ctx context.Context, conns []*connection.DBConnection, queries []sqlparser.Query) error {
+	if IsBroadcastAcknowledged(ctx) {
+		return nil
+	}
+	target := ""
+	for idx, query := range queries {
+		dsn, err := routedDSN(conns[idx], query)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if dsn == "" {
+			return errors.Wrapf(ErrMultiStatementNotAcknowledged, "%d-th statement is not routed to single shard", idx+1)
+		}
+		if target == "" {
+			target = dsn
+			continue
+		}
+		if target != dsn {
+			return errors.Wrapf(ErrMultiStatementNotAcknowledged, "%d-th statement is routed to %s but the others are routed to %s", idx+1, dsn, target)
+		}
+	}
+	return nil
+}
+
+// routedDSN returns DSN of database that query is routed to. If it cannot be decided before execution, returns empty string.
+func routedDSN(conn *connection.DBConnection, query sqlparser.Query) (string, error) {
+	if !conn.IsShard {
+		return conn.DSN(), nil
+	}
+	var shardKeyID sqlparser.Identifier
+	switch q := query.(type) {
+	case *sqlparser.QueryBase:
+		shardKeyID = q.ShardKeyID
+	case *sqlparser.InsertQuery:
+		shardKeyID = q.ShardKeyID
+	case *sqlparser.DeleteQuery:
+		shardKeyID = q.ShardKeyID
+	default:
+		return "", nil
+	}
+	if shardKeyID == sqlparser.UnknownID || conn.HasRoutingInterceptor() {
+		return "", nil
+	}
+	shardConn, err := conn.ShardConnectionByID(int64(shardKeyID))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return shardConn.DSN(), nil
+}
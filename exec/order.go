@@ -0,0 +1,313 @@
+package exec
+
+import (
+	"container/heap"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strconv"
+	"strings"
+
+	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+// orderedMerger merges rows selected from all shards by ORDER BY columns, and applies LIMIT/OFFSET to merged rows.
+//
+// Each shard returns rows sorted by ORDER BY clause, so merged rows are read lazily by k-way merge.
+type orderedMerger struct {
+	orderBy []*sqlparser.OrderByColumn
+	limit   *sqlparser.LimitClause
+	// number of columns selected only for sorting. they are the last columns of rows of shards, and are removed from merged rows
+	hiddenColumns int
+	// query executed on each shard
+	queryText string
+	args      []interface{}
+}
+
+// newOrderedMerger returns nil if query has neither ORDER BY clause nor LIMIT clause,
+// or if query is sorted by expression that cannot be selected additionally by query of each shard.
+func newOrderedMerger(query *sqlparser.QueryBase) (*orderedMerger, error) {
+	stmt, ok := query.Stmt.(*vtparser.Select)
+	if !ok {
+		return nil, nil
+	}
+	var shardStmt *vtparser.Select
+	hiddenColumns := 0
+	orderBy := query.OrderBy()
+	if len(stmt.OrderBy) > 0 && orderBy == nil {
+		// sorted by expressions not in select list, so they are selected by query of each shard additionally
+		shardStmt, orderBy, hiddenColumns = query.OrderByWithHiddenColumns()
+		if shardStmt == nil {
+			return nil, nil
+		}
+	}
+	limit, err := query.Limit()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if orderBy == nil && limit == nil {
+		return nil, nil
+	}
+	merger := &orderedMerger{
+		orderBy:       orderBy,
+		limit:         limit,
+		hiddenColumns: hiddenColumns,
+		queryText:     query.Text,
+		args:          query.Args,
+	}
+	rewriteLimit := limit != nil && (limit.Offset > 0 || len(limit.ArgIndexes) > 0)
+	if !rewriteLimit && shardStmt == nil {
+		return merger, nil
+	}
+	if shardStmt == nil {
+		copied := *stmt
+		shardStmt = &copied
+	}
+	if rewriteLimit {
+		// rows skipped by OFFSET are decided after merge, so each shard must return rows until OFFSET + LIMIT
+		shardStmt.Limit = &vtparser.Limit{
+			Rowcount: vtparser.NewIntVal([]byte(strconv.FormatInt(limit.Offset+limit.Count, 10))),
+		}
+		merger.args = removeArgs(query.Args, limit.ArgIndexes)
+	}
+	merger.queryText = sqlparser.StringWithPlaceholder(shardStmt)
+	return merger, nil
+}
+
+// removeArgs returns arguments excluded specified indexes
+func removeArgs(args []interface{}, indexes []int) []interface{} {
+	removed := map[int]bool{}
+	for _, index := range indexes {
+		removed[index] = true
+	}
+	newArgs := make([]interface{}, 0, len(args))
+	for idx, arg := range args {
+		if !removed[idx] {
+			newArgs = append(newArgs, arg)
+		}
+	}
+	return newArgs
+}
+
+func (m *orderedMerger) shardQuery() (string, []interface{}) {
+	return m.queryText, m.args
+}
+
+// merge returns rows merged lazily. rows of shards are closed when merged rows are closed.
+func (m *orderedMerger) merge(allRows []*sql.Rows) (*mergedRowsSet, error) {
+	source := &orderedRowsSource{merger: m, allRows: allRows}
+	if len(allRows) == 0 {
+		return &mergedRowsSet{source: source}, nil
+	}
+	columns, err := allRows[0].Columns()
+	if err != nil {
+		source.close()
+		return nil, errors.WithStack(err)
+	}
+	keyIndexes, err := m.keyIndexes(columns)
+	if err != nil {
+		source.close()
+		return nil, errors.WithStack(err)
+	}
+	source.keyIndexes = keyIndexes
+	columnTypes, err := allRows[0].ColumnTypes()
+	if err != nil {
+		source.close()
+		return nil, errors.WithStack(err)
+	}
+	source.keyKinds = make([]valueKind, len(keyIndexes))
+	for idx, keyIndex := range keyIndexes {
+		source.keyKinds[idx] = columnKind(columnTypes[keyIndex])
+	}
+	if m.hiddenColumns > len(columns) {
+		source.close()
+		return nil, errors.Errorf("cannot find %d hidden columns for ORDER BY in %v", m.hiddenColumns, columns)
+	}
+	for idx, rows := range allRows {
+		cursor := &orderedCursor{shardIndex: idx, rows: rows}
+		exists, err := cursor.fetch()
+		if err != nil {
+			source.close()
+			return nil, errors.WithStack(err)
+		}
+		if exists {
+			source.cursors = append(source.cursors, cursor)
+		}
+	}
+	heap.Init(source)
+	if source.err != nil {
+		source.close()
+		return nil, errors.WithStack(source.err)
+	}
+	return &mergedRowsSet{columns: columns[:len(columns)-m.hiddenColumns], source: source}, nil
+}
+
+// keyIndexes returns indexes of ORDER BY columns in columns of rows
+func (m *orderedMerger) keyIndexes(columns []string) ([]int, error) {
+	indexes := make([]int, 0, len(m.orderBy))
+	for _, column := range m.orderBy {
+		if column.Position > 0 {
+			if column.Position > len(columns) {
+				return nil, errors.Errorf("ORDER BY position %d is out of range", column.Position)
+			}
+			indexes = append(indexes, column.Position-1)
+			continue
+		}
+		index := -1
+		for idx, name := range columns {
+			if strings.EqualFold(name, column.Name) {
+				index = idx
+				break
+			}
+		}
+		if index < 0 {
+			return nil, errors.Errorf("cannot find ORDER BY column %s in %v", column.Name, columns)
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+// orderedCursor current row of a shard
+type orderedCursor struct {
+	shardIndex int
+	rows       *sql.Rows
+	values     []interface{}
+}
+
+// fetch reads next row. returns false if there are no more rows.
+func (c *orderedCursor) fetch() (bool, error) {
+	if !c.rows.Next() {
+		return false, errors.WithStack(c.rows.Err())
+	}
+	columns, err := c.rows.Columns()
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for idx := range values {
+		dest[idx] = &values[idx]
+	}
+	if err := c.rows.Scan(dest...); err != nil {
+		return false, errors.WithStack(err)
+	}
+	c.values = values
+	return true, nil
+}
+
+// orderedRowsSource implements mergedRowsSource and heap.Interface.
+// Top of heap is the cursor has next row of merged rows.
+type orderedRowsSource struct {
+	merger     *orderedMerger
+	allRows    []*sql.Rows
+	keyIndexes []int
+	// how values of ORDER BY columns are compared
+	keyKinds []valueKind
+	cursors  []*orderedCursor
+	skipped  int64
+	returned int64
+	// error occurred while comparing values
+	err error
+}
+
+func (s *orderedRowsSource) Len() int {
+	return len(s.cursors)
+}
+
+func (s *orderedRowsSource) Less(i, j int) bool {
+	cmp, err := s.compare(s.cursors[i], s.cursors[j])
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	return cmp < 0
+}
+
+func (s *orderedRowsSource) Swap(i, j int) {
+	s.cursors[i], s.cursors[j] = s.cursors[j], s.cursors[i]
+}
+
+func (s *orderedRowsSource) Push(x interface{}) {
+	s.cursors = append(s.cursors, x.(*orderedCursor))
+}
+
+func (s *orderedRowsSource) Pop() interface{} {
+	last := s.cursors[len(s.cursors)-1]
+	s.cursors = s.cursors[:len(s.cursors)-1]
+	return last
+}
+
+// compare compares rows by ORDER BY columns. NULL is smaller than any other value.
+// If rows are equal, row of former shard is smaller to keep order stable.
+func (s *orderedRowsSource) compare(a *orderedCursor, b *orderedCursor) (int, error) {
+	for idx, keyIndex := range s.keyIndexes {
+		av, bv := a.values[keyIndex], b.values[keyIndex]
+		var cmp int
+		switch {
+		case av == nil && bv == nil:
+			cmp = 0
+		case av == nil:
+			cmp = -1
+		case bv == nil:
+			cmp = 1
+		default:
+			c, err := compareValue(av, bv, s.keyKinds[idx])
+			if err != nil {
+				return 0, errors.WithStack(err)
+			}
+			cmp = c
+		}
+		if s.merger.orderBy[idx].Desc {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp, nil
+		}
+	}
+	return a.shardIndex - b.shardIndex, nil
+}
+
+func (s *orderedRowsSource) next(dest []driver.Value) error {
+	limit := s.merger.limit
+	for {
+		if s.err != nil {
+			return s.err
+		}
+		if len(s.cursors) == 0 || (limit != nil && s.returned >= limit.Count) {
+			return io.EOF
+		}
+		cursor := s.cursors[0]
+		values := cursor.values
+		exists, err := cursor.fetch()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if exists {
+			heap.Fix(s, 0)
+		} else {
+			heap.Pop(s)
+		}
+		if limit != nil && s.skipped < limit.Offset {
+			s.skipped++
+			continue
+		}
+		s.returned++
+		// hidden columns are not copied as dest has only columns of merged rows
+		for idx := range dest {
+			dest[idx] = values[idx]
+		}
+		return nil
+	}
+}
+
+func (s *orderedRowsSource) close() error {
+	var closeErr error
+	for _, rows := range s.allRows {
+		if err := rows.Close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+	return errors.WithStack(closeErr)
+}
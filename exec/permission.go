@@ -0,0 +1,50 @@
+package exec
+
+import (
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/connection"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+var (
+	// ErrReadOnlyTable returned when write query or DDL is executed to the table configured as read_only
+	ErrReadOnlyTable = errors.New("cannot execute write query to read only table")
+
+	// ErrWriteOnlyTable returned when read query is executed to the table configured as write_only
+	ErrWriteOnlyTable = errors.New("cannot execute read query to write only table")
+)
+
+// ValidatePermission validates whether query is allowed by read_only or write_only configuration of table.
+// Returned error can be compared with ErrReadOnlyTable or ErrWriteOnlyTable by errors.Cause.
+func ValidatePermission(conn *connection.DBConnection, query sqlparser.Query) error {
+	if conn.Config == nil {
+		return nil
+	}
+	queryType := query.QueryType()
+	if conn.Config.ReadOnly && !queryType.IsReadQuery() {
+		return errors.Wrapf(ErrReadOnlyTable, "%s to %s", queryType, query.Table())
+	}
+	if conn.Config.WriteOnly && queryType == sqlparser.Select {
+		return errors.Wrapf(ErrWriteOnlyTable, "%s to %s", queryType, query.Table())
+	}
+	return errors.WithStack(validateJoinTablePermission(query))
+}
+
+// validateJoinTablePermission validates tables joined by SELECT query are not write_only
+func validateJoinTablePermission(query sqlparser.Query) error {
+	queryBase, ok := query.(*sqlparser.QueryBase)
+	if !ok || !queryBase.IsJoinQuery() {
+		return nil
+	}
+	cfg, err := config.Get()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, tableName := range queryBase.JoinTableNames {
+		if table, exists := cfg.Tables[tableName]; exists && table.WriteOnly {
+			return errors.Wrapf(ErrWriteOnlyTable, "%s to %s", queryBase.QueryType(), tableName)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package exec
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/connection"
+	"go.knocknote.io/octillery/debug"
+	"go.knocknote.io/octillery/metrics"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+// queryReturning executes UPDATE/DELETE query that has RETURNING clause.
+// If query doesn't include shard_key, it is executed to all shards under all_shard_write_policy.
+func (e *QueryExecutorBase) queryReturning(query *sqlparser.QueryBase) ([]*sql.Rows, error) {
+	if !query.IsReturning() {
+		return nil, errors.Errorf("cannot invoke Query() for %s query without RETURNING clause", query.QueryType())
+	}
+	if !query.IsNotFoundShardKeyID() {
+		shardConns, err := e.routeShardByID(int64(query.ShardKeyID))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if len(shardConns) > 1 {
+			// broadened by routing interceptor
+			e.recordRouting(metrics.MultiShard)
+			return e.queryReturningShards(shardConns, query)
+		}
+		shardConn := shardConns[0]
+		e.recordRouting(metrics.SingleShard)
+		debug.Printf("(DB:%s):%s", shardConn.ShardName, query.Text)
+		rows, err := e.execQuery(shardConn, query.Text, query.Args...)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return []*sql.Rows{rows}, nil
+	}
+	if err := e.validateAllShardWrite(false); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	shardConns, err := e.routeAllShards()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	e.recordRouting(metrics.Broadcast)
+	return e.queryReturningShards(shardConns, query)
+}
+
+// queryReturningShards executes UPDATE/DELETE query that has RETURNING clause on shards
+func (e *QueryExecutorBase) queryReturningShards(shardConns []*connection.DBShardConnection, query *sqlparser.QueryBase) ([]*sql.Rows, error) {
+	results := []*sql.Rows{}
+	trace := &ExecutionTrace{}
+	for _, shardConn := range shardConns {
+		if err := e.contextErr(); err != nil {
+			for _, rows := range results {
+				rows.Close()
+			}
+			return nil, trace.wrap(errors.Wrapf(err, "cancelled before querying %s", shardConn.ShardName))
+		}
+		debug.Printf("(DB:%s):%s", shardConn.ShardName, query.Text)
+		done := trace.start(shardConn.ShardName)
+		rows, err := e.execQuery(shardConn, query.Text, query.Args...)
+		done(err)
+		if err != nil {
+			for _, rows := range results {
+				rows.Close()
+			}
+			return nil, trace.wrap(errors.WithStack(err))
+		}
+		results = append(results, rows)
+	}
+	return results, nil
+}
+
+// queryRowReturning executes UPDATE/DELETE query that has RETURNING clause for single shard.
+func (e *QueryExecutorBase) queryRowReturning(query *sqlparser.QueryBase) (*sql.Row, error) {
+	if !query.IsReturning() {
+		return nil, errors.Errorf("cannot invoke QueryRow() for %s query without RETURNING clause", query.QueryType())
+	}
+	if query.IsNotFoundShardKeyID() {
+		return nil, errors.New("cannot invoke QueryRow() for query without shard_key. use Query() instead")
+	}
+	shardConn, err := e.routeSingleShardByID(int64(query.ShardKeyID))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	e.recordRouting(metrics.SingleShard)
+	debug.Printf("(DB:%s):%s", shardConn.ShardName, query.Text)
+	row, err := e.execQueryRow(shardConn, query.Text, query.Args...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return row, nil
+}
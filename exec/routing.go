@@ -0,0 +1,49 @@
+package exec
+
+import (
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/connection"
+)
+
+// routeShards returns shards decided by routing interceptor of connection from shards chosen by sharding algorithm
+func (e *QueryExecutorBase) routeShards(proposedShards []*connection.DBShardConnection) ([]*connection.DBShardConnection, error) {
+	shardConns, err := e.conn.RouteShards(e.query, proposedShards)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return shardConns, nil
+}
+
+// routeShardByID returns shards for query having shard_key of id
+func (e *QueryExecutorBase) routeShardByID(id int64) ([]*connection.DBShardConnection, error) {
+	shardConn, err := e.conn.ShardConnectionByID(id)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	shardConns, err := e.routeShards([]*connection.DBShardConnection{shardConn})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return shardConns, nil
+}
+
+// routeSingleShardByID returns shard for query that can be executed only on a single shard ( e.g. INSERT )
+func (e *QueryExecutorBase) routeSingleShardByID(id int64) (*connection.DBShardConnection, error) {
+	shardConns, err := e.routeShardByID(id)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(shardConns) != 1 {
+		return nil, errors.Errorf("cannot execute %s query of %s on %d shards decided by routing interceptor", e.query.QueryType(), e.query.Table(), len(shardConns))
+	}
+	return shardConns[0], nil
+}
+
+// routeAllShards returns shards for query without shard_key
+func (e *QueryExecutorBase) routeAllShards() ([]*connection.DBShardConnection, error) {
+	shardConns, err := e.routeShards(e.conn.ShardConnections.AllShard())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return shardConns, nil
+}
@@ -2,21 +2,26 @@ package exec
 
 import (
 	"database/sql"
-	"strings"
 
 	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/connection"
+	adap "go.knocknote.io/octillery/connection/adapter"
 	"go.knocknote.io/octillery/debug"
+	"go.knocknote.io/octillery/metrics"
 	"go.knocknote.io/octillery/sqlparser"
+	"go.knocknote.io/octillery/warning"
 )
 
 // SelectQueryExecutor inherits QueryExecutorBase structure
 type SelectQueryExecutor struct {
 	*QueryExecutorBase
+	// shards decided by routing interceptor for query having shard_key. nil if query is executed on a single shard
+	routedShards []*connection.DBShardConnection
 }
 
 // NewSelectQueryExecutor creates instance of SelectQueryExecutor
 func NewSelectQueryExecutor(base *QueryExecutorBase) *SelectQueryExecutor {
-	return &SelectQueryExecutor{base}
+	return &SelectQueryExecutor{QueryExecutorBase: base}
 }
 
 // Query select multiple rows for shards.
@@ -26,34 +31,52 @@ func (e *SelectQueryExecutor) Query() ([]*sql.Rows, error) {
 		return nil, errors.New("cannot convert to sqlparser.Query to *sqlparser.QueryBase")
 	}
 
-	if e.conn.IsUsedSequencer && e.conn.Sequencer == nil {
+	if e.conn.IsUsedSequencer && e.conn.Sequencer == nil && e.conn.IDGenerator == nil {
 		return nil, errors.New("cannot execute query. sequencer's connection is nil")
 	}
+	if query.IsLockingRead() {
+		shardConn, text, err := e.lockingReadShard(query)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		e.recordRouting(metrics.SingleShard)
+		debug.Printf("(DB:%s):%s", shardConn.ShardName, text)
+		rows, err := e.execQuery(shardConn, text, query.Args...)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return []*sql.Rows{rows}, nil
+	}
 	allRows := make([]*sql.Rows, 0)
 	if query.IsNotFoundShardKeyID() {
-		debug.Printf("[WARN] query for all shards. current support only simple merge. doesn't support 'count' or 'order by' or 'limit'")
-		errs := []string{}
-		e.tx = nil // transaction is ignored at this query
-		for _, shardConn := range e.conn.ShardConnections.AllShard() {
-			debug.Printf("(DB:%s):%s", shardConn.ShardName, query.Text)
-			rows, err := e.execQuery(shardConn, query.Text, query.Args...)
+		if !e.isDeduplicateScatterQueries() {
+			return e.queryScatter(query)
+		}
+		sets, err := e.queryDeduplicatedRowsSets(query)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for _, set := range sets {
+			rows, err := set.Rows()
 			if err != nil {
-				errs = append(errs, err.Error())
-				continue
+				return nil, errors.WithStack(err)
 			}
 			allRows = append(allRows, rows)
 		}
-		if len(errs) > 0 {
-			err := strings.Join(errs, ":")
-			return allRows, errors.New(err)
-		}
 		return allRows, nil
 	}
 
-	shardConn, err := e.conn.ShardConnectionByID(int64(query.ShardKeyID))
+	shardConns, err := e.routeShardByID(int64(query.ShardKeyID))
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	if len(shardConns) > 1 {
+		// broadened by routing interceptor
+		e.routedShards = shardConns
+		return e.queryScatter(query)
+	}
+	shardConn := shardConns[0]
+	e.recordRouting(metrics.SingleShard)
 	debug.Printf("(DB:%s):%s", shardConn.ShardName, query.Text)
 	rows, err := e.execQuery(shardConn, query.Text, query.Args...)
 	if err != nil {
@@ -70,19 +93,92 @@ func (e *SelectQueryExecutor) QueryRow() (*sql.Row, error) {
 		return nil, errors.New("cannot convert to sqlparser.Query to *sqlparser.QueryBase")
 	}
 
-	if e.conn.IsUsedSequencer && e.conn.Sequencer == nil {
+	if e.conn.IsUsedSequencer && e.conn.Sequencer == nil && e.conn.IDGenerator == nil {
 		return nil, errors.New("cannot select row. sequencer's connection is nil")
 	}
+	if query.IsLockingRead() {
+		shardConn, text, err := e.lockingReadShard(query)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		e.recordRouting(metrics.SingleShard)
+		debug.Printf("(DB:%s):%s", shardConn.ShardName, text)
+		row, err := e.execQueryRow(shardConn, text, query.Args...)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return row, nil
+	}
 
 	if query.IsNotFoundShardKeyID() {
-		debug.Printf("[WARN] cannot call queryRow for all shards")
-		return nil, nil
+		if shardConn, err := e.singleShardConnection(query); err != nil {
+			return nil, errors.WithStack(err)
+		} else if shardConn != nil {
+			// all values of IN clause are in the same shard
+			shardConns, err := e.routeShards([]*connection.DBShardConnection{shardConn})
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			if len(shardConns) > 1 {
+				// broadened by routing interceptor
+				e.routedShards = shardConns
+				return e.queryRowShards(query)
+			}
+			return e.queryRowSingleShard(shardConns[0], query)
+		}
+		return e.queryRowShards(query)
 	}
 
-	shardConn, err := e.conn.ShardConnectionByID(int64(query.ShardKeyID))
+	shardConns, err := e.routeShardByID(int64(query.ShardKeyID))
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	if len(shardConns) > 1 {
+		// broadened by routing interceptor
+		e.routedShards = shardConns
+		return e.queryRowShards(query)
+	}
+	return e.queryRowSingleShard(shardConns[0], query)
+}
+
+// lockingReadShard returns the single shard where locking read ( e.g. 'SELECT ... FOR UPDATE' ) is executed and query text for it.
+// Rows locked across shards cannot be locked atomically, so locking read is rejected unless shard_key decides a single shard.
+func (e *SelectQueryExecutor) lockingReadShard(query *sqlparser.QueryBase) (*connection.DBShardConnection, string, error) {
+	var shardConns []*connection.DBShardConnection
+	if query.IsNotFoundShardKeyID() {
+		shardConn, err := e.singleShardConnection(query)
+		if err != nil {
+			return nil, "", errors.WithStack(err)
+		}
+		if shardConn == nil {
+			return nil, "", errors.Errorf("cannot execute SELECT ... %s for %s. shard_key ( %s ) must decide a single shard", query.Lock, query.TableName, e.conn.ShardKeyColumnName)
+		}
+		if shardConns, err = e.routeShards([]*connection.DBShardConnection{shardConn}); err != nil {
+			return nil, "", errors.WithStack(err)
+		}
+	} else {
+		var err error
+		if shardConns, err = e.routeShardByID(int64(query.ShardKeyID)); err != nil {
+			return nil, "", errors.WithStack(err)
+		}
+	}
+	if len(shardConns) != 1 {
+		return nil, "", errors.Errorf("cannot execute SELECT ... %s for %s on %d shards decided by routing interceptor", query.Lock, query.TableName, len(shardConns))
+	}
+	text := query.Text
+	if adapter, ok := e.conn.Adapter.(adap.LockingReadAdapter); ok {
+		clause, supported := adapter.LockClause(query.Lock)
+		if !supported {
+			return nil, "", errors.Errorf("adapter of %s doesn't support %s", query.TableName, query.Lock)
+		}
+		text = query.TextWithLock(clause)
+	}
+	return shardConns[0], text, nil
+}
+
+// queryRowSingleShard selects row from shardConn
+func (e *SelectQueryExecutor) queryRowSingleShard(shardConn *connection.DBShardConnection, query *sqlparser.QueryBase) (*sql.Row, error) {
+	e.recordRouting(metrics.SingleShard)
 	debug.Printf("(DB:%s):%s", shardConn.ShardName, query.Text)
 	row, err := e.execQueryRow(shardConn, query.Text, query.Args...)
 	if err != nil {
@@ -91,6 +187,225 @@ func (e *SelectQueryExecutor) QueryRow() (*sql.Row, error) {
 	return row, nil
 }
 
+// queryRowShards selects row from multiple shards by merging results of them
+func (e *SelectQueryExecutor) queryRowShards(query *sqlparser.QueryBase) (*sql.Row, error) {
+	merger, err := newRowsMerger(query)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if merger == nil && e.routedShards != nil {
+		return nil, errors.Errorf("cannot call QueryRow on %d shards decided by routing interceptor. use Query instead", len(e.routedShards))
+	}
+	if merger == nil {
+		warning.Warn(&warning.Warning{
+			Code:    warning.QueryRowForAllShards,
+			Message: "cannot call queryRow for all shards",
+			Table:   query.Table(),
+			Query:   query.Text,
+		})
+		return nil, nil
+	}
+	if e.isDeduplicateScatterQueries() {
+		sets, err := e.queryDeduplicatedRowsSets(query)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		// merged result is a rows set
+		return sets[0].Row()
+	}
+	merged, err := e.queryMergedRows(query, merger)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	row, err := merged.Row()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return row, nil
+}
+
+// queryScatter executes query without shard_key for shards.
+// If results can be merged by octillery, returns merged rows. Otherwise returns rows of each shard.
+func (e *SelectQueryExecutor) queryScatter(query *sqlparser.QueryBase) ([]*sql.Rows, error) {
+	merger, err := newRowsMerger(query)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if merger == nil {
+		return e.queryShards(query, nil)
+	}
+	merged, err := e.queryMergedRows(query, merger)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	rows, err := merged.Rows()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return []*sql.Rows{rows}, nil
+}
+
+// queryMergedRows executes query for shards and merges results by merger
+func (e *SelectQueryExecutor) queryMergedRows(query *sqlparser.QueryBase, merger rowsMerger) (*mergedRowsSet, error) {
+	allRows, err := e.queryShards(query, merger)
+	if err != nil {
+		for _, rows := range allRows {
+			rows.Close()
+		}
+		return nil, errors.WithStack(err)
+	}
+	merged, err := merger.merge(allRows)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return merged, nil
+}
+
+// shardQuery query executed on a shard
+type shardQuery struct {
+	conn *connection.DBShardConnection
+	text string
+	args []interface{}
+}
+
+// shardQueries returns queries for shards that have rows of shard_key values in IN clause.
+// Each query has only values of the shard in IN clause.
+// If query doesn't have IN clause for shard_key column, returns queries for all shards.
+// Shards are decided by routing interceptor of connection if it is set.
+// If merger is not nil, query of each shard is rewritten by merger.
+func (e *SelectQueryExecutor) shardQueries(query *sqlparser.QueryBase, merger rowsMerger) ([]*shardQuery, error) {
+	queryText, args := query.Text, query.Args
+	if merger != nil {
+		queryText, args = merger.shardQuery()
+	}
+	if e.routedShards != nil {
+		e.recordRouting(metrics.MultiShard)
+		queries := make([]*shardQuery, 0, len(e.routedShards))
+		for _, shardConn := range e.routedShards {
+			queries = append(queries, &shardQuery{conn: shardConn, text: queryText, args: args})
+		}
+		return queries, nil
+	}
+	if len(query.ShardKeyIDs) == 0 {
+		warning.Warn(&warning.Warning{
+			Code:    warning.ScatterQuery,
+			Message: "query for all shards. current support only simple merge, aggregate functions, 'order by' and 'limit'. doesn't support 'group by'",
+			Table:   e.query.Table(),
+			Query:   queryText,
+		})
+		shardConns, err := e.routeAllShards()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		e.tx = nil // transaction is ignored at this query
+		e.recordRouting(metrics.Broadcast)
+		queries := []*shardQuery{}
+		for _, shardConn := range shardConns {
+			queries = append(queries, &shardQuery{conn: shardConn, text: queryText, args: args})
+		}
+		return queries, nil
+	}
+	groupedShardConns, idsByShard, err := e.groupShardKeyIDs(query)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	shardConns, err := e.routeShards(groupedShardConns)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(shardConns) == 1 {
+		e.recordRouting(metrics.SingleShard)
+	} else {
+		e.recordRouting(metrics.MultiShard)
+	}
+	queries := make([]*shardQuery, 0, len(shardConns))
+	for _, shardConn := range shardConns {
+		ids, exists := idsByShard[shardConn]
+		if !exists {
+			// shard added by routing interceptor doesn't have values of IN clause, so query is not narrowed
+			queries = append(queries, &shardQuery{conn: shardConn, text: queryText, args: args})
+			continue
+		}
+		narrowed, err := query.NarrowShardKeyIDs(ids)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		narrowedQueryText, narrowedArgs := narrowed.Text, narrowed.Args
+		if merger != nil {
+			narrowedMerger, err := newRowsMerger(narrowed)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			narrowedQueryText, narrowedArgs = narrowedMerger.shardQuery()
+		}
+		queries = append(queries, &shardQuery{conn: shardConn, text: narrowedQueryText, args: narrowedArgs})
+	}
+	return queries, nil
+}
+
+// groupShardKeyIDs groups shard_key ids of IN clause by shard. shards are ordered by first appearance of ids
+func (e *SelectQueryExecutor) groupShardKeyIDs(query *sqlparser.QueryBase) ([]*connection.DBShardConnection, map[*connection.DBShardConnection][]sqlparser.Identifier, error) {
+	shardConns := []*connection.DBShardConnection{}
+	idsByShard := map[*connection.DBShardConnection][]sqlparser.Identifier{}
+	for _, id := range query.ShardKeyIDs {
+		shardConn, err := e.conn.ShardConnectionByID(int64(id))
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		if _, exists := idsByShard[shardConn]; !exists {
+			shardConns = append(shardConns, shardConn)
+		}
+		idsByShard[shardConn] = append(idsByShard[shardConn], id)
+	}
+	return shardConns, idsByShard, nil
+}
+
+// singleShardConnection returns shard connection if all shard_key values of IN clause are in the same shard. Otherwise returns nil.
+func (e *SelectQueryExecutor) singleShardConnection(query *sqlparser.QueryBase) (*connection.DBShardConnection, error) {
+	if len(query.ShardKeyIDs) == 0 {
+		return nil, nil
+	}
+	shardConns, _, err := e.groupShardKeyIDs(query)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(shardConns) != 1 {
+		return nil, nil
+	}
+	return shardConns[0], nil
+}
+
+// queryShards executes query for shards decided by shardQueries.
+// Aggregate functions without GROUP BY and ORDER BY/LIMIT clause are merged by caller, but the other results are simply merged.
+func (e *SelectQueryExecutor) queryShards(query *sqlparser.QueryBase, merger rowsMerger) ([]*sql.Rows, error) {
+	queries, err := e.shardQueries(query, merger)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	allRows := make([]*sql.Rows, 0)
+	errs := &connection.MultiError{}
+	trace := &ExecutionTrace{}
+	for _, shardQuery := range queries {
+		shardConn := shardQuery.conn
+		if err := e.contextErr(); err != nil {
+			for _, rows := range allRows {
+				rows.Close()
+			}
+			return nil, trace.wrap(errors.Wrapf(err, "cancelled before querying %s", shardConn.ShardName))
+		}
+		debug.Printf("(DB:%s):%s", shardConn.ShardName, shardQuery.text)
+		done := trace.start(shardConn.ShardName)
+		rows, err := e.execQuery(shardConn, shardQuery.text, shardQuery.args...)
+		done(err)
+		if err != nil {
+			errs.AddShardError(shardConn.ShardName, shardConn.DSN(), err)
+			continue
+		}
+		allRows = append(allRows, rows)
+	}
+	return allRows, trace.wrap(errs.ErrorOrNil())
+}
+
 // Exec doesn't support in SelectQueryExecutor, returns always error.
 func (e *SelectQueryExecutor) Exec() (sql.Result, error) {
 	return nil, errors.New("SelectQueryExecutor cannot invoke Exec()")
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 
 	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/metrics"
 	"go.knocknote.io/octillery/sqlparser"
 )
 
@@ -25,6 +26,7 @@ func (e *ShowQueryExecutor) Query() ([]*sql.Rows, error) {
 	}
 
 	for _, shardConn := range e.conn.ShardConnections.AllShard() {
+		e.recordRouting(metrics.SingleShard)
 		rows, err := e.execQuery(shardConn, query.Text, query.Args...)
 		if err != nil {
 			return nil, errors.WithStack(err)
@@ -43,6 +45,7 @@ func (e *ShowQueryExecutor) QueryRow() (*sql.Row, error) {
 	}
 
 	for _, shardConn := range e.conn.ShardConnections.AllShard() {
+		e.recordRouting(metrics.SingleShard)
 		row, err := e.execQueryRow(shardConn, query.Text, query.Args...)
 		if err != nil {
 			return nil, errors.WithStack(err)
@@ -0,0 +1,266 @@
+package exec
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/connection"
+	"go.knocknote.io/octillery/debug"
+	"go.knocknote.io/octillery/metrics"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+// ShardStmt prepared statement for sharded table.
+//
+// Shard of statement is decided by query arguments at each execution,
+// so statement is prepared lazily on the shard and cached per shard.
+// If query cannot be executed on a single shard as it is ( e.g. INSERT rewritten by sequencer, query for all shards, locking read,
+// UPDATE moving rows to another shard, UPDATE or DELETE changing values of 'unique_columns' or query whose shards are decided by routing interceptor ),
+// it is executed by QueryExecutor without prepared statement.
+type ShardStmt struct {
+	conn      *connection.DBConnection
+	tx        *connection.TxConnection
+	session   *connection.SessionConnection
+	queryText string
+
+	mu     sync.Mutex
+	stmts  map[*sql.DB]*sql.Stmt
+	closed bool
+}
+
+// NewShardStmt creates instance of ShardStmt. If tx is not nil, statement is prepared in transaction.
+func NewShardStmt(conn *connection.DBConnection, tx *connection.TxConnection, queryText string) *ShardStmt {
+	return &ShardStmt{
+		conn:      conn,
+		tx:        tx,
+		queryText: queryText,
+		stmts:     map[*sql.DB]*sql.Stmt{},
+	}
+}
+
+// WithTx returns statement prepared in transaction
+func (s *ShardStmt) WithTx(tx *connection.TxConnection) *ShardStmt {
+	return NewShardStmt(s.conn, tx, s.queryText)
+}
+
+// WithSession returns statement prepared on connections pinned by session
+func (s *ShardStmt) WithSession(session *connection.SessionConnection) *ShardStmt {
+	stmt := NewShardStmt(s.conn, nil, s.queryText)
+	stmt.session = session
+	return stmt
+}
+
+// executor returns QueryExecutor for query that cannot be executed by prepared statement
+func (s *ShardStmt) executor(ctx context.Context, query sqlparser.Query) QueryExecutor {
+	if s.session != nil {
+		return NewSessionQueryExecutor(ctx, s.conn, s.session, query)
+	}
+	return NewQueryExecutor(ctx, s.conn, s.tx, query)
+}
+
+// hasUniqueColumns returns whether table has 'unique_columns' reserved in sequencer's database
+func (s *ShardStmt) hasUniqueColumns() bool {
+	return s.conn.Config != nil && len(s.conn.Config.UniqueColumns) > 0
+}
+
+// parse parses query by arguments and returns shard connection if query can be executed on the shard by prepared statement.
+// queryText is bound named arguments by sqlparser.BindNamedArgs
+func (s *ShardStmt) parse(queryText string, args []interface{}) (sqlparser.Query, *connection.DBShardConnection, error) {
+	parser, err := sqlparser.New()
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	query, err := parser.Parse(queryText, args...)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	var queryBase *sqlparser.QueryBase
+	switch q := query.(type) {
+	case *sqlparser.QueryBase:
+		queryBase = q
+	case *sqlparser.DeleteQuery:
+		if s.hasUniqueColumns() {
+			// values of unique columns of deleted rows are released by QueryExecutor
+			return query, nil, nil
+		}
+		queryBase = q.QueryBase
+	default:
+		return query, nil, nil
+	}
+	if queryBase.IsNotFoundShardKeyID() || queryBase.IsReturning() || queryBase.IsLockingRead() || queryBase.ShardKeyMove != nil || queryBase.UniqueColumnUpdate != nil || s.conn.HasRoutingInterceptor() {
+		return query, nil, nil
+	}
+	if s.conn.IsUsedSequencer && s.conn.Sequencer == nil && s.conn.IDGenerator == nil {
+		return nil, nil, errors.New("cannot execute query. sequencer's connection is nil")
+	}
+	shardConn, err := s.conn.ShardConnectionByID(int64(queryBase.ShardKeyID))
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	metrics.RecordRouting(query.Table(), query.QueryType(), metrics.SingleShard)
+	return query, shardConn, nil
+}
+
+// stmt returns statement prepared on shard. statement is cached per *sql.DB
+func (s *ShardStmt) stmt(ctx context.Context, shardConn *connection.DBShardConnection, query sqlparser.Query, queryText string) (*sql.Stmt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errors.New("statement is already closed")
+	}
+	db := shardConn.Conn()
+	if s.tx == nil && s.session == nil && query.QueryType().IsReadQuery() {
+		db = shardConn.ReadConn()
+	}
+	if stmt, exists := s.stmts[db]; exists {
+		return stmt, nil
+	}
+	debug.Printf("(DB:%s):prepare %s", shardConn.ShardName, queryText)
+	var (
+		stmt *sql.Stmt
+		err  error
+	)
+	switch {
+	case s.tx != nil:
+		stmt, err = s.tx.Prepare(ctx, shardConn, queryText)
+	case s.session != nil:
+		stmt, err = s.session.Prepare(ctx, shardConn, queryText)
+	case ctx != nil:
+		stmt, err = db.PrepareContext(ctx, queryText)
+	default:
+		stmt, err = db.Prepare(queryText)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	s.stmts[db] = stmt
+	return stmt, nil
+}
+
+// Exec executes statement on the shard decided by arguments.
+func (s *ShardStmt) Exec(ctx context.Context, args ...interface{}) (sql.Result, error) {
+	queryText, args, err := sqlparser.BindNamedArgs(s.queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	query, shardConn, err := s.parse(queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if shardConn == nil {
+		result, err := s.executor(ctx, query).Exec()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return result, nil
+	}
+	stmt, err := s.stmt(ctx, shardConn, query, queryText)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var result sql.Result
+	done := s.conn.StartQuery(ctx, query.Table(), shardConn, queryText, args)
+	if ctx == nil {
+		result, err = stmt.Exec(args...)
+	} else {
+		result, err = stmt.ExecContext(ctx, args...)
+	}
+	done(result, err)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if s.tx != nil {
+		if err := s.tx.AddWriteQuery(shardConn, result, queryText, args...); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return result, nil
+}
+
+// Query executes statement on the shard decided by arguments.
+func (s *ShardStmt) Query(ctx context.Context, args ...interface{}) ([]*sql.Rows, error) {
+	queryText, args, err := sqlparser.BindNamedArgs(s.queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	query, shardConn, err := s.parse(queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if shardConn == nil {
+		rows, err := s.executor(ctx, query).Query()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return rows, nil
+	}
+	stmt, err := s.stmt(ctx, shardConn, query, queryText)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var rows *sql.Rows
+	done := s.conn.StartQuery(ctx, query.Table(), shardConn, queryText, args)
+	if ctx == nil {
+		rows, err = stmt.Query(args...)
+	} else {
+		rows, err = stmt.QueryContext(ctx, args...)
+	}
+	done(nil, err)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if s.tx != nil {
+		s.tx.AddReadQuery(queryText, args...)
+	}
+	return []*sql.Rows{rows}, nil
+}
+
+// QueryRow executes statement on the shard decided by arguments.
+func (s *ShardStmt) QueryRow(ctx context.Context, args ...interface{}) (*sql.Row, error) {
+	queryText, args, err := sqlparser.BindNamedArgs(s.queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	query, shardConn, err := s.parse(queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if shardConn == nil {
+		row, err := s.executor(ctx, query).QueryRow()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return row, nil
+	}
+	stmt, err := s.stmt(ctx, shardConn, query, queryText)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if s.tx != nil {
+		s.tx.AddReadQuery(queryText, args...)
+	}
+	done := s.conn.StartQuery(ctx, query.Table(), shardConn, queryText, args)
+	defer done(nil, nil)
+	if ctx == nil {
+		return stmt.QueryRow(args...), nil
+	}
+	return stmt.QueryRowContext(ctx, args...), nil
+}
+
+// Close closes statements prepared on shards.
+func (s *ShardStmt) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	errs := &connection.MultiError{}
+	for _, stmt := range s.stmts {
+		errs.Add(stmt.Close())
+	}
+	s.stmts = nil
+	return errs.ErrorOrNil()
+}
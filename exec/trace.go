@@ -0,0 +1,90 @@
+package exec
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ShardTrace execution of query on a shard
+type ShardTrace struct {
+	// shard name defined in configuration file
+	ShardName string
+	// time spent by query on the shard
+	Duration time.Duration
+	// error occurred on the shard. nil if query succeeded
+	Err error
+}
+
+// ExecutionTrace executions of query on shards in order of attempt.
+// Shards not attempted ( e.g. skipped by cancelled context ) are not included.
+type ExecutionTrace struct {
+	Shards []*ShardTrace
+}
+
+// start records beginning of execution on shard. returned function records result of it
+func (t *ExecutionTrace) start(shardName string) func(error) {
+	shard := &ShardTrace{ShardName: shardName}
+	t.Shards = append(t.Shards, shard)
+	startedAt := time.Now()
+	return func(err error) {
+		shard.Duration = time.Since(startedAt)
+		shard.Err = err
+	}
+}
+
+// wrap returns err with trace. If err is nil, returns nil
+func (t *ExecutionTrace) wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &TracedError{Err: err, Trace: t}
+}
+
+// String returns compact representation of trace like 'user_shard_1:ok:1.2ms user_shard_2:failed:3ms'
+func (t *ExecutionTrace) String() string {
+	shards := make([]string, len(t.Shards))
+	for idx, shard := range t.Shards {
+		status := "ok"
+		if shard.Err != nil {
+			status = "failed"
+		}
+		shards[idx] = fmt.Sprintf("%s:%s:%s", shard.ShardName, status, shard.Duration.Round(time.Microsecond))
+	}
+	return strings.Join(shards, " ")
+}
+
+// TracedError the error of query executed on multiple shards with trace of executions
+type TracedError struct {
+	Err   error
+	Trace *ExecutionTrace
+}
+
+func (e *TracedError) Error() string {
+	return fmt.Sprintf("%s (trace: %s)", e.Err, e.Trace)
+}
+
+// Unwrap returns original error
+func (e *TracedError) Unwrap() error {
+	return e.Err
+}
+
+// Cause returns original error for errors.Cause of github.com/pkg/errors
+func (e *TracedError) Cause() error {
+	return e.Err
+}
+
+// TraceOf returns execution trace attached to err by query executed on multiple shards
+func TraceOf(err error) (*ExecutionTrace, bool) {
+	for err != nil {
+		if traced, ok := err.(*TracedError); ok {
+			return traced.Trace, true
+		}
+		causer, ok := err.(interface{ Cause() error })
+		if !ok {
+			return nil, false
+		}
+		err = causer.Cause()
+	}
+	return nil, false
+}
@@ -0,0 +1,186 @@
+package exec
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/connection"
+	adap "go.knocknote.io/octillery/connection/adapter"
+	"go.knocknote.io/octillery/debug"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+// uniqueValue value of unique column reserved in sequencer's database
+type uniqueValue struct {
+	column string
+	value  string
+}
+
+// uniqueValueString formats value as it is reserved by INSERT query
+func uniqueValueString(value interface{}) string {
+	if bytes, ok := value.([]byte); ok {
+		return string(bytes)
+	}
+	return fmt.Sprint(value)
+}
+
+// forUpdateClause returns locking clause of 'for update' decided by adapter. empty clause means database doesn't need it
+func (e *QueryExecutorBase) forUpdateClause() (string, error) {
+	lock := "for update"
+	adapter, ok := e.conn.Adapter.(adap.LockingReadAdapter)
+	if !ok {
+		return lock, nil
+	}
+	clause, supported := adapter.LockClause(lock)
+	if !supported {
+		return "", errors.Errorf("adapter of %s doesn't support %s", e.query.Table(), lock)
+	}
+	return clause, nil
+}
+
+// hasUniqueColumns returns whether table has 'unique_columns' reserved in sequencer's database
+func (e *QueryExecutorBase) hasUniqueColumns() bool {
+	return e.conn.Config != nil && len(e.conn.Config.UniqueColumns) > 0
+}
+
+// uniqueValueShards returns shards query is executed on
+func (e *QueryExecutorBase) uniqueValueShards(query *sqlparser.QueryBase) ([]*connection.DBShardConnection, error) {
+	if query.IsNotFoundShardKeyID() {
+		return e.routeAllShards()
+	}
+	return e.routeShardByID(int64(query.ShardKeyID))
+}
+
+// selectUniqueValues returns values of columns of rows matched by condition on shards.
+// Rows are locked in transaction, so they are not changed by others until values are reserved or released.
+func (e *QueryExecutorBase) selectUniqueValues(shardConns []*connection.DBShardConnection, columns []string, condition string, args []interface{}) ([][]sql.NullString, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s%s", strings.Join(columns, ", "), e.query.Table(), condition)
+	if e.tx != nil {
+		lock, err := e.forUpdateClause()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if lock != "" {
+			query += " " + lock
+		}
+	}
+	values := [][]sql.NullString{}
+	for _, shardConn := range shardConns {
+		debug.Printf("(DB:%s):%s", shardConn.ShardName, query)
+		rows, err := e.execQuery(shardConn, query, args...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot select values of unique columns from %s", shardConn.ShardName)
+		}
+		for rows.Next() {
+			row := make([]sql.NullString, len(columns))
+			dest := make([]interface{}, len(columns))
+			for idx := range row {
+				dest[idx] = &row[idx]
+			}
+			if err := rows.Scan(dest...); err != nil {
+				rows.Close()
+				return nil, errors.WithStack(err)
+			}
+			values = append(values, row)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return values, nil
+}
+
+// releaseUniqueValues releases values of unique columns of rows updated or deleted
+func (e *QueryExecutorBase) releaseUniqueValues(values []*uniqueValue) error {
+	errs := &connection.MultiError{}
+	for _, value := range values {
+		errs.Add(e.conn.ReleaseUniqueValue(e.ctx, e.tx, e.query.Table(), value.column, value.value))
+	}
+	return errs.ErrorOrNil()
+}
+
+// execUniqueColumnUpdate calls fn executing UPDATE query that assigns values of 'unique_columns'.
+// Assigned values are reserved before fn, and values of updated rows are released after fn succeeded.
+// The same value cannot be assigned to multiple rows, because they would be duplicated.
+func (e *UpdateQueryExecutor) execUniqueColumnUpdate(query *sqlparser.QueryBase, fn func() error) error {
+	update := query.UniqueColumnUpdate
+	if update == nil {
+		return fn()
+	}
+	shardConns, err := e.uniqueValueShards(query)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	rows, err := e.selectUniqueValues(shardConns, update.Columns, update.Condition, update.ConditionArgs)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	reserved := map[string]string{}
+	released := []*uniqueValue{}
+	for idx, column := range update.Columns {
+		var newValue *string
+		if value := update.Values[idx]; value != nil {
+			formatted := uniqueValueString(value)
+			newValue = &formatted
+			if len(rows) > 1 {
+				return errors.Wrapf(sqlparser.ErrUniqueColumnUpdated, "'%s' is assigned to %s.%s of %d rows", formatted, query.Table(), column, len(rows))
+			}
+		}
+		for _, row := range rows {
+			current := row[idx]
+			if newValue != nil && current.Valid && current.String == *newValue {
+				// value is not changed
+				continue
+			}
+			if newValue != nil {
+				reserved[column] = *newValue
+			}
+			if current.Valid {
+				released = append(released, &uniqueValue{column: column, value: current.String})
+			}
+		}
+	}
+	release, err := e.conn.ReserveUniqueValues(e.ctx, e.tx, query.Table(), reserved)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := fn(); err != nil {
+		if releaseErr := release(); releaseErr != nil {
+			return errors.Wrapf(releaseErr, "cannot release unique values after %s", err)
+		}
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(e.releaseUniqueValues(released))
+}
+
+// execUniqueValuesRelease calls fn executing DELETE query, and releases values of 'unique_columns' of deleted rows after fn succeeded.
+func (e *DeleteQueryExecutor) execUniqueValuesRelease(query *sqlparser.DeleteQuery, fn func() error) error {
+	if !e.hasUniqueColumns() {
+		return fn()
+	}
+	shardConns, err := e.uniqueValueShards(query.QueryBase)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	columns := e.conn.Config.UniqueColumns
+	rows, err := e.selectUniqueValues(shardConns, columns, query.Condition(), query.Args)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := fn(); err != nil {
+		return errors.WithStack(err)
+	}
+	released := []*uniqueValue{}
+	for _, row := range rows {
+		for idx, column := range columns {
+			if row[idx].Valid {
+				released = append(released, &uniqueValue{column: column, value: row[idx].String})
+			}
+		}
+	}
+	return errors.WithStack(e.releaseUniqueValues(released))
+}
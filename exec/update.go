@@ -5,7 +5,9 @@ import (
 
 	"github.com/pkg/errors"
 	"go.knocknote.io/octillery/debug"
+	"go.knocknote.io/octillery/metrics"
 	"go.knocknote.io/octillery/sqlparser"
+	"go.knocknote.io/octillery/warning"
 )
 
 // UpdateQueryExecutor inherits QueryExecutorBase structure
@@ -18,14 +20,41 @@ func NewUpdateQueryExecutor(base *QueryExecutorBase) *UpdateQueryExecutor {
 	return &UpdateQueryExecutor{base}
 }
 
-// Query doesn't support in UpdateQueryExecutor, returns always error.
+// Query executes UPDATE query that has RETURNING clause for shards.
+// If query doesn't have RETURNING clause, returns always error.
 func (e *UpdateQueryExecutor) Query() ([]*sql.Rows, error) {
-	return nil, errors.New("UpdateQueryExecutor cannot invoke Query()")
+	query, ok := e.query.(*sqlparser.QueryBase)
+	if !ok {
+		return nil, errors.New("cannot convert sqlparser.Query to *sqlparser.QueryBase")
+	}
+	if query.ShardKeyMove != nil {
+		return nil, errors.New("cannot move rows to another shard by UPDATE query with RETURNING clause")
+	}
+	var rows []*sql.Rows
+	if err := e.execUniqueColumnUpdate(query, func() (err error) {
+		rows, err = e.queryReturning(query)
+		return err
+	}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return rows, nil
 }
 
-// QueryRow doesn't support in UpdateQueryExecutor, returns always error.
+// QueryRow executes UPDATE query that has RETURNING clause for single shard.
+// If query doesn't have RETURNING clause, returns always error.
 func (e *UpdateQueryExecutor) QueryRow() (*sql.Row, error) {
-	return nil, errors.New("UpdateQueryExecutor cannot invoke QueryRow()")
+	query, ok := e.query.(*sqlparser.QueryBase)
+	if !ok {
+		return nil, errors.New("cannot convert sqlparser.Query to *sqlparser.QueryBase")
+	}
+	if query.ShardKeyMove != nil {
+		return nil, errors.New("cannot move rows to another shard by UPDATE query with RETURNING clause")
+	}
+	if query.UniqueColumnUpdate != nil {
+		// error of query is returned by Scan, so reserved values cannot be released on failure
+		return nil, errors.Wrap(sqlparser.ErrUniqueColumnUpdated, "cannot invoke QueryRow() for UPDATE query assigning unique column. use Query() instead")
+	}
+	return e.queryRowReturning(query)
 }
 
 // Exec executes UPDATE query for shards.
@@ -34,16 +63,47 @@ func (e *UpdateQueryExecutor) Exec() (sql.Result, error) {
 	if !ok {
 		return nil, errors.New("cannot convert sqlparser.Query to *sqlparser.QueryBase")
 	}
-	if e.conn.IsUsedSequencer && e.conn.Sequencer == nil {
+	if e.conn.IsUsedSequencer && e.conn.Sequencer == nil && e.conn.IDGenerator == nil {
 		return nil, errors.New("cannot update row. sequencer's connection is nil")
 	}
+	if query.ShardKeyMove != nil {
+		return e.execShardKeyMove(query)
+	}
+	var result sql.Result
+	if err := e.execUniqueColumnUpdate(query, func() (err error) {
+		result, err = e.execUpdate(query)
+		return err
+	}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return result, nil
+}
+
+func (e *UpdateQueryExecutor) execUpdate(query *sqlparser.QueryBase) (sql.Result, error) {
 	if query.IsNotFoundShardKeyID() {
-		return nil, errors.New("cannot update row. not found shard_key column in this query")
+		warning.Warn(&warning.Warning{
+			Code:    warning.ScatterQuery,
+			Message: "update query for all shards",
+			Table:   query.Table(),
+			Query:   query.Text,
+		})
+		if err := e.validateAllShardWrite(false); err != nil {
+			return nil, errors.Wrap(err, "cannot update row. not found shard_key column in this query")
+		}
+		e.recordRouting(metrics.Broadcast)
+		return e.execAllShard(query.Text, query.Args...)
 	}
-	shardConn, err := e.conn.ShardConnectionByID(int64(query.ShardKeyID))
+	shardConns, err := e.routeShardByID(int64(query.ShardKeyID))
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	if len(shardConns) > 1 {
+		// broadened by routing interceptor
+		e.recordRouting(metrics.MultiShard)
+		return e.execShards(shardConns, query.Text, query.Args...)
+	}
+	shardConn := shardConns[0]
+	e.recordRouting(metrics.SingleShard)
 	debug.Printf("(DB:%s):%s", shardConn.ShardName, query.Text)
 	result, err := e.exec(shardConn, query.Text, query.Args...)
 	if err != nil {
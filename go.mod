@@ -6,15 +6,15 @@ require (
 	github.com/blastrain/vitess-sqlparser v0.0.0-20200914074247-af18b79da035
 	github.com/deckarep/golang-set v0.0.0-20180927150649-699df6a3acf6 // indirect
 	github.com/fatih/color v0.0.0-20160317093153-533cd7fd8a85
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/go-sql-driver/mysql v1.5.0
 	github.com/jessevdk/go-flags v0.0.0-20170212220246-460c7bb0abd6
 	github.com/mattn/go-colorable v0.0.0-20160220075935-9cbef7c35391 // indirect
 	github.com/mattn/go-isatty v0.0.0-20151211000621-56b76bdf51f7 // indirect
 	github.com/mattn/go-sqlite3 v0.0.0-20170407154627-cf7286f069c3
 	github.com/pkg/errors v0.8.1-0.20170910134614-2b3a18b5f0fb
+	github.com/prometheus/client_golang v1.0.0
 	github.com/schemalex/schemalex v0.1.1
 	github.com/sergi/go-diff v0.0.0-20170409071739-feef008d51ad
-	golang.org/x/net v0.0.0-20170421002609-c8c74377599b // indirect
-	golang.org/x/sys v0.0.0-20170421005244-ea9bcade75cb // indirect
 	gopkg.in/yaml.v2 v2.2.8
 )
@@ -0,0 +1,71 @@
+// Package idgen provides generators of unique ids used instead of sequencer's database.
+//
+// Generator is selected by 'type' of sequencer in configuration, and ids of shard_column are generated locally
+// without round trip to sequencer's database.
+//
+//	tables:
+//	  users:
+//	    shard: true
+//	    shard_column: id
+//	    sequencer:
+//	      type: snowflake
+//	      node_id: 3
+//
+// Ids of shard_column must be 64-bit integers because they decide shard by sharding algorithm,
+// so 128-bit ids like UUID or ULID are not built in. Application can register its own generator of 64-bit ids by Register.
+package idgen
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+)
+
+// IDGenerator generates unique ids for all shards.
+type IDGenerator interface {
+	// returns next unique id. it must be positive number
+	NextID(ctx context.Context) (int64, error)
+}
+
+// Factory creates IDGenerator by configuration of sequencer ( e.g. 'node_id' )
+type Factory func(cfg *config.DatabaseConfig) (IDGenerator, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{
+		SnowflakeType: newSnowflakeByConfig,
+	}
+)
+
+// Register register factory of IDGenerator with name used by 'type' of sequencer
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if factory == nil {
+		panic("register id generator factory is nil")
+	}
+	if name == config.SequencerTypeDatabase {
+		panic("cannot register id generator as " + name)
+	}
+	if _, dup := factories[name]; dup {
+		panic("register called twice for id generator " + name)
+	}
+	factories[name] = factory
+}
+
+// New creates IDGenerator by 'type' of sequencer's configuration
+func New(cfg *config.DatabaseConfig) (IDGenerator, error) {
+	factoriesMu.RLock()
+	factory, exists := factories[cfg.Type]
+	factoriesMu.RUnlock()
+	if !exists {
+		return nil, errors.Errorf("unknown type of sequencer %s", cfg.Type)
+	}
+	generator, err := factory(cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot create id generator %s", cfg.Type)
+	}
+	return generator, nil
+}
@@ -0,0 +1,105 @@
+package idgen
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.knocknote.io/octillery/config"
+)
+
+type constantGenerator struct{}
+
+func (g *constantGenerator) NextID(ctx context.Context) (int64, error) {
+	return 1, nil
+}
+
+func TestNew(t *testing.T) {
+	generator, err := New(&config.DatabaseConfig{Type: SnowflakeType, NodeID: 3})
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if _, ok := generator.(*Snowflake); !ok {
+		t.Fatal("cannot create snowflake")
+	}
+	if _, err := New(&config.DatabaseConfig{Type: SnowflakeType, NodeID: MaxSnowflakeNodeID + 1}); err == nil {
+		t.Fatal("cannot validate node_id")
+	}
+	if _, err := New(&config.DatabaseConfig{Type: "unknown"}); err == nil {
+		t.Fatal("cannot handle unknown type")
+	}
+	Register("constant", func(cfg *config.DatabaseConfig) (IDGenerator, error) {
+		return &constantGenerator{}, nil
+	})
+	generator, err = New(&config.DatabaseConfig{Type: "constant"})
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if id, _ := generator.NextID(context.Background()); id != 1 {
+		t.Fatal("cannot use registered generator")
+	}
+}
+
+func TestSnowflake(t *testing.T) {
+	snowflake, err := NewSnowflake(3)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	now := SnowflakeEpoch.Add(time.Hour)
+	snowflake.now = func() time.Time { return now }
+	t.Run("ids in a millisecond", func(t *testing.T) {
+		var lastID int64
+		for i := 0; i < 10; i++ {
+			id, err := snowflake.NextID(context.Background())
+			if err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			if id <= lastID {
+				t.Fatalf("ids must be ordered. %d <= %d", id, lastID)
+			}
+			if id>>(snowflakeNodeBits+snowflakeSequenceBits) != time.Hour.Nanoseconds()/int64(time.Millisecond) {
+				t.Fatalf("invalid timestamp of id %d", id)
+			}
+			if (id>>snowflakeSequenceBits)&MaxSnowflakeNodeID != 3 {
+				t.Fatalf("invalid node id of id %d", id)
+			}
+			lastID = id
+		}
+	})
+	t.Run("sequence is not reset by next millisecond", func(t *testing.T) {
+		now = now.Add(time.Millisecond)
+		id, err := snowflake.NextID(context.Background())
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if id&maxSnowflakeSequence != 11 {
+			t.Fatalf("sequence must be continued. %d", id&maxSnowflakeSequence)
+		}
+	})
+	t.Run("sequence is used up", func(t *testing.T) {
+		for i := 0; i < maxSnowflakeSequence; i++ {
+			if _, err := snowflake.NextID(context.Background()); err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if _, err := snowflake.NextID(ctx); err == nil {
+			t.Fatal("must wait for next millisecond")
+		}
+	})
+	t.Run("clock goes backwards", func(t *testing.T) {
+		now = now.Add(-time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if _, err := snowflake.NextID(ctx); err == nil {
+			t.Fatal("must wait until clock catches up")
+		}
+	})
+	t.Run("clock before epoch", func(t *testing.T) {
+		now = SnowflakeEpoch.Add(-time.Hour)
+		if _, err := snowflake.NextID(context.Background()); err == nil {
+			t.Fatal("cannot handle clock before epoch")
+		}
+	})
+}
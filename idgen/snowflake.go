@@ -0,0 +1,88 @@
+package idgen
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+)
+
+const (
+	// SnowflakeType type of sequencer for Snowflake
+	SnowflakeType = "snowflake"
+
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+
+	// MaxSnowflakeNodeID maximum node id of Snowflake
+	MaxSnowflakeNodeID = 1<<snowflakeNodeBits - 1
+
+	maxSnowflakeSequence = 1<<snowflakeSequenceBits - 1
+)
+
+// SnowflakeEpoch start time of timestamp in ids generated by Snowflake ( 2020-01-01 00:00:00 UTC )
+var SnowflakeEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Snowflake generates ids composed of 41 bits of milliseconds since SnowflakeEpoch, 10 bits of node id and 12 bits of sequence.
+//
+// Ids are ordered by time in a node. Sequence is not reset to zero every millisecond,
+// so lower bits of ids are spread even if few ids are generated in a millisecond ( e.g. modulo algorithm decides shard by them ).
+type Snowflake struct {
+	mu            sync.Mutex
+	nodeID        int64
+	lastMillis    int64
+	sequence      int64
+	firstSequence int64
+	now           func() time.Time
+}
+
+// NewSnowflake creates instance of Snowflake. nodeID must be unique for each process generating ids.
+func NewSnowflake(nodeID int) (*Snowflake, error) {
+	if nodeID < 0 || nodeID > MaxSnowflakeNodeID {
+		return nil, errors.Errorf("node_id of snowflake must be from 0 to %d. but got %d", MaxSnowflakeNodeID, nodeID)
+	}
+	return &Snowflake{nodeID: int64(nodeID), now: time.Now}, nil
+}
+
+func newSnowflakeByConfig(cfg *config.DatabaseConfig) (IDGenerator, error) {
+	return NewSnowflake(cfg.NodeID)
+}
+
+// NextID returns next id. If clock goes backwards or sequence of current millisecond is used up, waits for next millisecond.
+func (s *Snowflake) NextID(ctx context.Context) (int64, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		millis := s.now().Sub(SnowflakeEpoch).Nanoseconds() / int64(time.Millisecond)
+		if millis < 0 {
+			return 0, errors.Errorf("cannot generate id by snowflake. clock is before %s", SnowflakeEpoch)
+		}
+		if millis > s.lastMillis {
+			s.lastMillis = millis
+			s.sequence = (s.sequence + 1) & maxSnowflakeSequence
+			s.firstSequence = s.sequence
+			return s.id(), nil
+		}
+		if millis == s.lastMillis {
+			if sequence := (s.sequence + 1) & maxSnowflakeSequence; sequence != s.firstSequence {
+				s.sequence = sequence
+				return s.id(), nil
+			}
+		}
+		wait := time.Duration(s.lastMillis-millis+1) * time.Millisecond
+		select {
+		case <-ctx.Done():
+			return 0, errors.Wrap(ctx.Err(), "cannot generate id by snowflake")
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (s *Snowflake) id() int64 {
+	return s.lastMillis<<(snowflakeNodeBits+snowflakeSequenceBits) | s.nodeID<<snowflakeSequenceBits | s.sequence
+}
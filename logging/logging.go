@@ -0,0 +1,222 @@
+// Package logging provides sampled query logger writes queries executed by octillery to file as JSON lines.
+//
+// It is lightweight alternative of tracing infrastructure. Log file is rotated by size.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/debug"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+const (
+	// DefaultMaxSize default size of log file in bytes before rotation
+	DefaultMaxSize = 100 * 1024 * 1024
+
+	// DefaultMaxBackups default number of rotated log files kept
+	DefaultMaxBackups = 3
+)
+
+// Config configuration of QueryLogger
+type Config struct {
+	// path of log file. rotated files are saved as 'path.1', 'path.2', ...
+	Path string
+	// rate of queries logged. 1 logs all queries, 0.01 logs 1% of queries. if zero, all queries are logged
+	SampleRate float64
+	// size of log file in bytes before rotation. if zero, DefaultMaxSize is used
+	MaxSize int64
+	// number of rotated log files kept. if zero, DefaultMaxBackups is used
+	MaxBackups int
+	// whether query arguments are logged. they may include sensitive values
+	WithArgs bool
+}
+
+// QueryEntry a line of query log
+type QueryEntry struct {
+	// time when query is started
+	Time time.Time `json:"time"`
+	// table name. empty if query cannot be parsed
+	Table string `json:"table,omitempty"`
+	// query text
+	Query string `json:"query"`
+	// query arguments. empty if Config.WithArgs is false
+	Args []interface{} `json:"args,omitempty"`
+	// elapsed time of query in nanoseconds
+	Duration time.Duration `json:"duration"`
+	// error message if query is failed
+	Error string `json:"error,omitempty"`
+}
+
+// QueryLogger writes sampled queries to file
+type QueryLogger struct {
+	cfg  Config
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewQueryLogger creates instance of QueryLogger and opens log file.
+func NewQueryLogger(cfg *Config) (*QueryLogger, error) {
+	if cfg == nil || cfg.Path == "" {
+		return nil, errors.New("path of query log is required")
+	}
+	if cfg.SampleRate < 0 || cfg.SampleRate > 1 {
+		return nil, errors.Errorf("sample rate must be between 0 and 1. but got %f", cfg.SampleRate)
+	}
+	logger := &QueryLogger{cfg: *cfg}
+	if logger.cfg.SampleRate == 0 {
+		logger.cfg.SampleRate = 1
+	}
+	if logger.cfg.MaxSize <= 0 {
+		logger.cfg.MaxSize = DefaultMaxSize
+	}
+	if logger.cfg.MaxBackups <= 0 {
+		logger.cfg.MaxBackups = DefaultMaxBackups
+	}
+	if err := logger.open(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return logger, nil
+}
+
+func (l *QueryLogger) open() error {
+	file, err := os.OpenFile(l.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "cannot open query log %s", l.cfg.Path)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return errors.Wrapf(err, "cannot get size of query log %s", l.cfg.Path)
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// rotate renames 'path' to 'path.1' and 'path.N' to 'path.N+1', removes files over MaxBackups, then opens new file.
+// If renaming is failed, current file is opened again.
+func (l *QueryLogger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	renameErr := l.renameBackups()
+	if err := l.open(); err != nil {
+		l.file = nil
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(renameErr)
+}
+
+func (l *QueryLogger) renameBackups() error {
+	backupPath := func(idx int) string {
+		return fmt.Sprintf("%s.%d", l.cfg.Path, idx)
+	}
+	if err := os.Remove(backupPath(l.cfg.MaxBackups)); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	for idx := l.cfg.MaxBackups - 1; idx > 0; idx-- {
+		if err := os.Rename(backupPath(idx), backupPath(idx+1)); err != nil && !os.IsNotExist(err) {
+			return errors.WithStack(err)
+		}
+	}
+	return errors.WithStack(os.Rename(l.cfg.Path, backupPath(1)))
+}
+
+// Sample returns whether query should be logged by sample rate
+func (l *QueryLogger) Sample() bool {
+	return l.cfg.SampleRate >= 1 || rand.Float64() < l.cfg.SampleRate
+}
+
+// Log writes entry as a line of JSON. If size of log file exceeds MaxSize, it is rotated before writing.
+func (l *QueryLogger) Log(entry *QueryEntry) error {
+	if !l.cfg.WithArgs {
+		entry.Args = nil
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		// arguments may include values cannot be marshaled
+		args := make([]interface{}, len(entry.Args))
+		for idx, arg := range entry.Args {
+			args[idx] = fmt.Sprint(arg)
+		}
+		copied := *entry
+		copied.Args = args
+		if line, err = json.Marshal(&copied); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	line = append(line, '\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return errors.New("query log is already closed")
+	}
+	if l.size > 0 && l.size+int64(len(line)) > l.cfg.MaxSize {
+		if err := l.rotate(); err != nil {
+			return errors.Wrapf(err, "cannot rotate query log %s", l.cfg.Path)
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return errors.WithStack(err)
+}
+
+// Close closes log file
+func (l *QueryLogger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return errors.WithStack(err)
+}
+
+var (
+	loggerMu sync.RWMutex
+	logger   *QueryLogger
+)
+
+// SetQueryLogger sets logger receives queries executed by octillery. nil removes current logger.
+func SetQueryLogger(l *QueryLogger) {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	logger = l
+}
+
+// LogQuery logs query started at start if query logger is set and query is sampled.
+// Failure of logging doesn't affect query, so it is only printed in debug mode.
+func LogQuery(start time.Time, queryText string, args []interface{}, err error) {
+	loggerMu.RLock()
+	l := logger
+	loggerMu.RUnlock()
+	if l == nil || !l.Sample() {
+		return
+	}
+	entry := &QueryEntry{
+		Time:     start,
+		Query:    queryText,
+		Args:     args,
+		Duration: time.Since(start),
+	}
+	if parser, parseErr := sqlparser.New(); parseErr == nil {
+		if query, parseErr := parser.Parse(queryText, args...); parseErr == nil {
+			entry.Table = query.Table()
+		}
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if logErr := l.Log(entry); logErr != nil {
+		debug.Printf("failed to log query: %+v", logErr)
+	}
+}
@@ -0,0 +1,113 @@
+package logging
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/path"
+)
+
+func checkErr(t *testing.T, err error) {
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func init() {
+	confPath := filepath.Join(path.ThisDirPath(), "..", "test_databases.yml")
+	if _, err := config.Load(confPath); err != nil {
+		panic(err)
+	}
+}
+
+func readEntries(t *testing.T, logPath string) []*QueryEntry {
+	file, err := os.Open(logPath)
+	checkErr(t, err)
+	defer file.Close()
+	entries := []*QueryEntry{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry QueryEntry
+		checkErr(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, &entry)
+	}
+	checkErr(t, scanner.Err())
+	return entries
+}
+
+func TestQueryLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "octillery_logging")
+	checkErr(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Run("log query", func(t *testing.T) {
+		logPath := filepath.Join(dir, "query.log")
+		logger, err := NewQueryLogger(&Config{Path: logPath, WithArgs: true})
+		checkErr(t, err)
+		SetQueryLogger(logger)
+		defer SetQueryLogger(nil)
+
+		LogQuery(time.Now(), "select * from users where id = ?", []interface{}{int64(1)}, nil)
+		LogQuery(time.Now(), "invalid query", nil, errors.New("syntax error"))
+		checkErr(t, logger.Close())
+
+		entries := readEntries(t, logPath)
+		if len(entries) != 2 {
+			t.Fatalf("cannot log queries. %d", len(entries))
+		}
+		if entries[0].Table != "users" || entries[0].Query != "select * from users where id = ?" || len(entries[0].Args) != 1 {
+			t.Fatalf("invalid entry %+v", entries[0])
+		}
+		if entries[1].Table != "" || entries[1].Error != "syntax error" {
+			t.Fatalf("invalid entry %+v", entries[1])
+		}
+	})
+	t.Run("sampling", func(t *testing.T) {
+		logPath := filepath.Join(dir, "sampled.log")
+		logger, err := NewQueryLogger(&Config{Path: logPath, SampleRate: 0.5})
+		checkErr(t, err)
+		SetQueryLogger(logger)
+		defer SetQueryLogger(nil)
+
+		for i := 0; i < 1000; i++ {
+			LogQuery(time.Now(), "select * from users where id = ?", []interface{}{int64(1)}, nil)
+		}
+		checkErr(t, logger.Close())
+		entries := readEntries(t, logPath)
+		if len(entries) == 0 || len(entries) == 1000 {
+			t.Fatalf("cannot sample queries. %d", len(entries))
+		}
+		if len(entries[0].Args) != 0 {
+			t.Fatal("arguments must not be logged without WithArgs")
+		}
+		if _, err := NewQueryLogger(&Config{Path: logPath, SampleRate: 2}); err == nil {
+			t.Fatal("cannot validate sample rate")
+		}
+	})
+	t.Run("rotation", func(t *testing.T) {
+		logPath := filepath.Join(dir, "rotated.log")
+		logger, err := NewQueryLogger(&Config{Path: logPath, MaxSize: 200, MaxBackups: 2})
+		checkErr(t, err)
+		for i := 0; i < 10; i++ {
+			checkErr(t, logger.Log(&QueryEntry{Time: time.Now(), Table: "users", Query: "select * from users"}))
+		}
+		checkErr(t, logger.Close())
+		for _, name := range []string{"rotated.log", "rotated.log.1", "rotated.log.2"} {
+			info, err := os.Stat(filepath.Join(dir, name))
+			checkErr(t, err)
+			if info.Size() > 200 {
+				t.Fatalf("%s is not rotated. %d bytes", name, info.Size())
+			}
+		}
+		if _, err := os.Stat(filepath.Join(dir, "rotated.log.3")); !os.IsNotExist(err) {
+			t.Fatal("rotated files over MaxBackups must be removed")
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package logging
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.knocknote.io/octillery/debug"
+)
+
+// SlowQuery query taking longer than slow_query_threshold in configuration file on a database ( shard )
+type SlowQuery struct {
+	// time when query is started
+	Time time.Time `json:"time"`
+	// table name
+	Table string `json:"table,omitempty"`
+	// shard name defined in configuration file. empty if table is not sharded
+	ShardName string `json:"shard,omitempty"`
+	// DSN of database query is executed on
+	DSN string `json:"dsn"`
+	// query text sent to database
+	Query string `json:"query"`
+	// types of query arguments ( e.g. '<string>' ). values are not logged because they may include sensitive values
+	Args []interface{} `json:"args,omitempty"`
+	// elapsed time of query in nanoseconds
+	Duration time.Duration `json:"duration"`
+	// number of rows affected by query. -1 if query doesn't return result ( e.g. SELECT )
+	RowsAffected int64 `json:"rows_affected"`
+	// error message if query is failed
+	Error string `json:"error,omitempty"`
+}
+
+func (q *SlowQuery) String() string {
+	target := q.DSN
+	if q.ShardName != "" {
+		target = q.ShardName
+	}
+	text := fmt.Sprintf("%s on %s: %s", q.Duration, target, q.Query)
+	if len(q.Args) > 0 {
+		text += fmt.Sprintf(" args = %v", q.Args)
+	}
+	if q.RowsAffected >= 0 {
+		text += fmt.Sprintf(" rows_affected = %d", q.RowsAffected)
+	}
+	if q.Error != "" {
+		text += fmt.Sprintf(" error = %s", q.Error)
+	}
+	return text
+}
+
+// SlowQueryHandler receives slow queries. It must be safe for concurrent use.
+type SlowQueryHandler func(*SlowQuery)
+
+var (
+	slowQueryHandlerMu sync.RWMutex
+	slowQueryHandler   SlowQueryHandler
+)
+
+// SetSlowQueryHandler sets handler receives slow queries ( e.g. for writing them to application logger ). nil removes current handler.
+func SetSlowQueryHandler(h SlowQueryHandler) {
+	slowQueryHandlerMu.Lock()
+	defer slowQueryHandlerMu.Unlock()
+	slowQueryHandler = h
+}
+
+// LogSlowQuery notifies slow query to handler, and prints it if debug mode.
+func LogSlowQuery(q *SlowQuery) {
+	debug.Printf("[SLOW QUERY] %s", q)
+	slowQueryHandlerMu.RLock()
+	h := slowQueryHandler
+	slowQueryHandlerMu.RUnlock()
+	if h != nil {
+		h(q)
+	}
+}
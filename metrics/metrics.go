@@ -0,0 +1,135 @@
+// Package metrics provides counters of queries for sharded tables partitioned by how they are routed to shards.
+//
+// Applications can read them by RoutingCounts periodically, or receive each routing by SetRoutingHandler,
+// and export them to their monitoring system ( e.g. for tracking ratio of scatter queries per table ).
+package metrics
+
+import (
+	"sort"
+	"sync"
+
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+// RoutingMode how query is routed to shards
+type RoutingMode string
+
+const (
+	// SingleShard query is executed on a shard decided by shard_key
+	SingleShard RoutingMode = "single_shard"
+
+	// MultiShard query is executed on some of shards decided by shard_key values ( e.g. 'WHERE id IN (1, 2)' )
+	MultiShard RoutingMode = "multi_shard"
+
+	// Broadcast query is executed on all shards ( e.g. query without shard_key or DDL )
+	Broadcast RoutingMode = "broadcast"
+)
+
+// QueryKind kind of query used as label of counters
+type QueryKind string
+
+const (
+	// KindSelect SELECT query
+	KindSelect QueryKind = "select"
+
+	// KindInsert INSERT query
+	KindInsert QueryKind = "insert"
+
+	// KindUpdate UPDATE query
+	KindUpdate QueryKind = "update"
+
+	// KindDelete DELETE query
+	KindDelete QueryKind = "delete"
+
+	// KindDDL CREATE TABLE, DROP TABLE or TRUNCATE TABLE
+	KindDDL QueryKind = "ddl"
+
+	// KindOther the other queries ( e.g. SHOW )
+	KindOther QueryKind = "other"
+)
+
+// QueryKindOf returns kind of query type
+func QueryKindOf(queryType sqlparser.QueryType) QueryKind {
+	switch queryType {
+	case sqlparser.Select:
+		return KindSelect
+	case sqlparser.Insert:
+		return KindInsert
+	case sqlparser.Update:
+		return KindUpdate
+	case sqlparser.Delete:
+		return KindDelete
+	case sqlparser.CreateTable, sqlparser.Drop, sqlparser.TruncateTable:
+		return KindDDL
+	}
+	return KindOther
+}
+
+// RoutingKey labels of routing counter
+type RoutingKey struct {
+	// table name
+	Table string
+	// kind of query
+	Kind QueryKind
+	// how query is routed
+	Mode RoutingMode
+}
+
+// RoutingCount number of queries routed by the same way
+type RoutingCount struct {
+	RoutingKey
+	Count uint64
+}
+
+var (
+	mu             sync.Mutex
+	routingCounts  = map[RoutingKey]uint64{}
+	routingHandler func(RoutingKey)
+)
+
+// SetRoutingHandler sets handler called whenever query for sharded table is routed. nil removes current handler.
+// It must be safe for concurrent use.
+func SetRoutingHandler(handler func(RoutingKey)) {
+	mu.Lock()
+	defer mu.Unlock()
+	routingHandler = handler
+}
+
+// RecordRouting counts up query for table routed by mode
+func RecordRouting(table string, queryType sqlparser.QueryType, mode RoutingMode) {
+	key := RoutingKey{Table: table, Kind: QueryKindOf(queryType), Mode: mode}
+	mu.Lock()
+	routingCounts[key]++
+	handler := routingHandler
+	mu.Unlock()
+	if handler != nil {
+		handler(key)
+	}
+}
+
+// RoutingCounts returns snapshot of all counters sorted by table, kind and mode
+func RoutingCounts() []*RoutingCount {
+	mu.Lock()
+	counts := make([]*RoutingCount, 0, len(routingCounts))
+	for key, count := range routingCounts {
+		counts = append(counts, &RoutingCount{RoutingKey: key, Count: count})
+	}
+	mu.Unlock()
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Table != counts[j].Table {
+			return counts[i].Table < counts[j].Table
+		}
+		if counts[i].Kind != counts[j].Kind {
+			return counts[i].Kind < counts[j].Kind
+		}
+		return counts[i].Mode < counts[j].Mode
+	})
+	return counts
+}
+
+// ResetRoutingCounts clears all counters
+func ResetRoutingCounts() {
+	mu.Lock()
+	defer mu.Unlock()
+	routingCounts = map[RoutingKey]uint64{}
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"testing"
+
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+func TestRoutingCounts(t *testing.T) {
+	ResetRoutingCounts()
+	defer ResetRoutingCounts()
+
+	handled := []RoutingKey{}
+	SetRoutingHandler(func(key RoutingKey) { handled = append(handled, key) })
+	defer SetRoutingHandler(nil)
+
+	RecordRouting("users", sqlparser.Select, Broadcast)
+	RecordRouting("users", sqlparser.Select, SingleShard)
+	RecordRouting("users", sqlparser.Select, Broadcast)
+	RecordRouting("user_items", sqlparser.TruncateTable, Broadcast)
+
+	counts := RoutingCounts()
+	if len(counts) != 3 {
+		t.Fatalf("cannot partition counters. %d", len(counts))
+	}
+	expected := []RoutingCount{
+		{RoutingKey: RoutingKey{Table: "user_items", Kind: KindDDL, Mode: Broadcast}, Count: 1},
+		{RoutingKey: RoutingKey{Table: "users", Kind: KindSelect, Mode: Broadcast}, Count: 2},
+		{RoutingKey: RoutingKey{Table: "users", Kind: KindSelect, Mode: SingleShard}, Count: 1},
+	}
+	for idx, count := range counts {
+		if *count != expected[idx] {
+			t.Fatalf("invalid counter. expected %+v but got %+v", expected[idx], *count)
+		}
+	}
+	if len(handled) != 4 || handled[3].Table != "user_items" {
+		t.Fatal("cannot notify routing to handler")
+	}
+	ResetRoutingCounts()
+	if len(RoutingCounts()) != 0 {
+		t.Fatal("cannot reset counters")
+	}
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// SequencerObservation result of getting unique id from sequencer
+type SequencerObservation struct {
+	// table name of sequencer
+	Table string
+	// time spent by sequencer
+	Duration time.Duration
+	// error of sequencer. nil if id is published
+	Err error
+}
+
+// CommitFailure failure of commit to a database in transaction
+type CommitFailure struct {
+	// DSN of database failed to commit
+	DSN string
+	// true if transaction is already committed on other databases ( distributed transaction error )
+	Critical bool
+}
+
+var (
+	observerMu           sync.RWMutex
+	sequencerHandler     func(*SequencerObservation)
+	commitFailureHandler func(*CommitFailure)
+)
+
+// SetSequencerHandler sets handler called whenever unique id is published by sequencer. nil removes current handler.
+// It must be safe for concurrent use.
+func SetSequencerHandler(handler func(*SequencerObservation)) {
+	observerMu.Lock()
+	defer observerMu.Unlock()
+	sequencerHandler = handler
+}
+
+// SetCommitFailureHandler sets handler called whenever commit to a database fails. nil removes current handler.
+// It must be safe for concurrent use.
+func SetCommitFailureHandler(handler func(*CommitFailure)) {
+	observerMu.Lock()
+	defer observerMu.Unlock()
+	commitFailureHandler = handler
+}
+
+// RecordSequencer notifies result of sequencer for table to handler
+func RecordSequencer(table string, duration time.Duration, err error) {
+	observerMu.RLock()
+	handler := sequencerHandler
+	observerMu.RUnlock()
+	if handler != nil {
+		handler(&SequencerObservation{Table: table, Duration: duration, Err: err})
+	}
+}
+
+// RecordCommitFailure notifies failure of commit to database of dsn to handler
+func RecordCommitFailure(dsn string, critical bool) {
+	observerMu.RLock()
+	handler := commitFailureHandler
+	observerMu.RUnlock()
+	if handler != nil {
+		handler(&CommitFailure{DSN: dsn, Critical: critical})
+	}
+}
@@ -0,0 +1,148 @@
+// Package prometheus exports metrics of octillery by prometheus.Collector.
+//
+//	db, _ := sql.Open("mysql", "")
+//	collector := prometheus.NewCollector(db)
+//	promclient.MustRegister(collector)
+//
+// Queries are counted for each table and shard through query hook of DB, and number of connections are read from connection pools when metrics are collected.
+// Metrics of sequencer, distributed transaction and routing are global, so create only one Collector for an application.
+package prometheus
+
+import (
+	"context"
+	"strconv"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	osql "go.knocknote.io/octillery/database/sql"
+	"go.knocknote.io/octillery/metrics"
+)
+
+const namespace = "octillery"
+
+// Collector implements prometheus.Collector for metrics of octillery
+type Collector struct {
+	db                *osql.DB
+	queries           *prom.CounterVec
+	queryErrors       *prom.CounterVec
+	queryDuration     *prom.HistogramVec
+	sequencerDuration *prom.HistogramVec
+	sequencerErrors   *prom.CounterVec
+	commitFailures    *prom.CounterVec
+	routedQueries     *prom.Desc
+	openConnections   *prom.Desc
+	inUseConnections  *prom.Desc
+}
+
+// NewCollector creates Collector for db. It sets query hook of db and handlers of metrics package.
+// If application sets its own query hook to db, call ObserveQuery from it.
+func NewCollector(db *osql.DB) *Collector {
+	shardLabels := []string{"table", "shard"}
+	poolLabels := []string{"table", "shard", "role"}
+	c := &Collector{
+		db: db,
+		queries: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "queries_total",
+			Help:      "Number of queries executed on each shard.",
+		}, shardLabels),
+		queryErrors: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "query_errors_total",
+			Help:      "Number of queries failed on each shard.",
+		}, shardLabels),
+		queryDuration: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Name:      "query_duration_seconds",
+			Help:      "Latency of queries executed on each shard.",
+			Buckets:   prom.DefBuckets,
+		}, shardLabels),
+		sequencerDuration: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Name:      "sequencer_duration_seconds",
+			Help:      "Latency of publishing unique id by sequencer.",
+			Buckets:   prom.DefBuckets,
+		}, []string{"table"}),
+		sequencerErrors: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "sequencer_errors_total",
+			Help:      "Number of failures of sequencer.",
+		}, []string{"table"}),
+		commitFailures: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "commit_failures_total",
+			Help:      "Number of commit failures for each database. critical is true if transaction is already committed on other databases.",
+		}, []string{"dsn", "critical"}),
+		routedQueries: prom.NewDesc(
+			prom.BuildFQName(namespace, "", "routed_queries_total"),
+			"Number of queries for sharded tables by how they are routed to shards.",
+			[]string{"table", "kind", "mode"}, nil,
+		),
+		openConnections: prom.NewDesc(
+			prom.BuildFQName(namespace, "", "open_connections"),
+			"Number of established connections of each connection pool.",
+			poolLabels, nil,
+		),
+		inUseConnections: prom.NewDesc(
+			prom.BuildFQName(namespace, "", "in_use_connections"),
+			"Number of connections in use of each connection pool.",
+			poolLabels, nil,
+		),
+	}
+	db.SetQueryHook(c.ObserveQuery)
+	metrics.SetSequencerHandler(c.observeSequencer)
+	metrics.SetCommitFailureHandler(c.observeCommitFailure)
+	return c
+}
+
+// ObserveQuery counts query finished on a shard. It is set as query hook of DB by NewCollector.
+func (c *Collector) ObserveQuery(ctx context.Context, info osql.QueryHookInfo) {
+	if !info.Finished {
+		return
+	}
+	c.queries.WithLabelValues(info.Table, info.ShardName).Inc()
+	if info.Err != nil {
+		c.queryErrors.WithLabelValues(info.Table, info.ShardName).Inc()
+	}
+	c.queryDuration.WithLabelValues(info.Table, info.ShardName).Observe(info.Duration.Seconds())
+}
+
+func (c *Collector) observeSequencer(observation *metrics.SequencerObservation) {
+	c.sequencerDuration.WithLabelValues(observation.Table).Observe(observation.Duration.Seconds())
+	if observation.Err != nil {
+		c.sequencerErrors.WithLabelValues(observation.Table).Inc()
+	}
+}
+
+func (c *Collector) observeCommitFailure(failure *metrics.CommitFailure) {
+	c.commitFailures.WithLabelValues(failure.DSN, strconv.FormatBool(failure.Critical)).Inc()
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prom.Desc) {
+	c.queries.Describe(ch)
+	c.queryErrors.Describe(ch)
+	c.queryDuration.Describe(ch)
+	c.sequencerDuration.Describe(ch)
+	c.sequencerErrors.Describe(ch)
+	c.commitFailures.Describe(ch)
+	ch <- c.routedQueries
+	ch <- c.openConnections
+	ch <- c.inUseConnections
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prom.Metric) {
+	c.queries.Collect(ch)
+	c.queryErrors.Collect(ch)
+	c.queryDuration.Collect(ch)
+	c.sequencerDuration.Collect(ch)
+	c.sequencerErrors.Collect(ch)
+	c.commitFailures.Collect(ch)
+	for _, count := range metrics.RoutingCounts() {
+		ch <- prom.MustNewConstMetric(c.routedQueries, prom.CounterValue, float64(count.Count), count.Table, string(count.Kind), string(count.Mode))
+	}
+	for _, pool := range c.db.ConnectionManager().PoolStats() {
+		ch <- prom.MustNewConstMetric(c.openConnections, prom.GaugeValue, float64(pool.Stats.OpenConnections), pool.Table, pool.Shard, pool.Role)
+		ch <- prom.MustNewConstMetric(c.inUseConnections, prom.GaugeValue, float64(pool.Stats.InUse), pool.Table, pool.Shard, pool.Role)
+	}
+}
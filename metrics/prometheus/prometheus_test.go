@@ -0,0 +1,115 @@
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/connection"
+	_ "go.knocknote.io/octillery/connection/adapter/plugin"
+	osql "go.knocknote.io/octillery/database/sql"
+	"go.knocknote.io/octillery/metrics"
+)
+
+const testConfig = `
+tables:
+  users:
+    shard: true
+    shard_key: id
+    shards:
+      - user_shard_1:
+          adapter: sqlite3
+          database: %[1]s/user_shard_1.bin
+      - user_shard_2:
+          adapter: sqlite3
+          database: %[1]s/user_shard_2.bin
+`
+
+func checkErr(t *testing.T, err error) {
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func TestCollector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "octillery_prometheus")
+	checkErr(t, err)
+	defer os.RemoveAll(dir)
+	confPath := filepath.Join(dir, "databases.yml")
+	checkErr(t, ioutil.WriteFile(confPath, []byte(fmt.Sprintf(testConfig, dir)), 0644))
+	cfg, err := config.Load(confPath)
+	checkErr(t, err)
+	checkErr(t, connection.SetConfig(cfg))
+	db, err := osql.Open("sqlite3", "")
+	checkErr(t, err)
+	defer db.Close()
+
+	metrics.ResetRoutingCounts()
+	defer metrics.ResetRoutingCounts()
+	collector := NewCollector(db)
+	defer metrics.SetSequencerHandler(nil)
+	defer metrics.SetCommitFailureHandler(nil)
+
+	if _, err := db.Exec("create table if not exists users (id integer primary key, name varchar(255))"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := db.Exec("insert into users (id, name) values (1, 'alice')"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := db.Exec("insert into users (id, name) values (1, 'alice')"); err == nil {
+		t.Fatal("cannot handle error")
+	}
+
+	t.Run("queries", func(t *testing.T) {
+		if count := testutil.ToFloat64(collector.queries.WithLabelValues("users", "user_shard_2")); count != 3 {
+			t.Fatalf("invalid query count %f", count)
+		}
+		if count := testutil.ToFloat64(collector.queries.WithLabelValues("users", "user_shard_1")); count != 1 {
+			t.Fatalf("invalid query count %f", count)
+		}
+		if count := testutil.ToFloat64(collector.queryErrors.WithLabelValues("users", "user_shard_2")); count != 1 {
+			t.Fatalf("invalid error count %f", count)
+		}
+	})
+	t.Run("sequencer and commit", func(t *testing.T) {
+		metrics.RecordSequencer("users", time.Millisecond, nil)
+		metrics.RecordSequencer("users", time.Millisecond, errors.New("sequencer error"))
+		metrics.RecordCommitFailure("user_shard_1.bin", true)
+		if count := testutil.ToFloat64(collector.sequencerErrors.WithLabelValues("users")); count != 1 {
+			t.Fatalf("invalid sequencer error count %f", count)
+		}
+		if count := testutil.ToFloat64(collector.commitFailures.WithLabelValues("user_shard_1.bin", "true")); count != 1 {
+			t.Fatalf("invalid commit failure count %f", count)
+		}
+	})
+	t.Run("collect", func(t *testing.T) {
+		expected := `
+# HELP octillery_routed_queries_total Number of queries for sharded tables by how they are routed to shards.
+# TYPE octillery_routed_queries_total counter
+octillery_routed_queries_total{kind="ddl",mode="broadcast",table="users"} 1
+octillery_routed_queries_total{kind="insert",mode="single_shard",table="users"} 2
+`
+		if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "octillery_routed_queries_total"); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		rows, err := db.Query("select * from users where id = 1")
+		checkErr(t, err)
+		defer rows.Close()
+		expected = `
+# HELP octillery_in_use_connections Number of connections in use of each connection pool.
+# TYPE octillery_in_use_connections gauge
+octillery_in_use_connections{role="master",shard="user_shard_1",table="users"} 0
+octillery_in_use_connections{role="master",shard="user_shard_2",table="users"} 1
+`
+		if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "octillery_in_use_connections"); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package migrator
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/connection"
+	"go.knocknote.io/octillery/debug"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+var createTablePattern = regexp.MustCompile(`(?i)^\s*create\s+table\s+(if\s+not\s+exists\s+)?`)
+
+// CreateTablesIfNotExists executes CREATE TABLE statements found under schemaPath
+// for every database that each table belongs to ( all shards if table is sharded ).
+// Tables that already exist are not changed, so this is safe to call at every startup.
+func CreateTablesIfNotExists(schemaPath string) error {
+	queries, err := loadQueries(schemaPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	mgr, err := connection.NewConnectionManager()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer mgr.Close()
+	for _, query := range queries {
+		if query.QueryType() != sqlparser.CreateTable {
+			continue
+		}
+		ddl := strings.TrimFunc(query.(*sqlparser.QueryBase).Text, func(r rune) bool {
+			return unicode.IsSpace(r) || string(r) == ";"
+		})
+		ddl = createTablePattern.ReplaceAllString(ddl, "CREATE TABLE IF NOT EXISTS ")
+		if err := mgr.ForEachShard(query.Table(), func(shard *connection.DBShardConnection) error {
+			debug.Printf("(DB:%s):%s", shard.DSN(), ddl)
+			connection.InvalidateMetadataCache(shard.DSN())
+			if _, err := shard.Connection.Exec(ddl); err != nil {
+				return errors.Wrapf(err, "cannot create table %s to %s", query.Table(), shard.DSN())
+			}
+			return nil
+		}, &connection.ForEachShardOptions{StopOnError: true}); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,363 @@
+package migrator
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+// tableDefinition CREATE TABLE statement written in schema file.
+// It keeps text of schema file as it is, because normalized DDL by sqlparser is MySQL dialect.
+type tableDefinition struct {
+	name string
+	// CREATE TABLE statement without trailing semicolon
+	ddl string
+	// text after table name including table options ( e.g. `(id integer NOT NULL, PRIMARY KEY (id))` )
+	body    string
+	columns []*columnDefinition
+}
+
+// columnDefinition column definition of CREATE TABLE statement
+type columnDefinition struct {
+	name string
+	// text of column definition as it is ( e.g. `name varchar(255) NOT NULL DEFAULT ''` )
+	definition string
+	// declared type ( e.g. `varchar(255)` )
+	typ        string
+	notNull    bool
+	primaryKey bool
+	unique     bool
+	// expression of DEFAULT as it is. empty if DEFAULT is not specified
+	defaultValue string
+}
+
+// serverColumn column of table on database server
+type serverColumn struct {
+	name         string
+	typ          string
+	notNull      bool
+	primaryKey   bool
+	defaultValue string
+}
+
+// keywords which terminate type of column definition
+var columnOptionKeywords = map[string]bool{
+	"NOT":            true,
+	"NULL":           true,
+	"DEFAULT":        true,
+	"PRIMARY":        true,
+	"UNIQUE":         true,
+	"KEY":            true,
+	"REFERENCES":     true,
+	"CHECK":          true,
+	"COLLATE":        true,
+	"CONSTRAINT":     true,
+	"AUTO_INCREMENT": true,
+	"AUTOINCREMENT":  true,
+	"COMMENT":        true,
+	"GENERATED":      true,
+	"ON":             true,
+}
+
+// keywords which start table constraint instead of column definition
+var tableConstraintKeywords = map[string]bool{
+	"PRIMARY":    true,
+	"UNIQUE":     true,
+	"KEY":        true,
+	"INDEX":      true,
+	"CONSTRAINT": true,
+	"FOREIGN":    true,
+	"CHECK":      true,
+	"FULLTEXT":   true,
+	"SPATIAL":    true,
+}
+
+// localTableDefinitions returns definitions of tables created by allDDL in order.
+// allDDL is normalized as MySQL dialect, so text of schema file is found by comparing normalized DDL of queries.
+func localTableDefinitions(tableNameToQueryMap map[string]sqlparser.Query, allDDL []string) ([]*tableDefinition, error) {
+	ddlToQueryMap := map[string]*sqlparser.QueryBase{}
+	for _, query := range tableNameToQueryMap {
+		queryBase, ok := query.(*sqlparser.QueryBase)
+		if !ok || query.QueryType() != sqlparser.CreateTable {
+			continue
+		}
+		ddlToQueryMap[vtparser.String(queryBase.Stmt)] = queryBase
+	}
+	tables := []*tableDefinition{}
+	for _, ddl := range allDDL {
+		query, exists := ddlToQueryMap[ddl]
+		if !exists {
+			continue
+		}
+		table, err := parseTableDefinition(query.Table(), query.Text)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+// parseTableDefinition parses column definitions of CREATE TABLE statement without depending on dialect
+func parseTableDefinition(name string, ddl string) (*tableDefinition, error) {
+	ddl = strings.TrimFunc(ddl, func(r rune) bool {
+		return unicode.IsSpace(r) || r == ';'
+	})
+	begin := strings.Index(ddl, "(")
+	if begin < 0 {
+		return nil, errors.Errorf("cannot find column definitions of %s", name)
+	}
+	items, err := splitDefinitions(ddl, begin)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid schema of %s", name)
+	}
+	table := &tableDefinition{name: name, ddl: ddl, body: ddl[begin:]}
+	primaryKeys := []string{}
+	uniqueKeys := []string{}
+	for _, item := range items {
+		tokens := definitionTokens(item)
+		if len(tokens) == 0 {
+			continue
+		}
+		if tableConstraintKeywords[strings.ToUpper(tokens[0])] {
+			columns := constraintColumns(tokens)
+			switch strings.ToUpper(tokens[0]) {
+			case "PRIMARY":
+				primaryKeys = append(primaryKeys, columns...)
+			case "UNIQUE":
+				uniqueKeys = append(uniqueKeys, columns...)
+			case "CONSTRAINT":
+				if containsKeyword(tokens, "PRIMARY") {
+					primaryKeys = append(primaryKeys, columns...)
+				} else if containsKeyword(tokens, "UNIQUE") {
+					uniqueKeys = append(uniqueKeys, columns...)
+				}
+			}
+			continue
+		}
+		table.columns = append(table.columns, parseColumnDefinition(item, tokens))
+	}
+	for _, column := range table.columns {
+		for _, key := range primaryKeys {
+			if column.name == key {
+				column.primaryKey = true
+			}
+		}
+		for _, key := range uniqueKeys {
+			if column.name == key {
+				column.unique = true
+			}
+		}
+	}
+	return table, nil
+}
+
+// splitDefinitions splits text in parentheses started from begin by comma outside of quotes and nested parentheses.
+func splitDefinitions(text string, begin int) ([]string, error) {
+	items := []string{}
+	depth := 0
+	itemBegin := begin + 1
+	var quote rune
+	for idx, r := range text[begin:] {
+		pos := begin + idx
+		if quote != 0 {
+			if r == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch r {
+		case '\'', '"', '`':
+			quote = r
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				items = append(items, strings.TrimSpace(text[itemBegin:pos]))
+				return items, nil
+			}
+		case ',':
+			if depth == 1 {
+				items = append(items, strings.TrimSpace(text[itemBegin:pos]))
+				itemBegin = pos + 1
+			}
+		}
+	}
+	return nil, errors.New("parentheses are not closed")
+}
+
+// definitionTokens splits column definition into words, quoted texts and parenthesized texts.
+// Parenthesized text just after word is joined to it ( e.g. `varchar(255)` or `now()` ).
+func definitionTokens(definition string) []string {
+	tokens := []string{}
+	var token strings.Builder
+	flush := func() {
+		if token.Len() > 0 {
+			tokens = append(tokens, token.String())
+			token.Reset()
+		}
+	}
+	depth := 0
+	var quote rune
+	for _, r := range definition {
+		if quote != 0 {
+			token.WriteRune(r)
+			if r == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch {
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+			token.WriteRune(r)
+		case r == '(':
+			depth++
+			token.WriteRune(r)
+		case r == ')':
+			depth--
+			token.WriteRune(r)
+		case unicode.IsSpace(r) && depth == 0:
+			flush()
+		default:
+			token.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+func parseColumnDefinition(definition string, tokens []string) *columnDefinition {
+	column := &columnDefinition{
+		name:       unquoteIdentifier(tokens[0]),
+		definition: definition,
+	}
+	idx := 1
+	typ := []string{}
+	for ; idx < len(tokens) && !columnOptionKeywords[strings.ToUpper(tokens[idx])]; idx++ {
+		typ = append(typ, tokens[idx])
+	}
+	column.typ = strings.Join(typ, " ")
+	for ; idx < len(tokens); idx++ {
+		switch strings.ToUpper(tokens[idx]) {
+		case "NOT":
+			if idx+1 < len(tokens) && strings.ToUpper(tokens[idx+1]) == "NULL" {
+				column.notNull = true
+				idx++
+			}
+		case "PRIMARY":
+			column.primaryKey = true
+		case "UNIQUE":
+			column.unique = true
+		case "DEFAULT":
+			if idx+1 < len(tokens) {
+				column.defaultValue = tokens[idx+1]
+				idx++
+			}
+		}
+	}
+	return column
+}
+
+// constraintColumns returns column names of the first parenthesized text in table constraint
+func constraintColumns(tokens []string) []string {
+	for _, token := range tokens {
+		begin := strings.Index(token, "(")
+		if begin < 0 || !strings.HasSuffix(token, ")") {
+			continue
+		}
+		columns := []string{}
+		for _, column := range strings.Split(token[begin+1:len(token)-1], ",") {
+			// remove length of index ( e.g. `name(10)` )
+			if idx := strings.Index(column, "("); idx >= 0 {
+				column = column[:idx]
+			}
+			columns = append(columns, unquoteIdentifier(strings.TrimSpace(column)))
+		}
+		return columns
+	}
+	return nil
+}
+
+func containsKeyword(tokens []string, keyword string) bool {
+	for _, token := range tokens {
+		if strings.ToUpper(token) == keyword {
+			return true
+		}
+	}
+	return false
+}
+
+func unquoteIdentifier(name string) string {
+	if len(name) >= 2 {
+		first, last := name[0], name[len(name)-1]
+		if (first == '`' || first == '"') && first == last {
+			return name[1 : len(name)-1]
+		}
+	}
+	return name
+}
+
+// quoteIdentifier quotes identifier by double quotes of standard SQL ( PostgreSQL and SQLite )
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// normalizeType normalizes spaces and case of type to compare ( e.g. `VARCHAR ( 255 )` to `varchar(255)` )
+func normalizeType(typ string) string {
+	typ = strings.ToLower(strings.Join(strings.Fields(typ), " "))
+	typ = strings.Replace(typ, " (", "(", -1)
+	typ = strings.Replace(typ, "( ", "(", -1)
+	typ = strings.Replace(typ, " )", ")", -1)
+	typ = strings.Replace(typ, ", ", ",", -1)
+	return strings.Replace(typ, " ,", ",", -1)
+}
+
+// droppedTables returns tables on server which are not defined by schema files in order of name.
+// Tables of migration history are never dropped.
+func droppedTables(tables []*tableDefinition, serverTableNames []string) []string {
+	definedTables := map[string]bool{
+		MigrationHistoryTableName:  true,
+		MigrationRollbackTableName: true,
+	}
+	for _, table := range tables {
+		definedTables[table.name] = true
+	}
+	dropped := []string{}
+	for _, name := range serverTableNames {
+		if !definedTables[name] {
+			dropped = append(dropped, name)
+		}
+	}
+	sort.Strings(dropped)
+	return dropped
+}
+
+// schemaChange DDL to migrate a part of schema and DDL to revert it
+type schemaChange struct {
+	ddl      []string
+	rollback []string
+}
+
+// migrationDDL returns DDL of all changes in order
+func migrationDDL(changes []*schemaChange) []string {
+	diff := []string{}
+	for _, change := range changes {
+		diff = append(diff, change.ddl...)
+	}
+	return diff
+}
+
+// rollbackDDL returns DDL to revert all changes in reverse order
+func rollbackDDL(changes []*schemaChange) []string {
+	rollback := []string{}
+	for idx := len(changes) - 1; idx >= 0; idx-- {
+		rollback = append(rollback, changes[idx].rollback...)
+	}
+	return rollback
+}
@@ -0,0 +1,161 @@
+package migrator
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+// ForeignKeyWarning the FOREIGN KEY constraint that cannot be enforced by database because of sharding.
+type ForeignKeyWarning struct {
+	ForeignKey *sqlparser.ForeignKey
+	Reason     string
+}
+
+func (w *ForeignKeyWarning) String() string {
+	fk := w.ForeignKey
+	return fmt.Sprintf("%s(%s) REFERENCES %s(%s): %s",
+		fk.Table, strings.Join(fk.Columns, ", "),
+		fk.ReferencedTable, strings.Join(fk.ReferencedColumns, ", "),
+		w.Reason,
+	)
+}
+
+// CheckForeignKeys parses schema files under schemaPath and returns FOREIGN KEY constraints
+// that reference tables placed on the other database or sharded by the other key.
+// These constraints cannot be enforced by database, so application must guarantee them.
+func CheckForeignKeys(schemaPath string) ([]*ForeignKeyWarning, error) {
+	cfg, err := config.Get()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	foreignKeys, err := ForeignKeys(schemaPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	warnings := []*ForeignKeyWarning{}
+	for _, fk := range foreignKeys {
+		if reason := foreignKeyUnenforceableReason(cfg, fk); reason != "" {
+			warnings = append(warnings, &ForeignKeyWarning{ForeignKey: fk, Reason: reason})
+		}
+	}
+	return warnings, nil
+}
+
+// ForeignKeys returns all FOREIGN KEY constraints defined by schema files under schemaPath.
+func ForeignKeys(schemaPath string) ([]*sqlparser.ForeignKey, error) {
+	queries, err := loadQueries(schemaPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	foreignKeys := []*sqlparser.ForeignKey{}
+	for _, query := range queries {
+		queryBase, ok := query.(*sqlparser.QueryBase)
+		if !ok {
+			continue
+		}
+		foreignKeys = append(foreignKeys, queryBase.ForeignKeys()...)
+	}
+	return foreignKeys, nil
+}
+
+func foreignKeyUnenforceableReason(cfg *config.Config, fk *sqlparser.ForeignKey) string {
+	table, exists := cfg.Tables[fk.Table]
+	if !exists {
+		return fmt.Sprintf("cannot find %s in config file", fk.Table)
+	}
+	refTable, exists := cfg.Tables[fk.ReferencedTable]
+	if !exists {
+		return fmt.Sprintf("cannot find %s in config file", fk.ReferencedTable)
+	}
+	if !table.IsShard && !refTable.IsShard {
+		if !isSameDatabase(&table.DatabaseConfig, &refTable.DatabaseConfig) {
+			return "referenced table is placed on the other database"
+		}
+		return ""
+	}
+	if !table.IsShard || !refTable.IsShard {
+		return "either table is sharded but the other is not"
+	}
+	if len(fk.Columns) != 1 || fk.Columns[0] != cfg.ShardKeyColumnName(fk.Table) {
+		return fmt.Sprintf("foreign key column is not shard_key of %s", fk.Table)
+	}
+	if len(fk.ReferencedColumns) != 1 || fk.ReferencedColumns[0] != cfg.ShardKeyColumnName(fk.ReferencedTable) {
+		return fmt.Sprintf("referenced column is not shard_key of %s", fk.ReferencedTable)
+	}
+	if algorithmName(table.Algorithm) != algorithmName(refTable.Algorithm) {
+		return "tables are sharded by different algorithm"
+	}
+	if len(table.Shards) != len(refTable.Shards) {
+		return "tables have different number of shards"
+	}
+	for idx, shard := range table.Shards {
+		name, shardCfg := shardDatabaseConfig(shard)
+		refName, refShardCfg := shardDatabaseConfig(refTable.Shards[idx])
+		if !isSameDatabase(shardCfg, refShardCfg) {
+			return "tables are placed on different shard databases"
+		}
+		if !isSameSubShards(name, shardCfg, refName, refShardCfg) {
+			return "tables are placed on different sub-shards"
+		}
+	}
+	return ""
+}
+
+func shardDatabaseConfig(shard map[string]*config.DatabaseConfig) (string, *config.DatabaseConfig) {
+	for name, cfg := range shard {
+		return name, cfg
+	}
+	return "", &config.DatabaseConfig{}
+}
+
+// isSameSubShards returns whether rows of both shards are distributed to the same sub-shards.
+// If seed of sub-shards is not defined, it depends on shard name.
+func isSameSubShards(name string, a *config.DatabaseConfig, refName string, b *config.DatabaseConfig) bool {
+	if !a.IsSplit() && !b.IsSplit() {
+		return true
+	}
+	if len(a.SubShards) != len(b.SubShards) || a.SubShardAlgorithm != b.SubShardAlgorithm {
+		return false
+	}
+	if !reflect.DeepEqual(a.SubShardAlgorithmConfig, b.SubShardAlgorithmConfig) {
+		return false
+	}
+	if _, exists := a.SubShardAlgorithmConfig["seed"]; !exists && name != refName {
+		return false
+	}
+	for idx, subShard := range a.SubShards {
+		_, subCfg := shardDatabaseConfig(subShard)
+		_, refSubCfg := shardDatabaseConfig(b.SubShards[idx])
+		if !isSameDatabase(subCfg, refSubCfg) {
+			return false
+		}
+	}
+	return true
+}
+
+func algorithmName(name string) string {
+	if name == "" {
+		return "modulo"
+	}
+	return name
+}
+
+func isSameDatabase(a *config.DatabaseConfig, b *config.DatabaseConfig) bool {
+	if a.Adapter != b.Adapter || a.NameOrPath != b.NameOrPath {
+		return false
+	}
+	if len(a.Masters) != len(b.Masters) {
+		return false
+	}
+	for idx, master := range a.Masters {
+		if master != b.Masters[idx] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,262 @@
+package migrator
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// MigrationHistoryTableName name of table that records DDL applied by migrator on each database.
+	// This table is never dropped by migration even if it is not defined in schema files.
+	MigrationHistoryTableName = "octillery_migrations"
+	// MigrationRollbackTableName name of table that records DDL to revert each version of migration.
+	MigrationRollbackTableName = "octillery_migration_rollbacks"
+)
+
+// MigrationHistory DDL applied by migrator
+type MigrationHistory struct {
+	// DDL applied by the same migration have the same version
+	Version int64
+	// order of DDL in the migration of Version
+	Position int
+	// hex encoded SHA-256 of Statement
+	Checksum  string
+	Statement string
+	AppliedAt string
+}
+
+// MigrationStatus applied and pending DDL of a database
+type MigrationStatus struct {
+	DSN     string
+	Applied []*MigrationHistory
+	Pending []string
+}
+
+// AppliedHistory returns history of ddl if it is already applied, otherwise returns nil
+func (s *MigrationStatus) AppliedHistory(ddl string) *MigrationHistory {
+	checksum := migrationChecksum(ddl)
+	for _, history := range s.Applied {
+		if history.Checksum == checksum {
+			return history
+		}
+	}
+	return nil
+}
+
+func migrationChecksum(ddl string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(ddl)))
+	return hex.EncodeToString(sum[:])
+}
+
+// migrationHistoryTable accessor of MigrationHistoryTableName and MigrationRollbackTableName on a database
+type migrationHistoryTable struct {
+	conn    *sql.DB
+	adapter string
+}
+
+func (t *migrationHistoryTable) exists(tableName string, column string) bool {
+	rows, err := t.conn.Query(fmt.Sprintf("SELECT %s FROM %s WHERE 1 = 0", column, tableName))
+	if err != nil {
+		return false
+	}
+	rows.Close()
+	return true
+}
+
+// placeholder returns placeholder of idx-th ( 1-origin ) parameter for adapter
+func (t *migrationHistoryTable) placeholder(idx int) string {
+	if t.adapter == "postgres" {
+		return fmt.Sprintf("$%d", idx)
+	}
+	return "?"
+}
+
+// placeholders returns placeholders of num parameters for adapter
+func (t *migrationHistoryTable) placeholders(num int) string {
+	placeholders := []string{}
+	for idx := 1; idx <= num; idx++ {
+		placeholders = append(placeholders, t.placeholder(idx))
+	}
+	return strings.Join(placeholders, ", ")
+}
+
+// createHistoryTable creates table keyed by version and position, because the same DDL is applied again after its migration is reverted.
+// Reverted migration is kept with rolled_back_at.
+func (t *migrationHistoryTable) createHistoryTable() error {
+	if _, err := t.conn.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+  version bigint NOT NULL,
+  position integer NOT NULL,
+  checksum varchar(64) NOT NULL,
+  statement text NOT NULL,
+  applied_at timestamp NOT NULL,
+  rolled_back_at timestamp NULL,
+  PRIMARY KEY (version, position)
+)`, MigrationHistoryTableName)); err != nil {
+		return errors.Wrapf(err, "cannot create %s", MigrationHistoryTableName)
+	}
+	return nil
+}
+
+// upgrade rebuilds history table keyed by checksum ( created before migration can be reverted ) by current layout
+func (t *migrationHistoryTable) upgrade() error {
+	if !t.exists(MigrationHistoryTableName, "checksum") || t.exists(MigrationHistoryTableName, "rolled_back_at") {
+		return nil
+	}
+	return errors.WithStack(t.rebuild())
+}
+
+func (t *migrationHistoryTable) create() error {
+	if err := t.upgrade(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := t.createHistoryTable(); err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := t.conn.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+  version bigint NOT NULL,
+  position integer NOT NULL,
+  statement text NOT NULL,
+  PRIMARY KEY (version, position)
+)`, MigrationRollbackTableName)); err != nil {
+		return errors.Wrapf(err, "cannot create %s", MigrationRollbackTableName)
+	}
+	return nil
+}
+
+// legacyHistories returns histories of table keyed by checksum ( created before migration can be reverted ).
+// Position of each DDL is decided by order of applied time in its version.
+func (t *migrationHistoryTable) legacyHistories() ([]*MigrationHistory, error) {
+	versionColumn := "version"
+	if !t.exists(MigrationHistoryTableName, "version") {
+		// history table created before version is introduced
+		versionColumn = "0"
+	}
+	rows, err := t.conn.Query(fmt.Sprintf("SELECT %s, checksum, statement, applied_at FROM %s ORDER BY 1, applied_at, checksum", versionColumn, MigrationHistoryTableName))
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read %s", MigrationHistoryTableName)
+	}
+	defer rows.Close()
+	histories := []*MigrationHistory{}
+	for rows.Next() {
+		var history MigrationHistory
+		if err := rows.Scan(&history.Version, &history.Checksum, &history.Statement, &history.AppliedAt); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if len(histories) > 0 && histories[len(histories)-1].Version == history.Version {
+			history.Position = histories[len(histories)-1].Position + 1
+		}
+		histories = append(histories, &history)
+	}
+	return histories, errors.WithStack(rows.Err())
+}
+
+// rebuild recreates history table by current layout
+func (t *migrationHistoryTable) rebuild() error {
+	histories, err := t.legacyHistories()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := t.conn.Exec(fmt.Sprintf("DROP TABLE %s", MigrationHistoryTableName)); err != nil {
+		return errors.Wrapf(err, "cannot rebuild %s", MigrationHistoryTableName)
+	}
+	if err := t.createHistoryTable(); err != nil {
+		return errors.WithStack(err)
+	}
+	query := fmt.Sprintf("INSERT INTO %s (version, position, checksum, statement, applied_at) VALUES (%s)", MigrationHistoryTableName, t.placeholders(5))
+	for _, history := range histories {
+		if _, err := t.conn.Exec(query, history.Version, history.Position, history.Checksum, history.Statement, history.AppliedAt); err != nil {
+			return errors.Wrapf(err, "cannot rebuild %s", MigrationHistoryTableName)
+		}
+	}
+	return nil
+}
+
+// applied returns histories not reverted in order of version and position. If table doesn't exist, returns empty slice.
+func (t *migrationHistoryTable) applied() ([]*MigrationHistory, error) {
+	if !t.exists(MigrationHistoryTableName, "checksum") {
+		return []*MigrationHistory{}, nil
+	}
+	if !t.exists(MigrationHistoryTableName, "rolled_back_at") {
+		// table is rebuilt by current layout at next migration
+		histories, err := t.legacyHistories()
+		return histories, errors.WithStack(err)
+	}
+	rows, err := t.conn.Query(fmt.Sprintf("SELECT version, position, checksum, statement, applied_at FROM %s WHERE rolled_back_at IS NULL ORDER BY version, position", MigrationHistoryTableName))
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read %s", MigrationHistoryTableName)
+	}
+	defer rows.Close()
+	histories := []*MigrationHistory{}
+	for rows.Next() {
+		var history MigrationHistory
+		if err := rows.Scan(&history.Version, &history.Position, &history.Checksum, &history.Statement, &history.AppliedAt); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		histories = append(histories, &history)
+	}
+	return histories, errors.WithStack(rows.Err())
+}
+
+// nextVersion returns version for new migration
+func (t *migrationHistoryTable) nextVersion() (int64, error) {
+	var version sql.NullInt64
+	if err := t.conn.QueryRow(fmt.Sprintf("SELECT MAX(version) FROM %s", MigrationHistoryTableName)).Scan(&version); err != nil {
+		return 0, errors.Wrapf(err, "cannot get version from %s", MigrationHistoryTableName)
+	}
+	return version.Int64 + 1, nil
+}
+
+func (t *migrationHistoryTable) record(version int64, position int, ddl string) error {
+	query := fmt.Sprintf("INSERT INTO %s (version, position, checksum, statement, applied_at) VALUES (%s)", MigrationHistoryTableName, t.placeholders(5))
+	if _, err := t.conn.Exec(query, version, position, migrationChecksum(ddl), strings.TrimSpace(ddl), time.Now().UTC()); err != nil {
+		return errors.Wrapf(err, "cannot record applied DDL to %s", MigrationHistoryTableName)
+	}
+	return nil
+}
+
+func (t *migrationHistoryTable) recordRollback(version int64, rollback []string) error {
+	query := fmt.Sprintf("INSERT INTO %s (version, position, statement) VALUES (%s)", MigrationRollbackTableName, t.placeholders(3))
+	for idx, ddl := range rollback {
+		if _, err := t.conn.Exec(query, version, idx, ddl); err != nil {
+			return errors.Wrapf(err, "cannot record rollback DDL to %s", MigrationRollbackTableName)
+		}
+	}
+	return nil
+}
+
+// rollback returns DDL to revert migration of version in order to execute
+func (t *migrationHistoryTable) rollback(version int64) ([]string, error) {
+	if !t.exists(MigrationRollbackTableName, "version") {
+		return nil, nil
+	}
+	rows, err := t.conn.Query(fmt.Sprintf("SELECT statement FROM %s WHERE version = %s ORDER BY position", MigrationRollbackTableName, t.placeholders(1)), version)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read %s", MigrationRollbackTableName)
+	}
+	defer rows.Close()
+	rollback := []string{}
+	for rows.Next() {
+		var ddl string
+		if err := rows.Scan(&ddl); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		rollback = append(rollback, ddl)
+	}
+	return rollback, errors.WithStack(rows.Err())
+}
+
+// recordRolledBack marks histories of version as reverted. They are kept in history table,
+// and DDL of them can be applied again by later migration.
+func (t *migrationHistoryTable) recordRolledBack(version int64) error {
+	query := fmt.Sprintf("UPDATE %s SET rolled_back_at = %s WHERE version = %s", MigrationHistoryTableName, t.placeholder(1), t.placeholder(2))
+	if _, err := t.conn.Exec(query, time.Now().UTC(), version); err != nil {
+		return errors.Wrapf(err, "cannot record rollback of version %d to %s", version, MigrationHistoryTableName)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package migrator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMigrationHistory(t *testing.T) {
+	db := newSQLiteTestDatabase(t, "migrator_history_test")
+	defer db.close()
+	m := NewMigratorByConfig(false, true)
+	m.History = true
+
+	createUsers := "CREATE TABLE users (id integer NOT NULL PRIMARY KEY, name varchar(255) NOT NULL DEFAULT '')"
+	addAge := `ALTER TABLE "users" ADD COLUMN age int DEFAULT 0`
+	db.writeSchema(t, "users", createUsers)
+
+	t.Run("pending before migration", func(t *testing.T) {
+		statuses, err := m.Status(db.schemaDir)
+		checkErr(t, err)
+		if len(statuses) != 1 || len(statuses[0].Applied) != 0 || !reflect.DeepEqual(statuses[0].Pending, []string{createUsers}) {
+			t.Fatalf("invalid status %+v", statuses[0])
+		}
+	})
+	t.Run("applied DDL is recorded by version", func(t *testing.T) {
+		checkErr(t, m.Migrate(db.schemaDir))
+		db.writeSchema(t, "users", "CREATE TABLE users (id integer NOT NULL PRIMARY KEY, name varchar(255) NOT NULL DEFAULT '', age int DEFAULT 0)")
+		checkErr(t, m.Migrate(db.schemaDir))
+
+		statuses, err := m.Status(db.schemaDir)
+		checkErr(t, err)
+		status := statuses[0]
+		if len(status.Pending) != 0 {
+			t.Fatalf("unexpected pending DDL %v", status.Pending)
+		}
+		if len(status.Applied) != 2 {
+			t.Fatalf("invalid number of applied DDL %d", len(status.Applied))
+		}
+		for idx, expected := range []*MigrationHistory{
+			{Version: 1, Statement: createUsers, Checksum: migrationChecksum(createUsers)},
+			{Version: 2, Statement: addAge, Checksum: migrationChecksum(addAge)},
+		} {
+			applied := status.Applied[idx]
+			if applied.Version != expected.Version || applied.Statement != expected.Statement || applied.Checksum != expected.Checksum {
+				t.Fatalf("invalid history %+v", applied)
+			}
+			if applied.AppliedAt == "" {
+				t.Fatal("applied time must be recorded")
+			}
+		}
+		if history := status.AppliedHistory(addAge); history == nil || history.Version != 2 {
+			t.Fatal("cannot find applied history of DDL")
+		}
+		rollback, err := (&migrationHistoryTable{conn: db.conn, adapter: "sqlite3"}).rollback(1)
+		checkErr(t, err)
+		if !reflect.DeepEqual(rollback, []string{`DROP TABLE "users"`}) {
+			t.Fatalf("invalid rollback DDL %v", rollback)
+		}
+	})
+	t.Run("applied DDL is refused", func(t *testing.T) {
+		// table is dropped out of migrator, so DDL creating it is pending again
+		_, err := db.conn.Exec("DROP TABLE users")
+		checkErr(t, err)
+		db.writeSchema(t, "users", createUsers)
+		statuses, err := m.Status(db.schemaDir)
+		checkErr(t, err)
+		if !reflect.DeepEqual(statuses[0].Pending, []string{createUsers}) {
+			t.Fatalf("invalid pending DDL %v", statuses[0].Pending)
+		}
+		err = m.Migrate(db.schemaDir)
+		if err == nil || !strings.Contains(err.Error(), "is already applied") {
+			t.Fatalf("cannot refuse DDL already applied. err = %v", err)
+		}
+		tables, err := (&SQLiteMigrator{}).serverTables(db.conn)
+		checkErr(t, err)
+		if _, exists := tables["users"]; exists {
+			t.Fatal("refused DDL must not be applied")
+		}
+	})
+	t.Run("dry run also refuses applied DDL", func(t *testing.T) {
+		dryRun := NewMigratorByConfig(true, true)
+		dryRun.History = true
+		if err := dryRun.Migrate(db.schemaDir); err == nil {
+			t.Fatal("DDL already applied must be refused by dry run")
+		}
+	})
+}
+
+func TestMigrationHistoryAfterRollback(t *testing.T) {
+	db := newSQLiteTestDatabase(t, "migrator_history_rollback_test")
+	defer db.close()
+	m := NewMigratorByConfig(false, true)
+	m.History = true
+
+	createUsers := "CREATE TABLE users (id integer NOT NULL PRIMARY KEY, name varchar(255) NOT NULL DEFAULT '')"
+	createItems := "CREATE TABLE items (id integer NOT NULL PRIMARY KEY, user_id integer NOT NULL)"
+	db.writeSchema(t, "users", createUsers)
+	checkErr(t, m.Migrate(db.schemaDir))
+	db.writeSchema(t, "items", createItems)
+	checkErr(t, m.Migrate(db.schemaDir))
+
+	t.Run("reverted DDL can be applied again", func(t *testing.T) {
+		checkErr(t, m.Rollback(1))
+		statuses, err := m.Status(db.schemaDir)
+		checkErr(t, err)
+		if len(statuses[0].Applied) != 1 || !reflect.DeepEqual(statuses[0].Pending, []string{createItems}) {
+			t.Fatalf("invalid status after rollback %+v", statuses[0])
+		}
+		checkErr(t, m.Migrate(db.schemaDir))
+		statuses, err = m.Status(db.schemaDir)
+		checkErr(t, err)
+		applied := statuses[0].Applied
+		if len(applied) != 2 || applied[1].Statement != createItems || applied[1].Version != 3 {
+			t.Fatalf("DDL must be applied again by new version %+v", applied)
+		}
+		var reverted int
+		checkErr(t, db.conn.QueryRow("SELECT COUNT(*) FROM octillery_migrations WHERE rolled_back_at IS NOT NULL").Scan(&reverted))
+		if reverted != 1 {
+			t.Fatalf("reverted history must be kept. got %d", reverted)
+		}
+	})
+	t.Run("history table keyed by checksum is rebuilt", func(t *testing.T) {
+		_, err := db.conn.Exec("DROP TABLE octillery_migrations")
+		checkErr(t, err)
+		// history table keyed by checksum has no reverted version
+		_, err = db.conn.Exec("DELETE FROM octillery_migration_rollbacks WHERE version > 2")
+		checkErr(t, err)
+		_, err = db.conn.Exec(`CREATE TABLE octillery_migrations (
+  checksum varchar(64) NOT NULL,
+  statement text NOT NULL,
+  applied_at timestamp NOT NULL,
+  version bigint NOT NULL DEFAULT 0,
+  PRIMARY KEY (checksum)
+)`)
+		checkErr(t, err)
+		for version, ddl := range []string{createUsers, createItems} {
+			_, err := db.conn.Exec("INSERT INTO octillery_migrations (checksum, statement, applied_at, version) VALUES (?, ?, ?, ?)",
+				migrationChecksum(ddl), ddl, "2020-01-01 00:00:00", version+1)
+			checkErr(t, err)
+		}
+		statuses, err := m.Status(db.schemaDir)
+		checkErr(t, err)
+		if len(statuses[0].Applied) != 2 {
+			t.Fatalf("cannot read history table keyed by checksum %+v", statuses[0].Applied)
+		}
+		checkErr(t, m.Rollback(1))
+		checkErr(t, m.Migrate(db.schemaDir))
+		statuses, err = m.Status(db.schemaDir)
+		checkErr(t, err)
+		applied := statuses[0].Applied
+		if len(applied) != 2 || applied[1].Statement != createItems || applied[1].Version != 3 {
+			t.Fatalf("DDL must be applied again after history table is rebuilt %+v", applied)
+		}
+	})
+}
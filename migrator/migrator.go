@@ -6,10 +6,12 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 
 	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
 	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
 	"go.knocknote.io/octillery/connection"
 	"go.knocknote.io/octillery/sqlparser"
 )
@@ -20,6 +22,13 @@ type DBMigratorPlugin interface {
 	CompareSchema(*sql.DB, []string) ([]string, error)
 }
 
+// DBMigratorRollbackPlugin is implemented by DBMigratorPlugin that can generate DDL to revert migration.
+// RollbackSchema returns DDL to revert DDL returned by CompareSchema with the same arguments,
+// so it must be called before DDL is applied.
+type DBMigratorRollbackPlugin interface {
+	RollbackSchema(*sql.DB, []string) ([]string, error)
+}
+
 var (
 	migratorPluginsMu sync.RWMutex
 	migratorPlugins   = make(map[string]func() DBMigratorPlugin)
@@ -29,16 +38,33 @@ var (
 type Migrator struct {
 	DryRun bool
 	Quiet  bool
-	Plugin DBMigratorPlugin
+	// History records applied DDL to MigrationHistoryTableName and refuses to apply DDL already recorded.
+	// DDL to revert them is also recorded to MigrationRollbackTableName if plugin implements DBMigratorRollbackPlugin
+	History bool
+	// Plugin is used for all databases. If nil, plugin is chosen by adapter of each table in configuration
+	Plugin  DBMigratorPlugin
+	plugins map[string]DBMigratorPlugin
 }
 
 type dsnWithConnection struct {
-	dsn  string
-	conn *sql.DB
+	dsn     string
+	adapter string
+	conn    *sql.DB
+}
+
+// databaseDiff DDL to migrate a database
+type databaseDiff struct {
+	dsn     string
+	adapter string
+	conn    *sql.DB
+	diff    []string
+	// DDL to revert diff. nil if plugin cannot generate it
+	rollback []string
 }
 
 type combinedQuery struct {
 	queries []sqlparser.Query
+	adapter string
 	conn    *sql.DB
 }
 
@@ -68,18 +94,256 @@ func NewMigrator(adapter string, dryRun bool, isQuiet bool) (*Migrator, error) {
 	}, nil
 }
 
-// Migrate executes migrate
+// NewMigratorByConfig creates instance of Migrator that chooses DBMigratorPlugin by adapter of each table in configuration
+// ( e.g. MySQLMigrator for mysql, PostgreSQLMigrator for postgres and SQLiteMigrator for sqlite3 ).
+func NewMigratorByConfig(dryRun bool, isQuiet bool) *Migrator {
+	return &Migrator{
+		DryRun:  dryRun,
+		Quiet:   !dryRun && isQuiet,
+		plugins: map[string]DBMigratorPlugin{},
+	}
+}
+
+// plugin returns DBMigratorPlugin for adapter. Plugin is initialized by queries when it is created.
+func (m *Migrator) plugin(adapter string, queries []sqlparser.Query) (DBMigratorPlugin, error) {
+	if m.Plugin != nil {
+		return m.Plugin, nil
+	}
+	if plugin, exists := m.plugins[adapter]; exists {
+		return plugin, nil
+	}
+	migratorPluginsMu.RLock()
+	pluginCreator := migratorPlugins[adapter]
+	migratorPluginsMu.RUnlock()
+	if pluginCreator == nil {
+		return nil, errors.Errorf("cannot find migrator plugin for %s", adapter)
+	}
+	plugin := pluginCreator()
+	plugin.Init(queries)
+	if m.plugins == nil {
+		m.plugins = map[string]DBMigratorPlugin{}
+	}
+	m.plugins[adapter] = plugin
+	return plugin, nil
+}
+
+// Migrate executes migrate.
+// Cached results of metadata queries ( see connection.InvalidateMetadataCache ) are discarded for databases whose schema is changed.
+// If History is true, applied DDL is recorded to MigrationHistoryTableName of each database,
+// and migration is refused if DDL to apply is already recorded.
 func (m *Migrator) Migrate(schemaPath string) error {
 	queries, err := m.queries(schemaPath)
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	m.Plugin.Init(queries)
+	mgr, err := connection.NewConnectionManager()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer mgr.Close()
+	diffs, err := m.compare(mgr, queries, m.History && !m.DryRun)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, dbDiff := range diffs {
+		if len(dbDiff.diff) == 0 {
+			continue
+		}
+		dsn := dbDiff.dsn
+		historyTable := &migrationHistoryTable{conn: dbDiff.conn, adapter: dbDiff.adapter}
+		var version int64
+		if m.History {
+			if err := m.validateHistory(dbDiff, historyTable); err != nil {
+				return errors.WithStack(err)
+			}
+			if !m.DryRun {
+				if err := historyTable.create(); err != nil {
+					return errors.WithStack(err)
+				}
+				if version, err = historyTable.nextVersion(); err != nil {
+					return errors.WithStack(err)
+				}
+				if err := historyTable.recordRollback(version, dbDiff.rollback); err != nil {
+					return errors.WithStack(err)
+				}
+			}
+		}
+		if !m.Quiet {
+			fmt.Printf("[ %s ]\n\n", dsn)
+		}
+		for position, diff := range dbDiff.diff {
+			if !m.Quiet {
+				fmt.Printf("%s\n\n", diff)
+			}
+			if m.DryRun {
+				continue
+			}
+			// schema may be changed partially by failed DDL, so cached metadata is discarded before executing it
+			connection.InvalidateMetadataCache(dsn)
+			if _, err := dbDiff.conn.Exec(diff); err != nil {
+				return errors.WithStack(err)
+			}
+			if m.History {
+				if err := historyTable.record(version, position, diff); err != nil {
+					return errors.WithStack(err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Status returns applied DDL recorded in MigrationHistoryTableName and pending DDL of each database in order of DSN.
+func (m *Migrator) Status(schemaPath string) ([]*MigrationStatus, error) {
+	queries, err := m.queries(schemaPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	mgr, err := connection.NewConnectionManager()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer mgr.Close()
+	diffs, err := m.compare(mgr, queries, false)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	statuses := []*MigrationStatus{}
+	for _, dbDiff := range diffs {
+		historyTable := &migrationHistoryTable{conn: dbDiff.conn, adapter: dbDiff.adapter}
+		applied, err := historyTable.applied()
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot get status of %s", dbDiff.dsn)
+		}
+		statuses = append(statuses, &MigrationStatus{
+			DSN:     dbDiff.dsn,
+			Applied: applied,
+			Pending: dbDiff.diff,
+		})
+	}
+	return statuses, nil
+}
+
+// Rollback reverts the last n migrations of each database recorded by History in reverse order.
+// Migration whose DDL to revert is not recorded cannot be reverted.
+func (m *Migrator) Rollback(n int) error {
+	if n <= 0 {
+		return errors.Errorf("number of migrations to revert must be positive but got %d", n)
+	}
+	cfg, err := config.Get()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	mgr, err := connection.NewConnectionManager()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer mgr.Close()
+	dsnToHistoryTable := map[string]*migrationHistoryTable{}
+	for tableName := range cfg.Tables {
+		conn, err := mgr.ConnectionByTableName(tableName)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for _, shard := range conn.Shards() {
+			dsnToHistoryTable[shard.DSN()] = &migrationHistoryTable{
+				conn:    shard.Connection,
+				adapter: cfg.AdapterName(tableName),
+			}
+		}
+	}
+	dsns := []string{}
+	for dsn := range dsnToHistoryTable {
+		dsns = append(dsns, dsn)
+	}
+	sort.Strings(dsns)
+	for _, dsn := range dsns {
+		if err := m.rollback(dsn, dsnToHistoryTable[dsn], n); err != nil {
+			return errors.Wrapf(err, "cannot revert migration of %s", dsn)
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) rollback(dsn string, historyTable *migrationHistoryTable, n int) error {
+	if !m.DryRun {
+		if err := historyTable.upgrade(); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	applied, err := historyTable.applied()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	versions := []int64{}
+	for idx := len(applied) - 1; idx >= 0 && len(versions) < n; idx-- {
+		version := applied[idx].Version
+		if len(versions) == 0 || versions[len(versions)-1] != version {
+			versions = append(versions, version)
+		}
+	}
+	for _, version := range versions {
+		rollback, err := historyTable.rollback(version)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if len(rollback) == 0 {
+			return errors.Errorf("DDL to revert version %d is not recorded", version)
+		}
+		if !m.Quiet {
+			fmt.Printf("[ %s ] rollback version %d\n\n", dsn, version)
+		}
+		for _, ddl := range rollback {
+			if !m.Quiet {
+				fmt.Printf("%s\n\n", ddl)
+			}
+			if m.DryRun {
+				continue
+			}
+			connection.InvalidateMetadataCache(dsn)
+			if _, err := historyTable.conn.Exec(ddl); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		if m.DryRun {
+			continue
+		}
+		if err := historyTable.recordRolledBack(version); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// validateHistory returns error if any DDL of diff is already applied
+func (m *Migrator) validateHistory(dbDiff *databaseDiff, historyTable *migrationHistoryTable) error {
+	applied, err := historyTable.applied()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	status := &MigrationStatus{DSN: dbDiff.dsn, Applied: applied}
+	for _, diff := range dbDiff.diff {
+		if history := status.AppliedHistory(diff); history != nil {
+			return errors.Errorf("'%s' is already applied to %s at %s. revert its migration by rollback or delete it from %s to apply again",
+				history.Statement, dbDiff.dsn, history.AppliedAt, MigrationHistoryTableName)
+		}
+	}
+	return nil
+}
+
+// compare returns DDL to migrate each database in order of DSN. If withRollback is true, DDL to revert it is also generated.
+func (m *Migrator) compare(mgr *connection.DBConnectionManager, queries []sqlparser.Query, withRollback bool) ([]*databaseDiff, error) {
+	if m.Plugin != nil {
+		m.Plugin.Init(queries)
+	} else {
+		// plugins chosen by adapter are initialized by queries of each migration
+		m.plugins = map[string]DBMigratorPlugin{}
+	}
 	dsnToQueryMap := map[string]*combinedQuery{}
 	for _, query := range queries {
-		dsnConns, err := m.dsnWithConnections(query)
+		dsnConns, err := dsnWithConnections(mgr, query)
 		if err != nil {
-			return errors.WithStack(err)
+			return nil, errors.WithStack(err)
 		}
 		for _, dsnConn := range dsnConns {
 			dsn := dsnConn.dsn
@@ -88,39 +352,66 @@ func (m *Migrator) Migrate(schemaPath string) error {
 			} else {
 				dsnToQueryMap[dsn] = &combinedQuery{
 					queries: []sqlparser.Query{query},
+					adapter: dsnConn.adapter,
 					conn:    dsnConn.conn,
 				}
 			}
 		}
 	}
-	for dsn, combinedQuery := range dsnToQueryMap {
-		allDDL := combinedQuery.allDDL()
-		diff, err := m.Plugin.CompareSchema(combinedQuery.conn, allDDL)
+	dsns := []string{}
+	for dsn := range dsnToQueryMap {
+		dsns = append(dsns, dsn)
+	}
+	sort.Strings(dsns)
+	diffs := []*databaseDiff{}
+	for _, dsn := range dsns {
+		combinedQuery := dsnToQueryMap[dsn]
+		plugin, err := m.plugin(combinedQuery.adapter, queries)
 		if err != nil {
-			return errors.WithStack(err)
+			return nil, errors.Wrapf(err, "cannot migrate %s", dsn)
 		}
-		if len(diff) == 0 {
-			continue
+		allDDL := combinedQuery.allDDL()
+		diff, err := plugin.CompareSchema(combinedQuery.conn, allDDL)
+		if err != nil {
+			return nil, errors.WithStack(err)
 		}
-		if !m.Quiet {
-			fmt.Printf("[ %s ]\n\n", dsn)
+		dbDiff := &databaseDiff{
+			dsn:     dsn,
+			adapter: combinedQuery.adapter,
+			conn:    combinedQuery.conn,
+			diff:    diff,
 		}
-		for _, diff := range diff {
-			if !m.Quiet {
-				fmt.Printf("%s\n\n", diff)
-			}
-			if m.DryRun {
-				continue
-			}
-			if _, err := combinedQuery.conn.Exec(diff); err != nil {
-				return errors.WithStack(err)
+		if rollbackPlugin, ok := plugin.(DBMigratorRollbackPlugin); ok && withRollback && len(diff) > 0 {
+			rollback, err := rollbackPlugin.RollbackSchema(combinedQuery.conn, allDDL)
+			if err != nil {
+				return nil, errors.Wrapf(err, "cannot generate rollback DDL of %s", dsn)
 			}
+			dbDiff.rollback = rollback
 		}
+		diffs = append(diffs, dbDiff)
 	}
-	return nil
+	return diffs, nil
 }
 
 func (m *Migrator) queries(schemaPath string) ([]sqlparser.Query, error) {
+	return loadQueries(schemaPath)
+}
+
+// TableSchema returns CREATE TABLE query of tableName defined in schema files under schemaPath.
+func TableSchema(schemaPath string, tableName string) (*sqlparser.QueryBase, error) {
+	queries, err := loadQueries(schemaPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for _, query := range queries {
+		if query.QueryType() == sqlparser.CreateTable && query.Table() == tableName {
+			return query.(*sqlparser.QueryBase), nil
+		}
+	}
+	return nil, errors.Errorf("cannot find schema of %s in %s", tableName, schemaPath)
+}
+
+func loadQueries(schemaPath string) ([]sqlparser.Query, error) {
 	parser, err := sqlparser.New()
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -159,8 +450,8 @@ func (c *combinedQuery) allDDL() []string {
 	return allDDL
 }
 
-func (m *Migrator) dsnWithConnections(query sqlparser.Query) ([]*dsnWithConnection, error) {
-	mgr, err := connection.NewConnectionManager()
+func dsnWithConnections(mgr *connection.DBConnectionManager, query sqlparser.Query) ([]*dsnWithConnection, error) {
+	cfg, err := config.Get()
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -169,21 +460,11 @@ func (m *Migrator) dsnWithConnections(query sqlparser.Query) ([]*dsnWithConnecti
 		return nil, errors.WithStack(err)
 	}
 	dsnConns := []*dsnWithConnection{}
-	if conn.IsShard {
-		for _, shard := range conn.ShardConnections.AllShard() {
-			cfg := conn.Config.ShardConfigByName(shard.ShardName)
-			dsn := fmt.Sprintf("%s/%s", cfg.Masters[0], cfg.NameOrPath)
-			dsnConns = append(dsnConns, &dsnWithConnection{
-				dsn:  dsn,
-				conn: shard.Connection,
-			})
-		}
-	} else {
-		cfg := conn.Config
-		dsn := fmt.Sprintf("%s/%s", cfg.Masters[0], cfg.NameOrPath)
+	for _, shard := range conn.Shards() {
 		dsnConns = append(dsnConns, &dsnWithConnection{
-			dsn:  dsn,
-			conn: conn.Connection,
+			dsn:     shard.DSN(),
+			adapter: cfg.AdapterName(query.Table()),
+			conn:    shard.Connection,
 		})
 	}
 	return dsnConns, nil
@@ -49,6 +49,9 @@ func (s *serverSource) WriteSchema(dst io.Writer) error {
 		if err := tableRows.Scan(&table); err != nil {
 			return errors.Wrap(err, `failed to scan tables`)
 		}
+		if table == MigrationHistoryTableName || table == MigrationRollbackTableName {
+			continue
+		}
 
 		if err := db.QueryRow("SHOW CREATE TABLE `"+table+"`").Scan(&table, &tableSchema); err != nil {
 			return errors.Wrapf(err, `failed to execute 'SHOW CREATE TABLE "%s"'`, table)
@@ -86,33 +89,16 @@ func (m *MySQLMigrator) Init(queries []sqlparser.Query) {
 func (m *MySQLMigrator) CompareSchema(conn *sql.DB, allDDL []string) ([]string, error) {
 	from := &serverSource{conn: conn}
 	to := schemaTextSource(strings.Join(allDDL, ";\n"))
-	var buf bytes.Buffer
-	p := schemalex.New()
-	if err := diff.Sources(
-		&buf,
-		from,
-		to,
-		diff.WithTransaction(false), diff.WithParser(p),
-	); err != nil {
+	splittedDDL, err := m.diff(from, to)
+	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	schemaDiff := buf.String()
-	if len(schemaDiff) == 0 {
-		return nil, nil
-	}
 	parser, err := sqlparser.New()
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 	replacedDDL := []string{}
-	splittedDDL := strings.Split(schemaDiff, ";")
-	for _, ddl := range splittedDDL {
-		trimmedDDL := strings.TrimFunc(ddl, func(r rune) bool {
-			return unicode.IsSpace(r)
-		})
-		if trimmedDDL == "" {
-			continue
-		}
+	for _, trimmedDDL := range splittedDDL {
 		if !strings.HasPrefix(trimmedDDL, "CREATE TABLE") {
 			replacedDDL = append(replacedDDL, trimmedDDL)
 			continue
@@ -132,8 +118,49 @@ func (m *MySQLMigrator) CompareSchema(conn *sql.DB, allDDL []string) ([]string,
 	return replacedDDL, nil
 }
 
+// RollbackSchema returns DDL to revert DDL returned by CompareSchema.
+// It is diff from local schema to schema on mysql server before migration.
+func (m *MySQLMigrator) RollbackSchema(conn *sql.DB, allDDL []string) ([]string, error) {
+	from := schemaTextSource(strings.Join(allDDL, ";\n"))
+	to := &serverSource{conn: conn}
+	rollback, err := m.diff(from, to)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return rollback, nil
+}
+
+// diff returns DDL to change schema from `from` to `to`
+func (m *MySQLMigrator) diff(from schemalex.SchemaSource, to schemalex.SchemaSource) ([]string, error) {
+	var buf bytes.Buffer
+	p := schemalex.New()
+	if err := diff.Sources(
+		&buf,
+		from,
+		to,
+		diff.WithTransaction(false), diff.WithParser(p),
+	); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	ddl := []string{}
+	for _, splittedDDL := range strings.Split(buf.String(), ";") {
+		trimmedDDL := strings.TrimFunc(splittedDDL, func(r rune) bool {
+			return unicode.IsSpace(r)
+		})
+		if trimmedDDL == "" {
+			continue
+		}
+		ddl = append(ddl, trimmedDDL)
+	}
+	return ddl, nil
+}
+
 func init() {
 	Register("mysql", func() DBMigratorPlugin {
 		return &MySQLMigrator{}
 	})
+	// aurora adapter connects to MySQL compatible cluster
+	Register("aurora", func() DBMigratorPlugin {
+		return &MySQLMigrator{}
+	})
 }
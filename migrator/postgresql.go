@@ -0,0 +1,341 @@
+package migrator
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+// canonical names of types returned by format_type() of PostgreSQL
+var postgreSQLTypeAliases = map[string]string{
+	"int":         "integer",
+	"int4":        "integer",
+	"serial":      "integer",
+	"serial4":     "integer",
+	"int8":        "bigint",
+	"bigserial":   "bigint",
+	"serial8":     "bigint",
+	"int2":        "smallint",
+	"smallserial": "smallint",
+	"serial2":     "smallint",
+	"bool":        "boolean",
+	"varchar":     "character varying",
+	"char":        "character",
+	"bpchar":      "character",
+	"float":       "double precision",
+	"float8":      "double precision",
+	"double":      "double precision",
+	"float4":      "real",
+	"decimal":     "numeric",
+	"timestamp":   "timestamp without time zone",
+	"timestamptz": "timestamp with time zone",
+	"time":        "time without time zone",
+	"timetz":      "time with time zone",
+}
+
+var (
+	postgreSQLSerialTypes = map[string]bool{
+		"serial":      true,
+		"serial2":     true,
+		"serial4":     true,
+		"serial8":     true,
+		"smallserial": true,
+		"bigserial":   true,
+	}
+	// type cast of default value ( e.g. `'name'::character varying` )
+	postgreSQLCastPattern   = regexp.MustCompile(`::[a-z_ ]+(\([0-9, ]+\))?(\[\])?$`)
+	postgreSQLNumberPattern = regexp.MustCompile(`^'(-?[0-9.]+)'$`)
+)
+
+// PostgreSQLMigrator implements DBMigratorPlugin for PostgreSQL.
+// Tables and columns of current schema are introspected from pg_catalog,
+// and differences of columns ( type, NOT NULL and DEFAULT ) are changed by ALTER TABLE.
+// Changes of table constraints ( e.g. UNIQUE of multiple columns ) are not detected.
+type PostgreSQLMigrator struct {
+	tableNameToQueryMap map[string]sqlparser.Query
+}
+
+// Init create mapping from table name to sqlparser.Query
+func (m *PostgreSQLMigrator) Init(queries []sqlparser.Query) {
+	m.tableNameToQueryMap = map[string]sqlparser.Query{}
+	for _, query := range queries {
+		m.tableNameToQueryMap[query.Table()] = query
+	}
+}
+
+// CompareSchema compare schema on postgresql server with local schema
+func (m *PostgreSQLMigrator) CompareSchema(conn *sql.DB, allDDL []string) ([]string, error) {
+	changes, err := m.changes(conn, allDDL)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return migrationDDL(changes), nil
+}
+
+// RollbackSchema returns DDL to revert DDL returned by CompareSchema.
+// Dropped table is created by columns and primary key on pg_catalog before migration.
+func (m *PostgreSQLMigrator) RollbackSchema(conn *sql.DB, allDDL []string) ([]string, error) {
+	changes, err := m.changes(conn, allDDL)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return rollbackDDL(changes), nil
+}
+
+func (m *PostgreSQLMigrator) changes(conn *sql.DB, allDDL []string) ([]*schemaChange, error) {
+	tables, err := localTableDefinitions(m.tableNameToQueryMap, allDDL)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	serverTables, err := m.serverTables(conn)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	changes := []*schemaChange{}
+	for _, table := range tables {
+		if !serverTables[table.name] {
+			changes = append(changes, &schemaChange{
+				ddl:      []string{table.ddl},
+				rollback: []string{fmt.Sprintf("DROP TABLE %s", quoteIdentifier(table.name))},
+			})
+			continue
+		}
+		columns, err := m.serverColumns(conn, table.name)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		changes = append(changes, m.compareTable(table, columns)...)
+	}
+	serverTableNames := []string{}
+	for name := range serverTables {
+		serverTableNames = append(serverTableNames, name)
+	}
+	for _, name := range droppedTables(tables, serverTableNames) {
+		ddl, err := m.serverTableDDL(conn, name)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		changes = append(changes, &schemaChange{
+			ddl:      []string{fmt.Sprintf("DROP TABLE %s", quoteIdentifier(name))},
+			rollback: []string{ddl},
+		})
+	}
+	return changes, nil
+}
+
+func (m *PostgreSQLMigrator) serverTables(conn *sql.DB) (map[string]bool, error) {
+	rows, err := conn.Query(`
+SELECT c.relname FROM pg_catalog.pg_class c
+  JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+ WHERE c.relkind IN ('r', 'p') AND n.nspname = current_schema()`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get tables from pg_class")
+	}
+	defer rows.Close()
+	tables := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, errors.Wrap(err, "failed to scan tables")
+		}
+		tables[name] = true
+	}
+	return tables, errors.WithStack(rows.Err())
+}
+
+func (m *PostgreSQLMigrator) serverColumns(conn *sql.DB, tableName string) ([]*serverColumn, error) {
+	rows, err := conn.Query(`
+SELECT a.attname, pg_catalog.format_type(a.atttypid, a.atttypmod), a.attnotnull,
+       COALESCE(pg_catalog.pg_get_expr(d.adbin, d.adrelid), '')
+  FROM pg_catalog.pg_attribute a
+  JOIN pg_catalog.pg_class c ON c.oid = a.attrelid
+  JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+  LEFT JOIN pg_catalog.pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+ WHERE c.relname = $1 AND n.nspname = current_schema() AND a.attnum > 0 AND NOT a.attisdropped
+ ORDER BY a.attnum`, tableName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get columns of %s", tableName)
+	}
+	defer rows.Close()
+	columns := []*serverColumn{}
+	for rows.Next() {
+		var column serverColumn
+		if err := rows.Scan(&column.name, &column.typ, &column.notNull, &column.defaultValue); err != nil {
+			return nil, errors.Wrapf(err, "failed to scan columns of %s", tableName)
+		}
+		columns = append(columns, &column)
+	}
+	return columns, errors.WithStack(rows.Err())
+}
+
+// serverTableDDL returns CREATE TABLE statement of columns and primary key on server
+func (m *PostgreSQLMigrator) serverTableDDL(conn *sql.DB, tableName string) (string, error) {
+	columns, err := m.serverColumns(conn, tableName)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	definitions := []string{}
+	for _, column := range columns {
+		definitions = append(definitions, m.serverColumnDefinition(column))
+	}
+	rows, err := conn.Query(`
+SELECT a.attname FROM pg_catalog.pg_index i
+  JOIN pg_catalog.pg_class c ON c.oid = i.indrelid
+  JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+  JOIN pg_catalog.pg_attribute a ON a.attrelid = c.oid AND a.attnum = ANY(i.indkey)
+ WHERE i.indisprimary AND c.relname = $1 AND n.nspname = current_schema()
+ ORDER BY a.attnum`, tableName)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to get primary key of %s", tableName)
+	}
+	defer rows.Close()
+	primaryKeys := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return "", errors.Wrapf(err, "failed to scan primary key of %s", tableName)
+		}
+		primaryKeys = append(primaryKeys, quoteIdentifier(name))
+	}
+	if err := rows.Err(); err != nil {
+		return "", errors.WithStack(err)
+	}
+	if len(primaryKeys) > 0 {
+		definitions = append(definitions, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(primaryKeys, ", ")))
+	}
+	return fmt.Sprintf("CREATE TABLE %s (\n  %s\n)", quoteIdentifier(tableName), strings.Join(definitions, ",\n  ")), nil
+}
+
+func (m *PostgreSQLMigrator) serverColumnDefinition(column *serverColumn) string {
+	definition := fmt.Sprintf("%s %s", quoteIdentifier(column.name), column.typ)
+	if column.notNull {
+		definition += " NOT NULL"
+	}
+	if column.defaultValue != "" {
+		definition += " DEFAULT " + column.defaultValue
+	}
+	return definition
+}
+
+// compareTable returns ALTER TABLE statements for each difference of columns and statements to revert them
+func (m *PostgreSQLMigrator) compareTable(table *tableDefinition, columns []*serverColumn) []*schemaChange {
+	tableName := quoteIdentifier(table.name)
+	alter := func(format string, args ...interface{}) string {
+		return fmt.Sprintf("ALTER TABLE %s %s", tableName, fmt.Sprintf(format, args...))
+	}
+	serverColumns := map[string]*serverColumn{}
+	for _, column := range columns {
+		serverColumns[column.name] = column
+	}
+	localColumns := map[string]bool{}
+	changes := []*schemaChange{}
+	for _, local := range table.columns {
+		localColumns[local.name] = true
+		columnName := quoteIdentifier(local.name)
+		server, exists := serverColumns[local.name]
+		if !exists {
+			changes = append(changes, &schemaChange{
+				ddl:      []string{alter("ADD COLUMN %s", local.definition)},
+				rollback: []string{alter("DROP COLUMN %s", columnName)},
+			})
+			continue
+		}
+		typ := postgreSQLType(local.typ)
+		serverType := postgreSQLType(server.typ)
+		if typ != serverType {
+			changes = append(changes, &schemaChange{
+				ddl:      []string{alter("ALTER COLUMN %s TYPE %s USING %s::%s", columnName, typ, columnName, typ)},
+				rollback: []string{alter("ALTER COLUMN %s TYPE %s USING %s::%s", columnName, serverType, columnName, serverType)},
+			})
+		}
+		notNull := local.notNull || local.primaryKey
+		if notNull && !server.notNull {
+			changes = append(changes, &schemaChange{
+				ddl:      []string{alter("ALTER COLUMN %s SET NOT NULL", columnName)},
+				rollback: []string{alter("ALTER COLUMN %s DROP NOT NULL", columnName)},
+			})
+		} else if !notNull && server.notNull {
+			changes = append(changes, &schemaChange{
+				ddl:      []string{alter("ALTER COLUMN %s DROP NOT NULL", columnName)},
+				rollback: []string{alter("ALTER COLUMN %s SET NOT NULL", columnName)},
+			})
+		}
+		// default value of serial is sequence created by PostgreSQL
+		if postgreSQLSerialTypes[normalizeType(local.typ)] {
+			continue
+		}
+		if postgreSQLDefaultValue(local.defaultValue) == postgreSQLDefaultValue(server.defaultValue) {
+			continue
+		}
+		change := &schemaChange{}
+		if local.defaultValue == "" {
+			change.ddl = []string{alter("ALTER COLUMN %s DROP DEFAULT", columnName)}
+		} else {
+			change.ddl = []string{alter("ALTER COLUMN %s SET DEFAULT %s", columnName, local.defaultValue)}
+		}
+		if server.defaultValue == "" {
+			change.rollback = []string{alter("ALTER COLUMN %s DROP DEFAULT", columnName)}
+		} else {
+			change.rollback = []string{alter("ALTER COLUMN %s SET DEFAULT %s", columnName, server.defaultValue)}
+		}
+		changes = append(changes, change)
+	}
+	for _, column := range columns {
+		if localColumns[column.name] {
+			continue
+		}
+		changes = append(changes, &schemaChange{
+			ddl:      []string{alter("DROP COLUMN %s", quoteIdentifier(column.name))},
+			rollback: []string{alter("ADD COLUMN %s", m.serverColumnDefinition(column))},
+		})
+	}
+	return changes
+}
+
+// postgreSQLType returns canonical name of type ( e.g. `varchar(255)` to `character varying(255)` )
+func postgreSQLType(typ string) string {
+	typ = normalizeType(typ)
+	name, params := typ, ""
+	if idx := strings.Index(typ, "("); idx >= 0 {
+		name, params = typ[:idx], typ[idx:]
+	}
+	if alias, exists := postgreSQLTypeAliases[name]; exists {
+		name = alias
+	}
+	if name == "character" && params == "" {
+		params = "(1)"
+	}
+	return name + params
+}
+
+// postgreSQLDefaultValue normalizes default value to compare ( e.g. `'-1'::integer` to `-1` )
+func postgreSQLDefaultValue(value string) string {
+	value = strings.TrimSpace(value)
+	if strings.ToUpper(value) == "NULL" {
+		return ""
+	}
+	for {
+		casted := postgreSQLCastPattern.ReplaceAllString(value, "")
+		if casted == value {
+			break
+		}
+		value = casted
+	}
+	if matched := postgreSQLNumberPattern.FindStringSubmatch(value); matched != nil {
+		return matched[1]
+	}
+	if strings.HasPrefix(value, "'") {
+		return value
+	}
+	return strings.ToLower(value)
+}
+
+func init() {
+	Register("postgres", func() DBMigratorPlugin {
+		return &PostgreSQLMigrator{}
+	})
+}
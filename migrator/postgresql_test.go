@@ -0,0 +1,67 @@
+package migrator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPostgreSQLCompareTable(t *testing.T) {
+	table, err := parseTableDefinition("users", `
+CREATE TABLE users (
+  id bigint NOT NULL,
+  name varchar(255) NOT NULL DEFAULT '',
+  age int DEFAULT -1,
+  created_at timestamp,
+  PRIMARY KEY (id)
+);`)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if len(table.columns) != 4 || !table.columns[0].primaryKey || table.columns[1].typ != "varchar(255)" || table.columns[2].defaultValue != "-1" {
+		t.Fatalf("cannot parse table definition: %+v", table.columns)
+	}
+	m := &PostgreSQLMigrator{}
+	t.Run("same schema", func(t *testing.T) {
+		diff := migrationDDL(m.compareTable(table, []*serverColumn{
+			{name: "id", typ: "bigint", notNull: true},
+			{name: "name", typ: "character varying(255)", notNull: true, defaultValue: "''::character varying"},
+			{name: "age", typ: "integer", defaultValue: "'-1'::integer"},
+			{name: "created_at", typ: "timestamp without time zone"},
+		}))
+		if len(diff) != 0 {
+			t.Fatalf("unexpected diff %v", diff)
+		}
+	})
+	t.Run("changed schema", func(t *testing.T) {
+		changes := m.compareTable(table, []*serverColumn{
+			{name: "id", typ: "integer", notNull: true},
+			{name: "name", typ: "text"},
+			{name: "created_at", typ: "timestamp without time zone", defaultValue: "now()"},
+			{name: "deleted", typ: "boolean"},
+		})
+		expected := []string{
+			`ALTER TABLE "users" ALTER COLUMN "id" TYPE bigint USING "id"::bigint`,
+			`ALTER TABLE "users" ALTER COLUMN "name" TYPE character varying(255) USING "name"::character varying(255)`,
+			`ALTER TABLE "users" ALTER COLUMN "name" SET NOT NULL`,
+			`ALTER TABLE "users" ALTER COLUMN "name" SET DEFAULT ''`,
+			`ALTER TABLE "users" ADD COLUMN age int DEFAULT -1`,
+			`ALTER TABLE "users" ALTER COLUMN "created_at" DROP DEFAULT`,
+			`ALTER TABLE "users" DROP COLUMN "deleted"`,
+		}
+		if diff := migrationDDL(changes); !reflect.DeepEqual(diff, expected) {
+			t.Fatalf("unexpected diff %#v", diff)
+		}
+		expectedRollback := []string{
+			`ALTER TABLE "users" ADD COLUMN "deleted" boolean`,
+			`ALTER TABLE "users" ALTER COLUMN "created_at" SET DEFAULT now()`,
+			`ALTER TABLE "users" DROP COLUMN "age"`,
+			`ALTER TABLE "users" ALTER COLUMN "name" DROP DEFAULT`,
+			`ALTER TABLE "users" ALTER COLUMN "name" DROP NOT NULL`,
+			`ALTER TABLE "users" ALTER COLUMN "name" TYPE text USING "name"::text`,
+			`ALTER TABLE "users" ALTER COLUMN "id" TYPE integer USING "id"::integer`,
+		}
+		if rollback := rollbackDDL(changes); !reflect.DeepEqual(rollback, expectedRollback) {
+			t.Fatalf("unexpected rollback %#v", rollback)
+		}
+	})
+}
@@ -0,0 +1,224 @@
+package migrator
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+// prefix of table created while table is rebuilt
+const sqliteRebuildTablePrefix = "_octillery_new_"
+
+// SQLiteMigrator implements DBMigratorPlugin for SQLite.
+// Columns are compared by `PRAGMA table_info`.
+// SQLite cannot change existing columns by ALTER TABLE, so table is rebuilt
+// ( create new table, copy rows of common columns, drop old table and rename new table ) except only columns are added.
+// Changes of table constraints ( e.g. UNIQUE of multiple columns ) are not detected.
+type SQLiteMigrator struct {
+	tableNameToQueryMap map[string]sqlparser.Query
+}
+
+// Init create mapping from table name to sqlparser.Query
+func (m *SQLiteMigrator) Init(queries []sqlparser.Query) {
+	m.tableNameToQueryMap = map[string]sqlparser.Query{}
+	for _, query := range queries {
+		m.tableNameToQueryMap[query.Table()] = query
+	}
+}
+
+// CompareSchema compare schema on sqlite database with local schema
+func (m *SQLiteMigrator) CompareSchema(conn *sql.DB, allDDL []string) ([]string, error) {
+	changes, err := m.changes(conn, allDDL)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return migrationDDL(changes), nil
+}
+
+// RollbackSchema returns DDL to revert DDL returned by CompareSchema.
+// Changed tables are rebuilt by CREATE TABLE statement on sqlite_master before migration.
+func (m *SQLiteMigrator) RollbackSchema(conn *sql.DB, allDDL []string) ([]string, error) {
+	changes, err := m.changes(conn, allDDL)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return rollbackDDL(changes), nil
+}
+
+func (m *SQLiteMigrator) changes(conn *sql.DB, allDDL []string) ([]*schemaChange, error) {
+	tables, err := localTableDefinitions(m.tableNameToQueryMap, allDDL)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	serverTables, err := m.serverTables(conn)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	changes := []*schemaChange{}
+	for _, table := range tables {
+		tableName := quoteIdentifier(table.name)
+		serverSchema, exists := serverTables[table.name]
+		if !exists {
+			changes = append(changes, &schemaChange{
+				ddl:      []string{table.ddl},
+				rollback: []string{fmt.Sprintf("DROP TABLE %s", tableName)},
+			})
+			continue
+		}
+		columns, err := m.serverColumns(conn, table.name)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		diff := m.compareTable(table, columns)
+		if len(diff) == 0 {
+			continue
+		}
+		serverTable, err := parseTableDefinition(table.name, serverSchema)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		// columns after migration
+		migratedColumns := []*serverColumn{}
+		for _, column := range table.columns {
+			migratedColumns = append(migratedColumns, &serverColumn{name: column.name})
+		}
+		changes = append(changes, &schemaChange{
+			ddl:      diff,
+			rollback: m.rebuildTable(serverTable, migratedColumns),
+		})
+	}
+	serverTableNames := []string{}
+	for name := range serverTables {
+		serverTableNames = append(serverTableNames, name)
+	}
+	for _, name := range droppedTables(tables, serverTableNames) {
+		changes = append(changes, &schemaChange{
+			ddl:      []string{fmt.Sprintf("DROP TABLE %s", quoteIdentifier(name))},
+			rollback: []string{serverTables[name]},
+		})
+	}
+	return changes, nil
+}
+
+// serverTables returns mapping from table name to CREATE TABLE statement
+func (m *SQLiteMigrator) serverTables(conn *sql.DB) (map[string]string, error) {
+	rows, err := conn.Query("SELECT name, sql FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get tables from sqlite_master")
+	}
+	defer rows.Close()
+	tables := map[string]string{}
+	for rows.Next() {
+		var name, schema string
+		if err := rows.Scan(&name, &schema); err != nil {
+			return nil, errors.Wrap(err, "failed to scan tables")
+		}
+		tables[name] = schema
+	}
+	return tables, errors.WithStack(rows.Err())
+}
+
+func (m *SQLiteMigrator) serverColumns(conn *sql.DB, tableName string) ([]*serverColumn, error) {
+	rows, err := conn.Query(fmt.Sprintf("PRAGMA table_info(%s)", quoteIdentifier(tableName)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get columns of %s", tableName)
+	}
+	defer rows.Close()
+	columns := []*serverColumn{}
+	for rows.Next() {
+		var (
+			cid          int
+			column       serverColumn
+			notNull      int
+			defaultValue sql.NullString
+			primaryKey   int
+		)
+		if err := rows.Scan(&cid, &column.name, &column.typ, &notNull, &defaultValue, &primaryKey); err != nil {
+			return nil, errors.Wrapf(err, "failed to scan columns of %s", tableName)
+		}
+		column.notNull = notNull != 0
+		column.defaultValue = defaultValue.String
+		column.primaryKey = primaryKey > 0
+		columns = append(columns, &column)
+	}
+	return columns, errors.WithStack(rows.Err())
+}
+
+// compareTable returns ALTER TABLE statements if columns are only added, otherwise statements to rebuild table.
+func (m *SQLiteMigrator) compareTable(table *tableDefinition, columns []*serverColumn) []string {
+	if len(columns) > len(table.columns) {
+		return m.rebuildTable(table, columns)
+	}
+	for idx, column := range columns {
+		if !m.isSameColumn(table.columns[idx], column) {
+			return m.rebuildTable(table, columns)
+		}
+	}
+	addedColumns := table.columns[len(columns):]
+	for _, column := range addedColumns {
+		if !m.canAddColumn(column) {
+			return m.rebuildTable(table, columns)
+		}
+	}
+	diff := []string{}
+	for _, column := range addedColumns {
+		diff = append(diff, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", quoteIdentifier(table.name), column.definition))
+	}
+	return diff
+}
+
+func (m *SQLiteMigrator) isSameColumn(local *columnDefinition, server *serverColumn) bool {
+	return strings.EqualFold(local.name, server.name) &&
+		normalizeType(local.typ) == normalizeType(server.typ) &&
+		local.notNull == server.notNull &&
+		local.primaryKey == server.primaryKey &&
+		strings.TrimSpace(local.defaultValue) == strings.TrimSpace(server.defaultValue)
+}
+
+// canAddColumn returns whether column can be added by ALTER TABLE ADD COLUMN of SQLite
+func (m *SQLiteMigrator) canAddColumn(column *columnDefinition) bool {
+	if column.primaryKey || column.unique {
+		return false
+	}
+	defaultValue := strings.ToUpper(column.defaultValue)
+	switch defaultValue {
+	case "CURRENT_TIME", "CURRENT_DATE", "CURRENT_TIMESTAMP":
+		return false
+	case "", "NULL":
+		return !column.notNull
+	}
+	return !strings.HasPrefix(defaultValue, "(")
+}
+
+// rebuildTable returns statements to rebuild table by procedure written in https://www.sqlite.org/lang_altertable.html
+func (m *SQLiteMigrator) rebuildTable(table *tableDefinition, columns []*serverColumn) []string {
+	newTableName := quoteIdentifier(sqliteRebuildTablePrefix + table.name)
+	tableName := quoteIdentifier(table.name)
+	diff := []string{fmt.Sprintf("CREATE TABLE %s %s", newTableName, table.body)}
+	commonColumns := []string{}
+	for _, local := range table.columns {
+		for _, server := range columns {
+			if strings.EqualFold(local.name, server.name) {
+				commonColumns = append(commonColumns, quoteIdentifier(local.name))
+				break
+			}
+		}
+	}
+	if len(commonColumns) > 0 {
+		joinedColumns := strings.Join(commonColumns, ", ")
+		diff = append(diff, fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", newTableName, joinedColumns, joinedColumns, tableName))
+	}
+	return append(diff,
+		fmt.Sprintf("DROP TABLE %s", tableName),
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", newTableName, tableName),
+	)
+}
+
+func init() {
+	Register("sqlite3", func() DBMigratorPlugin {
+		return &SQLiteMigrator{}
+	})
+}
@@ -0,0 +1,163 @@
+package migrator
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/connection"
+	_ "go.knocknote.io/octillery/connection/adapter/plugin/sqlite3"
+)
+
+func checkErr(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+}
+
+// sqliteTestDatabase named in-memory database having users and items tables, and directory of their schema files
+type sqliteTestDatabase struct {
+	conn      *sql.DB
+	schemaDir string
+	mgr       *connection.DBConnectionManager
+}
+
+// newSQLiteTestDatabase sets configuration of tables on in-memory database named name.
+// Database is kept until close is called, so it can be migrated by multiple connection managers.
+func newSQLiteTestDatabase(t *testing.T, name string) *sqliteTestDatabase {
+	t.Helper()
+	db := config.DatabaseConfig{Adapter: "sqlite3", NameOrPath: "file::memory:?cache=shared&name=" + name}
+	checkErr(t, connection.SetConfig(&config.Config{Tables: map[string]*config.TableConfig{
+		"users": {DatabaseConfig: db},
+		"items": {DatabaseConfig: db},
+	}}))
+	mgr, err := connection.NewConnectionManager()
+	checkErr(t, err)
+	conn, err := mgr.ConnectionByTableName("users")
+	checkErr(t, err)
+	schemaDir, err := ioutil.TempDir("", "octillery_migrator")
+	checkErr(t, err)
+	return &sqliteTestDatabase{conn: conn.Connection, schemaDir: schemaDir, mgr: mgr}
+}
+
+func (d *sqliteTestDatabase) close() {
+	d.mgr.Close()
+	os.RemoveAll(d.schemaDir)
+}
+
+// writeSchema writes schema file of table. if ddl is empty, schema file is removed
+func (d *sqliteTestDatabase) writeSchema(t *testing.T, tableName string, ddl string) {
+	t.Helper()
+	path := filepath.Join(d.schemaDir, tableName+".sql")
+	if ddl == "" {
+		checkErr(t, os.Remove(path))
+		return
+	}
+	checkErr(t, ioutil.WriteFile(path, []byte(ddl), 0644))
+}
+
+// pending returns DDL to migrate database to schema files
+func (d *sqliteTestDatabase) pending(t *testing.T, m *Migrator) []string {
+	t.Helper()
+	statuses, err := m.Status(d.schemaDir)
+	checkErr(t, err)
+	if len(statuses) != 1 {
+		t.Fatalf("invalid number of databases %d", len(statuses))
+	}
+	return statuses[0].Pending
+}
+
+func (d *sqliteTestDatabase) columns(t *testing.T, tableName string) []*serverColumn {
+	t.Helper()
+	columns, err := (&SQLiteMigrator{}).serverColumns(d.conn, tableName)
+	checkErr(t, err)
+	return columns
+}
+
+func (d *sqliteTestDatabase) names(t *testing.T) []string {
+	t.Helper()
+	rows, err := d.conn.Query("SELECT name FROM users ORDER BY id")
+	checkErr(t, err)
+	defer rows.Close()
+	names := []string{}
+	for rows.Next() {
+		var name string
+		checkErr(t, rows.Scan(&name))
+		names = append(names, name)
+	}
+	checkErr(t, rows.Err())
+	return names
+}
+
+func TestSQLiteMigrator(t *testing.T) {
+	db := newSQLiteTestDatabase(t, "migrator_sqlite_test")
+	defer db.close()
+	m := NewMigratorByConfig(false, true)
+
+	db.writeSchema(t, "users", "CREATE TABLE users (id integer NOT NULL PRIMARY KEY, name varchar(255) NOT NULL DEFAULT '')")
+	db.writeSchema(t, "items", "CREATE TABLE items (id integer NOT NULL PRIMARY KEY)")
+	checkErr(t, m.Migrate(db.schemaDir))
+	_, err := db.conn.Exec("INSERT INTO users (id, name) VALUES (1, 'alice'), (2, 'bob')")
+	checkErr(t, err)
+	if pending := db.pending(t, m); len(pending) != 0 {
+		t.Fatalf("unexpected diff %v", pending)
+	}
+
+	t.Run("added column", func(t *testing.T) {
+		db.writeSchema(t, "users", "CREATE TABLE users (id integer NOT NULL PRIMARY KEY, name varchar(255) NOT NULL DEFAULT '', age int DEFAULT 0)")
+		expected := []string{`ALTER TABLE "users" ADD COLUMN age int DEFAULT 0`}
+		if pending := db.pending(t, m); !reflect.DeepEqual(pending, expected) {
+			t.Fatalf("unexpected diff %#v", pending)
+		}
+		checkErr(t, m.Migrate(db.schemaDir))
+		if columns := db.columns(t, "users"); len(columns) != 3 || columns[2].name != "age" || columns[2].defaultValue != "0" {
+			t.Fatal("cannot add column")
+		}
+		if names := db.names(t); !reflect.DeepEqual(names, []string{"alice", "bob"}) {
+			t.Fatalf("rows must be kept. %v", names)
+		}
+	})
+	t.Run("changed column", func(t *testing.T) {
+		db.writeSchema(t, "users", "CREATE TABLE users (id integer NOT NULL PRIMARY KEY, name text NOT NULL DEFAULT 'none', age int DEFAULT 0)")
+		expected := []string{
+			`CREATE TABLE "_octillery_new_users" (id integer NOT NULL PRIMARY KEY, name text NOT NULL DEFAULT 'none', age int DEFAULT 0)`,
+			`INSERT INTO "_octillery_new_users" ("id", "name", "age") SELECT "id", "name", "age" FROM "users"`,
+			`DROP TABLE "users"`,
+			`ALTER TABLE "_octillery_new_users" RENAME TO "users"`,
+		}
+		if pending := db.pending(t, m); !reflect.DeepEqual(pending, expected) {
+			t.Fatalf("unexpected diff %#v", pending)
+		}
+		checkErr(t, m.Migrate(db.schemaDir))
+		if columns := db.columns(t, "users"); columns[1].typ != "text" || columns[1].defaultValue != "'none'" {
+			t.Fatalf("cannot change column %+v", columns[1])
+		}
+		if names := db.names(t); !reflect.DeepEqual(names, []string{"alice", "bob"}) {
+			t.Fatalf("rows must be copied to rebuilt table. %v", names)
+		}
+		if pending := db.pending(t, m); len(pending) != 0 {
+			t.Fatalf("unexpected diff after migration %v", pending)
+		}
+	})
+	t.Run("dropped table", func(t *testing.T) {
+		db.writeSchema(t, "items", "")
+		expected := []string{`DROP TABLE "items"`}
+		if pending := db.pending(t, m); !reflect.DeepEqual(pending, expected) {
+			t.Fatalf("unexpected diff %#v", pending)
+		}
+		checkErr(t, m.Migrate(db.schemaDir))
+		tables, err := (&SQLiteMigrator{}).serverTables(db.conn)
+		checkErr(t, err)
+		if _, exists := tables["items"]; exists {
+			t.Fatal("cannot drop table")
+		}
+		if _, exists := tables["users"]; !exists {
+			t.Fatal("table defined in schema files must not be dropped")
+		}
+	})
+}
@@ -4,9 +4,14 @@
 package octillery
 
 import (
+	"context"
 	"database/sql"
+	"io"
+	"io/ioutil"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"go.knocknote.io/octillery/config"
@@ -14,13 +19,23 @@ import (
 	osql "go.knocknote.io/octillery/database/sql"
 	"go.knocknote.io/octillery/debug"
 	"go.knocknote.io/octillery/exec"
+	"go.knocknote.io/octillery/logging"
+	"go.knocknote.io/octillery/metrics"
+	"go.knocknote.io/octillery/migrator"
 	_ "go.knocknote.io/octillery/plugin" // load database adapter plugin
 	"go.knocknote.io/octillery/sqlparser"
+	"go.knocknote.io/octillery/warning"
 )
 
 // Version is the variable for versioning Octillery
 const Version = "v1.1.1"
 
+// Features returns capabilities enabled in this binary ( e.g. 'join', 'xa', 'adapter:mysql' for compiled in database adapter ).
+// Configuration file can demand them by 'required_features', and use config.RequireFeatures to check them by application.
+func Features() []config.Feature {
+	return config.Features()
+}
+
 // LoadConfig load your database configuration file.
 //
 // If use with debug mode, set environment variable  ( `OCTILLERY_DEBUG=1` ) before call this method.
@@ -35,7 +50,187 @@ func LoadConfig(configPath string) error {
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	return errors.WithStack(connection.SetConfig(cfg))
+	return errors.WithStack(setConfig(cfg))
+}
+
+// LoadConfigFromBytes load database configuration from content of configuration file instead of file path.
+// Use this for configuration embedded by go:embed. Other behaviors are the same as LoadConfig.
+func LoadConfigFromBytes(content []byte) error {
+	isDebug, _ := strconv.ParseBool(os.Getenv("OCTILLERY_DEBUG"))
+	debug.SetDebug(isDebug)
+	cfg, err := config.LoadFromBytes(content)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(setConfig(cfg))
+}
+
+// LoadConfigFromReader load database configuration from r ( e.g. response of configuration service ).
+// Other behaviors are the same as LoadConfig.
+func LoadConfigFromReader(r io.Reader) error {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(LoadConfigFromBytes(content))
+}
+
+// setConfig sets loaded configuration, and creates tables or enables foreign key assertion by it
+func setConfig(cfg *config.Config) error {
+	if err := connection.SetConfig(cfg); err != nil {
+		return errors.WithStack(err)
+	}
+	if cfg.AutoCreateTables {
+		if err := Bootstrap(cfg.SchemaPath); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if cfg.ForeignKeyAssertion {
+		if err := enableForeignKeyAssertion(cfg); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+func enableForeignKeyAssertion(cfg *config.Config) error {
+	warnings, err := migrator.CheckForeignKeys(cfg.SchemaPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	foreignKeys := []*sqlparser.ForeignKey{}
+	for _, warning := range warnings {
+		fk := warning.ForeignKey
+		_, existsTable := cfg.Tables[fk.Table]
+		_, existsReferencedTable := cfg.Tables[fk.ReferencedTable]
+		if !existsTable || !existsReferencedTable {
+			continue
+		}
+		foreignKeys = append(foreignKeys, fk)
+	}
+	osql.SetForeignKeys(foreignKeys)
+	return nil
+}
+
+// Bootstrap creates tables defined by schema files under schemaPath for all shards if they are missing.
+//
+// If `auto_create_tables: true` is defined in configuration file, LoadConfig calls this with `schema_path` automatically.
+// This is intended for development or test environment, use migrate command of octillery for production.
+func Bootstrap(schemaPath string) error {
+	if schemaPath == "" {
+		return errors.New("schema path is required for creating tables")
+	}
+	return errors.WithStack(migrator.CreateTablesIfNotExists(schemaPath))
+}
+
+// VerifySchemas compares schema of each table between all shards, and returns error if there are differences.
+//
+// If tableNames is not specified, all sharded tables in configuration file are verified.
+// Also, `schema_verification: strict` ( or 'warn' ) in configuration file enables this at first connection to each sharded table.
+func VerifySchemas(tableNames ...string) error {
+	return errors.WithStack(connection.VerifySchemas(tableNames...))
+}
+
+// SchemaDrifts compares columns and indexes of each table between all shards, and returns drifts of tables whose shards have different schema.
+//
+// If tableNames is not specified, all sharded tables in configuration file are compared.
+func SchemaDrifts(tableNames ...string) ([]*connection.SchemaDrift, error) {
+	drifts, err := connection.SchemaDrifts(tableNames...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return drifts, nil
+}
+
+// MaintainSequencers maintains tables of sequencer for all tables using sequencer ( e.g. OPTIMIZE TABLE of MySQL ).
+//
+// This is also runnable by `octillery maintain`.
+// For periodic maintenance in application, use StartSequencerMaintenance of DB.ConnectionManager().
+func MaintainSequencers(ctx context.Context) (results []*connection.SequencerMaintenance, e error) {
+	mgr, err := connection.NewConnectionManager()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() {
+		if err := mgr.Close(); err != nil && e == nil {
+			e = errors.WithStack(err)
+		}
+	}()
+	results, err = mgr.MaintainSequencers(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return results, nil
+}
+
+// AnalyzeTables updates statistics of tables on all shards ( e.g. ANALYZE TABLE of MySQL ) and returns result of each shard.
+// concurrency is number of shards of a table analyzed at the same time. If tableNames is not specified, all tables in configuration file are analyzed.
+//
+// This is also runnable by `octillery maintain analyze`.
+func AnalyzeTables(ctx context.Context, concurrency int, tableNames ...string) (results []*connection.TableAnalysis, e error) {
+	mgr, err := connection.NewConnectionManager()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() {
+		if err := mgr.Close(); err != nil && e == nil {
+			e = errors.WithStack(err)
+		}
+	}()
+	results, err = mgr.AnalyzeTables(ctx, tableNames, concurrency)
+	if err != nil {
+		return results, errors.WithStack(err)
+	}
+	return results, nil
+}
+
+// PrepareTimeBucketShards appends shards for time buckets until lead time passes to tables using 'time_range' algorithm, and returns their names.
+// Shards are created from 'shard_template' in configuration file ( see config.Config.WithTimeBucketShards ),
+// and connections of dbs are reloaded by new configuration. If `auto_create_tables: true` is defined, tables are also created on new shards.
+//
+// Call this periodically ( e.g. daily with lead = 24 * time.Hour ) so the shard for next period exists before the period starts.
+func PrepareTimeBucketShards(lead time.Duration, dbs ...*osql.DB) ([]string, error) {
+	cfg, err := config.Get()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	newCfg, shardNames, err := cfg.WithTimeBucketShards(time.Now().Add(lead))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(shardNames) == 0 {
+		return shardNames, nil
+	}
+	for _, db := range dbs {
+		if _, err := db.ConnectionManager().Reload(newCfg); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	if err := connection.SetConfig(newCfg); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if newCfg.AutoCreateTables {
+		if err := Bootstrap(newCfg.SchemaPath); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return shardNames, nil
+}
+
+// WithAllShards returns context that acknowledges UPDATE/DELETE without shard_key for sharded table.
+//
+// If `all_shard_write_policy: require_context` is defined in configuration file,
+// these queries are rejected unless they are executed by this context like db.ExecContext(octillery.WithAllShards(ctx), query).
+func WithAllShards(ctx context.Context) context.Context {
+	return exec.WithAllShards(ctx)
+}
+
+// WithBroadcast returns context that acknowledges multi statement query ( e.g. 'stmt1; stmt2' ) whose statements are routed to different shards.
+//
+// Each statement of multi statement query is routed independently,
+// so multi statement query is rejected unless all statements are routed to the same shard or it is executed by this context.
+func WithBroadcast(ctx context.Context) context.Context {
+	return exec.WithBroadcast(ctx)
 }
 
 // Exec invoke sql.Query or sql.Exec by query type.
@@ -55,13 +250,18 @@ func Exec(db *osql.DB, queryText string) ([]*sql.Rows, sql.Result, error) {
 	if err != nil {
 		return nil, nil, errors.WithStack(err)
 	}
+	if err := exec.ValidatePermission(conn, query); err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
 
 	if query.QueryType() == sqlparser.Select {
 		if conn.IsShard {
 			rows, err := exec.NewQueryExecutor(nil, conn, nil, query).Query()
 			return rows, nil, errors.WithStack(err)
 		}
+		done := conn.StartQuery(nil, query.Table(), conn, queryText, nil)
 		rows, err := conn.Connection.Query(queryText)
+		done(nil, err)
 		return []*sql.Rows{rows}, nil, errors.WithStack(err)
 	}
 
@@ -69,10 +269,91 @@ func Exec(db *osql.DB, queryText string) ([]*sql.Rows, sql.Result, error) {
 		result, err := exec.NewQueryExecutor(nil, conn, nil, query).Exec()
 		return nil, result, errors.WithStack(err)
 	}
+	done := conn.StartQuery(nil, query.Table(), conn, queryText, nil)
 	result, err := conn.Connection.Exec(queryText)
+	done(result, err)
 	return nil, result, errors.WithStack(err)
 }
 
+// ExecOnShard invoke sql.Query or sql.Exec on master of shard named shardName of table directly.
+//
+// Query is not routed by sharding algorithm and not rewritten ( e.g. id of INSERT is not published by sequencer ),
+// so this is intended for operators inspecting individual shards.
+func ExecOnShard(db *osql.DB, tableName string, shardName string, queryText string) ([]*sql.Rows, sql.Result, error) {
+	conn, err := db.ConnectionManager().ConnectionByTableName(tableName)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	if !conn.IsShard {
+		return nil, nil, errors.Errorf("%s is not sharded table", tableName)
+	}
+	var shardConn *connection.DBShardConnection
+	shardNames := []string{}
+	for _, shard := range conn.Shards() {
+		if shard.ShardName == shardName {
+			shardConn = shard
+		}
+		shardNames = append(shardNames, shard.ShardName)
+	}
+	if shardConn == nil {
+		return nil, nil, errors.Errorf("shard %s of %s is not found. shards are %s", shardName, tableName, strings.Join(shardNames, ", "))
+	}
+	parser, err := sqlparser.New()
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	query, err := parser.Parse(queryText)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	if err := exec.ValidatePermission(conn, query); err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	done := conn.StartQuery(nil, tableName, shardConn, queryText, nil)
+	if query.QueryType().IsReadQuery() {
+		rows, err := shardConn.Connection.Query(queryText)
+		done(nil, err)
+		return []*sql.Rows{rows}, nil, errors.WithStack(err)
+	}
+	result, err := shardConn.Connection.Exec(queryText)
+	done(result, err)
+	return nil, result, errors.WithStack(err)
+}
+
+// SetWarningHandler set function for it receives warnings of silent fallback behaviors ( e.g. query for all shards ).
+// Warnings have code ( e.g. warning.ScatterQuery ), so application can turn them into alerts or metrics.
+// If handler is nil, removes current handler.
+func SetWarningHandler(handler func(*warning.Warning)) {
+	warning.SetHandler(handler)
+}
+
+// SetSlowQueryHandler set function for it receives queries taking longer than slow_query_threshold in configuration file.
+// Slow query has shard name, duration and rows affected, and it is also printed in debug mode.
+// If handler is nil, removes current handler.
+func SetSlowQueryHandler(handler func(*logging.SlowQuery)) {
+	logging.SetSlowQueryHandler(handler)
+}
+
+// SetQueryHook set function for it is called before and after every query is executed on each database ( shard ) of all DB instances.
+// Hook receives table name, shard name, DSN, arguments and, after query is executed, duration and error, so it can be used for logging or tracing.
+// If hook is nil, removes current hook. Use DB.SetQueryHook to set hook for a DB instance.
+func SetQueryHook(hook func(context.Context, osql.QueryHookInfo)) {
+	osql.SetQueryHook(hook)
+}
+
+// RoutingMetrics returns number of queries for sharded tables partitioned by table, kind of query
+// and how they are routed ( single shard, multi shard or broadcast ). Use metrics.SetRoutingHandler to receive each routing.
+func RoutingMetrics() []*metrics.RoutingCount {
+	return metrics.RoutingCounts()
+}
+
+// RegisterValueSerializer registers function serializes query argument to SQL literal embedded in query rewritten by octillery
+// ( e.g. INSERT query for sharded table ). It is used for bool, time.Time and types not supported by octillery ( e.g. custom column types )
+// before serializer of database adapter. If it returns false, the next serializer is used.
+func RegisterValueSerializer(serializer func(value interface{}) (literal string, isString bool, ok bool)) {
+	sqlparser.RegisterValueSerializer(serializer)
+}
+
 // BeforeCommitCallback set function for it is callbacked before commit.
 // Function is set as internal global variable, so must be care possible about it is called by multiple threads.
 func BeforeCommitCallback(callback func(*osql.Tx, []*osql.QueryLog) error) {
@@ -1,17 +1,27 @@
 package octillery
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/connection"
 	osql "go.knocknote.io/octillery/database/sql"
+	"go.knocknote.io/octillery/exec"
 	"go.knocknote.io/octillery/path"
+	"go.knocknote.io/octillery/sqlparser"
+	"go.knocknote.io/octillery/warning"
 )
 
 func init() {
@@ -54,6 +64,140 @@ func TestLoadConfig(t *testing.T) {
 	checkErr(t, err)
 }
 
+func TestWatchConfig(t *testing.T) {
+	confPath := filepath.Join(path.ThisDirPath(), "test_databases.yml")
+	defer func() { checkErr(t, LoadConfig(confPath)) }()
+	content, err := ioutil.ReadFile(confPath)
+	checkErr(t, err)
+	dir, err := ioutil.TempDir("", "octillery_watch")
+	checkErr(t, err)
+	defer os.RemoveAll(dir)
+	watchedPath := filepath.Join(dir, "databases.yml")
+	checkErr(t, ioutil.WriteFile(watchedPath, content, 0644))
+	checkErr(t, LoadConfig(watchedPath))
+
+	watchedDB, err := osql.Open("sqlite3", "dummy_dsn")
+	checkErr(t, err)
+	defer watchedDB.Close()
+	conn, err := watchedDB.ConnectionManager().ConnectionByTableName("users")
+	checkErr(t, err)
+
+	watcher, err := WatchConfig(watchedPath, watchedDB)
+	checkErr(t, err)
+	defer watcher.Close()
+	reloaded := make(chan error, 10)
+	watcher.OnReload(func(err error) { reloaded <- err })
+	waitReload := func(t *testing.T) error {
+		select {
+		case err := <-reloaded:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("configuration is not reloaded")
+		}
+		return nil
+	}
+	t.Run("reload", func(t *testing.T) {
+		checkErr(t, ioutil.WriteFile(watchedPath, append(content, []byte("\n# changed\n")...), 0644))
+		current, err := config.Get()
+		checkErr(t, err)
+		checkErr(t, waitReload(t))
+		if current, _ := watchedDB.ConnectionManager().ConnectionByTableName("users"); current != conn {
+			t.Fatal("connection of unchanged table must be kept")
+		}
+		if reloaded, _ := config.Get(); reloaded == current {
+			t.Fatal("cannot set reloaded configuration")
+		}
+	})
+	t.Run("configuration cannot be applied", func(t *testing.T) {
+		current, err := config.Get()
+		checkErr(t, err)
+		invalid := strings.Replace(string(content), "adapter: sqlite3", "adapter: invalid_adapter", 1)
+		checkErr(t, ioutil.WriteFile(watchedPath, []byte(invalid), 0644))
+		if err := waitReload(t); err == nil {
+			t.Fatal("cannot handle configuration cannot be applied")
+		}
+		if current, _ := watchedDB.ConnectionManager().ConnectionByTableName("users"); current != conn {
+			t.Fatal("connection must be kept if reload is failed")
+		}
+		if cfg, _ := config.Get(); cfg != current {
+			t.Fatal("configuration must not be set if reload is failed")
+		}
+	})
+	t.Run("invalid configuration", func(t *testing.T) {
+		checkErr(t, ioutil.WriteFile(watchedPath, []byte("tables: ["), 0644))
+		if err := waitReload(t); err == nil {
+			t.Fatal("cannot handle invalid configuration")
+		}
+		if current, _ := watchedDB.ConnectionManager().ConnectionByTableName("users"); current != conn {
+			t.Fatal("connection must be kept if reload is failed")
+		}
+	})
+	checkErr(t, watcher.Close())
+	checkErr(t, watcher.Close())
+}
+
+func TestReloadConfigOfMultipleDBs(t *testing.T) {
+	confPath := filepath.Join(path.ThisDirPath(), "test_databases.yml")
+	defer func() { checkErr(t, LoadConfig(confPath)) }()
+	content, err := ioutil.ReadFile(confPath)
+	checkErr(t, err)
+	dir, err := ioutil.TempDir("", "octillery_reload")
+	checkErr(t, err)
+	defer os.RemoveAll(dir)
+	reloadedPath := filepath.Join(dir, "databases.yml")
+
+	firstDB, err := osql.Open("sqlite3", "dummy_dsn")
+	checkErr(t, err)
+	defer firstDB.Close()
+	secondDB, err := osql.Open("sqlite3", "dummy_dsn")
+	checkErr(t, err)
+	defer secondDB.Close()
+	usersConn, err := firstDB.ConnectionManager().ConnectionByTableName("users")
+	checkErr(t, err)
+	_, err = secondDB.ConnectionManager().ConnectionByTableName("users")
+	checkErr(t, err)
+	_, err = secondDB.ConnectionManager().ConnectionByTableName("user_items")
+	checkErr(t, err)
+
+	// users is changed for both dbs, but user_items opened only by second db cannot be opened
+	reloaded := strings.Replace(string(content), "shard_column: id\n", "shard_column: id\n    max_open_conns: 10\n", 1)
+	reloaded = strings.Replace(reloaded, "database: /tmp/user_item_shard_1.bin", "database: /tmp/user_item_shard_1.bin\n          adapter: invalid_adapter", 1)
+	checkErr(t, ioutil.WriteFile(reloadedPath, []byte(reloaded), 0644))
+	current, err := config.Get()
+	checkErr(t, err)
+	if err := ReloadConfig(reloadedPath, firstDB, secondDB); err == nil {
+		t.Fatal("cannot handle configuration cannot be applied to second db")
+	}
+	if conn, _ := firstDB.ConnectionManager().ConnectionByTableName("users"); conn != usersConn {
+		t.Fatal("connection of first db must be kept if reload of second db is failed")
+	}
+	if cfg, _ := config.Get(); cfg != current {
+		t.Fatal("configuration must not be set if reload is failed")
+	}
+}
+
+func TestBootstrap(t *testing.T) {
+	if err := Bootstrap(""); err == nil {
+		t.Fatal("cannot handle error")
+	}
+	schemaDir, err := ioutil.TempDir("", "octillery_schema")
+	checkErr(t, err)
+	defer os.RemoveAll(schemaDir)
+	schema := "CREATE TABLE user_items (id integer not null primary key autoincrement, user_id integer not null);"
+	checkErr(t, ioutil.WriteFile(filepath.Join(schemaDir, "user_items.sql"), []byte(schema), 0644))
+	checkErr(t, Bootstrap(schemaDir))
+	// tables already exist, so this must be skipped without error
+	checkErr(t, Bootstrap(schemaDir))
+	multiRows, _, err := Exec(db, "select user_id from user_items")
+	checkErr(t, err)
+	if len(multiRows) != 8 {
+		t.Fatalf("cannot create table for all shards. got %d", len(multiRows))
+	}
+	for _, rows := range multiRows {
+		rows.Close()
+	}
+}
+
 func TestDropTableWithSequencerAndWithoutShardKey(t *testing.T) {
 	_, _, err := Exec(db, "drop table if exists users")
 	checkErr(t, err)
@@ -314,3 +458,574 @@ func TestPrepareWithoutSharding(t *testing.T) {
 		t.Fatal(errors.New("cannot get userID"))
 	}
 }
+
+func TestJoinWithShardKey(t *testing.T) {
+	_, _, err := Exec(db, "drop table if exists user_profiles")
+	checkErr(t, err)
+	_, _, err = Exec(db, "create table if not exists user_profiles (id integer not null primary key autoincrement, user_id integer not null, nickname varchar(255))")
+	checkErr(t, err)
+	userIDs := []int64{}
+	for _, name := range []string{"join_alice", "join_bob"} {
+		result, err := db.Exec(fmt.Sprintf("insert into users(id, name) values (null, '%s')", name))
+		checkErr(t, err)
+		userID, err := result.LastInsertId()
+		checkErr(t, err)
+		_, err = db.Exec(fmt.Sprintf("insert into user_profiles(user_id, nickname) values (%d, '%s_nick')", userID, name))
+		checkErr(t, err)
+		userIDs = append(userIDs, userID)
+	}
+	var nickname string
+	query := "select p.nickname from users u inner join user_profiles p on u.id = p.user_id where u.id = ?"
+	checkErr(t, db.QueryRow(query, userIDs[1]).Scan(&nickname))
+	if nickname != "join_bob_nick" {
+		t.Fatalf("cannot join by shard_key. got %s", nickname)
+	}
+	rows, err := db.Query("select u.name, p.nickname from users u inner join user_profiles p on u.id = p.user_id where u.name like 'join_%'")
+	checkErr(t, err)
+	defer rows.Close()
+	joinedRows := 0
+	for rows.Next() {
+		var name string
+		checkErr(t, rows.Scan(&name, &nickname))
+		if nickname != name+"_nick" {
+			t.Fatalf("invalid joined row %s %s", name, nickname)
+		}
+		joinedRows++
+	}
+	if joinedRows != 2 {
+		t.Fatalf("cannot join for all shards. got %d rows", joinedRows)
+	}
+}
+
+func TestAggregateForAllShards(t *testing.T) {
+	_, _, err := Exec(db, "drop table if exists user_profiles")
+	checkErr(t, err)
+	_, _, err = Exec(db, "create table if not exists user_profiles (id integer not null primary key autoincrement, user_id integer not null, nickname varchar(255), score integer)")
+	checkErr(t, err)
+	for userID := 1; userID <= 10; userID++ {
+		_, err := db.Exec(fmt.Sprintf("insert into user_profiles(user_id, nickname, score) values (%d, 'user%02d', %d)", userID, userID, userID*10))
+		checkErr(t, err)
+	}
+	t.Run("query", func(t *testing.T) {
+		rows, err := db.Query("select count(*) as cnt, sum(score), min(nickname), max(score), avg(score) from user_profiles")
+		checkErr(t, err)
+		defer rows.Close()
+		columns, err := rows.Columns()
+		checkErr(t, err)
+		if len(columns) != 5 || columns[0] != "cnt" {
+			t.Fatalf("invalid columns %v", columns)
+		}
+		var (
+			count, sum, max int64
+			min             string
+			avg             float64
+			rowCount        int
+		)
+		for rows.Next() {
+			checkErr(t, rows.Scan(&count, &sum, &min, &max, &avg))
+			rowCount++
+		}
+		if rowCount != 1 {
+			t.Fatalf("cannot merge rows. got %d rows", rowCount)
+		}
+		if count != 10 || sum != 550 || min != "user01" || max != 100 || avg != 55 {
+			t.Fatalf("cannot merge aggregate functions. count = %d sum = %d min = %s max = %d avg = %f", count, sum, min, max, avg)
+		}
+	})
+	t.Run("query row with placeholder", func(t *testing.T) {
+		var (
+			count int64
+			avg   float64
+		)
+		checkErr(t, db.QueryRow("select count(*), avg(score) from user_profiles where score > ?", int64(50)).Scan(&count, &avg))
+		if count != 5 || avg != 80 {
+			t.Fatalf("cannot merge aggregate functions. count = %d avg = %f", count, avg)
+		}
+	})
+	t.Run("no rows", func(t *testing.T) {
+		var (
+			count int64
+			sum   sql.NullInt64
+		)
+		checkErr(t, db.QueryRow("select count(*), sum(score) from user_profiles where score > 1000").Scan(&count, &sum))
+		if count != 0 || sum.Valid {
+			t.Fatal("invalid aggregate functions for empty table")
+		}
+	})
+	t.Run("min and max of string column", func(t *testing.T) {
+		for _, values := range []string{"(11, '10', 0)", "(12, '9', 0)"} {
+			_, err := db.Exec("insert into user_profiles(user_id, nickname, score) values " + values)
+			checkErr(t, err)
+		}
+		var min, max string
+		checkErr(t, db.QueryRow("select min(nickname), max(nickname) from user_profiles where score = 0").Scan(&min, &max))
+		if min != "10" || max != "9" {
+			t.Fatalf("strings must be compared as strings even if they look like numbers. min = %s max = %s", min, max)
+		}
+	})
+}
+
+func TestOrderByAndLimitForAllShards(t *testing.T) {
+	_, _, err := Exec(db, "drop table if exists user_profiles")
+	checkErr(t, err)
+	_, _, err = Exec(db, "create table if not exists user_profiles (id integer not null primary key autoincrement, user_id integer not null, nickname varchar(255), score integer)")
+	checkErr(t, err)
+	for userID := 1; userID <= 10; userID++ {
+		_, err := db.Exec(fmt.Sprintf("insert into user_profiles(user_id, nickname, score) values (%d, 'user%02d', %d)", userID, userID, (userID*7)%10))
+		checkErr(t, err)
+	}
+	selectUserIDs := func(t *testing.T, query string, args ...interface{}) []int64 {
+		rows, err := db.Query(query, args...)
+		checkErr(t, err)
+		defer rows.Close()
+		userIDs := []int64{}
+		for rows.Next() {
+			var (
+				userID int64
+				score  int64
+			)
+			checkErr(t, rows.Scan(&userID, &score))
+			userIDs = append(userIDs, userID)
+		}
+		checkErr(t, rows.Err())
+		return userIDs
+	}
+	t.Run("order by", func(t *testing.T) {
+		userIDs := selectUserIDs(t, "select user_id, score from user_profiles order by score desc, user_id")
+		expected := "[7 4 1 8 5 2 9 6 3 10]"
+		if fmt.Sprint(userIDs) != expected {
+			t.Fatalf("cannot sort merged rows. expected %s but got %v", expected, userIDs)
+		}
+	})
+	t.Run("order by and limit", func(t *testing.T) {
+		userIDs := selectUserIDs(t, "select user_id, score from user_profiles where score > ? order by score limit ?", int64(0), 3)
+		expected := "[3 6 9]"
+		if fmt.Sprint(userIDs) != expected {
+			t.Fatalf("cannot apply limit to merged rows. expected %s but got %v", expected, userIDs)
+		}
+	})
+	t.Run("order by and limit with offset", func(t *testing.T) {
+		userIDs := selectUserIDs(t, "select user_id, score from user_profiles order by 2 desc limit 2, 3")
+		expected := "[1 8 5]"
+		if fmt.Sprint(userIDs) != expected {
+			t.Fatalf("cannot apply offset to merged rows. expected %s but got %v", expected, userIDs)
+		}
+	})
+	t.Run("order by column not in select list with offset", func(t *testing.T) {
+		rows, err := db.Query("select user_id from user_profiles order by score desc limit 3 offset 2")
+		checkErr(t, err)
+		defer rows.Close()
+		columns, err := rows.Columns()
+		checkErr(t, err)
+		if len(columns) != 1 {
+			t.Fatalf("hidden columns for sorting must be removed. but got %v", columns)
+		}
+		userIDs := []int64{}
+		for rows.Next() {
+			var userID int64
+			checkErr(t, rows.Scan(&userID))
+			userIDs = append(userIDs, userID)
+		}
+		checkErr(t, rows.Err())
+		expected := "[1 8 5]"
+		if fmt.Sprint(userIDs) != expected {
+			t.Fatalf("cannot sort merged rows by column not in select list. expected %s but got %v", expected, userIDs)
+		}
+	})
+	t.Run("order by expression with offset", func(t *testing.T) {
+		userIDs := selectUserIDs(t, "select user_id, score from user_profiles order by score % 3, user_id limit ? offset ?", 4, 1)
+		expected := "[8 9 10 1]"
+		if fmt.Sprint(userIDs) != expected {
+			t.Fatalf("cannot sort merged rows by expression. expected %s but got %v", expected, userIDs)
+		}
+	})
+	t.Run("query row", func(t *testing.T) {
+		var (
+			userID int64
+			score  int64
+		)
+		checkErr(t, db.QueryRow("select user_id, score from user_profiles order by score desc limit 1").Scan(&userID, &score))
+		if userID != 7 || score != 9 {
+			t.Fatalf("cannot select first row of merged rows. user_id = %d score = %d", userID, score)
+		}
+	})
+	t.Run("order by string column", func(t *testing.T) {
+		for _, values := range []string{"(11, '10', 0)", "(12, '9', 0)"} {
+			_, err := db.Exec("insert into user_profiles(user_id, nickname, score) values " + values)
+			checkErr(t, err)
+		}
+		userIDs := selectUserIDs(t, "select user_id, score from user_profiles where score = 0 order by nickname desc")
+		expected := "[10 12 11]"
+		if fmt.Sprint(userIDs) != expected {
+			t.Fatalf("strings must be sorted as strings even if they look like numbers. expected %s but got %v", expected, userIDs)
+		}
+	})
+}
+
+func TestShardKeyIn(t *testing.T) {
+	_, _, err := Exec(db, "delete from user_profiles")
+	checkErr(t, err)
+	for userID := 1; userID <= 6; userID++ {
+		_, err := db.Exec("insert into user_profiles(user_id, nickname, score) values (?, ?, ?)", int64(userID), fmt.Sprintf("user%02d", userID), userID*10)
+		checkErr(t, err)
+	}
+	codes := map[warning.Code]int{}
+	SetWarningHandler(func(w *warning.Warning) {
+		codes[w.Code]++
+	})
+	defer SetWarningHandler(nil)
+
+	t.Run("query", func(t *testing.T) {
+		rows, err := db.Query("select user_id from user_profiles where user_id in (?, 2, ?, 5)", int64(1), int64(4))
+		checkErr(t, err)
+		defer rows.Close()
+		userIDs := map[int64]bool{}
+		for rows.Next() {
+			var userID int64
+			checkErr(t, rows.Scan(&userID))
+			userIDs[userID] = true
+		}
+		checkErr(t, rows.Err())
+		if len(userIDs) != 4 || !userIDs[1] || !userIDs[2] || !userIDs[4] || !userIDs[5] {
+			t.Fatalf("cannot select rows by IN clause. %v", userIDs)
+		}
+	})
+	t.Run("merge", func(t *testing.T) {
+		var sum int64
+		checkErr(t, db.QueryRow("select sum(score) from user_profiles where user_id in (1, 2, 3)").Scan(&sum))
+		if sum != 60 {
+			t.Fatalf("cannot merge aggregate of IN clause. sum = %d", sum)
+		}
+	})
+	t.Run("query row for single shard", func(t *testing.T) {
+		var userID int64
+		checkErr(t, db.QueryRow("select user_id from user_profiles where user_id in (3, 5) order by user_id desc").Scan(&userID))
+		if userID != 5 {
+			t.Fatalf("cannot select row by IN clause. user_id = %d", userID)
+		}
+	})
+	if codes[warning.ScatterQuery] != 0 {
+		t.Fatal("query by IN clause of shard_key must not be executed for all shards")
+	}
+}
+
+func TestPrepareForShardingTable(t *testing.T) {
+	_, _, err := Exec(db, "delete from user_profiles")
+	checkErr(t, err)
+	insertStmt, err := db.Prepare("insert into user_profiles(user_id, nickname) values (?, ?)")
+	checkErr(t, err)
+	defer insertStmt.Close()
+	for userID := 1; userID <= 4; userID++ {
+		_, err := insertStmt.Exec(userID, fmt.Sprintf("user%02d", userID))
+		checkErr(t, err)
+	}
+
+	selectStmt, err := db.Prepare("select nickname from user_profiles where user_id = ?")
+	checkErr(t, err)
+	defer selectStmt.Close()
+	for userID := 1; userID <= 4; userID++ {
+		var nickname string
+		checkErr(t, selectStmt.QueryRow(userID).Scan(&nickname))
+		if nickname != fmt.Sprintf("user%02d", userID) {
+			t.Fatalf("cannot select row of user %d by prepared statement. nickname = %s", userID, nickname)
+		}
+	}
+
+	tx, err := db.Begin()
+	checkErr(t, err)
+	updateStmt, err := tx.Prepare("update user_profiles set nickname = ? where user_id = ?")
+	checkErr(t, err)
+	for _, userID := range []int64{1, 2} {
+		result, err := updateStmt.Exec("updated", userID)
+		checkErr(t, err)
+		if affected, err := result.RowsAffected(); err != nil || affected != 1 {
+			t.Fatalf("cannot update row of user %d by prepared statement. affected = %d err = %+v", userID, affected, err)
+		}
+	}
+	checkErr(t, updateStmt.Close())
+	checkErr(t, tx.Commit())
+
+	rows, err := selectStmt.Query(int64(2))
+	checkErr(t, err)
+	defer rows.Close()
+	if !rows.Next() {
+		t.Fatal("cannot select rows by prepared statement")
+	}
+	var nickname string
+	checkErr(t, rows.Scan(&nickname))
+	if nickname != "updated" {
+		t.Fatalf("cannot commit update by prepared statement. nickname = %s", nickname)
+	}
+}
+
+func TestUniqueColumns(t *testing.T) {
+	_, _, err := Exec(db, "drop table if exists user_accounts")
+	checkErr(t, err)
+	_, _, err = Exec(db, "create table if not exists user_accounts (id integer not null primary key autoincrement, user_id integer not null, email varchar(255))")
+	checkErr(t, err)
+	email := fmt.Sprintf("%d@example.com", time.Now().UnixNano())
+	_, err = db.Exec("insert into user_accounts(id, user_id, email) values (null, ?, ?)", int64(1), email)
+	checkErr(t, err)
+	t.Run("duplicate in other shard", func(t *testing.T) {
+		_, err := db.Exec("insert into user_accounts(id, user_id, email) values (null, ?, ?)", int64(2), email)
+		if !connection.IsDuplicateUniqueValue(err) {
+			t.Fatalf("cannot reject duplicate value of unique column. err = %+v", err)
+		}
+	})
+	t.Run("released by rollback", func(t *testing.T) {
+		otherEmail := "other_" + email
+		tx, err := db.Begin()
+		checkErr(t, err)
+		_, err = tx.Exec("insert into user_accounts(id, user_id, email) values (null, ?, ?)", int64(3), otherEmail)
+		checkErr(t, err)
+		checkErr(t, tx.Rollback())
+		_, err = db.Exec("insert into user_accounts(id, user_id, email) values (null, ?, ?)", int64(4), otherEmail)
+		checkErr(t, err)
+	})
+	t.Run("null is not reserved", func(t *testing.T) {
+		for _, userID := range []int64{5, 6} {
+			_, err := db.Exec("insert into user_accounts(id, user_id, email) values (null, ?, null)", userID)
+			checkErr(t, err)
+		}
+	})
+	t.Run("update", func(t *testing.T) {
+		oldEmail := "old_" + email
+		newEmail := "new_" + email
+		_, err := db.Exec("insert into user_accounts(id, user_id, email) values (null, ?, ?)", int64(7), oldEmail)
+		checkErr(t, err)
+		if _, err := db.Exec("update user_accounts set email = ? where user_id = ?", email, int64(7)); !connection.IsDuplicateUniqueValue(err) {
+			t.Fatalf("cannot reject duplicate value by update. err = %+v", err)
+		}
+		if _, err := db.Exec("update user_accounts set email = concat(email, 'x') where user_id = ?", int64(7)); errors.Cause(err) != sqlparser.ErrUniqueColumnUpdated {
+			t.Fatalf("cannot reject expression assigned to unique column. err = %+v", err)
+		}
+		if _, err := db.Exec("update user_accounts set email = ? where user_id in (5, 6)", newEmail); errors.Cause(err) != sqlparser.ErrUniqueColumnUpdated {
+			t.Fatalf("cannot reject the same value assigned to multiple rows. err = %+v", err)
+		}
+		_, err = db.Exec("update user_accounts set email = ? where user_id = ?", newEmail, int64(7))
+		checkErr(t, err)
+		if _, err := db.Exec("insert into user_accounts(id, user_id, email) values (null, ?, ?)", int64(8), newEmail); !connection.IsDuplicateUniqueValue(err) {
+			t.Fatalf("new value must be reserved by update. err = %+v", err)
+		}
+		_, err = db.Exec("insert into user_accounts(id, user_id, email) values (null, ?, ?)", int64(8), oldEmail)
+		checkErr(t, err)
+		_, err = db.Exec("update user_accounts set email = null where user_id = ?", int64(8))
+		checkErr(t, err)
+		_, err = db.Exec("insert into user_accounts(id, user_id, email) values (null, ?, ?)", int64(9), oldEmail)
+		checkErr(t, err)
+	})
+	t.Run("delete", func(t *testing.T) {
+		deletedEmail := "deleted_" + email
+		_, err := db.Exec("insert into user_accounts(id, user_id, email) values (null, ?, ?)", int64(10), deletedEmail)
+		checkErr(t, err)
+		tx, err := db.Begin()
+		checkErr(t, err)
+		_, err = tx.Exec("delete from user_accounts where user_id = ?", int64(10))
+		checkErr(t, err)
+		checkErr(t, tx.Rollback())
+		if _, err := db.Exec("insert into user_accounts(id, user_id, email) values (null, ?, ?)", int64(11), deletedEmail); !connection.IsDuplicateUniqueValue(err) {
+			t.Fatalf("value must be kept by rollback of delete. err = %+v", err)
+		}
+		_, err = db.Exec("delete from user_accounts where user_id = ?", int64(10))
+		checkErr(t, err)
+		_, err = db.Exec("insert into user_accounts(id, user_id, email) values (null, ?, ?)", int64(11), deletedEmail)
+		checkErr(t, err)
+	})
+	t.Run("prepared update", func(t *testing.T) {
+		oldEmail := "prepared_old_" + email
+		newEmail := "prepared_new_" + email
+		_, err := db.Exec("insert into user_accounts(id, user_id, email) values (null, ?, ?)", int64(12), oldEmail)
+		checkErr(t, err)
+		stmt, err := db.Prepare("update user_accounts set email = ? where user_id = ?")
+		checkErr(t, err)
+		defer stmt.Close()
+		if _, err := stmt.Exec(email, int64(12)); !connection.IsDuplicateUniqueValue(err) {
+			t.Fatalf("cannot reject duplicate value by prepared update. err = %+v", err)
+		}
+		_, err = stmt.Exec(newEmail, int64(12))
+		checkErr(t, err)
+		if _, err := db.Exec("insert into user_accounts(id, user_id, email) values (null, ?, ?)", int64(13), newEmail); !connection.IsDuplicateUniqueValue(err) {
+			t.Fatalf("new value must be reserved by prepared update. err = %+v", err)
+		}
+		_, err = db.Exec("insert into user_accounts(id, user_id, email) values (null, ?, ?)", int64(13), oldEmail)
+		checkErr(t, err)
+	})
+	t.Run("prepared delete", func(t *testing.T) {
+		deletedEmail := "prepared_deleted_" + email
+		_, err := db.Exec("insert into user_accounts(id, user_id, email) values (null, ?, ?)", int64(14), deletedEmail)
+		checkErr(t, err)
+		stmt, err := db.Prepare("delete from user_accounts where user_id = ?")
+		checkErr(t, err)
+		defer stmt.Close()
+		_, err = stmt.Exec(int64(14))
+		checkErr(t, err)
+		_, err = db.Exec("insert into user_accounts(id, user_id, email) values (null, ?, ?)", int64(15), deletedEmail)
+		checkErr(t, err)
+	})
+}
+
+func TestMultiStatement(t *testing.T) {
+	_, _, err := Exec(db, "delete from user_profiles")
+	checkErr(t, err)
+	t.Run("same shard", func(t *testing.T) {
+		result, err := db.Exec("insert into user_profiles(user_id, nickname, score) values (?, 'user01', 1); update user_profiles set score = ? where user_id = ?", int64(1), int64(10), int64(1))
+		checkErr(t, err)
+		affectedRows, err := result.RowsAffected()
+		checkErr(t, err)
+		if affectedRows != 2 {
+			t.Fatalf("invalid affected rows %d", affectedRows)
+		}
+		var score int64
+		checkErr(t, db.QueryRow("select score from user_profiles where user_id = ?", int64(1)).Scan(&score))
+		if score != 10 {
+			t.Fatal("cannot execute all statements")
+		}
+	})
+	t.Run("different shards", func(t *testing.T) {
+		query := "insert into user_profiles(user_id, nickname, score) values (?, 'user02', 2); insert into user_profiles(user_id, nickname, score) values (?, 'user03', 3)"
+		if _, err := db.Exec(query, int64(2), int64(3)); errors.Cause(err) != exec.ErrMultiStatementNotAcknowledged {
+			t.Fatalf("cannot reject statements routed to different shards. err = %v", err)
+		}
+		_, err := db.ExecContext(WithBroadcast(context.Background()), query, int64(2), int64(3))
+		checkErr(t, err)
+	})
+	t.Run("query", func(t *testing.T) {
+		rows, err := db.QueryContext(WithBroadcast(context.Background()), "select nickname from user_profiles where user_id = ?; select score from user_profiles where user_id = ?", int64(2), int64(3))
+		checkErr(t, err)
+		defer rows.Close()
+		var nickname string
+		if !rows.Next() {
+			t.Fatal("cannot read first result set")
+		}
+		checkErr(t, rows.Scan(&nickname))
+		if rows.Next() || !rows.NextResultSet() {
+			t.Fatal("cannot move to next result set")
+		}
+		var score int64
+		if !rows.Next() {
+			t.Fatal("cannot read second result set")
+		}
+		checkErr(t, rows.Scan(&score))
+		if nickname != "user02" || score != 3 {
+			t.Fatalf("invalid results. nickname = %s score = %d", nickname, score)
+		}
+		if rows.Next() || rows.NextResultSet() {
+			t.Fatal("invalid number of result sets")
+		}
+	})
+	t.Run("query row", func(t *testing.T) {
+		var nickname string
+		if err := db.QueryRow("select nickname from user_profiles where user_id = 1; select nickname from user_profiles where user_id = 1").Scan(&nickname); err == nil {
+			t.Fatal("QueryRow must not accept multi statement query")
+		}
+	})
+}
+
+func TestWarningHandler(t *testing.T) {
+	codes := map[warning.Code]int{}
+	SetWarningHandler(func(w *warning.Warning) {
+		codes[w.Code]++
+	})
+	defer SetWarningHandler(nil)
+
+	rows, err := db.Query("select nickname from user_profiles")
+	checkErr(t, err)
+	checkErr(t, rows.Close())
+	checkErr(t, db.Ping())
+	if codes[warning.ScatterQuery] == 0 {
+		t.Fatal("cannot receive warning for query to all shards")
+	}
+
+	result, err := db.Exec("insert into user_profiles(user_id, nickname) values (?, ?)", int64(100), "warning")
+	checkErr(t, err)
+	if _, err := result.LastInsertId(); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if codes[warning.ShardLocalID] == 0 {
+		t.Fatal("cannot receive warning for LastInsertId unique only in a shard")
+	}
+}
+
+func TestRoutingSnapshot(t *testing.T) {
+	topology, err := RoutingSnapshot()
+	checkErr(t, err)
+	users := topology.Table("users")
+	if users == nil || !users.IsShard {
+		t.Fatal("cannot get topology of users")
+	}
+	if users.Algorithm.Name != DefaultShardingAlgorithm || users.Algorithm.ShardNum != 2 {
+		t.Fatalf("invalid algorithm parameters %+v", users.Algorithm)
+	}
+	if len(users.Shards) != 2 || users.Shards[1].ShardName != "user_shard_2" || users.Shards[1].ShardIndex != 1 {
+		t.Fatal("invalid shards of users")
+	}
+	if users.Sequencer == nil || users.Sequencer.Database != "/tmp/user_seq.bin" {
+		t.Fatal("invalid sequencer of users")
+	}
+	userItems := topology.Table("user_items")
+	if userItems.Algorithm.Name != "hashmap" || userItems.ShardKeyColumnName != "user_id" {
+		t.Fatal("invalid topology of user_items")
+	}
+	if topology.Table("unknown") != nil {
+		t.Fatal("unknown table must not be included")
+	}
+	hosts := hostsWithoutCredentials([]string{"user:pass@tcp(localhost:3306)", "localhost:3307", "mysql://user:p@ss@db.example.com:3306"})
+	if hosts[0] != "tcp(localhost:3306)" || hosts[1] != "localhost:3307" || hosts[2] != "mysql://db.example.com:3306" {
+		t.Fatalf("cannot remove credentials from %v", hosts)
+	}
+}
+
+func TestVerifySchemas(t *testing.T) {
+	checkErr(t, VerifySchemas("users", "user_items"))
+}
+
+func TestLoadConfigFromBytes(t *testing.T) {
+	content, err := ioutil.ReadFile(filepath.Join(path.ThisDirPath(), "test_databases.yml"))
+	checkErr(t, err)
+	checkErr(t, LoadConfigFromReader(bytes.NewReader(content)))
+	cfg, err := config.Get()
+	checkErr(t, err)
+	if !cfg.IsShardTable("users") {
+		t.Fatal("cannot load configuration from reader")
+	}
+	db, err := osql.Open("sqlite3", "dummy_dsn")
+	checkErr(t, err)
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		t.Fatalf("cannot connect by loaded configuration %+v", err)
+	}
+	if err := LoadConfigFromBytes([]byte("tables:\n  users:\n    shard: true\n    algorithm: unknown\n    read_only: true\n    write_only: true\n")); err == nil {
+		t.Fatal("cannot handle error")
+	}
+	checkErr(t, LoadConfigFromBytes(content))
+}
+
+func TestMetadataCache(t *testing.T) {
+	initializeTables(t)
+	checkErr(t, VerifySchemas("users"))
+	cached := connection.GetMetadataCacheStats()
+	checkErr(t, VerifySchemas("users"))
+	stats := connection.GetMetadataCacheStats()
+	if stats.Hits-cached.Hits != 2 || stats.Misses != cached.Misses {
+		t.Fatalf("schema of shards must be read from cache %+v", stats)
+	}
+	// DROP/CREATE TABLE discards cached schema
+	initializeTables(t)
+	checkErr(t, VerifySchemas("users"))
+	if misses := connection.GetMetadataCacheStats().Misses; misses-stats.Misses != 2 {
+		t.Fatalf("cannot invalidate cached schema by DDL. misses = %d", misses-stats.Misses)
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	features := map[config.Feature]bool{}
+	for _, feature := range Features() {
+		features[feature] = true
+	}
+	if !features[config.FeatureJoin] || !features[config.AdapterFeature("sqlite3")] {
+		t.Fatalf("cannot get enabled features %v", Features())
+	}
+	if err := config.RequireFeatures([]config.Feature{config.FeatureJoin, config.AdapterFeature("sqlite3")}); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+}
@@ -0,0 +1,6 @@
+// +build octillery_aurora
+
+package plugin
+
+// compile aurora adapter into binary by `-tags octillery_aurora` instead of `octillery install --aurora`
+import _ "go.knocknote.io/octillery/connection/adapter/plugin/aurora"
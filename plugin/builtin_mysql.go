@@ -0,0 +1,6 @@
+// +build octillery_mysql
+
+package plugin
+
+// compile mysql adapter into binary by `-tags octillery_mysql` instead of `octillery install --mysql`
+import _ "go.knocknote.io/octillery/connection/adapter/plugin/mysql"
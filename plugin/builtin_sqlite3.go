@@ -0,0 +1,6 @@
+// +build octillery_sqlite3
+
+package plugin
+
+// compile sqlite3 adapter into binary by `-tags octillery_sqlite3` instead of `octillery install --sqlite`
+import _ "go.knocknote.io/octillery/connection/adapter/plugin/sqlite3"
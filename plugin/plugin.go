@@ -1 +1,8 @@
+// Package plugin loads database adapters.
+//
+// Adapters are included by one of the following ways.
+//
+// 1. Copy adapter file to this directory by `octillery install --mysql` ( or `--aurora`, `--sqlite` )
+//
+// 2. Build with tags like `-tags octillery_mysql,octillery_aurora,octillery_sqlite3` ( no install step is required )
 package plugin
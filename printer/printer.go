@@ -2,12 +2,54 @@ package printer
 
 import (
 	"database/sql"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"strings"
 
 	"github.com/pkg/errors"
 )
 
+// Format output format of Printer
+type Format string
+
+const (
+	// FormatTable prints rows as ASCII table like MySQL client ( default )
+	FormatTable Format = "table"
+	// FormatVertical prints each column in a line like '\G' of MySQL client
+	FormatVertical Format = "vertical"
+	// FormatCSV prints rows as CSV with header
+	FormatCSV Format = "csv"
+	// FormatTSV prints rows as TSV with header
+	FormatTSV Format = "tsv"
+	// FormatJSON prints rows as JSON array of objects keyed by column name
+	FormatJSON Format = "json"
+)
+
+// Formats returns all supported output formats
+func Formats() []Format {
+	return []Format{FormatTable, FormatVertical, FormatCSV, FormatTSV, FormatJSON}
+}
+
+// ParseFormat returns Format by name. If name is empty, returns FormatTable
+func ParseFormat(name string) (Format, error) {
+	if name == "" {
+		return FormatTable, nil
+	}
+	for _, format := range Formats() {
+		if strings.EqualFold(name, string(format)) {
+			return format, nil
+		}
+	}
+	names := []string{}
+	for _, format := range Formats() {
+		names = append(names, string(format))
+	}
+	return "", errors.Errorf("unknown output format %s. supported formats are %s", name, strings.Join(names, ", "))
+}
+
 // Row store found records
 type Row struct {
 	values []string
@@ -18,6 +60,8 @@ type Printer struct {
 	columns          []string
 	maxColumnLengths []int
 	allRows          []*Row
+	format           Format
+	writer           io.Writer
 }
 
 // NewPrinter creates instance of Printer
@@ -63,38 +107,142 @@ func NewPrinter(multiRows []*sql.Rows) (*Printer, error) {
 		columns:          columns,
 		maxColumnLengths: maxColumnLengths,
 		allRows:          allRows,
+		format:           FormatTable,
+		writer:           os.Stdout,
 	}, nil
 }
 
+// SetFormat set output format of Print
+func (p *Printer) SetFormat(format Format) {
+	p.format = format
+}
+
+// SetWriter set destination of Print instead of standard output ( e.g. file for exporting results )
+func (p *Printer) SetWriter(w io.Writer) {
+	p.writer = w
+}
+
 // Print print to console found rows
 func (p *Printer) Print() {
-	p.printRowDelimiter()
+	p.Fprint(p.writer)
+}
+
+// Fprint writes found rows to w by output format
+func (p *Printer) Fprint(w io.Writer) error {
+	switch p.format {
+	case FormatTable, "":
+		return errors.WithStack(p.printTable(w))
+	case FormatVertical:
+		return errors.WithStack(p.printVertical(w))
+	case FormatCSV:
+		return errors.WithStack(p.printCSV(w, ','))
+	case FormatTSV:
+		return errors.WithStack(p.printCSV(w, '\t'))
+	case FormatJSON:
+		return errors.WithStack(p.printJSON(w))
+	}
+	return errors.Errorf("unknown output format %s", p.format)
+}
+
+func (p *Printer) printTable(w io.Writer) error {
+	var b strings.Builder
+	p.printRowDelimiter(&b)
 	for idx, column := range p.columns {
-		fmt.Print("|")
-		p.printColumn(idx, column)
+		b.WriteString("|")
+		p.printColumn(&b, idx, column)
 	}
-	fmt.Println("|")
-	p.printRowDelimiter()
+	b.WriteString("|\n")
+	p.printRowDelimiter(&b)
 	for _, row := range p.allRows {
 		for idx, value := range row.values {
-			fmt.Print("|")
-			p.printColumn(idx, value)
+			b.WriteString("|")
+			p.printColumn(&b, idx, value)
 		}
-		fmt.Println("|")
-		p.printRowDelimiter()
+		b.WriteString("|\n")
+		p.printRowDelimiter(&b)
 	}
+	_, err := io.WriteString(w, b.String())
+	return errors.WithStack(err)
 }
 
-func (p *Printer) printRowDelimiter() {
+func (p *Printer) printRowDelimiter(b *strings.Builder) {
 	for idx := range p.columns {
-		fmt.Print("+")
-		fmt.Print(strings.Repeat("-", p.maxColumnLengths[idx]+2))
+		b.WriteString("+")
+		b.WriteString(strings.Repeat("-", p.maxColumnLengths[idx]+2))
 	}
-	fmt.Println("+")
+	b.WriteString("+\n")
 }
 
-func (p *Printer) printColumn(idx int, value string) {
+func (p *Printer) printColumn(b *strings.Builder, idx int, value string) {
 	maxLength := p.maxColumnLengths[idx]
 	length := maxLength - len(value) + 1
-	fmt.Print(" " + value + strings.Repeat(" ", length))
+	b.WriteString(" " + value + strings.Repeat(" ", length))
+}
+
+func (p *Printer) printVertical(w io.Writer) error {
+	maxLength := 0
+	for _, column := range p.columns {
+		if maxLength < len(column) {
+			maxLength = len(column)
+		}
+	}
+	var b strings.Builder
+	for rowIdx, row := range p.allRows {
+		fmt.Fprintf(&b, "%s %d. row %s\n", strings.Repeat("*", 27), rowIdx+1, strings.Repeat("*", 27))
+		for idx, value := range row.values {
+			fmt.Fprintf(&b, "%*s: %s\n", maxLength, p.columns[idx], value)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return errors.WithStack(err)
+}
+
+func (p *Printer) printCSV(w io.Writer, comma rune) error {
+	writer := csv.NewWriter(w)
+	writer.Comma = comma
+	if err := writer.Write(p.columns); err != nil {
+		return errors.WithStack(err)
+	}
+	for _, row := range p.allRows {
+		if err := writer.Write(row.values); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	writer.Flush()
+	return errors.WithStack(writer.Error())
+}
+
+// printJSON writes rows as JSON array. keys of each object are ordered by columns
+func (p *Printer) printJSON(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("[")
+	for rowIdx, row := range p.allRows {
+		if rowIdx > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n  {")
+		for idx, value := range row.values {
+			if idx > 0 {
+				b.WriteString(", ")
+			}
+			key, err := json.Marshal(p.columns[idx])
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			val, err := json.Marshal(value)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			b.Write(key)
+			b.WriteString(": ")
+			b.Write(val)
+		}
+		b.WriteString("}")
+	}
+	if len(p.allRows) > 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString("]\n")
+	_, err := io.WriteString(w, b.String())
+	return errors.WithStack(err)
 }
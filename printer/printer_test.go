@@ -0,0 +1,96 @@
+package printer
+
+import (
+	"bytes"
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newTestPrinter(t *testing.T, db *sql.DB) *Printer {
+	rows, err := db.Query(`SELECT 1 AS id, 'alice' AS name UNION ALL SELECT 2, 'bob, "jr"'`)
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer rows.Close()
+	p, err := NewPrinter([]*sql.Rows{rows})
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	return p
+}
+
+func TestFormats(t *testing.T) {
+	tests := []struct {
+		format   Format
+		expected string
+	}{
+		{
+			format: FormatTable,
+			expected: `+----+-----------+
+| id | name      |
++----+-----------+
+| 1  | alice     |
++----+-----------+
+| 2  | bob, "jr" |
++----+-----------+
+`,
+		},
+		{
+			format: FormatVertical,
+			expected: `*************************** 1. row ***************************
+  id: 1
+name: alice
+*************************** 2. row ***************************
+  id: 2
+name: bob, "jr"
+`,
+		},
+		{
+			format:   FormatCSV,
+			expected: "id,name\n1,alice\n2,\"bob, \"\"jr\"\"\"\n",
+		},
+		{
+			format:   FormatTSV,
+			expected: "id\tname\n1\talice\n2\t\"bob, \"\"jr\"\"\"\n",
+		},
+		{
+			format: FormatJSON,
+			expected: `[
+  {"id": "1", "name": "alice"},
+  {"id": "2", "name": "bob, \"jr\""}
+]
+`,
+		},
+	}
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer db.Close()
+	for _, test := range tests {
+		t.Run(string(test.format), func(t *testing.T) {
+			var buf bytes.Buffer
+			p := newTestPrinter(t, db)
+			p.SetFormat(test.format)
+			p.SetWriter(&buf)
+			p.Print()
+			if buf.String() != test.expected {
+				t.Fatalf("unexpected output:\n%s\nexpected:\n%s", buf.String(), test.expected)
+			}
+		})
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if format, err := ParseFormat(""); err != nil || format != FormatTable {
+		t.Fatalf("default format must be table. but got %s ( %v )", format, err)
+	}
+	if format, err := ParseFormat("JSON"); err != nil || format != FormatJSON {
+		t.Fatalf("cannot parse json format. got %s ( %v )", format, err)
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Fatal("cannot detect unknown format")
+	}
+}
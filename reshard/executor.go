@@ -0,0 +1,177 @@
+package reshard
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/connection"
+	"go.knocknote.io/octillery/debug"
+)
+
+// Result rows moved by Executor
+type Result struct {
+	// table name
+	TableName string
+	// number of moved rows
+	MovedRows int64
+	// number of committed batches
+	Batches int
+}
+
+// Executor moves rows between shards by Plan.
+// Writes to moved rows during execution cannot be followed, so application should stop writing to the table until it is finished.
+type Executor struct {
+	connMgr *connection.DBConnectionManager
+	// number of shard_key values moved by a transaction. if zero, DefaultBatchSize is used
+	BatchSize int
+	// if not nil, called after each batch is committed ( e.g. for progress output )
+	Progress func(move *Move, movedRows int64)
+}
+
+// NewExecutor creates instance of Executor. connMgr must be created by configuration after resharding.
+func NewExecutor(connMgr *connection.DBConnectionManager) *Executor {
+	return &Executor{connMgr: connMgr}
+}
+
+// Execute moves rows of plan in batches. Each batch is inserted to destination shard and deleted from source shard
+// by transaction of each shard. If error occurred, batches committed before it are kept, so Plan can be computed again for resuming.
+func (e *Executor) Execute(ctx context.Context, plan *Plan) (*Result, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	conn, err := e.connMgr.ConnectionByTableName(plan.TableName)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	batchSize := e.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	result := &Result{TableName: plan.TableName}
+	for _, move := range plan.Moves {
+		from := conn.ShardConnections.ShardConnectionByName(move.From)
+		to := conn.ShardConnections.ShardConnectionByName(move.To)
+		if from == nil || to == nil {
+			return result, errors.Errorf("cannot find shards %s and %s of %s", move.From, move.To, plan.TableName)
+		}
+		var movedRows int64
+		for start := 0; start < len(move.ShardKeys); start += batchSize {
+			if err := ctx.Err(); err != nil {
+				return result, errors.Wrapf(err, "cancelled before moving rows from %s to %s", move.From, move.To)
+			}
+			end := start + batchSize
+			if end > len(move.ShardKeys) {
+				end = len(move.ShardKeys)
+			}
+			rows, err := e.moveBatch(ctx, plan, from, to, move.ShardKeys[start:end])
+			if err != nil {
+				return result, errors.Wrapf(err, "cannot move rows from %s to %s", move.From, move.To)
+			}
+			movedRows += rows
+			result.MovedRows += rows
+			result.Batches++
+			if e.Progress != nil {
+				e.Progress(move, movedRows)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (e *Executor) moveBatch(ctx context.Context, plan *Plan, from, to *connection.DBShardConnection, shardKeys []int64) (int64, error) {
+	args := make([]interface{}, len(shardKeys))
+	for idx, shardKey := range shardKeys {
+		args[idx] = shardKey
+	}
+	where := fmt.Sprintf("%s IN (%s)", plan.ShardKeyColumn, strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", "))
+
+	fromTx, err := from.Connection.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	defer fromTx.Rollback()
+	toTx, err := to.Connection.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	defer toTx.Rollback()
+
+	selectQuery := fmt.Sprintf("SELECT * FROM %s WHERE %s", plan.TableName, where)
+	debug.Printf("(DB:%s):%s", from.ShardName, selectQuery)
+	rows, err := fromTx.QueryContext(ctx, selectQuery, args...)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	columns, values, err := scanRows(rows)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	insertQuery := fmt.Sprintf("INSERT INTO %s(%s) VALUES (%s)",
+		plan.TableName, strings.Join(columns, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "))
+	debug.Printf("(DB:%s):%s", to.ShardName, insertQuery)
+	for _, row := range values {
+		if _, err := toTx.ExecContext(ctx, insertQuery, row...); err != nil {
+			return 0, errors.Wrapf(err, "cannot insert row to %s", to.ShardName)
+		}
+	}
+	deleteQuery := fmt.Sprintf("DELETE FROM %s WHERE %s", plan.TableName, where)
+	debug.Printf("(DB:%s):%s", from.ShardName, deleteQuery)
+	result, err := fromTx.ExecContext(ctx, deleteQuery, args...)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	deletedRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if deletedRows != int64(len(values)) {
+		return 0, errors.Errorf("rows are changed during resharding. selected %d rows but deleted %d rows", len(values), deletedRows)
+	}
+	if err := toTx.Commit(); err != nil {
+		return 0, errors.Wrapf(err, "cannot commit to %s", to.ShardName)
+	}
+	if err := fromTx.Commit(); err != nil {
+		return 0, errors.Wrapf(err, "cannot commit to %s. rows of %s = %v are duplicated in %s", from.ShardName, plan.ShardKeyColumn, shardKeys, to.ShardName)
+	}
+	return deletedRows, nil
+}
+
+// scanRows reads all columns of rows as they are, so they can be passed to INSERT query as arguments
+func scanRows(rows *sql.Rows) ([]string, [][]interface{}, error) {
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	values := [][]interface{}{}
+	for rows.Next() {
+		row := make([]interface{}, len(columns))
+		dest := make([]interface{}, len(columns))
+		for idx := range row {
+			dest[idx] = &row[idx]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		values = append(values, row)
+	}
+	return columns, values, errors.WithStack(rows.Err())
+}
+
+// Verify computes plan again, and returns error if there are rows not moved yet or number of rows is changed from plan.
+func (e *Executor) Verify(ctx context.Context, plan *Plan) error {
+	current, err := NewPlanner(e.connMgr).Plan(ctx, plan.TableName)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if moved := current.MovedRows(); moved > 0 {
+		return errors.Errorf("%d rows of %s are not placed on their shards yet", moved, plan.TableName)
+	}
+	if current.TotalRows() != plan.TotalRows() {
+		return errors.Errorf("number of rows of %s is changed from %d to %d", plan.TableName, plan.TotalRows(), current.TotalRows())
+	}
+	return nil
+}
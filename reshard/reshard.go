@@ -0,0 +1,164 @@
+// Package reshard moves rows between shards after shards of sharded table are changed in configuration ( e.g. a new shard is added ).
+//
+// Configuration must already describe new shards and tables must exist in them ( e.g. by 'octillery migrate' ).
+// Planner finds rows placed on a shard different from the one decided by sharding algorithm of the configuration,
+// and Executor moves them in batches by transaction of each shard.
+//
+//	mgr, _ := connection.NewConnectionManager()
+//	plan, _ := reshard.NewPlanner(mgr).Plan(ctx, "users")
+//	result, _ := reshard.NewExecutor(mgr).Execute(ctx, plan)
+package reshard
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/connection"
+	"go.knocknote.io/octillery/debug"
+	"go.knocknote.io/octillery/exec"
+)
+
+// DefaultBatchSize default number of shard_key values moved by a transaction
+const DefaultBatchSize = 100
+
+// Move rows moved from a shard to another shard
+type Move struct {
+	// source shard name
+	From string
+	// destination shard name
+	To string
+	// shard_key values of moved rows
+	ShardKeys []int64
+	// number of moved rows
+	Rows int64
+}
+
+// Plan rows of a table moved by resharding
+type Plan struct {
+	// table name
+	TableName string
+	// column name of shard_key
+	ShardKeyColumn string
+	// moves ordered by source and destination shard names
+	Moves []*Move
+	// number of rows in each shard before resharding
+	RowCounts map[string]int64
+}
+
+// TotalRows returns number of rows of all shards
+func (p *Plan) TotalRows() int64 {
+	var total int64
+	for _, count := range p.RowCounts {
+		total += count
+	}
+	return total
+}
+
+// MovedRows returns number of rows moved by plan
+func (p *Plan) MovedRows() int64 {
+	var moved int64
+	for _, move := range p.Moves {
+		moved += move.Rows
+	}
+	return moved
+}
+
+func (p *Plan) String() string {
+	lines := []string{fmt.Sprintf("%s: %d of %d rows are moved", p.TableName, p.MovedRows(), p.TotalRows())}
+	for _, move := range p.Moves {
+		lines = append(lines, fmt.Sprintf("  %s -> %s: %d rows ( %d keys )", move.From, move.To, move.Rows, len(move.ShardKeys)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Planner computes rows moved by resharding
+type Planner struct {
+	connMgr *connection.DBConnectionManager
+}
+
+// NewPlanner creates instance of Planner. connMgr must be created by configuration after resharding.
+func NewPlanner(connMgr *connection.DBConnectionManager) *Planner {
+	return &Planner{connMgr: connMgr}
+}
+
+func shardKeyColumn(tableName string) (string, error) {
+	cfg, err := config.Get()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if !cfg.IsShardTable(tableName) {
+		return "", errors.Errorf("%s is not sharded table", tableName)
+	}
+	return cfg.ShardKeyColumnName(tableName), nil
+}
+
+// Plan scans shard_key values of all shards, and returns rows whose shard decided by sharding algorithm is different from current shard.
+func (p *Planner) Plan(ctx context.Context, tableName string) (*Plan, error) {
+	column, err := shardKeyColumn(tableName)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	conn, err := p.connMgr.ConnectionByTableName(tableName)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	plan := &Plan{TableName: tableName, ShardKeyColumn: column, RowCounts: map[string]int64{}}
+	moves := map[string]*Move{}
+	var mu sync.Mutex
+	query := fmt.Sprintf("SELECT %s, COUNT(*) FROM %s GROUP BY %s", column, tableName, column)
+	if err := exec.FanOut(ctx, conn, func(ctx context.Context, shard *connection.DBShardConnection) error {
+		debug.Printf("(DB:%s):%s", shard.ShardName, query)
+		rows, err := shard.Connection.QueryContext(ctx, query)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var (
+				shardKey sql.NullInt64
+				count    int64
+			)
+			if err := rows.Scan(&shardKey, &count); err != nil {
+				return errors.WithStack(err)
+			}
+			if !shardKey.Valid {
+				return errors.Errorf("cannot decide shard of %d rows. %s is NULL", count, column)
+			}
+			dest, err := conn.ShardConnectionByID(shardKey.Int64)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			mu.Lock()
+			plan.RowCounts[shard.ShardName] += count
+			if dest.ShardName != shard.ShardName {
+				key := shard.ShardName + "\x00" + dest.ShardName
+				if _, exists := moves[key]; !exists {
+					moves[key] = &Move{From: shard.ShardName, To: dest.ShardName}
+				}
+				moves[key].ShardKeys = append(moves[key].ShardKeys, shardKey.Int64)
+				moves[key].Rows += count
+			}
+			mu.Unlock()
+		}
+		return errors.WithStack(rows.Err())
+	}); err != nil {
+		return nil, err
+	}
+	for _, move := range moves {
+		sort.Slice(move.ShardKeys, func(i, j int) bool { return move.ShardKeys[i] < move.ShardKeys[j] })
+		plan.Moves = append(plan.Moves, move)
+	}
+	sort.Slice(plan.Moves, func(i, j int) bool {
+		if plan.Moves[i].From != plan.Moves[j].From {
+			return plan.Moves[i].From < plan.Moves[j].From
+		}
+		return plan.Moves[i].To < plan.Moves[j].To
+	})
+	return plan, nil
+}
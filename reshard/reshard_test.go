@@ -0,0 +1,131 @@
+package reshard
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/connection"
+	_ "go.knocknote.io/octillery/connection/adapter/plugin"
+)
+
+const testConfig = `
+tables:
+  users:
+    shard: true
+    shard_key: user_id
+    shards:
+%[2]s
+`
+
+const testShardConfig = `      - user_shard_%[2]d:
+          adapter: sqlite3
+          database: %[1]s/user_shard_%[2]d.bin
+`
+
+func checkErr(t *testing.T, err error) {
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+// loadConfig loads configuration that has shardNum shards and creates connection manager by it
+func loadConfig(t *testing.T, dir string, shardNum int) *connection.DBConnectionManager {
+	shards := ""
+	for i := 1; i <= shardNum; i++ {
+		shards += fmt.Sprintf(testShardConfig, dir, i)
+	}
+	confPath := filepath.Join(dir, "databases.yml")
+	checkErr(t, ioutil.WriteFile(confPath, []byte(fmt.Sprintf(testConfig, dir, shards)), 0644))
+	cfg, err := config.Load(confPath)
+	checkErr(t, err)
+	checkErr(t, connection.SetConfig(cfg))
+	mgr, err := connection.NewConnectionManager()
+	checkErr(t, err)
+	checkErr(t, mgr.ForEachShard("users", func(shard *connection.DBShardConnection) error {
+		_, err := shard.Connection.Exec("create table if not exists users (id integer primary key, user_id integer not null, name varchar(255))")
+		return err
+	}, nil))
+	return mgr
+}
+
+func TestReshard(t *testing.T) {
+	dir, err := ioutil.TempDir("", "octillery_reshard")
+	checkErr(t, err)
+	defer os.RemoveAll(dir)
+
+	mgr := loadConfig(t, dir, 2)
+	conn, err := mgr.ConnectionByTableName("users")
+	checkErr(t, err)
+	for userID := int64(1); userID <= 12; userID++ {
+		shard, err := conn.ShardConnectionByID(userID)
+		checkErr(t, err)
+		for i := 0; i < 2; i++ {
+			// id is unique across shards as it is generated by sequencer
+			_, err := shard.Connection.Exec("insert into users(id, user_id, name) values (?, ?, ?)", userID*10+int64(i), userID, fmt.Sprintf("user%d", userID))
+			checkErr(t, err)
+		}
+	}
+	checkErr(t, mgr.Close())
+
+	// add user_shard_3
+	mgr = loadConfig(t, dir, 3)
+	defer mgr.Close()
+	conn, err = mgr.ConnectionByTableName("users")
+	checkErr(t, err)
+
+	expectedRows := int64(0)
+	for userID := int64(1); userID <= 12; userID++ {
+		// shard decided by modulo of 2 shards and 3 shards
+		if userID%2 != userID%3 {
+			expectedRows += 2
+		}
+	}
+	plan, err := NewPlanner(mgr).Plan(context.Background(), "users")
+	checkErr(t, err)
+	if plan.TotalRows() != 24 || plan.MovedRows() != expectedRows {
+		t.Fatalf("invalid plan\n%s", plan)
+	}
+	for _, move := range plan.Moves {
+		for _, userID := range move.ShardKeys {
+			shard, err := conn.ShardConnectionByID(userID)
+			checkErr(t, err)
+			if shard.ShardName != move.To {
+				t.Fatalf("user_id %d must be moved to %s. but planned to %s", userID, shard.ShardName, move.To)
+			}
+		}
+	}
+	if _, err := NewPlanner(mgr).Plan(context.Background(), "invalid_table"); err == nil {
+		t.Fatal("cannot handle invalid table")
+	}
+
+	executor := NewExecutor(mgr)
+	executor.BatchSize = 2
+	batches := 0
+	executor.Progress = func(*Move, int64) { batches++ }
+	result, err := executor.Execute(context.Background(), plan)
+	checkErr(t, err)
+	if result.MovedRows != expectedRows || result.Batches != batches {
+		t.Fatalf("invalid result %+v", result)
+	}
+	checkErr(t, executor.Verify(context.Background(), plan))
+	for userID := int64(1); userID <= 12; userID++ {
+		shard, err := conn.ShardConnectionByID(userID)
+		checkErr(t, err)
+		var count int
+		checkErr(t, shard.Connection.QueryRow("select count(*) from users where user_id = ?", userID).Scan(&count))
+		if count != 2 {
+			t.Fatalf("rows of user_id %d are not found in %s", userID, shard.ShardName)
+		}
+	}
+	if _, err := conn.ShardConnections.ShardConnectionByName("user_shard_1").Connection.Exec("delete from users where user_id = 6"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := executor.Verify(context.Background(), plan); err == nil {
+		t.Fatal("cannot verify number of rows")
+	}
+}
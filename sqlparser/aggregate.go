@@ -0,0 +1,83 @@
+package sqlparser
+
+import (
+	"strings"
+
+	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+)
+
+// AggregateFunc the type of aggregate function ( COUNT, SUM, MIN, MAX, AVG )
+type AggregateFunc int
+
+const (
+	// AggregateCount 'COUNT' function
+	AggregateCount AggregateFunc = iota + 1
+	// AggregateSum 'SUM' function
+	AggregateSum
+	// AggregateMin 'MIN' function
+	AggregateMin
+	// AggregateMax 'MAX' function
+	AggregateMax
+	// AggregateAvg 'AVG' function
+	AggregateAvg
+)
+
+var aggregateFuncs = map[string]AggregateFunc{
+	"count": AggregateCount,
+	"sum":   AggregateSum,
+	"min":   AggregateMin,
+	"max":   AggregateMax,
+	"avg":   AggregateAvg,
+}
+
+func (f AggregateFunc) String() string {
+	for name, fn := range aggregateFuncs {
+		if fn == f {
+			return strings.ToUpper(name)
+		}
+	}
+	return ""
+}
+
+// AggregateColumn a column of SELECT query calls aggregate function
+type AggregateColumn struct {
+	// aggregate function
+	Func AggregateFunc
+	// alias of column. empty if not specified
+	Alias string
+	// function call expression ( e.g. 'count(*)' )
+	Expr *vtparser.FuncExpr
+}
+
+// Aggregates returns columns of SELECT query if all of them are aggregate functions that results of shards can be merged.
+// If query has column without aggregate function, GROUP BY clause, HAVING clause or DISTINCT, returns nil.
+func (q *QueryBase) Aggregates() []*AggregateColumn {
+	if q.Type != Select {
+		return nil
+	}
+	stmt, ok := q.Stmt.(*vtparser.Select)
+	if !ok || len(stmt.GroupBy) > 0 || stmt.Having != nil || stmt.Distinct != "" {
+		return nil
+	}
+	columns := make([]*AggregateColumn, 0, len(stmt.SelectExprs))
+	for _, selectExpr := range stmt.SelectExprs {
+		expr, ok := selectExpr.(*vtparser.AliasedExpr)
+		if !ok {
+			return nil
+		}
+		funcExpr, ok := expr.Expr.(*vtparser.FuncExpr)
+		if !ok || funcExpr.Distinct || !funcExpr.Qualifier.IsEmpty() {
+			return nil
+		}
+		fn, exists := aggregateFuncs[funcExpr.Name.Lowered()]
+		if !exists {
+			return nil
+		}
+		columns = append(columns, &AggregateColumn{
+			Func:  fn,
+			Alias: expr.As.String(),
+			Expr:  funcExpr,
+		})
+	}
+	return columns
+}
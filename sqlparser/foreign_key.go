@@ -0,0 +1,42 @@
+package sqlparser
+
+import (
+	"regexp"
+	"strings"
+)
+
+var foreignKeyPattern = regexp.MustCompile("(?is)FOREIGN\\s+KEY\\s*(?:`?\\w+`?\\s*)?\\(([^)]+)\\)\\s*REFERENCES\\s+`?(\\w+)`?\\s*\\(([^)]+)\\)")
+
+// ForeignKey the definition of FOREIGN KEY constraint in CREATE TABLE statement.
+type ForeignKey struct {
+	Table             string
+	Columns           []string
+	ReferencedTable   string
+	ReferencedColumns []string
+}
+
+// ForeignKeys returns FOREIGN KEY constraints defined in CREATE TABLE statement.
+// vitess-sqlparser doesn't keep referenced table in AST, so they are extracted from query text.
+func (q *QueryBase) ForeignKeys() []*ForeignKey {
+	if q.Type != CreateTable {
+		return nil
+	}
+	foreignKeys := []*ForeignKey{}
+	for _, matched := range foreignKeyPattern.FindAllStringSubmatch(q.Text, -1) {
+		foreignKeys = append(foreignKeys, &ForeignKey{
+			Table:             q.TableName,
+			Columns:           splitColumnNames(matched[1]),
+			ReferencedTable:   matched[2],
+			ReferencedColumns: splitColumnNames(matched[3]),
+		})
+	}
+	return foreignKeys
+}
+
+func splitColumnNames(text string) []string {
+	columns := []string{}
+	for _, column := range strings.Split(text, ",") {
+		columns = append(columns, strings.Trim(column, " \t\n`\""))
+	}
+	return columns
+}
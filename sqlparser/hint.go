@@ -0,0 +1,133 @@
+package sqlparser
+
+import (
+	"strconv"
+	"strings"
+
+	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/debug"
+)
+
+const (
+	// shardHintPrefix prefix of comment that gives shard key of query ( e.g. '/* octillery:shard_key=123 */' )
+	shardHintPrefix = "octillery:"
+
+	// shardKeyHintName name of hint that gives shard key
+	shardKeyHintName = "shard_key"
+)
+
+// parseShardKeyHint returns shard key given by magic comment like '/* octillery:shard_key=123 */'.
+// Comments in quoted text are ignored. Unknown hint or value that is not integer returns error.
+func parseShardKeyHint(queryText string) (*Identifier, error) {
+	var hint *Identifier
+	for _, comment := range blockComments(queryText) {
+		comment = strings.TrimSpace(comment)
+		if !strings.HasPrefix(comment, shardHintPrefix) {
+			continue
+		}
+		fields := strings.FieldsFunc(strings.TrimPrefix(comment, shardHintPrefix), func(c rune) bool {
+			return c == ',' || isSpace(byte(c))
+		})
+		if len(fields) == 0 {
+			return nil, errors.Errorf("invalid hint '%s'. hint must be like '%sshard_key=123'", comment, shardHintPrefix)
+		}
+		for _, field := range fields {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				return nil, errors.Errorf("invalid hint '%s'. hint must be like '%sshard_key=123'", field, shardHintPrefix)
+			}
+			if kv[0] != shardKeyHintName {
+				return nil, errors.Errorf("unknown hint %s", kv[0])
+			}
+			id, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil || id < 0 {
+				return nil, errors.Errorf("invalid shard_key hint '%s'. it must be non-negative integer", kv[1])
+			}
+			if hint != nil && *hint != Identifier(id) {
+				return nil, errors.Errorf("query has different shard_key hints %d and %d", *hint, id)
+			}
+			shardKeyID := Identifier(id)
+			hint = &shardKeyID
+		}
+	}
+	return hint, nil
+}
+
+// blockComments returns text of block comments ( /* ... */ ) except in quoted text
+func blockComments(queryText string) []string {
+	comments := []string{}
+	var quote byte
+	for i := 0; i < len(queryText); i++ {
+		c := queryText[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '-' && strings.HasPrefix(queryText[i:], "--"):
+			if end := strings.IndexByte(queryText[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(queryText)
+			}
+		case c == '/' && strings.HasPrefix(queryText[i:], "/*"):
+			end := strings.Index(queryText[i+2:], "*/")
+			if end < 0 {
+				return comments
+			}
+			comments = append(comments, queryText[i+2:i+2+end])
+			i += end + 3
+		}
+	}
+	return comments
+}
+
+// parseWhereWithShardKeyHint parses WHERE clause that may be too complex to find shard key ( e.g. generated by ORM ).
+// Shard key of hint is used instead, but if shard key found in WHERE clause is different from it, returns error.
+func (p *Parser) parseWhereWithShardKeyHint(where *vtparser.Where, queryBase *QueryBase) error {
+	parsed := *queryBase
+	if err := p.parseExpr(where.Expr, &parsed); err != nil {
+		debug.Printf("shard_key hint is used because WHERE clause cannot be parsed: %s", err)
+	} else {
+		*queryBase = parsed
+	}
+	return errors.WithStack(p.applyShardKeyHint(queryBase))
+}
+
+// applyShardKeyHint sets shard key of hint to queryBase
+func (p *Parser) applyShardKeyHint(queryBase *QueryBase) error {
+	hint := *queryBase.shardKeyHint
+	if queryBase.ShardKeyID != UnknownID && queryBase.ShardKeyID != hint {
+		return errors.Errorf("shard_key hint %d conflicts with shard_key %d in query", hint, queryBase.ShardKeyID)
+	}
+	queryBase.ShardKeyID = hint
+	return nil
+}
+
+// applyShardKeyHintToQuery validates query given shard key by hint, and sets it to query
+func (p *Parser) applyShardKeyHintToQuery(query Query) error {
+	if !p.cfg.IsShardTable(query.Table()) {
+		return errors.Errorf("shard_key hint is not available for %s. it is not sharded table", query.Table())
+	}
+	switch q := query.(type) {
+	case *InsertQuery:
+		return errors.New("shard_key hint is not available for INSERT query. shard_key column must be included in VALUES")
+	case *DeleteQuery:
+		if err := p.applyShardKeyHint(q.QueryBase); err != nil {
+			return errors.WithStack(err)
+		}
+		q.setStateAfterParsing()
+		return nil
+	case *QueryBase:
+		if q.Type != Select && q.Type != Update {
+			return errors.Errorf("shard_key hint is not available for %s query", q.Type)
+		}
+		return errors.WithStack(p.applyShardKeyHint(q))
+	}
+	return errors.Errorf("shard_key hint is not available for %s query", query.QueryType())
+}
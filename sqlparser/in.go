@@ -0,0 +1,122 @@
+package sqlparser
+
+import (
+	"strconv"
+	"strings"
+
+	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/pkg/errors"
+)
+
+// shardKeyInExpr IN expression for shard_key column ( e.g. 'user_id IN (1, 2, 3)' )
+type shardKeyInExpr struct {
+	expr *vtparser.ComparisonExpr
+	// shard_key id of each value of IN clause
+	ids []Identifier
+}
+
+// parseInExpr collects shard_key ids from IN clause.
+// If some values cannot be decided before execution ( e.g. subquery ), query is executed for all shards.
+func (p *Parser) parseInExpr(expr *vtparser.ComparisonExpr, queryBase *QueryBase) error {
+	tuple, ok := expr.Right.(vtparser.ValTuple)
+	if !ok {
+		return nil
+	}
+	ids := make([]Identifier, 0, len(tuple))
+	uniqueIDs := []Identifier{}
+	found := map[Identifier]bool{}
+	for _, valExpr := range tuple {
+		val, ok := valExpr.(*vtparser.SQLVal)
+		if !ok {
+			return nil
+		}
+		valQuery := &QueryBase{Args: queryBase.Args, ShardKeyID: UnknownID}
+		if err := p.parseVal(val, valQuery); err != nil {
+			return errors.WithStack(err)
+		}
+		if valQuery.IsNotFoundShardKeyID() {
+			return nil
+		}
+		id := valQuery.ShardKeyID
+		ids = append(ids, id)
+		if !found[id] {
+			found[id] = true
+			uniqueIDs = append(uniqueIDs, id)
+		}
+	}
+	if len(uniqueIDs) == 0 {
+		return nil
+	}
+	if len(uniqueIDs) == 1 {
+		queryBase.ShardKeyID = uniqueIDs[0]
+		return nil
+	}
+	queryBase.ShardKeyIDs = uniqueIDs
+	queryBase.shardKeyIn = &shardKeyInExpr{expr: expr, ids: ids}
+	return nil
+}
+
+// NarrowShardKeyIDs returns query whose IN clause for shard_key column has only values of specified ids.
+// This is used to execute query for shard that has rows of ids.
+func (q *QueryBase) NarrowShardKeyIDs(ids []Identifier) (*QueryBase, error) {
+	if q.shardKeyIn == nil {
+		return nil, errors.New("query doesn't have IN clause for shard_key column")
+	}
+	included := map[Identifier]bool{}
+	for _, id := range ids {
+		included[id] = true
+	}
+	tuple := q.shardKeyIn.expr.Right.(vtparser.ValTuple)
+	narrowedTuple := vtparser.ValTuple{}
+	removedArgIndexes := map[int]bool{}
+	for idx, valExpr := range tuple {
+		if included[q.shardKeyIn.ids[idx]] {
+			narrowedTuple = append(narrowedTuple, valExpr)
+			continue
+		}
+		val := valExpr.(*vtparser.SQLVal)
+		if val.Type != vtparser.ValArg {
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimPrefix(string(val.Val), ":v"))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		removedArgIndexes[index-1] = true
+	}
+	if len(narrowedTuple) == 0 {
+		return nil, errors.Errorf("cannot find values of ids %v in IN clause", ids)
+	}
+
+	// replace IN clause temporarily to format narrowed query
+	q.shardKeyIn.expr.Right = narrowedTuple
+	text := StringWithPlaceholder(q.Stmt)
+	q.shardKeyIn.expr.Right = tuple
+
+	stmt, err := vtparser.Parse(text)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if q.Lock != "" {
+		text = text + " " + q.Lock
+	}
+	args := make([]interface{}, 0, len(q.Args))
+	for idx, arg := range q.Args {
+		if !removedArgIndexes[idx] {
+			args = append(args, arg)
+		}
+	}
+	return &QueryBase{
+		Text:              text,
+		Args:              args,
+		Type:              q.Type,
+		TableName:         q.TableName,
+		ShardKeyID:        UnknownID,
+		Stmt:              stmt,
+		Returning:         q.Returning,
+		JoinTableNames:    q.JoinTableNames,
+		ShardKeyIDs:       ids,
+		DollarPlaceholder: q.DollarPlaceholder,
+		Lock:              q.Lock,
+	}, nil
+}
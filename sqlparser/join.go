@@ -0,0 +1,244 @@
+package sqlparser
+
+import (
+	"reflect"
+
+	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+)
+
+// joinedTable the table referenced by FROM clause of JOIN query
+type joinedTable struct {
+	name  string
+	alias string
+}
+
+// collectJoinedTables collects tables and ON conditions from FROM clause
+func (p *Parser) collectJoinedTables(tableExpr vtparser.TableExpr, tables []*joinedTable, conds []vtparser.Expr) ([]*joinedTable, []vtparser.Expr, error) {
+	switch expr := tableExpr.(type) {
+	case *vtparser.AliasedTableExpr:
+		tableName, ok := expr.Expr.(vtparser.TableName)
+		if !ok {
+			if _, isSubquery := expr.Expr.(*vtparser.Subquery); isSubquery {
+				return nil, nil, errors.New("parse error. subquery does not supported")
+			}
+			return nil, nil, errors.Errorf("parse error. expr '%s' does not supported", reflect.TypeOf(expr.Expr))
+		}
+		alias := expr.As.String()
+		if alias == "" {
+			alias = tableName.Name.String()
+		}
+		return append(tables, &joinedTable{name: tableName.Name.String(), alias: alias}), conds, nil
+	case *vtparser.ParenTableExpr:
+		var err error
+		for _, e := range expr.Exprs {
+			if tables, conds, err = p.collectJoinedTables(e, tables, conds); err != nil {
+				return nil, nil, errors.WithStack(err)
+			}
+		}
+		return tables, conds, nil
+	case *vtparser.JoinTableExpr:
+		var err error
+		if tables, conds, err = p.collectJoinedTables(expr.LeftExpr, tables, conds); err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		if tables, conds, err = p.collectJoinedTables(expr.RightExpr, tables, conds); err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		if expr.On != nil {
+			conds = append(conds, expr.On)
+		}
+		return tables, conds, nil
+	default:
+	}
+	return nil, nil, errors.Errorf("parse error. expr '%s' does not supported", reflect.TypeOf(tableExpr))
+}
+
+// parseJoinStmt parses SELECT query joins multiple tables.
+// Sharded tables can be joined only if they are placed on the same shards and compared by shard_key each other,
+// because JOIN is executed on each shard and results are merged.
+func (p *Parser) parseJoinStmt(stmt *vtparser.Select, tables []*joinedTable, conds []vtparser.Expr, queryBase *QueryBase) error {
+	mainTable := tables[0]
+	queryBase.TableName = mainTable.name
+	p.tableAliases = map[string]string{}
+	for _, table := range tables {
+		if _, exists := p.tableAliases[table.alias]; exists {
+			return errors.Errorf("parse error. table alias %s is not unique", table.alias)
+		}
+		p.tableAliases[table.alias] = table.name
+		if table.name != mainTable.name && !containsString(queryBase.JoinTableNames, table.name) {
+			queryBase.JoinTableNames = append(queryBase.JoinTableNames, table.name)
+		}
+	}
+	isShard := p.cfg.IsShardTable(mainTable.name)
+	for _, tableName := range queryBase.JoinTableNames {
+		if p.cfg.IsShardTable(tableName) != isShard {
+			return errors.Errorf("parse error. cannot join sharded table and not sharded table ( %s and %s )", mainTable.name, tableName)
+		}
+		if !p.isSamePlacement(mainTable.name, tableName) {
+			return errors.Errorf("parse error. cannot join %s and %s placed on different databases", mainTable.name, tableName)
+		}
+	}
+	if !isShard {
+		return nil
+	}
+	if stmt.Where != nil {
+		conds = append(conds, stmt.Where.Expr)
+	}
+	joined := map[string]bool{mainTable.alias: true}
+	for {
+		found := false
+		for _, cond := range conds {
+			for _, pair := range p.shardKeyPairs(cond, nil) {
+				if joined[pair[0]] != joined[pair[1]] {
+					joined[pair[0]] = true
+					joined[pair[1]] = true
+					found = true
+				}
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	for _, table := range tables {
+		if !joined[table.alias] {
+			return errors.Errorf("parse error. JOIN condition must compare shard_key of %s and %s", mainTable.alias, table.alias)
+		}
+	}
+	if stmt.Where == nil {
+		return nil
+	}
+	return errors.WithStack(p.parseWhere(stmt.Where, queryBase))
+}
+
+// shardKeyPairs returns pairs of table aliases compared by shard_key ( e.g. 'users.id = user_items.user_id' ) in AND conditions
+func (p *Parser) shardKeyPairs(expr vtparser.Expr, pairs [][2]string) [][2]string {
+	switch e := expr.(type) {
+	case *vtparser.AndExpr:
+		return p.shardKeyPairs(e.Right, p.shardKeyPairs(e.Left, pairs))
+	case *vtparser.ParenExpr:
+		return p.shardKeyPairs(e.Expr, pairs)
+	case *vtparser.ComparisonExpr:
+		if e.Operator != vtparser.EqualStr {
+			return pairs
+		}
+		left := p.shardKeyAlias(e.Left)
+		right := p.shardKeyAlias(e.Right)
+		if left != "" && right != "" && left != right {
+			return append(pairs, [2]string{left, right})
+		}
+	default:
+	}
+	return pairs
+}
+
+// shardKeyAlias returns alias of table if expr is qualified shard_key column of the table
+func (p *Parser) shardKeyAlias(expr vtparser.Expr) string {
+	colName, ok := expr.(*vtparser.ColName)
+	if !ok || colName.Qualifier.IsEmpty() {
+		return ""
+	}
+	alias := colName.Qualifier.Name.String()
+	tableName, exists := p.tableAliases[alias]
+	if !exists {
+		return ""
+	}
+	if p.shardKeyColumnName(tableName) != colName.Name.String() {
+		return ""
+	}
+	return alias
+}
+
+// isSamePlacement returns whether all shards ( or database ) of both tables are the same database
+func (p *Parser) isSamePlacement(tableName string, otherTableName string) bool {
+	table := p.cfg.Tables[tableName]
+	other := p.cfg.Tables[otherTableName]
+	if table == nil || other == nil {
+		return false
+	}
+	if !table.IsShard {
+		return isSameDatabase(&table.DatabaseConfig, &other.DatabaseConfig)
+	}
+	if algorithmName(table.Algorithm) != algorithmName(other.Algorithm) || len(table.Shards) != len(other.Shards) {
+		return false
+	}
+	if len(table.AlgorithmConfig) > 0 || len(other.AlgorithmConfig) > 0 {
+		if !reflect.DeepEqual(table.AlgorithmConfig, other.AlgorithmConfig) {
+			return false
+		}
+	}
+	for idx, shard := range table.Shards {
+		otherShard := other.Shards[idx]
+		if len(shard) != 1 || len(otherShard) != 1 {
+			return false
+		}
+		for name, cfg := range shard {
+			for otherName, otherCfg := range otherShard {
+				if !isSameDatabase(cfg, otherCfg) || !isSameSubShards(name, cfg, otherName, otherCfg) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+func isSameDatabase(cfg *config.DatabaseConfig, other *config.DatabaseConfig) bool {
+	return cfg.Adapter == other.Adapter &&
+		cfg.NameOrPath == other.NameOrPath &&
+		reflect.DeepEqual(cfg.Masters, other.Masters)
+}
+
+// isSameSubShards returns whether rows of both shards are distributed to the same sub-shards.
+// If seed of sub-shards is not defined, it depends on shard name.
+func isSameSubShards(name string, cfg *config.DatabaseConfig, otherName string, other *config.DatabaseConfig) bool {
+	if !cfg.IsSplit() && !other.IsSplit() {
+		return true
+	}
+	if len(cfg.SubShards) != len(other.SubShards) || cfg.SubShardAlgorithm != other.SubShardAlgorithm {
+		return false
+	}
+	if !reflect.DeepEqual(cfg.SubShardAlgorithmConfig, other.SubShardAlgorithmConfig) {
+		return false
+	}
+	if _, exists := cfg.SubShardAlgorithmConfig["seed"]; !exists && name != otherName {
+		return false
+	}
+	for idx, subShard := range cfg.SubShards {
+		otherSubShard := other.SubShards[idx]
+		if len(subShard) != 1 || len(otherSubShard) != 1 {
+			return false
+		}
+		for _, subCfg := range subShard {
+			for _, otherSubCfg := range otherSubShard {
+				if !isSameDatabase(subCfg, otherSubCfg) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+func algorithmName(name string) string {
+	if name == "" {
+		return "modulo"
+	}
+	return name
+}
+
+func isAliasedTableExpr(tableExpr vtparser.TableExpr) bool {
+	_, ok := tableExpr.(*vtparser.AliasedTableExpr)
+	return ok
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package sqlparser
+
+import (
+	"regexp"
+	"strings"
+)
+
+// lockClause matches locking clause at the end of SELECT query.
+// 'FOR SHARE', 'NOWAIT' and 'SKIP LOCKED' ( MySQL 8.0 and PostgreSQL ) cannot be parsed by vitess-sqlparser,
+// so all locking clauses are removed from query before parsing.
+var lockClause = regexp.MustCompile(`(?is)\s+((?:for\s+update|for\s+share|lock\s+in\s+share\s+mode)(?:\s+nowait|\s+skip\s+locked)?)\s*;?\s*$`)
+
+// splitLockClause removes locking clause from SELECT query, and returns it normalized to lower case
+func splitLockClause(query string) (string, string) {
+	matches := lockClause.FindStringSubmatchIndex(query)
+	if len(matches) == 0 {
+		return query, ""
+	}
+	lock := strings.Join(strings.Fields(strings.ToLower(query[matches[2]:matches[3]])), " ")
+	return query[:matches[0]], lock
+}
+
+// TextWithLock returns query text whose locking clause is replaced with lock ( e.g. clause of database decided by adapter ).
+// If lock is empty, locking clause is removed from query text.
+func (q *QueryBase) TextWithLock(lock string) string {
+	text, _ := splitLockClause(q.Text)
+	if lock == "" {
+		return text
+	}
+	return text + " " + lock
+}
+
+// IsLockingRead returns whether query is SELECT with locking clause ( e.g. 'SELECT ... FOR UPDATE' ).
+// Locking read must be executed on master, so it is not read from slave even if 'read_from_slave' is enabled.
+func IsLockingRead(query Query) bool {
+	queryBase, ok := query.(*QueryBase)
+	return ok && queryBase.IsLockingRead()
+}
+
+// IsSlaveReadable returns whether query can be executed on slave ( read query except locking read )
+func IsSlaveReadable(query Query) bool {
+	return query.QueryType().IsReadQuery() && !IsLockingRead(query)
+}
@@ -0,0 +1,116 @@
+package sqlparser
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+
+	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/pkg/errors"
+)
+
+// ShardKeyMove rows updated by UPDATE query changing shard_key column, those are moved to the shard of new shard_key value.
+// It is parsed only if 'move_on_shard_key_update' of table is enabled.
+type ShardKeyMove struct {
+	// new value of shard_key column
+	ShardKeyID Identifier
+	// columns assigned by SET clause
+	Columns []string
+	// values assigned to Columns. placeholders are replaced by query arguments
+	Values []interface{}
+	// condition of WHERE clause selects rows to move
+	Where string
+	// arguments of placeholders in Where
+	WhereArgs []interface{}
+}
+
+// ValueByColumn returns value assigned to column by SET clause
+func (m *ShardKeyMove) ValueByColumn(column string) (interface{}, bool) {
+	for idx, col := range m.Columns {
+		if strings.EqualFold(col, column) {
+			return m.Values[idx], true
+		}
+	}
+	return nil, false
+}
+
+func (p *Parser) argByValArg(val *vtparser.SQLVal, args []interface{}) (interface{}, error) {
+	index := p.ValueIndexByValArg(val)
+	if index == 0 || len(args) < index {
+		return nil, errors.Errorf("cannot find argument of placeholder %s", string(val.Val))
+	}
+	return args[index-1], nil
+}
+
+// placeholderArgs returns arguments of placeholders in nodes in order of appearance
+func (p *Parser) placeholderArgs(args []interface{}, nodes ...vtparser.SQLNode) ([]interface{}, error) {
+	placeholderArgs := []interface{}{}
+	if err := vtparser.Walk(func(node vtparser.SQLNode) (bool, error) {
+		val, ok := node.(*vtparser.SQLVal)
+		if !ok || val.Type != vtparser.ValArg {
+			return true, nil
+		}
+		arg, err := p.argByValArg(val, args)
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+		placeholderArgs = append(placeholderArgs, arg)
+		return false, nil
+	}, nodes...); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return placeholderArgs, nil
+}
+
+// parseAssignedValue returns value assigned by SET clause. value must be literal or placeholder
+func (p *Parser) parseAssignedValue(expr vtparser.Expr, args []interface{}) (interface{}, error) {
+	switch valExpr := expr.(type) {
+	case *vtparser.NullVal:
+		return nil, nil
+	case *vtparser.SQLVal:
+		switch valExpr.Type {
+		case vtparser.StrVal:
+			return string(valExpr.Val), nil
+		case vtparser.IntVal:
+			if strings.EqualFold(string(valExpr.Val), "null") {
+				return nil, nil
+			}
+			value, err := strconv.ParseInt(string(valExpr.Val), 10, 64)
+			return value, errors.WithStack(err)
+		case vtparser.FloatVal:
+			value, err := strconv.ParseFloat(string(valExpr.Val), 64)
+			return value, errors.WithStack(err)
+		case vtparser.ValArg:
+			return p.argByValArg(valExpr, args)
+		}
+	}
+	return nil, errors.Errorf("unsupported value %s", reflect.TypeOf(expr))
+}
+
+// parseShardKeyMove parses UPDATE query changing shard_key from queryBase.ShardKeyID to shardKeyID.
+// Rows are moved without evaluating expressions by database, so both shard_key values must be decided by query
+// and the other values of SET clause must be literal or placeholder.
+func (p *Parser) parseShardKeyMove(stmt *vtparser.Update, queryBase *QueryBase, shardKeyID Identifier) (*ShardKeyMove, error) {
+	if queryBase.ShardKeyID == UnknownID || shardKeyID == UnknownID {
+		return nil, errors.Wrap(ErrShardKeyUpdated, "cannot move rows. both current and new values of shard_key must be decided by query")
+	}
+	if stmt.Where == nil || len(stmt.OrderBy) > 0 || stmt.Limit != nil {
+		return nil, errors.Wrap(ErrShardKeyUpdated, "cannot move rows by query with ORDER BY or LIMIT clause")
+	}
+	move := &ShardKeyMove{ShardKeyID: shardKeyID}
+	for _, updateExpr := range stmt.Exprs {
+		value, err := p.parseAssignedValue(updateExpr.Expr, queryBase.Args)
+		if err != nil {
+			return nil, errors.Wrapf(ErrShardKeyUpdated, "cannot move rows. value of %s must be literal or placeholder: %s", updateExpr.Name.Name, err)
+		}
+		move.Columns = append(move.Columns, updateExpr.Name.Name.String())
+		move.Values = append(move.Values, value)
+	}
+	whereArgs, err := p.placeholderArgs(queryBase.Args, stmt.Where.Expr)
+	if err != nil {
+		return nil, errors.Wrap(ErrShardKeyUpdated, err.Error())
+	}
+	move.WhereArgs = whereArgs
+	move.Where = StringWithPlaceholder(stmt.Where.Expr)
+	return move, nil
+}
@@ -0,0 +1,119 @@
+package sqlparser
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// BindNamedArgs replaces named placeholders ( ':name' or '@name' ) in query text to '?'
+// and arranges query arguments in order of placeholders, so sharding can treat them as positional placeholders.
+// Arguments except sql.NamedArg are used by '?' in order.
+// If arguments have no sql.NamedArg, query text and arguments are returned as it is.
+//
+// '@name' is kept if it is not found in named arguments, because it may be user variable of MySQL.
+func BindNamedArgs(queryText string, args []interface{}) (string, []interface{}, error) {
+	namedArgs := map[string]interface{}{}
+	positionalArgs := []interface{}{}
+	for _, arg := range args {
+		if named, ok := arg.(sql.NamedArg); ok && named.Name != "" {
+			namedArgs[named.Name] = named.Value
+			continue
+		}
+		positionalArgs = append(positionalArgs, arg)
+	}
+	if len(namedArgs) == 0 {
+		return queryText, args, nil
+	}
+	var (
+		builder    strings.Builder
+		quote      byte
+		boundArgs  = make([]interface{}, 0, len(args))
+		argIndex   int
+		usedByName = map[string]struct{}{}
+	)
+	for i := 0; i < len(queryText); i++ {
+		c := queryText[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && i+1 < len(queryText) {
+				builder.WriteByte(c)
+				i++
+				c = queryText[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '-' && strings.HasPrefix(queryText[i:], "--"):
+			end := strings.IndexByte(queryText[i:], '\n')
+			if end < 0 {
+				end = len(queryText) - i
+			}
+			builder.WriteString(queryText[i : i+end])
+			i += end - 1
+			continue
+		case c == '/' && strings.HasPrefix(queryText[i:], "/*"):
+			end := strings.Index(queryText[i+2:], "*/")
+			if end < 0 {
+				end = len(queryText) - i
+			} else {
+				end += 4
+			}
+			builder.WriteString(queryText[i : i+end])
+			i += end - 1
+			continue
+		case c == '?':
+			if argIndex >= len(positionalArgs) {
+				return "", nil, errors.Errorf("not enough arguments for %d-th positional placeholder", argIndex+1)
+			}
+			boundArgs = append(boundArgs, positionalArgs[argIndex])
+			argIndex++
+		case c == '@' && i+1 < len(queryText) && queryText[i+1] == '@':
+			// system variable of MySQL ( e.g. '@@autocommit' )
+			builder.WriteString("@@")
+			i++
+			continue
+		case (c == ':' || c == '@') && (i == 0 || queryText[i-1] != ':'):
+			name := placeholderName(queryText[i+1:])
+			if name == "" {
+				break
+			}
+			value, exists := namedArgs[name]
+			if !exists {
+				if c == '@' {
+					break
+				}
+				return "", nil, errors.Errorf("named argument %s is not found", name)
+			}
+			boundArgs = append(boundArgs, value)
+			usedByName[name] = struct{}{}
+			builder.WriteByte('?')
+			i += len(name)
+			continue
+		}
+		builder.WriteByte(c)
+	}
+	if argIndex != len(positionalArgs) {
+		return "", nil, errors.Errorf("query has %d positional placeholders but got %d positional arguments", argIndex, len(positionalArgs))
+	}
+	for name := range namedArgs {
+		if _, exists := usedByName[name]; !exists {
+			return "", nil, errors.Errorf("named argument %s is not used by query", name)
+		}
+	}
+	return builder.String(), boundArgs, nil
+}
+
+// placeholderName returns identifier at the beginning of text
+func placeholderName(text string) string {
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || (i > 0 && isDigit(c)) {
+			continue
+		}
+		return text[:i]
+	}
+	return text
+}
@@ -0,0 +1,209 @@
+package sqlparser
+
+import (
+	"strconv"
+	"strings"
+
+	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/pkg/errors"
+)
+
+// OrderByColumn a column of ORDER BY clause
+type OrderByColumn struct {
+	// column name without table qualifier. empty if column is specified by position
+	Name string
+	// position of column in select list ( 1 origin ). zero if column is specified by name
+	Position int
+	// whether sorts by descending order
+	Desc bool
+}
+
+// LimitClause LIMIT clause of SELECT query
+type LimitClause struct {
+	// number of rows skipped
+	Offset int64
+	// max number of rows
+	Count int64
+	// indexes of query arguments ( 0 origin ) used by LIMIT clause
+	ArgIndexes []int
+}
+
+// hiddenOrderByColumnPrefix prefix of alias of ORDER BY expression selected additionally by query of each shard
+const hiddenOrderByColumnPrefix = "octillery_order_by_"
+
+// OrderBy returns columns of ORDER BY clause if all of them are columns of select list.
+// If query doesn't have ORDER BY clause or it is sorted by expression, returns nil.
+func (q *QueryBase) OrderBy() []*OrderByColumn {
+	if q.Type != Select {
+		return nil
+	}
+	stmt, ok := q.Stmt.(*vtparser.Select)
+	if !ok || len(stmt.OrderBy) == 0 {
+		return nil
+	}
+	columns := make([]*OrderByColumn, 0, len(stmt.OrderBy))
+	for _, order := range stmt.OrderBy {
+		column := orderByColumn(stmt, order)
+		if column == nil {
+			return nil
+		}
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+// OrderByWithHiddenColumns returns columns of ORDER BY clause and statement executed on each shard.
+// ORDER BY expressions that are not columns of select list are appended to select list of the statement as hidden columns,
+// so rows of shards can be merged by them. Number of hidden columns is also returned.
+// If query doesn't have ORDER BY clause or hidden columns change result of query ( DISTINCT, GROUP BY or placeholder in expression ), returns nil.
+func (q *QueryBase) OrderByWithHiddenColumns() (*vtparser.Select, []*OrderByColumn, int) {
+	if q.Type != Select {
+		return nil, nil, 0
+	}
+	stmt, ok := q.Stmt.(*vtparser.Select)
+	if !ok || len(stmt.OrderBy) == 0 || stmt.Distinct != "" || len(stmt.GroupBy) > 0 {
+		return nil, nil, 0
+	}
+	shardStmt := *stmt
+	shardStmt.SelectExprs = append(vtparser.SelectExprs{}, stmt.SelectExprs...)
+	columns := make([]*OrderByColumn, 0, len(stmt.OrderBy))
+	hidden := 0
+	for _, order := range stmt.OrderBy {
+		if column := orderByColumn(stmt, order); column != nil {
+			columns = append(columns, column)
+			continue
+		}
+		if hasValArg(order.Expr) {
+			// placeholder in select list changes order of arguments
+			return nil, nil, 0
+		}
+		name := hiddenOrderByColumnPrefix + strconv.Itoa(hidden)
+		shardStmt.SelectExprs = append(shardStmt.SelectExprs, &vtparser.AliasedExpr{Expr: order.Expr, As: vtparser.NewColIdent(name)})
+		columns = append(columns, &OrderByColumn{Name: name, Desc: order.Direction == vtparser.DescScr})
+		hidden++
+	}
+	return &shardStmt, columns, hidden
+}
+
+// orderByColumn returns column of ORDER BY clause if it is a column of select list. Otherwise returns nil.
+func orderByColumn(stmt *vtparser.Select, order *vtparser.Order) *OrderByColumn {
+	column := &OrderByColumn{Desc: order.Direction == vtparser.DescScr}
+	switch expr := order.Expr.(type) {
+	case *vtparser.ColName:
+		column.Name = expr.Name.String()
+		if !isSelectedColumn(stmt.SelectExprs, column.Name) {
+			return nil
+		}
+	case *vtparser.SQLVal:
+		if expr.Type != vtparser.IntVal {
+			return nil
+		}
+		position, err := strconv.Atoi(string(expr.Val))
+		if err != nil || position < 1 || position > len(stmt.SelectExprs) {
+			return nil
+		}
+		column.Position = position
+	default:
+		return nil
+	}
+	return column
+}
+
+// hasValArg returns whether expression has placeholder
+func hasValArg(expr vtparser.Expr) bool {
+	found := false
+	vtparser.Walk(func(node vtparser.SQLNode) (bool, error) {
+		if val, ok := node.(*vtparser.SQLVal); ok && val.Type == vtparser.ValArg {
+			found = true
+			return false, nil
+		}
+		return true, nil
+	}, expr)
+	return found
+}
+
+// isSelectedColumn returns whether column is included in result of query
+func isSelectedColumn(selectExprs vtparser.SelectExprs, name string) bool {
+	for _, selectExpr := range selectExprs {
+		switch expr := selectExpr.(type) {
+		case *vtparser.StarExpr:
+			return true
+		case *vtparser.AliasedExpr:
+			if strings.EqualFold(expr.As.String(), name) {
+				return true
+			}
+			if colName, ok := expr.Expr.(*vtparser.ColName); ok && expr.As.IsEmpty() && strings.EqualFold(colName.Name.String(), name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Limit returns LIMIT clause of SELECT query. If query doesn't have LIMIT clause, returns nil.
+func (q *QueryBase) Limit() (*LimitClause, error) {
+	if q.Type != Select {
+		return nil, nil
+	}
+	stmt, ok := q.Stmt.(*vtparser.Select)
+	if !ok || stmt.Limit == nil {
+		return nil, nil
+	}
+	limit := &LimitClause{}
+	if stmt.Limit.Offset != nil {
+		offset, argIndex, err := q.limitValue(stmt.Limit.Offset)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid offset")
+		}
+		limit.Offset = offset
+		if argIndex >= 0 {
+			limit.ArgIndexes = append(limit.ArgIndexes, argIndex)
+		}
+	}
+	count, argIndex, err := q.limitValue(stmt.Limit.Rowcount)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid limit")
+	}
+	limit.Count = count
+	if argIndex >= 0 {
+		limit.ArgIndexes = append(limit.ArgIndexes, argIndex)
+	}
+	return limit, nil
+}
+
+// limitValue returns value of LIMIT or OFFSET and index of query argument. if value is not placeholder, index is -1.
+func (q *QueryBase) limitValue(expr vtparser.Expr) (int64, int, error) {
+	val, ok := expr.(*vtparser.SQLVal)
+	if !ok {
+		return 0, -1, errors.Errorf("unsupported expression %s", vtparser.String(expr))
+	}
+	switch val.Type {
+	case vtparser.IntVal:
+		value, err := strconv.ParseInt(string(val.Val), 10, 64)
+		if err != nil {
+			return 0, -1, errors.WithStack(err)
+		}
+		return value, -1, nil
+	case vtparser.ValArg:
+		index, err := strconv.Atoi(strings.TrimPrefix(string(val.Val), ":v"))
+		if err != nil || index < 1 || index > len(q.Args) {
+			return 0, -1, errors.Errorf("cannot find argument of %s", string(val.Val))
+		}
+		switch arg := q.Args[index-1].(type) {
+		case int:
+			return int64(arg), index - 1, nil
+		case int32:
+			return int64(arg), index - 1, nil
+		case int64:
+			return arg, index - 1, nil
+		case uint:
+			return int64(arg), index - 1, nil
+		case uint32:
+			return int64(arg), index - 1, nil
+		case uint64:
+			return int64(arg), index - 1, nil
+		}
+		return 0, -1, errors.Errorf("argument of %s must be integer. but got %v", string(val.Val), q.Args[index-1])
+	}
+	return 0, -1, errors.Errorf("unsupported value %s", string(val.Val))
+}
@@ -0,0 +1,152 @@
+package sqlparser
+
+import (
+	"strconv"
+	"strings"
+
+	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/pkg/errors"
+)
+
+// StringWithPlaceholder formats node as query text. ':v1' formatted by vitess-sqlparser is replaced to '?'
+func StringWithPlaceholder(node vtparser.SQLNode) string {
+	return replaceValArgToPlaceholder(vtparser.String(node))
+}
+
+// replaceValArgToPlaceholder replaces ':v1' formatted by vitess-sqlparser to '?' except in quoted text
+func replaceValArgToPlaceholder(text string) string {
+	var (
+		builder strings.Builder
+		quote   byte
+	)
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && i+1 < len(text) {
+				builder.WriteByte(c)
+				i++
+				c = text[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == ':' && i+2 < len(text) && text[i+1] == 'v' && isDigit(text[i+2]):
+			i += 2
+			for i+1 < len(text) && isDigit(text[i+1]) {
+				i++
+			}
+			builder.WriteByte('?')
+			continue
+		}
+		builder.WriteByte(c)
+	}
+	return builder.String()
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// bindDollarPlaceholders replaces PostgreSQL style placeholders ( '$1' ) in query text to '?' except in quoted text,
+// and arranges query arguments in order of them. It returns false if query doesn't have them.
+func bindDollarPlaceholders(queryText string, args []interface{}) (string, []interface{}, bool, error) {
+	var (
+		builder   strings.Builder
+		quote     byte
+		boundArgs []interface{}
+		found     bool
+		hasQMark  bool
+	)
+	for i := 0; i < len(queryText); i++ {
+		c := queryText[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && i+1 < len(queryText) {
+				builder.WriteByte(c)
+				i++
+				c = queryText[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?':
+			hasQMark = true
+		case c == '$' && i+1 < len(queryText) && isDigit(queryText[i+1]) && (i == 0 || !isIdentifierChar(queryText[i-1])):
+			end := i + 1
+			for end < len(queryText) && isDigit(queryText[end]) {
+				end++
+			}
+			index, err := strconv.Atoi(queryText[i+1 : end])
+			if err != nil {
+				return "", nil, false, errors.WithStack(err)
+			}
+			if index == 0 || index > len(args) {
+				return "", nil, false, errors.Errorf("argument of placeholder $%d is not found", index)
+			}
+			found = true
+			boundArgs = append(boundArgs, args[index-1])
+			builder.WriteByte('?')
+			i = end - 1
+			continue
+		}
+		builder.WriteByte(c)
+	}
+	if !found {
+		return queryText, args, false, nil
+	}
+	if hasQMark {
+		return "", nil, false, errors.New("cannot use both '?' and '$1' style placeholders in a query")
+	}
+	return builder.String(), boundArgs, true, nil
+}
+
+// ReplacePlaceholderToDollar replaces '?' in query text to '$1', '$2', ... in order except in quoted text.
+// It restores query written by PostgreSQL style placeholders after it is rewritten as '?' by octillery.
+func ReplacePlaceholderToDollar(text string) string {
+	var (
+		builder strings.Builder
+		quote   byte
+		index   int
+	)
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && i+1 < len(text) {
+				builder.WriteByte(c)
+				i++
+				c = text[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?':
+			index++
+			builder.WriteString("$" + strconv.Itoa(index))
+			continue
+		}
+		builder.WriteByte(c)
+	}
+	return builder.String()
+}
+
+// IsDollarPlaceholder returns whether query is written by PostgreSQL style placeholders ( '$1' )
+func IsDollarPlaceholder(query Query) bool {
+	switch q := query.(type) {
+	case *QueryBase:
+		return q.DollarPlaceholder
+	case *InsertQuery:
+		return q.DollarPlaceholder
+	case *DeleteQuery:
+		return q.DollarPlaceholder
+	}
+	return false
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c == '$' || isDigit(c) || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
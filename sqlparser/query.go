@@ -1,6 +1,9 @@
 package sqlparser
 
 import (
+	"fmt"
+	"strings"
+
 	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
 )
 
@@ -34,12 +37,27 @@ const (
 	TruncateTable
 	// Show 'SHOW' query type
 	Show
+	// Savepoint 'SAVEPOINT' query type
+	Savepoint
+	// ReleaseSavepoint 'RELEASE SAVEPOINT' query type
+	ReleaseSavepoint
+	// RollbackToSavepoint 'ROLLBACK TO SAVEPOINT' query type
+	RollbackToSavepoint
+	// CreateTemporaryTable 'CREATE TEMPORARY TABLE' query type
+	CreateTemporaryTable
+	// DropTemporaryTable 'DROP TEMPORARY TABLE' query type
+	DropTemporaryTable
 )
 
 func (t QueryType) IsWriteQuery() bool {
 	return t == Insert || t == Update || t == Delete
 }
 
+// IsReadQuery returns whether query doesn't change any data or schema
+func (t QueryType) IsReadQuery() bool {
+	return t == Select || t == Show
+}
+
 func (t QueryType) String() string {
 	switch t {
 	case Unknown:
@@ -58,6 +76,16 @@ func (t QueryType) String() string {
 		return "CREATE TABLE"
 	case TruncateTable:
 		return "TRUNCATE TABLE"
+	case Savepoint:
+		return "SAVEPOINT"
+	case ReleaseSavepoint:
+		return "RELEASE SAVEPOINT"
+	case RollbackToSavepoint:
+		return "ROLLBACK TO SAVEPOINT"
+	case CreateTemporaryTable:
+		return "CREATE TEMPORARY TABLE"
+	case DropTemporaryTable:
+		return "DROP TEMPORARY TABLE"
 	}
 	return ""
 }
@@ -90,6 +118,26 @@ type QueryBase struct {
 	ShardKeyID                 Identifier
 	ShardKeyIDPlaceholderIndex int
 	Stmt                       vtparser.Statement
+	// column list of RETURNING clause ( e.g. 'id' of 'INSERT ... RETURNING id' )
+	Returning string
+	// tables joined to TableName by JOIN query
+	JoinTableNames []string
+	// shard_key ids of IN clause ( e.g. 'WHERE user_id IN (1, 2, 3)' ). used only if ShardKeyID is not found
+	ShardKeyIDs []Identifier
+	// IN expression for shard_key column that ShardKeyIDs are taken from
+	shardKeyIn *shardKeyInExpr
+	// shard_key given by comment like '/* octillery:shard_key=123 */'
+	shardKeyHint *Identifier
+	// whether query is written by PostgreSQL style placeholders ( '$1' ).
+	// they are replaced to '?' in Text and Args are arranged in order of them
+	DollarPlaceholder bool
+	// rows moved to another shard by UPDATE query changing shard_key. nil if query doesn't move rows
+	ShardKeyMove *ShardKeyMove
+	// values of 'unique_columns' assigned by UPDATE query. nil if query doesn't assign them
+	UniqueColumnUpdate *UniqueColumnUpdate
+	// locking clause of SELECT query normalized to lower case ( e.g. 'for update', 'lock in share mode' ).
+	// empty if query is not locking read
+	Lock string
 }
 
 // Table returns table name
@@ -102,6 +150,21 @@ func (q *QueryBase) QueryType() QueryType {
 	return q.Type
 }
 
+// IsReturning returns whether query has RETURNING clause or not
+func (q *QueryBase) IsReturning() bool {
+	return q.Returning != ""
+}
+
+// IsLockingRead returns whether query is SELECT with locking clause ( e.g. 'SELECT ... FOR UPDATE' )
+func (q *QueryBase) IsLockingRead() bool {
+	return q.Lock != ""
+}
+
+// IsJoinQuery returns whether query joins multiple tables or not
+func (q *QueryBase) IsJoinQuery() bool {
+	return len(q.JoinTableNames) > 0
+}
+
 // IsNotFoundShardKeyID returns whether sharding key is found in SQL
 func (q *QueryBase) IsNotFoundShardKeyID() bool {
 	return q.ShardKeyID == UnknownID
@@ -110,9 +173,12 @@ func (q *QueryBase) IsNotFoundShardKeyID() bool {
 // InsertQuery a implementation of Query interface.
 type InsertQuery struct {
 	*QueryBase
-	Stmt           *vtparser.Insert
-	ColumnValues   []func() *vtparser.SQLVal
+	Stmt         *vtparser.Insert
+	ColumnValues []func() *vtparser.SQLVal
+	// queries inserting each row if query has multiple value tuples for sharded table. otherwise empty.
+	RowQueries     []*InsertQuery
 	nextSequenceID Identifier
+	conflictClause string
 }
 
 // NewInsertQuery creates instance of InsertQuery structure.
@@ -135,9 +201,46 @@ func (q *InsertQuery) SetNextSequenceID(id int64) {
 	q.nextSequenceID = Identifier(id)
 }
 
-// String returns formatted text.
-// If insert query includes variable like placeholder, replace it.
-func (q *InsertQuery) String() string {
+// ColumnValue returns value of column after placeholder is replaced by query argument.
+// If column is not specified or the value is not literal ( e.g. NULL or expression ), returns false.
+func (q *InsertQuery) ColumnValue(column string) (*vtparser.SQLVal, bool) {
+	for idx, col := range q.Stmt.Columns {
+		if !strings.EqualFold(col.String(), column) {
+			continue
+		}
+		var val *vtparser.SQLVal
+		if columnValue := q.ColumnValues[idx]; columnValue != nil {
+			val = columnValue()
+		} else if v, ok := q.Stmt.Rows.(vtparser.Values)[0][idx].(*vtparser.SQLVal); ok {
+			val = v
+		}
+		if val == nil || val.Type == vtparser.ValArg || (val.Type == vtparser.IntVal && strings.EqualFold(string(val.Val), "null")) {
+			return nil, false
+		}
+		return val, true
+	}
+	return nil, false
+}
+
+// SetIgnoreDuplicate makes INSERT query ignore duplicate key error.
+// modifier is put after INSERT keyword ( e.g. 'ignore' ), and clause is appended to query ( e.g. 'on conflict do nothing' ).
+func (q *InsertQuery) SetIgnoreDuplicate(modifier string, clause string) {
+	if modifier != "" {
+		q.Stmt.Ignore = modifier + " "
+	}
+	q.conflictClause = clause
+	for _, row := range q.RowQueries {
+		row.SetIgnoreDuplicate(modifier, clause)
+	}
+}
+
+// IsMultiRows returns whether query inserts multiple rows that may be routed to different shards
+func (q *InsertQuery) IsMultiRows() bool {
+	return len(q.RowQueries) > 1
+}
+
+// rowValues returns value tuple of the first row after placeholder is replaced
+func (q *InsertQuery) rowValues() vtparser.ValTuple {
 	values := q.Stmt.Rows.(vtparser.Values)
 	for idx, columnValue := range q.ColumnValues {
 		if columnValue == nil {
@@ -145,7 +248,37 @@ func (q *InsertQuery) String() string {
 		}
 		values[0][idx] = columnValue()
 	}
-	return vtparser.String(q.Stmt)
+	return values[0]
+}
+
+func (q *InsertQuery) format(stmt *vtparser.Insert) string {
+	text := vtparser.String(stmt)
+	if q.conflictClause != "" {
+		text = fmt.Sprintf("%s %s", text, q.conflictClause)
+	}
+	if q.IsReturning() {
+		return fmt.Sprintf("%s returning %s", text, q.Returning)
+	}
+	return text
+}
+
+// String returns formatted text.
+// If insert query includes variable like placeholder, replace it.
+func (q *InsertQuery) String() string {
+	q.rowValues()
+	return q.format(q.Stmt)
+}
+
+// BulkString returns formatted text inserting rows at once.
+// rows must be the part of RowQueries, and query's RETURNING clause is used.
+func (q *InsertQuery) BulkString(rows []*InsertQuery) string {
+	values := make(vtparser.Values, 0, len(rows))
+	for _, row := range rows {
+		values = append(values, row.rowValues())
+	}
+	stmt := *q.Stmt
+	stmt.Rows = values
+	return q.format(&stmt)
 }
 
 // DeleteQuery a implementation of Query interface.
@@ -170,4 +303,3 @@ func (q *DeleteQuery) setStateAfterParsing() {
 	q.IsAllShardQuery = q.IsNotFoundShardKeyID() &&
 		(q.Stmt.Where != nil || q.Stmt.OrderBy != nil || q.Stmt.Limit != nil)
 }
-
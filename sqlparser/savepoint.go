@@ -0,0 +1,40 @@
+package sqlparser
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	savepointPattern           = regexp.MustCompile("(?is)^\\s*SAVEPOINT\\s+`?(\\w+)`?\\s*;?\\s*$")
+	releaseSavepointPattern    = regexp.MustCompile("(?is)^\\s*RELEASE\\s+(?:SAVEPOINT\\s+)?`?(\\w+)`?\\s*;?\\s*$")
+	rollbackToSavepointPattern = regexp.MustCompile("(?is)^\\s*ROLLBACK\\s+(?:WORK\\s+)?TO\\s+(?:SAVEPOINT\\s+)?`?(\\w+)`?\\s*;?\\s*$")
+)
+
+// SavepointQuery a implementation of Query interface for 'SAVEPOINT', 'RELEASE SAVEPOINT' and 'ROLLBACK TO SAVEPOINT'.
+// It doesn't have table name, because savepoint is set on all databases accessed by transaction.
+type SavepointQuery struct {
+	*QueryBase
+	// savepoint name
+	Name string
+}
+
+// ParseSavepoint parses savepoint statement.
+// vitess-sqlparser doesn't support it, so it is parsed from query text. If query is not savepoint statement, returns false.
+func ParseSavepoint(queryText string) (*SavepointQuery, bool) {
+	formattedQueryText := strings.Replace(queryText, `"`, "`", -1)
+	for queryType, pattern := range map[QueryType]*regexp.Regexp{
+		Savepoint:           savepointPattern,
+		ReleaseSavepoint:    releaseSavepointPattern,
+		RollbackToSavepoint: rollbackToSavepointPattern,
+	} {
+		matched := pattern.FindStringSubmatch(formattedQueryText)
+		if len(matched) == 0 {
+			continue
+		}
+		queryBase := NewQueryBase(nil, queryText, nil)
+		queryBase.Type = queryType
+		return &SavepointQuery{QueryBase: queryBase, Name: matched[1]}, true
+	}
+	return nil, false
+}
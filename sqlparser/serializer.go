@@ -0,0 +1,72 @@
+package sqlparser
+
+import (
+	"sync"
+
+	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"go.knocknote.io/octillery/connection/adapter"
+)
+
+// ValueSerializer serializes query argument to SQL literal embedded in query rewritten by octillery
+// ( e.g. INSERT query for sharded table ). It returns literal of value and whether it is string literal that is quoted and escaped by octillery.
+// If ok is false, next serializer is used.
+type ValueSerializer func(value interface{}) (literal string, isString bool, ok bool)
+
+var (
+	serializersMu sync.RWMutex
+	serializers   []ValueSerializer
+)
+
+// RegisterValueSerializer registers serializer used before serializer of adapter.
+// Serializers are used for bool, time.Time and types not supported by octillery ( e.g. custom column types ).
+func RegisterValueSerializer(serializer ValueSerializer) {
+	serializersMu.Lock()
+	defer serializersMu.Unlock()
+	serializers = append(serializers, serializer)
+}
+
+// serializeValue serializes value by registered serializers or adapter of table.
+// If they don't serialize value, returns false.
+func (p *Parser) serializeValue(tableName string, value interface{}) (func() *vtparser.SQLVal, bool) {
+	serializersMu.RLock()
+	registered := serializers
+	serializersMu.RUnlock()
+	for _, serializer := range registered {
+		if literal, isString, ok := serializer(value); ok {
+			return createSQLLiteralTypeVal(literal, isString), true
+		}
+	}
+	adap, err := adapter.Adapter(p.cfg.AdapterName(tableName))
+	if err != nil {
+		return nil, false
+	}
+	valueSerializer, ok := adap.(adapter.ValueSerializerAdapter)
+	if !ok {
+		return nil, false
+	}
+	literal, isString, ok := valueSerializer.SerializeValue(value)
+	if !ok {
+		return nil, false
+	}
+	return createSQLLiteralTypeVal(literal, isString), true
+}
+
+// serializeValueOr serializes value by serializeValue. If value is not serialized, returns defaultValue.
+func (p *Parser) serializeValueOr(tableName string, value interface{}, defaultValue func() *vtparser.SQLVal) func() *vtparser.SQLVal {
+	if val, ok := p.serializeValue(tableName, value); ok {
+		return val
+	}
+	return defaultValue
+}
+
+func createSQLLiteralTypeVal(literal string, isString bool) func() *vtparser.SQLVal {
+	if isString {
+		return createSQLStringTypeVal(literal)
+	}
+	return func() *vtparser.SQLVal {
+		return &vtparser.SQLVal{
+			Type: vtparser.IntVal,
+			Val:  []byte(literal),
+		}
+	}
+}
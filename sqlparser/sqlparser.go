@@ -5,18 +5,28 @@ import (
 	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
 	"github.com/pkg/errors"
 	"go.knocknote.io/octillery/config"
 	"go.knocknote.io/octillery/debug"
+	"go.knocknote.io/octillery/warning"
 )
 
+func init() {
+	config.RegisterFeature(config.FeatureJoin)
+}
+
 // Parser the structure for parsing SQL
 type Parser struct {
 	cfg   *config.Config
 	query *Query
+	// map alias ( or name ) to table name of JOIN query
+	tableAliases map[string]string
+	// query is parsed by ParsePrepared before arguments are bound
+	isPrepared bool
 }
 
 var (
@@ -29,6 +39,14 @@ var (
 
 var (
 	ErrShardingKeyNotAllowNil = errors.New("sharding key does not allow nil")
+
+	// ErrShardKeyUpdated returned when UPDATE query changes value of shard_key column.
+	// The row would have to move to another shard, so octillery rejects it instead of writing to wrong shard.
+	ErrShardKeyUpdated = errors.New("cannot update value of shard_key column")
+
+	// ErrUniqueColumnUpdated returned when UPDATE query assigns value of 'unique_columns' that cannot be reserved before execution
+	// ( e.g. value is expression, or the same value is assigned to multiple rows ).
+	ErrUniqueColumnUpdated = errors.New("cannot update value of unique column")
 )
 
 func (p *Parser) shardColumnName(tableName string) string {
@@ -42,6 +60,11 @@ func (p *Parser) shardKeyColumnName(tableName string) string {
 func (p *Parser) isShardKeyColumn(valExpr vtparser.Expr, queryBase *QueryBase) bool {
 	switch expr := valExpr.(type) {
 	case *vtparser.ColName:
+		if !expr.Qualifier.IsEmpty() && p.tableAliases != nil {
+			// JOIN query compares shard_key of joined tables each other, so any of them decides shard
+			tableName, exists := p.tableAliases[expr.Qualifier.Name.String()]
+			return exists && p.shardKeyColumnName(tableName) == expr.Name.String()
+		}
 		if p.shardKeyColumnName(queryBase.TableName) == expr.Name.String() {
 			return true
 		}
@@ -86,16 +109,23 @@ func (p *Parser) parseVal(val *vtparser.SQLVal, queryBase *QueryBase) error {
 
 	placeholderIndex := p.parseShardColumnPlaceholderIndex(val)
 	if placeholderIndex == 0 {
+		if len(queryBase.Args) == 0 {
+			// named placeholder ( e.g. ':id' ) is bound to position when query is executed with arguments
+			return nil
+		}
 		return errors.New("cannot parse shard_key column provided by query argument")
 	}
 	queryBase.ShardKeyIDPlaceholderIndex = placeholderIndex
 	if len(queryBase.Args) >= placeholderIndex {
 		arg := queryBase.Args[placeholderIndex-1]
-		switch argType := arg.(type) {
+		switch arg.(type) {
 		case int, int8, int16, int32, int64:
-			queryBase.ShardKeyID = Identifier(argType.(int64))
+			queryBase.ShardKeyID = Identifier(reflect.ValueOf(arg).Int())
 		case uint, uint8, uint16, uint32, uint64:
-			queryBase.ShardKeyID = Identifier(argType.(uint64))
+			queryBase.ShardKeyID = Identifier(reflect.ValueOf(arg).Uint())
+		case time.Time:
+			// time value is used as Unix time for time_range algorithm
+			queryBase.ShardKeyID = Identifier(arg.(time.Time).Unix())
 		default:
 			return errors.Errorf("unsupport shard_key type %s", reflect.TypeOf(arg))
 		}
@@ -135,10 +165,24 @@ func (p *Parser) parseComparisonExpr(expr *vtparser.ComparisonExpr, queryBase *Q
 	if !p.isShardKeyColumn(expr.Left, queryBase) {
 		return nil
 	}
+	if _, isColumn := expr.Right.(*vtparser.ColName); isColumn {
+		// comparison between columns ( e.g. JOIN condition ) doesn't decide shard
+		return nil
+	}
+	switch expr.Operator {
+	case vtparser.InStr:
+		return errors.WithStack(p.parseInExpr(expr, queryBase))
+	case vtparser.NotInStr:
+		// NOT IN clause is executed for all shards
+		return nil
+	}
 	return errors.WithStack(p.parseExpr(expr.Right, queryBase))
 }
 
 func (p *Parser) parseWhere(where *vtparser.Where, queryBase *QueryBase) error {
+	if queryBase.shardKeyHint != nil {
+		return errors.WithStack(p.parseWhereWithShardKeyHint(where, queryBase))
+	}
 	return errors.WithStack(p.parseExpr(where.Expr, queryBase))
 }
 
@@ -176,6 +220,23 @@ func (p *Parser) parseTableExpr(stmt *vtparser.Select, tableExpr vtparser.TableE
 
 func (p *Parser) parseSelectStmt(stmt *vtparser.Select, queryBase *QueryBase) (Query, error) {
 	queryBase.Type = Select
+	p.tableAliases = nil
+	if len(stmt.From) > 1 || !isAliasedTableExpr(stmt.From[0]) {
+		var (
+			tables []*joinedTable
+			conds  []vtparser.Expr
+			err    error
+		)
+		for _, tableExpr := range stmt.From {
+			if tables, conds, err = p.collectJoinedTables(tableExpr, tables, conds); err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+		if err := p.parseJoinStmt(stmt, tables, conds, queryBase); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return queryBase, nil
+	}
 	for _, tableExpr := range stmt.From {
 		if err := p.parseTableExpr(stmt, tableExpr, queryBase); err != nil {
 			return nil, errors.WithStack(err)
@@ -311,26 +372,37 @@ func (p *Parser) replaceInsertValueFromValArg(query *InsertQuery, colIndex int,
 		}
 	case bool:
 		val := convertBoolToInt8(arg)
-		query.ColumnValues[colIndex] = createSQLIntTypeVal(val)
+		query.ColumnValues[colIndex] = p.serializeValueOr(query.TableName, arg, createSQLIntTypeVal(val))
 	case *bool:
 		if arg == nil {
 			query.ColumnValues[colIndex] = createSQLNilTypeVal()
 		} else {
 			val := convertBoolToInt8(*arg)
-			query.ColumnValues[colIndex] = createSQLIntTypeVal(val)
+			query.ColumnValues[colIndex] = p.serializeValueOr(query.TableName, *arg, createSQLIntTypeVal(val))
 		}
 	case time.Time:
-		query.ColumnValues[colIndex] = createSQLTimeTypeVal(arg)
+		p.replaceInsertValueFromValArgCaseTime(query, colIndex, colName, arg)
 	case *time.Time:
 		if arg == nil {
-			query.ColumnValues[colIndex] = createSQLNilTypeVal()
+			if err := p.replaceInsertValueFromValArgCaseIntNilPtr(query, colIndex, colName); err != nil {
+				return errors.WithStack(err)
+			}
 		} else {
-			query.ColumnValues[colIndex] = createSQLTimeTypeVal(*arg)
+			p.replaceInsertValueFromValArgCaseTime(query, colIndex, colName, *arg)
 		}
 	case nil:
 		query.ColumnValues[colIndex] = createSQLNilTypeVal()
 	default:
-		debug.Printf("arg type = %s", reflect.TypeOf(arg))
+		if val, ok := p.serializeValue(query.TableName, arg); ok {
+			query.ColumnValues[colIndex] = val
+			return nil
+		}
+		warning.Warn(&warning.Warning{
+			Code:    warning.UnsupportedArgType,
+			Message: fmt.Sprintf("value of %s is not replaced because arg type %s is not supported", colName, reflect.TypeOf(arg)),
+			Table:   query.TableName,
+			Query:   query.Text,
+		})
 	}
 	return nil
 }
@@ -342,6 +414,13 @@ func (p *Parser) replaceInsertValueFromValArgCaseInt(query *InsertQuery, colInde
 	query.ColumnValues[colIndex] = createSQLIntTypeVal(arg)
 }
 
+func (p *Parser) replaceInsertValueFromValArgCaseTime(query *InsertQuery, colIndex int, colName string, arg time.Time) {
+	if colName == p.shardKeyColumnName(query.TableName) {
+		query.ShardKeyID = Identifier(arg.Unix())
+	}
+	query.ColumnValues[colIndex] = p.serializeValueOr(query.TableName, arg, createSQLTimeTypeVal(arg))
+}
+
 func (p *Parser) replaceInsertValueFromValArgCaseIntNilPtr(query *InsertQuery, colIndex int, colName string) error {
 	if colName == p.shardKeyColumnName(query.TableName) {
 		return errors.WithStack(ErrShardingKeyNotAllowNil)
@@ -383,23 +462,70 @@ func (p *Parser) parseInsertStmt(stmt *vtparser.Insert, queryBase *QueryBase) (Q
 	queryBase.Type = Insert
 	queryBase.TableName = stmt.Table.Name.String()
 	query := NewInsertQuery(queryBase, stmt)
-	for idx, column := range stmt.Columns {
-		colName := column.String()
-		if err := p.replaceInsertValue(query, idx, colName); err != nil {
-			return nil, errors.WithStack(err)
+	if err := p.replaceInsertValues(query); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	values, ok := stmt.Rows.(vtparser.Values)
+	if !ok || len(values) < 2 || !p.cfg.IsShardTable(queryBase.TableName) {
+		return query, nil
+	}
+	rows, err := p.splitInsertRows(query, values)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	query.RowQueries = rows
+	for _, row := range rows {
+		if row.ShardKeyID != rows[0].ShardKeyID {
+			// rows may be inserted to different shards
+			query.ShardKeyID = UnknownID
+			break
 		}
 	}
 	return query, nil
 }
 
-func (p *Parser) parseUpdateExprs(exprs vtparser.UpdateExprs, queryBase *QueryBase) error {
+func (p *Parser) replaceInsertValues(query *InsertQuery) error {
+	for idx, column := range query.Stmt.Columns {
+		if err := p.replaceInsertValue(query, idx, column.String()); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// splitInsertRows creates InsertQuery for each value tuple, so each row is routed by own shard_key
+func (p *Parser) splitInsertRows(query *InsertQuery, values vtparser.Values) ([]*InsertQuery, error) {
+	rows := make([]*InsertQuery, 0, len(values))
+	for _, value := range values {
+		stmt := *query.Stmt
+		tuple := make(vtparser.ValTuple, len(value))
+		copy(tuple, value)
+		stmt.Rows = vtparser.Values{tuple}
+		queryBase := *query.QueryBase
+		queryBase.Stmt = &stmt
+		queryBase.ShardKeyID = UnknownID
+		row := NewInsertQuery(&queryBase, &stmt)
+		if err := p.replaceInsertValues(row); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// parseUpdateExprs returns shard_key value assigned by SET clause. If SET clause doesn't assign shard_key column, returns nil.
+// If assigned value cannot be decided by query ( e.g. 'user_id = user_id + 1' ), returned value is UnknownID.
+func (p *Parser) parseUpdateExprs(exprs vtparser.UpdateExprs, queryBase *QueryBase) *Identifier {
 	for _, updateExpr := range exprs {
 		if p.shardKeyColumnName(queryBase.TableName) != updateExpr.Name.Name.String() {
 			continue
 		}
-		if err := p.parseExpr(updateExpr.Expr, queryBase); err != nil {
-			return errors.WithStack(err)
+		assigned := &QueryBase{Args: queryBase.Args, TableName: queryBase.TableName, ShardKeyID: UnknownID}
+		if err := p.parseExpr(updateExpr.Expr, assigned); err != nil {
+			debug.Printf("cannot parse value of shard_key column: %+v", err)
+			assigned.ShardKeyID = UnknownID
 		}
+		return &assigned.ShardKeyID
 	}
 	return nil
 }
@@ -443,16 +569,40 @@ func (p *Parser) parseUpdateStmt(stmt *vtparser.Update, queryBase *QueryBase) (Q
 		return queryBase, nil
 	}
 
-	if stmt.Exprs != nil {
-		if err := p.parseUpdateExprs(stmt.Exprs, queryBase); err != nil {
-			return nil, errors.WithStack(err)
-		}
-	}
 	if stmt.Where != nil {
 		if err := p.parseWhere(stmt.Where, queryBase); err != nil {
 			return nil, errors.WithStack(err)
 		}
 	}
+	if assignedShardKeyID := p.parseUpdateExprs(stmt.Exprs, queryBase); assignedShardKeyID != nil {
+		// assigning the same value as WHERE clause doesn't move the row
+		if *assignedShardKeyID == UnknownID || *assignedShardKeyID != queryBase.ShardKeyID {
+			if !p.cfg.IsMoveOnShardKeyUpdate(tableName) {
+				return nil, errors.Wrapf(ErrShardKeyUpdated, "%s.%s", tableName, p.shardKeyColumnName(tableName))
+			}
+			if p.isPrepared {
+				// rows to move are decided by arguments bound at execution of prepared statement
+				return queryBase, nil
+			}
+			move, err := p.parseShardKeyMove(stmt, queryBase, *assignedShardKeyID)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s.%s", tableName, p.shardKeyColumnName(tableName))
+			}
+			queryBase.ShardKeyMove = move
+		}
+	}
+	if p.isPrepared {
+		// values of unique columns are decided by arguments bound at execution of prepared statement
+		return queryBase, nil
+	}
+	uniqueColumnUpdate, err := p.parseUniqueColumnUpdate(stmt, queryBase)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if uniqueColumnUpdate != nil && queryBase.ShardKeyMove != nil {
+		return nil, errors.Wrapf(ErrUniqueColumnUpdated, "cannot move rows assigning value of unique column of %s", tableName)
+	}
+	queryBase.UniqueColumnUpdate = uniqueColumnUpdate
 	return queryBase, nil
 }
 
@@ -518,17 +668,105 @@ func (p *Parser) formatQuery(query string) string {
 	return formattedQuery
 }
 
+// splitReturningClause removes RETURNING clause of INSERT/UPDATE/DELETE ( PostgreSQL ) from query
+// because it cannot be parsed by vitess-sqlparser, and returns column list of it.
+// Query is scanned by tokenizer, so 'returning' in quoted text or comments is ignored.
+func (p *Parser) splitReturningClause(query string) (string, string) {
+	tokenizer := vtparser.NewStringTokenizer(query)
+	typ, _ := tokenizer.Scan()
+	if typ != vtparser.INSERT && typ != vtparser.UPDATE && typ != vtparser.DELETE {
+		return query, ""
+	}
+	nesting := 0
+	for {
+		typ, value := tokenizer.Scan()
+		switch typ {
+		case 0, vtparser.LEX_ERROR:
+			return query, ""
+		case '(':
+			nesting++
+		case ')':
+			nesting--
+		case vtparser.ID:
+			// end of token is position of the character read ahead by tokenizer
+			end := tokenizer.Position - 1
+			start := end - len(value)
+			if nesting != 0 || start < 0 || !strings.EqualFold(query[start:end], "returning") {
+				continue
+			}
+			returning := strings.TrimSpace(query[end:])
+			if returning == "" {
+				return query, ""
+			}
+			return strings.TrimSpace(query[:start]), returning
+		}
+	}
+}
+
 // Parse parse SQL/DDL by [blastrain/vitess-sqlparser](https://github.com/blastrain/vitess-sqlparser),
 // it returns Query interface includes table name or query type
 // nolint: gocyclo
 func (p *Parser) Parse(queryText string, args ...interface{}) (Query, error) {
-	formattedQueryText := p.formatQuery(queryText)
+	if query, ok := ParseSavepoint(queryText); ok {
+		return query, nil
+	}
+	if query, ok := ParseTemporaryTable(queryText, args...); ok {
+		return query, nil
+	}
+	queryText, args, err := BindNamedArgs(queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	queryText, args, isDollarPlaceholder, err := bindDollarPlaceholders(queryText, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	shardKeyHint, err := parseShardKeyHint(queryText)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	formattedQueryText, returning := p.splitReturningClause(p.formatQuery(queryText))
+	formattedQueryText, lock := splitLockClause(formattedQueryText)
 	ast, err := vtparser.Parse(formattedQueryText)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	if _, isSelect := ast.(*vtparser.Select); lock != "" && !isSelect {
+		return nil, errors.Errorf("locking clause '%s' is available only for SELECT query", lock)
+	}
 
 	queryBase := NewQueryBase(ast, queryText, args)
+	queryBase.Returning = returning
+	queryBase.Lock = lock
+	queryBase.DollarPlaceholder = isDollarPlaceholder
+	queryBase.shardKeyHint = shardKeyHint
+	query, err := p.parseStmt(ast, queryBase)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if shardKeyHint != nil {
+		if err := p.applyShardKeyHintToQuery(query); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return query, nil
+}
+
+// ParsePrepared parses query prepared before arguments are bound.
+// Values decided by arguments ( e.g. new value of shard_key moving rows ) are not verified,
+// so query must be parsed by Parse again with arguments when prepared statement is executed.
+func (p *Parser) ParsePrepared(queryText string) (Query, error) {
+	p.isPrepared = true
+	defer func() { p.isPrepared = false }()
+	query, err := p.Parse(queryText)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return query, nil
+}
+
+// nolint: gocyclo
+func (p *Parser) parseStmt(ast vtparser.Statement, queryBase *QueryBase) (Query, error) {
 	switch stmt := ast.(type) {
 	case *vtparser.Select:
 		query, err := p.parseSelectStmt(stmt, queryBase)
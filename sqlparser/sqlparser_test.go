@@ -1,13 +1,18 @@
 package sqlparser
 
 import (
+	"database/sql"
 	"fmt"
 	"log"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
 	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/connection/adapter"
 	"go.knocknote.io/octillery/path"
 )
 
@@ -37,6 +42,24 @@ func TestDDL(t *testing.T) {
 			t.Fatal("cannot parse 'create table' query")
 		}
 	})
+	t.Run("create table with foreign key", func(t *testing.T) {
+		query, err := parser.Parse("create table user_decks (id integer not null primary key, user_id integer not null, CONSTRAINT `fk_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`))")
+		checkErr(t, err)
+		foreignKeys := query.(*QueryBase).ForeignKeys()
+		if len(foreignKeys) != 1 {
+			t.Fatal("cannot parse foreign key")
+		}
+		fk := foreignKeys[0]
+		if fk.Table != "user_decks" || fk.ReferencedTable != "users" {
+			t.Fatalf("cannot parse foreign key table. %s => %s", fk.Table, fk.ReferencedTable)
+		}
+		if len(fk.Columns) != 1 || fk.Columns[0] != "user_id" {
+			t.Fatalf("cannot parse foreign key column. %v", fk.Columns)
+		}
+		if len(fk.ReferencedColumns) != 1 || fk.ReferencedColumns[0] != "id" {
+			t.Fatalf("cannot parse referenced column. %v", fk.ReferencedColumns)
+		}
+	})
 	t.Run("drop table", func(t *testing.T) {
 		query, err := parser.Parse("drop table if exists users")
 		checkErr(t, err)
@@ -759,6 +782,456 @@ func testInsertWithNotShardingTable(t *testing.T) {
 	})
 }
 
+func TestJOIN(t *testing.T) {
+	parser, err := New()
+	checkErr(t, err)
+	t.Run("join by shard_key", func(t *testing.T) {
+		query, err := parser.Parse("select u.name, p.nickname from users u inner join user_profiles p on u.id = p.user_id where u.id = ?", int64(2))
+		checkErr(t, err)
+		joinQuery := query.(*QueryBase)
+		if joinQuery.Table() != "users" || !joinQuery.IsJoinQuery() || joinQuery.JoinTableNames[0] != "user_profiles" {
+			t.Fatalf("cannot parse joined tables %s %v", joinQuery.Table(), joinQuery.JoinTableNames)
+		}
+		if joinQuery.ShardKeyID != 2 {
+			t.Fatal("cannot parse shard_key")
+		}
+	})
+	t.Run("shard_key of joined table", func(t *testing.T) {
+		query, err := parser.Parse("select * from users join user_profiles on users.id = user_profiles.user_id where user_profiles.user_id = 3")
+		checkErr(t, err)
+		if query.(*QueryBase).ShardKeyID != 3 {
+			t.Fatal("cannot parse shard_key")
+		}
+	})
+	t.Run("join for all shards", func(t *testing.T) {
+		query, err := parser.Parse("select * from users, user_profiles where users.id = user_profiles.user_id and users.name = 'bob'")
+		checkErr(t, err)
+		if !query.(*QueryBase).IsNotFoundShardKeyID() {
+			t.Fatal("cannot parse shard_key")
+		}
+	})
+	t.Run("self join", func(t *testing.T) {
+		if _, err := parser.Parse("select * from users a join users b on a.id = b.id"); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if _, err := parser.Parse("select * from users a join users b on a.name = b.name"); err == nil {
+			t.Fatal("cannot handle error")
+		}
+	})
+	t.Run("not shard_key condition", func(t *testing.T) {
+		if _, err := parser.Parse("select * from users join user_profiles on users.name = user_profiles.name"); err == nil {
+			t.Fatal("cannot handle error")
+		}
+		if _, err := parser.Parse("select * from users join user_profiles on users.id > user_profiles.user_id"); err == nil {
+			t.Fatal("cannot handle error")
+		}
+	})
+	t.Run("different shards", func(t *testing.T) {
+		if _, err := parser.Parse("select * from users join user_decks on users.id = user_decks.user_id"); err == nil {
+			t.Fatal("cannot handle error")
+		}
+	})
+	t.Run("not sharded table", func(t *testing.T) {
+		if _, err := parser.Parse("select * from users join user_stages on users.id = user_stages.user_id"); err == nil {
+			t.Fatal("cannot handle error")
+		}
+		query, err := parser.Parse("select * from user_stages a join user_stages b on a.id = b.parent_id")
+		checkErr(t, err)
+		if query.Table() != "user_stages" {
+			t.Fatal("cannot parse table")
+		}
+	})
+}
+
+func TestAggregates(t *testing.T) {
+	parser, err := New()
+	checkErr(t, err)
+	query, err := parser.Parse("select count(*) as cnt, SUM(age), min(name), max(age), avg(age) from users where name = 'bob'")
+	checkErr(t, err)
+	columns := query.(*QueryBase).Aggregates()
+	if len(columns) != 5 {
+		t.Fatalf("cannot parse aggregate functions. %d columns", len(columns))
+	}
+	funcs := []AggregateFunc{AggregateCount, AggregateSum, AggregateMin, AggregateMax, AggregateAvg}
+	for idx, column := range columns {
+		if column.Func != funcs[idx] {
+			t.Fatalf("invalid aggregate function %s", column.Func)
+		}
+	}
+	if columns[0].Alias != "cnt" || columns[1].Alias != "" {
+		t.Fatal("cannot parse alias")
+	}
+	for _, text := range []string{
+		"select name, count(*) from users",
+		"select count(*) from users group by name",
+		"select count(*) from users having count(*) > 1",
+		"select count(distinct name) from users",
+		"select length(name) from users",
+		"select * from users",
+	} {
+		query, err := parser.Parse(text)
+		checkErr(t, err)
+		if query.(*QueryBase).Aggregates() != nil {
+			t.Fatalf("%s cannot be merged", text)
+		}
+	}
+}
+
+func TestOrderByAndLimit(t *testing.T) {
+	parser, err := New()
+	checkErr(t, err)
+	query, err := parser.Parse("select id, name as n from users order by n desc, 1 limit ?, ?", int64(10), 20)
+	checkErr(t, err)
+	queryBase := query.(*QueryBase)
+	orderBy := queryBase.OrderBy()
+	if len(orderBy) != 2 {
+		t.Fatalf("cannot parse ORDER BY clause. %d columns", len(orderBy))
+	}
+	if orderBy[0].Name != "n" || !orderBy[0].Desc || orderBy[1].Position != 1 || orderBy[1].Desc {
+		t.Fatal("invalid ORDER BY columns")
+	}
+	limit, err := queryBase.Limit()
+	checkErr(t, err)
+	if limit.Offset != 10 || limit.Count != 20 || len(limit.ArgIndexes) != 2 {
+		t.Fatalf("invalid LIMIT clause %+v", limit)
+	}
+	for _, text := range []string{
+		"select id from users order by name",
+		"select id from users order by length(name)",
+	} {
+		query, err := parser.Parse(text)
+		checkErr(t, err)
+		if query.(*QueryBase).OrderBy() != nil {
+			t.Fatalf("%s cannot be sorted by merged rows", text)
+		}
+	}
+	query, err = parser.Parse("select * from users order by name limit 5")
+	checkErr(t, err)
+	if query.(*QueryBase).OrderBy() == nil {
+		t.Fatal("cannot parse ORDER BY clause with '*'")
+	}
+	limit, err = query.(*QueryBase).Limit()
+	checkErr(t, err)
+	if limit.Offset != 0 || limit.Count != 5 || len(limit.ArgIndexes) != 0 {
+		t.Fatalf("invalid LIMIT clause %+v", limit)
+	}
+}
+
+func TestOrderByWithHiddenColumns(t *testing.T) {
+	parser, err := New()
+	checkErr(t, err)
+	query, err := parser.Parse("select id from users order by id, length(name) desc, created_at limit 10")
+	checkErr(t, err)
+	stmt, orderBy, hidden := query.(*QueryBase).OrderByWithHiddenColumns()
+	if stmt == nil || hidden != 2 || len(orderBy) != 3 {
+		t.Fatalf("cannot add hidden columns. %d hidden columns", hidden)
+	}
+	if orderBy[0].Name != "id" || orderBy[1].Name != "octillery_order_by_0" || !orderBy[1].Desc || orderBy[2].Name != "octillery_order_by_1" {
+		t.Fatal("invalid ORDER BY columns")
+	}
+	expected := "select id, length(name) as octillery_order_by_0, created_at as octillery_order_by_1 from users order by id asc, length(name) desc, created_at asc limit 10"
+	if text := StringWithPlaceholder(stmt); text != expected {
+		t.Fatalf("invalid query for shards. expected %s but got %s", expected, text)
+	}
+	if query.(*QueryBase).Text != "select id from users order by id, length(name) desc, created_at limit 10" {
+		t.Fatal("original query must not be changed")
+	}
+	for _, text := range []string{
+		"select id from users",
+		"select distinct id from users order by name",
+		"select id, count(*) from users group by id order by name",
+		"select id from users order by field(id, ?, ?)",
+	} {
+		query, err := parser.Parse(text, 1, 2)
+		checkErr(t, err)
+		if stmt, _, _ := query.(*QueryBase).OrderByWithHiddenColumns(); stmt != nil {
+			t.Fatalf("%s cannot be sorted by hidden columns", text)
+		}
+	}
+}
+
+func TestShardKeyHint(t *testing.T) {
+	parser, err := New()
+	checkErr(t, err)
+	complexWhere := "select * from user_items /* octillery:shard_key=3 */ where (user_id = 3 and id = 1) or (user_id = 3 and id = 2)"
+	if _, err := parser.Parse(strings.Replace(complexWhere, "/* octillery:shard_key=3 */ ", "", 1)); err == nil {
+		t.Fatal("complex WHERE clause must not be parsed without hint")
+	}
+	for _, text := range []string{
+		complexWhere,
+		"/* octillery:shard_key=3 */ select * from user_items where user_id = 3",
+		"select /* octillery: shard_key=3, shard_key=3 */ * from user_items",
+		"update user_items /* octillery:shard_key=3 */ set id = 1 where user_id = 3 or user_id = 4",
+	} {
+		query, err := parser.Parse(text)
+		checkErr(t, err)
+		if query.(*QueryBase).ShardKeyID != 3 {
+			t.Fatalf("cannot get shard_key from hint of %s", text)
+		}
+	}
+	query, err := parser.Parse("delete from user_items /* octillery:shard_key=5 */")
+	checkErr(t, err)
+	if deleteQuery := query.(*DeleteQuery); deleteQuery.ShardKeyID != 5 || deleteQuery.IsAllShardQuery || deleteQuery.IsDeleteTable {
+		t.Fatal("cannot get shard_key from hint of DELETE query")
+	}
+	query, err = parser.Parse("select * from user_items where name = '/* octillery:shard_key=1 */'")
+	checkErr(t, err)
+	if !query.(*QueryBase).IsNotFoundShardKeyID() {
+		t.Fatal("comment in quoted text must be ignored")
+	}
+	for _, text := range []string{
+		"select * from user_items /* octillery:shard_key=abc */",
+		"select * from user_items /* octillery:shard_key=-1 */",
+		"select * from user_items /* octillery:shard_key=1.5 */",
+		"select * from user_items /* octillery:shard_key */",
+		"select * from user_items /* octillery:shard=1 */",
+		"select * from user_items /* octillery: */",
+		"select * from user_items /* octillery:shard_key=1 */ /* octillery:shard_key=2 */",
+		"select * from user_items /* octillery:shard_key=1 */ where user_id = 2",
+		"select * from user_stages /* octillery:shard_key=1 */",
+		"insert into user_items(id, user_id) values (1, 2) /* octillery:shard_key=2 */",
+	} {
+		if _, err := parser.Parse(text); err == nil {
+			t.Fatalf("cannot reject invalid hint of %s", text)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	statements, err := SplitStatements("insert into users(name) values ('a;b'); /* ; */ update users set name = ? where id = ?; -- ;\n;", "c", int64(1))
+	checkErr(t, err)
+	if len(statements) != 2 {
+		t.Fatalf("cannot split statements. got %d statements", len(statements))
+	}
+	if statements[0].Text != "insert into users(name) values ('a;b')" || len(statements[0].Args) != 0 {
+		t.Fatalf("invalid first statement %+v", statements[0])
+	}
+	if statements[1].Text != "/* ; */ update users set name = ? where id = ?" || len(statements[1].Args) != 2 {
+		t.Fatalf("invalid second statement %+v", statements[1])
+	}
+	statements, err = SplitStatements("select * from users where id = ?;", int64(1))
+	checkErr(t, err)
+	if len(statements) != 1 || statements[0].Text != "select * from users where id = ?" || len(statements[0].Args) != 1 {
+		t.Fatal("cannot split single statement")
+	}
+	if _, err := SplitStatements("select * from users where id = ?; select * from users", int64(1), int64(2)); err == nil {
+		t.Fatal("cannot detect mismatch of arguments")
+	}
+}
+
+type serializerTestAdapter struct {
+	enabled bool
+}
+
+func (a *serializerTestAdapter) CurrentSequenceID(conn *sql.DB, tableName string) (int64, error) {
+	return 0, nil
+}
+
+func (a *serializerTestAdapter) NextSequenceID(conn *sql.DB, tableName string) (int64, error) {
+	return 0, nil
+}
+
+func (a *serializerTestAdapter) ExecDDL(config *config.DatabaseConfig) error {
+	return nil
+}
+
+func (a *serializerTestAdapter) OpenConnection(config *config.DatabaseConfig, queryValues string) (*sql.DB, error) {
+	return nil, nil
+}
+
+func (a *serializerTestAdapter) CreateSequencerTableIfNotExists(conn *sql.DB, tableName string) error {
+	return nil
+}
+
+func (a *serializerTestAdapter) InsertRowToSequencerIfNotExists(conn *sql.DB, tableName string) error {
+	return nil
+}
+
+func (a *serializerTestAdapter) SerializeValue(value interface{}) (string, bool, bool) {
+	if !a.enabled {
+		return "", false, false
+	}
+	switch v := value.(type) {
+	case bool:
+		return fmt.Sprint(v), false, true
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05.999999-07:00"), true, true
+	}
+	return "", false, false
+}
+
+type serializerTestPoint struct {
+	x, y int
+}
+
+func TestValueSerializer(t *testing.T) {
+	serializerAdapter := &serializerTestAdapter{enabled: true}
+	adapter.Register("sqlite3", serializerAdapter)
+	defer func() {
+		serializerAdapter.enabled = false
+	}()
+	RegisterValueSerializer(func(value interface{}) (string, bool, bool) {
+		if point, ok := value.(serializerTestPoint); ok {
+			return fmt.Sprintf("POINT(%d %d)", point.x, point.y), true, true
+		}
+		return "", false, false
+	})
+	parser, err := New()
+	checkErr(t, err)
+	createdAt := time.Date(2019, 8, 1, 12, 0, 0, 0, time.FixedZone("JST", 9*60*60))
+	query, err := parser.Parse("insert into user_decks(id, bool, time, string) values (?, ?, ?, ?)", int64(4), true, createdAt, serializerTestPoint{x: 1, y: 2})
+	checkErr(t, err)
+	insertQuery := query.(*InsertQuery)
+	insertQuery.SetNextSequenceID(4) // simulate sequencer's action
+	expected := "insert into user_decks(id, bool, time, string) values (4, true, '2019-08-01 12:00:00+09:00', 'POINT(1 2)')"
+	if insertQuery.String() != expected {
+		t.Fatalf("cannot serialize values. expected %s but got %s", expected, insertQuery.String())
+	}
+}
+
+func TestUpdateShardKey(t *testing.T) {
+	parser, err := New()
+	checkErr(t, err)
+	for _, text := range []string{
+		"update user_items set user_id = 5 where user_id = 3",
+		"update user_items set user_id = 5 where id = 3",
+		"update user_items set user_id = user_id + 1 where user_id = 3",
+	} {
+		if _, err := parser.Parse(text); errors.Cause(err) != ErrShardKeyUpdated {
+			t.Fatalf("cannot reject %s. err = %v", text, err)
+		}
+	}
+	if _, err := parser.Parse("update user_items set user_id = ? where user_id = ?", int64(5), int64(3)); errors.Cause(err) != ErrShardKeyUpdated {
+		t.Fatalf("cannot reject update of shard_key by placeholder. err = %v", err)
+	}
+	query, err := parser.Parse("update user_items set user_id = ?, name = 'bob' where user_id = ?", int64(3), int64(3))
+	checkErr(t, err)
+	if query.(*QueryBase).ShardKeyID != 3 {
+		t.Fatal("cannot parse shard_key assigned the same value")
+	}
+	query, err = parser.Parse("update user_stages set id = 5 where id = 3")
+	checkErr(t, err)
+	if query.Table() != "user_stages" {
+		t.Fatal("cannot parse update for not sharded table")
+	}
+}
+
+func TestShardKeyMove(t *testing.T) {
+	parser, err := New()
+	checkErr(t, err)
+	parser.cfg.Tables["user_items"].MoveOnShardKeyUpdate = true
+	defer func() { parser.cfg.Tables["user_items"].MoveOnShardKeyUpdate = false }()
+
+	query, err := parser.Parse("update user_items set user_id = ?, name = 'bob', count = 3 where user_id = ? and name = ?", int64(5), int64(3), "alice")
+	checkErr(t, err)
+	move := query.(*QueryBase).ShardKeyMove
+	if move == nil || move.ShardKeyID != 5 {
+		t.Fatalf("cannot parse new shard_key. %+v", move)
+	}
+	if !reflect.DeepEqual(move.Values, []interface{}{int64(5), "bob", int64(3)}) {
+		t.Fatalf("cannot parse assigned values. %v", move.Values)
+	}
+	if value, _ := move.ValueByColumn("NAME"); value != "bob" {
+		t.Fatalf("cannot find assigned value by column. %v", value)
+	}
+	if move.Where != "user_id = ? and name = ?" || !reflect.DeepEqual(move.WhereArgs, []interface{}{int64(3), "alice"}) {
+		t.Fatalf("cannot parse WHERE clause. %s %v", move.Where, move.WhereArgs)
+	}
+	for _, text := range []string{
+		"update user_items set user_id = 5 where id = 3",
+		"update user_items set user_id = user_id + 1 where user_id = 3",
+		"update user_items set user_id = 5, count = count + 1 where user_id = 3",
+		"update user_items set user_id = 5 where user_id = 3 limit 1",
+	} {
+		if _, err := parser.Parse(text); errors.Cause(err) != ErrShardKeyUpdated {
+			t.Fatalf("cannot reject %s. err = %v", text, err)
+		}
+	}
+	query, err = parser.Parse("update user_items set name = 'bob' where user_id = 3")
+	checkErr(t, err)
+	if query.(*QueryBase).ShardKeyMove != nil {
+		t.Fatal("query not changing shard_key must not move rows")
+	}
+	t.Run("prepared statement", func(t *testing.T) {
+		text := "update user_items set user_id = ? where user_id = ?"
+		if _, err := parser.ParsePrepared(text); err != nil {
+			t.Fatalf("cannot prepare query moving rows by arguments. %+v", err)
+		}
+		if _, err := parser.Parse(text); errors.Cause(err) != ErrShardKeyUpdated {
+			t.Fatalf("cannot verify arguments after prepared. err = %v", err)
+		}
+	})
+}
+
+func TestShardKeyIn(t *testing.T) {
+	parser, err := New()
+	checkErr(t, err)
+	query, err := parser.Parse("select * from user_items where user_id in (1, ?, 3) and name = ?", int64(2), "bob")
+	checkErr(t, err)
+	queryBase := query.(*QueryBase)
+	if !queryBase.IsNotFoundShardKeyID() || fmt.Sprint(queryBase.ShardKeyIDs) != "[1 2 3]" {
+		t.Fatalf("cannot parse shard_key ids of IN clause. %v", queryBase.ShardKeyIDs)
+	}
+	t.Run("narrow to placeholder value", func(t *testing.T) {
+		narrowed, err := queryBase.NarrowShardKeyIDs([]Identifier{2})
+		checkErr(t, err)
+		if narrowed.Text != "select * from user_items where user_id in (?) and name = ?" {
+			t.Fatalf("cannot narrow IN clause. %s", narrowed.Text)
+		}
+		if fmt.Sprint(narrowed.Args) != "[2 bob]" {
+			t.Fatalf("cannot narrow arguments. %v", narrowed.Args)
+		}
+	})
+	t.Run("narrow to literal values", func(t *testing.T) {
+		narrowed, err := queryBase.NarrowShardKeyIDs([]Identifier{1, 3})
+		checkErr(t, err)
+		if narrowed.Text != "select * from user_items where user_id in (1, 3) and name = ?" {
+			t.Fatalf("cannot narrow IN clause. %s", narrowed.Text)
+		}
+		if fmt.Sprint(narrowed.Args) != "[bob]" {
+			t.Fatalf("cannot narrow arguments. %v", narrowed.Args)
+		}
+	})
+	t.Run("single value", func(t *testing.T) {
+		query, err := parser.Parse("select * from user_items where user_id in (5, 5)")
+		checkErr(t, err)
+		if query.(*QueryBase).ShardKeyID != 5 {
+			t.Fatal("cannot parse shard_key of IN clause has single value")
+		}
+	})
+	t.Run("not in", func(t *testing.T) {
+		query, err := parser.Parse("select * from user_items where user_id not in (1, 2)")
+		checkErr(t, err)
+		if !query.(*QueryBase).IsNotFoundShardKeyID() || len(query.(*QueryBase).ShardKeyIDs) != 0 {
+			t.Fatal("NOT IN clause must be executed for all shards")
+		}
+	})
+}
+
+func TestTimeShardKey(t *testing.T) {
+	parser, err := New()
+	checkErr(t, err)
+	createdAt := time.Date(2020, 3, 15, 12, 0, 0, 0, time.UTC)
+	query, err := parser.Parse("select * from user_profiles where user_id = ?", createdAt)
+	checkErr(t, err)
+	if query.(*QueryBase).ShardKeyID != Identifier(createdAt.Unix()) {
+		t.Fatalf("cannot parse time value as shard_key. %d", query.(*QueryBase).ShardKeyID)
+	}
+	query, err = parser.Parse("insert into user_profiles(user_id, name) values (?, ?)", &createdAt, "bob")
+	checkErr(t, err)
+	insertQuery := query.(*InsertQuery)
+	if insertQuery.ShardKeyID != Identifier(createdAt.Unix()) {
+		t.Fatalf("cannot parse time value as shard_key of INSERT. %d", insertQuery.ShardKeyID)
+	}
+	if insertQuery.String() != "insert into user_profiles(user_id, name) values ('2020-03-15 12:00:00', 'bob')" {
+		t.Fatalf("time value must be written as it is. %s", insertQuery.String())
+	}
+	var nilTime *time.Time
+	if _, err := parser.Parse("insert into user_profiles(user_id, name) values (?, ?)", nilTime, "bob"); err == nil {
+		t.Fatal("cannot handle error")
+	}
+}
+
 func TestINSERT(t *testing.T) {
 	t.Run("sharding table", func(t *testing.T) {
 		testINSERTWithShardingTable(t)
@@ -768,6 +1241,60 @@ func TestINSERT(t *testing.T) {
 	})
 }
 
+func TestMultiRowsINSERT(t *testing.T) {
+	parser, err := New()
+	checkErr(t, err)
+	t.Run("rows with different shard_key", func(t *testing.T) {
+		text := "insert into user_items(id, user_id, is_deleted) values (null, ?, 0), (null, 2, ?), (null, ?, 1)"
+		query, err := parser.Parse(text, int64(1), true, int64(3))
+		checkErr(t, err)
+		insertQuery := query.(*InsertQuery)
+		if !insertQuery.IsMultiRows() || len(insertQuery.RowQueries) != 3 {
+			t.Fatal("cannot split rows")
+		}
+		if insertQuery.ShardKeyID != UnknownID {
+			t.Fatal("shard_key of query must be unknown if rows have different shard_key")
+		}
+		for idx, row := range insertQuery.RowQueries {
+			if row.ShardKeyID != Identifier(idx+1) {
+				t.Fatalf("cannot parse shard_key of %d-th row. %d", idx+1, row.ShardKeyID)
+			}
+		}
+		if insertQuery.RowQueries[1].String() != "insert into user_items(id, user_id, is_deleted) values (null, 2, 1)" {
+			t.Fatalf("cannot generate row query. %s", insertQuery.RowQueries[1].String())
+		}
+		bulk := insertQuery.BulkString([]*InsertQuery{insertQuery.RowQueries[0], insertQuery.RowQueries[2]})
+		if bulk != "insert into user_items(id, user_id, is_deleted) values (null, 1, 0), (null, 3, 1)" {
+			t.Fatalf("cannot generate bulk query. %s", bulk)
+		}
+	})
+	t.Run("rows with the same shard_key", func(t *testing.T) {
+		query, err := parser.Parse("insert into user_items(id, user_id) values (null, 1), (null, 1)")
+		checkErr(t, err)
+		insertQuery := query.(*InsertQuery)
+		if !insertQuery.IsMultiRows() || insertQuery.ShardKeyID != 1 {
+			t.Fatal("cannot parse shard_key of rows")
+		}
+	})
+	t.Run("rows numbered by sequencer", func(t *testing.T) {
+		query, err := parser.Parse("insert into users(id, name) values (null, 'alice'), (null, 'bob')")
+		checkErr(t, err)
+		insertQuery := query.(*InsertQuery)
+		insertQuery.RowQueries[0].SetNextSequenceID(10)
+		insertQuery.RowQueries[1].SetNextSequenceID(11)
+		if insertQuery.BulkString(insertQuery.RowQueries) != "insert into users(id, name) values (10, 'alice'), (11, 'bob')" {
+			t.Fatalf("cannot generate bulk query. %s", insertQuery.BulkString(insertQuery.RowQueries))
+		}
+	})
+	t.Run("not sharding table", func(t *testing.T) {
+		query, err := parser.Parse("insert into user_stages(id, name) values (null, 'alice'), (null, 'bob')")
+		checkErr(t, err)
+		if query.(*InsertQuery).IsMultiRows() {
+			t.Fatal("rows of not sharding table must not be split")
+		}
+	})
+}
+
 func testUpdateWithShardColumnTable(t *testing.T, tableName string) {
 	parser, err := New()
 	checkErr(t, err)
@@ -962,6 +1489,285 @@ func TestDELETE(t *testing.T) {
 	})
 }
 
+func TestRETURNING(t *testing.T) {
+	parser, err := New()
+	checkErr(t, err)
+	t.Run("insert query", func(t *testing.T) {
+		query, err := parser.Parse("insert into users(id, name) values (null, 'bob') RETURNING id, name")
+		checkErr(t, err)
+		insertQuery := query.(*InsertQuery)
+		if insertQuery.Returning != "id, name" {
+			t.Fatalf("cannot parse returning clause. %s", insertQuery.Returning)
+		}
+		insertQuery.SetNextSequenceID(1)
+		if insertQuery.String() != "insert into users(id, name) values (1, 'bob') returning id, name" {
+			t.Fatalf("cannot format query. %s", insertQuery.String())
+		}
+	})
+	t.Run("update query", func(t *testing.T) {
+		query, err := parser.Parse("update user_items set name = 'returning' where user_id = ? returning id", int64(1))
+		checkErr(t, err)
+		updateQuery := query.(*QueryBase)
+		if updateQuery.Returning != "id" {
+			t.Fatalf("cannot parse returning clause. %s", updateQuery.Returning)
+		}
+		if updateQuery.ShardKeyID != 1 {
+			t.Fatal("cannot parse")
+		}
+	})
+	t.Run("delete query", func(t *testing.T) {
+		query, err := parser.Parse("delete from users where id = 1 returning *")
+		checkErr(t, err)
+		if !query.(*DeleteQuery).IsReturning() {
+			t.Fatal("cannot parse returning clause")
+		}
+	})
+	t.Run("query without returning", func(t *testing.T) {
+		query, err := parser.Parse("update users set name = 'alice' where id = 1")
+		checkErr(t, err)
+		if query.(*QueryBase).IsReturning() {
+			t.Fatal("invalid returning clause")
+		}
+	})
+	t.Run("returning in literal or comment", func(t *testing.T) {
+		for _, queryText := range []string{
+			"update users set name = 'free returning policy' where id = 1",
+			"insert into users(name) values ('a returning b')",
+			"delete from users where name = 'x returning y' /* returning id */",
+			"update users set `returning` = 1 where id = 1",
+		} {
+			query, err := parser.Parse(queryText)
+			checkErr(t, err)
+			if query.(interface{ IsReturning() bool }).IsReturning() {
+				t.Fatalf("invalid returning clause of %s", queryText)
+			}
+		}
+		query, err := parser.Parse("insert into users(id, name) values (null, 'a returning b') returning id")
+		checkErr(t, err)
+		insertQuery := query.(*InsertQuery)
+		insertQuery.SetNextSequenceID(1)
+		if insertQuery.Returning != "id" ||
+			insertQuery.String() != "insert into users(id, name) values (1, 'a returning b') returning id" {
+			t.Fatalf("cannot parse returning clause. %s", insertQuery.String())
+		}
+	})
+}
+
+func TestUniqueColumnUpdate(t *testing.T) {
+	parser, err := New()
+	checkErr(t, err)
+	query, err := parser.Parse("update user_accounts set email = ?, name = 'bob' where user_id = ? limit 1", "bob@example.com", int64(1))
+	checkErr(t, err)
+	update := query.(*QueryBase).UniqueColumnUpdate
+	if update == nil ||
+		!reflect.DeepEqual(update.Columns, []string{"email"}) ||
+		!reflect.DeepEqual(update.Values, []interface{}{"bob@example.com"}) ||
+		update.Condition != " where user_id = ? limit 1" ||
+		!reflect.DeepEqual(update.ConditionArgs, []interface{}{int64(1)}) {
+		t.Fatalf("cannot parse values of unique column %+v", update)
+	}
+	query, err = parser.Parse("update user_accounts set name = 'bob' where user_id = 1")
+	checkErr(t, err)
+	if query.(*QueryBase).UniqueColumnUpdate != nil {
+		t.Fatal("invalid values of unique column")
+	}
+	if _, err := parser.Parse("update user_accounts set email = lower(email) where user_id = 1"); errors.Cause(err) != ErrUniqueColumnUpdated {
+		t.Fatalf("cannot reject expression assigned to unique column. err = %v", err)
+	}
+	if _, err := parser.ParsePrepared("update user_accounts set email = ? where user_id = ?"); err != nil {
+		t.Fatalf("cannot prepare query assigning unique column by arguments. %+v", err)
+	}
+	query, err = parser.Parse("delete from user_accounts where user_id = ? order by id limit 1", int64(1))
+	checkErr(t, err)
+	if condition := query.(*DeleteQuery).Condition(); condition != " where user_id = ? order by id asc limit 1" {
+		t.Fatalf("invalid condition of delete query %s", condition)
+	}
+}
+
+func TestSAVEPOINT(t *testing.T) {
+	parser, err := New()
+	checkErr(t, err)
+	for queryText, queryType := range map[string]QueryType{
+		"SAVEPOINT sp1":             Savepoint,
+		"savepoint `sp1`;":          Savepoint,
+		"RELEASE SAVEPOINT sp1":     ReleaseSavepoint,
+		"release sp1":               ReleaseSavepoint,
+		"ROLLBACK TO SAVEPOINT sp1": RollbackToSavepoint,
+		"rollback work to \"sp1\"":  RollbackToSavepoint,
+	} {
+		query, err := parser.Parse(queryText)
+		checkErr(t, err)
+		if query.QueryType() != queryType {
+			t.Fatalf("cannot parse query type of %s", queryText)
+		}
+		if query.(*SavepointQuery).Name != "sp1" {
+			t.Fatalf("cannot parse savepoint name of %s", queryText)
+		}
+	}
+	if _, ok := ParseSavepoint("ROLLBACK"); ok {
+		t.Fatal("ROLLBACK is not savepoint statement")
+	}
+}
+
+func TestTemporaryTable(t *testing.T) {
+	parser, err := New()
+	checkErr(t, err)
+	for queryText, queryType := range map[string]QueryType{
+		"CREATE TEMPORARY TABLE tmp_users (id integer)":                      CreateTemporaryTable,
+		"create temp table if not exists `tmp_users` as select * from users": CreateTemporaryTable,
+		"DROP TEMPORARY TABLE tmp_users":                                     DropTemporaryTable,
+		"drop temporary table if exists \"tmp_users\";":                      DropTemporaryTable,
+	} {
+		query, err := parser.Parse(queryText)
+		checkErr(t, err)
+		if query.QueryType() != queryType {
+			t.Fatalf("cannot parse query type of %s", queryText)
+		}
+		if query.Table() != "tmp_users" {
+			t.Fatalf("cannot parse table name of %s", queryText)
+		}
+		if query.(*TemporaryTableQuery).IfExists != strings.Contains(strings.ToLower(queryText), "exists") {
+			t.Fatalf("cannot parse IF EXISTS of %s", queryText)
+		}
+	}
+	if _, ok := ParseTemporaryTable("CREATE TABLE tmp_users (id integer)"); ok {
+		t.Fatal("CREATE TABLE is not temporary table statement")
+	}
+}
+
+func TestLockingRead(t *testing.T) {
+	parser, err := New()
+	checkErr(t, err)
+	for queryText, lock := range map[string]string{
+		"SELECT * FROM users WHERE id = 1 FOR UPDATE":                 "for update",
+		"select * from users where id = 1 lock in share mode;":        "lock in share mode",
+		"SELECT * FROM users WHERE id = 1 FOR SHARE":                  "for share",
+		"SELECT * FROM users WHERE id = 1 for  update\n  SKIP LOCKED": "for update skip locked",
+		"SELECT * FROM users WHERE id = 1 FOR UPDATE NOWAIT":          "for update nowait",
+	} {
+		query, err := parser.Parse(queryText)
+		checkErr(t, err)
+		queryBase := query.(*QueryBase)
+		if queryBase.Lock != lock || !IsLockingRead(query) || IsSlaveReadable(query) {
+			t.Fatalf("cannot parse locking clause of %s. lock = %s", queryText, queryBase.Lock)
+		}
+		if queryBase.ShardKeyID != 1 {
+			t.Fatalf("cannot parse shard_key of %s", queryText)
+		}
+		if queryBase.Text != queryText {
+			t.Fatalf("locking clause must be preserved in query text %s", queryBase.Text)
+		}
+		if text := queryBase.TextWithLock(""); text != "SELECT * FROM users WHERE id = 1" && text != "select * from users where id = 1" {
+			t.Fatalf("cannot remove locking clause %s", text)
+		}
+		if text := queryBase.TextWithLock("for update"); !strings.HasSuffix(text, "id = 1 for update") {
+			t.Fatalf("cannot replace locking clause %s", text)
+		}
+	}
+	query, err := parser.Parse("SELECT * FROM users WHERE id = 1")
+	checkErr(t, err)
+	if IsLockingRead(query) || !IsSlaveReadable(query) {
+		t.Fatal("query without locking clause is not locking read")
+	}
+	t.Run("narrowed IN clause", func(t *testing.T) {
+		query, err := parser.Parse("SELECT * FROM user_items WHERE user_id IN (1, 2, 3) FOR UPDATE")
+		checkErr(t, err)
+		narrowed, err := query.(*QueryBase).NarrowShardKeyIDs([]Identifier{1, 3})
+		checkErr(t, err)
+		if narrowed.Lock != "for update" || !strings.HasSuffix(narrowed.Text, " for update") {
+			t.Fatalf("cannot keep locking clause of narrowed query %s", narrowed.Text)
+		}
+	})
+	if _, err := parser.Parse("DELETE FROM users WHERE id = 1 FOR UPDATE"); err == nil {
+		t.Fatal("locking clause must be rejected for query except SELECT")
+	}
+}
+
+func TestNamedArgs(t *testing.T) {
+	parser, err := New()
+	checkErr(t, err)
+	t.Run("shard key", func(t *testing.T) {
+		for _, text := range []string{
+			"update users set name = :name where id = :id",
+			"update users set name = @name where id = @id",
+		} {
+			query, err := parser.Parse(text, sql.Named("id", int64(3)), sql.Named("name", "bob"))
+			checkErr(t, err)
+			queryBase := query.(*QueryBase)
+			if queryBase.ShardKeyID != 3 || queryBase.ShardKeyIDPlaceholderIndex != 2 {
+				t.Fatalf("cannot parse shard_key of %s", text)
+			}
+			if queryBase.Text != "update users set name = ? where id = ?" {
+				t.Fatalf("cannot bind named arguments. %s", queryBase.Text)
+			}
+			if len(queryBase.Args) != 2 || queryBase.Args[0] != "bob" || queryBase.Args[1] != int64(3) {
+				t.Fatalf("cannot arrange arguments. %v", queryBase.Args)
+			}
+		}
+	})
+	t.Run("mixed with positional placeholder", func(t *testing.T) {
+		text, args, err := BindNamedArgs(
+			"select ':id', @@autocommit, @var, created_at::date from users where id = :id and name = ?",
+			[]interface{}{sql.Named("id", int64(1)), "bob"},
+		)
+		checkErr(t, err)
+		if text != "select ':id', @@autocommit, @var, created_at::date from users where id = ? and name = ?" {
+			t.Fatalf("cannot bind named arguments. %s", text)
+		}
+		if len(args) != 2 || args[0] != int64(1) || args[1] != "bob" {
+			t.Fatalf("cannot arrange arguments. %v", args)
+		}
+	})
+	t.Run("invalid arguments", func(t *testing.T) {
+		if _, _, err := BindNamedArgs("select * from users where id = :id", []interface{}{sql.Named("user_id", 1)}); err == nil {
+			t.Fatal("cannot handle unknown named argument")
+		}
+		if _, _, err := BindNamedArgs("select * from users where id = :id and name = ?", []interface{}{sql.Named("id", 1)}); err == nil {
+			t.Fatal("cannot handle missing positional argument")
+		}
+	})
+}
+
+func TestDollarPlaceholder(t *testing.T) {
+	parser, err := New()
+	checkErr(t, err)
+	t.Run("shard key", func(t *testing.T) {
+		query, err := parser.Parse("update users set name = $2, memo = '$1' where id = $1", int64(3), "bob")
+		checkErr(t, err)
+		queryBase := query.(*QueryBase)
+		if queryBase.ShardKeyID != 3 || !queryBase.DollarPlaceholder {
+			t.Fatal("cannot parse shard_key by $1 placeholder")
+		}
+		if queryBase.Text != "update users set name = ?, memo = '$1' where id = ?" {
+			t.Fatalf("cannot replace placeholders. %s", queryBase.Text)
+		}
+		if len(queryBase.Args) != 2 || queryBase.Args[0] != "bob" || queryBase.Args[1] != int64(3) {
+			t.Fatalf("cannot arrange arguments. %v", queryBase.Args)
+		}
+		if text := ReplacePlaceholderToDollar(queryBase.Text); text != "update users set name = $1, memo = '$1' where id = $2" {
+			t.Fatalf("cannot restore placeholders. %s", text)
+		}
+	})
+	t.Run("in clause", func(t *testing.T) {
+		query, err := parser.Parse("select * from users where id in ($1, $2)", int64(1), int64(2))
+		checkErr(t, err)
+		if ids := query.(*QueryBase).ShardKeyIDs; len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+			t.Fatalf("cannot parse shard_key ids by $1 placeholder. %v", ids)
+		}
+		if !IsDollarPlaceholder(query) {
+			t.Fatal("cannot detect $1 placeholder")
+		}
+	})
+	t.Run("invalid placeholder", func(t *testing.T) {
+		if _, err := parser.Parse("select * from users where id = $2", int64(1)); err == nil {
+			t.Fatal("cannot handle placeholder without argument")
+		}
+		if _, err := parser.Parse("select * from users where id = $1 and name = ?", int64(1), "bob"); err == nil {
+			t.Fatal("cannot handle mixed placeholders")
+		}
+	})
+}
+
 func TestERROR(t *testing.T) {
 	parser, err := New()
 	checkErr(t, err)
@@ -0,0 +1,110 @@
+package sqlparser
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Statement a statement of multi statement query ( e.g. 'stmt1; stmt2' )
+type Statement struct {
+	// query text of statement without semicolon
+	Text string
+	// query arguments used by placeholders of statement
+	Args []interface{}
+}
+
+// SplitStatements splits query text by semicolons except in quoted text or comments,
+// and distributes query arguments to each statement in order of placeholders.
+// Empty statements ( e.g. after last semicolon ) are removed.
+func SplitStatements(queryText string, args ...interface{}) ([]*Statement, error) {
+	texts, placeholderCounts := splitStatementTexts(queryText)
+	if len(texts) <= 1 {
+		// single statement takes all arguments as is
+		text := strings.TrimSpace(queryText)
+		if len(texts) == 1 {
+			text = texts[0]
+		}
+		return []*Statement{{Text: text, Args: args}}, nil
+	}
+	totalCount := 0
+	for _, count := range placeholderCounts {
+		totalCount += count
+	}
+	if totalCount != len(args) {
+		return nil, errors.Errorf("multi statement query has %d placeholders but got %d arguments", totalCount, len(args))
+	}
+	statements := make([]*Statement, 0, len(texts))
+	argIndex := 0
+	for idx, text := range texts {
+		count := placeholderCounts[idx]
+		statements = append(statements, &Statement{
+			Text: text,
+			Args: args[argIndex : argIndex+count],
+		})
+		argIndex += count
+	}
+	return statements, nil
+}
+
+// splitStatementTexts returns non-empty statements and number of placeholders in each statement
+func splitStatementTexts(queryText string) ([]string, []int) {
+	var (
+		texts            []string
+		counts           []int
+		quote            byte
+		start            int
+		placeholderCount int
+		// whether current statement has text except spaces and comments
+		hasContent bool
+	)
+	appendStatement := func(end int) {
+		if hasContent {
+			texts = append(texts, strings.TrimSpace(queryText[start:end]))
+			counts = append(counts, placeholderCount)
+		}
+		placeholderCount = 0
+		hasContent = false
+	}
+	for i := 0; i < len(queryText); i++ {
+		c := queryText[i]
+		if quote == 0 && c != ';' && !isSpace(c) && !strings.HasPrefix(queryText[i:], "--") && !strings.HasPrefix(queryText[i:], "/*") {
+			hasContent = true
+		}
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '-' && strings.HasPrefix(queryText[i:], "--"):
+			if end := strings.IndexByte(queryText[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(queryText)
+			}
+		case c == '/' && strings.HasPrefix(queryText[i:], "/*"):
+			if end := strings.Index(queryText[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(queryText)
+			}
+		case c == '?':
+			placeholderCount++
+		case c == ';':
+			appendStatement(i)
+			start = i + 1
+		}
+	}
+	if start < len(queryText) {
+		appendStatement(len(queryText))
+	}
+	return texts, counts
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
@@ -0,0 +1,39 @@
+package sqlparser
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	createTemporaryTablePattern = regexp.MustCompile("(?is)^\\s*CREATE\\s+TEMP(?:ORARY)?\\s+TABLE\\s+(IF\\s+NOT\\s+EXISTS\\s+)?`?(\\w+)`?")
+	dropTemporaryTablePattern   = regexp.MustCompile("(?is)^\\s*DROP\\s+TEMPORARY\\s+TABLE\\s+(IF\\s+EXISTS\\s+)?`?(\\w+)`?\\s*;?\\s*$")
+)
+
+// TemporaryTableQuery a implementation of Query interface for 'CREATE TEMPORARY TABLE' and 'DROP TEMPORARY TABLE'.
+// Temporary table is not defined in configuration file, so it is created on a database accessed by transaction.
+type TemporaryTableQuery struct {
+	*QueryBase
+	// true if query has 'IF NOT EXISTS' or 'IF EXISTS'
+	IfExists bool
+}
+
+// ParseTemporaryTable parses statement creating or dropping temporary table.
+// vitess-sqlparser doesn't support it, so it is parsed from query text. If query is not such statement, returns false.
+func ParseTemporaryTable(queryText string, args ...interface{}) (*TemporaryTableQuery, bool) {
+	formattedQueryText := strings.Replace(queryText, `"`, "`", -1)
+	for queryType, pattern := range map[QueryType]*regexp.Regexp{
+		CreateTemporaryTable: createTemporaryTablePattern,
+		DropTemporaryTable:   dropTemporaryTablePattern,
+	} {
+		matched := pattern.FindStringSubmatch(formattedQueryText)
+		if len(matched) == 0 {
+			continue
+		}
+		queryBase := NewQueryBase(nil, queryText, args)
+		queryBase.Type = queryType
+		queryBase.TableName = matched[2]
+		return &TemporaryTableQuery{QueryBase: queryBase, IfExists: matched[1] != ""}, true
+	}
+	return nil, false
+}
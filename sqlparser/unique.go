@@ -0,0 +1,67 @@
+package sqlparser
+
+import (
+	"strings"
+
+	vtparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/pkg/errors"
+)
+
+// UniqueColumnUpdate values of 'unique_columns' assigned by SET clause of UPDATE query.
+// Assigned values are reserved before execution, and current values of updated rows are released after it.
+type UniqueColumnUpdate struct {
+	// unique columns assigned by SET clause
+	Columns []string
+	// values assigned to Columns. placeholders are replaced by query arguments, and nil means NULL
+	Values []interface{}
+	// WHERE, ORDER BY and LIMIT clauses select updated rows ( e.g. ' where id = ?' )
+	Condition string
+	// arguments of placeholders in Condition
+	ConditionArgs []interface{}
+}
+
+// Condition returns WHERE, ORDER BY and LIMIT clauses select deleted rows ( e.g. ' where id = ?' ).
+// Placeholders in them are the same as query, so Args are used as arguments of them.
+func (q *DeleteQuery) Condition() string {
+	return conditionClauses(q.Stmt.Where, q.Stmt.OrderBy, q.Stmt.Limit)
+}
+
+func conditionClauses(where *vtparser.Where, orderBy vtparser.OrderBy, limit *vtparser.Limit) string {
+	return StringWithPlaceholder(where) + StringWithPlaceholder(orderBy) + StringWithPlaceholder(limit)
+}
+
+// parseUniqueColumnUpdate parses values of 'unique_columns' assigned by UPDATE query.
+// Values must be decided by query to reserve them before execution, so they must be literal or placeholder.
+func (p *Parser) parseUniqueColumnUpdate(stmt *vtparser.Update, queryBase *QueryBase) (*UniqueColumnUpdate, error) {
+	table, exists := p.cfg.Tables[queryBase.TableName]
+	if !exists || len(table.UniqueColumns) == 0 {
+		return nil, nil
+	}
+	update := &UniqueColumnUpdate{}
+	for _, updateExpr := range stmt.Exprs {
+		column := updateExpr.Name.Name.String()
+		isUniqueColumn := false
+		for _, uniqueColumn := range table.UniqueColumns {
+			isUniqueColumn = isUniqueColumn || strings.EqualFold(uniqueColumn, column)
+		}
+		if !isUniqueColumn {
+			continue
+		}
+		value, err := p.parseAssignedValue(updateExpr.Expr, queryBase.Args)
+		if err != nil {
+			return nil, errors.Wrapf(ErrUniqueColumnUpdated, "value of %s.%s must be literal or placeholder: %s", queryBase.TableName, column, err)
+		}
+		update.Columns = append(update.Columns, column)
+		update.Values = append(update.Values, value)
+	}
+	if len(update.Columns) == 0 {
+		return nil, nil
+	}
+	conditionArgs, err := p.placeholderArgs(queryBase.Args, stmt.Where, stmt.OrderBy, stmt.Limit)
+	if err != nil {
+		return nil, errors.Wrap(ErrUniqueColumnUpdated, err.Error())
+	}
+	update.Condition = conditionClauses(stmt.Where, stmt.OrderBy, stmt.Limit)
+	update.ConditionArgs = conditionArgs
+	return update, nil
+}
@@ -0,0 +1,171 @@
+package stats
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/connection"
+)
+
+// DefaultHotShardThreshold default ratio of rows to average rows of shards regarded as hot shard
+const DefaultHotShardThreshold = 1.5
+
+// DistributionOptions options for Distribution
+type DistributionOptions struct {
+	// if true, minimum and maximum value of shard key of each shard are fetched
+	WithShardKeyRange bool
+	// number of shards counted concurrently. 0 or 1 means sequential counting
+	Concurrency int
+}
+
+// ShardDistribution rows of a table in a shard
+type ShardDistribution struct {
+	// shard name defined in configuration file
+	ShardName string
+	// number of rows
+	RowCount int64
+	// ratio of RowCount to average rows of shards. 1 means rows are evenly distributed
+	Ratio float64
+	// minimum and maximum value of shard key. empty if WithShardKeyRange is false or shard has no rows
+	MinShardKey string
+	MaxShardKey string
+}
+
+// TableDistribution distribution of rows of a sharded table between shards
+type TableDistribution struct {
+	// table name
+	TableName string
+	// column name for deciding sharding target
+	ShardKeyColumnName string
+	// number of rows of all shards
+	RowCount int64
+	// distribution of each shard in order of shards in configuration file
+	Shards []*ShardDistribution
+	// ratio of rows of the most populated shard to average rows of shards.
+	// 1 means rows are evenly distributed, and zero if table has no rows
+	Skew float64
+}
+
+// HotShards returns shards whose Ratio is greater than or equal to threshold
+func (d *TableDistribution) HotShards(threshold float64) []*ShardDistribution {
+	shards := []*ShardDistribution{}
+	if d.RowCount == 0 {
+		return shards
+	}
+	for _, shard := range d.Shards {
+		if shard.Ratio >= threshold {
+			shards = append(shards, shard)
+		}
+	}
+	return shards
+}
+
+func (d *TableDistribution) String() string {
+	lines := []string{fmt.Sprintf("%s: %d rows in %d shards ( skew %.2f )", d.TableName, d.RowCount, len(d.Shards), d.Skew)}
+	for _, shard := range d.Shards {
+		line := fmt.Sprintf("  %s: %d rows ( ratio %.2f )", shard.ShardName, shard.RowCount, shard.Ratio)
+		if shard.MinShardKey != "" || shard.MaxShardKey != "" {
+			line += fmt.Sprintf(" %s: [%s, %s]", d.ShardKeyColumnName, shard.MinShardKey, shard.MaxShardKey)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Distribution counts rows of sharded table on each shard, and returns how rows are distributed between shards.
+// Skew is useful to detect hot shards produced by sharding algorithm ( e.g. modulo of sequential ids ).
+func Distribution(ctx context.Context, connMgr *connection.DBConnectionManager, tableName string, opts *DistributionOptions) (*TableDistribution, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if opts == nil {
+		opts = &DistributionOptions{}
+	}
+	conn, err := connMgr.ConnectionByTableName(tableName)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !conn.IsShard {
+		return nil, errors.Errorf("%s is not sharded table", tableName)
+	}
+	shardKey := connMgr.ShardKeyColumnName(tableName)
+	query := fmt.Sprintf("select count(*) from %s", tableName)
+	if opts.WithShardKeyRange {
+		query = fmt.Sprintf("select count(*), min(%[1]s), max(%[1]s) from %[2]s", shardKey, tableName)
+	}
+	shards := conn.Shards()
+	distribution := &TableDistribution{
+		TableName:          tableName,
+		ShardKeyColumnName: shardKey,
+		Shards:             make([]*ShardDistribution, len(shards)),
+	}
+	indexByShardName := map[string]int{}
+	for idx, shard := range shards {
+		indexByShardName[shard.ShardName] = idx
+		distribution.Shards[idx] = &ShardDistribution{ShardName: shard.ShardName}
+	}
+	if err := conn.ForEachShard(func(shard *connection.DBShardConnection) error {
+		result := distribution.Shards[indexByShardName[shard.ShardName]]
+		row := shard.Connection.QueryRowContext(ctx, query)
+		if !opts.WithShardKeyRange {
+			return errors.WithStack(row.Scan(&result.RowCount))
+		}
+		var min, max sql.NullString
+		if err := row.Scan(&result.RowCount, &min, &max); err != nil {
+			return errors.WithStack(err)
+		}
+		result.MinShardKey = min.String
+		result.MaxShardKey = max.String
+		return nil
+	}, &connection.ForEachShardOptions{Concurrency: opts.Concurrency, Context: ctx}); err != nil {
+		return nil, errors.Wrapf(err, "cannot count rows of %s", tableName)
+	}
+	for _, shard := range distribution.Shards {
+		distribution.RowCount += shard.RowCount
+	}
+	if distribution.RowCount == 0 {
+		return distribution, nil
+	}
+	avg := float64(distribution.RowCount) / float64(len(distribution.Shards))
+	for _, shard := range distribution.Shards {
+		shard.Ratio = float64(shard.RowCount) / avg
+		if shard.Ratio > distribution.Skew {
+			distribution.Skew = shard.Ratio
+		}
+	}
+	return distribution, nil
+}
+
+// Distributions returns distribution of rows of tables in order of tableNames.
+// If tableNames is empty, all sharded tables in configuration file are counted in order of table name.
+// Errors for each table are aggregated to MultiError.
+func Distributions(ctx context.Context, connMgr *connection.DBConnectionManager, opts *DistributionOptions, tableNames ...string) ([]*TableDistribution, error) {
+	if len(tableNames) == 0 {
+		cfg, err := config.Get()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for tableName, table := range cfg.Tables {
+			if table.IsShard {
+				tableNames = append(tableNames, tableName)
+			}
+		}
+		sort.Strings(tableNames)
+	}
+	distributions := []*TableDistribution{}
+	errs := &connection.MultiError{}
+	for _, tableName := range tableNames {
+		distribution, err := Distribution(ctx, connMgr, tableName, opts)
+		if err != nil {
+			errs.Add(err)
+			continue
+		}
+		distributions = append(distributions, distribution)
+	}
+	return distributions, errs.ErrorOrNil()
+}
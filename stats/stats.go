@@ -0,0 +1,311 @@
+// Package stats provides sampler of per-table statistics for estimating cost of query,
+// and report of distribution of rows between shards.
+package stats
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/connection"
+	"go.knocknote.io/octillery/debug"
+	"go.knocknote.io/octillery/sqlparser"
+)
+
+const (
+	// DefaultSamplingInterval default interval of sampling by Sampler.Start
+	DefaultSamplingInterval = time.Minute
+
+	// latencyWeight weight of new latency for exponential moving average
+	latencyWeight = 0.2
+)
+
+// ShardStats statistics of a table in a shard
+type ShardStats struct {
+	// shard name. this is empty if table is not sharded
+	ShardName string
+	// number of rows at last sampling
+	RowCount int64
+	// average latency of queries to this shard
+	AvgLatency time.Duration
+	// number of samples
+	Samples int64
+}
+
+// TableStats statistics of a table
+type TableStats struct {
+	// table name
+	TableName string
+	// statistics of each shard
+	Shards map[string]*ShardStats
+	// time of last sampling
+	SampledAt time.Time
+}
+
+// RowCount returns number of rows of all shards
+func (s *TableStats) RowCount() int64 {
+	var count int64
+	for _, shard := range s.Shards {
+		count += shard.RowCount
+	}
+	return count
+}
+
+// Estimation expected cost of query
+type Estimation struct {
+	// table name
+	TableName string
+	// number of shards touched by query
+	ShardNum int
+	// names of shards touched by query. this is empty if shard cannot be decided before execution ( e.g. INSERT by sequencer )
+	ShardNames []string
+	// whether query is executed for all shards or not
+	IsScatter bool
+	// upper bound of rows scanned by query ( sum of rows of touched shards )
+	EstimatedRows int64
+	// rough cost of query ( sum of average latency of touched shards )
+	EstimatedCost time.Duration
+	// whether statistics of table is sampled or not. if false, EstimatedRows and EstimatedCost are zero
+	IsSampled bool
+}
+
+func (e *Estimation) String() string {
+	if !e.IsSampled {
+		return fmt.Sprintf("table:%s shards:%d scatter:%t rows:unknown cost:unknown", e.TableName, e.ShardNum, e.IsScatter)
+	}
+	return fmt.Sprintf("table:%s shards:%d scatter:%t rows:%d cost:%s", e.TableName, e.ShardNum, e.IsScatter, e.EstimatedRows, e.EstimatedCost)
+}
+
+// Sampler records per-shard row count and average latency for each table periodically.
+type Sampler struct {
+	connMgr    *connection.DBConnectionManager
+	tableNames []string
+	mu         sync.RWMutex
+	stats      map[string]*TableStats
+	stopCh     chan struct{}
+	wg         sync.WaitGroup
+}
+
+// NewSampler creates instance of Sampler for tableNames.
+func NewSampler(connMgr *connection.DBConnectionManager, tableNames ...string) *Sampler {
+	return &Sampler{
+		connMgr:    connMgr,
+		tableNames: tableNames,
+		stats:      map[string]*TableStats{},
+	}
+}
+
+// Start starts sampling at intervals in background. If interval is zero, DefaultSamplingInterval is used.
+func (s *Sampler) Start(interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultSamplingInterval
+	}
+	s.mu.Lock()
+	if s.stopCh != nil {
+		s.mu.Unlock()
+		return
+	}
+	stopCh := make(chan struct{})
+	s.stopCh = stopCh
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := s.Sample(context.Background()); err != nil {
+				debug.Printf("failed to sample statistics: %+v", err)
+			}
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops sampling started by Start
+func (s *Sampler) Stop() {
+	s.mu.Lock()
+	stopCh := s.stopCh
+	s.stopCh = nil
+	s.mu.Unlock()
+	if stopCh == nil {
+		return
+	}
+	close(stopCh)
+	s.wg.Wait()
+}
+
+// Sample records statistics of all tables once.
+func (s *Sampler) Sample(ctx context.Context) error {
+	errs := &connection.MultiError{}
+	for _, tableName := range s.tableNames {
+		errs.Add(s.sampleTable(ctx, tableName))
+	}
+	return errs.ErrorOrNil()
+}
+
+func (s *Sampler) sampleTable(ctx context.Context, tableName string) error {
+	conn, err := s.connMgr.ConnectionByTableName(tableName)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	query := fmt.Sprintf("select count(*) from %s", tableName)
+	errs := &connection.MultiError{}
+	for _, shard := range conn.Shards() {
+		var count int64
+		start := time.Now()
+		if err := shard.Connection.QueryRowContext(ctx, query).Scan(&count); err != nil {
+			errs.AddShardError(shard.ShardName, shard.DSN(), err)
+			continue
+		}
+		s.record(tableName, shard.ShardName, count, time.Since(start))
+	}
+	return errs.ErrorOrNil()
+}
+
+func (s *Sampler) record(tableName string, shardName string, rowCount int64, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tableStats, exists := s.stats[tableName]
+	if !exists {
+		tableStats = &TableStats{TableName: tableName, Shards: map[string]*ShardStats{}}
+		s.stats[tableName] = tableStats
+	}
+	shardStats, exists := tableStats.Shards[shardName]
+	if !exists {
+		shardStats = &ShardStats{ShardName: shardName, AvgLatency: latency}
+		tableStats.Shards[shardName] = shardStats
+	}
+	shardStats.RowCount = rowCount
+	shardStats.AvgLatency = movingAverage(shardStats.AvgLatency, latency)
+	shardStats.Samples++
+	tableStats.SampledAt = time.Now()
+}
+
+// RecordLatency records latency of query executed to shard.
+// Applications can call this for improving accuracy of average latency.
+func (s *Sampler) RecordLatency(tableName string, shardName string, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tableStats, exists := s.stats[tableName]
+	if !exists {
+		return
+	}
+	shardStats, exists := tableStats.Shards[shardName]
+	if !exists {
+		return
+	}
+	shardStats.AvgLatency = movingAverage(shardStats.AvgLatency, latency)
+}
+
+func movingAverage(avg time.Duration, latency time.Duration) time.Duration {
+	return time.Duration(float64(avg)*(1-latencyWeight) + float64(latency)*latencyWeight)
+}
+
+// TableStats returns copy of statistics of table. if table is not sampled yet, returns nil.
+func (s *Sampler) TableStats(tableName string) *TableStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tableStats, exists := s.stats[tableName]
+	if !exists {
+		return nil
+	}
+	copied := &TableStats{
+		TableName: tableStats.TableName,
+		Shards:    make(map[string]*ShardStats, len(tableStats.Shards)),
+		SampledAt: tableStats.SampledAt,
+	}
+	for shardName, shardStats := range tableStats.Shards {
+		shard := *shardStats
+		copied.Shards[shardName] = &shard
+	}
+	return copied
+}
+
+// Estimate returns expected shards touched by query and rough cost of it by sampled statistics.
+func (s *Sampler) Estimate(queryText string, args ...interface{}) (*Estimation, error) {
+	parser, err := sqlparser.New()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	query, err := parser.Parse(queryText, args...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	conn, err := s.connMgr.ConnectionByTableName(query.Table())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	estimation := &Estimation{TableName: query.Table()}
+	shards := []*connection.DBShardConnection{}
+	shardKeyID := shardKeyIDByQuery(query)
+	switch {
+	case !conn.IsShard:
+		shards = conn.Shards()
+	case shardKeyID != sqlparser.UnknownID:
+		shard, err := conn.ShardConnectionByID(int64(shardKeyID))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		shards = append(shards, shard)
+	case query.QueryType() == sqlparser.Insert:
+		// shard is decided by id published by sequencer at execution
+		estimation.ShardNum = 1
+	default:
+		shards = conn.Shards()
+		estimation.IsScatter = true
+	}
+	if len(shards) > 0 {
+		estimation.ShardNum = len(shards)
+	}
+	for _, shard := range shards {
+		estimation.ShardNames = append(estimation.ShardNames, shard.ShardName)
+	}
+	sort.Strings(estimation.ShardNames)
+
+	tableStats := s.TableStats(query.Table())
+	if tableStats == nil {
+		return estimation, nil
+	}
+	estimation.IsSampled = true
+	if len(shards) == 0 {
+		// use average of all shards
+		if num := int64(len(tableStats.Shards)); num > 0 {
+			var cost time.Duration
+			for _, shardStats := range tableStats.Shards {
+				cost += shardStats.AvgLatency
+			}
+			estimation.EstimatedCost = cost / time.Duration(num)
+		}
+		return estimation, nil
+	}
+	for _, shard := range shards {
+		shardStats, exists := tableStats.Shards[shard.ShardName]
+		if !exists {
+			continue
+		}
+		estimation.EstimatedRows += shardStats.RowCount
+		estimation.EstimatedCost += shardStats.AvgLatency
+	}
+	return estimation, nil
+}
+
+func shardKeyIDByQuery(query sqlparser.Query) sqlparser.Identifier {
+	switch q := query.(type) {
+	case *sqlparser.QueryBase:
+		return q.ShardKeyID
+	case *sqlparser.InsertQuery:
+		return q.ShardKeyID
+	case *sqlparser.DeleteQuery:
+		return q.ShardKeyID
+	}
+	return sqlparser.UnknownID
+}
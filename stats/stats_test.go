@@ -0,0 +1,150 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/connection"
+	_ "go.knocknote.io/octillery/connection/adapter/plugin"
+)
+
+const testConfig = `
+tables:
+  users:
+    shard: true
+    shard_key: id
+    shards:
+      - user_shard_1:
+          adapter: sqlite3
+          database: %[1]s/user_shard_1.bin
+      - user_shard_2:
+          adapter: sqlite3
+          database: %[1]s/user_shard_2.bin
+  user_stages:
+    adapter: sqlite3
+    database: %[1]s/user_stage.bin
+`
+
+func checkErr(t *testing.T, err error) {
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func setup(t *testing.T) (*connection.DBConnectionManager, func()) {
+	dir, err := ioutil.TempDir("", "octillery_stats")
+	checkErr(t, err)
+	confPath := filepath.Join(dir, "databases.yml")
+	checkErr(t, ioutil.WriteFile(confPath, []byte(fmt.Sprintf(testConfig, dir)), 0644))
+	cfg, err := config.Load(confPath)
+	checkErr(t, err)
+	checkErr(t, connection.SetConfig(cfg))
+	mgr, err := connection.NewConnectionManager()
+	checkErr(t, err)
+	for _, tableName := range []string{"users", "user_stages"} {
+		checkErr(t, mgr.ForEachShard(tableName, func(shard *connection.DBShardConnection) error {
+			if _, err := shard.Connection.Exec(fmt.Sprintf("create table %s (id integer primary key, name varchar(255))", tableName)); err != nil {
+				return err
+			}
+			_, err := shard.Connection.Exec(fmt.Sprintf("insert into %s(name) values ('alice'), ('bob')", tableName))
+			return err
+		}, nil))
+	}
+	return mgr, func() {
+		mgr.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	mgr, teardown := setup(t)
+	defer teardown()
+
+	sampler := NewSampler(mgr, "users", "user_stages")
+	estimation, err := sampler.Estimate("select * from users")
+	checkErr(t, err)
+	if estimation.IsSampled || !estimation.IsScatter || estimation.ShardNum != 2 {
+		t.Fatalf("invalid estimation %s", estimation)
+	}
+
+	checkErr(t, sampler.Sample(context.Background()))
+	if stats := sampler.TableStats("users"); stats == nil || stats.RowCount() != 4 || len(stats.Shards) != 2 {
+		t.Fatal("cannot sample statistics")
+	}
+	t.Run("scatter", func(t *testing.T) {
+		estimation, err := sampler.Estimate("select * from users where name = 'alice'")
+		checkErr(t, err)
+		if !estimation.IsSampled || !estimation.IsScatter || estimation.EstimatedRows != 4 {
+			t.Fatalf("invalid estimation %s", estimation)
+		}
+	})
+	t.Run("single shard", func(t *testing.T) {
+		estimation, err := sampler.Estimate("select * from users where id = ?", int64(1))
+		checkErr(t, err)
+		if estimation.IsScatter || estimation.ShardNum != 1 || estimation.EstimatedRows != 2 || len(estimation.ShardNames) != 1 {
+			t.Fatalf("invalid estimation %s", estimation)
+		}
+	})
+	t.Run("not sharded table", func(t *testing.T) {
+		estimation, err := sampler.Estimate("select * from user_stages")
+		checkErr(t, err)
+		if estimation.IsScatter || estimation.ShardNum != 1 || estimation.EstimatedRows != 2 {
+			t.Fatalf("invalid estimation %s", estimation)
+		}
+	})
+	t.Run("background sampling", func(t *testing.T) {
+		sampler := NewSampler(mgr, "user_stages")
+		sampler.Start(time.Millisecond)
+		defer sampler.Stop()
+		for i := 0; i < 100 && sampler.TableStats("user_stages") == nil; i++ {
+			time.Sleep(time.Millisecond)
+		}
+		if sampler.TableStats("user_stages") == nil {
+			t.Fatal("cannot sample statistics in background")
+		}
+	})
+}
+
+func TestDistribution(t *testing.T) {
+	mgr, teardown := setup(t)
+	defer teardown()
+
+	conn, err := mgr.ConnectionByTableName("users")
+	checkErr(t, err)
+	shard := conn.ShardConnections.ShardConnectionByName("user_shard_1")
+	for id := 10; id < 14; id++ {
+		_, err := shard.Connection.Exec(fmt.Sprintf("insert into users(id, name) values (%d, 'carol')", id))
+		checkErr(t, err)
+	}
+
+	distribution, err := Distribution(context.Background(), mgr, "users", &DistributionOptions{WithShardKeyRange: true, Concurrency: 2})
+	checkErr(t, err)
+	if distribution.RowCount != 8 || distribution.ShardKeyColumnName != "id" || len(distribution.Shards) != 2 || distribution.Skew != 1.5 {
+		t.Fatalf("invalid distribution %s", distribution)
+	}
+	shard1 := distribution.Shards[0]
+	if shard1.ShardName != "user_shard_1" || shard1.RowCount != 6 || shard1.MinShardKey != "1" || shard1.MaxShardKey != "13" {
+		t.Fatalf("invalid distribution of shard %+v", shard1)
+	}
+	if shard2 := distribution.Shards[1]; shard2.RowCount != 2 || shard2.Ratio != 0.5 {
+		t.Fatalf("invalid distribution of shard %+v", shard2)
+	}
+	if hotShards := distribution.HotShards(DefaultHotShardThreshold); len(hotShards) != 1 || hotShards[0] != shard1 {
+		t.Fatal("cannot detect hot shard")
+	}
+
+	if _, err := Distribution(context.Background(), mgr, "user_stages", nil); err == nil {
+		t.Fatal("cannot handle error")
+	}
+	distributions, err := Distributions(context.Background(), mgr, nil)
+	checkErr(t, err)
+	if len(distributions) != 1 || distributions[0].TableName != "users" || distributions[0].Shards[0].MinShardKey != "" {
+		t.Fatal("cannot get distributions of sharded tables")
+	}
+}
@@ -0,0 +1,198 @@
+package octillery
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/algorithm"
+	"go.knocknote.io/octillery/config"
+)
+
+// DefaultShardingAlgorithm algorithm name used when 'algorithm' is not defined in configuration file
+const DefaultShardingAlgorithm = "modulo"
+
+// Topology is the serializable snapshot of routing table.
+// It doesn't include any credentials ( username and password ), so it can be passed to external services.
+type Topology struct {
+	Tables []*TableTopology `json:"tables" yaml:"tables"`
+}
+
+// TableTopology routing definition of a table
+type TableTopology struct {
+	// table name
+	Name string `json:"name" yaml:"name"`
+	// whether table is sharded or not
+	IsShard bool `json:"shard" yaml:"shard"`
+	// unique id's column for all shards
+	ShardColumnName string `json:"shard_column,omitempty" yaml:"shard_column,omitempty"`
+	// column name for deciding sharding target
+	ShardKeyColumnName string `json:"shard_key,omitempty" yaml:"shard_key,omitempty"`
+	// sharding algorithm and its parameters. nil if table is not sharded
+	Algorithm *AlgorithmTopology `json:"algorithm,omitempty" yaml:"algorithm,omitempty"`
+	// database of not sharded table
+	Database *DatabaseTopology `json:"database,omitempty" yaml:"database,omitempty"`
+	// database of sequencer
+	Sequencer *DatabaseTopology `json:"sequencer,omitempty" yaml:"sequencer,omitempty"`
+	// shard databases in order passed to sharding algorithm
+	Shards []*DatabaseTopology `json:"shards,omitempty" yaml:"shards,omitempty"`
+}
+
+// AlgorithmTopology parameters of sharding algorithm
+type AlgorithmTopology struct {
+	// algorithm name ( e.g. 'modulo' or 'hashmap' )
+	Name string `json:"name" yaml:"name"`
+	// number of shards
+	ShardNum int `json:"shard_num" yaml:"shard_num"`
+	// parameters defined by 'algorithm_config'
+	Params map[string]interface{} `json:"params,omitempty" yaml:"params,omitempty"`
+}
+
+// DatabaseTopology database definition without credentials
+type DatabaseTopology struct {
+	// shard name defined in configuration file. empty if it is not shard
+	ShardName string `json:"shard_name,omitempty" yaml:"shard_name,omitempty"`
+	// index of shard passed to sharding algorithm
+	ShardIndex int `json:"shard_index" yaml:"shard_index"`
+	// database name of MySQL or database file path of SQLite
+	Database string `json:"database" yaml:"database"`
+	// adapter name
+	Adapter string `json:"adapter" yaml:"adapter"`
+	// master server's hosts
+	Masters []string `json:"masters,omitempty" yaml:"masters,omitempty"`
+	// slave server's hosts
+	Slaves []string `json:"slaves,omitempty" yaml:"slaves,omitempty"`
+	// number of sequencer partitions
+	Partitions int `json:"partitions,omitempty" yaml:"partitions,omitempty"`
+	// algorithm to distribute rows of split shard to sub-shards. nil if shard is not split
+	SubShardAlgorithm *AlgorithmTopology `json:"sub_shard_algorithm,omitempty" yaml:"sub_shard_algorithm,omitempty"`
+	// sub-shards in order passed to algorithm of sub-shards
+	SubShards []*DatabaseTopology `json:"sub_shards,omitempty" yaml:"sub_shards,omitempty"`
+}
+
+// RoutingSnapshot returns routing table built by configuration loaded by LoadConfig.
+//
+// This is intended for external services ( e.g. data pipeline ) that mirror routing decisions of octillery.
+func RoutingSnapshot() (*Topology, error) {
+	cfg, err := config.Get()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return NewTopology(cfg), nil
+}
+
+// NewTopology creates routing table snapshot by configuration.
+func NewTopology(cfg *config.Config) *Topology {
+	tableNames := make([]string, 0, len(cfg.Tables))
+	for tableName := range cfg.Tables {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+	tables := make([]*TableTopology, 0, len(tableNames))
+	for _, tableName := range tableNames {
+		tables = append(tables, newTableTopology(tableName, cfg.Tables[tableName]))
+	}
+	return &Topology{Tables: tables}
+}
+
+// Table returns routing definition of table. if table is not defined, returns nil.
+func (t *Topology) Table(tableName string) *TableTopology {
+	for _, table := range t.Tables {
+		if table.Name == tableName {
+			return table
+		}
+	}
+	return nil
+}
+
+func newTableTopology(tableName string, cfg *config.TableConfig) *TableTopology {
+	table := &TableTopology{
+		Name:               tableName,
+		IsShard:            cfg.IsShard,
+		ShardColumnName:    cfg.ShardColumnName,
+		ShardKeyColumnName: cfg.ShardKeyColumnName,
+	}
+	if !cfg.IsShard {
+		table.Database = newDatabaseTopology("", 0, &cfg.DatabaseConfig)
+		return table
+	}
+	if table.ShardKeyColumnName == "" {
+		table.ShardKeyColumnName = cfg.ShardColumnName
+	}
+	algorithmName := cfg.Algorithm
+	if algorithmName == "" {
+		algorithmName = DefaultShardingAlgorithm
+	}
+	table.Algorithm = &AlgorithmTopology{
+		Name:     algorithmName,
+		ShardNum: len(cfg.Shards),
+		Params:   cfg.AlgorithmConfig,
+	}
+	if cfg.Sequencer != nil {
+		table.Sequencer = newDatabaseTopology("", 0, cfg.Sequencer)
+	}
+	for idx, shard := range cfg.Shards {
+		for shardName, shardConfig := range shard {
+			table.Shards = append(table.Shards, newShardTopology(shardName, idx, shardConfig))
+		}
+	}
+	return table
+}
+
+func newShardTopology(shardName string, shardIndex int, cfg *config.DatabaseConfig) *DatabaseTopology {
+	shard := newDatabaseTopology(shardName, shardIndex, cfg)
+	if !cfg.IsSplit() {
+		return shard
+	}
+	algorithmName := cfg.SubShardAlgorithm
+	if algorithmName == "" {
+		algorithmName = algorithm.DefaultSubShardingAlgorithm
+	}
+	shard.SubShardAlgorithm = &AlgorithmTopology{
+		Name:     algorithmName,
+		ShardNum: len(cfg.SubShards),
+		Params:   cfg.SubShardAlgorithmConfig,
+	}
+	for idx, subShard := range cfg.SubShards {
+		for subShardName, subShardConfig := range subShard {
+			shard.SubShards = append(shard.SubShards, newDatabaseTopology(subShardName, idx, subShardConfig))
+		}
+	}
+	return shard
+}
+
+func newDatabaseTopology(shardName string, shardIndex int, cfg *config.DatabaseConfig) *DatabaseTopology {
+	return &DatabaseTopology{
+		ShardName:  shardName,
+		ShardIndex: shardIndex,
+		Database:   cfg.NameOrPath,
+		Adapter:    cfg.Adapter,
+		Masters:    hostsWithoutCredentials(cfg.Masters),
+		Slaves:     hostsWithoutCredentials(cfg.Slaves),
+		Partitions: cfg.Partitions,
+	}
+}
+
+// hostsWithoutCredentials removes 'user:password@' part from dsn list
+func hostsWithoutCredentials(dsnList []string) []string {
+	if len(dsnList) == 0 {
+		return nil
+	}
+	hosts := make([]string, 0, len(dsnList))
+	for _, dsn := range dsnList {
+		if idx := strings.Index(dsn, "://"); idx >= 0 {
+			scheme := dsn[:idx+len("://")]
+			rest := dsn[idx+len("://"):]
+			if at := strings.LastIndex(rest, "@"); at >= 0 {
+				rest = rest[at+1:]
+			}
+			hosts = append(hosts, scheme+rest)
+			continue
+		}
+		if at := strings.LastIndex(dsn, "@"); at >= 0 {
+			dsn = dsn[at+1:]
+		}
+		hosts = append(hosts, dsn)
+	}
+	return hosts
+}
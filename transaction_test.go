@@ -1,15 +1,26 @@
 package octillery
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/compat"
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/connection"
 	"go.knocknote.io/octillery/database/sql"
+	"go.knocknote.io/octillery/encryption"
+	"go.knocknote.io/octillery/logging"
 	"go.knocknote.io/octillery/path"
+	"go.knocknote.io/octillery/sqlparser"
 )
 
 func init() {
@@ -144,6 +155,159 @@ func TestDistributedTransaction(t *testing.T) {
 	}
 }
 
+func TestSavepoint(t *testing.T) {
+	initializeTables(t)
+	db, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	// savepoint before accessing any database
+	if _, err := tx.Exec("SAVEPOINT before_users"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	insertToUsers(tx, t)
+	if _, err := tx.Exec("SAVEPOINT before_items"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	// user_items are placed on database accessed after savepoint
+	insertToUserItems(tx, t)
+	if _, err := tx.Exec("ROLLBACK TO SAVEPOINT before_items"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if _, err := tx.Exec("RELEASE SAVEPOINT before_items"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if _, err := tx.Exec("RELEASE SAVEPOINT before_items"); err == nil {
+		t.Fatal("cannot handle error")
+	}
+	BeforeCommitCallback(func(tx *sql.Tx, writeQueries []*sql.QueryLog) error {
+		if len(writeQueries) != 1 {
+			t.Fatal("write queries after savepoint must be removed")
+		}
+		return nil
+	})
+	AfterCommitCallback(func(*sql.Tx) error {
+		return nil
+	}, func(tx *sql.Tx, isCriticalError bool, failureQueries []*sql.QueryLog) error {
+		t.Fatal("cannot commit")
+		return nil
+	})
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM user_items WHERE user_id = 10").Scan(&count); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if count != 0 {
+		t.Fatal("cannot rollback to savepoint")
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if count != 1 {
+		t.Fatal("rows before savepoint must be committed")
+	}
+	if _, err := db.Exec("SAVEPOINT outside_tx"); err == nil {
+		t.Fatal("cannot handle error")
+	}
+}
+
+func TestTemporaryTable(t *testing.T) {
+	initializeTables(t)
+	db, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if _, err := tx.Exec("CREATE TEMPORARY TABLE tmp_users (id integer, name varchar(255))"); err == nil {
+		t.Fatal("cannot handle error")
+	}
+	insertToUsers(tx, t)
+	if _, err := tx.Exec("CREATE TEMPORARY TABLE tmp_users (id integer, name varchar(255))"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if _, err := tx.Exec("INSERT INTO tmp_users(id, name) VALUES (1, 'alice'), (2, 'bob')"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if _, err := tx.Exec("DELETE FROM tmp_users WHERE id = ?", 2); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	var name string
+	if err := tx.QueryRow("SELECT name FROM tmp_users WHERE id = ?", 1).Scan(&name); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if name != "alice" {
+		t.Fatal("cannot select temporary table")
+	}
+	rows, err := tx.Query("SELECT id FROM tmp_users")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	count := 0
+	for rows.Next() {
+		count++
+	}
+	rows.Close()
+	if count != 1 {
+		t.Fatalf("invalid number of rows of temporary table %d", count)
+	}
+	if _, err := tx.Exec("DROP TEMPORARY TABLE IF EXISTS tmp_items"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if _, err := tx.Exec("DROP TEMPORARY TABLE tmp_items"); err == nil {
+		t.Fatal("cannot handle error")
+	}
+	if _, err := tx.Exec("DROP TEMPORARY TABLE tmp_users"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if _, err := tx.Exec("CREATE TEMPORARY TABLE tmp_users (id integer)"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	BeforeCommitCallback(func(tx *sql.Tx, writeQueries []*sql.QueryLog) error {
+		if len(writeQueries) != 1 {
+			t.Fatal("write queries of temporary table must not be recorded")
+		}
+		return nil
+	})
+	AfterCommitCallback(func(*sql.Tx) error {
+		return nil
+	}, func(tx *sql.Tx, isCriticalError bool, failureQueries []*sql.QueryLog) error {
+		t.Fatal("cannot commit")
+		return nil
+	})
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM tmp_users").Scan(&count); err == nil {
+		t.Fatal("temporary table must not be visible after commit")
+	}
+	for i := 0; i < 2; i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		insertToUsers(tx, t)
+		// temporary table is dropped by previous transaction, so it can be created again
+		if _, err := tx.Exec("CREATE TEMPORARY TABLE tmp_users (id integer)"); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if err := tx.Rollback(); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+	}
+	if _, err := db.Exec("CREATE TEMPORARY TABLE tmp_users (id integer)"); err == nil {
+		t.Fatal("cannot handle error")
+	}
+}
+
 func TestDistributedTransactionNormalError(t *testing.T) {
 	initializeTables(t)
 	db, err := sql.Open("", "")
@@ -439,3 +603,1238 @@ func TestIsAlreadyCommittedUpdateQueryLog(t *testing.T) {
 		Args:  []interface{}{"alice", 5, 10},
 	})
 }
+
+func TestExecWithQueryLogBySequencer(t *testing.T) {
+	initializeTables(t)
+	db, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer tx.Rollback()
+	publishedID := insertToUsers(tx, t)
+	result, err := tx.ExecWithQueryLog(&sql.QueryLog{
+		Query:        "INSERT INTO users(id, name, age) VALUES (null, 'alice', 5)",
+		LastInsertID: publishedID + 100,
+	})
+	checkErr(t, err)
+	lastInsertID, err := result.LastInsertId()
+	checkErr(t, err)
+	if lastInsertID != publishedID+100 {
+		t.Fatalf("cannot replay query by published id. id is %d", lastInsertID)
+	}
+	var name string
+	checkErr(t, tx.QueryRow("SELECT name FROM users WHERE id = ?", lastInsertID).Scan(&name))
+	if name != "alice" {
+		t.Fatalf("cannot insert replayed row. name is %s", name)
+	}
+	if id := insertToUsers(tx, t); id != publishedID+1 {
+		t.Fatalf("sequencer is consumed by replayed query. next id is %d", id)
+	}
+}
+
+func TestDollarPlaceholder(t *testing.T) {
+	initializeTables(t)
+	db, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	result, err := db.Exec("INSERT INTO users(id, name, age) VALUES (null, 'alice', 5)")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if _, err := db.Exec("UPDATE users SET name = $2 WHERE id = $1", id, "bob"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	var name string
+	if err := db.QueryRow("SELECT name FROM users WHERE id = $1", id).Scan(&name); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if name != "bob" {
+		t.Fatal("cannot route query by $1 placeholder")
+	}
+}
+
+func TestMultiRowsInsert(t *testing.T) {
+	initializeTables(t)
+	db, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	t.Run("numbered by sequencer", func(t *testing.T) {
+		result, err := db.Exec("INSERT INTO users(id, name, age) VALUES (null, ?, 5), (null, 'bob', ?), (null, 'chris', 7)", "alice", 6)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		affectedRows, err := result.RowsAffected()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if affectedRows != 3 {
+			t.Fatalf("cannot merge affected rows. %d", affectedRows)
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		for idx, expected := range []string{"alice", "bob", "chris"} {
+			var name string
+			if err := db.QueryRow("SELECT name FROM users WHERE id = ?", id+int64(idx)).Scan(&name); err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			if name != expected {
+				t.Fatalf("cannot insert %d-th row. %s", idx+1, name)
+			}
+		}
+	})
+	t.Run("routed by shard_key", func(t *testing.T) {
+		result, err := db.Exec("INSERT INTO user_items(user_id) VALUES (1), (2), (?), (1)", 3)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		affectedRows, err := result.RowsAffected()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if affectedRows != 4 {
+			t.Fatalf("cannot merge affected rows. %d", affectedRows)
+		}
+		for userID, expected := range map[int64]int{1: 2, 2: 1, 3: 1} {
+			var count int
+			if err := db.QueryRow("SELECT COUNT(*) FROM user_items WHERE user_id = ?", userID).Scan(&count); err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			if count != expected {
+				t.Fatalf("cannot insert rows of user_id %d to its shard. %d rows", userID, count)
+			}
+		}
+	})
+	t.Run("rolled back if a shard is failed", func(t *testing.T) {
+		mgr, err := connection.NewConnectionManager()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		defer mgr.Close()
+		conn, err := mgr.ConnectionByTableName("user_items")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		shardNameByUserID := func(userID int64) string {
+			shardConn, err := conn.ShardConnectionByID(userID)
+			if err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			return shardConn.ShardName
+		}
+		var otherUserID int64
+		for userID := int64(100); otherUserID == 0; userID++ {
+			if shardNameByUserID(userID) != shardNameByUserID(1) {
+				otherUserID = userID
+			}
+		}
+		// id 1 is already inserted to the shard of user_id 1, so the second shard is failed
+		query := "INSERT INTO user_items(id, user_id) VALUES (100, ?), (1, 1)"
+		if _, err := db.Exec(query, otherUserID); err == nil {
+			t.Fatal("cannot handle error of a shard")
+		}
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM user_items WHERE user_id = ?", otherUserID).Scan(&count); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if count != 0 {
+			t.Fatal("rows inserted to other shards must be rolled back")
+		}
+
+		cfg, err := config.Get()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		cfg.DistributedTransaction = false
+		defer func() { cfg.DistributedTransaction = true }()
+		if _, err := db.Exec("INSERT INTO user_items(user_id) VALUES (1), (?)", otherUserID); err == nil {
+			t.Fatal("cannot reject inserting rows to multiple shards without distributed transaction")
+		}
+	})
+}
+
+func TestForeignKeyAssertion(t *testing.T) {
+	initializeTables(t)
+	db, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	sql.SetForeignKeys([]*sqlparser.ForeignKey{{
+		Table:             "user_items",
+		Columns:           []string{"user_id"},
+		ReferencedTable:   "users",
+		ReferencedColumns: []string{"id"},
+	}})
+	defer sql.SetForeignKeys(nil)
+
+	result, err := db.Exec("INSERT INTO users(id, name, age) VALUES (null, 'alice', 5)")
+	checkErr(t, err)
+	userID, err := result.LastInsertId()
+	checkErr(t, err)
+	missingUserID := userID + 1000
+	countItems := func() int {
+		var count int
+		checkErr(t, db.QueryRow("SELECT COUNT(*) FROM user_items WHERE id > 0").Scan(&count))
+		return count
+	}
+	t.Run("multiple rows", func(t *testing.T) {
+		if _, err := db.Exec("INSERT INTO user_items(user_id) VALUES (?), (?)", userID, missingUserID); errors.Cause(err) != sql.ErrForeignKeyConstraint {
+			t.Fatalf("cannot assert foreign key of the second row. err = %v", err)
+		}
+		if _, err := db.Exec(fmt.Sprintf("INSERT INTO user_items(user_id) VALUES (%d), (%d)", userID, missingUserID)); errors.Cause(err) != sql.ErrForeignKeyConstraint {
+			t.Fatalf("cannot assert foreign key of the second literal row. err = %v", err)
+		}
+		if countItems() != 0 {
+			t.Fatal("rows must not be inserted if foreign key is violated")
+		}
+		_, err := db.Exec("INSERT INTO user_items(user_id) VALUES (?), (?)", userID, userID)
+		checkErr(t, err)
+	})
+	t.Run("prepared statement", func(t *testing.T) {
+		stmt, err := db.Prepare("INSERT INTO user_items(user_id) VALUES (?)")
+		checkErr(t, err)
+		defer stmt.Close()
+		if _, err := stmt.Exec(missingUserID); errors.Cause(err) != sql.ErrForeignKeyConstraint {
+			t.Fatalf("cannot assert foreign key by prepared statement. err = %v", err)
+		}
+		_, err = stmt.Exec(userID)
+		checkErr(t, err)
+		if countItems() != 3 {
+			t.Fatalf("cannot insert rows referencing existing row. %d rows", countItems())
+		}
+	})
+}
+
+func TestCompat(t *testing.T) {
+	initializeTables(t)
+	db, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	BeforeCommitCallback(func(*sql.Tx, []*sql.QueryLog) error {
+		return nil
+	})
+	AfterCommitCallback(func(*sql.Tx) error {
+		return nil
+	}, func(*sql.Tx, bool, []*sql.QueryLog) error {
+		return nil
+	})
+	t.Run("sharded table", func(t *testing.T) {
+		compat.Run(t, &compat.Config{
+			DB:          db,
+			Table:       "users",
+			InsertQuery: "INSERT INTO users(id, name, age) VALUES (null, ?, ?)",
+			InsertArgs:  []interface{}{"alice", 5},
+		})
+	})
+	t.Run("not sharded table", func(t *testing.T) {
+		compat.Run(t, &compat.Config{
+			DB:          db,
+			Table:       "user_stages",
+			InsertQuery: "INSERT INTO user_stages(user_id, name, age) VALUES (?, ?, ?)",
+			InsertArgs:  []interface{}{1, "alice", 5},
+		})
+	})
+}
+
+func TestMoveOnShardKeyUpdate(t *testing.T) {
+	initializeTables(t)
+	db, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	BeforeCommitCallback(func(*sql.Tx, []*sql.QueryLog) error {
+		return nil
+	})
+	AfterCommitCallback(func(*sql.Tx) error {
+		return nil
+	}, func(*sql.Tx, bool, []*sql.QueryLog) error {
+		return nil
+	})
+	cfg, err := config.Get()
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	cfg.Tables["user_items"].MoveOnShardKeyUpdate = true
+	defer func() { cfg.Tables["user_items"].MoveOnShardKeyUpdate = false }()
+
+	mgr, err := connection.NewConnectionManager()
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer mgr.Close()
+	conn, err := mgr.ConnectionByTableName("user_items")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	shardNameByUserID := func(userID int64) string {
+		shardConn, err := conn.ShardConnectionByID(userID)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		return shardConn.ShardName
+	}
+	var movedUserID int64
+	for userID := int64(2); movedUserID == 0; userID++ {
+		if shardNameByUserID(userID) != shardNameByUserID(1) {
+			movedUserID = userID
+		}
+	}
+	countByUserID := func(userID int64) int {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM user_items WHERE user_id = ?", userID).Scan(&count); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		return count
+	}
+	if _, err := db.Exec("INSERT INTO user_items(user_id) VALUES (1), (1)"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+
+	t.Run("distributed transaction is disabled", func(t *testing.T) {
+		cfg.DistributedTransaction = false
+		defer func() { cfg.DistributedTransaction = true }()
+		if _, err := db.Exec("UPDATE user_items SET user_id = ? WHERE user_id = ?", movedUserID, 1); err == nil {
+			t.Fatal("cannot reject moving rows without distributed transaction")
+		}
+	})
+	t.Run("rollback", func(t *testing.T) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if _, err := tx.Exec("UPDATE user_items SET user_id = ? WHERE user_id = ?", movedUserID, 1); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if err := tx.Rollback(); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if countByUserID(1) != 2 || countByUserID(movedUserID) != 0 {
+			t.Fatal("moved rows must be restored by rollback")
+		}
+	})
+	t.Run("move rows", func(t *testing.T) {
+		idsByUserID := func(userID int64) []int64 {
+			rows, err := db.Query("SELECT id FROM user_items WHERE user_id = ? ORDER BY id", userID)
+			if err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			defer rows.Close()
+			ids := []int64{}
+			for rows.Next() {
+				var id int64
+				if err := rows.Scan(&id); err != nil {
+					t.Fatalf("%+v\n", err)
+				}
+				ids = append(ids, id)
+			}
+			return ids
+		}
+		ids := idsByUserID(1)
+		recorder := &queryHookRecorder{}
+		SetQueryHook(recorder.hook)
+		defer SetQueryHook(nil)
+		result, err := db.Exec("UPDATE user_items SET user_id = ? WHERE user_id = ?", movedUserID, 1)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		SetQueryHook(nil)
+		deletedIDs := []int64{}
+		for _, event := range recorder.reset() {
+			if event.Finished && strings.HasPrefix(event.Query, "DELETE") {
+				if event.Query != "DELETE FROM user_items WHERE id = ?" {
+					t.Fatalf("moved rows must be deleted by id. %s", event.Query)
+				}
+				deletedIDs = append(deletedIDs, event.Args[0].(int64))
+			}
+		}
+		if !reflect.DeepEqual(deletedIDs, ids) || !reflect.DeepEqual(idsByUserID(movedUserID), ids) {
+			t.Fatalf("cannot move rows read from shard. deleted %v, moved %v", deletedIDs, ids)
+		}
+		affectedRows, err := result.RowsAffected()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if affectedRows != 2 {
+			t.Fatalf("cannot get number of moved rows. %d", affectedRows)
+		}
+		if countByUserID(1) != 0 || countByUserID(movedUserID) != 2 {
+			t.Fatal("cannot move rows to the shard of new shard_key")
+		}
+	})
+	t.Run("move rows by prepared statement", func(t *testing.T) {
+		stmt, err := db.Prepare("UPDATE user_items SET user_id = ? WHERE user_id = ?")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		defer stmt.Close()
+		result, err := stmt.Exec(1, movedUserID)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		affectedRows, err := result.RowsAffected()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if affectedRows != 2 {
+			t.Fatalf("cannot get number of moved rows. %d", affectedRows)
+		}
+		if countByUserID(movedUserID) != 0 || countByUserID(1) != 2 {
+			t.Fatal("cannot move rows to the shard of new shard_key by prepared statement")
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if _, err := tx.Stmt(stmt).Exec(movedUserID, 1); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if countByUserID(1) != 0 || countByUserID(movedUserID) != 2 {
+			t.Fatal("cannot move rows to the shard of new shard_key by prepared statement in transaction")
+		}
+	})
+	t.Run("expression cannot be moved", func(t *testing.T) {
+		if _, err := db.Exec("UPDATE user_items SET user_id = user_id + 1 WHERE user_id = ?", movedUserID); errors.Cause(err) != sqlparser.ErrShardKeyUpdated {
+			t.Fatalf("cannot reject moving rows by expression. err = %v", err)
+		}
+	})
+}
+
+func TestDeduplicateScatterQueries(t *testing.T) {
+	initializeTables(t)
+	db, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	cfg, err := config.Get()
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	cfg.DeduplicateScatterQueries = true
+	defer func() { cfg.DeduplicateScatterQueries = false }()
+
+	if _, err := db.Exec("INSERT INTO user_items(user_id) VALUES (1), (2), (3), (4)"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			rows, err := db.Query("SELECT user_id FROM user_items ORDER BY user_id")
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer rows.Close()
+			userIDs := []int64{}
+			for rows.Next() {
+				var userID int64
+				if err := rows.Scan(&userID); err != nil {
+					errs <- err
+					return
+				}
+				userIDs = append(userIDs, userID)
+			}
+			if fmt.Sprint(userIDs) != "[1 2 3 4]" {
+				errs <- errors.Errorf("cannot get shared rows. %v", userIDs)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			var count int
+			if err := db.QueryRow("SELECT COUNT(*) FROM user_items").Scan(&count); err != nil {
+				errs <- err
+				return
+			}
+			if count != 4 {
+				errs <- errors.Errorf("cannot get shared row. %d", count)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("%+v\n", err)
+	}
+	t.Run("shared execution is not cancelled by first caller", func(t *testing.T) {
+		query := "SELECT user_id FROM user_items WHERE id > 0 ORDER BY user_id"
+		started := make(chan struct{})
+		release := make(chan struct{})
+		var once sync.Once
+		SetQueryHook(func(ctx context.Context, info sql.QueryHookInfo) {
+			if info.Finished || info.Query != query {
+				return
+			}
+			once.Do(func() {
+				close(started)
+				<-release
+			})
+		})
+		defer SetQueryHook(nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		firstErr := make(chan error, 1)
+		go func() {
+			rows, err := db.QueryContext(ctx, query)
+			if err == nil {
+				rows.Close()
+			}
+			firstErr <- err
+		}()
+		<-started
+		type result struct {
+			userIDs []int64
+			err     error
+		}
+		second := make(chan result, 1)
+		go func() {
+			rows, err := db.QueryContext(context.Background(), query)
+			if err != nil {
+				second <- result{err: err}
+				return
+			}
+			defer rows.Close()
+			userIDs := []int64{}
+			for rows.Next() {
+				var userID int64
+				if err := rows.Scan(&userID); err != nil {
+					second <- result{err: err}
+					return
+				}
+				userIDs = append(userIDs, userID)
+			}
+			second <- result{userIDs: userIDs, err: rows.Err()}
+		}()
+		// wait for second caller sharing execution blocked by hook
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		select {
+		case err := <-firstErr:
+			if errors.Cause(err) != context.Canceled {
+				close(release)
+				t.Fatalf("first caller must return error of its own context. err = %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			close(release)
+			t.Fatal("first caller must not wait shared execution after its context is done")
+		}
+		close(release)
+		got := <-second
+		if got.err != nil {
+			t.Fatalf("%+v\n", got.err)
+		}
+		if fmt.Sprint(got.userIDs) != "[1 2 3 4]" {
+			t.Fatalf("cannot get shared rows. %v", got.userIDs)
+		}
+	})
+}
+
+func TestEncryptedColumns(t *testing.T) {
+	initializeTables(t)
+	db, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	encryptor, err := encryption.NewAESGCMEncryptor([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	if _, err := encryption.EncryptorByName("test_encryptor"); err != nil {
+		encryption.Register("test_encryptor", encryptor)
+	}
+	cfg, err := config.Get()
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	for _, tableName := range []string{"users", "user_stages"} {
+		cfg.Tables[tableName].EncryptedColumns = map[string]string{"name": "test_encryptor"}
+	}
+	defer func() {
+		for _, tableName := range []string{"users", "user_stages"} {
+			cfg.Tables[tableName].EncryptedColumns = nil
+		}
+	}()
+
+	if _, err := db.Exec("INSERT INTO users(name, age) VALUES (?, ?), (?, ?)", "alice", 10, "bob", 20); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	t.Run("stored value is encrypted", func(t *testing.T) {
+		mgr, err := connection.NewConnectionManager()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		defer mgr.Close()
+		conn, err := mgr.ConnectionByTableName("users")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		for _, shardConn := range conn.ShardConnections.AllShard() {
+			rows, err := shardConn.Connection.Query("SELECT name FROM users")
+			if err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			for rows.Next() {
+				var name string
+				if err := rows.Scan(&name); err != nil {
+					t.Fatalf("%+v\n", err)
+				}
+				if name == "alice" || name == "bob" {
+					t.Fatal("plaintext is stored to encrypted column")
+				}
+			}
+			rows.Close()
+		}
+	})
+	t.Run("decrypt by QueryRow", func(t *testing.T) {
+		var (
+			name string
+			age  int
+		)
+		if err := db.QueryRow("SELECT name, age FROM users WHERE age = ?", 10).Scan(&name, &age); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if name != "alice" || age != 10 {
+			t.Fatalf("cannot decrypt column. name = %s, age = %d", name, age)
+		}
+	})
+	t.Run("decrypt rows of all shards", func(t *testing.T) {
+		rows, err := db.Query("SELECT name FROM users ORDER BY age")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		defer rows.Close()
+		names := []string{}
+		for rows.Next() {
+			var name sql.NullString
+			if err := rows.Scan(&name); err != nil {
+				t.Fatalf("%+v\n", err)
+			}
+			names = append(names, name.String)
+		}
+		if fmt.Sprint(names) != "[alice bob]" {
+			t.Fatalf("cannot decrypt rows. %v", names)
+		}
+	})
+	t.Run("update in transaction", func(t *testing.T) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if _, err := tx.Exec("INSERT INTO user_stages(user_id, name, age) VALUES (?, ?, ?)", 1, "stage1", 1); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if _, err := tx.Exec("UPDATE user_stages SET name = ? WHERE user_id = ?", "stage2", 1); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		var name string
+		if err := tx.QueryRow("SELECT name FROM user_stages WHERE user_id = ?", 1).Scan(&name); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if name != "stage2" {
+			t.Fatalf("cannot decrypt updated column. %s", name)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+	})
+	t.Run("literal value is rejected", func(t *testing.T) {
+		if _, err := db.Exec("UPDATE users SET name = 'carol' WHERE age = ?", 10); err == nil {
+			t.Fatal("cannot reject plaintext in query")
+		}
+	})
+	t.Run("prepared statement is rejected", func(t *testing.T) {
+		if _, err := db.Prepare("SELECT name FROM users WHERE age = ?"); err == nil {
+			t.Fatal("cannot reject prepared statement for encrypted table")
+		}
+	})
+}
+
+type queryHookRecorder struct {
+	mu     sync.Mutex
+	events []sql.QueryHookInfo
+}
+
+func (r *queryHookRecorder) hook(ctx context.Context, info sql.QueryHookInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, info)
+}
+
+func (r *queryHookRecorder) reset() []sql.QueryHookInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
+
+func TestQueryHook(t *testing.T) {
+	initializeTables(t)
+	db, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	recorder := &queryHookRecorder{}
+	SetQueryHook(recorder.hook)
+	defer SetQueryHook(nil)
+
+	t.Run("not sharded table", func(t *testing.T) {
+		if _, err := db.Exec("INSERT INTO user_stages(user_id, name, age) VALUES (?, ?, ?)", 10, "bob", 10); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		events := recorder.reset()
+		if len(events) != 2 {
+			t.Fatalf("cannot get events before and after query. %+v", events)
+		}
+		before, after := events[0], events[1]
+		if before.Finished || !after.Finished {
+			t.Fatal("invalid order of events")
+		}
+		if after.Table != "user_stages" || after.ShardName != "" || !strings.HasSuffix(after.DSN, "user_stage.bin") {
+			t.Fatalf("invalid event %+v", after)
+		}
+		if fmt.Sprint(after.Args) != "[10 bob 10]" || fmt.Sprint(after.RedactedArgs()) != "[<int> <string> <int>]" {
+			t.Fatalf("invalid args %v %v", after.Args, after.RedactedArgs())
+		}
+		if after.Err != nil || after.Duration <= 0 || after.RowsAffected != 1 {
+			t.Fatalf("invalid result of query %+v", after)
+		}
+	})
+	t.Run("sharded table", func(t *testing.T) {
+		if _, err := db.Exec("INSERT INTO user_items(user_id) VALUES (1), (2), (3), (4)"); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		recorder.reset()
+		rows, err := db.Query("SELECT user_id FROM user_items")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		rows.Close()
+		shardNames := map[string]struct{}{}
+		for _, event := range recorder.reset() {
+			if event.Table != "user_items" || event.ShardName == "" {
+				t.Fatalf("invalid event %+v", event)
+			}
+			if event.Finished {
+				shardNames[event.ShardName] = struct{}{}
+			}
+		}
+		if len(shardNames) != 8 {
+			t.Fatalf("hook is not called for all shards. %v", shardNames)
+		}
+		if err := db.QueryRow("SELECT user_id FROM user_items WHERE user_id = ?", 1).Scan(new(int64)); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		events := recorder.reset()
+		if len(events) != 2 || events[1].ShardName == "" || fmt.Sprint(events[1].Args) != "[1]" {
+			t.Fatalf("invalid events %+v", events)
+		}
+	})
+	t.Run("prepared statement", func(t *testing.T) {
+		stmt, err := db.Prepare("UPDATE user_items SET id = id WHERE user_id = ?")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		defer stmt.Close()
+		recorder.reset()
+		if _, err := stmt.Exec(1); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		events := recorder.reset()
+		if len(events) != 2 || events[0].Finished || !events[1].Finished {
+			t.Fatalf("cannot get events before and after prepared statement. %+v", events)
+		}
+		if after := events[1]; after.Table != "user_items" || after.ShardName == "" || fmt.Sprint(after.Args) != "[1]" || after.RowsAffected != 1 {
+			t.Fatalf("invalid event %+v", after)
+		}
+		selectStmt, err := db.Prepare("SELECT user_id FROM user_items WHERE user_id = ?")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		defer selectStmt.Close()
+		rows, err := selectStmt.Query(2)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		rows.Close()
+		if err := selectStmt.QueryRow(3).Scan(new(int64)); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if events := recorder.reset(); len(events) != 4 || fmt.Sprint(events[1].Args) != "[2]" || fmt.Sprint(events[3].Args) != "[3]" {
+			t.Fatalf("cannot get events of prepared statement %+v", events)
+		}
+	})
+	t.Run("transaction", func(t *testing.T) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		insertToUserStages(tx, t)
+		insertToUserItems(tx, t)
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		tables := []string{}
+		for _, event := range recorder.reset() {
+			if event.Finished {
+				tables = append(tables, event.Table)
+			}
+		}
+		if fmt.Sprint(tables) != "[user_stages user_items]" {
+			t.Fatalf("invalid events %v", tables)
+		}
+	})
+	t.Run("error", func(t *testing.T) {
+		if _, err := db.Query("SELECT unknown_column FROM user_stages"); err == nil {
+			t.Fatal("cannot handle error")
+		}
+		events := recorder.reset()
+		if len(events) != 2 || events[1].Err == nil {
+			t.Fatalf("cannot get error by hook %+v", events)
+		}
+	})
+	t.Run("hook of DB", func(t *testing.T) {
+		SetQueryHook(nil)
+		otherDB, err := sql.Open("", "")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		otherDB.SetQueryHook(recorder.hook)
+		if _, err := db.Exec("DELETE FROM user_stages WHERE id = 1"); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if events := recorder.reset(); len(events) != 0 {
+			t.Fatalf("hook of other DB is called %+v", events)
+		}
+		if _, err := otherDB.Exec("DELETE FROM user_stages WHERE id = 1"); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if events := recorder.reset(); len(events) != 2 {
+			t.Fatalf("cannot get events by hook of DB %+v", events)
+		}
+	})
+}
+
+type routingInterceptorFunc func(sqlparser.Query, []*connection.DBShardConnection) ([]*connection.DBShardConnection, error)
+
+func (f routingInterceptorFunc) Decide(query sqlparser.Query, proposedShards []*connection.DBShardConnection) ([]*connection.DBShardConnection, error) {
+	return f(query, proposedShards)
+}
+
+func TestRoutingInterceptor(t *testing.T) {
+	initializeTables(t)
+	db, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	conn, err := db.ConnectionManager().ConnectionByTableName("user_items")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	shards := conn.ShardConnections.AllShard()
+	canary := shards[len(shards)-1]
+	// user_id whose rows are not placed on canary shard by sharding algorithm
+	var userID int64
+	for id := int64(1); ; id++ {
+		shard, err := conn.ShardConnectionByID(id)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if shard != canary {
+			userID = id
+			break
+		}
+	}
+	recorder := &queryHookRecorder{}
+	db.SetQueryHook(recorder.hook)
+	executedShards := func() []string {
+		shardNames := []string{}
+		for _, event := range recorder.reset() {
+			if event.Finished {
+				shardNames = append(shardNames, event.ShardName)
+			}
+		}
+		return shardNames
+	}
+	intercept := func(queryType sqlparser.QueryType, decide func([]*connection.DBShardConnection) ([]*connection.DBShardConnection, error)) {
+		db.SetRoutingInterceptor(routingInterceptorFunc(func(query sqlparser.Query, proposedShards []*connection.DBShardConnection) ([]*connection.DBShardConnection, error) {
+			if query.Table() != "user_items" || query.QueryType() != queryType {
+				return proposedShards, nil
+			}
+			return decide(proposedShards)
+		}))
+	}
+	defer db.SetRoutingInterceptor(nil)
+
+	t.Run("replace shard", func(t *testing.T) {
+		intercept(sqlparser.Insert, func([]*connection.DBShardConnection) ([]*connection.DBShardConnection, error) {
+			return []*connection.DBShardConnection{canary}, nil
+		})
+		if _, err := db.Exec("INSERT INTO user_items(user_id) VALUES (?)", userID); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if shardNames := executedShards(); fmt.Sprint(shardNames) != fmt.Sprintf("[%s]", canary.ShardName) {
+			t.Fatalf("cannot pin row to canary shard. %v", shardNames)
+		}
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM user_items WHERE user_id = ?", userID).Scan(&count); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if count != 0 {
+			t.Fatal("row must not be placed on shard decided by sharding algorithm")
+		}
+	})
+	t.Run("broaden shards", func(t *testing.T) {
+		intercept(sqlparser.Select, func(proposedShards []*connection.DBShardConnection) ([]*connection.DBShardConnection, error) {
+			return append(proposedShards, canary), nil
+		})
+		recorder.reset()
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM user_items WHERE user_id = ?", userID).Scan(&count); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if count != 1 {
+			t.Fatalf("cannot merge results of broadened shards. count = %d", count)
+		}
+		if shardNames := executedShards(); len(shardNames) != 2 {
+			t.Fatalf("query must be executed on 2 shards. %v", shardNames)
+		}
+		rows, err := db.Query("SELECT user_id FROM user_items WHERE user_id = ?", userID)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if !rows.Next() {
+			t.Fatal("cannot select row from canary shard")
+		}
+		rows.Close()
+		if err := db.QueryRow("SELECT user_id FROM user_items WHERE user_id = ?", userID).Scan(new(int64)); err == nil {
+			t.Fatal("QueryRow on broadened shards without merge must be error")
+		}
+		intercept(sqlparser.Update, func(proposedShards []*connection.DBShardConnection) ([]*connection.DBShardConnection, error) {
+			return append(proposedShards, canary), nil
+		})
+		result, err := db.Exec("UPDATE user_items SET id = id WHERE user_id = ?", userID)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if affectedRows, err := result.RowsAffected(); err != nil || affectedRows != 1 {
+			t.Fatalf("cannot update row on broadened shards. affected rows = %d, err = %v", affectedRows, err)
+		}
+	})
+	t.Run("narrow shards", func(t *testing.T) {
+		intercept(sqlparser.Select, func(proposedShards []*connection.DBShardConnection) ([]*connection.DBShardConnection, error) {
+			return proposedShards[len(proposedShards)-1:], nil
+		})
+		recorder.reset()
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM user_items").Scan(&count); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if shardNames := executedShards(); fmt.Sprint(shardNames) != fmt.Sprintf("[%s]", canary.ShardName) {
+			t.Fatalf("scatter query must be narrowed to canary shard. %v", shardNames)
+		}
+		if count != 1 {
+			t.Fatalf("invalid count of canary shard %d", count)
+		}
+	})
+	t.Run("veto", func(t *testing.T) {
+		intercept(sqlparser.Delete, func([]*connection.DBShardConnection) ([]*connection.DBShardConnection, error) {
+			return nil, errors.New("DELETE is not allowed")
+		})
+		if _, err := db.Exec("DELETE FROM user_items WHERE user_id = ?", userID); err == nil {
+			t.Fatal("query must be vetoed by routing interceptor")
+		}
+		if shardNames := executedShards(); len(shardNames) != 0 {
+			t.Fatalf("vetoed query is executed on %v", shardNames)
+		}
+		intercept(sqlparser.Insert, func([]*connection.DBShardConnection) ([]*connection.DBShardConnection, error) {
+			return shards, nil
+		})
+		if _, err := db.Exec("INSERT INTO user_items(user_id) VALUES (?)", userID); err == nil {
+			t.Fatal("INSERT must not be executed on multiple shards")
+		}
+		intercept(sqlparser.Select, func([]*connection.DBShardConnection) ([]*connection.DBShardConnection, error) {
+			return []*connection.DBShardConnection{}, nil
+		})
+		if _, err := db.Query("SELECT * FROM user_items"); err == nil {
+			t.Fatal("query must be rejected if routing interceptor decides no shards")
+		}
+	})
+}
+
+func TestMigrateSequencer(t *testing.T) {
+	initializeTables(t)
+	db, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer db.Close()
+	insertDeck := func() int64 {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		id := insertToUserDecks(tx, t)
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		return id
+	}
+	var lastID int64
+	for i := 0; i < 3; i++ {
+		lastID = insertDeck()
+	}
+	mgr := db.ConnectionManager()
+	seqPath := "/tmp/user_deck_seq_migrated.bin"
+	os.Remove(seqPath)
+	defer os.Remove(seqPath)
+	to := &config.DatabaseConfig{Adapter: "sqlite3", NameOrPath: seqPath, Partitions: 2}
+	t.Run("migrate to database", func(t *testing.T) {
+		migration, err := mgr.MigrateSequencer(context.Background(), "user_decks", to, 10)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if migration.MaxID < lastID || migration.FirstID <= migration.MaxID+10 {
+			t.Fatalf("new sequencer must start above existing ids. last id = %d, max id = %d, first id = %d", lastID, migration.MaxID, migration.FirstID)
+		}
+		if err := migration.Verify(context.Background()); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if id := insertDeck(); id <= migration.MaxID+10 {
+			t.Fatalf("id must be published by new sequencer. but got %d", id)
+		}
+		lastID = insertDeck()
+		if err := migration.Rollback(context.Background()); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if id := insertDeck(); id <= lastID {
+			t.Fatalf("old sequencer must be advanced above ids of new sequencer. last id = %d, id = %d", lastID, id)
+		}
+		if err := migration.Rollback(context.Background()); err == nil {
+			t.Fatal("must not roll back finished migration")
+		}
+	})
+	t.Run("migrate to id generator", func(t *testing.T) {
+		migration, err := mgr.MigrateSequencer(context.Background(), "user_decks", &config.DatabaseConfig{Type: "snowflake", NodeID: 1}, 10)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if err := migration.Verify(context.Background()); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if err := migration.Commit(); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if id := insertDeck(); id <= migration.FirstID {
+			t.Fatalf("id must be generated by snowflake. but got %d", id)
+		}
+		if err := migration.Commit(); err == nil {
+			t.Fatal("must not commit finished migration")
+		}
+	})
+	t.Run("invalid migration", func(t *testing.T) {
+		if _, err := mgr.MigrateSequencer(context.Background(), "user_items", to, 0); err == nil {
+			t.Fatal("must not migrate table not using sequencer")
+		}
+		if _, err := mgr.MigrateSequencer(context.Background(), "user_accounts", to, 0); err == nil {
+			t.Fatal("must not migrate table using unique_columns")
+		}
+		if _, err := mgr.MigrateSequencer(context.Background(), "user_decks", to, -1); err == nil {
+			t.Fatal("must not accept negative headroom")
+		}
+	})
+}
+
+func TestLockingRead(t *testing.T) {
+	initializeTables(t)
+	db, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer tx.Rollback()
+	id := insertToUsers(tx, t)
+	t.Run("single shard", func(t *testing.T) {
+		var name string
+		if err := tx.QueryRow("SELECT name FROM users WHERE id = ? FOR UPDATE", id).Scan(&name); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if name != "alice" {
+			t.Fatalf("cannot select locked row. name = %s", name)
+		}
+		rows, err := tx.Query("SELECT name FROM users WHERE id IN (?) LOCK IN SHARE MODE", id)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		count := 0
+		for rows.Next() {
+			count++
+		}
+		rows.Close()
+		if count != 1 {
+			t.Fatalf("cannot select locked rows. count = %d", count)
+		}
+	})
+	t.Run("without shard key", func(t *testing.T) {
+		if _, err := tx.Query("SELECT name FROM users WHERE age = 5 FOR UPDATE"); err == nil || !strings.Contains(err.Error(), "shard_key") {
+			t.Fatalf("locking read without shard_key must be rejected. err = %v", err)
+		}
+		if err := tx.QueryRow("SELECT name FROM users FOR UPDATE").Scan(new(string)); err == nil {
+			t.Fatal("locking read for all shards must be rejected")
+		}
+	})
+	t.Run("unsupported lock option", func(t *testing.T) {
+		if err := tx.QueryRow("SELECT name FROM users WHERE id = ? FOR UPDATE NOWAIT", id).Scan(new(string)); err == nil {
+			t.Fatal("NOWAIT is not supported by SQLite")
+		}
+	})
+}
+
+func TestExecOnShard(t *testing.T) {
+	initializeTables(t)
+	db, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	defer db.Close()
+	// id is not published by sequencer, so row is inserted to user_shard_1 as it is
+	if _, _, err := ExecOnShard(db, "users", "user_shard_1", "INSERT INTO users(id, name, age) VALUES (3, 'alice', 5)"); err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	for _, shardName := range []string{"user_shard_1", "user_shard_2"} {
+		multiRows, _, err := ExecOnShard(db, "users", shardName, "SELECT COUNT(*) FROM users")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if len(multiRows) != 1 || !multiRows[0].Next() {
+			t.Fatal("cannot get rows of shard")
+		}
+		var count int
+		if err := multiRows[0].Scan(&count); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		multiRows[0].Close()
+		expected := 0
+		if shardName == "user_shard_1" {
+			expected = 1
+		}
+		if count != expected {
+			t.Fatalf("expected %d rows on %s but got %d", expected, shardName, count)
+		}
+	}
+	if _, _, err := ExecOnShard(db, "users", "user_shard_3", "SELECT COUNT(*) FROM users"); err == nil {
+		t.Fatal("cannot detect unknown shard")
+	}
+	if _, _, err := ExecOnShard(db, "user_stages", "user_shard_1", "SELECT COUNT(*) FROM user_stages"); err == nil {
+		t.Fatal("cannot detect table not sharded")
+	}
+}
+
+func TestSlowQueryLog(t *testing.T) {
+	initializeTables(t)
+	db, err := sql.Open("", "")
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+	var (
+		mu          sync.Mutex
+		slowQueries []*logging.SlowQuery
+	)
+	SetSlowQueryHandler(func(q *logging.SlowQuery) {
+		mu.Lock()
+		defer mu.Unlock()
+		slowQueries = append(slowQueries, q)
+	})
+	defer SetSlowQueryHandler(nil)
+	reset := func() []*logging.SlowQuery {
+		mu.Lock()
+		defer mu.Unlock()
+		queries := slowQueries
+		slowQueries = nil
+		return queries
+	}
+	cfg, err := config.Get()
+	if err != nil {
+		t.Fatalf("%+v\n", err)
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		if _, err := db.Exec("INSERT INTO user_stages(user_id, name, age) VALUES (?, ?, ?)", 10, "bob", 10); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		if queries := reset(); len(queries) != 0 {
+			t.Fatalf("query must not be logged %+v", queries)
+		}
+	})
+	cfg.SlowQueryThreshold = time.Nanosecond
+	defer func() { cfg.SlowQueryThreshold = 0 }()
+	t.Run("not sharded table", func(t *testing.T) {
+		if _, err := db.Exec("UPDATE user_stages SET age = ? WHERE user_id = ?", 20, 10); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		queries := reset()
+		if len(queries) != 1 {
+			t.Fatalf("cannot log slow query %+v", queries)
+		}
+		q := queries[0]
+		if q.Table != "user_stages" || q.ShardName != "" || q.RowsAffected != 1 || q.Duration <= 0 {
+			t.Fatalf("invalid slow query %+v", q)
+		}
+		if fmt.Sprint(q.Args) != "[<int> <int>]" {
+			t.Fatalf("arguments must be redacted %v", q.Args)
+		}
+	})
+	t.Run("sharded table", func(t *testing.T) {
+		if _, err := db.Exec("INSERT INTO user_items(user_id) VALUES (1), (2), (3), (4)"); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		reset()
+		rows, err := db.Query("SELECT user_id FROM user_items WHERE user_id = ?", 1)
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		rows.Close()
+		queries := reset()
+		if len(queries) != 1 || queries[0].ShardName == "" || queries[0].RowsAffected != -1 {
+			t.Fatalf("cannot log slow query with shard %+v", queries)
+		}
+		if !strings.Contains(queries[0].String(), queries[0].ShardName) {
+			t.Fatalf("invalid text of slow query %s", queries[0])
+		}
+	})
+	t.Run("prepared statement", func(t *testing.T) {
+		stmt, err := db.Prepare("UPDATE user_items SET id = id WHERE user_id = ?")
+		if err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		defer stmt.Close()
+		reset()
+		if _, err := stmt.Exec(2); err != nil {
+			t.Fatalf("%+v\n", err)
+		}
+		queries := reset()
+		if len(queries) != 1 || queries[0].Table != "user_items" || queries[0].ShardName == "" || queries[0].RowsAffected != 1 {
+			t.Fatalf("cannot log slow prepared statement %+v", queries)
+		}
+	})
+}
@@ -0,0 +1,85 @@
+// Package warning notifies behaviors that octillery silently degrades ( e.g. query for all shards ).
+//
+// Applications can receive them by octillery.SetWarningHandler and turn them into alerts or metrics.
+package warning
+
+import (
+	"fmt"
+	"sync"
+
+	"go.knocknote.io/octillery/debug"
+)
+
+// Code the kind of warning
+type Code string
+
+const (
+	// ScatterQuery query is executed on all shards because shard_key is not found in it
+	ScatterQuery Code = "scatter_query"
+
+	// QueryRowForAllShards QueryRow without shard_key returns nil row because it cannot choose a shard
+	QueryRowForAllShards Code = "query_row_for_all_shards"
+
+	// UnsupportedArgType argument of query is not embedded to query because the type is not supported
+	UnsupportedArgType Code = "unsupported_arg_type"
+
+	// PingIgnored Ping to sharded database is ignored.
+	//
+	// Deprecated: Ping pings all databases, so this is no longer notified.
+	PingIgnored Code = "ping_ignored"
+
+	// ShardLocalID LastInsertId of INSERT for sharded table is used although it is unique only in a shard
+	ShardLocalID Code = "shard_local_id"
+
+	// ReplicationPositionUnavailable replication position of database cannot be captured after commit
+	ReplicationPositionUnavailable Code = "replication_position_unavailable"
+)
+
+// Warning a structured warning notified to handler
+type Warning struct {
+	// kind of warning
+	Code Code
+	// human readable message
+	Message string
+	// target table name. empty if unknown
+	Table string
+	// query text caused warning. empty if warning is not related to query
+	Query string
+}
+
+func (w *Warning) String() string {
+	text := fmt.Sprintf("[%s] %s", w.Code, w.Message)
+	if w.Table != "" {
+		text += fmt.Sprintf(" table = %s", w.Table)
+	}
+	if w.Query != "" {
+		text += fmt.Sprintf(" query = %s", w.Query)
+	}
+	return text
+}
+
+// Handler receives warnings. It must be safe for concurrent use.
+type Handler func(*Warning)
+
+var (
+	handlerMu sync.RWMutex
+	handler   Handler
+)
+
+// SetHandler sets handler receives all warnings. nil removes current handler.
+func SetHandler(h Handler) {
+	handlerMu.Lock()
+	defer handlerMu.Unlock()
+	handler = h
+}
+
+// Warn notifies warning to handler, and prints it if debug mode.
+func Warn(w *Warning) {
+	debug.Printf("[WARN] %s", w)
+	handlerMu.RLock()
+	h := handler
+	handlerMu.RUnlock()
+	if h != nil {
+		h(w)
+	}
+}
@@ -0,0 +1,166 @@
+package octillery
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"go.knocknote.io/octillery/config"
+	"go.knocknote.io/octillery/connection"
+	osql "go.knocknote.io/octillery/database/sql"
+	"go.knocknote.io/octillery/debug"
+)
+
+// DefaultConfigWatchDelay default time for waiting successive changes of configuration file before reload
+const DefaultConfigWatchDelay = 100 * time.Millisecond
+
+// ReloadConfig loads configuration file again, and applies it to connections of dbs without restart of process.
+//
+// Connections of changed tables are swapped, and connections of removed tables are closed after queries in progress are drained.
+// Connections of all dbs are opened by loaded configuration before any of them are swapped,
+// so if loaded configuration cannot be applied to one of dbs, error is returned and connections of all dbs are kept.
+// Loaded configuration is set as global configuration only after connections of all dbs are reloaded.
+func ReloadConfig(configPath string, dbs ...*osql.DB) error {
+	content, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	cfg, err := config.Parse(content)
+	if err != nil {
+		return errors.Wrapf(err, "cannot load %s", configPath)
+	}
+	stagedReloads := make([]*connection.StagedReload, 0, len(dbs))
+	for _, db := range dbs {
+		staged, err := db.ConnectionManager().PrepareReload(cfg)
+		if err != nil {
+			for _, staged := range stagedReloads {
+				staged.Discard()
+			}
+			return errors.Wrapf(err, "cannot reload %s", configPath)
+		}
+		stagedReloads = append(stagedReloads, staged)
+	}
+	for _, staged := range stagedReloads {
+		result := staged.Apply()
+		debug.Printf("reloaded %s. changed tables = %v, removed tables = %v", configPath, result.Changed, result.Removed)
+	}
+	if err := connection.SetConfig(cfg); err != nil {
+		return errors.Wrapf(err, "cannot set %s", configPath)
+	}
+	return nil
+}
+
+// ConfigWatcher watches configuration file by fsnotify, and reloads connections of DB when it is changed.
+type ConfigWatcher struct {
+	configPath string
+	dbs        []*osql.DB
+	watcher    *fsnotify.Watcher
+	delay      time.Duration
+	done       chan struct{}
+	wg         sync.WaitGroup
+
+	mu       sync.Mutex
+	onReload func(error)
+}
+
+// WatchConfig starts watching configuration file, and calls ReloadConfig for dbs when it is changed.
+// Directory of configuration file is watched, so replacing file by rename ( e.g. editors or ConfigMap of Kubernetes ) is also detected.
+func WatchConfig(configPath string, dbs ...*osql.DB) (*ConfigWatcher, error) {
+	absPath, err := filepath.Abs(configPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := watcher.Add(filepath.Dir(absPath)); err != nil {
+		watcher.Close()
+		return nil, errors.Wrapf(err, "cannot watch %s", configPath)
+	}
+	w := &ConfigWatcher{
+		configPath: absPath,
+		dbs:        dbs,
+		watcher:    watcher,
+		delay:      DefaultConfigWatchDelay,
+		done:       make(chan struct{}),
+	}
+	w.wg.Add(1)
+	go w.run()
+	return w, nil
+}
+
+// OnReload sets function called after configuration is reloaded.
+// If reload is failed, err is not nil and current connections are kept.
+func (w *ConfigWatcher) OnReload(fn func(err error)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onReload = fn
+}
+
+// Close stops watching configuration file
+func (w *ConfigWatcher) Close() error {
+	select {
+	case <-w.done:
+		return nil
+	default:
+	}
+	close(w.done)
+	err := w.watcher.Close()
+	w.wg.Wait()
+	return errors.WithStack(err)
+}
+
+func (w *ConfigWatcher) isConfigEvent(event fsnotify.Event) bool {
+	if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+		return false
+	}
+	name, err := filepath.Abs(event.Name)
+	if err != nil {
+		return false
+	}
+	// ConfigMap of Kubernetes replaces symbolic link of data directory, so events in the same directory are also handled
+	return name == w.configPath || filepath.Base(name) == "..data"
+}
+
+func (w *ConfigWatcher) run() {
+	defer w.wg.Done()
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-w.done:
+			return
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if w.isConfigEvent(event) {
+				// successive events of a change are reloaded at once
+				timer = time.After(w.delay)
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			w.notify(errors.Wrapf(err, "cannot watch %s", w.configPath))
+		case <-timer:
+			timer = nil
+			w.notify(ReloadConfig(w.configPath, w.dbs...))
+		}
+	}
+}
+
+func (w *ConfigWatcher) notify(err error) {
+	if err != nil {
+		debug.Printf("%+v", err)
+	}
+	w.mu.Lock()
+	onReload := w.onReload
+	w.mu.Unlock()
+	if onReload != nil {
+		onReload(err)
+	}
+}